@@ -3,21 +3,32 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/blobstore"
 	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/editorconfig"
 	"github.com/epuerta/codex-go/internal/fileops"
 	"github.com/epuerta/codex-go/internal/functions"
 	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/epuerta/codex-go/internal/lsp"
+	"github.com/epuerta/codex-go/internal/memory"
+	"github.com/epuerta/codex-go/internal/policy"
 	"github.com/epuerta/codex-go/internal/sandbox"
+	"github.com/epuerta/codex-go/internal/snapshot"
 	"github.com/epuerta/codex-go/internal/ui"
 	"github.com/google/uuid"
 )
@@ -48,6 +59,25 @@ type sendFunctionResultMsg struct {
 	originalArgs string // Arguments JSON from the *original* call
 	output       string // Result content from execution
 	success      bool   // Result status from execution
+	exitCode     *int   // Exit code of a command that ran
+	duration     time.Duration
+	cancelled    bool   // The user cancelled the call; answered through the agent's CancelToolCall
+	note         string // Told to the model along with the result
+}
+
+// patchProgressMsg reports a hunk of a patch being applied in the background
+type patchProgressMsg struct {
+	progress fileops.PatchProgress
+}
+
+// patchAppliedMsg carries the outcome of a patch applied in the background
+type patchAppliedMsg struct {
+	call       agent.FunctionCall // patch_file call the patch came from
+	results    []*fileops.AgentPatchResult
+	err        error
+	normalized []string // .editorconfig normalizations, for the model
+	formatErrs []string // Auto-format failures, for the user
+	duration   time.Duration
 }
 
 // UserInputSubmitMsg signals that the user pressed Enter in the chat input
@@ -63,9 +93,16 @@ type App struct {
 	ChatModel        ui.ChatModel // ChatModel is now a sub-model
 	Config           *config.Config
 	FunctionRegistry *functions.Registry
+	Journal          *fileops.Journal     // Pre-turn snapshots of files modified this turn
+	Workspace        *functions.Workspace // Directory file and shell tools resolve paths against
+	Memory           *memory.Store        // Project memory (nil when disabled)
+	LanguageServers  *lsp.Manager         // Servers behind the code navigation tools (nil when unavailable)
+	Policy           *policy.Policy       // Approval rules from the global and project policy files
 	IsRunning        bool
 	Sandbox          sandbox.Sandbox
+	Executor         *functions.Executor // Queue limiting how many tool calls run at once
 	Logger           logging.Logger
+	Program          *tea.Program // Running program, which steps aside for commands that want input
 
 	// Rollout tracking
 	CurrentRollout *AppRollout
@@ -77,13 +114,44 @@ type App struct {
 
 	agentMsgChan      chan tea.Msg // Channel for agent messages
 	isFirstAgentChunk bool         // Track if we are processing the first chunk of a stream
-	isAgentProcessing bool         // Track if the agent is busy with a request/response cycle
+	isAgentProcessing atomic.Bool  // Track if the agent is busy with a request/response cycle (read by the signal handler)
 
 	// State for Approval UI
 	isAwaitingApproval  bool
+	deferredMsgs        []tea.Msg // Agent messages that arrived while awaiting approval, replayed once it ends
 	approvalModel       ui.ApprovalModel
 	pendingFunctionCall *agent.FunctionCall // Store the function call needing approval
 	pendingApprovalArgs string              // Store the specific args shown in the prompt
+	proposedCommand     string              // Command the model proposed, once the user has edited it
+
+	// File contents the last reply gave in code blocks instead of calling write_file
+	fileSuggestions        []fileops.FileSuggestion
+	applyingSuggestion     *fileops.FileSuggestion // Suggestion awaiting approval; its write is not a tool call
+	pendingQuestion        *agent.FunctionCall     // ask_user call the next input answers
+	pendingChoice          *pendingChoice          // ask_user_choice call the next input answers
+	pendingCommandInput    *pendingCommandInput    // Running command the next input is sent to
+	reviewingFlaggedOutput *flaggedOutputMsg       // Flagged tool output awaiting approval; not a tool call
+	interruptRequested     bool                    // /interrupt cancelled the turn; its end sends the queued messages
+
+	statsReport string // Usage report shown over the chat by /stats until a key is pressed
+
+	// State for end-of-turn review
+	isReviewing bool
+	reviewModel ui.ReviewModel
+
+	// Workspace snapshots; with auto_snapshot in full-auto mode one is taken before each turn
+	Snapshots    *snapshot.Manager
+	turnSnapshot string // Snapshot taken before the current turn, "" when none was
+
+	closeOnce sync.Once // Close runs once, whether from normal exit or a signal
+}
+
+// messageQueue is implemented by agents that hold messages submitted while a
+// turn is in progress until it ends
+type messageQueue interface {
+	QueueMessage(content string) error
+	QueuedMessages() []string
+	SendQueuedMessages(ctx context.Context, handler agent.ResponseHandler) (bool, error)
 }
 
 // AppRollout represents a saved session that can be loaded later
@@ -95,6 +163,16 @@ type AppRollout struct {
 	CreatedAt     time.Time       `json:"created_at"`
 	UpdatedAt     time.Time       `json:"updated_at"`
 	SessionID     string          `json:"session_id"`
+	Interrupted   bool            `json:"interrupted,omitempty"` // Session ended by a signal mid-turn
+	WorkingDir    string          `json:"working_dir,omitempty"` // Session working directory, "" for the workspace root
+	DiffStats     []TurnDiffStat  `json:"diff_stats,omitempty"`  // Files changed by each turn that changed any
+}
+
+// TurnDiffStat is the summary of the files changed by a turn, placed after
+// the first Messages messages of the conversation
+type TurnDiffStat struct {
+	Messages int `json:"messages"`
+	fileops.DiffStat
 }
 
 // NewApp creates a new application instance
@@ -118,22 +196,47 @@ func NewApp(config *config.Config, logger logging.Logger) (*App, error) {
 
 	// Set the session info with the current information
 	sessionID := uuid.New().String()[:16]
+	model := config.Model
+	if config.RoutesModels() {
+		model = config.ExploreModel + " / " + config.EditModel
+	}
 	chatModel.SetSessionInfo(
 		sessionID,
 		config.CWD,
-		config.Model,
+		model,
 		string(config.ApprovalMode),
 	)
+	chatModel.SetReadOnly(config.ReadOnly)
 
-	// Create function registry
-	registry := functions.NewRegistry()
+	// Approval rules are checked before the approval mode; a broken file must not be ignored
+	approvalPolicy, err := policy.Load(policy.GlobalPath(), policy.ProjectPath(config.ToolDir()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load approval policy: %w", err)
+	}
+
+	// Tools snapshot the files they modify in the journal
+	journal := fileops.NewJournal()
+	if config.BlobStore {
+		journal.SetBlobStore(blobstore.Open(blobstore.DirFor(config.ToolDir()), config.BlobCompression), a.SessionID)
+	}
+
+	var memoryStore *memory.Store
+	if !config.DisableMemory {
+		store, err := memory.OpenProject(config)
+		if err != nil {
+			logger.Log("Warning: Failed to open project memory: %v", err)
+		} else {
+			memoryStore = store
+		}
+	}
+
+	// Language servers start on first use
+	var languageServers *lsp.Manager
+	if !config.DisableLanguageServers && lsp.Available(config.LanguageServers) {
+		languageServers = lsp.NewManager(config.ToolDir(), config.LanguageServers)
+	}
 
-	// Register core functions
-	registry.Register("read_file", functions.ReadFile)
-	registry.Register("write_file", functions.WriteFile)
-	registry.Register("patch_file", functions.PatchFile)
-	registry.Register("execute_command", functions.ExecuteCommand)
-	registry.Register("list_directory", functions.ListDirectory)
+	registry := functions.NewToolRegistry(config, journal, memoryStore, languageServers, a.Embedder())
 
 	// Create sandbox
 	sb := sandbox.NewSandbox()
@@ -143,14 +246,24 @@ func NewApp(config *config.Config, logger logging.Logger) (*App, error) {
 		ChatModel:        chatModel,
 		Config:           config,
 		FunctionRegistry: registry,
+		Journal:          journal,
+		Workspace:        registry.Workspace(),
+		Memory:           memoryStore,
+		LanguageServers:  languageServers,
+		Policy:           approvalPolicy,
 		IsRunning:        false,
 		Sandbox:          sb,
+		Executor:         functions.NewExecutor(config.MaxConcurrentTools, config.ToolLimits()),
+		Snapshots:        newSnapshotManager(config),
 		Logger:           logger,
 		agentMsgChan:     make(chan tea.Msg),
 		// Initialize approval state
 		isAwaitingApproval: false,
 	}
 
+	// Under the strict tool_output_guard the user reviews flagged tool outputs
+	a.SetFlaggedOutputApprover(app.approveFlaggedOutput)
+
 	logger.Log("Repository context check: DisableProjectDoc=%t", config.DisableProjectDoc)
 	// Initialize repository context if not disabled
 	if !config.DisableProjectDoc {
@@ -189,43 +302,89 @@ func (app *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var skipChatModelUpdate bool = false
 
 	app.Logger.Log("App.Update received msg type: %T, isAwaitingApproval: %t", msg, app.isAwaitingApproval)
+	app.refreshRunningCalls()
+
+	// The /stats overlay takes the keys until one closes it
+	if key, ok := msg.(tea.KeyMsg); ok && app.statsReport != "" {
+		app.Logger.Log("Closing the stats overlay on key %s", key)
+		app.statsReport = ""
+		return app, nil
+	}
+
+	// *** Review UI Handling ***
+	if app.isReviewing {
+		switch reviewMsg := msg.(type) {
+		case ui.ReviewResultMsg:
+			if reviewMsg.Restore {
+				app.restoreTurnSnapshot()
+			} else {
+				app.finishTurnReview(reviewMsg.Keep)
+			}
+			return app, textinput.Blink
+		case tea.WindowSizeMsg:
+			app.width = reviewMsg.Width
+			app.height = reviewMsg.Height
+			app.reviewModel.SetSize(reviewMsg.Width, reviewMsg.Height)
+			return app, nil
+		case tea.KeyMsg, tea.MouseMsg:
+			var cmd tea.Cmd
+			app.reviewModel, cmd = app.reviewModel.Update(msg)
+			return app, cmd
+		}
+	}
+	// *** End Review UI Handling ***
 
 	// *** Approval UI Handling ***
 	if app.isAwaitingApproval {
 		switch approvalMsg := msg.(type) {
 		case ui.ApprovalResultMsg:
-			app.Logger.Log("Received ApprovalResultMsg: Approved=%t", approvalMsg.Approved)
+			app.Logger.Log("Received ApprovalResultMsg: Approved=%t, Edited=%t", approvalMsg.Approved, approvalMsg.Edited != "")
 			app.isAwaitingApproval = false // Exit approval mode
 
+			// Applying a suggested file is the user's own action, not an answer to a tool call
+			if app.applyingSuggestion != nil {
+				app.finishFileSuggestion(approvalMsg.Approved)
+				skipChatModelUpdate = true
+				break
+			}
+			// Neither is releasing a flagged output, which the agent waits on
+			if app.reviewingFlaggedOutput != nil {
+				app.finishFlaggedOutputApproval(approvalMsg.Approved)
+				skipChatModelUpdate = true
+				break
+			}
+
+			// An edited command is re-evaluated and may need approval of its own
+			if approvalMsg.Approved && approvalMsg.Edited != "" && functions.IsShellTool(app.pendingFunctionCall.Name) && app.reviewCommandEdit(approvalMsg.Edited) {
+				skipChatModelUpdate = true
+				break
+			}
+
 			app.ChatModel.SetThinkingStatus("Processing function result...")
 
 			var agentOutput string
 			var success bool
+			var exitCode *int
+			var duration time.Duration
 			functionName := app.pendingFunctionCall.Name
 			handlerExecuted := false // Flag to prevent fallthrough
+			resultDeferred := false  // The result is sent when background work finishes
 
 			if approvalMsg.Approved {
 				app.Logger.Log("Approval granted for %s. Executing...", functionName)
 
 				// *** Execute the approved function ***
-				if functionName == "execute_command" {
+				if functions.IsShellTool(functionName) {
 					handlerExecuted = true // Mark as handled
 					cmdStr := app.pendingApprovalArgs
 					app.Logger.Log("Executing approved command via sandbox: %s", cmdStr)
-					result, err := app.Sandbox.Execute(context.Background(), sandbox.SandboxOptions{Command: cmdStr, WorkingDir: app.Config.CWD, Timeout: 30 * time.Second})
-					uiResult := &ui.CommandResult{Command: cmdStr, Stdout: result.Stdout, Stderr: result.Stderr, ExitCode: result.ExitCode, Duration: result.Duration, Error: err}
-					app.ChatModel.AddCommandMessage(cmdStr, uiResult)
-					app.ChatModel.ForceUpdateViewport()
-					agentOutput = result.Stdout
-					success = err == nil && result.ExitCode == 0
-					if !success {
-						if err != nil {
-							agentOutput = fmt.Sprintf("Execution Error: %v", err)
-						} else {
-							agentOutput = fmt.Sprintf("Command Failed (code %d): %s", result.ExitCode, result.Stderr)
-						}
+					note := ""
+					if app.proposedCommand != "" && app.proposedCommand != cmdStr {
+						// Tell the model what actually ran
+						note = commandEditNote(app.proposedCommand, cmdStr)
 					}
-					app.Logger.Log("Executed command. Agent output: %s, Success: %t", agentOutput, success)
+					app.startToolRun(*app.pendingFunctionCall, cmdStr, note)
+					resultDeferred = true
 
 				} else if functionName == "patch_file" {
 					handlerExecuted = true // Mark as handled
@@ -234,6 +393,9 @@ func (app *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					app.ChatModel.SetThinkingStatus("Applying patch...")
 					app.Logger.Log("Calling fileops.ParseAgentPatch...")
 					operations, parseErr := fileops.ParseAgentPatch(patchContent)
+					if parseErr == nil {
+						parseErr = app.resolvePatchTargets(operations)
+					}
 					if parseErr != nil {
 						app.Logger.Log("ERROR: Failed to parse agent patch: %v", parseErr)
 						agentOutput = fmt.Sprintf("Error parsing patch: %v", parseErr)
@@ -249,62 +411,9 @@ func (app *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						app.ChatModel.ForceUpdateViewport()
 						app.Logger.Log("ForceUpdateViewport completed after adding parse error.")
 					} else {
-						app.Logger.Log("Parsed %d operations from patch. Calling fileops.ApplyAgentPatch...", len(operations))
-						applyResults, applyErr := fileops.ApplyAgentPatch(operations)
-						app.Logger.Log("ApplyAgentPatch finished. Results count: %d, Overall error: %v", len(applyResults), applyErr)
-
-						successCount, failureCount := 0, 0
-						app.Logger.Log("Adding patch results to UI...")
-						for i, res := range applyResults {
-							app.Logger.Log("Processing applyResult %d: Success=%t, Path=%s, Diff=%s, Error=%v", i+1, res.Success, res.Path, res.Diff, res.Error)
-							if res.Success {
-								successCount++
-								// --- Start: Auto-format successful patch ---
-								formatCmdStr := getFormatterCommand(res.Path)
-								if formatCmdStr != "" {
-									app.Logger.Log("Attempting to auto-format successfully patched file: %s with command: %s", res.Path, formatCmdStr)
-									formatCtx, formatCancel := context.WithTimeout(context.Background(), 15*time.Second)
-									formatResult, formatErr := app.Sandbox.Execute(formatCtx, sandbox.SandboxOptions{
-										Command:    formatCmdStr,
-										WorkingDir: app.Config.CWD,
-									})
-									formatCancel()
-									if formatErr != nil || formatResult.ExitCode != 0 {
-										formatErrMsg := fmt.Sprintf("Auto-formatting failed for %s.", res.Path)
-										if formatErr != nil {
-											formatErrMsg = fmt.Sprintf("%s Error: %v", formatErrMsg, formatErr)
-										} else {
-											formatErrMsg = fmt.Sprintf("%s Exit Code: %d, Stderr: %s", formatErrMsg, formatResult.ExitCode, formatResult.Stderr)
-										}
-										app.Logger.Log("ERROR: %s", formatErrMsg)
-										app.ChatModel.AddSystemMessage(formatErrMsg)
-									} else {
-										app.Logger.Log("Successfully auto-formatted %s.", res.Path)
-									}
-								} else {
-									app.Logger.Log("No formatter identified for file extension of %s, skipping auto-format.", res.Path)
-								}
-								// --- End: Auto-format successful patch ---
-							} else {
-								failureCount++
-							}
-							app.ChatModel.AddAgentPatchResultMessage(res)
-							app.ChatModel.ForceUpdateViewport()
-						}
-						app.Logger.Log("Finished adding %d patch result messages to ChatModel.", len(applyResults))
-						app.Logger.Log("Finished processing patch apply results.")
-
-						if applyErr != nil {
-							agentOutput = fmt.Sprintf("Patch application finished with errors. Succeeded: %d, Failed: %d. First error: %v", successCount, failureCount, applyErr)
-							success = false
-						} else if failureCount > 0 {
-							agentOutput = fmt.Sprintf("Patch application finished. Succeeded: %d, Failed: %d.", successCount, failureCount)
-							success = false
-						} else {
-							agentOutput = fmt.Sprintf("Patch application finished successfully. Operations applied: %d.", successCount)
-							success = true
-						}
-						app.Logger.Log("Patch application summary for agent: %s", agentOutput)
+						app.Logger.Log("Parsed %d operations from patch. Applying in the background...", len(operations))
+						app.applyPatchInBackground(*app.pendingFunctionCall, operations)
+						resultDeferred = true
 					}
 				}
 
@@ -313,17 +422,8 @@ func (app *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					fn := app.FunctionRegistry.Get(functionName)
 					if fn != nil {
 						app.Logger.Log("Executing approved registered function: %s", functionName)
-						app.ChatModel.SetThinkingStatus(fmt.Sprintf("Executing: %s", functionName))
-						result, err := fn(app.pendingFunctionCall.Arguments)
-						app.Logger.Log("Approved Function '%s' execution result: ResultLen=%d, Error=%v", functionName, len(result), err)
-						success = err == nil
-						agentOutput = result
-						if err != nil {
-							agentOutput = fmt.Sprintf("Error: %v", err)
-							app.ChatModel.AddSystemMessage(agentOutput)
-						}
-						app.ChatModel.AddFunctionResultMessage(agentOutput, !success)
-						app.ChatModel.ForceUpdateViewport()
+						app.startToolRun(*app.pendingFunctionCall, "", "")
+						resultDeferred = true
 					} else {
 						app.Logger.Log("ERROR: Approved function %s not found in registry!", functionName)
 						agentOutput = fmt.Sprintf("Internal error: approved function %s not found", functionName)
@@ -339,21 +439,26 @@ func (app *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 
 			// --- Send result back to agent ---
-			resultMsg := sendFunctionResultMsg{
-				ctx:          context.Background(),
-				functionName: app.pendingFunctionCall.Name,
-				callID:       app.pendingFunctionCall.ID,
-				originalArgs: app.pendingFunctionCall.Arguments,
-				output:       agentOutput,
-				success:      success,
+			if !resultDeferred {
+				resultMsg := sendFunctionResultMsg{
+					ctx:          context.Background(),
+					functionName: app.pendingFunctionCall.Name,
+					callID:       app.pendingFunctionCall.ID,
+					originalArgs: app.pendingFunctionCall.Arguments,
+					output:       agentOutput,
+					success:      success,
+					exitCode:     exitCode,
+					duration:     duration,
+				}
+				app.Logger.Log("App.Update (ApprovalResultMsg): Starting goroutine to send sendFunctionResultMsg for %s.", resultMsg.functionName)
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					app.agentMsgChan <- resultMsg
+				}()
 			}
-			app.Logger.Log("App.Update (ApprovalResultMsg): Starting goroutine to send sendFunctionResultMsg for %s.", resultMsg.functionName)
-			go func() {
-				time.Sleep(50 * time.Millisecond)
-				app.agentMsgChan <- resultMsg
-			}()
 			app.pendingFunctionCall = nil
 			app.pendingApprovalArgs = ""
+			app.proposedCommand = ""
 
 			skipChatModelUpdate = true
 
@@ -365,10 +470,34 @@ func (app *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			cmds = append(cmds, cmd)
 			skipChatModelUpdate = true
 
+		case toolRunMsg:
+			// A call running in the background finished; the other calls' results do not wait on the dialog
+			app.Logger.Log("Received toolRunMsg for call %s (%s) while awaiting approval", approvalMsg.call.ID, approvalMsg.call.Name)
+			app.finishToolRun(approvalMsg)
+			cmds = append(cmds, app.listenForAgentMessages())
+
+		case sendFunctionResultMsg:
+			app.Logger.Log("Received sendFunctionResultMsg for %s while awaiting approval", approvalMsg.functionName)
+			app.sendFunctionResultCmd(approvalMsg)
+			cmds = append(cmds, app.listenForAgentMessages())
+
+		case toolQueueMsg:
+			// The calls listed above the input were refreshed on the way in
+			cmds = append(cmds, app.listenForAgentMessages())
+
+		case agentResponseMsg, agentErrorMsg, agentStreamCompleteMsg, agentFollowUpCompleteMsg, patchProgressMsg, patchAppliedMsg, commandWantsInputMsg, commandInputDoneMsg, flaggedOutputMsg:
+			// Held until the dialog closes, then handled in the order they arrived
+			app.Logger.Log("Deferring msg %T while awaiting approval", msg)
+			app.deferredMsgs = append(app.deferredMsgs, msg)
+			cmds = append(cmds, app.listenForAgentMessages())
+
 		default:
 			app.Logger.Log("Ignoring msg %T while awaiting approval", msg)
 			skipChatModelUpdate = true
 		}
+		if !app.isAwaitingApproval && len(app.deferredMsgs) > 0 {
+			cmds = append(cmds, app.replayDeferredMsgs())
+		}
 		// Return early to avoid processing other cases while approval is active
 		return app, tea.Batch(cmds...)
 	}
@@ -386,15 +515,30 @@ func (app *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case tea.KeyMsg:
 		app.Logger.Log("Received KeyMsg: Type=%v, Rune=%q, Alt=%t", msg.Type, msg.Runes, msg.Alt)
+		if app.cancelToolCallKey(msg) {
+			skipChatModelUpdate = true
+			break
+		}
+		if msg.Type == tea.KeyEsc && app.pendingCommandInput != nil {
+			app.answerCommandInput("") // Stops the command rather than quitting
+			skipChatModelUpdate = true
+			break
+		}
 		if msg.Type == tea.KeyCtrlC || msg.Type == tea.KeyEsc || (msg.String() == "q" && app.ChatModel.InputIsEmpty()) {
 			app.Logger.Log("Quit key detected. Shutting down.")
 			app.Agent.Cancel() // Cancel any pending agent work
+			app.Executor.Cancel()
 			app.IsRunning = false
 			return app, tea.Quit
 		}
 
 	case ui.UserInputSubmitMsg:
-		if strings.HasPrefix(msg.Content, "/") {
+		if app.pendingCommandInput != nil {
+			// The input goes to the running command waiting for it
+			app.answerCommandInput(msg.Content)
+			skipChatModelUpdate = true
+			cmd = nil
+		} else if strings.HasPrefix(msg.Content, "/") {
 			command := strings.TrimSpace(msg.Content)
 			if command == "/clear" {
 				app.Logger.Log("User command: /clear")
@@ -403,13 +547,81 @@ func (app *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				app.ChatModel.AddSystemMessage("Chat history cleared.")
 				skipChatModelUpdate = true
 				cmd = nil
+			} else if command == "/memory" || strings.HasPrefix(command, "/memory ") {
+				app.Logger.Log("User command: %s", command)
+				app.ChatModel.AddSystemMessage(app.handleMemoryCommand(strings.Fields(command)[1:]))
+				skipChatModelUpdate = true
+				cmd = nil
+			} else if command == "/apply" || strings.HasPrefix(command, "/apply ") {
+				app.Logger.Log("User command: %s", command)
+				app.applyFileSuggestion(strings.Fields(command)[1:])
+				skipChatModelUpdate = true
+				cmd = nil
+			} else if command == "/set" || strings.HasPrefix(command, "/set ") {
+				app.Logger.Log("User command: %s", command)
+				app.ChatModel.AddSystemMessage(app.handleSetCommand(strings.Fields(command)[1:]))
+				skipChatModelUpdate = true
+				cmd = nil
+			} else if command == "/policy" || strings.HasPrefix(command, "/policy ") {
+				app.Logger.Log("User command: %s", command)
+				app.ChatModel.AddSystemMessage(app.handlePolicyCommand(strings.Fields(command)[1:]))
+				skipChatModelUpdate = true
+				cmd = nil
+			} else if command == "/redact" || strings.HasPrefix(command, "/redact ") || command == "/delete" || strings.HasPrefix(command, "/delete ") {
+				app.Logger.Log("User command: %s", command)
+				fields := strings.Fields(command)
+				app.ChatModel.AddSystemMessage(app.handleHistoryEditCommand(strings.TrimPrefix(fields[0], "/"), fields[1:]))
+				skipChatModelUpdate = true
+				cmd = nil
+			} else if command == "/pin" || strings.HasPrefix(command, "/pin ") || command == "/unpin" || strings.HasPrefix(command, "/unpin ") {
+				app.Logger.Log("User command: %s", command)
+				fields := strings.Fields(command)
+				app.ChatModel.AddSystemMessage(app.handlePinCommand(strings.TrimPrefix(fields[0], "/"), fields[1:]))
+				skipChatModelUpdate = true
+				cmd = nil
+			} else if command == "/stats" {
+				app.Logger.Log("User command: /stats")
+				app.statsReport = app.usageStats()
+				skipChatModelUpdate = true
+				cmd = nil
+			} else if command == "/inspect" || strings.HasPrefix(command, "/inspect ") {
+				app.Logger.Log("User command: %s", command)
+				app.ChatModel.AddSystemMessage(app.inspectReport(strings.Fields(command)[1:]))
+				skipChatModelUpdate = true
+				cmd = nil
+			} else if command == "/cancel" || strings.HasPrefix(command, "/cancel ") {
+				app.Logger.Log("User command: %s", command)
+				app.ChatModel.AddSystemMessage(app.handleCancelCommand(strings.Fields(command)[1:]))
+				skipChatModelUpdate = true
+				cmd = nil
+			} else if command == "/interrupt" || strings.HasPrefix(command, "/interrupt ") {
+				app.Logger.Log("User command: %s", command)
+				app.interruptTurn(strings.TrimSpace(strings.TrimPrefix(command, "/interrupt")))
+				skipChatModelUpdate = true
+				cmd = nil
 			} else if command == "/help" {
 				app.Logger.Log("User command: /help")
 				helpText := `Codex-Go Help:
   /clear : Clears the current conversation history.
+  /memory list : Lists remembered project facts.
+  /memory forget <key> : Removes a remembered fact.
+  /apply <n> : Writes file suggestion n from the last reply (approval rules apply).
+  /set : Shows the tool concurrency limits.
+  /set max_concurrent_tools <n> : Limits how many tool calls run at once (0 = unlimited).
+  /set tool_concurrency.<tool> <n> : Limits how many calls to one tool run at once.
+  /policy : Lists the approval policy rules in evaluation order.
+  /policy explain <command> : Shows which policy rule decides a shell command.
+  /redact [n] : Replaces message n's content (and its tool calls' data) with [redacted]; lists messages without n.
+  /delete [n] : Deletes message n from the history; lists messages without n.
+  /pin [n] : Pins message n so it is never pruned or summarized; lists messages (pinned marked *) without n.
+  /unpin [n] : Unpins message n.
+  /stats : Shows usage today and over the last 7 days.
+  /inspect [turn] : Shows what turn n sent to the API and the response; lists the turns without n.
+  /interrupt [message] : Cancels the current turn and sends the queued messages (and message) now.
+  /cancel [n] : Lists the running tool calls; with n, stops call n alone and tells the model it was cancelled.
   /help  : Shows this help message.
   Ctrl+C : Quits the application.
-  Enter  : Sends your message to the assistant.`
+  Enter  : Sends your message to the assistant, or queues it until the current turn ends.`
 				app.ChatModel.AddSystemMessage(helpText)
 				skipChatModelUpdate = true
 				cmd = nil
@@ -419,9 +631,35 @@ func (app *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				skipChatModelUpdate = true
 				cmd = nil
 			}
+		} else if app.pendingChoice != nil {
+			// The input answers the assistant's ask_user_choice call, or searches its file picker
+			app.answerChoice(msg.Content)
+			skipChatModelUpdate = true
+			cmd = nil
+		} else if call := app.pendingQuestion; call != nil {
+			// The input answers the assistant's ask_user call
+			app.Logger.Log("User answered question %s: %q", call.ID, msg.Content)
+			app.pendingQuestion = nil
+			app.ChatModel.ClearQuestion()
+			app.ChatModel.AddUserMessage(msg.Content)
+			app.ChatModel.StartThinking()
+			resultMsg := sendFunctionResultMsg{
+				ctx:          context.Background(),
+				functionName: call.Name,
+				callID:       call.ID,
+				originalArgs: call.Arguments,
+				output:       msg.Content,
+				success:      true,
+			}
+			go func() {
+				app.agentMsgChan <- resultMsg
+			}()
+			skipChatModelUpdate = true
+			cmd = nil
 		} else {
-			if app.isAgentProcessing {
-				app.Logger.Log("WARN: User submitted input while agent is processing. Ignoring.")
+			app.ChatModel.ClearQuestion()
+			if app.isAgentProcessing.Load() {
+				app.queueMessage(msg.Content)
 				skipChatModelUpdate = true
 				cmd = nil
 			} else {
@@ -429,7 +667,7 @@ func (app *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				app.ChatModel.AddUserMessage(msg.Content)
 				app.ChatModel.StartThinking()
 				app.isFirstAgentChunk = true
-				app.isAgentProcessing = true
+				app.isAgentProcessing.Store(true)
 				cmd = app.listenAgentStreamCmd(msg.Content)
 				skipChatModelUpdate = true
 			}
@@ -448,32 +686,62 @@ func (app *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case agentErrorMsg:
 		app.Logger.Log("ERROR: Received agentErrorMsg: %v", msg.err)
-		app.ChatModel.AddSystemMessage(fmt.Sprintf("Error: %v", msg.err))
-		app.ChatModel.StopThinking()
-		app.isFirstAgentChunk = false
-		app.isAgentProcessing = false
+		if app.interruptRequested {
+			app.ChatModel.AddSystemMessage("Turn interrupted.")
+		} else {
+			app.ChatModel.AddSystemMessage(fmt.Sprintf("Error: %v", msg.err))
+		}
+		if app.endTurn() {
+			app.sendQueuedMessages()
+		}
 		cmds = append(cmds, app.listenForAgentMessages(), textinput.Blink)
 		agentMessageHandled = true
 		skipChatModelUpdate = true
 
 	case agentStreamCompleteMsg:
 		app.Logger.Log("Received agentStreamCompleteMsg (no tool calls)")
-		app.ChatModel.StopThinking()
-		app.isFirstAgentChunk = false
-		app.isAgentProcessing = false
+		// Only the final reply of a turn is offered, not text around its tool calls
+		if app.endTurn() {
+			app.offerFileSuggestions()
+			app.sendQueuedMessages()
+		}
 		cmds = append(cmds, app.listenForAgentMessages(), textinput.Blink)
 		agentMessageHandled = true
 		skipChatModelUpdate = true
 
 	case agentFollowUpCompleteMsg:
 		app.Logger.Log("Received agentFollowUpCompleteMsg")
-		app.ChatModel.StopThinking()
-		app.isFirstAgentChunk = false
-		app.isAgentProcessing = false
+		// Only the final reply of a turn is offered, not text around its tool calls
+		if app.endTurn() {
+			app.offerFileSuggestions()
+			app.sendQueuedMessages()
+		}
 		cmds = append(cmds, app.listenForAgentMessages(), textinput.Blink)
 		agentMessageHandled = true
 		skipChatModelUpdate = true
 
+	case patchProgressMsg:
+		app.Logger.Log("Patch progress: %s", msg.progress)
+		app.ChatModel.AddPatchProgressMessage(msg.progress)
+		app.ChatModel.SetThinkingStatus(fmt.Sprintf("Applying patch: hunk %d/%d...", msg.progress.Hunk, msg.progress.Hunks))
+		cmds = append(cmds, app.listenForAgentMessages())
+		agentMessageHandled = true
+		skipChatModelUpdate = true
+
+	case patchAppliedMsg:
+		app.Logger.Log("Received patchAppliedMsg for call %s: %d result(s), error: %v", msg.call.ID, len(msg.results), msg.err)
+		app.finishPatch(msg)
+		cmds = append(cmds, app.listenForAgentMessages())
+		agentMessageHandled = true
+		skipChatModelUpdate = true
+
+	case toolRunMsg:
+		app.Logger.Log("Received toolRunMsg for call %s (%s), error: %v", msg.call.ID, msg.call.Name, msg.err)
+		app.finishToolRun(msg)
+		cmds = append(cmds, app.listenForAgentMessages())
+		agentMessageHandled = true
+		skipChatModelUpdate = true
+
 	case sendFunctionResultMsg:
 		app.Logger.Log("Received sendFunctionResultMsg for %s", msg.functionName)
 		app.sendFunctionResultCmd(msg)
@@ -481,6 +749,33 @@ func (app *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		agentMessageHandled = true
 		skipChatModelUpdate = true
 
+	case commandWantsInputMsg:
+		app.Logger.Log("Received command_wants_input for '%s'", msg.req.Command)
+		app.startCommandInput(msg)
+		cmds = append(cmds, app.listenForAgentMessages())
+		agentMessageHandled = true
+		skipChatModelUpdate = true
+
+	case commandInputDoneMsg:
+		app.endCommandInput(msg.reply)
+		cmds = append(cmds, app.listenForAgentMessages())
+		agentMessageHandled = true
+		skipChatModelUpdate = true
+
+	case flaggedOutputMsg:
+		app.Logger.Log("Received flagged output of call %s for review", msg.result.CallID)
+		app.askFlaggedOutputApproval(msg)
+		cmds = append(cmds, app.listenForAgentMessages())
+		agentMessageHandled = true
+		skipChatModelUpdate = true
+
+	case toolQueueMsg:
+		app.Logger.Log("Tool call %s queue status: position %d, %d queued, %d running", msg.callID, msg.status.Position, msg.status.Queued, msg.status.Running)
+		app.showQueueStatus(msg)
+		cmds = append(cmds, app.listenForAgentMessages())
+		agentMessageHandled = true
+		skipChatModelUpdate = true
+
 	}
 
 	if !skipChatModelUpdate {
@@ -516,6 +811,12 @@ func (app *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 // View renders the application UI
 func (app *App) View() string {
+	if app.isReviewing {
+		return app.reviewModel.View()
+	}
+	if app.statsReport != "" {
+		return ui.StatsOverlay(app.statsReport, app.width, app.height)
+	}
 	if app.isAwaitingApproval {
 		// Ensure the approval model has the correct size based on current terminal dimensions
 		app.approvalModel.SetSize(app.width, app.height)
@@ -527,17 +828,40 @@ func (app *App) View() string {
 	}
 }
 
+// replayDeferredMsgs returns a command handling the agent messages deferred
+// while awaiting approval, in the order they arrived
+func (app *App) replayDeferredMsgs() tea.Cmd {
+	deferred := app.deferredMsgs
+	app.deferredMsgs = nil
+	replay := make([]tea.Cmd, len(deferred))
+	for i, msg := range deferred {
+		replay[i] = func() tea.Msg { return msg }
+	}
+	return tea.Sequence(replay...)
+}
+
 // listenAgentStreamCmd starts the agent stream goroutine which sends messages to app.agentMsgChan
 func (app *App) listenAgentStreamCmd(content string) tea.Cmd {
 	app.Logger.Log("listenAgentStreamCmd: Starting agent stream goroutine for content: %q", content)
+	message := agent.Message{Role: "user", Content: content}
+	app.streamAgent(func(ctx context.Context, handler agent.ResponseHandler) (bool, error) {
+		app.snapshotTurn(content, handler)
+		return app.Agent.SendMessage(ctx, []agent.Message{message}, handler)
+	})
+
+	app.Logger.Log("listenAgentStreamCmd: Returning nil command.")
+	return nil
+}
+
+// streamAgent runs send, which starts a turn of the agent, in a goroutine that
+// forwards the streamed items and the outcome to app.agentMsgChan
+func (app *App) streamAgent(send func(ctx context.Context, handler agent.ResponseHandler) (bool, error)) {
 	go func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 		defer cancel()
 
-		message := agent.Message{Role: "user", Content: content}
-
 		app.Logger.Log("listenAgentStreamCmd: Goroutine started. Calling Agent.SendMessage...")
-		streamEndedWithTools, err := app.Agent.SendMessage(ctx, []agent.Message{message}, func(itemJSON string) {
+		streamEndedWithTools, err := send(ctx, func(itemJSON string) {
 			app.Logger.Log("listenAgentStreamCmd Handler: Received JSON string: %q", itemJSON)
 
 			var item agent.ResponseItem
@@ -548,8 +872,13 @@ func (app *App) listenAgentStreamCmd(content string) tea.Cmd {
 				return
 			}
 
+			// With choices > 1 the chat follows choice 0; the agent keeps the other candidates
+			if item.Choice > 0 {
+				return
+			}
+
 			switch item.Type {
-			case "message", "function_call":
+			case "message", "function_call", "warning", "error", "reasoning", "empty_response", "refusal", "user_input_required", "tool_output_flagged", "queued_messages_sent", "function_call_progress", "tool_aborted":
 				fcCopy := item.FunctionCall
 				if item.FunctionCall != nil {
 					copiedFC := *item.FunctionCall
@@ -559,7 +888,11 @@ func (app *App) listenAgentStreamCmd(content string) tea.Cmd {
 					Type:             item.Type,
 					Message:          item.Message,
 					FunctionCall:     fcCopy,
+					Error:            item.Error,
 					ThinkingDuration: item.ThinkingDuration,
+					FinishReason:     item.FinishReason,
+					Progress:         item.Progress,
+					Refusal:          item.Refusal,
 				}
 				app.Logger.Log("listenAgentStreamCmd Handler: Sending agentResponseMsg to channel (Type: %s).", item.Type)
 				app.agentMsgChan <- agentResponseMsg{item: itemToSend}
@@ -582,9 +915,6 @@ func (app *App) listenAgentStreamCmd(content string) tea.Cmd {
 			app.Logger.Log("listenAgentStreamCmd: Goroutine finished normally, ended with tool calls. NOT sending agentStreamCompleteMsg.")
 		}
 	}()
-
-	app.Logger.Log("listenAgentStreamCmd: Returning nil command.")
-	return nil
 }
 
 // handleAgentResponseItem processes a single response item from the agent
@@ -620,16 +950,54 @@ func (app *App) handleAgentResponseItem(item agent.ResponseItem) {
 
 	case "function_call":
 		if item.FunctionCall != nil {
+			// ask_user is answered by the user's next input, announced by the user_input_required item
+			if item.FunctionCall.Name == agent.AskUserToolName {
+				app.Logger.Log("Assistant asked a question (ID: %s); waiting for the user's answer.", item.FunctionCall.ID)
+				call := *item.FunctionCall
+				app.pendingQuestion = &call
+				app.ChatModel.StopThinking()
+				return
+			}
+			if item.FunctionCall.Name == agent.AskUserChoiceToolName {
+				app.startChoice(*item.FunctionCall)
+				app.ChatModel.StopThinking()
+				return
+			}
+
 			app.Logger.Log("Handling 'function_call' item. Name: %s, ID: %s, Full Args JSON: %s", item.FunctionCall.Name, item.FunctionCall.ID, item.FunctionCall.Arguments)
 			app.ChatModel.SetThinkingStatus(fmt.Sprintf("Evaluating %s...", item.FunctionCall.Name))
 			app.ChatModel.AddFunctionCallMessage(item.FunctionCall.Name, item.FunctionCall.Arguments)
 			app.ChatModel.ForceUpdateViewport()
 
+			// --- Enforce Read-Only Mode ---
+			if app.Config.ReadOnly && agent.IsMutatingTool(item.FunctionCall.Name) {
+				app.Logger.Log("Read-only mode: refusing %s.", item.FunctionCall.Name)
+				app.refuseCall(item.FunctionCall, fmt.Sprintf("Policy error: '%s' is not available because this session is read-only.", item.FunctionCall.Name))
+				return
+			}
+
+			// --- Enforce Disabled Tools ---
+			if app.Config.ToolDisabled(item.FunctionCall.Name) {
+				app.Logger.Log("Tools config: refusing disabled %s.", item.FunctionCall.Name)
+				app.refuseCall(item.FunctionCall, fmt.Sprintf("Policy error: '%s' is disabled by the tools configuration.", item.FunctionCall.Name))
+				return
+			}
+
+			// --- Enforce Approval Policy Denials ---
+			policyMatch, policyMatched := app.policyDecision(item.FunctionCall)
+			if policyMatched && policyMatch.Decision == policy.Deny {
+				app.refuseCall(item.FunctionCall, fmt.Sprintf("Policy error: '%s' was denied: %s", item.FunctionCall.Name, policyMatch.Explain()))
+				return
+			}
+
 			// --- Decide if Approval Needed ---
-			needsApproval := app.needsApprovalForFunction(item.FunctionCall.Name)
+			needsApproval := app.requiresApproval(item.FunctionCall)
+			if needsApproval && policyMatched && policyMatch.Decision == policy.Prompt {
+				app.ChatModel.AddSystemMessage("Approval required by policy: " + policyMatch.Explain())
+			}
 			var argsForApproval string
 			if needsApproval {
-				if item.FunctionCall.Name == "execute_command" || item.FunctionCall.Name == "patch_file" || item.FunctionCall.Name == "write_file" {
+				if functions.IsShellTool(item.FunctionCall.Name) || item.FunctionCall.Name == "patch_file" || item.FunctionCall.Name == "write_file" || item.FunctionCall.Name == "append_file" {
 					var argsMap map[string]interface{}
 					if err := json.Unmarshal([]byte(item.FunctionCall.Arguments), &argsMap); err == nil {
 						if cmd, ok := argsMap["command"].(string); ok {
@@ -638,7 +1006,7 @@ func (app *App) handleAgentResponseItem(item agent.ResponseItem) {
 							argsForApproval = patch
 						} else if patch, ok := argsMap["patch_content"].(string); ok { // Handle alternative key
 							argsForApproval = patch
-						} else if content, ok := argsMap["content"].(string); ok { // For write_file
+						} else if content, ok := argsMap["content"].(string); ok { // For write_file and append_file
 							argsForApproval = content
 						} else {
 							argsForApproval = item.FunctionCall.Arguments
@@ -660,7 +1028,7 @@ func (app *App) handleAgentResponseItem(item agent.ResponseItem) {
 				// --- Add Summary Message to Chat (if patch_file) ---
 				if item.FunctionCall.Name == "patch_file" {
 					// Extract target files from the patch content for the summary
-					targetFiles := extractTargetFilesFromPatch(argsForApproval)
+					targetFiles := functions.PatchTargets(argsForApproval)
 					summary := ""
 					if len(targetFiles) > 0 {
 						summary = fmt.Sprintf("Assistant proposes patching file(s): %s. Approval required.", strings.Join(targetFiles, ", "))
@@ -684,8 +1052,10 @@ func (app *App) handleAgentResponseItem(item agent.ResponseItem) {
 			app.ChatModel.SetThinkingStatus(fmt.Sprintf("Executing: %s...", item.FunctionCall.Name))
 			var agentOutput string
 			var success bool
+			var exitCode *int
+			var duration time.Duration
 
-			if item.FunctionCall.Name == "execute_command" {
+			if functions.IsShellTool(item.FunctionCall.Name) {
 				var args map[string]interface{}
 				cmdStr := ""
 				if err := json.Unmarshal([]byte(item.FunctionCall.Arguments), &args); err != nil {
@@ -700,22 +1070,8 @@ func (app *App) handleAgentResponseItem(item agent.ResponseItem) {
 						success = false
 						app.ChatModel.AddSystemMessage(agentOutput)
 					} else {
-						result, err := app.Sandbox.Execute(context.Background(), sandbox.SandboxOptions{
-							Command:    cmdStr,
-							WorkingDir: app.Config.CWD,
-							Timeout:    30 * time.Second,
-						})
-						uiResult := &ui.CommandResult{Command: cmdStr, Stdout: result.Stdout, Stderr: result.Stderr, ExitCode: result.ExitCode, Duration: result.Duration, Error: err}
-						app.ChatModel.AddCommandMessage(cmdStr, uiResult)
-						agentOutput = result.Stdout
-						success = err == nil && result.ExitCode == 0
-						if !success { /* Set error output */
-							if err != nil {
-								agentOutput = fmt.Sprintf("Execution Error: %v", err)
-							} else {
-								agentOutput = fmt.Sprintf("Command Failed (code %d): %s", result.ExitCode, result.Stderr)
-							}
-						}
+						app.startToolRun(*item.FunctionCall, cmdStr, "")
+						return // The function result is sent once the command finishes
 					}
 				}
 			} else if item.FunctionCall.Name == "patch_file" {
@@ -737,7 +1093,7 @@ func (app *App) handleAgentResponseItem(item agent.ResponseItem) {
 						app.ChatModel.AddSystemMessage(agentOutput)
 					} else {
 						// --- Approval Check ---
-						if app.needsApprovalForFunction(item.FunctionCall.Name) {
+						if app.requiresApproval(item.FunctionCall) {
 							app.askForApproval(item.FunctionCall.Name, patchContent, item.FunctionCall)
 							// If approval is needed, we stop processing here and wait for ApprovalResultMsg
 							app.Logger.Log("Approval required for patch_file. Skipping direct execution.")
@@ -746,6 +1102,9 @@ func (app *App) handleAgentResponseItem(item agent.ResponseItem) {
 						// --- Direct Execution (if no approval needed) ---
 						app.Logger.Log("Calling fileops.ParseAgentPatch directly...")
 						operations, parseErr := fileops.ParseAgentPatch(patchContent)
+						if parseErr == nil {
+							parseErr = app.resolvePatchTargets(operations)
+						}
 						if parseErr != nil {
 							agentOutput = fmt.Sprintf("Error parsing patch: %v", parseErr)
 							success = false
@@ -756,54 +1115,8 @@ func (app *App) handleAgentResponseItem(item agent.ResponseItem) {
 								Diff:    "Patch parsing failed",
 							})
 						} else {
-							app.Logger.Log("Calling fileops.ApplyAgentPatch directly...")
-							applyResults, applyErr := fileops.ApplyAgentPatch(operations)
-							successCount, failureCount := 0, 0
-							for _, res := range applyResults {
-								if res.Success {
-									successCount++
-									// --- Start: Auto-format successful patch ---
-									formatCmdStr := getFormatterCommand(res.Path)
-									if formatCmdStr != "" {
-										app.Logger.Log("[Direct Execute] Attempting to auto-format successfully patched file: %s with command: %s", res.Path, formatCmdStr)
-										formatCtx, formatCancel := context.WithTimeout(context.Background(), 15*time.Second)
-										formatResult, formatErr := app.Sandbox.Execute(formatCtx, sandbox.SandboxOptions{
-											Command:    formatCmdStr,
-											WorkingDir: app.Config.CWD,
-										})
-										formatCancel()
-										if formatErr != nil || formatResult.ExitCode != 0 {
-											formatErrMsg := fmt.Sprintf("[Direct Execute] Auto-formatting failed for %s.", res.Path)
-											if formatErr != nil {
-												formatErrMsg = fmt.Sprintf("%s Error: %v", formatErrMsg, formatErr)
-											} else {
-												formatErrMsg = fmt.Sprintf("%s Exit Code: %d, Stderr: %s", formatErrMsg, formatResult.ExitCode, formatResult.Stderr)
-											}
-											app.Logger.Log("ERROR: %s", formatErrMsg)
-											app.ChatModel.AddSystemMessage(formatErrMsg)
-										} else {
-											app.Logger.Log("[Direct Execute] Successfully auto-formatted %s.", res.Path)
-										}
-									} else {
-										app.Logger.Log("[Direct Execute] No formatter identified for file extension of %s, skipping auto-format.", res.Path)
-									}
-									// --- End: Auto-format successful patch ---
-								} else {
-									failureCount++
-								}
-								app.ChatModel.AddAgentPatchResultMessage(res)
-								app.ChatModel.ForceUpdateViewport()
-							}
-							if applyErr != nil {
-								agentOutput = fmt.Sprintf("Patch application finished with errors. Succeeded: %d, Failed: %d. First error: %v", successCount, failureCount, applyErr)
-								success = false
-							} else if failureCount > 0 {
-								agentOutput = fmt.Sprintf("Patch application finished. Succeeded: %d, Failed: %d.", successCount, failureCount)
-								success = false
-							} else {
-								agentOutput = fmt.Sprintf("Patch application finished successfully. Operations applied: %d.", successCount)
-								success = true
-							}
+							app.applyPatchInBackground(*item.FunctionCall, operations)
+							return // The function result is sent once the patch is applied
 						}
 					}
 				}
@@ -814,14 +1127,8 @@ func (app *App) handleAgentResponseItem(item agent.ResponseItem) {
 					success = false
 					app.ChatModel.AddSystemMessage(agentOutput)
 				} else {
-					result, err := fn(item.FunctionCall.Arguments)
-					success = err == nil
-					agentOutput = result
-					if err != nil { /* Set agentOutput, add system message */
-						agentOutput = fmt.Sprintf("Error: %v", err)
-						app.ChatModel.AddSystemMessage(agentOutput)
-					}
-					app.ChatModel.AddFunctionResultMessage(agentOutput, !success)
+					app.startToolRun(*item.FunctionCall, "", "")
+					return // The function result is sent once the tool finishes
 				}
 			}
 
@@ -833,6 +1140,8 @@ func (app *App) handleAgentResponseItem(item agent.ResponseItem) {
 				originalArgs: item.FunctionCall.Arguments,
 				output:       agentOutput,
 				success:      success,
+				exitCode:     exitCode,
+				duration:     duration,
 			}
 
 			app.Logger.Log("App.handleAgentResponseItem (Direct Execute): Starting goroutine to send sendFunctionResultMsg for %s.", resultMsg.functionName)
@@ -846,6 +1155,118 @@ func (app *App) handleAgentResponseItem(item agent.ResponseItem) {
 			app.Logger.Log("WARN: Handling 'function_call' item, but item.FunctionCall is nil.")
 		}
 
+	case "reasoning":
+		// Inline reasoning split out of the response; only its latest line is shown, as status
+		if item.Message != nil {
+			app.Logger.Log("Agent reasoning: %d chars", len(item.Message.Content))
+			lines := strings.Split(strings.TrimSpace(item.Message.Content), "\n")
+			status := []rune(strings.TrimSpace(lines[len(lines)-1]))
+			if len(status) > 60 {
+				status = append([]rune("..."), status[len(status)-57:]...)
+			}
+			app.ChatModel.SetThinkingStatus("Reasoning: " + string(status))
+		}
+
+	case "function_call_progress":
+		// A tool call whose arguments are still streaming, e.g. a long write_file
+		if item.FunctionCall != nil && item.Progress != nil {
+			target := item.FunctionCall.Name
+			if item.Progress.Path != "" {
+				target = item.Progress.Path
+			}
+			app.ChatModel.SetThinkingStatus(fmt.Sprintf("%s %s... %s", callVerb(item.FunctionCall.Name), target, formatArgumentSize(item.Progress.Bytes)))
+		}
+
+	case "warning":
+		if item.Message != nil {
+			app.Logger.Log("Agent warning: %s", item.Message.Content)
+			app.ChatModel.AddSystemMessage(item.Message.Content)
+			app.ChatModel.ForceUpdateViewport()
+		}
+
+	case "tool_output_flagged":
+		if item.Message != nil {
+			app.Logger.Log("Tool output flagged: %s", item.Message.Content)
+			app.ChatModel.AddSystemMessage("Warning: " + item.Message.Content + " The assistant was told to treat it as data.")
+			app.ChatModel.ForceUpdateViewport()
+		}
+
+	case "user_input_required":
+		// Quote the question above the input, which takes the answer
+		if choice := app.pendingChoice; choice != nil && item.FunctionCall != nil && item.FunctionCall.ID == choice.call.ID {
+			app.ChatModel.AskQuestion(choice.prompt())
+			app.ChatModel.ForceUpdateViewport()
+		} else if item.Message != nil {
+			app.Logger.Log("Assistant is waiting for input: %q", item.Message.Content)
+			app.ChatModel.AskQuestion(item.Message.Content)
+			app.ChatModel.ForceUpdateViewport()
+		}
+
+	case "queued_messages_sent":
+		// The queued messages went out together as the turn's user message
+		if item.Message != nil {
+			app.Logger.Log("Queued messages sent (%d chars).", len(item.Message.Content))
+			app.ChatModel.SetQueued(nil)
+			app.ChatModel.AddUserMessage(item.Message.Content)
+			app.ChatModel.ForceUpdateViewport()
+		}
+
+	case "empty_response":
+		// The model sent nothing; say so rather than leave the view waiting
+		app.Logger.Log("Agent returned an empty response (finish reason %q).", item.FinishReason)
+		if item.Message != nil {
+			app.ChatModel.AddSystemMessage(item.Message.Content + " Send your message again or rephrase it.")
+		}
+		app.ChatModel.ForceUpdateViewport()
+
+	case "refusal":
+		// The model declined, or a content filter withheld the response; shown apart from replies
+		if item.Message != nil && item.Refusal != nil {
+			app.Logger.Log("Response refused (category %s).", item.Refusal.Category)
+			app.ChatModel.AddRefusalMessage(item.Message.Content)
+			app.ChatModel.ForceUpdateViewport()
+		}
+
+	case "tool_aborted":
+		// A call of the cancelled turn was answered as aborted; stop waiting on it
+		if call := item.FunctionCall; call != nil {
+			app.Logger.Log("Tool call %s (%s) aborted: %s", call.ID, call.Name, item.Error)
+			if app.pendingQuestion != nil && app.pendingQuestion.ID == call.ID {
+				app.pendingQuestion = nil
+				app.ChatModel.ClearQuestion()
+			}
+			if app.pendingChoice != nil && app.pendingChoice.call.ID == call.ID {
+				app.pendingChoice = nil
+				app.ChatModel.ClearQuestion()
+			}
+			name := call.Name
+			if name == "" {
+				name = call.ID
+			}
+			app.ChatModel.AddSystemMessage(fmt.Sprintf("Tool call '%s' was aborted: %s.", name, item.Error))
+			app.ChatModel.ForceUpdateViewport()
+		}
+
+	case "turn_diffstat":
+		// Emitted by the app once the turn ended; shown under its reply and kept with the rollout
+		if item.DiffStat != nil {
+			app.ChatModel.AddDiffStatMessage(*item.DiffStat)
+
+			messages := 0
+			if history := app.Agent.GetHistory(); history != nil {
+				messages = len(history.GetMessages())
+			}
+			if app.CurrentRollout == nil {
+				app.CurrentRollout = &AppRollout{CreatedAt: time.Now(), SessionID: uuid.New().String()}
+			}
+			app.CurrentRollout.DiffStats = append(app.CurrentRollout.DiffStats, TurnDiffStat{Messages: messages, DiffStat: *item.DiffStat})
+		}
+
+	case "error":
+		app.Logger.Log("Agent error item: %s", item.Error)
+		app.ChatModel.AddSystemMessage("Error: " + item.Error)
+		app.ChatModel.ForceUpdateViewport()
+
 	default:
 		app.Logger.Log("WARN: App.handleAgentResponseItem received unhandled item type: %s.", item.Type)
 	}
@@ -856,14 +1277,26 @@ func (app *App) sendFunctionResultCmd(msg sendFunctionResultMsg) {
 	app.Logger.Log("sendFunctionResultCmd: Preparing to send result for %s (callID: %s), success=%t", msg.functionName, msg.callID, msg.success)
 	if app.Agent != nil {
 		go func() {
-			app.Logger.Log("sendFunctionResultCmd Goroutine: Calling Agent.SendFunctionResult for %s...", msg.functionName)
-			err := app.Agent.SendFunctionResult(msg.ctx, msg.callID, msg.functionName, msg.output, msg.success)
-			app.Logger.Log("sendFunctionResultCmd Goroutine: Agent.SendFunctionResult returned error: %v", err)
+			var err error
+			if canceller, ok := app.Agent.(toolCallCanceller); ok && msg.cancelled {
+				app.Logger.Log("sendFunctionResultCmd Goroutine: Calling Agent.CancelToolCall for %s...", msg.callID)
+				err = canceller.CancelToolCall(msg.ctx, msg.callID)
+			} else {
+				result := agent.NewToolResult(msg.callID, msg.functionName, msg.output, msg.success)
+				result.ExitCode = msg.exitCode
+				result.DurationMs = msg.duration.Milliseconds()
+				if msg.note != "" {
+					result.Metadata = map[string]string{"note": msg.note}
+				}
+				app.Logger.Log("sendFunctionResultCmd Goroutine: Calling Agent.SendToolResult for %s...", msg.functionName)
+				err = app.Agent.SendToolResult(msg.ctx, result)
+			}
+			app.Logger.Log("sendFunctionResultCmd Goroutine: Agent.SendToolResult returned error: %v", err)
 			if err != nil {
-				app.Logger.Log("ERROR: sendFunctionResultCmd Goroutine: Sending agentErrorMsg due to SendFunctionResult failure: %v", err)
+				app.Logger.Log("ERROR: sendFunctionResultCmd Goroutine: Sending agentErrorMsg due to SendToolResult failure: %v", err)
 				app.agentMsgChan <- agentErrorMsg{err: fmt.Errorf("failed to send function result for %s: %w", msg.functionName, err)}
 			} else {
-				app.Logger.Log("sendFunctionResultCmd Goroutine: Agent.SendFunctionResult success. Handler will send next messages.")
+				app.Logger.Log("sendFunctionResultCmd Goroutine: Agent.SendToolResult success. Handler will send next messages.")
 			}
 		}()
 
@@ -882,29 +1315,650 @@ func (app *App) sendFunctionResultCmd(msg sendFunctionResultMsg) {
 	}
 }
 
+// endTurn finalizes the turn once the agent signals its real end, the model
+// having stopped requesting tools or the turn having failed: per-interaction
+// state is released and the turn's changes are summarized and offered for
+// review. It reports whether the turn was still running; a signal arriving
+// after the turn ended changes nothing.
+func (app *App) endTurn() bool {
+	if !app.isAgentProcessing.CompareAndSwap(true, false) {
+		app.Logger.Log("endTurn: No turn in progress; ignoring the end signal.")
+		return false
+	}
+	app.cleanupInteraction()
+	app.startTurnReview()
+	app.ChatModel.StopThinking()
+	app.isFirstAgentChunk = false
+	return true
+}
+
+// cleanupInteraction releases per-interaction state once a turn has ended
+func (app *App) cleanupInteraction() {
+	if app.FunctionRegistry != nil {
+		app.FunctionRegistry.EndTurn() // Discards abandoned chunked writes
+	}
+}
+
+// queueMessage holds input submitted while the agent is busy until the turn
+// ends, or drops it when the agent cannot queue messages
+func (app *App) queueMessage(content string) {
+	queue, ok := app.Agent.(messageQueue)
+	if !ok {
+		app.Logger.Log("WARN: User submitted input while agent is processing. Ignoring.")
+		return
+	}
+	if err := queue.QueueMessage(content); err != nil {
+		app.Logger.Log("Failed to queue message: %v", err)
+		app.ChatModel.AddSystemMessage(fmt.Sprintf("Could not queue message: %v", err))
+		return
+	}
+	app.Logger.Log("User submitted input while agent is processing. Queued: %q", content)
+	app.ChatModel.SetQueued(queue.QueuedMessages())
+}
+
+// sendQueuedMessages starts a turn with the queued messages, if any, once
+// the previous turn has ended; see endTurn
+func (app *App) sendQueuedMessages() {
+	app.interruptRequested = false
+	queue, ok := app.Agent.(messageQueue)
+	if !ok || len(queue.QueuedMessages()) == 0 {
+		return
+	}
+	app.Logger.Log("Sending %d queued message(s).", len(queue.QueuedMessages()))
+	app.ChatModel.StartThinking()
+	app.isFirstAgentChunk = true
+	app.isAgentProcessing.Store(true)
+	app.streamAgent(queue.SendQueuedMessages)
+}
+
+// interruptTurn runs /interrupt: content, if given, is queued, and the turn
+// in progress is cancelled so the queued messages are sent right away rather
+// than when it ends
+func (app *App) interruptTurn(content string) {
+	if content != "" {
+		if !app.isAgentProcessing.Load() {
+			app.ChatModel.AddUserMessage(content)
+			app.ChatModel.StartThinking()
+			app.isFirstAgentChunk = true
+			app.isAgentProcessing.Store(true)
+			app.listenAgentStreamCmd(content)
+			return
+		}
+		app.queueMessage(content)
+	}
+	if !app.isAgentProcessing.Load() {
+		app.ChatModel.AddSystemMessage("Nothing to interrupt: the assistant is not working on a turn.")
+		return
+	}
+	app.Logger.Log("Interrupting the current turn.")
+	app.interruptRequested = true
+	app.Agent.Cancel()
+	app.Executor.Cancel()
+	app.ChatModel.SetThinkingStatus("Interrupting...")
+}
+
+// resolvePatchTargets resolves patch file paths against the working directory,
+// rejecting the patch if any path escapes it while sandboxing is enforced
+func (app *App) resolvePatchTargets(operations []fileops.AgentPatchOperation) error {
+	for i := range operations {
+		if operations[i].Path == "" {
+			continue
+		}
+		resolved, err := app.Workspace.Resolve(operations[i].Path)
+		if err != nil {
+			return err
+		}
+		operations[i].Path = resolved
+	}
+	return nil
+}
+
+// journalPatchTargets snapshots the files a patch is about to modify
+func (app *App) journalPatchTargets(operations []fileops.AgentPatchOperation) {
+	for _, op := range operations {
+		if op.Path == "" {
+			continue
+		}
+		if err := app.Journal.Record(op.Path); err != nil {
+			app.Logger.Log("WARN: Failed to journal %s before patching: %v", op.Path, err)
+		}
+	}
+}
+
+// applyPatchInBackground applies a parsed agent patch off the UI loop, so
+// each hunk shows as it is applied. The new content of each file is
+// normalized before it is written, and successfully patched files are then
+// formatted. The outcome arrives as a patchAppliedMsg, which
+// sends the result of call.
+func (app *App) applyPatchInBackground(call agent.FunctionCall, operations []fileops.AgentPatchOperation) {
+	app.ChatModel.SetThinkingStatus("Applying patch...")
+	go func() {
+		start := time.Now()
+		app.journalPatchTargets(operations)
+		results, err := fileops.ApplyAgentPatchWith(operations, app.patchNormalizer(), func(p fileops.PatchProgress) {
+			app.agentMsgChan <- patchProgressMsg{progress: p}
+		})
+		msg := patchAppliedMsg{call: call, results: results, err: err}
+		for _, res := range results {
+			if !res.Success {
+				continue
+			}
+			if note := app.normalizedPatchNote(res); note != "" {
+				msg.normalized = append(msg.normalized, note)
+			}
+			if formatErr := app.formatPatchedFile(res.Path); formatErr != "" {
+				msg.formatErrs = append(msg.formatErrs, formatErr)
+			}
+		}
+		msg.duration = time.Since(start)
+		app.agentMsgChan <- msg
+	}()
+}
+
+// finishPatch shows the outcome of a patch applied in the background and
+// sends it to the agent as the result of the patch_file call
+func (app *App) finishPatch(msg patchAppliedMsg) {
+	successCount, failureCount := 0, 0
+	for _, res := range msg.results {
+		if res.Success {
+			successCount++
+		} else {
+			failureCount++
+		}
+		app.ChatModel.AddAgentPatchResultMessage(res)
+	}
+	for _, formatErr := range msg.formatErrs {
+		app.ChatModel.AddSystemMessage(formatErr)
+	}
+	app.ChatModel.ForceUpdateViewport()
+
+	var agentOutput string
+	success := false
+	if msg.err != nil {
+		agentOutput = fmt.Sprintf("Patch application finished with errors. Succeeded: %d, Failed: %d. First error: %v", successCount, failureCount, msg.err)
+	} else if failureCount > 0 {
+		agentOutput = fmt.Sprintf("Patch application finished. Succeeded: %d, Failed: %d.", successCount, failureCount)
+	} else {
+		agentOutput = fmt.Sprintf("Patch application finished successfully. Operations applied: %d.", successCount)
+		success = true
+	}
+	if len(msg.normalized) > 0 {
+		agentOutput += "\n" + strings.Join(msg.normalized, "\n")
+	}
+	app.Logger.Log("Patch application summary for agent: %s", agentOutput)
+
+	app.sendFunctionResultCmd(sendFunctionResultMsg{
+		ctx:          context.Background(),
+		functionName: msg.call.Name,
+		callID:       msg.call.ID,
+		originalArgs: msg.call.Arguments,
+		output:       agentOutput,
+		success:      success,
+		duration:     msg.duration,
+	})
+}
+
+// formatPatchedFile runs the formatter for path's language on it, if there
+// is one, and returns a message for the user when formatting failed
+func (app *App) formatPatchedFile(path string) string {
+	formatCmdStr := getFormatterCommand(path)
+	if formatCmdStr == "" {
+		app.Logger.Log("No formatter identified for file extension of %s, skipping auto-format.", path)
+		return ""
+	}
+	app.Logger.Log("Attempting to auto-format successfully patched file: %s with command: %s", path, formatCmdStr)
+	formatCtx, formatCancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer formatCancel()
+	formatResult, formatErr := app.Sandbox.Execute(formatCtx, sandbox.SandboxOptions{
+		Command:    formatCmdStr,
+		Shell:      app.Config.Shell,
+		WorkingDir: app.Workspace.Cwd(),
+	})
+	if formatErr != nil || formatResult.ExitCode != 0 {
+		formatErrMsg := fmt.Sprintf("Auto-formatting failed for %s.", path)
+		if formatErr != nil {
+			formatErrMsg = fmt.Sprintf("%s Error: %v", formatErrMsg, formatErr)
+		} else {
+			formatErrMsg = fmt.Sprintf("%s Exit Code: %d, Stderr: %s", formatErrMsg, formatResult.ExitCode, formatResult.Stderr)
+		}
+		app.Logger.Log("ERROR: %s", formatErrMsg)
+		return formatErrMsg
+	}
+	app.Logger.Log("Successfully auto-formatted %s.", path)
+	return ""
+}
+
+// patchNormalizer returns the normalizer the content of files the agent
+// patches is passed through before it is written, or nil when .editorconfig
+// normalization is disabled
+func (app *App) patchNormalizer() fileops.Normalizer {
+	if app.Config.DisableEditorConfig {
+		return nil
+	}
+	return editorconfig.NormalizeFor
+}
+
+// normalizedPatchNote tells the model how a file the agent patched was
+// normalized to its .editorconfig properties, or returns "" when it was not
+func (app *App) normalizedPatchNote(res *fileops.AgentPatchResult) string {
+	if res.NormalizeError != nil {
+		app.Logger.Log("WARN: Failed to normalize %s to .editorconfig: %v", res.Path, res.NormalizeError)
+		return ""
+	}
+	if len(res.Normalized) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s was normalized to .editorconfig: %s.", res.Path, strings.Join(res.Normalized, ", "))
+}
+
+// startTurnReview shows the consolidated changes of the finished turn for review.
+// When review is disabled or nothing changed, the turn's journal is simply finalized.
+func (app *App) startTurnReview() {
+	if app.Journal == nil || app.Journal.Len() == 0 {
+		return
+	}
+	app.addTurnDiffStat()
+	if !app.Config.TurnReviewEnabled() {
+		app.Journal.Reset()
+		return
+	}
+
+	changes := app.Journal.Changes()
+	if len(changes) == 0 {
+		app.Journal.Reset()
+		return
+	}
+
+	app.Logger.Log("Starting end-of-turn review of %d changed file(s).", len(changes))
+	app.reviewModel = ui.NewReviewModel(changes)
+	app.reviewModel.SetSnapshot(app.turnSnapshot)
+	app.reviewModel.SetSize(app.width, app.height)
+	app.isReviewing = true
+}
+
+// addTurnDiffStat computes the summary of the files the turn changed, once
+// at its end, and emits it as a "turn_diffstat" item
+func (app *App) addTurnDiffStat() {
+	stat := app.Journal.DiffStat()
+	if len(stat.Files) == 0 {
+		return
+	}
+	app.handleAgentResponseItem(agent.ResponseItem{Type: agent.EventTurnDiffStat, DiffStat: &stat})
+}
+
+// finishTurnReview reverts the files the user unselected and finalizes the turn
+func (app *App) finishTurnReview(keep map[string]bool) {
+	app.isReviewing = false
+
+	var reverted, failed []string
+	for path, kept := range keep {
+		if kept {
+			continue
+		}
+		if err := app.Journal.Revert(path); err != nil {
+			app.Logger.Log("ERROR: Failed to revert %s: %v", path, err)
+			failed = append(failed, path)
+		} else {
+			reverted = append(reverted, path)
+		}
+	}
+	app.Journal.Reset()
+
+	summary := fmt.Sprintf("Review complete: kept %d file(s), reverted %d.", len(keep)-len(reverted)-len(failed), len(reverted))
+	if len(reverted) > 0 {
+		sort.Strings(reverted)
+		summary += "\nReverted: " + strings.Join(reverted, ", ")
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		summary += "\nFailed to revert: " + strings.Join(failed, ", ")
+	}
+	app.Logger.Log("%s", summary)
+	app.ChatModel.AddSystemMessage(summary)
+	app.ChatModel.ForceUpdateViewport()
+}
+
 // needsApprovalForFunction determines if a function needs approval based on the current mode
 func (app *App) needsApprovalForFunction(functionName string) bool {
-	// Logging the check
-	app.Logger.Log("Checking approval for function '%s' with mode '%s'", functionName, app.Config.ApprovalMode)
-
-	switch app.Config.ApprovalMode {
-	case config.Suggest:
-		needs := functionName != "read_file" && functionName != "list_directory"
-		app.Logger.Log("Suggest Mode: Needs approval = %t", needs)
-		return needs
-	case config.AutoEdit:
-		needs := functionName == "execute_command"
-		app.Logger.Log("AutoEdit Mode: Needs approval = %t", needs)
-		return needs
-	case config.FullAuto:
-		app.Logger.Log("FullAuto Mode: Needs approval = false")
+	needs := functions.NeedsApproval(app.Config.ApprovalMode, functionName)
+	app.Logger.Log("Checking approval for function '%s' with mode '%s': needs approval = %t", functionName, app.Config.ApprovalMode, needs)
+	return needs
+}
+
+// commandWritesOutsideWorkspace reports whether a shell command, run in the
+// session working directory, is likely to write outside the workspace, which
+// escalates it to approval even in auto modes
+func (app *App) commandWritesOutsideWorkspace(call *agent.FunctionCall) bool {
+	if !functions.IsShellTool(call.Name) || app.Config.ApprovalMode == config.DangerousAutoApprove {
+		return false
+	}
+	var args struct {
+		Command string `json:"command"`
+	}
+	if err := json.Unmarshal([]byte(call.Arguments), &args); err != nil || args.Command == "" {
 		return false
-	case config.DangerousAutoApprove:
-		app.Logger.Log("Dangerous Mode: Needs approval = false")
+	}
+	outside := sandbox.AnalyzeCommand(args.Command).WritesOutsideFrom(app.Workspace.Cwd(), app.Workspace.Dir)
+	if len(outside) == 0 {
 		return false
+	}
+	app.Logger.Log("Escalating '%s' to approval: writes outside the workspace (%s)", args.Command, strings.Join(outside, ", "))
+	return true
+}
+
+// reviewCommandEdit records that the user edited the proposed command and runs
+// the approval policy again on the edited one: a rule denying it refuses the
+// call, and a rule prompting for it, or a write outside the workspace the
+// policy does not allow, asks for approval again. It returns true when the
+// edited command does not run now.
+func (app *App) reviewCommandEdit(edited string) bool {
+	if app.proposedCommand == "" {
+		app.proposedCommand = app.pendingApprovalArgs
+	}
+	app.Logger.Log("[AUDIT] App: User edited command for call %s before approving: %q -> %q", app.pendingFunctionCall.ID, app.proposedCommand, edited)
+	app.pendingApprovalArgs = edited
+	app.ChatModel.AddSystemMessage(fmt.Sprintf("Command edited before running: %s", edited))
+
+	call := editedCommandCall(app.pendingFunctionCall, edited)
+	match, matched := app.policyDecision(call)
+	switch {
+	case matched && match.Decision == policy.Deny:
+		app.refuseCall(app.pendingFunctionCall, fmt.Sprintf("Policy error: the edited command '%s' was denied: %s", edited, match.Explain()))
+		app.pendingFunctionCall = nil
+		app.pendingApprovalArgs = ""
+		app.proposedCommand = ""
+		return true
+	case matched:
+		if match.Decision != policy.Prompt || app.Config.ApprovalMode == config.DangerousAutoApprove {
+			return false
+		}
+		app.Logger.Log("Edited command '%s' needs approval by policy; asking again.", edited)
+		app.ChatModel.AddSystemMessage("The edited command needs approval again by policy: " + match.Explain())
 	default:
-		app.Logger.Log("WARN: Unknown approval mode '%s', defaulting to 'suggest' behavior.", app.Config.ApprovalMode)
-		return functionName != "read_file" && functionName != "list_directory"
+		outside := sandbox.AnalyzeCommand(edited).WritesOutsideFrom(app.Workspace.Cwd(), app.Workspace.Dir)
+		if len(outside) == 0 || app.Config.ApprovalMode == config.DangerousAutoApprove {
+			return false
+		}
+		app.Logger.Log("Edited command '%s' writes outside the workspace (%s); asking again.", edited, strings.Join(outside, ", "))
+		app.ChatModel.AddSystemMessage(fmt.Sprintf("The edited command writes outside the workspace (%s) and needs approval again.", strings.Join(outside, ", ")))
+	}
+	app.askForApproval(app.pendingFunctionCall.Name, edited, app.pendingFunctionCall)
+	return true
+}
+
+// refuseCall shows why a tool call is refused and sends the reason to the
+// model as the call's failed result
+func (app *App) refuseCall(call *agent.FunctionCall, reason string) {
+	app.ChatModel.AddSystemMessage(reason)
+	resultMsg := sendFunctionResultMsg{
+		ctx:          context.Background(),
+		functionName: call.Name,
+		callID:       call.ID,
+		originalArgs: call.Arguments,
+		output:       reason,
+		success:      false,
+	}
+	go func() {
+		app.agentMsgChan <- resultMsg
+	}()
+}
+
+// editedCommandCall returns call with its command replaced by the user's edit
+func editedCommandCall(call *agent.FunctionCall, command string) *agent.FunctionCall {
+	args := map[string]interface{}{}
+	json.Unmarshal([]byte(call.Arguments), &args)
+	args["command"] = command
+	data, _ := json.Marshal(args)
+	edited := *call
+	edited.Arguments = string(data)
+	return &edited
+}
+
+// offerFileSuggestions lists the files the last reply gave in full in code
+// blocks, so the user can apply them with /apply
+func (app *App) offerFileSuggestions() {
+	app.fileSuggestions = nil
+	if app.Config.ReadOnly {
+		return
+	}
+	last, ok := app.Agent.GetHistory().GetLastMessage()
+	if !ok || last.Role != "assistant" {
+		return
+	}
+	suggestions := fileops.ExtractFileSuggestions(last.Content)
+	if len(suggestions) == 0 {
+		return
+	}
+	app.fileSuggestions = suggestions
+
+	var b strings.Builder
+	b.WriteString("The reply contains file contents that were not written. Use /apply <n> to write one:")
+	for i, suggestion := range suggestions {
+		state := "new file"
+		if resolved, err := app.Workspace.Resolve(suggestion.Path); err != nil {
+			state = "outside the workspace"
+		} else if _, err := os.Stat(resolved); err == nil {
+			state = "replaces existing file"
+		}
+		fmt.Fprintf(&b, "\n  %d. %s (%s)", i+1, suggestion.Path, state)
+	}
+	app.Logger.Log("Offering %d file suggestion(s) from the last reply.", len(suggestions))
+	app.ChatModel.AddSystemMessage(b.String())
+	app.ChatModel.ForceUpdateViewport()
+}
+
+// applyFileSuggestion writes file suggestion n (1-based) through the write_file
+// tool, asking for approval with a diff against the existing file when the
+// approval mode requires it for write_file
+func (app *App) applyFileSuggestion(args []string) {
+	if app.isAgentProcessing.Load() {
+		app.ChatModel.AddSystemMessage("Wait for the assistant to finish before applying a suggestion.")
+		return
+	}
+	if len(app.fileSuggestions) == 0 {
+		app.ChatModel.AddSystemMessage("The last reply has no file suggestions to apply.")
+		return
+	}
+	n := 0
+	if len(args) == 1 {
+		n, _ = strconv.Atoi(args[0])
+	}
+	if n < 1 || n > len(app.fileSuggestions) {
+		app.ChatModel.AddSystemMessage(fmt.Sprintf("Usage: /apply <n>, where n is 1 to %d", len(app.fileSuggestions)))
+		return
+	}
+	suggestion := app.fileSuggestions[n-1]
+
+	resolved, err := app.Workspace.Resolve(suggestion.Path)
+	if err != nil {
+		app.ChatModel.AddSystemMessage(fmt.Sprintf("Cannot apply the suggestion for %s: %v", suggestion.Path, err))
+		return
+	}
+	existing, err := os.ReadFile(resolved)
+	if err != nil && !os.IsNotExist(err) {
+		app.ChatModel.AddSystemMessage(fmt.Sprintf("Cannot read %s: %v", suggestion.Path, err))
+		return
+	}
+	diff := fileops.UnifiedDiff(suggestion.Path, string(existing), suggestion.Content)
+	if diff == "" {
+		app.ChatModel.AddSystemMessage(fmt.Sprintf("%s already matches the suggestion.", suggestion.Path))
+		return
+	}
+
+	arguments, _ := json.Marshal(map[string]string{"path": suggestion.Path, "content": suggestion.Content})
+	call := &agent.FunctionCall{Name: "write_file", Arguments: string(arguments)}
+	app.applyingSuggestion = &suggestion
+	if app.needsApprovalForFunction("write_file") {
+		app.askForApproval("write_file", diff, call)
+		return
+	}
+	app.pendingFunctionCall = call
+	app.finishFileSuggestion(true)
+}
+
+// finishFileSuggestion writes the suggestion awaiting approval if approved.
+// The write is the user's own edit, so it is kept out of the next turn's review.
+func (app *App) finishFileSuggestion(approved bool) {
+	suggestion, call := app.applyingSuggestion, app.pendingFunctionCall
+	app.applyingSuggestion = nil
+	app.pendingFunctionCall = nil
+	app.pendingApprovalArgs = ""
+
+	if !approved {
+		app.ChatModel.AddSystemMessage(fmt.Sprintf("Suggestion for %s not applied.", suggestion.Path))
+		return
+	}
+	fn := app.FunctionRegistry.Get("write_file")
+	if fn == nil {
+		app.ChatModel.AddSystemMessage("Cannot apply the suggestion: write_file is not available.")
+		return
+	}
+	result, err := fn(call.Arguments)
+	app.Journal.Reset()
+	if err != nil {
+		app.Logger.Log("ERROR: Failed to apply suggestion for %s: %v", suggestion.Path, err)
+		app.ChatModel.AddSystemMessage(fmt.Sprintf("Failed to apply the suggestion for %s: %v", suggestion.Path, err))
+		return
+	}
+	app.Logger.Log("[AUDIT] App: User applied the suggested contents of %s.", suggestion.Path)
+	app.ChatModel.AddSystemMessage(fmt.Sprintf("Applied suggestion: %s", result))
+	app.ChatModel.ForceUpdateViewport()
+}
+
+// runCommand runs a shell command in the sandbox once the executor queue has a
+// slot for it, as tool call callID, with stdin as its input when given. Otherwise the user is asked
+// for any input it waits for. The result is never nil, even when the command
+// did not run.
+func (app *App) runCommand(callID, command, stdin string) (*sandbox.CommandResult, error) {
+	result := &sandbox.CommandResult{}
+	_, err := app.Executor.Run(functions.WithCallID(context.Background(), callID), "execute_command", func(ctx context.Context, command string) (string, error) {
+		opts := sandbox.SandboxOptions{
+			Command:    command,
+			Shell:      app.Config.Shell,
+			WorkingDir: app.Workspace.Cwd(),
+			ReadOnly:   app.Config.ReadOnly,
+			Env:        app.Config.CommandEnvironment(),
+			Timeout:    30 * time.Second,
+			OnInput:    app.commandInput,
+		}
+		if stdin != "" {
+			opts.Stdin = strings.NewReader(stdin)
+		}
+		executed, err := app.Sandbox.Execute(ctx, opts)
+		if executed != nil {
+			result = executed
+			if err == nil && errors.Is(executed.Error, sandbox.ErrWantsInput) {
+				err = functions.WantsInputError(executed.Error)
+			}
+		}
+		return result.Stdout, err
+	}, command, app.queueStatus(callID))
+	return result, err
+}
+
+// runTool runs a registered tool as tool call callID once the executor queue
+// has a slot for it
+func (app *App) runTool(callID, name, args string) (string, error) {
+	fn := app.FunctionRegistry.GetContext(name)
+	if fn == nil {
+		return "", fmt.Errorf("unknown function: %s", name)
+	}
+	ctx := sandbox.WithInputHandler(functions.WithCallID(context.Background(), callID), app.commandInput)
+	return app.Executor.Run(ctx, name, fn, args, app.queueStatus(callID))
+}
+
+// syncWorkingDir records the session working directory with the agent and
+// shows it in the status bar
+func (app *App) syncWorkingDir() {
+	dir := app.Workspace.Cwd()
+	if setter, ok := app.Agent.(interface{ SetWorkingDir(dir string) error }); ok {
+		if err := setter.SetWorkingDir(dir); err != nil {
+			app.Logger.Log("Failed to record working directory %s: %v", dir, err)
+		}
+	}
+	app.ChatModel.SetSessionInfo("", dir, "", "")
+	app.Logger.Log("Working directory is now %s.", dir)
+}
+
+// restoreWorkingDir moves a resumed session back to the working directory it
+// was in. A directory that no longer exists leaves it at the workspace root.
+func (app *App) restoreWorkingDir(dir string) {
+	if dir == "" {
+		return
+	}
+	if _, err := app.Workspace.Chdir(dir); err != nil {
+		app.Logger.Log("Cannot restore working directory %s: %v", dir, err)
+		app.ChatModel.AddSystemMessage(fmt.Sprintf("Could not return to working directory %s (%v); using %s.", dir, err, app.Workspace.Cwd()))
+	}
+	app.syncWorkingDir()
+}
+
+// handleSetCommand runs /set, which shows or changes the tool concurrency limits
+func (app *App) handleSetCommand(args []string) string {
+	if len(args) == 0 {
+		return "Tool concurrency limits:\n" + app.Executor.Describe()
+	}
+	if len(args) != 2 {
+		return "Usage: /set max_concurrent_tools <n> | /set tool_concurrency.<tool> <n>"
+	}
+	limit, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Sprintf("Invalid limit %q: expected a number (0 = unlimited)", args[1])
+	}
+
+	switch key := args[0]; {
+	case key == "max_concurrent_tools":
+		app.Executor.SetMaxConcurrent(limit)
+	case strings.HasPrefix(key, "tool_concurrency.") && len(key) > len("tool_concurrency."):
+		app.Executor.SetLimit(strings.TrimPrefix(key, "tool_concurrency."), limit)
+	default:
+		return fmt.Sprintf("Unknown setting: %s (expected max_concurrent_tools or tool_concurrency.<tool>)", key)
+	}
+	app.Logger.Log("User set %s to %d.", args[0], limit)
+	return "Tool concurrency limits:\n" + app.Executor.Describe()
+}
+
+// commandEditNote tells the model that the user changed its command before running it
+func commandEditNote(proposed, executed string) string {
+	return fmt.Sprintf("The user edited the command before running it.\nProposed: %s\nExecuted: %s", proposed, executed)
+}
+
+// handleMemoryCommand runs a /memory subcommand and returns the text to show
+func (app *App) handleMemoryCommand(args []string) string {
+	if app.Memory == nil {
+		return "Project memory is disabled."
+	}
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	switch args[0] {
+	case "list":
+		entries := app.Memory.List()
+		if len(entries) == 0 {
+			return fmt.Sprintf("No remembered facts (%s).", app.Memory.Path())
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "Project memory (%s):", app.Memory.Path())
+		for _, e := range entries {
+			fmt.Fprintf(&b, "\n  %s: %s", e.Key, e.Value)
+		}
+		return b.String()
+	case "forget":
+		if len(args) < 2 {
+			return "Usage: /memory forget <key>"
+		}
+		key := strings.Join(args[1:], " ")
+		removed, err := app.Memory.Forget(key)
+		if err != nil {
+			return fmt.Sprintf("Failed to forget %q: %v", key, err)
+		}
+		if !removed {
+			return fmt.Sprintf("No remembered fact named %q.", key)
+		}
+		return fmt.Sprintf("Forgot %q.", key)
+	default:
+		return fmt.Sprintf("Unknown memory command: %s (expected list or forget)", args[0])
 	}
 }
 
@@ -919,15 +1973,31 @@ func (app *App) askForApproval(functionName, argsToDisplay string, originalCall
 	case "write_file":
 		title = "Approve File Write"
 		description = "The assistant wants to write to a file on your filesystem:"
+		if app.applyingSuggestion != nil {
+			title = "Apply Suggested File"
+			description = fmt.Sprintf("Write the code block from the assistant's reply to %s:", app.applyingSuggestion.Path)
+			contentToDisplay = ui.FormatUnifiedDiffForDisplay(argsToDisplay)
+		}
+	case "append_file":
+		title = "Approve File Append"
+		description = "The assistant wants to append to a file on your filesystem:"
+	case "apply_edits":
+		title = "Approve Multi-File Edit"
+		description = "The assistant wants to apply these edits together; either all of them are applied or none:"
+	case "commit_write":
+		title = "Approve Chunked File Write"
+		description = "The assistant wants to commit a staged chunked write to a file on your filesystem:"
 	case "patch_file":
 		title = "Approve File Patch"
 		description = "The assistant wants to modify file(s) using the following patch:"
 		// Format the patch content for display
 		app.Logger.Log("Formatting patch content for display...")
 		contentToDisplay = ui.FormatPatchForDisplay(argsToDisplay)
-	case "execute_command":
+	case "shell", "execute_command":
 		title = "Approve Command Execution"
 		description = "The assistant wants to execute the following shell command:"
+		// Static preview of what the command is likely to touch
+		contentToDisplay = argsToDisplay + "\n\nLikely effects:\n" + sandbox.AnalyzeCommand(argsToDisplay).Summary()
 	default:
 		title = "Approve Operation"
 		description = fmt.Sprintf("The assistant wants to perform the '%s' operation with arguments:", functionName)
@@ -935,6 +2005,9 @@ func (app *App) askForApproval(functionName, argsToDisplay string, originalCall
 
 	app.Logger.Log("Creating ApprovalModel. Title: %s, Desc: %s, Content Length: %d", title, description, len(contentToDisplay))
 	app.approvalModel = ui.NewApprovalModel(title, description, contentToDisplay)
+	if functions.IsShellTool(functionName) {
+		app.approvalModel.AllowEdit(argsToDisplay)
+	}
 	app.isAwaitingApproval = true
 	app.pendingFunctionCall = originalCall  // Store the original call details
 	app.pendingApprovalArgs = argsToDisplay // Store the *original*, unformatted args shown to the user
@@ -948,9 +2021,35 @@ func (app *App) askForApproval(functionName, argsToDisplay string, originalCall
 	app.Logger.Log("Approval state set. Waiting for ui.ApprovalResultMsg.")
 }
 
-// initRepositoryContext loads project-specific context from codex.md files
+// repositoryContextPriority merges the codex.md context right after the base
+// system prompt, before the other additions
+const repositoryContextPriority = 10
+
+// initRepositoryContext loads project-specific context from codex.md files.
+// Agents that support system prompt sources reload it for every request, so
+// edits to the files apply without a restart.
 func (app *App) initRepositoryContext() error {
 	app.Logger.Log("Initializing repository context...")
+	if sources, ok := app.Agent.(interface {
+		AddSystemPromptSource(src agent.SystemPromptSource) error
+	}); ok {
+		return sources.AddSystemPromptSource(agent.SystemPromptSource{
+			Name:     "repository_context",
+			Priority: repositoryContextPriority,
+			Content: func(*agent.ConversationHistory) string {
+				repoContext, err := app.loadRepositoryContext()
+				if err != nil {
+					app.Logger.Log("Error loading repository context: %v", err)
+					return ""
+				}
+				if repoContext == "" {
+					return ""
+				}
+				return "Repository Context:\n" + repoContext
+			},
+		})
+	}
+
 	repoContext, err := app.loadRepositoryContext()
 	if err != nil {
 		app.Logger.Log("Error loading repository context: %v", err)
@@ -1055,6 +2154,7 @@ func (app *App) SaveRollout() error {
 	history := app.Agent.GetHistory()
 	if history != nil {
 		app.CurrentRollout.Messages = history.GetMessages()
+		app.CurrentRollout.WorkingDir = history.WorkingDir
 	}
 
 	if app.RolloutPath == "" {
@@ -1109,19 +2209,67 @@ func (app *App) LoadRollout(path string) error {
 	app.Logger.Log("Rollout loaded successfully. SessionID: %s, CreatedAt: %s", rollout.SessionID, rollout.CreatedAt)
 
 	// Add the messages to the chat model
-	for _, msg := range rollout.Messages {
+	app.showMessages(rollout.Messages, rollout.DiffStats...)
+	app.Logger.Log("Loaded %d messages from rollout into ChatModel.", len(rollout.Messages))
+	app.restoreWorkingDir(rollout.WorkingDir)
+
+	return nil
+}
+
+// RecoverSession continues a session that did not shut down cleanly, restoring
+// its conversation into the agent and the chat view
+func (app *App) RecoverSession(id string) error {
+	recoverer, ok := app.Agent.(interface{ RecoverSession(id string) error })
+	if !ok {
+		return fmt.Errorf("agent does not support session recovery")
+	}
+	if err := recoverer.RecoverSession(id); err != nil {
+		return err
+	}
+
+	messages := app.Agent.GetHistory().GetMessages()
+	app.showMessages(messages)
+	app.ChatModel.AddSystemMessage(fmt.Sprintf("Recovered session %s (%d messages).", id, len(messages)))
+	app.restoreWorkingDir(app.Agent.GetHistory().WorkingDir)
+	app.Logger.Log("Recovered session %s with %d messages.", id, len(messages))
+
+	// Messages queued before the crash are sent now that no turn is running
+	if queued := app.Agent.GetHistory().QueuedMessages; len(queued) > 0 {
+		app.ChatModel.SetQueued(queued)
+		app.ChatModel.AddSystemMessage(fmt.Sprintf("Sending %d message(s) queued before the session ended.", len(queued)))
+		app.sendQueuedMessages()
+	}
+	return nil
+}
+
+// pinnedLabel marks pinned messages in the chat view
+const pinnedLabel = "[pinned] "
+
+// showMessages adds the user, assistant and system messages to the chat view,
+// pinned ones labelled, followed by the diff stat footers of the turns that
+// ended after them
+func (app *App) showMessages(messages []agent.Message, diffStats ...TurnDiffStat) {
+	for i, msg := range messages {
+		content := msg.Content
+		if msg.Pinned {
+			content = pinnedLabel + content
+		}
 		switch msg.Role {
 		case "user":
-			app.ChatModel.AddUserMessage(msg.Content)
+			app.ChatModel.AddUserMessage(content)
 		case "assistant":
-			app.ChatModel.AddAssistantMessage(msg.Content)
+			app.ChatModel.AddAssistantMessage(content)
 		case "system":
-			app.ChatModel.AddSystemMessage(msg.Content)
+			app.ChatModel.AddSystemMessage(content)
+		}
+		for len(diffStats) > 0 && diffStats[0].Messages <= i+1 {
+			app.ChatModel.AddDiffStatMessage(diffStats[0].DiffStat)
+			diffStats = diffStats[1:]
 		}
 	}
-	app.Logger.Log("Loaded %d messages from rollout into ChatModel.", len(rollout.Messages))
-
-	return nil
+	for _, stat := range diffStats {
+		app.ChatModel.AddDiffStatMessage(stat.DiffStat)
+	}
 }
 
 // Placeholder definition for logDebug if it doesn't exist
@@ -1138,61 +2286,84 @@ func logDebug(format string, args ...interface{}) {
 
 // Close closes the application and cleans up all resources
 func (app *App) Close() error {
-	app.Logger.Log("App.Close: Cleaning up resources...")
+	app.closeOnce.Do(func() {
+		app.Logger.Log("App.Close: Cleaning up resources...")
+
+		// Set the app as not running
+		app.IsRunning = false
+
+		// Cancel agent operations
+		if app.Agent != nil {
+			app.Logger.Log("App.Close: Cancelling agent...")
+			app.Agent.Cancel()
+			app.Executor.Cancel()
+			if err := app.Agent.Close(); err != nil {
+				app.Logger.Log("App.Close: Error closing agent: %v", err)
+				// Continue with cleanup despite errors
+			}
+		}
 
-	// Set the app as not running
-	app.IsRunning = false
+		// Ensure sandbox is closed if needed
+		if closer, ok := app.Sandbox.(io.Closer); ok {
+			app.Logger.Log("App.Close: Closing sandbox...")
+			if err := closer.Close(); err != nil {
+				app.Logger.Log("App.Close: Error closing sandbox: %v", err)
+				// Continue with cleanup despite errors
+			}
+		}
 
-	// Cancel agent operations
-	if app.Agent != nil {
-		app.Logger.Log("App.Close: Cancelling agent...")
-		app.Agent.Cancel()
-		if err := app.Agent.Close(); err != nil {
-			app.Logger.Log("App.Close: Error closing agent: %v", err)
-			// Continue with cleanup despite errors
+		// Stop any language servers started by the code navigation tools
+		if app.LanguageServers != nil {
+			app.Logger.Log("App.Close: Stopping language servers...")
+			app.LanguageServers.Close()
 		}
-	}
 
-	// Ensure sandbox is closed if needed
-	if closer, ok := app.Sandbox.(io.Closer); ok {
-		app.Logger.Log("App.Close: Closing sandbox...")
-		if err := closer.Close(); err != nil {
-			app.Logger.Log("App.Close: Error closing sandbox: %v", err)
+		// Save current session state if needed
+		app.Logger.Log("App.Close: Saving rollout...")
+		if err := app.SaveRollout(); err != nil {
+			app.Logger.Log("App.Close: Error saving rollout: %v", err)
 			// Continue with cleanup despite errors
 		}
-	}
-
-	// Save current session state if needed
-	app.Logger.Log("App.Close: Saving rollout...")
-	if err := app.SaveRollout(); err != nil {
-		app.Logger.Log("App.Close: Error saving rollout: %v", err)
-		// Continue with cleanup despite errors
-	}
 
-	// Close the agent message channel to unblock any waiting goroutines
-	if app.agentMsgChan != nil {
-		app.Logger.Log("App.Close: Closing agent message channel...")
-		close(app.agentMsgChan)
-	}
+		// agentMsgChan is left open: after a signal-driven Cancel, stream
+		// goroutines may still be sending on it
 
-	app.Logger.Log("App.Close: Cleanup complete")
+		app.Logger.Log("App.Close: Cleanup complete")
+	})
 	return nil
 }
 
-// extractTargetFilesFromPatch is a simple helper to find // FILE: lines in a patch string
-func extractTargetFilesFromPatch(patchContent string) []string {
-	var files []string
-	lines := strings.Split(patchContent, "\n")
-	for _, line := range lines {
-		trimmedLine := strings.TrimSpace(line)
-		if strings.HasPrefix(trimmedLine, "// FILE:") {
-			filename := strings.TrimSpace(strings.TrimPrefix(trimmedLine, "// FILE:"))
-			if filename != "" {
-				files = append(files, filename)
+// Shutdown stops the app in response to a termination signal: the current turn
+// is cancelled, running tool processes get grace to exit before being killed,
+// and the session is saved, marked as interrupted if a turn was in progress.
+func (app *App) Shutdown(grace time.Duration) error {
+	interrupted := app.isAgentProcessing.Load()
+	app.Logger.Log("App.Shutdown: Starting (turn in progress: %t)", interrupted)
+
+	// Cancelling first keeps the pending tool calls, so the next session
+	// records them as aborted
+	if app.Agent != nil {
+		app.Agent.Cancel()
+	}
+	app.Executor.Cancel() // Drops queued tool calls; running ones get the grace below
+
+	if killed := sandbox.TerminateAll(grace); killed > 0 {
+		app.Logger.Log("App.Shutdown: Killed %d tool process groups that ignored SIGTERM", killed)
+	}
+
+	if interrupted {
+		if app.Agent != nil {
+			if history := app.Agent.GetHistory(); history != nil {
+				history.MarkInterrupted()
 			}
 		}
+		if app.CurrentRollout == nil {
+			app.CurrentRollout = &AppRollout{CreatedAt: time.Now(), SessionID: uuid.New().String()}
+		}
+		app.CurrentRollout.Interrupted = true
 	}
-	return files
+
+	return app.Close()
 }
 
 // getFormatterCommand returns a suitable formatting command string for a given file path
@@ -1215,3 +2386,19 @@ func getFormatterCommand(filePath string) string {
 		return ""
 	}
 }
+
+// callVerb describes what a tool call whose arguments are streaming is about to do
+func callVerb(name string) string {
+	if agent.IsMutatingTool(name) {
+		return "Writing"
+	}
+	return "Preparing"
+}
+
+// formatArgumentSize renders a byte count as B or KB
+func formatArgumentSize(bytes int) string {
+	if bytes < 1024 {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	return fmt.Sprintf("%.1f KB", float64(bytes)/1024)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// maxPickerMatches is how many files a search in the file picker lists
+const maxPickerMatches = 15
+
+// maxPickerFiles caps how many workspace files the file picker searches
+const maxPickerFiles = 50000
+
+// pickerSkipDirs are directories the file picker does not search
+var pickerSkipDirs = map[string]bool{"node_modules": true, "vendor": true}
+
+// pendingChoice is an ask_user_choice call waiting for the user's selection
+type pendingChoice struct {
+	call    agent.FunctionCall
+	request agent.ChoiceRequest
+	options []string // Listed choices: the request's options, or the files matching the last search
+	query   string   // Last file search
+}
+
+// addChoiceAnswers adds the answers of the --choices file to cfg.ChoiceAnswers
+func addChoiceAnswers(cmd *cobra.Command, cfg *config.Config) error {
+	path, _ := cmd.Flags().GetString("choices")
+	if path == "" {
+		return nil
+	}
+	answers, err := agent.LoadChoiceAnswers(path)
+	if err != nil {
+		return err
+	}
+	if cfg.ChoiceAnswers == nil {
+		cfg.ChoiceAnswers = make(map[string]string)
+	}
+	maps.Copy(cfg.ChoiceAnswers, answers)
+	return nil
+}
+
+// startChoice waits for the user to answer an ask_user_choice call. A call
+// that cannot be shown is answered with the error straight away.
+func (app *App) startChoice(call agent.FunctionCall) {
+	request, err := agent.ParseChoiceRequest(call.Arguments)
+	if err != nil {
+		app.Logger.Log("Invalid ask_user_choice call %s: %v", call.ID, err)
+		app.sendChoiceResult(call, err.Error(), false)
+		return
+	}
+	app.Logger.Log("Assistant asked for a choice (ID: %s, kind %q); waiting for the user's selection.", call.ID, request.Kind)
+	app.pendingChoice = &pendingChoice{call: call, request: request}
+	if request.Kind != agent.ChoiceKindFiles {
+		app.pendingChoice.options = request.Options
+	}
+}
+
+// prompt renders the question with the listed choices and how to answer
+func (c *pendingChoice) prompt() string {
+	var b strings.Builder
+	b.WriteString(c.request.Question)
+	if c.request.Kind == agent.ChoiceKindFiles && c.query != "" {
+		if len(c.options) == 0 {
+			fmt.Fprintf(&b, "\nNo files match %q.", c.query)
+		} else {
+			fmt.Fprintf(&b, "\nFiles matching %q:", c.query)
+		}
+	}
+	for i, option := range c.options {
+		fmt.Fprintf(&b, "\n  %d. %s", i+1, option)
+	}
+
+	pick := "the number of your choice"
+	if c.request.Multiple {
+		pick = "the numbers of your choices, separated by spaces or commas"
+	}
+	switch {
+	case c.request.Kind != agent.ChoiceKindFiles:
+		fmt.Fprintf(&b, "\nType %s.", pick)
+	case len(c.options) == 0:
+		b.WriteString("\nType part of a file name to search the workspace.")
+	default:
+		fmt.Fprintf(&b, "\nType %s, or another search.", pick)
+	}
+	return b.String()
+}
+
+// answerChoice handles input while an ask_user_choice call waits: a
+// selection answers the call, and in the file picker other input searches
+// the workspace
+func (app *App) answerChoice(input string) {
+	choice := app.pendingChoice
+	input = strings.TrimSpace(input)
+
+	selection, ok := choice.pick(input)
+	if !ok && choice.request.Kind == agent.ChoiceKindFiles && input != "" {
+		choice.query = input
+		choice.options = app.searchFiles(input)
+		if len(choice.options) == 1 && !choice.request.Multiple {
+			selection, ok = choice.options, true
+		} else {
+			app.ChatModel.AskQuestion(choice.prompt())
+			return
+		}
+	}
+	if !ok {
+		if len(choice.options) == 0 {
+			app.ChatModel.AddSystemMessage("Type part of a file name to search the workspace.")
+		} else {
+			app.ChatModel.AddSystemMessage(fmt.Sprintf("Type a number from 1 to %d.", len(choice.options)))
+		}
+		return
+	}
+
+	app.pendingChoice = nil
+	app.ChatModel.ClearQuestion()
+	answer := strings.Join(selection, "\n")
+	app.Logger.Log("User answered choice %s: %q", choice.call.ID, answer)
+	app.ChatModel.AddUserMessage(answer)
+	app.ChatModel.StartThinking()
+	app.sendChoiceResult(choice.call, answer, true)
+}
+
+// pick returns the listed choices input selects, by number or by name
+func (c *pendingChoice) pick(input string) ([]string, bool) {
+	for _, option := range c.options {
+		if strings.EqualFold(option, input) {
+			return []string{option}, true
+		}
+	}
+	fields := strings.FieldsFunc(input, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(fields) == 0 || (len(fields) > 1 && !c.request.Multiple) {
+		return nil, false
+	}
+	var selection []string
+	for _, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 || n > len(c.options) {
+			return nil, false
+		}
+		selection = append(selection, c.options[n-1])
+	}
+	return selection, true
+}
+
+// sendChoiceResult answers an ask_user_choice call
+func (app *App) sendChoiceResult(call agent.FunctionCall, output string, success bool) {
+	resultMsg := sendFunctionResultMsg{
+		ctx:          context.Background(),
+		functionName: call.Name,
+		callID:       call.ID,
+		originalArgs: call.Arguments,
+		output:       output,
+		success:      success,
+	}
+	go func() {
+		app.agentMsgChan <- resultMsg
+	}()
+}
+
+// searchFiles returns the workspace files best matching query, a fuzzy
+// pattern whose characters must appear in the path in order
+func (app *App) searchFiles(query string) []string {
+	type match struct {
+		path  string
+		score int
+	}
+	var matches []match
+	root := app.Workspace.Dir
+	count := 0
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Skip what cannot be read
+		}
+		if d.IsDir() {
+			if path != root && (strings.HasPrefix(d.Name(), ".") || pickerSkipDirs[d.Name()]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if count++; count > maxPickerFiles {
+			return filepath.SkipAll
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if score, ok := fuzzyScore(rel, query); ok {
+			matches = append(matches, match{rel, score})
+		}
+		return nil
+	})
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		if len(matches[i].path) != len(matches[j].path) {
+			return len(matches[i].path) < len(matches[j].path)
+		}
+		return matches[i].path < matches[j].path
+	})
+	paths := make([]string, 0, min(len(matches), maxPickerMatches))
+	for _, m := range matches[:min(len(matches), maxPickerMatches)] {
+		paths = append(paths, m.path)
+	}
+	return paths
+}
+
+// fuzzyScore reports whether the characters of query appear in path in
+// order, ignoring case, and scores the match: consecutive characters and
+// matches in the file name score higher
+func fuzzyScore(path, query string) (int, bool) {
+	lowerPath, lowerQuery := strings.ToLower(path), strings.ToLower(query)
+	base := strings.LastIndex(lowerPath, "/") + 1
+	score, last := 0, -2
+	pos := 0
+	for _, r := range lowerQuery {
+		i := strings.IndexRune(lowerPath[pos:], r)
+		if i < 0 {
+			return 0, false
+		}
+		i += pos
+		score++
+		if i == last+1 {
+			score += 2
+		}
+		if i >= base {
+			score++
+		}
+		last, pos = i, i+len(string(r))
+	}
+	return score, true
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/sandbox"
+)
+
+// commandWantsInputMsg is the "command_wants_input" event: a running command
+// waits on its stdin, and the user's answer is sent on reply
+type commandWantsInputMsg struct {
+	req   sandbox.InputRequest
+	reply chan commandInputReply
+}
+
+// commandInputDoneMsg withdraws the input request answered on reply, whose
+// command exited before the user answered
+type commandInputDoneMsg struct {
+	reply chan commandInputReply
+}
+
+// commandInputReply is what the user answered a command waiting for input
+type commandInputReply struct {
+	input string
+	ok    bool // false stops the command
+}
+
+// commandInput answers a command waiting on its stdin with the user's input.
+// The request is posted to the UI as a command_wants_input event, and the
+// sandbox waits for the answer the user types in the chat input. Without a
+// running program nobody can answer, so the command is stopped and the model
+// told to pass its input up front.
+func (app *App) commandInput(ctx context.Context, req sandbox.InputRequest) (string, bool) {
+	app.Logger.Log("[AUDIT] App: command_wants_input: '%s' (PID %d) is waiting on stdin", req.Command, req.PID)
+	if app.Program == nil {
+		return "", false
+	}
+
+	reply := make(chan commandInputReply, 1)
+	select {
+	case app.agentMsgChan <- commandWantsInputMsg{req: req, reply: reply}:
+	case <-ctx.Done():
+		return "", false
+	}
+	select {
+	case answer := <-reply:
+		return answer.input, answer.ok
+	case <-ctx.Done():
+		// The command finished while the user was typing
+		go func() { app.agentMsgChan <- commandInputDoneMsg{reply: reply} }()
+		return "", false
+	}
+}
+
+// pendingCommandInput is a command waiting for the input the user types next
+type pendingCommandInput struct {
+	req   sandbox.InputRequest
+	reply chan commandInputReply
+}
+
+// startCommandInput shows a command's input request and lets the next input answer it
+func (app *App) startCommandInput(msg commandWantsInputMsg) {
+	app.pendingCommandInput = &pendingCommandInput{req: msg.req, reply: msg.reply}
+	prompt := fmt.Sprintf("%s is waiting for input.", msg.req.Command)
+	if output := strings.TrimRight(msg.req.Output, "\n"); output != "" {
+		prompt += "\n" + output
+	}
+	app.ChatModel.AddSystemMessage(prompt + "\nType the input and press Enter to send it. An empty line or Esc stops the command; /eof ends its input.")
+	app.ChatModel.AskQuestion(fmt.Sprintf("%s is waiting for input", msg.req.Command))
+	app.ChatModel.ForceUpdateViewport()
+}
+
+// answerCommandInput sends what the user typed to the command waiting for it.
+// An empty line stops the command and /eof closes its stdin.
+func (app *App) answerCommandInput(line string) {
+	pending := app.pendingCommandInput
+	app.pendingCommandInput = nil
+	app.ChatModel.ClearQuestion()
+
+	var answer commandInputReply
+	switch {
+	case strings.TrimSpace(line) == "/eof":
+		app.Logger.Log("User ended the input of '%s'.", pending.req.Command)
+		answer = commandInputReply{ok: true}
+	case strings.TrimSpace(line) == "":
+		app.Logger.Log("User stopped '%s' instead of answering it.", pending.req.Command)
+		app.ChatModel.AddSystemMessage(fmt.Sprintf("Stopping %s.", pending.req.Command))
+	default:
+		app.Logger.Log("User sent %d bytes of input to '%s'.", len(line)+1, pending.req.Command)
+		answer = commandInputReply{input: line + "\n", ok: true}
+	}
+	pending.reply <- answer
+}
+
+// endCommandInput withdraws the input request answered on reply, if it is
+// still shown
+func (app *App) endCommandInput(reply chan commandInputReply) {
+	if app.pendingCommandInput == nil || app.pendingCommandInput.reply != reply {
+		return
+	}
+	app.Logger.Log("'%s' exited before the user answered it.", app.pendingCommandInput.req.Command)
+	app.pendingCommandInput = nil
+	app.ChatModel.ClearQuestion()
+}
+
+// stdinData extracts the stdin_data argument of a shell tool call
+func stdinData(args string) string {
+	var params struct {
+		StdinData string `json:"stdin_data"`
+	}
+	json.Unmarshal([]byte(args), &params)
+	return params.StdinData
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/doctor"
+	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/spf13/cobra"
+)
+
+// doctorCmd creates the command that diagnoses the local setup
+func doctorCmd() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the configuration, API connection and local tooling",
+		Long: `Run self-diagnostics and print how to fix anything that is wrong.
+
+Checks, in order:
+  - config: the configuration parses and an API key resolves
+  - api reachability: the base URL answers at all
+  - api models: the API key is accepted and the configured model is listed
+  - streaming: a one-token streamed completion arrives as server-sent events,
+    which buffering proxies can break
+  - git: git is installed and the working directory is inside a repository
+  - sandbox: which sandbox commands run in on this platform
+  - session directory: sessions can be written
+  - terminal: truecolor and alternate screen support
+
+The command exits with status 1 when any check fails.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runDoctor(asJSON)
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print the results as JSON")
+
+	return cmd
+}
+
+// runDoctor implements the doctor command
+func runDoctor(asJSON bool) {
+	appLogger = logging.NewNilLogger()
+
+	isTerminal := false
+	if info, err := os.Stdout.Stat(); err == nil {
+		isTerminal = info.Mode()&os.ModeCharDevice != 0
+	}
+	results := doctor.Run(context.Background(), doctor.Options{
+		LoadConfig: config.Load,
+		Getenv:     os.Getenv,
+		IsTerminal: isTerminal,
+		RunGit:     doctor.DefaultGitRunner,
+	})
+
+	if asJSON {
+		if err := doctor.WriteJSON(os.Stdout, results); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing results: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		doctor.WriteText(os.Stdout, results)
+	}
+	if doctor.Failed(results) {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/ui"
+)
+
+// flaggedOutputPreview caps how much of a flagged output is shown for review
+const flaggedOutputPreview = 2000
+
+// flaggedOutputMsg asks the user to review a flagged tool output; the
+// decision is sent on reply
+type flaggedOutputMsg struct {
+	result  agent.ToolResult
+	reasons []string
+	reply   chan bool
+}
+
+// approveFlaggedOutput asks the user whether a tool output that looks like a
+// prompt injection may be sent to the model, under the strict
+// tool_output_guard. The output is shown in the approval dialog, and the
+// agent waits for the decision. Without a running program nobody can
+// approve, so it is withheld.
+func (app *App) approveFlaggedOutput(ctx context.Context, result agent.ToolResult, reasons []string) bool {
+	app.Logger.Log("[AUDIT] App: tool_output_flagged: output of %s (call %s): %s", result.Name, result.CallID, strings.Join(reasons, "; "))
+	if app.Program == nil {
+		return false
+	}
+
+	reply := make(chan bool, 1)
+	select {
+	case app.agentMsgChan <- flaggedOutputMsg{result: result, reasons: reasons, reply: reply}:
+	case <-ctx.Done():
+		return false
+	}
+	select {
+	case approved := <-reply:
+		if approved {
+			app.Logger.Log("[AUDIT] App: User approved the flagged output of call %s.", result.CallID)
+		} else {
+			app.Logger.Log("[AUDIT] App: User withheld the flagged output of call %s.", result.CallID)
+		}
+		return approved
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// askFlaggedOutputApproval shows a flagged output in the approval dialog
+func (app *App) askFlaggedOutputApproval(msg flaggedOutputMsg) {
+	output := msg.result.Output
+	if !msg.result.Success {
+		output = msg.result.Error
+	}
+	if len(output) > flaggedOutputPreview {
+		output = output[:flaggedOutputPreview] + fmt.Sprintf("\n... (%d more bytes)", len(output)-flaggedOutputPreview)
+	}
+
+	app.reviewingFlaggedOutput = &msg
+	app.approvalModel = ui.NewApprovalModel(
+		"Approve Flagged Tool Output",
+		fmt.Sprintf("The output of %s may contain a prompt injection: it %s. Send it to the assistant?", msg.result.Name, strings.Join(msg.reasons, "; ")),
+		output)
+	app.isAwaitingApproval = true
+	app.ChatModel.SetThinkingStatus(fmt.Sprintf("Awaiting approval of the output of %s...", msg.result.Name))
+	app.ChatModel.ForceUpdateViewport()
+}
+
+// finishFlaggedOutputApproval sends the user's decision on the flagged output under review
+func (app *App) finishFlaggedOutputApproval(approved bool) {
+	msg := app.reviewingFlaggedOutput
+	app.reviewingFlaggedOutput = nil
+	if !approved {
+		app.ChatModel.AddSystemMessage(fmt.Sprintf("The output of %s was withheld from the assistant.", msg.result.Name))
+	}
+	app.ChatModel.SetThinkingStatus("Function executed, waiting for assistant response...")
+	msg.reply <- approved
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/blobstore"
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/epuerta/codex-go/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+// gcCmd creates the command that removes unreferenced blobs
+func gcCmd() *cobra.Command {
+	var grace time.Duration
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove stored blobs no saved session references",
+		Long: `Garbage-collect the project's blob store (.codex/blobs), which holds full
+tool outputs, file snapshots taken before edits, the content of long session
+messages and the files of workspace snapshots taken outside git.
+
+Every blob records the sessions referencing it. References from sessions no
+longer saved in session_dir are dropped, and blobs left without references
+are removed; workspace snapshots keep their files. Blobs written within
+--grace are kept, as a running session may not have saved the session that
+references them yet.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runGC(grace)
+		},
+	}
+	cmd.Flags().DurationVar(&grace, "grace", blobstore.DefaultGracePeriod, "Keep blobs written more recently than this")
+
+	return cmd
+}
+
+// runGC implements the gc command
+func runGC(grace time.Duration) {
+	appLogger = logging.NewNilLogger()
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.SessionDir == "" {
+		fmt.Fprintln(os.Stderr, "Error: sessions are not saved (session_dir is empty), so every blob would look unreferenced")
+		os.Exit(1)
+	}
+	sessions, err := agent.SavedSessions(cfg.SessionDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	snapshots, err := newSnapshotManager(cfg).List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	retained := make(map[string]bool, len(sessions)+len(snapshots))
+	for owner := range sessions {
+		retained[owner] = true
+	}
+	for _, snap := range snapshots {
+		retained[snapshot.Owner(snap.ID)] = true
+	}
+
+	dir := blobstore.DirFor(cfg.ToolDir())
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		fmt.Printf("No blob store in %s\n", dir)
+		return
+	}
+	stats, err := blobstore.Open(dir, cfg.BlobCompression).GC(func(owner string) bool { return retained[owner] }, grace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Removed %d blobs (%d bytes) from %s; %d kept\n", stats.Blobs, stats.Bytes, dir, stats.Kept)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/epuerta/codex-go/internal/sessionimport"
+	"github.com/spf13/cobra"
+)
+
+// importCmd creates the import command converting sessions from other tools
+func importCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Import sessions from the original codex CLI or a ChatGPT export",
+		Long: `Convert saved conversations into sessions that can be continued here.
+
+Supported files:
+  - A rollout JSON saved by the TypeScript codex CLI (~/.codex/sessions/rollout-*.json)
+  - conversations.json from a ChatGPT data export; every conversation in it
+    becomes its own session
+
+Roles, tool calls and timestamps are carried over where they have an
+equivalent. Messages that could not be fully converted (attachments, tools
+that do not exist here) are flagged in the session's import metadata, and
+parts of the file the importer does not know are skipped with a warning.
+
+Continue an imported session with: codex --recover <session-id>`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runImport(args[0])
+		},
+	}
+
+	return cmd
+}
+
+// runImport implements the import command
+func runImport(path string) {
+	appLogger = logging.NewNilLogger()
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.SessionDir == "" {
+		fmt.Fprintln(os.Stderr, "Error: no session directory is configured")
+		os.Exit(1)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	sessions, err := sessionimport.Import(data, path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error importing %s: %v\n", path, err)
+		os.Exit(1)
+	}
+
+	for _, session := range sessions {
+		history := session.History
+		if err := agent.SaveSession(cfg.SessionDir, history); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving session: %v\n", err)
+			os.Exit(1)
+		}
+
+		title := history.Import.Title
+		if title == "" {
+			title = history.Import.SourceID
+		}
+		fmt.Printf("Imported %q as session %s (%d messages", title, history.CurrentSession, len(history.Messages))
+		if flagged := history.Import.Flagged(); flagged > 0 {
+			fmt.Printf(", %d not fully converted", flagged)
+		}
+		fmt.Println(")")
+		for _, warning := range session.Warnings {
+			fmt.Fprintf(os.Stderr, "  warning: %s\n", warning)
+		}
+	}
+	if len(sessions) == 0 {
+		fmt.Println("No conversations found.")
+		return
+	}
+	fmt.Println("Continue a session with: codex --recover <session-id>")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/epuerta/codex-go/internal/codeindex"
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/spf13/cobra"
+)
+
+// indexCmd creates the command that builds the semantic search index
+func indexCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "index",
+		Short: "Build or update the embeddings index for semantic code search",
+		Long: `Walk the workspace, split source files into chunks (Go files by
+declaration, other files by overlapping windows of lines), embed them with the
+provider's embeddings endpoint and store the vectors in .codex/index.json.
+
+Only new and changed files are embedded again; unchanged files keep their
+vectors and deleted files are dropped. Changing embedding_model re-embeds
+everything.
+
+Embedding costs money, so this needs semantic_search enabled in the config.
+The semantic_search tool uses the index, and falls back to a keyword search
+when there is none.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runIndex()
+		},
+	}
+}
+
+// runIndex implements the index command
+func runIndex() {
+	appLogger = logging.NewNilLogger()
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if !cfg.SemanticSearch {
+		fmt.Fprintln(os.Stderr, "Error: semantic search is disabled (set semantic_search: true to index the workspace)")
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	path := codeindex.PathFor(cfg.ToolDir())
+	prev, err := codeindex.Load(path)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Warning: rebuilding the index: %v\n", err)
+	}
+	progress := func(done, total int) {
+		fmt.Fprintf(os.Stderr, "\rEmbedded %d of %d chunks", done, total)
+		if done == total {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+	idx, stats, err := codeindex.Build(ctx, cfg.ToolDir(), prev, codeindex.NewOpenAIEmbedder(cfg), progress)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		os.Exit(1)
+	}
+	if err := idx.Save(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Indexed %d files (%d chunks) into %s\n", stats.Files, idx.Chunks(), path)
+	fmt.Printf("  embedded %d new or changed files (%d chunks), kept %d unchanged, removed %d\n",
+		stats.Embedded, stats.Chunks, stats.Unchanged, stats.Removed)
+}
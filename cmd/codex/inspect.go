@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/spf13/cobra"
+)
+
+// inspectCmd creates the command that shows what a turn sent to the API
+func inspectCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "inspect <session> [turn]",
+		Short: "Show exactly what a turn of a session sent to the API",
+		Long: `Print the requests a turn of a session sent to the API: the messages, the
+tools offered, the other parameters and the raw streamed response. Without a
+turn, the session's turns are listed.
+
+Every request is indexed next to the session in session_dir, with its message
+count and a hash of its body. Bodies and responses are only kept with
+capture_requests set; for other requests the index entry alone is shown.`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			runInspect(args)
+		},
+	}
+
+	return cmd
+}
+
+// runInspect implements the inspect command
+func runInspect(args []string) {
+	appLogger = logging.NewNilLogger()
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := writeInspection(os.Stdout, cfg.SessionDir, args[0], args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// inspectReport renders /inspect [turn] for the current session
+func (app *App) inspectReport(args []string) string {
+	session, ok := app.Agent.(interface{ SessionID() string })
+	if !ok || app.Config.SessionDir == "" {
+		return "Requests are not indexed for this session (session_dir is empty)."
+	}
+	var b strings.Builder
+	if err := writeInspection(&b, app.Config.SessionDir, session.SessionID(), args); err != nil {
+		return fmt.Sprintf("Failed to inspect: %v", err)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// writeInspection writes the requests of a turn of session id, or lists its
+// turns when args has no turn number
+func writeInspection(w io.Writer, dir, id string, args []string) error {
+	refs, err := agent.LoadRequestIndex(dir, id)
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("no requests are indexed for session %s", id)
+	}
+	if len(args) == 0 {
+		writeTurnList(w, refs)
+		return nil
+	}
+	turn, err := strconv.Atoi(args[0])
+	if err != nil || turn < 1 {
+		return fmt.Errorf("invalid turn %q: expected a positive number", args[0])
+	}
+
+	var requests []agent.RequestRef
+	for _, ref := range refs {
+		if ref.Turn == turn {
+			requests = append(requests, ref)
+		}
+	}
+	if len(requests) == 0 {
+		return fmt.Errorf("session %s has no turn %d (turns 1-%d)", id, turn, refs[len(refs)-1].Turn)
+	}
+	for i, ref := range requests {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "Turn %d, request %d of %d (%s, %s)\n", ref.Turn, ref.Request, len(requests), ref.Time.Format("2006-01-02 15:04:05"), ref.Model)
+		fmt.Fprintf(w, "%d messages, %d tools, sha256 %s\n", ref.Messages, ref.Tools, ref.Hash)
+		capture, err := agent.LoadRequestCapture(dir, ref)
+		if err != nil {
+			fmt.Fprintf(w, "%v\n", err)
+			continue
+		}
+		writeCapture(w, capture)
+	}
+	return nil
+}
+
+// writeTurnList lists the indexed turns of a session, one line each
+func writeTurnList(w io.Writer, refs []agent.RequestRef) {
+	for i := 0; i < len(refs); {
+		first := refs[i]
+		n, captured := 0, 0
+		for ; i < len(refs) && refs[i].Turn == first.Turn; i++ {
+			n++
+			if refs[i].Capture != "" {
+				captured++
+			}
+		}
+		fmt.Fprintf(w, "Turn %d: %s, %d requests, %d captured, %d messages\n", first.Turn, first.Time.Format("2006-01-02 15:04:05"), n, captured, first.Messages)
+	}
+}
+
+// writeCapture writes the parameters, messages, tools and raw response of a captured request
+func writeCapture(w io.Writer, capture *agent.RequestCapture) {
+	req := capture.Request
+
+	// Everything but the messages and tools is a parameter, whatever the request sets
+	var params map[string]json.RawMessage
+	if data, err := json.Marshal(req); err == nil {
+		json.Unmarshal(data, &params)
+	}
+	delete(params, "messages")
+	delete(params, "tools")
+	fmt.Fprintln(w, "\nParameters:")
+	for _, key := range slices.Sorted(maps.Keys(params)) {
+		fmt.Fprintf(w, "  %s: %s\n", key, params[key])
+	}
+
+	fmt.Fprintln(w, "\nMessages:")
+	for i, msg := range req.Messages {
+		role := msg.Role
+		if msg.ToolCallID != "" {
+			role += " (" + msg.ToolCallID + ")"
+		}
+		fmt.Fprintf(w, "  [%d] %s:\n", i+1, role)
+		if msg.Content != "" {
+			fmt.Fprintf(w, "%s\n", indent(msg.Content, "      "))
+		}
+		for _, call := range msg.ToolCalls {
+			fmt.Fprintf(w, "      -> %s %s(%s)\n", call.ID, call.Function.Name, call.Function.Arguments)
+		}
+	}
+
+	fmt.Fprintln(w, "\nTools:")
+	for _, tool := range req.Tools {
+		if tool.Function != nil {
+			fmt.Fprintf(w, "  %s\n", tool.Function.Name)
+		}
+	}
+
+	fmt.Fprintf(w, "\nResponse (%d chunks):\n", len(capture.Response))
+	for _, chunk := range capture.Response {
+		if data, err := json.Marshal(chunk); err == nil {
+			fmt.Fprintf(w, "  %s\n", data)
+		}
+	}
+	if capture.Error != "" {
+		fmt.Fprintf(w, "  (ended early: %s)\n", capture.Error)
+	}
+}
+
+// indent prefixes every line of s
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
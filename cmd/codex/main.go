@@ -7,17 +7,17 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
-	"syscall"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/epuerta/codex-go/internal/agent"
 	"github.com/epuerta/codex-go/internal/config"
 	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/epuerta/codex-go/internal/replay"
+	"github.com/epuerta/codex-go/internal/sandbox"
 	"github.com/epuerta/codex-go/internal/ui"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -35,6 +35,17 @@ var (
 	appLogger logging.Logger
 )
 
+// exitNeedsInput is the exit status of an unattended run stopped by a
+// question nobody could answer (question_policy: fail)
+const exitNeedsInput = 3
+
+// exitRefused is the exit status of an unattended run whose response the
+// model refused or a content filter withheld
+const exitRefused = 4
+
+// maxQuietAnswers is how many questions quiet mode answers before giving up
+const maxQuietAnswers = 3
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "codex [flags] [prompt]",
@@ -66,9 +77,14 @@ func init() {
 	rootCmd.PersistentFlags().Bool("full-stdout", false, "Do not truncate stdout/stderr from command outputs")
 	rootCmd.PersistentFlags().Bool("auto-edit", false, "Automatically approve file edits; still prompt for commands")
 	rootCmd.PersistentFlags().Bool("full-auto", false, "Automatically approve edits and commands when executed in the sandbox")
+	rootCmd.PersistentFlags().Bool("read-only", false, "Never modify files: withhold edit tools and refuse mutating shell commands")
+	rootCmd.PersistentFlags().Bool("no-review", false, "Skip the end-of-turn review of file changes")
 	rootCmd.PersistentFlags().Bool("dangerously-auto-approve-everything", false, "Skip all confirmation prompts and execute commands without sandboxing. EXTREMELY DANGEROUS - use only in ephemeral environments.")
 	rootCmd.PersistentFlags().BoolP("config", "c", false, "Open the instructions file in your editor")
 	rootCmd.PersistentFlags().StringP("view", "v", "", "Inspect a previously saved rollout instead of starting a session")
+	rootCmd.PersistentFlags().String("recover", "", "Recover a session that did not shut down cleanly, by ID or \"latest\"")
+	rootCmd.PersistentFlags().String("record", "", "Record the session's API traffic into a replay bundle at this path (see codex replay)")
+	rootCmd.PersistentFlags().String("choices", "", "JSON file answering ask_user_choice questions in unattended runs, keyed by question hash")
 
 	// Add logging flags
 	rootCmd.PersistentFlags().Bool("debug", false, "Enable debug logging to a file")
@@ -79,6 +95,17 @@ func init() {
 
 	// Add subcommands
 	rootCmd.AddCommand(completionCmd())
+	rootCmd.AddCommand(serveCmd())
+	rootCmd.AddCommand(taskRunCmd())
+	rootCmd.AddCommand(importCmd())
+	rootCmd.AddCommand(doctorCmd())
+	rootCmd.AddCommand(statsCmd())
+	rootCmd.AddCommand(indexCmd())
+	rootCmd.AddCommand(verifyCmd())
+	rootCmd.AddCommand(replayCmd())
+	rootCmd.AddCommand(inspectCmd())
+	rootCmd.AddCommand(gcCmd())
+	rootCmd.AddCommand(snapshotCmd())
 }
 
 // completionCmd creates the completion command for shell completion scripts
@@ -127,8 +154,12 @@ func runCmdImpl(cmd *cobra.Command, args []string) {
 	autoEdit, _ := cmd.Flags().GetBool("auto-edit")
 	fullAuto, _ := cmd.Flags().GetBool("full-auto")
 	dangerouslyAutoApprove, _ := cmd.Flags().GetBool("dangerously-auto-approve-everything")
+	readOnly, _ := cmd.Flags().GetBool("read-only")
+	noReview, _ := cmd.Flags().GetBool("no-review")
 	configFlag, _ := cmd.Flags().GetBool("config")
 	viewRollout, _ := cmd.Flags().GetString("view")
+	recoverFlag, _ := cmd.Flags().GetString("recover")
+	recordPath, _ := cmd.Flags().GetString("record")
 	images, _ := cmd.Flags().GetStringArray("image")
 	// Get logging flags
 	debugFlag, _ := cmd.Flags().GetBool("debug")
@@ -227,6 +258,20 @@ func runCmdImpl(cmd *cobra.Command, args []string) {
 	// Set full stdout option
 	cfg.FullStdout = fullStdout
 
+	if err := addChoiceAnswers(cmd, cfg); err != nil {
+		appLogger.Log("Error loading choices: %v", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Read-only can be enabled by flag or config, but not disabled by the flag's default
+	if readOnly {
+		cfg.ReadOnly = true
+	}
+	if noReview {
+		cfg.NoReview = true
+	}
+
 	// Override project doc settings
 	if noProjectDoc {
 		cfg.DisableProjectDoc = true
@@ -237,6 +282,25 @@ func runCmdImpl(cmd *cobra.Command, args []string) {
 
 	appLogger.Log("Config loaded: Model=%s, ApprovalMode=%s, CWD=%s", cfg.Model, cfg.ApprovalMode, cfg.CWD)
 
+	// Keep other codex processes out of the project while this one works in it
+	projectLock, err := acquireProjectLock(cfg, !quiet)
+	if err != nil {
+		appLogger.Log("Error locking the project: %v", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if projectLock != nil {
+		defer projectLock.Release()
+	}
+
+	// Offer to recover sessions left behind by a crash
+	recoverID, err := chooseRecoverySession(cfg, recoverFlag, !quiet)
+	if err != nil {
+		appLogger.Log("Error choosing a session to recover: %v", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Create agent
 	ai, err := agent.NewOpenAIAgent(cfg, appLogger)
 	if err != nil {
@@ -245,6 +309,19 @@ func runCmdImpl(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 	defer ai.Close()
+	if projectLock != nil {
+		ai.SetWriteLock(projectLock)
+	}
+
+	// Record the session for codex replay
+	if recordPath != "" {
+		recorder := replay.Record(ai, recordPath, cfg.Model)
+		defer func() {
+			if err := recorder.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing replay bundle: %v\n", err)
+			}
+		}()
+	}
 
 	// Get prompt from args
 	var prompt string
@@ -260,12 +337,20 @@ func runCmdImpl(cmd *cobra.Command, args []string) {
 			os.Exit(1)
 		}
 
+		if recoverID != "" {
+			if err := ai.RecoverSession(recoverID); err != nil {
+				appLogger.Log("Error recovering session: %v", err)
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
 		runQuietMode(ai, prompt, cfg)
 		return
 	}
 
 	// Run interactive mode
-	runInteractiveMode(ai, prompt, cfg, images)
+	runInteractiveMode(ai, prompt, cfg, images, recoverID)
 }
 
 // runQuietMode runs the agent in quiet mode with a prompt
@@ -276,14 +361,31 @@ func runQuietMode(ai *agent.OpenAIAgent, prompt string, cfg *config.Config) {
 	defer cancel()
 
 	// Handle OS signals for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	shutdown := newShutdownCoordinator(appLogger)
+	defer shutdown.Stop()
+	turnDone := make(chan struct{})
 	go func() {
-		<-sigChan
-		appLogger.Log("Cancellation signal received.") // Use logger
-		fmt.Println("\nCancelling...")
-		cancel()
-		ai.Cancel()
+		sig := <-shutdown.Signals()
+		shutdown.Shutdown(sig, func() {
+			cancel()
+			ai.Cancel()
+			if killed := sandbox.TerminateAll(toolShutdownGrace); killed > 0 {
+				appLogger.Log("Killed %d tool process groups that ignored SIGTERM", killed)
+			}
+
+			// Let the cancelled stream finish recording before saving
+			select {
+			case <-turnDone:
+			case <-time.After(toolShutdownGrace):
+				appLogger.Log("Timeout waiting for the cancelled turn to finish.")
+			}
+			ai.GetHistory().MarkInterrupted()
+			if err := ai.Close(); err != nil {
+				appLogger.Log("Error closing agent: %v", err)
+			}
+			appLogger.Log("Quiet mode interrupted.")
+			os.Exit(130)
+		})
 	}()
 
 	// Create messages including system prompt
@@ -295,6 +397,9 @@ func runQuietMode(ai *agent.OpenAIAgent, prompt string, cfg *config.Config) {
 
 	// Send message and collect response
 	var finalResponse string
+	var question string                  // Question the model is waiting on
+	var questionCall *agent.FunctionCall // Its ask_user call, nil when the reply asked it
+	var refusal *agent.Refusal           // Why the last response was refused, if it was
 
 	handler := func(itemJSON string) {
 		appLogger.Log("Quiet mode received item: %s", itemJSON) // Use logger
@@ -306,20 +411,84 @@ func runQuietMode(ai *agent.OpenAIAgent, prompt string, cfg *config.Config) {
 			return
 		}
 
-		if item.Type == "message" && item.Message != nil && item.Message.Role == "assistant" {
+		if item.Type == "message" && item.Message != nil && item.Message.Role == "assistant" && item.Choice == 0 {
 			// Content in each item is the full message so far.
 			finalResponse = item.Message.Content
 		}
+		if item.Type == "user_input_required" && item.Message != nil {
+			question, questionCall = item.Message.Content, item.FunctionCall
+		}
+		if item.Type == "refusal" && item.Refusal != nil {
+			refusal = item.Refusal
+		}
 		// We don't print streamed parts in quiet mode, just collect the final full message.
 	}
 
+	// A full-auto run can be rolled back with codex snapshot restore
+	if cfg.AutoSnapshotEnabled() {
+		if snap, err := newSnapshotManager(cfg).Create(turnSnapshotLabel(prompt)); err != nil {
+			appLogger.Log("Failed to snapshot the workspace before the run: %v", err)
+			fmt.Fprintf(os.Stderr, "Warning: no snapshot of the workspace was taken: %v\n", err)
+		} else {
+			appLogger.Log("Snapshot %s (%s) taken before the run.", snap.ID, snap.Kind)
+		}
+	}
+
+	// Questions are answered from the config; without an answer the run stops
 	_, err := ai.SendMessage(ctx, messages, handler)
+	for answered := 0; err == nil && question != ""; answered++ {
+		// ask_user_choice is answered from the choices file, or fails with an error the model sees
+		if call := questionCall; call != nil && call.Name == agent.AskUserChoiceToolName && answered < maxQuietAnswers {
+			question, questionCall = "", nil
+			answer, choiceErr := agent.AnswerChoice(cfg.ChoiceAnswers, call.Arguments)
+			if choiceErr != nil {
+				appLogger.Log("Quiet mode cannot answer %s: %v", call.Name, choiceErr)
+				answer = choiceErr.Error()
+			}
+			err = ai.SendToolResult(ctx, agent.NewToolResult(call.ID, call.Name, answer, choiceErr == nil))
+			continue
+		}
+		answer, ok := agent.AnswerQuestion(cfg, question)
+		if !ok || answered >= maxQuietAnswers {
+			close(turnDone)
+			if finalResponse != "" {
+				fmt.Println(finalResponse)
+			}
+			appLogger.Log("Quiet mode stopped at an unanswered question: %q", question)
+			fmt.Fprintf(os.Stderr, "Error: %v: %q\n", agent.ErrUserInputRequired, question)
+			ai.Close()
+			os.Exit(exitNeedsInput)
+		}
+		appLogger.Log("Quiet mode answering %q with %q", question, answer)
+		call := questionCall
+		question, questionCall = "", nil
+		if call != nil {
+			err = ai.SendToolResult(ctx, agent.NewToolResult(call.ID, call.Name, answer, true))
+		} else {
+			_, err = ai.SendMessage(ctx, []agent.Message{{Role: "user", Content: answer}}, handler)
+		}
+	}
+	close(turnDone)
+	if ctx.Err() != nil {
+		// A shutdown signal cancelled the turn; the shutdown handler saves and exits
+		select {}
+	}
 	if err != nil {
 		appLogger.Log("Error sending message in quiet mode: %v", err) // Use logger
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
+	if refusal != nil {
+		if finalResponse != "" {
+			fmt.Println(finalResponse)
+		}
+		appLogger.Log("Quiet mode stopped at a refused response (category %s).", refusal.Category)
+		fmt.Fprintf(os.Stderr, "Error: %v (%s): %s\n", agent.ErrRefused, refusal.Category, refusal.Message())
+		ai.Close()
+		os.Exit(exitRefused)
+	}
+
 	// Print final response after the stream completes
 	fmt.Println(finalResponse)
 	appLogger.Log("Quiet mode finished.") // Use logger
@@ -434,7 +603,7 @@ func viewSavedRollout(rolloutPath string) {
 }
 
 // runInteractiveMode runs the agent in interactive mode
-func runInteractiveMode(ai *agent.OpenAIAgent, initialPrompt string, cfg *config.Config, images []string) {
+func runInteractiveMode(ai *agent.OpenAIAgent, initialPrompt string, cfg *config.Config, images []string, recoverID string) {
 	appLogger.Log("Starting interactive mode...")
 
 	// Create the main application model, passing the logger
@@ -445,11 +614,21 @@ func runInteractiveMode(ai *agent.OpenAIAgent, initialPrompt string, cfg *config
 		os.Exit(1)
 	}
 
+	// Continue a crashed session if one was chosen
+	if recoverID != "" {
+		if err := app.RecoverSession(recoverID); err != nil {
+			appLogger.Log("Error recovering session %s: %v", recoverID, err)
+			fmt.Fprintf(os.Stderr, "Error recovering session: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Handle images if provided
 	// ... (image handling logic - needs logger integration if errors occur)
 
 	// Create Bubble Tea program
 	p := tea.NewProgram(app, tea.WithAltScreen(), tea.WithMouseCellMotion())
+	app.Program = p
 
 	// Start the program
 	app.IsRunning = true
@@ -473,37 +652,29 @@ func runInteractiveMode(ai *agent.OpenAIAgent, initialPrompt string, cfg *config
 	}
 
 	// Handle graceful shutdown on signals
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	shutdown := newShutdownCoordinator(appLogger)
+	defer shutdown.Stop()
 
 	// Wait for either signal or program exit
 	select {
-	case <-sigChan:
-		appLogger.Log("Shutdown signal received.")
-		fmt.Println("\nShutting down...")
-
-		// Cancel the agent operations
-		if ai != nil {
-			appLogger.Log("Cancelling agent operations...")
-			ai.Cancel()
-		}
-
-		// Call Close on the App to clean up resources
-		appLogger.Log("Closing app resources...")
-		if err := app.Close(); err != nil {
-			appLogger.Log("Error closing app: %v", err)
-		}
+	case sig := <-shutdown.Signals():
+		shutdown.Shutdown(sig, func() {
+			// Cancel the turn, stop tool processes and save the session
+			if err := app.Shutdown(toolShutdownGrace); err != nil {
+				appLogger.Log("Error shutting down app: %v", err)
+			}
 
-		// Exit Bubble Tea
-		p.Quit()
+			// Exit Bubble Tea
+			p.Quit()
 
-		// Give a timeout for graceful shutdown
-		select {
-		case <-programDone:
-			appLogger.Log("Program exited gracefully after shutdown signal.")
-		case <-time.After(1 * time.Second):
-			appLogger.Log("Timeout waiting for program to exit. Forcing shutdown.")
-		}
+			// Give a timeout for graceful shutdown
+			select {
+			case <-programDone:
+				appLogger.Log("Program exited gracefully after shutdown signal.")
+			case <-time.After(1 * time.Second):
+				appLogger.Log("Timeout waiting for program to exit. Forcing shutdown.")
+			}
+		})
 
 	case <-programDone:
 		appLogger.Log("Bubble Tea program exited normally.")
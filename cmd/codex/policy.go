@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/functions"
+	"github.com/epuerta/codex-go/internal/policy"
+)
+
+// policyDecision evaluates a tool call against the approval policy. It
+// returns false when no rule or default applies.
+func (app *App) policyDecision(call *agent.FunctionCall) (policy.Match, bool) {
+	match, ok := app.Policy.Evaluate(functions.PolicyRequest(app.Workspace, call.Name, call.Arguments))
+	if ok {
+		app.Logger.Log("Policy: %s %s -> %s", call.Name, call.ID, match.Explain())
+	}
+	return match, ok
+}
+
+// requiresApproval decides whether a tool call must be approved: by the
+// approval policy when a rule or default applies, otherwise by the approval
+// mode and the workspace checks. A "prompt" decision is ignored in dangerous mode.
+func (app *App) requiresApproval(call *agent.FunctionCall) bool {
+	if _, requiresApproval, ok := functions.PolicyApproval(app.Policy, app.Config.ApprovalMode, app.Workspace, call.Name, call.Arguments); ok {
+		return requiresApproval
+	}
+	return app.needsApprovalForFunction(call.Name) || app.commandWritesOutsideWorkspace(call)
+}
+
+// handlePolicyCommand implements /policy: listing the rules in evaluation
+// order, or explaining the decision for a shell command
+func (app *App) handlePolicyCommand(args []string) string {
+	if len(args) == 0 || args[0] == "list" {
+		rules := app.Policy.Rules()
+		if app.Policy.Empty() {
+			return fmt.Sprintf("No approval policy; decisions follow the %s approval mode.\nPolicy files: %s, %s", app.Config.ApprovalMode, policy.GlobalPath(), policy.ProjectPath(app.Config.ToolDir()))
+		}
+		var b strings.Builder
+		b.WriteString("Approval policy rules, in evaluation order:")
+		for i, rule := range rules {
+			fmt.Fprintf(&b, "\n  %d. %s", i+1, rule)
+		}
+		return b.String()
+	}
+
+	switch args[0] {
+	case "explain":
+		if len(args) < 2 {
+			return "Usage: /policy explain <command>"
+		}
+		command := strings.Join(args[1:], " ")
+		match, ok := app.Policy.Evaluate(policy.Request{Tool: "execute_command", Command: command})
+		if !ok {
+			approval := "runs without approval"
+			if app.needsApprovalForFunction("execute_command") {
+				approval = "needs approval"
+			}
+			return fmt.Sprintf("No rule matches %q; in %s mode it %s.", command, app.Config.ApprovalMode, approval)
+		}
+		return fmt.Sprintf("%q: %s", command, match.Explain())
+	default:
+		return fmt.Sprintf("Unknown policy command: %s (expected list or explain)", args[0])
+	}
+}
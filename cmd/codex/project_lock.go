@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/projectlock"
+)
+
+// acquireProjectLock takes the lock of the project the tools work in, unless the
+// project is opened read-only. When another live codex process holds it, the
+// user chooses to steal it once that process has exited, open the project
+// read-only (setting cfg.ReadOnly) or exit; without a terminal to ask on, the
+// project is not opened. It returns nil when no lock is held.
+func acquireProjectLock(cfg *config.Config, interactive bool) (*projectlock.Lock, error) {
+	if cfg.ReadOnly {
+		return nil, nil
+	}
+	lock, err := projectlock.Acquire(cfg.ToolDir())
+	var held *projectlock.HeldError
+	if !errors.As(err, &held) {
+		return lock, err
+	}
+	if !interactive || !isTerminal(os.Stdin) {
+		return nil, fmt.Errorf("%w (pass --read-only to open it anyway)", held)
+	}
+	return promptProjectLock(cfg, held, os.Stdin, os.Stdout)
+}
+
+// promptProjectLock asks what to do about a project locked by another process
+func promptProjectLock(cfg *config.Config, held *projectlock.HeldError, in io.Reader, out io.Writer) (*projectlock.Lock, error) {
+	reader := bufio.NewReader(in)
+	fmt.Fprintf(out, "The project is locked: %v.\n", held)
+	fmt.Fprintln(out, "  s) Steal the lock, once the other process has exited")
+	fmt.Fprintln(out, "  r) Open the project read-only")
+	fmt.Fprintln(out, "  e) Exit")
+	fmt.Fprint(out, "Choice [s/r/E]: ")
+	line, _ := reader.ReadString('\n')
+
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "s":
+		// A lock is only stolen from a holder that is no longer running
+		lock, err := projectlock.Steal(cfg.ToolDir())
+		if errors.As(err, &held) {
+			return nil, fmt.Errorf("process %d is still running; the lock can be stolen once it exits (or pass --read-only)", held.Holder.PID)
+		}
+		return lock, err
+	case "r":
+		cfg.ReadOnly = true
+		return nil, nil
+	default:
+		return nil, errors.New("the project is locked by another codex process")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+// recoverLatest is the --recover value that selects the most recent crashed session
+const recoverLatest = "latest"
+
+// chooseRecoverySession resolves which crashed session to recover. An explicit
+// --recover value is used as is ("latest" picks the most recent session);
+// otherwise, when stdin is a terminal, the user is offered the sessions left
+// behind by a crash. It returns "" when nothing should be recovered.
+func chooseRecoverySession(cfg *config.Config, flagValue string, interactive bool) (string, error) {
+	if !cfg.AutosaveEnabled() {
+		if flagValue != "" {
+			return "", fmt.Errorf("cannot recover a session: autosave is disabled")
+		}
+		return "", nil
+	}
+	if flagValue != "" && flagValue != recoverLatest {
+		return flagValue, nil
+	}
+
+	sessions, err := agent.FindRecoverableSessions(cfg.SessionDir)
+	if err != nil {
+		return "", err
+	}
+	if flagValue == recoverLatest {
+		if len(sessions) == 0 {
+			return "", fmt.Errorf("no session to recover in %s", cfg.SessionDir)
+		}
+		return sessions[0].ID, nil
+	}
+	if len(sessions) == 0 || !interactive || !isTerminal(os.Stdin) {
+		return "", nil
+	}
+	return promptRecovery(cfg, sessions, os.Stdin, os.Stdout)
+}
+
+// promptRecovery asks which crashed session to recover. Sessions the user
+// declines are dismissed so they are not offered again.
+func promptRecovery(cfg *config.Config, sessions []agent.RecoverableSession, in io.Reader, out io.Writer) (string, error) {
+	fmt.Fprintln(out, "Found sessions that did not shut down cleanly:")
+	for i, s := range sessions {
+		fmt.Fprintf(out, "  %d) %s  %d messages  %q\n", i+1, s.UpdatedAt.Format("Jan 2 15:04"), s.Messages, s.Preview)
+	}
+	fmt.Fprintf(out, "Recover which session? [1-%d, Enter to start fresh and dismiss them]: ", len(sessions))
+
+	line, _ := bufio.NewReader(in).ReadString('\n')
+	line = strings.TrimSpace(line)
+
+	choice := ""
+	if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(sessions) {
+		choice = sessions[n-1].ID
+	} else if line != "" {
+		fmt.Fprintf(out, "Invalid choice %q; starting a fresh session.\n", line)
+	}
+
+	for _, s := range sessions {
+		if s.ID == choice {
+			continue
+		}
+		if err := agent.DismissSession(cfg.SessionDir, s.ID); err != nil {
+			appLogger.Log("Failed to dismiss session %s: %v", s.ID, err)
+		}
+	}
+	return choice, nil
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
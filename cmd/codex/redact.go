@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/agent"
+)
+
+// historyEditor is implemented by agents whose history messages can be
+// redacted or deleted
+type historyEditor interface {
+	RedactMessage(index int) (agent.Tombstone, error)
+	DeleteMessage(index int) (agent.Tombstone, error)
+}
+
+// handleHistoryEditCommand runs /redact and /delete. Without an index it lists
+// the messages with their indexes.
+func (app *App) handleHistoryEditCommand(action string, args []string) string {
+	editor, ok := app.Agent.(historyEditor)
+	if !ok {
+		return "This agent does not support editing the history."
+	}
+	if len(args) == 0 {
+		return fmt.Sprintf("Usage: /%s <n>\n%s", action, listHistory(app.Agent.GetHistory().GetMessages()))
+	}
+	index, err := strconv.Atoi(args[0])
+	if err != nil || len(args) > 1 {
+		return fmt.Sprintf("Usage: /%s <n>, where n is a message index from /%s", action, action)
+	}
+	if app.isAgentProcessing.Load() {
+		return fmt.Sprintf("Wait for the assistant to finish before using /%s.", action)
+	}
+
+	var tomb agent.Tombstone
+	if action == "redact" {
+		tomb, err = editor.RedactMessage(index)
+	} else {
+		tomb, err = editor.DeleteMessage(index)
+	}
+	if err != nil && !errors.Is(err, agent.ErrDeletedMessageAnswered) {
+		return fmt.Sprintf("Error: %v", err)
+	}
+
+	// Redraw the transcript so the removed content is gone from the screen too
+	app.ChatModel.ClearMessages()
+	app.showMessages(app.Agent.GetHistory().GetMessages())
+
+	verb := "Redacted"
+	if action == "delete" {
+		verb = "Deleted"
+	}
+	result := fmt.Sprintf("%s message %d (%s, %d bytes).", verb, tomb.Index, tomb.Role, tomb.Bytes)
+	if len(tomb.ToolCalls) > 0 {
+		result += fmt.Sprintf(" Its %d tool call(s) and their results went with it.", len(tomb.ToolCalls))
+	}
+	if err != nil {
+		result += " The assistant had already replied to it; that reply is kept."
+	}
+	return result
+}
+
+// historyPinner is implemented by agents whose history messages can be pinned
+type historyPinner interface {
+	PinMessage(index int) error
+	UnpinMessage(index int) error
+}
+
+// handlePinCommand runs /pin and /unpin. Without an index it lists the
+// messages with their indexes.
+func (app *App) handlePinCommand(action string, args []string) string {
+	pinner, ok := app.Agent.(historyPinner)
+	if !ok {
+		return "This agent does not support pinning messages."
+	}
+	if len(args) == 0 {
+		return fmt.Sprintf("Usage: /%s <n>\n%s", action, listHistory(app.Agent.GetHistory().GetMessages()))
+	}
+	index, err := strconv.Atoi(args[0])
+	if err != nil || len(args) > 1 {
+		return fmt.Sprintf("Usage: /%s <n>, where n is a message index from /%s", action, action)
+	}
+
+	if action == "pin" {
+		err = pinner.PinMessage(index)
+	} else {
+		err = pinner.UnpinMessage(index)
+	}
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	if action == "pin" {
+		return fmt.Sprintf("Pinned message %d: it stays in the context however long the conversation gets.", index)
+	}
+	return fmt.Sprintf("Unpinned message %d.", index)
+}
+
+// listHistory shows each message with its index and the start of its
+// content; pinned messages are marked with *
+func listHistory(messages []agent.Message) string {
+	if len(messages) == 0 {
+		return "The history is empty."
+	}
+	var b strings.Builder
+	for i, msg := range messages {
+		preview := msg.Content
+		if len(msg.ToolCalls) > 0 {
+			names := make([]string, len(msg.ToolCalls))
+			for j, call := range msg.ToolCalls {
+				names[j] = call.Function.Name
+			}
+			preview = "calls " + strings.Join(names, ", ")
+		}
+		preview = strings.Join(strings.Fields(preview), " ")
+		if len(preview) > 60 {
+			preview = preview[:57] + "..."
+		}
+		pin := " "
+		if msg.Pinned {
+			pin = "*"
+		}
+		fmt.Fprintf(&b, "  %3d%s %-9s %s\n", i, pin, msg.Role, preview)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/epuerta/codex-go/internal/replay"
+	"github.com/spf13/cobra"
+)
+
+// replayCmd creates the command that plays recorded sessions back
+func replayCmd() *cobra.Command {
+	var update bool
+	cmd := &cobra.Command{
+		Use:   "replay <bundle.json>...",
+		Short: "Play recorded sessions back and report where the behavior changed",
+		Long: `Play replay bundles, recorded with --record, back through the agent. The
+recorded responses are streamed to it instead of calling the API, and the
+recorded user messages and tool results are sent in order; no tool is run.
+
+The requests, response items, tool calls and final history are compared with
+the recording and the first difference in each is listed. The exit code is 1
+when any bundle differs, so bundles of reported problems can guard fixes in
+CI. With --update the bundles are rewritten with the new behavior instead.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runReplay(args, update)
+		},
+	}
+	cmd.Flags().BoolVar(&update, "update", false, "Accept the new behavior: rewrite the bundles with the replayed outcome")
+
+	return cmd
+}
+
+// runReplay implements the replay command
+func runReplay(paths []string, update bool) {
+	appLogger = logging.NewNilLogger()
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	// Nothing reaches the API, and the replay leaves no sessions or usage behind
+	cfg.APIKey = "replay"
+	cfg.UsageLog = ""
+	cfg.AutosaveInterval = -1
+	cfg.WatchInstructions = false
+
+	failed := false
+	for _, path := range paths {
+		diffs, err := replayBundle(cfg, path, update)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+		case update:
+			fmt.Printf("%s: updated\n", path)
+		case len(diffs) == 0:
+			fmt.Printf("%s: OK\n", path)
+		default:
+			failed = true
+			fmt.Printf("%s: %d difference(s)\n", path, len(diffs))
+			for _, diff := range diffs {
+				fmt.Printf("  %s\n", diff)
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// replayBundle plays the bundle at path on a new agent, saving the outcome
+// over it with update
+func replayBundle(base *config.Config, path string, update bool) ([]string, error) {
+	b, err := replay.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := *base
+	if b.Model != "" {
+		cfg.Model = b.Model
+	}
+	ai, err := agent.NewOpenAIAgent(&cfg, appLogger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create agent: %w", err)
+	}
+	defer ai.Close()
+
+	got, diffs, err := replay.Run(context.Background(), ai, b)
+	if err != nil {
+		return nil, err
+	}
+	if update {
+		return nil, got.Save(path)
+	}
+	return diffs, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/fileops"
+	"github.com/epuerta/codex-go/internal/functions"
+	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/epuerta/codex-go/internal/lsp"
+	"github.com/epuerta/codex-go/internal/memory"
+	"github.com/epuerta/codex-go/internal/policy"
+	"github.com/epuerta/codex-go/internal/sandbox"
+	"github.com/epuerta/codex-go/internal/tasks"
+	"github.com/spf13/cobra"
+)
+
+// taskRunCmd creates the run command executing a YAML task file
+func taskRunCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run <task.yaml>",
+		Short: "Run a scripted list of prompts from a YAML task file",
+		Long: `Run the steps of a task file in order against a single session.
+
+Each step sends a prompt and executes the resulting tool calls unattended:
+calls that would need approval under the step's approval mode are denied.
+When a step has a success command, its exit code must be zero for the run
+to continue. Events are written to stdout as JSON lines tagged with the step
+ID; a summary is written to stderr and the exit code is 1 if a step failed.
+Questions the model asks are answered from question_answers, or per
+question_policy; a step stopped by an unanswered question exits with code 3, and
+one whose response was refused or withheld by a content filter with code 4.
+Files a reply gives in full in a code block, instead of writing them, are
+reported as "suggested_file" events and are not written.
+
+Example task file:
+  name: bump-deps
+  approval_mode: full-auto
+  variables:
+    module: github.com/spf13/cobra
+  steps:
+    - id: bump
+      prompt: Upgrade {{.module}} to the latest version.
+      success: go build ./...
+    - id: test
+      prompt: Run the tests and fix any failures.
+      success: go test ./...`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runTask(cmd, args[0])
+		},
+	}
+
+	cmd.Flags().String("from-step", "", "Start at this step ID or 1-based step number, skipping earlier steps")
+	cmd.Flags().StringArray("var", nil, "Set a task variable (name=value); may be repeated")
+
+	return cmd
+}
+
+// runTask implements the run command
+func runTask(cmd *cobra.Command, path string) {
+	fromStep, _ := cmd.Flags().GetString("from-step")
+	vars, _ := cmd.Flags().GetStringArray("var")
+	model, _ := cmd.Flags().GetString("model")
+	approvalModeStr, _ := cmd.Flags().GetString("approval-mode")
+	debugFlag, _ := cmd.Flags().GetBool("debug")
+	logFileFlag, _ := cmd.Flags().GetString("log-file")
+	readOnly, _ := cmd.Flags().GetBool("read-only")
+
+	appLogger = logging.NewNilLogger()
+	if debugFlag && logFileFlag != "" {
+		fileLogger, err := logging.NewFileLogger(logFileFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating file logger: %v\n", err)
+			os.Exit(1)
+		}
+		appLogger = fileLogger
+	}
+	defer appLogger.Close()
+
+	overrides := make(map[string]string)
+	for _, v := range vars {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok || name == "" {
+			fmt.Fprintf(os.Stderr, "Error: invalid --var %q (expected name=value)\n", v)
+			os.Exit(1)
+		}
+		overrides[name] = value
+	}
+	task, err := tasks.Load(path, overrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if task.Name == "" {
+		task.Name = path
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if model != "" {
+		cfg.Model = model
+	}
+	if readOnly {
+		cfg.ReadOnly = true
+	}
+	if err := addChoiceAnswers(cmd, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	switch strings.ToLower(approvalModeStr) {
+	case "auto-edit":
+		cfg.ApprovalMode = config.AutoEdit
+	case "full-auto":
+		cfg.ApprovalMode = config.FullAuto
+	case "dangerous":
+		cfg.ApprovalMode = config.DangerousAutoApprove
+	default:
+		cfg.ApprovalMode = config.Suggest
+	}
+
+	approvalPolicy, err := policy.Load(policy.GlobalPath(), policy.ProjectPath(cfg.ToolDir()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading approval policy: %v\n", err)
+		os.Exit(1)
+	}
+
+	ai, err := agent.NewOpenAIAgent(cfg, appLogger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating agent: %v\n", err)
+		os.Exit(1)
+	}
+
+	var memoryStore *memory.Store
+	if !cfg.DisableMemory {
+		if store, err := memory.OpenProject(cfg); err != nil {
+			appLogger.Log("Failed to open project memory: %v", err)
+		} else {
+			memoryStore = store
+		}
+	}
+	var languageServers *lsp.Manager
+	if !cfg.DisableLanguageServers && lsp.Available(cfg.LanguageServers) {
+		languageServers = lsp.NewManager(cfg.ToolDir(), cfg.LanguageServers)
+	}
+	tools := func(stepConfig *config.Config, journal *fileops.Journal) *functions.Registry {
+		return functions.NewToolRegistry(stepConfig, journal, memoryStore, languageServers, ai.Embedder())
+	}
+
+	// Stop the run and its tool processes on interrupt
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	shutdown := newShutdownCoordinator(appLogger)
+	defer shutdown.Stop()
+	go func() {
+		sig := <-shutdown.Signals()
+		shutdown.Shutdown(sig, func() {
+			cancel()
+			ai.Cancel()
+			if killed := sandbox.TerminateAll(toolShutdownGrace); killed > 0 {
+				appLogger.Log("Killed %d tool process groups that ignored SIGTERM", killed)
+			}
+		})
+	}()
+
+	runner := tasks.NewRunner(ai, cfg, tools, os.Stdout)
+	runner.SetPolicy(approvalPolicy)
+	report, err := runner.Run(ctx, task, fromStep)
+	if closeErr := ai.Close(); closeErr != nil {
+		appLogger.Log("Error closing agent: %v", closeErr)
+	}
+	if languageServers != nil {
+		languageServers.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	report.WriteSummary(os.Stderr)
+	if report.NeedsInput() {
+		os.Exit(exitNeedsInput)
+	}
+	if report.Refused() {
+		os.Exit(exitRefused)
+	}
+	if !report.Passed() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/epuerta/codex-go/internal/policy"
+	"github.com/epuerta/codex-go/internal/server"
+	"github.com/spf13/cobra"
+)
+
+// serveCmd creates the serve command exposing agent sessions over HTTP
+func serveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Expose Codex sessions over an HTTP API",
+		Long: `Run Codex as an HTTP server so editors and other tools can drive it.
+
+Endpoints:
+  POST   /sessions                    Create a session ({"execution": "server"|"client"})
+  POST   /sessions/{id}/messages      Send a user message; streams ResponseItems over SSE
+  POST   /sessions/{id}/tool_results  Return a client-executed tool result; streams the follow-up
+  POST   /sessions/{id}/approvals     Approve or deny a server-executed tool call
+  POST   /sessions/{id}/tool_calls/{call_id}/cancel
+                                      Stop one running server-executed tool call
+  DELETE /sessions/{id}               Cancel and close a session
+
+Requests that stream from a session (messages and tool results) run one at a
+time per session, in the order they arrive, so they never interleave in its
+history; sessions run in parallel. A request for a busy session waits its turn,
+up to --session-queue requests and --session-queue-timeout each; beyond that it
+gets 409 Conflict. Approvals and cancellations answer the running request and
+never wait.
+
+Set --auth-token (or CODEX_SERVE_TOKEN) to require "Authorization: Bearer <token>".`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runServe(cmd)
+		},
+	}
+
+	cmd.Flags().String("addr", "127.0.0.1:8080", "Address to listen on")
+	cmd.Flags().String("auth-token", "", "Bearer token required on every request (default: $CODEX_SERVE_TOKEN)")
+	cmd.Flags().Duration("session-timeout", server.DefaultSessionTimeout, "Close sessions idle for longer than this")
+	cmd.Flags().Int("session-queue", server.DefaultSessionQueueLimit, "Requests that may wait for a busy session (negative rejects them)")
+	cmd.Flags().Duration("session-queue-timeout", server.DefaultSessionQueueTimeout, "How long a request waits for a busy session")
+
+	return cmd
+}
+
+// runServe implements the serve command
+func runServe(cmd *cobra.Command) {
+	addr, _ := cmd.Flags().GetString("addr")
+	authToken, _ := cmd.Flags().GetString("auth-token")
+	sessionTimeout, _ := cmd.Flags().GetDuration("session-timeout")
+	sessionQueue, _ := cmd.Flags().GetInt("session-queue")
+	sessionQueueTimeout, _ := cmd.Flags().GetDuration("session-queue-timeout")
+	model, _ := cmd.Flags().GetString("model")
+	approvalModeStr, _ := cmd.Flags().GetString("approval-mode")
+	debugFlag, _ := cmd.Flags().GetBool("debug")
+	logFileFlag, _ := cmd.Flags().GetString("log-file")
+	readOnly, _ := cmd.Flags().GetBool("read-only")
+	noReview, _ := cmd.Flags().GetBool("no-review")
+
+	if authToken == "" {
+		authToken = os.Getenv("CODEX_SERVE_TOKEN")
+	}
+
+	appLogger = logging.NewNilLogger()
+	if debugFlag && logFileFlag != "" {
+		fileLogger, err := logging.NewFileLogger(logFileFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating file logger: %v\n", err)
+			os.Exit(1)
+		}
+		appLogger = fileLogger
+	}
+	defer appLogger.Close()
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if model != "" {
+		cfg.Model = model
+	}
+	if readOnly {
+		cfg.ReadOnly = true
+	}
+	if noReview {
+		cfg.NoReview = true
+	}
+	switch strings.ToLower(approvalModeStr) {
+	case "auto-edit":
+		cfg.ApprovalMode = config.AutoEdit
+	case "full-auto":
+		cfg.ApprovalMode = config.FullAuto
+	case "dangerous":
+		cfg.ApprovalMode = config.DangerousAutoApprove
+	default:
+		cfg.ApprovalMode = config.Suggest
+	}
+
+	approvalPolicy, err := policy.Load(policy.GlobalPath(), policy.ProjectPath(cfg.ToolDir()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading approval policy: %v\n", err)
+		os.Exit(1)
+	}
+
+	srv := server.New(cfg, appLogger, server.Options{
+		AuthToken:           authToken,
+		SessionTimeout:      sessionTimeout,
+		SessionQueueLimit:   sessionQueue,
+		SessionQueueTimeout: sessionQueueTimeout,
+		Policy:              approvalPolicy,
+	}, nil)
+	defer srv.Close()
+
+	httpServer := &http.Server{
+		Addr:    addr,
+		Handler: srv.Handler(),
+	}
+
+	// Shut down cleanly on interrupt
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(ctx)
+	}()
+
+	if authToken == "" {
+		fmt.Fprintln(os.Stderr, "Warning: no auth token configured; the API is unauthenticated.")
+	}
+	fmt.Fprintf(os.Stderr, "Codex server listening on %s (model: %s, approval: %s)\n", addr, cfg.Model, cfg.ApprovalMode)
+
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/logging"
+)
+
+// toolShutdownGrace is how long running tool processes get to exit after
+// SIGTERM before they are killed
+const toolShutdownGrace = 3 * time.Second
+
+// shutdownCoordinator turns the first SIGINT/SIGTERM into a graceful shutdown
+// and a second one into an immediate exit
+type shutdownCoordinator struct {
+	logger  logging.Logger
+	signals chan os.Signal
+}
+
+// newShutdownCoordinator starts listening for termination signals
+func newShutdownCoordinator(logger logging.Logger) *shutdownCoordinator {
+	c := &shutdownCoordinator{
+		logger:  logger,
+		signals: make(chan os.Signal, 2),
+	}
+	signal.Notify(c.signals, os.Interrupt, syscall.SIGTERM)
+	return c
+}
+
+// Signals delivers the first termination signal
+func (c *shutdownCoordinator) Signals() <-chan os.Signal {
+	return c.signals
+}
+
+// Shutdown runs graceful after the first signal was received. Another signal
+// while graceful runs exits the process immediately.
+func (c *shutdownCoordinator) Shutdown(sig os.Signal, graceful func()) {
+	c.logger.Log("Shutdown signal received: %v", sig)
+	fmt.Fprintln(os.Stderr, "\nShutting down... (press Ctrl+C again to force)")
+
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-c.signals:
+			c.logger.Log("Second signal received (%v), forcing exit.", sig)
+			os.Exit(130)
+		case <-finished:
+		}
+	}()
+
+	graceful()
+	close(finished)
+}
+
+// Stop stops listening for signals
+func (c *shutdownCoordinator) Stop() {
+	signal.Stop(c.signals)
+}
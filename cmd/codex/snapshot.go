@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/blobstore"
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/epuerta/codex-go/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+// snapshotCmd creates the command that takes, lists and restores workspace snapshots
+func snapshotCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Take, list and restore snapshots of the whole workspace",
+		Long: `Checkpoint the whole workspace before letting the agent loose, and roll it
+back in one step.
+
+In a git repository a snapshot is a commit of the working tree (tracked and
+untracked files, not ignored ones) on the hidden ref ` + snapshot.Ref + `.
+It is staged through a temporary index: the branch, the index and the
+working tree are left as they are. Outside git, the workspace's files up to
+snapshot_max_file_size are copied into the blob store (.codex/blobs), and
+larger files are left out.
+
+With auto_snapshot (the default), a snapshot is taken before every turn in
+full-auto mode, and the end-of-turn review restores it with R.`,
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "create [label]",
+		Short: "Snapshot the workspace",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			label := "manual snapshot"
+			if len(args) > 0 {
+				label = args[0]
+			}
+			runSnapshotCreate(label)
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the snapshots, newest first",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runSnapshotList()
+		},
+	})
+
+	var includeNew bool
+	restore := &cobra.Command{
+		Use:   "restore [id]",
+		Short: "Restore a snapshot, the latest one by default",
+		Long: `Bring the workspace back to a snapshot: its files are written back, including
+those deleted since. The ID may be shortened to any unique prefix.
+
+Files created since the snapshot would be deleted, so the restore is refused
+when there are any, listing them, unless --include-new is given.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			id := ""
+			if len(args) > 0 {
+				id = args[0]
+			}
+			runSnapshotRestore(id, includeNew)
+		},
+	}
+	restore.Flags().BoolVar(&includeNew, "include-new", false, "Delete the files created since the snapshot")
+	cmd.AddCommand(restore)
+
+	return cmd
+}
+
+// openSnapshots loads the configuration and opens the workspace's snapshots
+func openSnapshots() *snapshot.Manager {
+	appLogger = logging.NewNilLogger()
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	return newSnapshotManager(cfg)
+}
+
+// newSnapshotManager opens the snapshots of the workspace cfg's tools work
+// in, its working directory, keeping their files in the project's blob store
+func newSnapshotManager(cfg *config.Config) *snapshot.Manager {
+	blobs := blobstore.Open(blobstore.DirFor(cfg.ToolDir()), cfg.BlobCompression)
+	return snapshot.Open(cfg.ToolDir(), blobs, cfg.SnapshotMaxFileSize)
+}
+
+// runSnapshotCreate implements snapshot create
+func runSnapshotCreate(label string) {
+	snap, err := openSnapshots().Create(label)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Created snapshot %s (%s)\n", snap.ID, snap.Kind)
+	if len(snap.Skipped) > 0 {
+		fmt.Printf("  left out %d files larger than snapshot_max_file_size: %s\n", len(snap.Skipped), strings.Join(snap.Skipped, ", "))
+	}
+}
+
+// runSnapshotList implements snapshot list
+func runSnapshotList() {
+	snapshots, err := openSnapshots().List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(snapshots) == 0 {
+		fmt.Println("No snapshots")
+		return
+	}
+	for _, snap := range snapshots {
+		fmt.Printf("%s  %s  %s\n", snap.ID, snap.CreatedAt.Local().Format(time.DateTime), snap.Label)
+	}
+}
+
+// runSnapshotRestore implements snapshot restore
+func runSnapshotRestore(id string, includeNew bool) {
+	result, err := openSnapshots().Restore(id, snapshot.RestoreOptions{IncludeNew: includeNew})
+	var newFiles *snapshot.NewFilesError
+	if errors.As(err, &newFiles) {
+		fmt.Fprintf(os.Stderr, "Error: restoring snapshot %s would delete these files, created since it was taken:\n", newFiles.ID)
+		for _, path := range newFiles.Paths {
+			fmt.Fprintf(os.Stderr, "  %s\n", path)
+		}
+		fmt.Fprintln(os.Stderr, "Move them away, or run again with --include-new to delete them.")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Restored snapshot %s (%s): %d files\n", result.Snapshot.ID, result.Snapshot.Label, result.Restored)
+	if len(result.Deleted) > 0 {
+		fmt.Printf("  deleted %d new files: %s\n", len(result.Deleted), strings.Join(result.Deleted, ", "))
+	}
+}
+
+// snapshotLabelLength caps how much of the prompt labels the snapshot of its turn
+const snapshotLabelLength = 60
+
+// turnSnapshotLabel labels the snapshot taken before the turn prompt starts
+func turnSnapshotLabel(prompt string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(prompt), "\n")
+	if runes := []rune(line); len(runes) > snapshotLabelLength {
+		line = string(runes[:snapshotLabelLength]) + "..."
+	}
+	return "Before: " + line
+}
+
+// snapshotTurn snapshots the workspace before a turn, with auto_snapshot in
+// full-auto mode, so the end-of-turn review can roll the turn back. A failed
+// snapshot is reported as a warning and the turn goes on.
+func (app *App) snapshotTurn(prompt string, handler agent.ResponseHandler) {
+	app.turnSnapshot = ""
+	if !app.Config.AutoSnapshotEnabled() {
+		return
+	}
+	snap, err := app.Snapshots.Create(turnSnapshotLabel(prompt))
+	if err != nil {
+		app.Logger.Log("ERROR: Failed to snapshot the workspace before the turn: %v", err)
+		data, _ := json.Marshal(agent.ResponseItem{
+			Type:    agent.EventWarning,
+			Message: &agent.Message{Role: "system", Content: fmt.Sprintf("No snapshot of the workspace was taken before this turn: %v", err)},
+		})
+		handler(string(data))
+		return
+	}
+	app.Logger.Log("Snapshot %s (%s) taken before the turn.", snap.ID, snap.Kind)
+	app.turnSnapshot = snap.ID
+}
+
+// restoreTurnSnapshot answers R in the end-of-turn review: the workspace goes
+// back to the snapshot taken before the turn. Files the turn itself created
+// are deleted; any other new file makes the restore refuse, and the review
+// goes on without the option.
+func (app *App) restoreTurnSnapshot() {
+	var created []string
+	for _, change := range app.Journal.Changes() {
+		if change.Status == "created" {
+			created = append(created, change.Path)
+		}
+	}
+
+	result, err := app.Snapshots.Restore(app.turnSnapshot, snapshot.RestoreOptions{Discard: created})
+	if err != nil {
+		app.Logger.Log("ERROR: Failed to restore snapshot %s: %v", app.turnSnapshot, err)
+		message := fmt.Sprintf("Snapshot %s was not restored: %v", app.turnSnapshot, err)
+		var newFiles *snapshot.NewFilesError
+		if errors.As(err, &newFiles) {
+			message = fmt.Sprintf("Snapshot %s was not restored: these files were created since it was taken, not by the turn's file edits, and would be deleted: %s\nMove them away, or run `codex snapshot restore %s --include-new`.",
+				app.turnSnapshot, strings.Join(newFiles.Paths, ", "), app.turnSnapshot)
+		}
+		app.reviewModel.SetSnapshot("")
+		app.reviewModel.SetNotice(message)
+		return
+	}
+
+	app.isReviewing = false
+	app.Journal.Reset()
+	summary := fmt.Sprintf("Restored snapshot %s: the workspace is back to how it was before the turn.", result.Snapshot.ID)
+	if len(result.Deleted) > 0 {
+		sort.Strings(result.Deleted)
+		summary += "\nDeleted: " + strings.Join(result.Deleted, ", ")
+	}
+	app.Logger.Log("%s", summary)
+	app.ChatModel.AddSystemMessage(summary)
+	app.ChatModel.ForceUpdateViewport()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/epuerta/codex-go/internal/usagelog"
+	"github.com/spf13/cobra"
+)
+
+// statsCmd creates the command that reports recorded usage
+func statsCmd() *cobra.Command {
+	var since string
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Report token usage, cost and tool calls over a period",
+		Long: `Summarize the usage log (usage_log, default ~/.codex/usage.jsonl), which
+every codex process appends one record to per turn.
+
+The report shows turns, tokens and estimated cost, the average turn latency,
+the most used tools and the totals of each day.
+
+The period starts at --since: a number of days or weeks (7d, 2w), a
+duration (12h), "today", or a date (YYYY-MM-DD).`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runStats(since, asJSON)
+		},
+	}
+	cmd.Flags().StringVar(&since, "since", "7d", "Start of the reported period")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print the summary as JSON")
+
+	return cmd
+}
+
+// runStats implements the stats command
+func runStats(since string, asJSON bool) {
+	appLogger = logging.NewNilLogger()
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.UsageLog == "" {
+		fmt.Fprintln(os.Stderr, "Error: the usage log is disabled (usage_log is empty)")
+		os.Exit(1)
+	}
+	start, err := usagelog.ParseSince(since, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	records, err := usagelog.Read(cfg.UsageLog, start)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	summary := usagelog.Summarize(records, time.Local)
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(summary); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing summary: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	usagelog.WriteReport(os.Stdout, fmt.Sprintf("Usage since %s", start.Format("2006-01-02 15:04")), summary)
+}
+
+// usageStats renders the report of the /stats overlay: today and the last seven days
+func (app *App) usageStats() string {
+	if app.Config.UsageLog == "" {
+		return "The usage log is disabled (usage_log is empty)."
+	}
+	now := time.Now()
+	today, _ := usagelog.ParseSince("today", now)
+	week, _ := usagelog.ParseSince("7d", now)
+	records, err := usagelog.Read(app.Config.UsageLog, week)
+	if err != nil {
+		return fmt.Sprintf("Failed to read usage: %v", err)
+	}
+
+	var todays []usagelog.Record
+	for _, rec := range records {
+		if !rec.Time.Before(today) {
+			todays = append(todays, rec)
+		}
+	}
+	var b strings.Builder
+	usagelog.WriteReport(&b, "Today", usagelog.Summarize(todays, time.Local))
+	usagelog.WriteReport(&b, "Last 7 days", usagelog.Summarize(records, time.Local))
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/functions"
+	"github.com/epuerta/codex-go/internal/sandbox"
+	"github.com/epuerta/codex-go/internal/ui"
+)
+
+// toolCallCanceller is implemented by agents that can answer a single tool
+// call as cancelled by the user while the others go on
+type toolCallCanceller interface {
+	CancelToolCall(ctx context.Context, callID string) error
+}
+
+// toolRunMsg carries the outcome of a tool call run in the background
+type toolRunMsg struct {
+	call    agent.FunctionCall
+	command string                 // Shell command of an execute_command call
+	result  *sandbox.CommandResult // Outcome of the command
+	output  string                 // Output of any other tool
+	err     error
+	note    string // Told to the model along with the result
+}
+
+// toolQueueMsg reports that a tool call's place in the executor queue changed
+type toolQueueMsg struct {
+	callID string
+	status functions.QueueStatus
+}
+
+// startToolRun runs a tool call in the background, so input is still handled
+// while it runs and /cancel can stop it alone. command is the shell command
+// of an execute_command call; note is told to the model along with the
+// result.
+func (app *App) startToolRun(call agent.FunctionCall, command, note string) {
+	app.ChatModel.SetThinkingStatus(fmt.Sprintf("Executing: %s... (alt+<n> cancels a running call)", call.Name))
+	go func() {
+		msg := toolRunMsg{call: call, command: command, note: note}
+		if command != "" {
+			msg.result, msg.err = app.runCommand(call.ID, command, stdinData(call.Arguments))
+		} else {
+			msg.output, msg.err = app.runTool(call.ID, call.Name, call.Arguments)
+		}
+		app.agentMsgChan <- msg
+	}()
+}
+
+// queueStatus returns the executor status callback for tool call callID,
+// which posts the call's queue position to the UI. The executor must not
+// block on it, so the message is sent from a goroutine of its own.
+func (app *App) queueStatus(callID string) func(functions.QueueStatus) {
+	return func(status functions.QueueStatus) {
+		go func() { app.agentMsgChan <- toolQueueMsg{callID: callID, status: status} }()
+	}
+}
+
+// showQueueStatus shows how many tool calls wait for a slot. Updates may
+// arrive out of order, so the count is read back from the executor.
+func (app *App) showQueueStatus(msg toolQueueMsg) {
+	queued := 0
+	for _, call := range app.Executor.Calls() {
+		if !call.Running {
+			queued++
+		}
+	}
+	switch {
+	case queued == 1:
+		app.ChatModel.SetThinkingStatus("1 tool call queued...")
+	case queued > 1:
+		app.ChatModel.SetThinkingStatus(fmt.Sprintf("%d tool calls queued...", queued))
+	case msg.status.Position == 0:
+		app.ChatModel.SetThinkingStatus(fmt.Sprintf("Executing: %s... (alt+<n> cancels a running call)", msg.status.Tool))
+	}
+}
+
+// finishToolRun shows the outcome of a tool call run in the background and
+// sends it to the agent as the call's result. A call stopped with /cancel is
+// answered as cancelled by the user.
+func (app *App) finishToolRun(msg toolRunMsg) {
+	resultMsg := sendFunctionResultMsg{
+		ctx:          context.Background(),
+		functionName: msg.call.Name,
+		callID:       msg.call.ID,
+		originalArgs: msg.call.Arguments,
+	}
+
+	switch {
+	case errors.Is(msg.err, functions.ErrCancelledByUser):
+		app.ChatModel.AddSystemMessage(fmt.Sprintf("Cancelled %s (%s).", msg.call.Name, msg.call.ID))
+		resultMsg.output = agent.ToolCallCancelled
+		resultMsg.cancelled = true
+
+	case msg.command != "":
+		result := msg.result
+		uiResult := &ui.CommandResult{Command: msg.command, Stdout: result.Stdout, Stderr: result.Stderr, ExitCode: result.ExitCode, Duration: result.Duration, Error: msg.err}
+		app.ChatModel.AddCommandMessage(msg.command, uiResult)
+		// A command that ran succeeds whatever its exit code, which the result carries
+		shellResult := functions.NewShellResult(result, functions.ShellOutputLimit(app.Config))
+		if msg.err != nil {
+			shellResult.ExecError = msg.err.Error()
+		}
+		resultMsg.output = shellResult.String()
+		resultMsg.success = shellResult.ExecError == ""
+		if resultMsg.success {
+			resultMsg.exitCode = &result.ExitCode
+		}
+		resultMsg.duration = result.Duration
+
+	default:
+		if msg.call.Name == "change_directory" && msg.err == nil {
+			app.syncWorkingDir()
+		}
+		resultMsg.output = msg.output
+		resultMsg.success = msg.err == nil
+		if msg.err != nil {
+			resultMsg.output = fmt.Sprintf("Error: %v", msg.err)
+			app.ChatModel.AddSystemMessage(resultMsg.output)
+		}
+		app.ChatModel.AddFunctionResultMessage(resultMsg.output, !resultMsg.success)
+	}
+	if !resultMsg.cancelled {
+		resultMsg.note = msg.note
+	}
+	app.ChatModel.ForceUpdateViewport()
+	app.Logger.Log("Tool call %s (%s) finished. Success: %t", msg.call.ID, msg.call.Name, resultMsg.success)
+	app.sendFunctionResultCmd(resultMsg)
+}
+
+// toolCalls returns the model's tool calls running or queued, in the order
+// they are listed and numbered for cancelling
+func (app *App) toolCalls() []functions.CallStatus {
+	if app.Executor == nil {
+		return nil
+	}
+	var calls []functions.CallStatus
+	for _, call := range app.Executor.Calls() {
+		if call.CallID != "" { // Commands the user ran themselves are not the model's calls
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// refreshRunningCalls lists the tool calls running or queued above the input
+func (app *App) refreshRunningCalls() {
+	var lines []string
+	for _, call := range app.toolCalls() {
+		state := "running"
+		if !call.Running {
+			state = "queued"
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s) %s %s", call.Tool, call.CallID, state, time.Since(call.Since).Round(time.Second)))
+	}
+	app.ChatModel.SetRunningCalls(lines)
+}
+
+// cancelToolCallKey handles the alt+<n> key that cancels the n-th listed
+// tool call, reporting whether key is one
+func (app *App) cancelToolCallKey(key tea.KeyMsg) bool {
+	if !key.Alt || len(key.Runes) != 1 || key.Runes[0] < '1' || key.Runes[0] > '9' {
+		return false
+	}
+	calls := app.toolCalls()
+	n := int(key.Runes[0] - '0')
+	if n > len(calls) {
+		return false
+	}
+	if app.cancelToolCall(calls[n-1].CallID) {
+		app.ChatModel.AddSystemMessage(fmt.Sprintf("Cancelling tool call %s...", calls[n-1].CallID))
+	}
+	return true
+}
+
+// cancelToolCall stops one tool call; its result is sent when it returns
+func (app *App) cancelToolCall(callID string) bool {
+	if !app.Executor.CancelCall(callID) {
+		return false
+	}
+	app.Logger.Log("[AUDIT] App: User cancelled tool call %s.", callID)
+	app.refreshRunningCalls()
+	return true
+}
+
+// handleCancelCommand implements /cancel: without arguments it lists the
+// tool calls running or queued, numbered; /cancel <n> (or a call ID) stops
+// that call alone, and the turn goes on once the others finish. The calls
+// are also listed above the input, where alt+<n> cancels one.
+func (app *App) handleCancelCommand(args []string) string {
+	calls := app.toolCalls()
+	if len(args) == 0 {
+		if len(calls) == 0 {
+			return "No tool calls are running."
+		}
+		var b strings.Builder
+		b.WriteString("Running tool calls:")
+		for i, call := range calls {
+			state := "running"
+			if !call.Running {
+				state = "queued"
+			}
+			fmt.Fprintf(&b, "\n  %d. %s (%s) %s %s", i+1, call.Tool, call.CallID, state, time.Since(call.Since).Round(time.Second))
+		}
+		b.WriteString("\nPress alt+<n> or type /cancel <n> to stop one call; the others keep running.")
+		return b.String()
+	}
+
+	callID := args[0]
+	if n, err := strconv.Atoi(args[0]); err == nil {
+		if n < 1 || n > len(calls) {
+			return fmt.Sprintf("No running tool call %d; /cancel lists them.", n)
+		}
+		callID = calls[n-1].CallID
+	}
+	if !app.cancelToolCall(callID) {
+		return fmt.Sprintf("No running tool call %s; /cancel lists them.", callID)
+	}
+	return fmt.Sprintf("Cancelling tool call %s...", callID)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/spf13/cobra"
+)
+
+// verifyCmd creates the command that checks saved sessions for tool call
+// inconsistencies
+func verifyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify <session.json>...",
+		Short: "Check saved sessions for unpaired or misordered tool calls",
+		Long: `Load saved sessions without changing them and check that every tool call
+has exactly one result, every result answers a call, and results directly
+follow the message making the calls.
+
+Every inconsistency is listed with the index of the message it concerns. The
+exit code is 1 when any session has one, so this can guard stored fixtures in
+CI.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runVerify(args)
+		},
+	}
+}
+
+// runVerify implements the verify command
+func runVerify(paths []string) {
+	failed := false
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", path, err)
+			failed = true
+			continue
+		}
+		var history agent.ConversationHistory
+		if err := json.Unmarshal(data, &history); err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing %s: %v\n", path, err)
+			failed = true
+			continue
+		}
+		if err := history.Migrate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", path, err)
+			failed = true
+			continue
+		}
+
+		errs := history.Validate()
+		if len(errs) == 0 {
+			fmt.Printf("%s: OK (%d messages)\n", path, len(history.Messages))
+			continue
+		}
+		failed = true
+		fmt.Printf("%s: %d problem(s)\n", path, len(errs))
+		for _, err := range errs {
+			fmt.Printf("  %v\n", err)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
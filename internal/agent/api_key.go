@@ -0,0 +1,43 @@
+package agent
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// newOpenAIClient creates a client for the endpoint of cfg that authenticates with apiKey
+func newOpenAIClient(cfg *config.Config, apiKey string) *openai.Client {
+	clientConfig := openai.DefaultConfig(apiKey)
+	if cfg.BaseURL != "" {
+		clientConfig.BaseURL = cfg.BaseURL
+	}
+	if cfg.CacheControl != config.CacheControlNever {
+		clientConfig.HTTPClient = &http.Client{Transport: &cacheControlTransport{base: http.DefaultTransport, mode: cfg.CacheControl}}
+	}
+	return openai.NewClientWithConfig(clientConfig)
+}
+
+// SetAPIKey replaces the API key, e.g. when a short-lived token is refreshed.
+// Requests already sent finish on the old key; every request after it,
+// continuations of a response in progress included, uses the new one.
+func (a *OpenAIAgent) SetAPIKey(key string) error {
+	if key == "" {
+		return errors.New("API key is required")
+	}
+	client := newOpenAIClient(a.config, key)
+	a.clientMu.Lock()
+	a.client = client
+	a.clientMu.Unlock()
+	a.logger.Log("[INFO] Agent.SetAPIKey: API key replaced.")
+	return nil
+}
+
+// apiClient returns the client requests are sent with
+func (a *OpenAIAgent) apiClient() *openai.Client {
+	a.clientMu.Lock()
+	defer a.clientMu.Unlock()
+	return a.client
+}
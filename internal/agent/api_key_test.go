@@ -0,0 +1,28 @@
+package agent
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSetAPIKey(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "first", "second")
+	ctx := context.Background()
+
+	if _, err := a.SendMessage(ctx, []Message{{Role: "user", Content: "hello"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if err := a.SetAPIKey(""); err == nil {
+		t.Errorf("Expected an empty key to be rejected")
+	}
+	if err := a.SetAPIKey("rotated"); err != nil {
+		t.Fatalf("SetAPIKey failed: %v", err)
+	}
+	if _, err := a.SendMessage(ctx, []Message{{Role: "user", Content: "again"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	if len(fake.auth) != 2 || fake.auth[0] != "Bearer test" || fake.auth[1] != "Bearer rotated" {
+		t.Errorf("Expected the second request to use the new key, got %q", fake.auth)
+	}
+}
@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// startAutosave journals the history and periodically folds the journal into
+// the session snapshot, so the session survives a crash
+func (a *OpenAIAgent) startAutosave(interval time.Duration) error {
+	if err := a.attachJournal(a.history); err != nil {
+		return err
+	}
+	a.autosaveStop = make(chan struct{})
+	a.autosaveDone = make(chan struct{})
+	go a.autosaveLoop(interval)
+	return nil
+}
+
+// autosaveLoop checkpoints the session journal every interval until stopped
+func (a *OpenAIAgent) autosaveLoop(interval time.Duration) {
+	defer close(a.autosaveDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.autosaveStop:
+			return
+		case <-ticker.C:
+			a.mu.Lock()
+			journal := a.journal
+			a.mu.Unlock()
+			if journal == nil {
+				continue
+			}
+			if err := journal.checkpoint(); err != nil {
+				a.logger.Log("[WARN] Agent.autosave: %v", err)
+			}
+		}
+	}
+}
+
+// stopAutosave stops the autosave loop and finishes the journal, leaving a
+// complete snapshot and no journal behind
+func (a *OpenAIAgent) stopAutosave() error {
+	if a.autosaveStop == nil {
+		return nil
+	}
+	close(a.autosaveStop)
+	<-a.autosaveDone
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.journal == nil {
+		return nil
+	}
+	err := a.journal.finish(a.history)
+	a.journal = nil
+	a.history.journal = nil
+	return err
+}
+
+// attachJournal starts journaling history in the session directory. The
+// caller must hold a.mu or be the only user of the agent.
+func (a *OpenAIAgent) attachJournal(history *ConversationHistory) error {
+	journal, err := openSessionJournal(a.config.SessionDir, history.CurrentSession)
+	if err != nil {
+		return err
+	}
+	journal.lock = a.historyOpts.Lock
+	journal.blobs = a.blobs
+	// A recovered session's journal may end in a torn write; fold it away first
+	if err := journal.checkpoint(); err != nil {
+		journal.file.Close()
+		return err
+	}
+	if err := journal.record(history); err != nil {
+		journal.file.Close()
+		return err
+	}
+	history.journal = journal
+	a.journal = journal
+	return nil
+}
+
+// switchJournal moves journaling from the current history to history, which
+// is about to replace it. The caller must hold a.mu.
+func (a *OpenAIAgent) switchJournal(history *ConversationHistory) {
+	if a.journal == nil {
+		return
+	}
+	if err := a.journal.finish(a.history); err != nil {
+		a.logger.Log("[WARN] Agent.SetHistory: Failed to save the previous session: %v", err)
+	}
+	a.history.journal = nil
+	a.journal = nil
+	if err := a.attachJournal(history); err != nil {
+		a.logger.Log("[WARN] Agent.SetHistory: Failed to journal session %s: %v", history.CurrentSession, err)
+	}
+}
+
+// SessionID returns the ID the current session is saved under
+func (a *OpenAIAgent) SessionID() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.history.CurrentSession
+}
+
+// RecoverSession replaces the conversation with session id, rebuilt from its
+// snapshot and journal after a crash. The session continues under its own ID.
+func (a *OpenAIAgent) RecoverSession(id string) error {
+	if !a.config.AutosaveEnabled() {
+		return errors.New("session autosave is disabled")
+	}
+	if id == "" || filepath.Base(id) != id {
+		return fmt.Errorf("invalid session ID %q", id)
+	}
+	history, _, err := loadSession(a.config.SessionDir, id)
+	if err != nil {
+		return fmt.Errorf("failed to recover session %s: %w", id, err)
+	}
+	if len(history.Messages) == 0 {
+		return fmt.Errorf("failed to recover session %s: no saved messages", id)
+	}
+	history.MaxTokenCount = a.historyOpts.MaxTokenCount
+	history.EnablePersist = a.historyOpts.EnablePersist
+	history.HistoryPath = a.historyOpts.HistoryPath
+	history.Retention = a.historyOpts.Retention
+	history.Packer = a.historyOpts.Packer
+
+	// Imported sessions come without this agent's system prompt
+	if history.Messages[0].Role != "system" {
+		history.SetSystemPrompt(a.historyOpts.SystemPrompt)
+	}
+
+	a.SetHistory(history)
+	a.logger.Log("[INFO] Agent.RecoverSession: Recovered session %s with %d messages", id, len(history.Messages))
+	return nil
+}
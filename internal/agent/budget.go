@@ -0,0 +1,167 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrBudgetExceeded is returned instead of sending a request once the
+// session's usage has reached the configured token or dollar budget
+var ErrBudgetExceeded = errors.New("usage budget exceeded")
+
+// Usage is the cumulative token usage and estimated cost of a session
+type Usage struct {
+	Requests           int     `json:"requests"`
+	PromptTokens       int     `json:"prompt_tokens"`
+	CachedPromptTokens int     `json:"cached_prompt_tokens,omitempty"` // Of PromptTokens, those the provider served from its prompt cache
+	CompletionTokens   int     `json:"completion_tokens"`
+	TotalTokens        int     `json:"total_tokens"`
+	CostUSD            float64 `json:"cost_usd"`
+	Estimated          bool    `json:"estimated,omitempty"` // Some requests reported no usage and were estimated
+}
+
+// usageMeter accumulates the usage of every request and enforces the budget
+type usageMeter struct {
+	mu        sync.Mutex
+	usage     Usage
+	estimated int // Requests whose usage was estimated
+	maxTokens int
+	maxCost   float64
+	warnAt    float64
+	warned    bool // The approaching-budget warning was sent
+	exhausted bool // The budget-reached warning was sent
+}
+
+func newUsageMeter(cfg *config.Config) *usageMeter {
+	warnAt := cfg.BudgetWarnAt
+	if warnAt <= 0 || warnAt >= 1 {
+		warnAt = config.DefaultBudgetWarnAt
+	}
+	return &usageMeter{maxTokens: cfg.BudgetTokens, maxCost: cfg.BudgetUSD, warnAt: warnAt}
+}
+
+// Usage returns the session's usage so far
+func (a *OpenAIAgent) Usage() Usage {
+	usage, _ := a.usage.snapshot()
+	return usage
+}
+
+// snapshot returns the usage so far and how many requests had it estimated
+func (m *usageMeter) snapshot() (Usage, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usage, m.estimated
+}
+
+// checkBudget refuses a request once the budget is used up
+func (a *OpenAIAgent) checkBudget() error {
+	m := a.usage
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fraction() >= 1 {
+		a.logger.Log("[WARN] Agent.checkBudget: Refusing request: %s", m.describe())
+		return fmt.Errorf("%w: %s", ErrBudgetExceeded, m.describe())
+	}
+	return nil
+}
+
+// recordUsage adds the usage of a finished request, estimating it from the
+// request and the streamed text when the server reported none, and warns
+// handler as the budget is approached and when it is reached
+func (a *OpenAIAgent) recordUsage(req openai.ChatCompletionRequest, reported *openai.Usage, completion string, handler ResponseHandler) {
+	usage, estimated := openai.Usage{}, reported == nil
+	if reported != nil {
+		usage = *reported
+	} else {
+		usage = estimateUsage(req, completion)
+	}
+	if a.limiter != nil {
+		a.limiter.settle(estimateUsage(req, "").PromptTokens, usage.PromptTokens+usage.CompletionTokens)
+	}
+	price, priced := a.config.PriceFor(req.Model)
+
+	m := a.usage
+	m.mu.Lock()
+	if !priced && m.maxCost > 0 {
+		a.logger.Log("[WARN] Agent.recordUsage: No price known for model %s; its cost is not counted", req.Model)
+	}
+	m.usage.Requests++
+	m.usage.PromptTokens += usage.PromptTokens
+	if usage.PromptTokensDetails != nil {
+		m.usage.CachedPromptTokens += usage.PromptTokensDetails.CachedTokens
+	}
+	m.usage.CompletionTokens += usage.CompletionTokens
+	m.usage.TotalTokens += usage.PromptTokens + usage.CompletionTokens
+	cost := (float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output) / 1e6
+	m.usage.CostUSD += cost
+	m.usage.Estimated = m.usage.Estimated || estimated
+	if estimated {
+		m.estimated++
+	}
+
+	var warning string
+	switch fraction := m.fraction(); {
+	case fraction >= 1 && !m.exhausted:
+		m.exhausted, m.warned = true, true
+		warning = fmt.Sprintf("Usage budget reached (%s); further requests will be refused.", m.describe())
+	case fraction >= m.warnAt && !m.warned:
+		m.warned = true
+		warning = fmt.Sprintf("%.0f%% of the usage budget is used (%s).", fraction*100, m.describe())
+	}
+	m.mu.Unlock()
+	a.countRequest(req.Model, usage, cost)
+
+	if warning != "" {
+		a.logger.Log("[WARN] Agent.recordUsage: %s", warning)
+		sendWarning(handler, warning)
+	}
+}
+
+// fraction returns how much of the budget is used, by whichever limit is
+// closer; 0 without a budget. The caller must hold m.mu.
+func (m *usageMeter) fraction() float64 {
+	fraction := 0.0
+	if m.maxTokens > 0 {
+		fraction = float64(m.usage.TotalTokens) / float64(m.maxTokens)
+	}
+	if m.maxCost > 0 {
+		fraction = max(fraction, m.usage.CostUSD/m.maxCost)
+	}
+	return fraction
+}
+
+// describe summarizes usage against the budget. The caller must hold m.mu.
+func (m *usageMeter) describe() string {
+	var parts []string
+	if m.maxTokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d of %d tokens", m.usage.TotalTokens, m.maxTokens))
+	}
+	if m.maxCost > 0 {
+		parts = append(parts, fmt.Sprintf("$%.2f of $%.2f", m.usage.CostUSD, m.maxCost))
+	}
+	if len(parts) == 2 {
+		return parts[0] + ", " + parts[1]
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return fmt.Sprintf("%d tokens", m.usage.TotalTokens)
+}
+
+// estimateUsage approximates the usage of a request whose stream reported
+// none, at about four characters per token
+func estimateUsage(req openai.ChatCompletionRequest, completion string) openai.Usage {
+	chars := 0
+	for _, msg := range req.Messages {
+		chars += len(msg.Content)
+		for _, tc := range msg.ToolCalls {
+			chars += len(tc.Function.Name) + len(tc.Function.Arguments)
+		}
+	}
+	prompt, output := chars/4, len(completion)/4
+	return openai.Usage{PromptTokens: prompt, CompletionTokens: output, TotalTokens: prompt + output}
+}
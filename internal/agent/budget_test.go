@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestTokenBudgetWarnsThenRefuses(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t)
+	fake.usage = &openai.Usage{PromptTokens: 400, CompletionTokens: 50, TotalTokens: 450}
+	a.usage = newUsageMeter(&config.Config{BudgetTokens: 1000})
+
+	send := func() ([]ResponseItem, error) {
+		var items []ResponseItem
+		_, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "next"}}, collectItems(&items))
+		return items, err
+	}
+	warningsIn := func(items []ResponseItem) []string {
+		var warnings []string
+		for _, item := range items {
+			if item.Type == "warning" {
+				warnings = append(warnings, item.Message.Content)
+			}
+		}
+		return warnings
+	}
+
+	items, err := send()
+	if err != nil || len(warningsIn(items)) != 0 {
+		t.Fatalf("Expected the first request within budget without warnings, got %v, %v", err, warningsIn(items))
+	}
+
+	items, _ = send()
+	if w := warningsIn(items); len(w) != 1 || !strings.Contains(w[0], "90% of the usage budget") {
+		t.Errorf("Expected an approaching-budget warning, got %v", w)
+	}
+
+	items, _ = send()
+	if w := warningsIn(items); len(w) != 1 || !strings.Contains(w[0], "Usage budget reached (1350 of 1000 tokens)") {
+		t.Errorf("Expected a budget-reached warning, got %v", w)
+	}
+
+	historyLen := len(a.GetHistory().GetMessages())
+	if _, err := send(); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Expected ErrBudgetExceeded, got %v", err)
+	}
+	if len(fake.requests) != 3 {
+		t.Errorf("Expected no request once the budget is used up, got %d requests", len(fake.requests))
+	}
+	if len(a.GetHistory().GetMessages()) != historyLen {
+		t.Errorf("Expected a refused message not to be added to the history")
+	}
+
+	usage := a.Usage()
+	if usage.Requests != 3 || usage.PromptTokens != 1200 || usage.CompletionTokens != 150 || usage.Estimated {
+		t.Errorf("Expected the reported usage of 3 requests, got %+v", usage)
+	}
+}
+
+func TestDollarBudgetUsesModelPrices(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t)
+	fake.usage = &openai.Usage{PromptTokens: 100000, CompletionTokens: 10000}
+	a.config.ModelPrices = map[string]config.ModelPrice{"gpt-4o": {Input: 5, Output: 15}}
+	a.usage = newUsageMeter(&config.Config{BudgetUSD: 0.60})
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "hi"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if cost := a.Usage().CostUSD; math.Abs(cost-0.65) > 1e-9 {
+		t.Errorf("Expected a cost of $0.65, got $%f", cost)
+	}
+	_, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "again"}}, func(string) {})
+	if !errors.Is(err, ErrBudgetExceeded) || !strings.Contains(err.Error(), "$0.65 of $0.60") {
+		t.Errorf("Expected ErrBudgetExceeded with the spend, got %v", err)
+	}
+}
+
+func TestBudgetEstimatesUnreportedUsage(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, strings.Repeat("x", 400))
+	a.usage = newUsageMeter(&config.Config{BudgetTokens: 50})
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "hi"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	usage := a.Usage()
+	if !usage.Estimated || usage.CompletionTokens != 100 {
+		t.Errorf("Expected an estimate of 100 completion tokens, got %+v", usage)
+	}
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "again"}}, func(string) {}); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Expected the estimate to count against the budget, got %v", err)
+	}
+}
+
+func TestDollarBudgetRequiresKnownPrice(t *testing.T) {
+	_, err := NewOpenAIAgent(&config.Config{APIKey: "test", Model: "in-house-model", BudgetUSD: 5}, nil)
+	if err == nil || !strings.Contains(err.Error(), "model_prices") {
+		t.Errorf("Expected an error for a dollar budget without a price, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"encoding/json"
+	"regexp"
+	"slices"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// callProgressInterval is how many more argument bytes a streaming tool call
+// must receive before its progress is reported again
+const callProgressInterval = 1024
+
+// pathArgument matches a complete "path" string in partial JSON arguments
+var pathArgument = regexp.MustCompile(`"path"\s*:\s*("(?:[^"\\]|\\.)*")`)
+
+// CallProgress is how far the arguments of a streaming tool call have come
+type CallProgress struct {
+	Bytes int    `json:"bytes"`          // Argument bytes received so far
+	Path  string `json:"path,omitempty"` // Target of a file tool, once it can be parsed
+}
+
+// callReport is what was last reported for a streaming call
+type callReport struct {
+	bytes int
+	path  string
+}
+
+// callProgress reports tool calls as their arguments stream in, so a long
+// write_file call does not look like a stalled response
+type callProgress struct {
+	agent    *OpenAIAgent
+	reported map[*openai.FunctionCall]*callReport
+}
+
+// newCallProgress creates a reporter for the calls of one response
+func newCallProgress(a *OpenAIAgent) *callProgress {
+	return &callProgress{agent: a, reported: make(map[*openai.FunctionCall]*callReport)}
+}
+
+// update sends a "function_call_progress" item for call when its name first
+// becomes known, when its path is parsed, and after every
+// callProgressInterval bytes of arguments
+func (p *callProgress) update(handler ResponseHandler, id string, call *openai.FunctionCall) {
+	if call == nil || call.Name == "" {
+		return
+	}
+	last, seen := p.reported[call]
+	if !seen {
+		last = &callReport{}
+		p.reported[call] = last
+	}
+	path := last.path
+	if path == "" && p.agent.takesPath(call.Name) {
+		path = partialPath(call.Arguments)
+	}
+	if seen && path == last.path && len(call.Arguments)-last.bytes < callProgressInterval {
+		return
+	}
+	last.bytes, last.path = len(call.Arguments), path
+
+	item := ResponseItem{
+		Type:         EventFunctionCallProgress,
+		FunctionCall: &FunctionCall{ID: id, Name: call.Name},
+		Progress:     &CallProgress{Bytes: len(call.Arguments), Path: path},
+	}
+	if data, err := json.Marshal(item); err == nil {
+		handler(string(data))
+	}
+}
+
+// takesPath reports whether the named tool has a path parameter
+func (a *OpenAIAgent) takesPath(name string) bool {
+	for _, tool := range a.tools {
+		if tool.Function.Name == name {
+			return slices.Contains(toolParameterNames(tool), "path")
+		}
+	}
+	return false
+}
+
+// partialPath returns the "path" argument of possibly incomplete JSON
+// arguments, or "" while it has not been received in full
+func partialPath(args string) string {
+	match := pathArgument.FindStringSubmatch(args)
+	if match == nil {
+		return ""
+	}
+	var path string
+	if err := json.Unmarshal([]byte(match[1]), &path); err != nil {
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestCallProgressReportsStreamingArguments(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t)
+	var items []ResponseItem
+	handler := func(itemJSON string) {
+		var item ResponseItem
+		if err := json.Unmarshal([]byte(itemJSON), &item); err == nil {
+			items = append(items, item)
+		}
+	}
+	progress := newCallProgress(a)
+
+	call := &openai.FunctionCall{}
+	progress.update(handler, "call_1", call)
+	if len(items) != 0 {
+		t.Fatalf("Expected no progress before the tool name is known, got %d items", len(items))
+	}
+
+	deltas := []string{`{"pa`, `th": "internal/fo`, `o.go", "content": "`, strings.Repeat("x", 500), strings.Repeat("x", 600)}
+	for _, delta := range deltas {
+		call.Name = "write_file"
+		call.Arguments += delta
+		progress.update(handler, "call_1", call)
+	}
+
+	// Named, path parsed, then a kilobyte more arguments
+	if len(items) != 3 {
+		t.Fatalf("Expected 3 progress items, got %d: %+v", len(items), items)
+	}
+	for _, item := range items {
+		if item.Type != "function_call_progress" || item.FunctionCall == nil || item.FunctionCall.Name != "write_file" || item.Progress == nil {
+			t.Fatalf("Expected write_file progress items, got %+v", item)
+		}
+	}
+	if items[0].Progress.Path != "" {
+		t.Errorf("Expected no path while it is incomplete, got %q", items[0].Progress.Path)
+	}
+	if items[1].Progress.Path != "internal/foo.go" {
+		t.Errorf("Expected the path once complete, got %q", items[1].Progress.Path)
+	}
+	if last := items[2].Progress; last.Bytes != len(call.Arguments) || last.Path != "internal/foo.go" {
+		t.Errorf("Expected %d bytes for internal/foo.go, got %+v", len(call.Arguments), last)
+	}
+}
+
+func TestPartialPath(t *testing.T) {
+	tests := map[string]string{
+		`{"path": "a.go"`:                      "a.go",
+		`{"path":"dir/\"quoted\".go", "con`:    `dir/"quoted".go`,
+		`{"path": "unfinished`:                 "",
+		`{"content": "say \"path\": \"x\"", `:  "",
+		`{"pa`:                                 "",
+		`not json at all "path": "b.go" maybe`: "b.go",
+	}
+	for args, want := range tests {
+		if got := partialPath(args); got != want {
+			t.Errorf("partialPath(%q): expected %q, got %q", args, want, got)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// candidateSet accumulates the choices of a response beyond the first, when
+// the response was requested with the choices setting. They are streamed as
+// "message" items tagged with their choice index; their tool calls and
+// inline reasoning are not processed.
+type candidateSet struct {
+	cfg     *config.Config
+	model   string
+	started time.Time
+	content map[int]string
+	updates map[int]*messageFlusher
+}
+
+// newCandidateSet creates an empty set for a response of model started at started
+func newCandidateSet(cfg *config.Config, model string, started time.Time) *candidateSet {
+	return &candidateSet{
+		cfg:     cfg,
+		model:   model,
+		started: started,
+		content: make(map[int]string),
+		updates: make(map[int]*messageFlusher),
+	}
+}
+
+// applyChoices asks for the configured number of choices
+func (a *OpenAIAgent) applyChoices(req *openai.ChatCompletionRequest) {
+	if a.config.Choices > 1 {
+		req.N = a.config.Choices
+	}
+}
+
+// split returns the first choice of a chunk, if it has one, and adds the
+// others to the set
+func (c *candidateSet) split(handler ResponseHandler, choices []openai.ChatCompletionStreamChoice) (openai.ChatCompletionStreamChoice, bool) {
+	var first openai.ChatCompletionStreamChoice
+	found := false
+	for _, choice := range choices {
+		if choice.Index == 0 {
+			first, found = choice, true
+			continue
+		}
+		c.add(handler, choice)
+	}
+	return first, found
+}
+
+// add accumulates the text of a candidate choice and sends it on
+func (c *candidateSet) add(handler ResponseHandler, choice openai.ChatCompletionStreamChoice) {
+	updates := c.updates[choice.Index]
+	if updates == nil {
+		updates = newMessageFlusher(c.cfg, c.model)
+		c.updates[choice.Index] = updates
+	}
+	if choice.Delta.Content != "" {
+		c.content[choice.Index] += choice.Delta.Content
+		item := textUpdate(openai.ChatMessageRoleAssistant, c.content[choice.Index], c.started)
+		item.Choice = choice.Index
+		updates.send(handler, item)
+	}
+	if finish := finishReasonOf(choice); finish != FinishReasonNone {
+		updates.finish(handler, finish)
+	}
+}
+
+// flush sends the updates of every candidate still held back
+func (c *candidateSet) flush(handler ResponseHandler) {
+	for _, updates := range c.updates {
+		updates.flush(handler)
+	}
+}
+
+// all returns the text of every choice in index order, first being the text
+// of choice 0
+func (c *candidateSet) all(first string) []string {
+	texts := []string{first}
+	for index := range c.content {
+		for len(texts) <= index {
+			texts = append(texts, "")
+		}
+		texts[index] = c.content[index]
+	}
+	return texts
+}
+
+// Candidates returns the text of every choice of the last response to new
+// input, the one recorded in the history first, when the choices setting asks
+// for more than one. It returns nil otherwise.
+func (a *OpenAIAgent) Candidates() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.candidates
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestChoicesAreStreamedAndReturnedAsCandidates(t *testing.T) {
+	var req openai.ChatCompletionRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &req)
+		w.Header().Set("Content-Type", "text/event-stream")
+		// Choices arrive interleaved, one per chunk, as with n > 1
+		chunks := []map[string]interface{}{
+			{"index": 1, "delta": map[string]string{"role": "assistant", "content": "Second "}},
+			{"index": 0, "delta": map[string]string{"role": "assistant", "content": "First "}},
+			{"index": 2, "delta": map[string]string{"role": "assistant", "content": "Third"}},
+			{"index": 0, "delta": map[string]string{"content": "answer"}},
+			{"index": 1, "delta": map[string]string{"content": "answer"}},
+			{"index": 2, "delta": map[string]string{}, "finish_reason": "stop"},
+			{"index": 0, "delta": map[string]string{}, "finish_reason": "stop"},
+			{"index": 1, "delta": map[string]string{}, "finish_reason": "stop"},
+		}
+		for _, choice := range chunks {
+			data, _ := json.Marshal(map[string]interface{}{"id": "chatcmpl-test", "object": "chat.completion.chunk", "choices": []interface{}{choice}})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(ts.Close)
+
+	a, err := NewOpenAIAgent(&config.Config{APIKey: "test", Model: "gpt-4o", BaseURL: ts.URL, Choices: 3}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Answer"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	if req.N != 3 {
+		t.Errorf("Expected the request to ask for 3 choices, got %d", req.N)
+	}
+	final := make(map[int]ResponseItem)
+	for _, item := range items {
+		if item.Type == "message" {
+			final[item.Choice] = item
+		}
+	}
+	want := []string{"First answer", "Second answer", "Third"}
+	for choice, text := range want {
+		item, ok := final[choice]
+		if !ok || item.Message.Content != text || item.FinishReason != FinishReasonStop {
+			t.Errorf("Expected choice %d to end with %q, got %+v", choice, text, item)
+		}
+	}
+
+	candidates := a.Candidates()
+	if len(candidates) != len(want) {
+		t.Fatalf("Expected %d candidates, got %q", len(want), candidates)
+	}
+	for i, text := range want {
+		if candidates[i] != text {
+			t.Errorf("Expected candidate %d to be %q, got %q", i, text, candidates[i])
+		}
+	}
+	messages := a.GetHistory().GetMessages()
+	if last := messages[len(messages)-1]; last.Role != "assistant" || last.Content != "First answer" {
+		t.Errorf("Expected the history to record choice 0, got %+v", last)
+	}
+}
+
+func TestCandidatesNeedChoices(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "Only answer")
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Answer"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if fake.lastRequest().N != 0 {
+		t.Errorf("Expected no n without choices, got %d", fake.lastRequest().N)
+	}
+	if candidates := a.Candidates(); candidates != nil {
+		t.Errorf("Expected no candidates, got %q", candidates)
+	}
+}
@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// AskUserChoiceToolName is the tool the model calls to have the user pick
+// from a list of options, or pick files of the workspace. Hosts answer it
+// with the selection as the tool result, one option per line.
+const AskUserChoiceToolName = "ask_user_choice"
+
+// ChoiceKindFiles asks the user to pick files of the workspace instead of
+// listed options
+const ChoiceKindFiles = "files"
+
+// askUserChoiceTool lets the model ask a structured question mid-turn
+var askUserChoiceTool = ToolDefinition{
+	Type: "function",
+	Function: FunctionDef{
+		Name:        AskUserChoiceToolName,
+		Description: "Ask the user to choose from a list of options, or to pick files of the workspace (kind \"files\"), and wait for the selection. Prefer this over ask_user when the answer is one of known alternatives or a set of files. The result is the selected options or file paths, one per line.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": OrderedMap{
+				{"question", map[string]interface{}{
+					"type":        "string",
+					"description": "The question shown above the choices",
+				}},
+				{"options", map[string]interface{}{
+					"type":        "array",
+					"items":       map[string]interface{}{"type": "string"},
+					"description": "The options to choose from (ignored for kind \"files\")",
+				}},
+				{"kind", map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"options", ChoiceKindFiles},
+					"description": "\"options\" (default) or \"files\" to let the user search and pick workspace files",
+				}},
+				{"multiple", map[string]interface{}{
+					"type":        "boolean",
+					"description": "Whether the user may select more than one",
+				}},
+			},
+			"required": []string{"question"},
+		},
+	},
+}
+
+// ChoiceRequest is the question of an ask_user_choice call
+type ChoiceRequest struct {
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+	Kind     string   `json:"kind"`
+	Multiple bool     `json:"multiple"`
+}
+
+// ParseChoiceRequest returns the question of an ask_user_choice call
+func ParseChoiceRequest(arguments string) (ChoiceRequest, error) {
+	var req ChoiceRequest
+	if err := json.Unmarshal([]byte(arguments), &req); err != nil {
+		return req, fmt.Errorf("invalid %s arguments: %w", AskUserChoiceToolName, err)
+	}
+	req.Question = strings.TrimSpace(req.Question)
+	if req.Question == "" {
+		return req, fmt.Errorf("invalid %s arguments: the question is empty", AskUserChoiceToolName)
+	}
+	switch req.Kind {
+	case "", "options":
+		if len(req.Options) == 0 {
+			return req, fmt.Errorf("invalid %s arguments: no options to choose from (use kind \"files\" to pick files)", AskUserChoiceToolName)
+		}
+	case ChoiceKindFiles:
+	default:
+		return req, fmt.Errorf("invalid %s arguments: unknown kind %q: expected options or files", AskUserChoiceToolName, req.Kind)
+	}
+	return req, nil
+}
+
+// Hash identifies the question in a choices answers file: the first 16 hex
+// digits of the SHA-256 of the question text
+func (r ChoiceRequest) Hash() string {
+	sum := sha256.Sum256([]byte(r.Question))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// LoadChoiceAnswers reads a choices answers file: a JSON object mapping
+// question hashes to the selection to answer with, one option per line
+func LoadChoiceAnswers(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read choices file: %w", err)
+	}
+	var answers map[string]string
+	if err := json.Unmarshal(data, &answers); err != nil {
+		return nil, fmt.Errorf("failed to parse choices file %s: %w", path, err)
+	}
+	return answers, nil
+}
+
+// AnswerChoice answers an ask_user_choice call without a user, from answers
+// loaded with LoadChoiceAnswers. The error, returned as the tool's failed
+// result, names the hash to add an answer under.
+func AnswerChoice(answers map[string]string, arguments string) (string, error) {
+	req, err := ParseChoiceRequest(arguments)
+	if err != nil {
+		return "", err
+	}
+	answer, ok := answers[req.Hash()]
+	if !ok {
+		return "", fmt.Errorf("%s needs a user, and none is attached: no answer to %q (hash %s) was provided with --choices", AskUserChoiceToolName, req.Question, req.Hash())
+	}
+	return answer, nil
+}
+
+// IsQuestionTool reports whether a tool is answered by the user rather than run
+func IsQuestionTool(name string) bool {
+	return name == AskUserToolName || name == AskUserChoiceToolName
+}
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseChoiceRequest(t *testing.T) {
+	valid := []string{
+		`{"question":"Which one?","options":["a","b"]}`,
+		`{"question":"Which files?","kind":"files","multiple":true}`,
+	}
+	for _, args := range valid {
+		if _, err := ParseChoiceRequest(args); err != nil {
+			t.Errorf("ParseChoiceRequest(%s): unexpected error %v", args, err)
+		}
+	}
+
+	invalid := map[string]string{
+		`{"question":"Which one?"}`:                          "no options",
+		`{"question":" ","options":["a"]}`:                   "question is empty",
+		`{"question":"Which?","kind":"dirs"}`:                `unknown kind "dirs"`,
+		`{"question":"Which?","options":"a"}`:                "invalid ask_user_choice arguments",
+		`{"question":"Which?","kind":"files","options":[1]}`: "invalid ask_user_choice arguments",
+	}
+	for args, want := range invalid {
+		if _, err := ParseChoiceRequest(args); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseChoiceRequest(%s): expected an error containing %q, got %v", args, want, err)
+		}
+	}
+}
+
+func TestAnswerChoice(t *testing.T) {
+	args := `{"question":"Which files?","kind":"files"}`
+	request, _ := ParseChoiceRequest(args)
+
+	path := filepath.Join(t.TempDir(), "choices.json")
+	os.WriteFile(path, []byte(`{"`+request.Hash()+`": "main.go"}`), 0644)
+	answers, err := LoadChoiceAnswers(path)
+	if err != nil {
+		t.Fatalf("LoadChoiceAnswers failed: %v", err)
+	}
+	if answer, err := AnswerChoice(answers, args); err != nil || answer != "main.go" {
+		t.Errorf("Expected the answer from the file, got %q (%v)", answer, err)
+	}
+
+	_, err = AnswerChoice(nil, args)
+	if err == nil || !strings.Contains(err.Error(), request.Hash()) {
+		t.Errorf("Expected an error naming the question hash, got %v", err)
+	}
+}
+
+func TestAskUserChoiceCallReported(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, toolCallReply(AskUserChoiceToolName, `{"question":"Which DB?","options":["Postgres","SQLite"]}`))
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "set up storage"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	if n := countItems(items, "user_input_required"); n != 1 {
+		t.Fatalf("Expected 1 user_input_required item, got %d in %+v", n, items)
+	}
+	for _, item := range items {
+		if item.Type == "user_input_required" && (item.FunctionCall == nil || item.FunctionCall.Name != AskUserChoiceToolName || item.Message.Content != "Which DB?") {
+			t.Errorf("Expected the ask_user_choice call and its question, got %+v", item)
+		}
+	}
+
+	found := false
+	for _, tool := range fake.lastRequest().Tools {
+		if tool.Function != nil && tool.Function.Name == AskUserChoiceToolName {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected the ask_user_choice tool to be offered")
+	}
+}
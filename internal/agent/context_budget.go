@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// EstimateTokens approximates the tokens of text the way the history counts
+// them, at about four characters per token
+func EstimateTokens(text string) int {
+	return int(math.Ceil(float64(len(text)) / 4))
+}
+
+// RemainingContextTokens returns how many more prompt tokens fit in the
+// model's context window: the window (context_window, or the model's default)
+// less the output reserved with max_tokens and the estimated tokens of the
+// history, the system prompt sources merged into it and the tool definitions
+// sent with the next request. It is negative once they overflow the window.
+// Compare it with EstimateTokens of a tool result to decide whether to
+// truncate the result before sending it.
+func (a *OpenAIAgent) RemainingContextTokens() int {
+	a.mu.Lock()
+	prompt := 0
+	history := a.history
+	var sources []SystemPromptSource
+	if history != nil {
+		prompt = estimateTokens(history.GetMessagesForContext())
+		sources = a.promptSources
+	}
+	a.mu.Unlock()
+	for _, src := range sources {
+		if content := src.Content(history); strings.TrimSpace(content) != "" {
+			prompt += EstimateTokens(content)
+		}
+	}
+	if apiTools := a.requestTools(); len(apiTools) > 0 {
+		tools, _ := json.Marshal(apiTools)
+		prompt += EstimateTokens(string(tools))
+	}
+	return a.config.ContextWindowFor(a.model()) - a.config.MaxTokens - prompt
+}
+
+// applyOutputLimit caps the response at max_tokens. Reasoning models take the
+// cap as max_completion_tokens and reject max_tokens.
+func (a *OpenAIAgent) applyOutputLimit(req *openai.ChatCompletionRequest) {
+	if a.config.MaxTokens <= 0 {
+		return
+	}
+	if a.config.IsReasoningModel(req.Model) {
+		req.MaxCompletionTokens = a.config.MaxTokens
+	} else {
+		req.MaxTokens = a.config.MaxTokens
+	}
+}
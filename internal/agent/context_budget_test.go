@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRemainingContextTokens(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "Hello")
+	a.config.ContextWindow = 100000
+	a.config.MaxTokens = 4000
+
+	before := a.RemainingContextTokens()
+	if before >= 100000-4000 || before <= 0 {
+		t.Fatalf("Expected the system prompt and tools to be counted against the window, got %d", before)
+	}
+
+	section := "Prefer table-driven tests."
+	if err := a.AddSystemPromptSource(SystemPromptSource{Name: "style", Content: func(*ConversationHistory) string { return section }}); err != nil {
+		t.Fatalf("AddSystemPromptSource failed: %v", err)
+	}
+	if got, want := a.RemainingContextTokens(), before-EstimateTokens(section); got != want {
+		t.Errorf("Expected the merged system prompt source to be counted, got %d tokens left, want %d", got, want)
+	}
+	before -= EstimateTokens(section)
+
+	message := "Summarize this project for me, please."
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: message}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	want := before - estimateMessageTokens(Message{Content: message}) - estimateMessageTokens(Message{Content: "Hello"})
+	if got := a.RemainingContextTokens(); got != want {
+		t.Errorf("Expected %d tokens left after the exchange, got %d", want, got)
+	}
+
+	if req := fake.lastRequest(); req.MaxTokens != 4000 {
+		t.Errorf("Expected max_tokens to be requested, got %d", req.MaxTokens)
+	}
+	a.config.Model = "o3-mini"
+	a.SendMessage(context.Background(), []Message{{Role: "user", Content: "again"}}, func(string) {})
+	if req := fake.lastRequest(); req.MaxTokens != 0 || req.MaxCompletionTokens != 4000 {
+		t.Errorf("Expected a reasoning model to get max_completion_tokens, got %d and %d", req.MaxTokens, req.MaxCompletionTokens)
+	}
+}
@@ -0,0 +1,311 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ContextPacker chooses the messages of the history sent with a request when
+// the whole history does not fit the token budget. The packed messages must
+// keep the API's pairing: a tool call is sent with all of its results.
+type ContextPacker interface {
+	// Pack returns the messages to send for h within budget tokens where it
+	// can. The retained messages (see RetentionPolicy) are always sent.
+	Pack(h *ConversationHistory, budget int) []Message
+}
+
+// AgePacker drops the oldest messages the retention policy does not keep
+// until the rest fits, like pruning does to the history itself
+type AgePacker struct{}
+
+// Pack implements ContextPacker
+func (AgePacker) Pack(h *ConversationHistory, budget int) []Message {
+	messages := append([]Message(nil), h.Messages...)
+	keep := h.retained()
+	tokens := estimateTokens(messages)
+	for tokens > budget {
+		start, end, ok := oldestDroppable(messages, keep)
+		if !ok {
+			break
+		}
+		tokens -= estimateTokens(messages[start:end])
+		messages = append(messages[:start], messages[end:]...)
+		keep = append(keep[:start], keep[end:]...)
+	}
+	return messages
+}
+
+// RelevancePacker keeps the messages most likely to matter instead of the
+// newest: each group of messages (one message, or a tool call with its
+// results) is scored by recency, role, whether it involves a file touched in
+// the last TouchedTurns turns, and size. The best groups are packed greedily;
+// tool results that do not fit are replaced by a one-line stub, and groups
+// whose stubs do not fit either are dropped.
+type RelevancePacker struct {
+	TouchedTurns int // Turns whose tool calls make a file recent (0 = DefaultTouchedTurns)
+}
+
+// DefaultTouchedTurns is how many of the last turns make the files their tool
+// calls touched recent for RelevancePacker
+const DefaultTouchedTurns = 3
+
+// Relevance score weights. A group from the first turn scores 0 for recency
+// and one from the last turn scoreRecency.
+const (
+	scoreRecency      = 4.0
+	scoreTouched      = 3.0
+	scoreUser         = 2.0
+	scoreAssistant    = 1.0
+	scoreSizeMax      = 3.0  // Largest size penalty
+	scoreSizePerToken = 1e-3 // Size penalty per token
+)
+
+// packGroup is a group of messages RelevancePacker keeps or drops together
+type packGroup struct {
+	start, end int
+	turn       int // 1-based turn of the group, counted in user messages
+	score      float64
+	tokens     int
+	stubbed    []Message // The group with its tool results stubbed, nil if it has none
+	stubTokens int
+}
+
+// Pack implements ContextPacker
+func (p RelevancePacker) Pack(h *ConversationHistory, budget int) []Message {
+	messages := h.Messages
+	if estimateTokens(messages) <= budget {
+		return messages
+	}
+	touchedTurns := p.TouchedTurns
+	if touchedTurns <= 0 {
+		touchedTurns = DefaultTouchedTurns
+	}
+
+	keep := h.retained()
+	groups := packGroups(messages)
+	lastTurn := 1
+	if len(groups) > 0 {
+		lastTurn = max(groups[len(groups)-1].turn, 1)
+	}
+
+	// Files touched by the tool calls of the last turns
+	touched := make(map[string]bool)
+	for _, g := range groups {
+		if g.turn > lastTurn-touchedTurns {
+			for _, path := range groupPaths(messages[g.start:g.end]) {
+				touched[path] = true
+			}
+		}
+	}
+
+	used := 0
+	var optional []*packGroup
+	for i := range groups {
+		g := &groups[i]
+		if keep[g.start] {
+			used += g.tokens
+			continue
+		}
+		g.score = relevanceScore(messages[g.start:g.end], g, lastTurn, touched)
+		optional = append(optional, g)
+	}
+	sort.SliceStable(optional, func(i, j int) bool { return optional[i].score > optional[j].score })
+
+	// Greedily take the best groups whole, else stubbed
+	chosen := make(map[int][]Message)
+	for _, g := range optional {
+		switch {
+		case used+g.tokens <= budget:
+			chosen[g.start] = messages[g.start:g.end]
+			used += g.tokens
+		case g.stubbed != nil && used+g.stubTokens <= budget:
+			chosen[g.start] = g.stubbed
+			used += g.stubTokens
+		}
+	}
+
+	var packed []Message
+	for _, g := range groups {
+		if keep[g.start] {
+			packed = append(packed, messages[g.start:g.end]...)
+		} else if group, ok := chosen[g.start]; ok {
+			packed = append(packed, group...)
+		}
+	}
+	return packed
+}
+
+// packGroups splits messages into the groups packing keeps or drops together
+func packGroups(messages []Message) []packGroup {
+	var groups []packGroup
+	turn := 0
+	for start := 0; start < len(messages); {
+		end := groupEnd(messages, start)
+		if messages[start].Role == "user" {
+			turn++
+		}
+		g := packGroup{start: start, end: end, turn: max(turn, 1), tokens: estimateTokens(messages[start:end])}
+		if stubbed, ok := stubToolResults(messages[start:end], g.turn); ok {
+			g.stubbed = stubbed
+			g.stubTokens = estimateTokens(stubbed)
+		}
+		groups = append(groups, g)
+		start = end
+	}
+	return groups
+}
+
+// relevanceScore scores a group of messages: recent groups, groups touching
+// recently touched files, and user and assistant messages score higher;
+// large groups lower
+func relevanceScore(group []Message, g *packGroup, lastTurn int, touched map[string]bool) float64 {
+	score := 0.0
+	if lastTurn > 1 {
+		score += scoreRecency * float64(g.turn-1) / float64(lastTurn-1)
+	}
+	switch {
+	case group[0].Role == "user":
+		score += scoreUser
+	case group[0].Role == "assistant" && len(group[0].ToolCalls) == 0:
+		score += scoreAssistant
+	}
+	for _, path := range groupPaths(group) {
+		if touched[path] {
+			score += scoreTouched
+			break
+		}
+	}
+	return score - min(float64(g.tokens)*scoreSizePerToken, scoreSizeMax)
+}
+
+// groupPaths returns the file paths the tool calls of a group take, cleaned
+func groupPaths(group []Message) []string {
+	var paths []string
+	for _, msg := range group {
+		for _, call := range msg.ToolCalls {
+			for _, path := range argumentPaths(call.Function.Arguments) {
+				paths = append(paths, filepath.Clean(path))
+			}
+		}
+	}
+	return paths
+}
+
+// argumentPaths returns the "path" and "file_path" arguments of a tool call,
+// including those of a list of edits
+func argumentPaths(arguments string) []string {
+	var args map[string]interface{}
+	if json.Unmarshal([]byte(arguments), &args) != nil {
+		return nil
+	}
+	var paths []string
+	collect := func(obj map[string]interface{}) {
+		for _, key := range []string{"path", "file_path"} {
+			if path, ok := obj[key].(string); ok && path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	collect(args)
+	for _, value := range args {
+		if list, ok := value.([]interface{}); ok {
+			for _, elem := range list {
+				if obj, ok := elem.(map[string]interface{}); ok {
+					collect(obj)
+				}
+			}
+		}
+	}
+	return paths
+}
+
+// stubToolResults returns a copy of a group whose tool results are replaced
+// by one-line stubs, or false if it has no tool results
+func stubToolResults(group []Message, turn int) ([]Message, bool) {
+	if len(group[0].ToolCalls) == 0 || len(group) == 1 {
+		return nil, false
+	}
+	calls := make(map[string]ToolCall)
+	for _, call := range group[0].ToolCalls {
+		calls[call.ID] = call
+	}
+	stubbed := append([]Message(nil), group...)
+	for i := 1; i < len(stubbed); i++ {
+		msg := &stubbed[i]
+		result, ok := ParseToolResult(msg.Content)
+		if !ok {
+			result = ToolResult{Success: true, Output: msg.Content}
+		}
+		stub := fmt.Sprintf("[output of `%s` from turn %d elided — %s]", callLabel(calls[msg.ToolCallID], msg.Name), turn, resultSummary(result))
+		msg.Content = ToolResult{Success: result.Success, Output: stub, ExitCode: result.ExitCode}.Content()
+	}
+	return stubbed, true
+}
+
+// callLabel names a tool call in a stub: its command, or the tool with the
+// file it took
+func callLabel(call ToolCall, name string) string {
+	if call.Function.Name != "" {
+		name = call.Function.Name
+	}
+	var args struct {
+		Command string `json:"command"`
+	}
+	if json.Unmarshal([]byte(call.Function.Arguments), &args) == nil && args.Command != "" {
+		return truncateLabel(args.Command)
+	}
+	if paths := argumentPaths(call.Function.Arguments); len(paths) > 0 {
+		return name + " " + paths[0]
+	}
+	return name
+}
+
+// truncateLabel shortens a label to its first line and at most 60 characters
+func truncateLabel(label string) string {
+	label, _, _ = strings.Cut(label, "\n")
+	if runes := []rune(label); len(runes) > 60 {
+		label = string(runes[:57]) + "..."
+	}
+	return label
+}
+
+// resultSummary describes an elided result in a few words: failures of a
+// test run, a failed call's exit code, or its size
+func resultSummary(result ToolResult) string {
+	text := result.text()
+	failures := 0
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--- FAIL") {
+			failures++
+		}
+	}
+	lines := strings.Count(strings.TrimRight(text, "\n"), "\n") + 1
+	switch {
+	case failures == 1:
+		return "1 failure"
+	case failures > 1:
+		return fmt.Sprintf("%d failures", failures)
+	case result.ExitCode != nil && *result.ExitCode != 0:
+		return fmt.Sprintf("exit code %d, %d lines", *result.ExitCode, lines)
+	case !result.Success:
+		return "failed"
+	case text == "":
+		return "no output"
+	case lines == 1:
+		return "1 line"
+	default:
+		return fmt.Sprintf("%d lines", lines)
+	}
+}
+
+// estimateTokens estimates the tokens of messages like the history counts them
+func estimateTokens(messages []Message) int {
+	tokens := 0
+	for _, msg := range messages {
+		tokens += estimateMessageTokens(msg)
+	}
+	return tokens
+}
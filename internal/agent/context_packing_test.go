@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// packingHistory is a session whose first turn read main.go, listed the tree
+// and ran the failing tests, and whose second turn edited main.go
+func packingHistory(t *testing.T) *ConversationHistory {
+	t.Helper()
+	h, err := NewConversationHistory(DefaultHistoryOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.MaxTokenCount = 1 << 20 // Packing is tested on its own, not pruning
+
+	call := func(id, name, args, output string) {
+		h.AddMessage(Message{Role: "assistant", ToolCalls: []ToolCall{{ID: id, Type: "function", Function: FunctionCall{Name: name, Arguments: args}}}})
+		h.AddMessage(NewToolResult(id, name, output, true).Message())
+	}
+	var failures strings.Builder
+	for i := 0; i < 12; i++ {
+		fmt.Fprintf(&failures, "--- FAIL: TestCase%d (0.00s)\n    case_test.go:%d: wrong answer, expected something quite different\n", i, 10+i)
+	}
+
+	h.AddMessage(Message{Role: "user", Content: "Fix the failing tests"})
+	call("call_read", "read_file", `{"path":"main.go"}`, strings.Repeat("func main() { run() }\n", 120))
+	call("call_ls", "shell", `{"command":"ls -R"}`, strings.Repeat("internal/some/deeply/nested/file.go\n", 110))
+	call("call_test", "shell", `{"command":"go test ./..."}`, failures.String()+strings.Repeat("ok  \tpkg\t0.01s\n", 60))
+	h.AddMessage(Message{Role: "user", Content: "Now fix run in main.go"})
+	call("call_write", "write_file", `{"path":"./main.go","content":"package main"}`, "Wrote main.go")
+	h.AddMessage(Message{Role: "assistant", Content: "Fixed run."})
+	h.AddMessage(Message{Role: "user", Content: "Anything else?"})
+	return h
+}
+
+// toolContents returns the content of each tool result in messages by call ID
+func toolContents(messages []Message) map[string]string {
+	contents := make(map[string]string)
+	for _, msg := range messages {
+		if msg.Role == "tool" {
+			contents[msg.ToolCallID] = msg.Content
+		}
+	}
+	return contents
+}
+
+// validPairing checks that packed messages keep every tool call with its results
+func validPairing(t *testing.T, name string, messages []Message) {
+	t.Helper()
+	check := &ConversationHistory{Messages: messages}
+	if errs := check.Validate(); len(errs) > 0 {
+		t.Errorf("%s packing broke the tool call pairing: %v", name, errs)
+	}
+}
+
+func TestPackingUnderTightBudget(t *testing.T) {
+	h := packingHistory(t)
+	full := toolContents(h.Messages)
+	// Room for everything but the listing and the test output, and their stubs
+	budget := h.EstimateTokenCount() - estimateMessageTokens(Message{Content: full["call_ls"]}) - estimateMessageTokens(Message{Content: full["call_test"]}) + 60
+
+	aged := AgePacker{}.Pack(h, budget)
+	relevant := RelevancePacker{}.Pack(h, budget)
+	for name, packed := range map[string][]Message{"age": aged, "relevance": relevant} {
+		if tokens := estimateTokens(packed); tokens > budget {
+			t.Errorf("%s packing used %d tokens, over the budget of %d", name, tokens, budget)
+		}
+		validPairing(t, name, packed)
+		if packed[0].Role != "system" || packed[len(packed)-1].Content != "Anything else?" || packed[1].Content != "Fix the failing tests" {
+			t.Errorf("%s packing dropped the system prompt, task or last turn", name)
+		}
+	}
+
+	// Age drops the oldest output first, though main.go was edited since
+	agedOut := toolContents(aged)
+	if _, ok := agedOut["call_read"]; ok {
+		t.Errorf("Expected age packing to drop the oldest tool output")
+	}
+	if agedOut["call_test"] != full["call_test"] {
+		t.Errorf("Expected age packing to keep the newer test output whole")
+	}
+
+	// Relevance keeps the read of the recently edited file and stubs the rest
+	relevantOut := toolContents(relevant)
+	if relevantOut["call_read"] != full["call_read"] {
+		t.Errorf("Expected relevance packing to keep the read of main.go, got %q", relevantOut["call_read"])
+	}
+	if !strings.Contains(relevantOut["call_test"], "[output of `go test ./...` from turn 1 elided — 12 failures]") {
+		t.Errorf("Expected the test output stubbed, got %q", relevantOut["call_test"])
+	}
+	if !strings.Contains(relevantOut["call_ls"], "[output of `ls -R` from turn 1 elided — 110 lines]") {
+		t.Errorf("Expected the listing stubbed, got %q", relevantOut["call_ls"])
+	}
+	if _, ok := ParseToolResult(relevantOut["call_ls"]); !ok {
+		t.Errorf("Expected the stub to be a tool result, got %q", relevantOut["call_ls"])
+	}
+}
+
+func TestRelevancePackingDropsWhatStubsCannotFit(t *testing.T) {
+	h := packingHistory(t)
+	retained := 0
+	for i, kept := range h.retained() {
+		if kept {
+			retained += estimateMessageTokens(h.Messages[i])
+		}
+	}
+
+	packed := RelevancePacker{}.Pack(h, retained)
+	validPairing(t, "relevance", packed)
+	if len(toolContents(packed)) != 0 {
+		t.Errorf("Expected only the retained messages without room for stubs, got %+v", packed)
+	}
+	if got := (RelevancePacker{}).Pack(h, 1<<20); len(got) != len(h.Messages) {
+		t.Errorf("Expected everything sent when it fits, got %d of %d messages", len(got), len(h.Messages))
+	}
+}
+
+func TestContextStrategyPacksRequestsWithoutPruning(t *testing.T) {
+	b, err := NewOpenAIAgent(&config.Config{APIKey: "test", Model: "gpt-4o", ContextStrategy: config.ContextRelevance}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	if _, ok := b.history.Packer.(RelevancePacker); !ok {
+		t.Fatalf("Expected context_strategy relevance to pack by relevance, got %T", b.history.Packer)
+	}
+
+	a, fake := newFakeOpenAIAgent(t, "Nothing else.")
+	h := packingHistory(t)
+	h.Packer = b.history.Packer
+	h.MaxTokenCount = h.EstimateTokenCount() / 2
+	a.SetHistory(h)
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Really?"}}, collectItems(new([]ResponseItem))); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if got := toolContents(h.Messages); len(got) != 4 || !strings.Contains(got["call_ls"], "nested/file.go") {
+		t.Errorf("Expected the history kept whole, got %d tool results", len(got))
+	}
+	var stubbed bool
+	for _, msg := range fake.lastRequest().Messages {
+		stubbed = stubbed || (msg.Role == openai.ChatMessageRoleTool && strings.Contains(msg.Content, "elided"))
+	}
+	if !stubbed {
+		t.Errorf("Expected the request to carry stubbed tool output")
+	}
+}
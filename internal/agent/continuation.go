@@ -0,0 +1,175 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// continuationPrompt asks the model to resume a reply cut off at the token limit
+const continuationPrompt = "Your previous reply was cut off at the output token limit. Continue exactly where you stopped, with no preamble and without repeating anything."
+
+// truncatedToolCallPrompt asks the model to make a tool call again after its
+// arguments were cut off at the token limit
+const truncatedToolCallPrompt = "Your previous tool call was cut off at the output token limit and was discarded. Make the call again; if its arguments are large, split the work into smaller calls, for example with begin_write and append_chunk."
+
+// errToolCallTruncated is returned by continuingStream.Recv when a response
+// was cut off inside a tool call. The tool calls and content received so far
+// must be discarded; the stream then yields the response to a new request.
+var errToolCallTruncated = errors.New("tool call cut off at the token limit")
+
+// continuingStream streams a response and, when the model stops at the token
+// limit, requests the rest of it. Text is continued: the chunks of the
+// continuation follow the ones already received, so content accumulated by
+// the caller is stitched together. A tool call cannot be stitched; it is
+// requested again and the caller is told to start over with errToolCallTruncated.
+type continuingStream struct {
+	agent    *OpenAIAgent
+	ctx      context.Context
+	handler  ResponseHandler
+	base     []openai.ChatCompletionMessage // Messages of the original request
+	req      openai.ChatCompletionRequest   // Request being streamed
+	stream   ChatStream
+	limit    int // Continuations allowed for the response
+	used     int
+	content  string // Text of the response so far, across requests
+	received string // Text received from the current request
+	toolCall bool   // Whether the current request produced tool call deltas
+	usage    *openai.Usage
+	pending  string // "text" or "tool_call" once the current request was cut off
+}
+
+// openStream creates a stream for req that continues responses cut off at the token limit
+func (a *OpenAIAgent) openStream(ctx context.Context, req openai.ChatCompletionRequest, handler ResponseHandler) (*continuingStream, error) {
+	if err := a.throttle(ctx, req); err != nil {
+		return nil, err
+	}
+	stream, err := a.openChatStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &continuingStream{
+		agent:   a,
+		ctx:     ctx,
+		handler: handler,
+		base:    req.Messages,
+		req:     req,
+		stream:  stream,
+		limit:   maxContinuations(a.config),
+	}, nil
+}
+
+// maxContinuations is how many continuation requests a response may take
+func maxContinuations(cfg *config.Config) int {
+	switch {
+	case cfg.MaxContinuations < 0:
+		return 0
+	case cfg.MaxContinuations == 0:
+		return config.DefaultMaxContinuations
+	}
+	return cfg.MaxContinuations
+}
+
+// Recv returns the next chunk of the response
+func (s *continuingStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	for {
+		response, err := s.stream.Recv()
+		if errors.Is(err, io.EOF) && s.pending != "" {
+			truncatedToolCall := s.pending == "tool_call"
+			if err := s.continueResponse(); err != nil {
+				return openai.ChatCompletionStreamResponse{}, err
+			}
+			if truncatedToolCall {
+				return openai.ChatCompletionStreamResponse{}, errToolCallTruncated
+			}
+			continue
+		}
+		if err != nil {
+			return response, err
+		}
+
+		// What follows the cut-off chunk only matters for usage accounting
+		if s.pending != "" {
+			if response.Usage != nil {
+				s.usage = response.Usage
+			}
+			continue
+		}
+
+		if len(response.Choices) > 0 {
+			choice := &response.Choices[0]
+			if len(choice.Delta.ToolCalls) > 0 {
+				s.toolCall = true
+			}
+			s.content += choice.Delta.Content
+			s.received += choice.Delta.Content
+			if finishReasonOf(*choice) == FinishReasonLength {
+				s.cutOff(choice)
+			}
+		}
+		return response, nil
+	}
+}
+
+// cutOff decides what to do with a response that stopped at the token limit.
+// When it is continued, the finish reason is removed from choice.
+func (s *continuingStream) cutOff(choice *openai.ChatCompletionStreamChoice) {
+	a := s.agent
+	if s.used >= s.limit || a.checkBudget() != nil {
+		a.logger.Log("[WARN] Agent.openStream: Response cut off at the token limit after %d continuation(s); not continuing.", s.used)
+		if s.limit > 0 {
+			sendWarning(s.handler, fmt.Sprintf("The response was cut off at the token limit after %d continuation(s).", s.used))
+		}
+		return
+	}
+	s.used++
+	s.pending = "text"
+	if s.toolCall {
+		s.pending = "tool_call"
+	}
+	choice.FinishReason = ""
+	a.logger.Log("[INFO] Agent.openStream: Response cut off at the token limit (%s); requesting continuation %d of %d.", s.pending, s.used, s.limit)
+}
+
+// continueResponse sends the request for the rest of a response that was cut off
+func (s *continuingStream) continueResponse() error {
+	a := s.agent
+	a.recordUsage(s.req, s.usage, s.received, s.handler)
+
+	messages := append([]openai.ChatCompletionMessage{}, s.base...)
+	if s.pending == "tool_call" {
+		// Start the response over; the partial arguments cannot be completed reliably
+		s.content = ""
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: truncatedToolCallPrompt})
+	} else {
+		messages = append(messages,
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: s.content},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: continuationPrompt},
+		)
+	}
+	s.req.Messages = messages
+
+	s.stream.Close()
+	if err := a.throttle(s.ctx, s.req); err != nil {
+		return fmt.Errorf("error creating continuation stream: %w", err)
+	}
+	stream, err := a.openChatStream(s.ctx, s.req)
+	if err != nil {
+		return fmt.Errorf("error creating continuation stream: %w", err)
+	}
+	s.stream = stream
+	s.received = ""
+	s.toolCall = false
+	s.usage = nil
+	s.pending = ""
+	return nil
+}
+
+// Close closes the stream of the current request
+func (s *continuingStream) Close() error {
+	return s.stream.Close()
+}
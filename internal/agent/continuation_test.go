@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTruncatedReplyIsContinued(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, truncatedReply("```go\nfunc main() {"), truncatedReply("\n\tfmt.Println(1)"), "\n}\n```")
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Write main"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	want := "```go\nfunc main() {\n\tfmt.Println(1)\n}\n```"
+	messages := a.history.GetMessages()
+	if last := messages[len(messages)-1]; last.Role != "assistant" || last.Content != want {
+		t.Errorf("Expected one stitched assistant message %q, got %+v", want, last)
+	}
+	var shown string
+	for _, item := range items {
+		if item.Type == "message" {
+			shown = item.Message.Content
+		}
+	}
+	if shown != want {
+		t.Errorf("Expected the handler to see the stitched reply, got %q", shown)
+	}
+
+	if len(fake.requests) != 3 {
+		t.Fatalf("Expected 2 continuation requests, got %d requests", len(fake.requests))
+	}
+	continuation := fake.requests[2].Messages
+	n := len(continuation)
+	if continuation[n-2].Role != "assistant" || continuation[n-2].Content != "```go\nfunc main() {\n\tfmt.Println(1)" || continuation[n-1].Content != continuationPrompt {
+		t.Errorf("Expected the continuation to carry the reply so far, got %+v", continuation[n-2:])
+	}
+	for _, msg := range messages {
+		if msg.Content == continuationPrompt {
+			t.Errorf("Expected the continuation prompt to stay out of the history")
+		}
+	}
+}
+
+func TestContinuationsAreCapped(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, truncatedReply("one"), truncatedReply(" two"), truncatedReply(" three"))
+	a.config.MaxContinuations = 1
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Count"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	if len(fake.requests) != 2 {
+		t.Errorf("Expected 1 continuation, got %d requests", len(fake.requests))
+	}
+	messages := a.history.GetMessages()
+	if last := messages[len(messages)-1]; last.Content != "one two" {
+		t.Errorf("Expected the reply up to the cap, got %q", last.Content)
+	}
+	warned := false
+	for _, item := range items {
+		warned = warned || (item.Type == "warning" && strings.Contains(item.Message.Content, "cut off"))
+	}
+	if !warned {
+		t.Errorf("Expected a warning that the reply was cut off")
+	}
+}
+
+func TestTruncatedToolCallIsRequestedAgain(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, truncatedReply(toolCallReply("write_file", `{"path":"a.go","content":"packa`)), toolCallReply("write_file", `{"path":"a.go","content":"package a\n"}`))
+	var items []ResponseItem
+	endedWithTools, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Write a.go"}}, collectItems(&items))
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if !endedWithTools {
+		t.Fatal("Expected the turn to end with the re-requested tool call")
+	}
+
+	var calls []*FunctionCall
+	for _, item := range items {
+		if item.Type == "function_call" {
+			calls = append(calls, item.FunctionCall)
+		}
+	}
+	if len(calls) != 1 || calls[0].ID != "call_2" || calls[0].Arguments != `{"path":"a.go","content":"package a\n"}` {
+		t.Fatalf("Expected only the complete call to reach the handler, got %+v", calls)
+	}
+	if a.IsToolCallPending("call_1") || !a.IsToolCallPending("call_2") {
+		t.Errorf("Expected only the complete call to be pending")
+	}
+
+	retry := fake.requests[1].Messages
+	if retry[len(retry)-1].Content != truncatedToolCallPrompt {
+		t.Errorf("Expected the retry to explain the cut-off call, got %q", retry[len(retry)-1].Content)
+	}
+	for _, msg := range a.history.GetMessages() {
+		for _, tc := range msg.ToolCalls {
+			if tc.ID == "call_1" {
+				t.Errorf("Expected the cut-off call to stay out of the history")
+			}
+		}
+	}
+}
+
+func TestTruncatedFollowUpIsContinued(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, toolCallReply("read_file", `{"path":"a.go"}`), truncatedReply("The file"), " declares package a.")
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Read a.go"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if err := a.SendFunctionResult(context.Background(), "call_1", "read_file", "package a", true); err != nil {
+		t.Fatalf("SendFunctionResult failed: %v", err)
+	}
+
+	messages := a.history.GetMessages()
+	if last := messages[len(messages)-1]; last.Content != "The file declares package a." {
+		t.Errorf("Expected the follow-up reply to be stitched, got %q", last.Content)
+	}
+}
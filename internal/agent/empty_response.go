@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// isEmptyResponse reports whether a response carried nothing: no answer text
+// and no tool calls. A response cut off at the token limit or withheld by the
+// provider is not empty, its finish reason says what happened.
+func isEmptyResponse(content string, toolCalls bool, finish FinishReason) bool {
+	return content == "" && !toolCalls && finish != FinishReasonLength && finish != FinishReasonContentFilter
+}
+
+// retryEmptyResponse reports whether an empty response should be requested
+// again: once per request when RetryEmptyResponse is set. Otherwise the empty
+// response is reported to handler and the turn ends.
+func (a *OpenAIAgent) retryEmptyResponse(handler ResponseHandler, finish FinishReason) bool {
+	a.mu.Lock()
+	retry := a.config.RetryEmptyResponse && !a.emptyRetried
+	a.emptyRetried = retry
+	a.mu.Unlock()
+	if retry {
+		a.logger.Log("[WARN] Agent: Empty response (finish reason %q); requesting it again.", finish)
+		return true
+	}
+	a.logger.Log("[WARN] Agent: Empty response (finish reason %q); ending the turn.", finish)
+	sendEmptyResponse(handler, finish)
+	return false
+}
+
+// resetEmptyRetry allows the next request an empty response retry again
+func (a *OpenAIAgent) resetEmptyRetry() {
+	a.mu.Lock()
+	a.emptyRetried = false
+	a.mu.Unlock()
+}
+
+// sendEmptyResponse emits an "empty_response" item, so the UI stops waiting
+// for a response that will not come
+func sendEmptyResponse(handler ResponseHandler, finish FinishReason) {
+	item := ResponseItem{
+		Type:         EventEmptyResponse,
+		Message:      &Message{Role: "system", Content: fmt.Sprintf("The model returned an empty response (finish reason %q).", finish)},
+		FinishReason: finish,
+	}
+	if data, err := json.Marshal(item); err == nil {
+		handler(string(data))
+	}
+}
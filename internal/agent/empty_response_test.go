@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"context"
+	"testing"
+)
+
+// countItems returns how many items of type typ were sent
+func countItems(items []ResponseItem, typ EventType) int {
+	n := 0
+	for _, item := range items {
+		if item.Type == typ {
+			n++
+		}
+	}
+	return n
+}
+
+func TestEmptyResponseReported(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "")
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "hello"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	if len(fake.requests) != 1 {
+		t.Errorf("Expected 1 request without retries, got %d", len(fake.requests))
+	}
+	if n := countItems(items, "empty_response"); n != 1 {
+		t.Fatalf("Expected 1 empty_response item, got %d in %+v", n, items)
+	}
+	if last := items[len(items)-1]; last.Type != "empty_response" || last.FinishReason != FinishReasonStop {
+		t.Errorf("Expected the empty_response item last with finish reason stop, got %+v", last)
+	}
+	messages := a.GetHistory().GetMessages()
+	if last := messages[len(messages)-1]; last.Role != "user" {
+		t.Errorf("Expected nothing added after the user message, got %+v", last)
+	}
+}
+
+func TestEmptyResponseRetriedOnce(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "", "Hello there")
+	a.config.RetryEmptyResponse = true
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "hello"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	if len(fake.requests) != 2 {
+		t.Errorf("Expected the empty response to be requested again, got %d requests", len(fake.requests))
+	}
+	if n := countItems(items, "empty_response"); n != 0 {
+		t.Errorf("Expected no empty_response item after a successful retry, got %d", n)
+	}
+	if reply, ok := a.GetLastAssistantMessage(); !ok || reply != "Hello there" {
+		t.Errorf("Expected the retried reply in the history, got %q", reply)
+	}
+
+	// The retry budget is per request, so a later empty response is retried again
+	fake.replies = []string{"", ""}
+	items = nil
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "again"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if len(fake.requests) != 4 {
+		t.Errorf("Expected 4 requests, got %d", len(fake.requests))
+	}
+	if n := countItems(items, "empty_response"); n != 1 {
+		t.Errorf("Expected 1 empty_response item after the retry came back empty too, got %d", n)
+	}
+}
+
+func TestEmptyFollowUpReported(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, toolCallReply("shell", `{"command":"ls"}`), "")
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "list files"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	var callID string
+	for _, item := range items {
+		if item.Type == "function_call" {
+			callID = item.FunctionCall.ID
+		}
+	}
+	items = nil
+	if err := a.SendFunctionResult(context.Background(), callID, "shell", "main.go", true); err != nil {
+		t.Fatalf("SendFunctionResult failed: %v", err)
+	}
+
+	if len(fake.requests) != 2 {
+		t.Errorf("Expected 2 requests, got %d", len(fake.requests))
+	}
+	if n := countItems(items, "empty_response"); n != 1 {
+		t.Fatalf("Expected 1 empty_response item, got %d in %+v", n, items)
+	}
+	if last := items[len(items)-1]; last.Type != "followup_complete" {
+		t.Errorf("Expected the follow-up to complete after the empty response, got %+v", last)
+	}
+	checkToolPairing(t, a.GetHistory().GetMessages())
+}
@@ -0,0 +1,258 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// EventSchemaVersion is the version of the ResponseItem schema the agent
+// emits, sent as schemaVersion on every item. It is bumped only by changes
+// that can break a consumer: removing or renaming a type or field, changing
+// a field's type, or making a field required. New event types and optional
+// fields keep the version; consumers ignore what they do not know. Every
+// change to the schema, breaking or not, needs an entry in docs/events.md.
+const EventSchemaVersion = 1
+
+// EventType is the type of a ResponseItem. Consumers must ignore items of
+// types they do not know, so new types can be added without a new version.
+type EventType string
+
+const (
+	// EventMessage is (an update of) the assistant's reply. Required: message.
+	// Optional: finishReason on the last item of a response, choice with choices > 1.
+	EventMessage EventType = "message"
+	// EventReasoning is (an update of) the reasoning the model put in think
+	// tags. Required: message.
+	EventReasoning EventType = "reasoning"
+	// EventFunctionCall is a tool call the host must answer. Required:
+	// functionCall. Optional: finishReason.
+	EventFunctionCall EventType = "function_call"
+	// EventFunctionCallProgress reports a tool call whose arguments are still
+	// streaming. Required: functionCall (ID and name only), progress.
+	EventFunctionCallProgress EventType = "function_call_progress"
+	// EventFunctionCallOutput is the output of a tool call the host ran, sent
+	// by the server and task runner. Required: functionOutput.
+	EventFunctionCallOutput EventType = "function_call_output"
+	// EventFollowupComplete ends the response to tool results when it made no
+	// further calls. It has no fields of its own.
+	EventFollowupComplete EventType = "followup_complete"
+	// EventWarning is a notice for the user. Required: message.
+	EventWarning EventType = "warning"
+	// EventError replaces an item a response hook failed on. Required: error.
+	EventError EventType = "error"
+	// EventToolAborted is a tool call the turn's cancellation left
+	// unanswered. Required: functionCall (ID and name only), error.
+	EventToolAborted EventType = "tool_aborted"
+	// EventTurnDiffStat summarizes the files changed over a turn. Required: diffStat.
+	EventTurnDiffStat EventType = "turn_diffstat"
+	// EventEmptyResponse is a response with neither text nor tool calls.
+	// Required: message. Optional: finishReason.
+	EventEmptyResponse EventType = "empty_response"
+	// EventQueuedMessagesSent is the queued messages sent as one user
+	// message. Required: message.
+	EventQueuedMessagesSent EventType = "queued_messages_sent"
+	// EventToolOutputFlagged is tool output that looks like a prompt
+	// injection. Required: message, functionOutput.
+	EventToolOutputFlagged EventType = "tool_output_flagged"
+	// EventUserInputRequired is a question the turn waits on. Required:
+	// message. Optional: functionCall, the ask_user call to answer.
+	EventUserInputRequired EventType = "user_input_required"
+	// EventRefusal is a response the model declined to give or the
+	// provider's content filter withheld. Required: message (what to show),
+	// refusal. Optional: finishReason.
+	EventRefusal EventType = "refusal"
+)
+
+// eventSpec lists the JSON fields of an event type beyond those every item
+// has (type, schemaVersion and thinkingDuration)
+type eventSpec struct {
+	required []string
+	optional []string
+}
+
+// eventSpecs is the schema of each event type, as documented on its constant
+var eventSpecs = map[EventType]eventSpec{
+	EventMessage:              {required: []string{"message"}, optional: []string{"finishReason", "choice"}},
+	EventReasoning:            {required: []string{"message"}},
+	EventFunctionCall:         {required: []string{"functionCall"}, optional: []string{"finishReason"}},
+	EventFunctionCallProgress: {required: []string{"functionCall", "progress"}},
+	EventFunctionCallOutput:   {required: []string{"functionOutput"}},
+	EventFollowupComplete:     {},
+	EventWarning:              {required: []string{"message"}},
+	EventError:                {required: []string{"error"}},
+	EventToolAborted:          {required: []string{"functionCall", "error"}},
+	EventTurnDiffStat:         {required: []string{"diffStat"}},
+	EventEmptyResponse:        {required: []string{"message"}, optional: []string{"finishReason"}},
+	EventQueuedMessagesSent:   {required: []string{"message"}},
+	EventToolOutputFlagged:    {required: []string{"message", "functionOutput"}},
+	EventUserInputRequired:    {required: []string{"message"}, optional: []string{"functionCall"}},
+	EventRefusal:              {required: []string{"message", "refusal"}, optional: []string{"finishReason"}},
+}
+
+// EventTypes returns every event type of the current schema version, sorted
+func EventTypes() []EventType {
+	types := make([]EventType, 0, len(eventSpecs))
+	for t := range eventSpecs {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// Known reports whether t is an event type of the current schema version
+func (t EventType) Known() bool {
+	_, ok := eventSpecs[t]
+	return ok
+}
+
+// MarshalJSON implements json.Marshaler, stamping items with the current
+// schema version
+func (item ResponseItem) MarshalJSON() ([]byte, error) {
+	type plain ResponseItem // Without the method, to avoid recursion
+	if item.SchemaVersion == 0 {
+		item.SchemaVersion = EventSchemaVersion
+	}
+	return json.Marshal(plain(item))
+}
+
+// finishReasons are the values finishReason takes when set
+var finishReasons = []FinishReason{FinishReasonStop, FinishReasonToolCalls, FinishReasonLength, FinishReasonContentFilter, FinishReasonOther}
+
+// EventJSONSchema returns the JSON Schema of ResponseItem, generated from the
+// Go structs and the event types' required fields. docs/events.schema.json
+// holds its output for the current version.
+func EventJSONSchema() ([]byte, error) {
+	defs := make(map[string]interface{})
+	root := structSchema(reflect.TypeOf(ResponseItem{}), defs)
+
+	properties := root["properties"].(map[string]interface{})
+	var types []interface{}
+	for _, t := range EventTypes() {
+		types = append(types, string(t))
+	}
+	properties["type"] = map[string]interface{}{"type": "string", "enum": types}
+	properties["schemaVersion"] = map[string]interface{}{"type": "integer", "const": EventSchemaVersion}
+
+	var rules []interface{}
+	for _, t := range EventTypes() {
+		spec := eventSpecs[t]
+		then := map[string]interface{}{"required": stringList(spec.required)}
+		if len(spec.optional) > 0 {
+			then["description"] = "Optional: " + strings.Join(spec.optional, ", ")
+		}
+		rules = append(rules, map[string]interface{}{
+			"if":   map[string]interface{}{"properties": map[string]interface{}{"type": map[string]interface{}{"const": string(t)}}},
+			"then": then,
+		})
+	}
+
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = "ResponseItem"
+	root["description"] = fmt.Sprintf("Event emitted by the agent, schema version %d. Ignore unknown types and fields.", EventSchemaVersion)
+	root["allOf"] = rules
+	root["$defs"] = defs
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event schema: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// EventSchemaFingerprint returns a short hash of the event schema, recorded
+// with each entry of the changelog in docs/events.md
+func EventSchemaFingerprint() (string, error) {
+	schema, err := EventJSONSchema()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(schema)
+	return hex.EncodeToString(sum[:])[:12], nil
+}
+
+// structSchema returns the schema of a struct type, with the schemas of the
+// structs it refers to added to defs
+func structSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, omitEmpty := jsonFieldName(field)
+		if name == "-" {
+			continue
+		}
+		properties[name] = typeSchema(field.Type, defs)
+		if !omitEmpty {
+			required = append(required, name)
+		}
+	}
+	return map[string]interface{}{"type": "object", "properties": properties, "required": stringList(required)}
+}
+
+// typeSchema returns the schema of a field's type
+func typeSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(FinishReason("")):
+		var values []interface{}
+		for _, r := range finishReasons {
+			values = append(values, string(r))
+		}
+		return map[string]interface{}{"type": "string", "enum": values}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), defs)
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": []interface{}{"array", "null"}, "items": typeSchema(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": []interface{}{"object", "null"}, "additionalProperties": typeSchema(t.Elem(), defs)}
+	case reflect.Struct:
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = nil // Placeholder, in case the struct refers to itself
+			defs[t.Name()] = structSchema(t, defs)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// jsonFieldName returns the JSON name of a struct field and whether it is
+// omitted when empty
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "" {
+		return field.Name, false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, strings.Contains(","+opts+",", ",omitempty,")
+}
+
+// stringList converts names to a JSON array
+func stringList(names []string) []interface{} {
+	list := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		list = append(list, name)
+	}
+	return list
+}
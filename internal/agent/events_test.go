@@ -0,0 +1,250 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/fileops"
+)
+
+// eventDocs is the schema guide and changelog; eventSchemaFile holds the
+// output of EventJSONSchema. UPDATE_EVENT_SCHEMA=1 rewrites the schema file.
+const (
+	eventDocs       = "../../docs/events.md"
+	eventSchemaFile = "../../docs/events.schema.json"
+)
+
+// sampleEvents returns an item of every event type, as the agent sends them
+func sampleEvents() []ResponseItem {
+	call := &FunctionCall{ID: "call_1", Name: "shell", Arguments: `{"command":"ls"}`}
+	output := &FunctionCallOutput{CallID: "call_1", Output: "README.md", Success: true}
+	return []ResponseItem{
+		{Type: EventMessage, Message: &Message{Role: "assistant", Content: "Done."}, ThinkingDuration: 1200, FinishReason: FinishReasonStop},
+		{Type: EventMessage, Message: &Message{Role: "assistant", Content: "Another take."}, Choice: 1},
+		{Type: EventReasoning, Message: &Message{Role: "assistant", Content: "Let me look."}, ThinkingDuration: 300},
+		{Type: EventFunctionCall, FunctionCall: call, FinishReason: FinishReasonToolCalls},
+		{Type: EventFunctionCallProgress, FunctionCall: &FunctionCall{ID: "call_1", Name: "write_file"}, Progress: &CallProgress{Bytes: 4096, Path: "main.go"}},
+		{Type: EventFunctionCallOutput, FunctionOutput: output},
+		{Type: EventFollowupComplete},
+		{Type: EventWarning, Message: &Message{Role: "system", Content: "Unknown tool."}},
+		{Type: EventError, Error: "response hook failed on message item: boom"},
+		{Type: EventToolAborted, FunctionCall: &FunctionCall{ID: "call_1", Name: "shell"}, Error: abortedToolReason},
+		{Type: EventTurnDiffStat, DiffStat: &fileops.DiffStat{Files: []fileops.FileStat{{Path: "main.go", Status: "modified", Added: 3, Removed: 1}}, Added: 3, Removed: 1}},
+		{Type: EventEmptyResponse, Message: &Message{Role: "system", Content: "The model returned an empty response."}, FinishReason: FinishReasonContentFilter},
+		{Type: EventQueuedMessagesSent, Message: &Message{Role: "user", Content: "also this"}},
+		{Type: EventToolOutputFlagged, Message: &Message{Role: "system", Content: "Looks like an injection."}, FunctionOutput: output},
+		{Type: EventUserInputRequired, Message: &Message{Role: "assistant", Content: "Which file?"}, FunctionCall: call},
+		{Type: EventRefusal, Message: &Message{ID: "msg_1", Role: "assistant", Content: "I can't help with that."}, Refusal: &Refusal{Category: RefusalCategoryModel, Text: "I can't help with that."}, FinishReason: FinishReasonStop},
+	}
+}
+
+func TestEventSchemaIsUpToDate(t *testing.T) {
+	schema, err := EventJSONSchema()
+	if err != nil {
+		t.Fatalf("EventJSONSchema failed: %v", err)
+	}
+	if os.Getenv("UPDATE_EVENT_SCHEMA") != "" {
+		if err := os.WriteFile(eventSchemaFile, schema, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	committed, err := os.ReadFile(eventSchemaFile)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", eventSchemaFile, err)
+	}
+	if !bytes.Equal(committed, schema) {
+		t.Errorf("%s is out of date with ResponseItem: run UPDATE_EVENT_SCHEMA=1 go test ./internal/agent -run TestEventSchemaIsUpToDate and add a changelog entry to %s", filepath.Base(eventSchemaFile), filepath.Base(eventDocs))
+	}
+}
+
+func TestEventChangelogRecordsSchema(t *testing.T) {
+	fingerprint, err := EventSchemaFingerprint()
+	if err != nil {
+		t.Fatalf("EventSchemaFingerprint failed: %v", err)
+	}
+	docs, err := os.ReadFile(eventDocs)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", eventDocs, err)
+	}
+
+	// The changelog's latest entry must be for the current version and schema
+	entry := regexp.MustCompile(`(?m)^### Version (\d+) \(schema ([0-9a-f]{12})\)`).FindSubmatch(docs)
+	if entry == nil {
+		t.Fatalf("Expected a changelog entry in %s", eventDocs)
+	}
+	if string(entry[1]) != fmt.Sprint(EventSchemaVersion) || string(entry[2]) != fingerprint {
+		t.Errorf("The event schema changed: add a changelog entry \"### Version %d (schema %s)\" at the top of the changelog in %s, and bump EventSchemaVersion first if the change can break consumers (latest entry: version %s, schema %s)",
+			EventSchemaVersion, fingerprint, eventDocs, entry[1], entry[2])
+	}
+
+	for _, typ := range EventTypes() {
+		if !bytes.Contains(docs, []byte("| `"+string(typ)+"` |")) {
+			t.Errorf("Expected %s to document the %q event type", eventDocs, typ)
+		}
+	}
+}
+
+func TestEveryEventTypeMatchesSchema(t *testing.T) {
+	schemaJSON, err := EventJSONSchema()
+	if err != nil {
+		t.Fatalf("EventJSONSchema failed: %v", err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(schemaJSON, &schema); err != nil {
+		t.Fatalf("Schema is not valid JSON: %v", err)
+	}
+
+	covered := make(map[EventType]bool)
+	for _, item := range sampleEvents() {
+		covered[item.Type] = true
+		data, err := json.Marshal(item)
+		if err != nil {
+			t.Fatalf("Failed to marshal %s item: %v", item.Type, err)
+		}
+		var value interface{}
+		json.Unmarshal(data, &value)
+		if errs := validateSchema(schema, schema, value, "$"); len(errs) > 0 {
+			t.Errorf("%s item does not match the schema: %s\n%s", item.Type, strings.Join(errs, "; "), data)
+		}
+	}
+	for _, typ := range EventTypes() {
+		if !covered[typ] {
+			t.Errorf("No sample of the %q event type; add one to sampleEvents", typ)
+		}
+	}
+
+	// An item missing a field its type requires is rejected
+	var value interface{}
+	data, _ := json.Marshal(ResponseItem{Type: EventToolAborted, Error: "cancelled"})
+	json.Unmarshal(data, &value)
+	if errs := validateSchema(schema, schema, value, "$"); len(errs) == 0 {
+		t.Errorf("Expected a tool_aborted item without functionCall to fail validation")
+	}
+}
+
+func TestResponseItemCarriesSchemaVersion(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, "Hello")
+	var raw []string
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Hi"}}, func(itemJSON string) { raw = append(raw, itemJSON) }); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if len(raw) == 0 {
+		t.Fatal("Expected items")
+	}
+	for _, itemJSON := range raw {
+		var item ResponseItem
+		if err := json.Unmarshal([]byte(itemJSON), &item); err != nil {
+			t.Fatalf("Failed to parse %s: %v", itemJSON, err)
+		}
+		if item.SchemaVersion != EventSchemaVersion || !item.Type.Known() {
+			t.Errorf("Expected a known type at schema version %d, got %s", EventSchemaVersion, itemJSON)
+		}
+	}
+	if EventType("future_event").Known() {
+		t.Errorf("Expected an unknown type not to be known")
+	}
+}
+
+// validateSchema checks value against the subset of JSON Schema that
+// EventJSONSchema generates, returning the violations
+func validateSchema(root, schema map[string]interface{}, value interface{}, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/$defs/")
+		def, ok := root["$defs"].(map[string]interface{})[name].(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: unresolved $ref %s", path, ref)}
+		}
+		return validateSchema(root, def, value, path)
+	}
+
+	var errs []string
+	if typ, ok := schema["type"]; ok && !matchesType(typ, value) {
+		return []string{fmt.Sprintf("%s: expected type %v, got %T", path, typ, value)}
+	}
+	if c, ok := schema["const"]; ok && fmt.Sprint(c) != fmt.Sprint(value) {
+		errs = append(errs, fmt.Sprintf("%s: expected %v, got %v", path, c, value))
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || fmt.Sprint(e) == fmt.Sprint(value)
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: %v is not one of %v", path, value, enum))
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := v[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required %s", path, name))
+			}
+		}
+		if props, ok := schema["properties"].(map[string]interface{}); ok {
+			for name, field := range v {
+				if prop, ok := props[name].(map[string]interface{}); ok {
+					errs = append(errs, validateSchema(root, prop, field, path+"."+name)...)
+				}
+			}
+		}
+		if extra, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+			for name, field := range v {
+				errs = append(errs, validateSchema(root, extra, field, path+"."+name)...)
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, elem := range v {
+				errs = append(errs, validateSchema(root, items, elem, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+
+	if rules, ok := schema["allOf"].([]interface{}); ok {
+		for _, r := range rules {
+			rule := r.(map[string]interface{})
+			cond, _ := rule["if"].(map[string]interface{})
+			if cond != nil && len(validateSchema(root, cond, value, path)) == 0 {
+				errs = append(errs, validateSchema(root, rule["then"].(map[string]interface{}), value, path)...)
+			}
+		}
+	}
+	return errs
+}
+
+// matchesType reports whether value is of the JSON Schema type typ, a name
+// or a list of names
+func matchesType(typ interface{}, value interface{}) bool {
+	if list, ok := typ.([]interface{}); ok {
+		for _, t := range list {
+			if matchesType(t, value) {
+				return true
+			}
+		}
+		return false
+	}
+	switch v := value.(type) {
+	case nil:
+		return typ == "null"
+	case string:
+		return typ == "string"
+	case bool:
+		return typ == "boolean"
+	case float64:
+		return typ == "number" || (typ == "integer" && v == float64(int64(v)))
+	case []interface{}:
+		return typ == "array"
+	case map[string]interface{}:
+		return typ == "object"
+	}
+	return false
+}
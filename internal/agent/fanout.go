@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"runtime/debug"
+	"sync"
+
+	"github.com/epuerta/codex-go/internal/logging"
+)
+
+// DefaultObserverQueueSize is how many items an additional response handler
+// may fall behind the stream before items are dropped for it
+const DefaultObserverQueueSize = 1024
+
+// observer is an additional response handler fed from its own queue, so a slow
+// or panicking observer cannot stall the stream or the other handlers
+type observer struct {
+	handler ResponseHandler
+	queue   chan string
+	done    chan struct{}
+	dropped int // Items dropped because the queue was full; guarded by observerSet.mu
+}
+
+// observerSet holds the additional response handlers of an agent
+type observerSet struct {
+	mu        sync.Mutex
+	observers []*observer
+}
+
+// AddResponseHandler registers a handler that receives every response item
+// alongside the handler passed to SendMessage, e.g. to record the stream while
+// the UI renders it. Items are delivered in order on the observer's own
+// goroutine; if it falls more than DefaultObserverQueueSize items behind, newer
+// items are dropped for it rather than blocking the stream. A panic in the
+// handler is logged and delivery continues with the next item. The returned
+// function unregisters the handler after delivering the items already queued;
+// it must not be called from the handler itself.
+func (a *OpenAIAgent) AddResponseHandler(handler ResponseHandler) (remove func()) {
+	o := &observer{
+		handler: handler,
+		queue:   make(chan string, DefaultObserverQueueSize),
+		done:    make(chan struct{}),
+	}
+	go o.run(a.logger)
+
+	a.observers.mu.Lock()
+	a.observers.observers = append(a.observers.observers[:len(a.observers.observers):len(a.observers.observers)], o)
+	a.observers.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { a.observers.remove(o) })
+	}
+}
+
+// tee returns a handler that queues each item for the observers, then calls
+// primary. A panic in primary is logged instead of ending the stream.
+func (s *observerSet) tee(primary ResponseHandler, logger logging.Logger) ResponseHandler {
+	return func(itemJSON string) {
+		s.publish(itemJSON, logger)
+		if primary != nil {
+			safeDispatch(primary, itemJSON, logger, "response handler")
+		}
+	}
+}
+
+// publish queues an item for every observer without blocking
+func (s *observerSet) publish(itemJSON string, logger logging.Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range s.observers {
+		select {
+		case o.queue <- itemJSON:
+		default:
+			o.dropped++
+			if o.dropped == 1 || o.dropped%DefaultObserverQueueSize == 0 {
+				logger.Log("[WARN] Agent.observers: Response handler is falling behind; %d items dropped", o.dropped)
+			}
+		}
+	}
+}
+
+// remove unregisters o and waits for it to deliver its queued items
+func (s *observerSet) remove(o *observer) {
+	s.mu.Lock()
+	kept := make([]*observer, 0, len(s.observers))
+	for _, existing := range s.observers {
+		if existing != o {
+			kept = append(kept, existing)
+		}
+	}
+	s.observers = kept
+	s.mu.Unlock()
+
+	close(o.queue)
+	<-o.done
+}
+
+// closeAll unregisters every observer after delivering their queued items
+func (s *observerSet) closeAll() {
+	s.mu.Lock()
+	observers := s.observers
+	s.observers = nil
+	s.mu.Unlock()
+
+	for _, o := range observers {
+		close(o.queue)
+		<-o.done
+	}
+}
+
+// run delivers queued items to the observer's handler until the queue is closed
+func (o *observer) run(logger logging.Logger) {
+	defer close(o.done)
+	for itemJSON := range o.queue {
+		safeDispatch(o.handler, itemJSON, logger, "additional response handler")
+	}
+}
+
+// safeDispatch calls handler, recovering and logging a panic
+func safeDispatch(handler ResponseHandler, itemJSON string, logger logging.Logger, name string) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Log("[ERROR] Agent: %s panicked: %v\n%s", name, r, debug.Stack())
+		}
+	}()
+	handler(itemJSON)
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAdditionalHandlersReceiveEveryItem(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, "Hello there")
+
+	var recorded, panicking []ResponseItem
+	removeRecorder := a.AddResponseHandler(collectItems(&recorded))
+	calls := 0
+	removePanicking := a.AddResponseHandler(func(itemJSON string) {
+		calls++
+		if calls == 1 {
+			panic("observer bug")
+		}
+		collectItems(&panicking)(itemJSON)
+	})
+
+	var primary []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Hi"}}, collectItems(&primary)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	removeRecorder()
+	removePanicking()
+
+	if len(primary) == 0 {
+		t.Fatalf("Expected the primary handler to receive items")
+	}
+	if len(recorded) != len(primary) {
+		t.Errorf("Expected the recorder to receive all %d items, got %d", len(primary), len(recorded))
+	}
+	if calls != len(primary) || len(panicking) != len(primary)-1 {
+		t.Errorf("Expected delivery to continue after a panic, got %d calls and %d items", calls, len(panicking))
+	}
+
+	// Removed handlers no longer receive items
+	before := len(recorded)
+	a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Again"}}, collectItems(&primary))
+	if len(recorded) != before {
+		t.Errorf("Expected a removed handler to receive nothing, got %d new items", len(recorded)-before)
+	}
+}
+
+func TestSlowHandlerDoesNotStallStream(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, "A streamed reply")
+
+	release := make(chan struct{})
+	var slow []ResponseItem
+	remove := a.AddResponseHandler(func(itemJSON string) {
+		<-release
+		collectItems(&slow)(itemJSON)
+	})
+
+	done := make(chan struct{})
+	var primary []ResponseItem
+	go func() {
+		a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Hi"}}, func(itemJSON string) {
+			panic("renderer bug")
+		})
+		a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Again"}}, collectItems(&primary))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the stream to finish while a handler is blocked")
+	}
+	if len(primary) == 0 {
+		t.Errorf("Expected the agent to keep working after the primary handler panicked")
+	}
+
+	close(release)
+	remove()
+	if len(slow) == 0 {
+		t.Errorf("Expected the slow handler to receive the queued items once unblocked")
+	}
+}
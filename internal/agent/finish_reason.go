@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// FinishReason is why the model stopped generating a response, normalized
+// across providers so that logic depending on it does not need to know which
+// API the response came from
+type FinishReason string
+
+const (
+	FinishReasonNone          FinishReason = ""               // The response has not finished
+	FinishReasonStop          FinishReason = "stop"           // The model ended its reply
+	FinishReasonToolCalls     FinishReason = "tool_calls"     // The model is waiting for tool results
+	FinishReasonLength        FinishReason = "length"         // The output token limit was reached
+	FinishReasonContentFilter FinishReason = "content_filter" // The provider withheld the output
+	FinishReasonOther         FinishReason = "other"          // Any reason not recognized
+)
+
+// finishReasonAliases maps the lowercased finish reasons of the providers
+// reached through OpenAI-compatible APIs to their normalized value
+var finishReasonAliases = map[string]FinishReason{
+	// OpenAI
+	"stop":           FinishReasonStop,
+	"tool_calls":     FinishReasonToolCalls,
+	"function_call":  FinishReasonToolCalls,
+	"length":         FinishReasonLength,
+	"content_filter": FinishReasonContentFilter,
+	// Anthropic
+	"end_turn":      FinishReasonStop,
+	"stop_sequence": FinishReasonStop,
+	"tool_use":      FinishReasonToolCalls,
+	"max_tokens":    FinishReasonLength,
+	"refusal":       FinishReasonContentFilter,
+	// Gemini
+	"safety":     FinishReasonContentFilter,
+	"recitation": FinishReasonContentFilter,
+	"blocklist":  FinishReasonContentFilter,
+	// Local servers (Ollama, llama.cpp, vLLM)
+	"eos":          FinishReasonStop,
+	"model_length": FinishReasonLength,
+}
+
+// NormalizeFinishReason maps a provider's finish reason to a FinishReason.
+// An empty or "null" reason means the response has not finished.
+func NormalizeFinishReason(reason string) FinishReason {
+	reason = strings.ToLower(strings.TrimSpace(reason))
+	if reason == "" || reason == "null" {
+		return FinishReasonNone
+	}
+	if normalized, ok := finishReasonAliases[reason]; ok {
+		return normalized
+	}
+	return FinishReasonOther
+}
+
+// finishReasonOf returns the normalized finish reason of a streamed choice
+func finishReasonOf(choice openai.ChatCompletionStreamChoice) FinishReason {
+	return NormalizeFinishReason(string(choice.FinishReason))
+}
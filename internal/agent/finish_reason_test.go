@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNormalizeFinishReason(t *testing.T) {
+	tests := map[string]FinishReason{
+		"":               FinishReasonNone,
+		"null":           FinishReasonNone,
+		"stop":           FinishReasonStop,
+		"end_turn":       FinishReasonStop,
+		"STOP":           FinishReasonStop,
+		"tool_calls":     FinishReasonToolCalls,
+		"function_call":  FinishReasonToolCalls,
+		"tool_use":       FinishReasonToolCalls,
+		"length":         FinishReasonLength,
+		"max_tokens":     FinishReasonLength,
+		"MAX_TOKENS":     FinishReasonLength,
+		"content_filter": FinishReasonContentFilter,
+		"SAFETY":         FinishReasonContentFilter,
+		"something_new":  FinishReasonOther,
+	}
+	for raw, want := range tests {
+		if got := NormalizeFinishReason(raw); got != want {
+			t.Errorf("NormalizeFinishReason(%q): expected %q, got %q", raw, want, got)
+		}
+	}
+}
+
+func TestLastItemOfResponseCarriesFinishReason(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, "All done.", toolCallReply("shell", `{"command":"ls"}`))
+
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Hi"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if len(items) == 0 {
+		t.Fatalf("Expected message items")
+	}
+	last := items[len(items)-1]
+	if last.Type != "message" || last.Message.Content != "All done." || last.FinishReason != FinishReasonStop {
+		t.Errorf("Expected the final message to finish with stop, got %+v", last)
+	}
+	for _, item := range items[:len(items)-1] {
+		if item.FinishReason != FinishReasonNone {
+			t.Errorf("Expected only the last item to carry a finish reason, got %+v", item)
+		}
+	}
+
+	items = nil
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "List"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if len(items) != 1 || items[0].Type != "function_call" || items[0].FinishReason != FinishReasonToolCalls {
+		t.Errorf("Expected a function call finishing with tool_calls, got %+v", items)
+	}
+}
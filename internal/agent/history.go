@@ -3,23 +3,28 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/sashabaranov/go-openai"
+	"github.com/google/uuid"
 )
 
 // HistoryOptions defines options for conversation history management
 type HistoryOptions struct {
-	MaxTokenCount int    // Maximum number of tokens to keep in history
-	SessionID     string // Unique ID for this conversation session
-	HistoryPath   string // Path to store history files
-	EnablePersist bool   // Whether to persist history to disk
-	SystemPrompt  string // System prompt to prepend to history
+	MaxTokenCount int             // Maximum number of tokens to keep in history
+	SessionID     string          // Unique ID for this conversation session
+	HistoryPath   string          // Path to store history files
+	EnablePersist bool            // Whether to persist history to disk
+	SystemPrompt  string          // System prompt to prepend to history
+	Retention     RetentionPolicy // What pruning keeps however old it is
+	Packer        ContextPacker   // Packs each request instead of pruning the history; nil prunes
+	Lock          WriteLock       // Held while the history is written to disk; nil when unlocked
+	Summarizer    Summarizer      // Summarizes what pruning dropped; nil counts the messages instead
 }
 
 // DefaultHistoryOptions returns the default options for history management
@@ -29,6 +34,7 @@ func DefaultHistoryOptions() HistoryOptions {
 		SessionID:     "default", // Default session ID
 		HistoryPath:   "",        // Empty means no persistence
 		EnablePersist: false,     // Disabled by default
+		Retention:     DefaultRetentionPolicy(),
 		SystemPrompt: `You are a sophisticated AI coding assistant designed to help with software development tasks in the user's current project context.
 
 Your primary goal is to fulfill the user's request, which may require multiple steps and the use of available tools.
@@ -49,18 +55,51 @@ Format code blocks and technical details appropriately using markdown. Be concis
 	}
 }
 
+// Summarizer asks a model for a summary of a conversation transcript
+type Summarizer func(ctx context.Context, transcript string) (string, error)
+
 // ConversationHistory manages the conversation history between the user and AI
 type ConversationHistory struct {
-	Messages       []Message `json:"messages"`
-	MaxTokenCount  int       `json:"max_token_count"`
-	CurrentTokens  int       `json:"current_tokens"`
-	CurrentSession string    `json:"current_session"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
-	EnablePersist  bool      `json:"-"` // Not stored in JSON
-	HistoryPath    string    `json:"-"` // Not stored in JSON
+	FormatVersion  int             `json:"format_version"` // HistoryFormatVersion when saved; 0 in files from before versioning
+	Messages       []Message       `json:"messages"`
+	MaxTokenCount  int             `json:"max_token_count"`
+	CurrentTokens  int             `json:"current_tokens"`
+	CurrentSession string          `json:"current_session"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	Interrupted    bool            `json:"interrupted,omitempty"`     // Session ended by a signal mid-turn
+	Import         *ImportInfo     `json:"import,omitempty"`          // Where an imported session came from
+	Tombstones     []Tombstone     `json:"tombstones,omitempty"`      // Messages redacted or deleted
+	WorkingDir     string          `json:"working_dir,omitempty"`     // Session working directory, "" for the workspace root
+	QueuedMessages []string        `json:"queued_messages,omitempty"` // User messages waiting for the current turn to end
+	BlobDir        string          `json:"blob_dir,omitempty"`        // Blob store holding the content of large messages
+	EnablePersist  bool            `json:"-"`                         // Not stored in JSON
+	HistoryPath    string          `json:"-"`                         // Not stored in JSON
+	Retention      RetentionPolicy `json:"-"`                         // What pruning keeps however old it is
+	Packer         ContextPacker   `json:"-"`                         // Packs each request instead of pruning the history; nil prunes
+	Lock           WriteLock       `json:"-"`                         // Held while the history is written to disk
+	Summarizer     Summarizer      `json:"-"`                         // Summarizes what pruning dropped
+
+	rewrites uint64 // Bumped whenever existing messages are changed or removed
+
+	journal *sessionJournal // Write-ahead journal of changes, when autosave is enabled
+
+	// Tool calls still awaiting results, valid for the history as of openCallsAt/openCallsRev
+	openCalls    map[string]bool
+	openCallsAt  int
+	openCallsRev uint64
 }
 
+var (
+	// ErrOrphanToolResult is returned when a tool result has no matching unanswered tool call
+	ErrOrphanToolResult = errors.New("tool result without a matching tool call")
+	// ErrInvalidToolCall is returned when an assistant tool call cannot be answered
+	ErrInvalidToolCall = errors.New("invalid tool call")
+	// ErrUnansweredToolCalls flags a message added while earlier tool calls still
+	// lack results. The message is added; the error only reports the inconsistency.
+	ErrUnansweredToolCalls = errors.New("earlier tool calls have no results")
+)
+
 // NewConversationHistory creates a new conversation history with the given options
 func NewConversationHistory(opts HistoryOptions) (*ConversationHistory, error) {
 	history := &ConversationHistory{
@@ -72,6 +111,10 @@ func NewConversationHistory(opts HistoryOptions) (*ConversationHistory, error) {
 		UpdatedAt:      time.Now(),
 		EnablePersist:  opts.EnablePersist,
 		HistoryPath:    opts.HistoryPath,
+		Retention:      opts.Retention,
+		Packer:         opts.Packer,
+		Lock:           opts.Lock,
+		Summarizer:     opts.Summarizer,
 	}
 
 	// If persistence is enabled, try to load existing history
@@ -85,6 +128,9 @@ func NewConversationHistory(opts HistoryOptions) (*ConversationHistory, error) {
 					// Update the history path and persistence flag
 					history.HistoryPath = opts.HistoryPath
 					history.EnablePersist = opts.EnablePersist
+					if err := history.Migrate(); err != nil {
+						return nil, fmt.Errorf("failed to load history %s: %w", historyFile, err)
+					}
 					return history, nil
 				}
 			}
@@ -96,6 +142,8 @@ func NewConversationHistory(opts HistoryOptions) (*ConversationHistory, error) {
 		}
 	}
 
+	history.FormatVersion = HistoryFormatVersion
+
 	// Add system prompt if provided
 	if opts.SystemPrompt != "" {
 		history.AddMessage(Message{
@@ -109,14 +157,35 @@ func NewConversationHistory(opts HistoryOptions) (*ConversationHistory, error) {
 
 // AddMessage adds a single message to the history
 func (h *ConversationHistory) AddMessage(message Message) {
+	h.add(message)
+}
+
+// AppendMessage adds a single message to the history after checking it against
+// the tool calls still awaiting results. Tool results without a matching
+// unanswered tool call and tool calls without IDs are rejected with
+// ErrOrphanToolResult or ErrInvalidToolCall. A message that interrupts
+// unanswered tool calls is added but flagged with ErrUnansweredToolCalls.
+func (h *ConversationHistory) AppendMessage(message Message) error {
+	flag, err := h.validateMessage(message)
+	if err != nil {
+		return err
+	}
+	h.add(message)
+	return flag
+}
+
+// add appends message and updates the token count, pruning and persistence
+func (h *ConversationHistory) add(message Message) {
 	h.Messages = append(h.Messages, message)
+	h.trackToolCalls(message)
 	h.UpdatedAt = time.Now()
 
-	// Update token count estimation
-	h.CurrentTokens = h.EstimateTokenCount()
+	// Update token count estimation incrementally; earlier messages are unchanged
+	h.CurrentTokens += estimateMessageTokens(message)
 
 	// Prune history if needed
 	h.pruneIfNeeded()
+	h.journalChanges()
 
 	// Save to disk if persistence is enabled
 	if h.EnablePersist && h.HistoryPath != "" {
@@ -124,6 +193,74 @@ func (h *ConversationHistory) AddMessage(message Message) {
 	}
 }
 
+// validateMessage checks a message against the tool calls still awaiting results.
+// It returns an error for messages that must be rejected, and a flag for messages
+// that are accepted despite an inconsistency.
+func (h *ConversationHistory) validateMessage(message Message) (flag error, err error) {
+	open := h.unansweredToolCalls()
+
+	if message.Role == "tool" {
+		if message.ToolCallID == "" {
+			return nil, fmt.Errorf("%w: tool result has no tool_call_id", ErrOrphanToolResult)
+		}
+		if !open[message.ToolCallID] {
+			return nil, fmt.Errorf("%w: no unanswered tool call with ID %s", ErrOrphanToolResult, message.ToolCallID)
+		}
+		return nil, nil
+	}
+
+	for _, tc := range message.ToolCalls {
+		if tc.ID == "" {
+			return nil, fmt.Errorf("%w: tool call to '%s' has no ID", ErrInvalidToolCall, tc.Function.Name)
+		}
+	}
+
+	if len(open) > 0 {
+		ids := make([]string, 0, len(open))
+		for id := range open {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return fmt.Errorf("%w: %s message added before results for %s", ErrUnansweredToolCalls, message.Role, strings.Join(ids, ", ")), nil
+	}
+	return nil, nil
+}
+
+// unansweredToolCalls returns the IDs of tool calls that have no result yet,
+// rebuilding the set when the history was changed other than through AddMessage
+func (h *ConversationHistory) unansweredToolCalls() map[string]bool {
+	if h.openCalls != nil && h.openCallsAt == len(h.Messages) && h.openCallsRev == h.rewrites {
+		return h.openCalls
+	}
+	h.openCalls = make(map[string]bool)
+	for _, msg := range h.Messages {
+		h.applyToolCalls(msg)
+	}
+	h.openCallsAt = len(h.Messages)
+	h.openCallsRev = h.rewrites
+	return h.openCalls
+}
+
+// trackToolCalls updates the unanswered tool calls for a message just
+// appended. A set that was already stale is left to be rebuilt.
+func (h *ConversationHistory) trackToolCalls(message Message) {
+	if h.openCalls == nil || h.openCallsAt != len(h.Messages)-1 || h.openCallsRev != h.rewrites {
+		return
+	}
+	h.applyToolCalls(message)
+	h.openCallsAt = len(h.Messages)
+}
+
+// applyToolCalls opens the calls a message requests and closes the one it answers
+func (h *ConversationHistory) applyToolCalls(message Message) {
+	for _, tc := range message.ToolCalls {
+		h.openCalls[tc.ID] = true
+	}
+	if message.Role == "tool" {
+		delete(h.openCalls, message.ToolCallID)
+	}
+}
+
 // AddToolMessage adds a tool message to the history
 func (h *ConversationHistory) AddToolMessage(toolName string, parameters map[string]interface{}, callID string) {
 	parametersJSON, _ := json.Marshal(parameters)
@@ -156,6 +293,64 @@ func (h *ConversationHistory) AddToolResultMessage(callID, toolName string, cont
 	h.AddMessage(resultMessage)
 }
 
+// ReplaceToolResultContent replaces the content of the tool result for callID.
+// It returns false if no such result exists.
+func (h *ConversationHistory) ReplaceToolResultContent(callID, content string) bool {
+	for i := range h.Messages {
+		if h.Messages[i].Role == "tool" && h.Messages[i].ToolCallID == callID {
+			h.Messages[i].Content = content
+			h.UpdatedAt = time.Now()
+			h.rewrites++
+			h.CurrentTokens = h.EstimateTokenCount()
+			h.journalChanges()
+			return true
+		}
+	}
+	return false
+}
+
+// SetSystemPrompt replaces the content of the leading system message, adding
+// one if the history does not start with a system message. An empty prompt
+// removes the leading system message.
+func (h *ConversationHistory) SetSystemPrompt(prompt string) {
+	hasSystem := len(h.Messages) > 0 && h.Messages[0].Role == "system"
+	switch {
+	case hasSystem && prompt == "":
+		h.Messages = append([]Message{}, h.Messages[1:]...)
+		h.Import.shift(-1)
+	case hasSystem:
+		if h.Messages[0].Content == prompt {
+			return
+		}
+		h.Messages[0].Content = prompt
+	case prompt == "":
+		return
+	default:
+		h.Messages = append([]Message{{Role: "system", Content: prompt}}, h.Messages...)
+		h.Import.shift(1)
+	}
+	h.UpdatedAt = time.Now()
+	h.rewrites++
+	h.CurrentTokens = h.EstimateTokenCount()
+	h.journalChanges()
+
+	if h.EnablePersist && h.HistoryPath != "" {
+		h.Save(h.HistoryPath)
+	}
+}
+
+// FindToolCall returns the tool call with the given ID from the assistant messages
+func (h *ConversationHistory) FindToolCall(callID string) (ToolCall, bool) {
+	for i := len(h.Messages) - 1; i >= 0; i-- {
+		for _, tc := range h.Messages[i].ToolCalls {
+			if tc.ID == callID {
+				return tc, true
+			}
+		}
+	}
+	return ToolCall{}, false
+}
+
 // AddMessages adds multiple messages to the history
 func (h *ConversationHistory) AddMessages(messages []Message) {
 	for _, msg := range messages {
@@ -163,9 +358,121 @@ func (h *ConversationHistory) AddMessages(messages []Message) {
 	}
 }
 
-// GetMessagesForContext returns messages suitable for the AI context
+// AppendMessages adds multiple messages to the history with AppendMessage. It
+// stops at the first rejected message; flagged messages are added and their
+// flags joined.
+func (h *ConversationHistory) AppendMessages(messages []Message) error {
+	var flags []error
+	for i, msg := range messages {
+		if err := h.AppendMessage(msg); err != nil {
+			if !errors.Is(err, ErrUnansweredToolCalls) {
+				return errors.Join(append(flags, fmt.Errorf("message %d: %w", i, err))...)
+			}
+			flags = append(flags, fmt.Errorf("message %d: %w", i, err))
+		}
+	}
+	return errors.Join(flags...)
+}
+
+// Truncate drops every message from index n onwards
+func (h *ConversationHistory) Truncate(n int) {
+	if n < 0 {
+		n = 0
+	}
+	if n >= len(h.Messages) {
+		return
+	}
+	h.Messages = h.Messages[:n:n]
+	h.UpdatedAt = time.Now()
+	h.rewrites++
+	h.CurrentTokens = h.EstimateTokenCount()
+	h.journalChanges()
+
+	if h.EnablePersist && h.HistoryPath != "" {
+		h.Save(h.HistoryPath)
+	}
+}
+
+// Fork returns a new history containing the messages up to and including
+// fromMessageIndex, leaving this history untouched. The fork point is moved so
+// an assistant tool-call message is never separated from its tool results: it
+// extends over results that directly follow, and otherwise falls back to just
+// before the unanswered tool-call message. The fork gets its own session ID.
+func (h *ConversationHistory) Fork(fromMessageIndex int) (*ConversationHistory, error) {
+	if fromMessageIndex < 0 || fromMessageIndex >= len(h.Messages) {
+		return nil, fmt.Errorf("fork index %d out of range (history has %d messages)", fromMessageIndex, len(h.Messages))
+	}
+
+	end := forkPoint(h.Messages, fromMessageIndex+1)
+
+	messages := make([]Message, end)
+	for i, msg := range h.Messages[:end] {
+		if msg.ToolCalls != nil {
+			msg.ToolCalls = append([]ToolCall(nil), msg.ToolCalls...)
+		}
+		messages[i] = msg
+	}
+
+	fork := &ConversationHistory{
+		FormatVersion:  HistoryFormatVersion,
+		Messages:       messages,
+		MaxTokenCount:  h.MaxTokenCount,
+		CurrentSession: uuid.New().String(),
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+		EnablePersist:  h.EnablePersist,
+		HistoryPath:    h.HistoryPath,
+		Retention:      h.Retention,
+		Packer:         h.Packer,
+		Lock:           h.Lock,
+		Summarizer:     h.Summarizer,
+	}
+	fork.CurrentTokens = fork.EstimateTokenCount()
+
+	if fork.EnablePersist && fork.HistoryPath != "" {
+		if err := fork.Save(fork.HistoryPath); err != nil {
+			return nil, fmt.Errorf("failed to save forked history: %w", err)
+		}
+	}
+
+	return fork, nil
+}
+
+// forkPoint adjusts end so messages[:end] contains no tool call without its results
+func forkPoint(messages []Message, end int) int {
+	pending := make(map[string]bool)
+	lastCallMessage := -1
+	for i, msg := range messages[:end] {
+		for _, tc := range msg.ToolCalls {
+			pending[tc.ID] = true
+			lastCallMessage = i
+		}
+		if msg.Role == "tool" {
+			delete(pending, msg.ToolCallID)
+		}
+	}
+
+	// Pull in results that directly follow the fork point
+	for end < len(messages) && len(pending) > 0 && messages[end].Role == "tool" {
+		delete(pending, messages[end].ToolCallID)
+		end++
+	}
+
+	if len(pending) > 0 {
+		// Cut before the unanswered call; earlier calls are checked again
+		return forkPoint(messages, lastCallMessage)
+	}
+	return end
+}
+
+// GetMessagesForContext returns the messages to send to the AI: the history
+// as its Packer packs it within the token limit, or the whole history when it
+// has no Packer and pruning keeps it within the limit
 func (h *ConversationHistory) GetMessagesForContext() []Message {
-	return h.Messages
+	if h.Packer == nil {
+		return h.Messages
+	}
+	return h.Packer.Pack(h, h.MaxTokenCount)
 }
 
 // GetMessages returns all messages in the history
@@ -186,6 +493,8 @@ func (h *ConversationHistory) Clear() {
 	h.Messages = []Message{}
 	h.CurrentTokens = 0
 	h.UpdatedAt = time.Now()
+	h.rewrites++
+	h.journalChanges()
 
 	// Save empty history if persistence is enabled
 	if h.EnablePersist && h.HistoryPath != "" {
@@ -193,146 +502,198 @@ func (h *ConversationHistory) Clear() {
 	}
 }
 
+// MarkInterrupted records that the session was cut short by a shutdown signal
+// while a turn was in progress
+func (h *ConversationHistory) MarkInterrupted() {
+	h.Interrupted = true
+	h.UpdatedAt = time.Now()
+	h.journalChanges()
+}
+
+// journalChanges records the latest changes in the session journal, if any.
+// Failures are not fatal: unrecorded messages are retried with the next change.
+func (h *ConversationHistory) journalChanges() {
+	if h.journal != nil {
+		h.journal.record(h)
+	}
+}
+
 // Save persists the conversation history to disk
 func (h *ConversationHistory) Save(path string) error {
 	if path == "" {
 		return nil // No-op if path is not specified
 	}
+	if err := verifyLock(h.Lock); err != nil {
+		return fmt.Errorf("history not saved: %w", err)
+	}
+	_, err := h.writeFile(path)
+	return err
+}
 
+// writeFile writes the history to its session file in dir, without checking
+// the write lock, and returns the file's path
+func (h *ConversationHistory) writeFile(dir string) (string, error) {
 	// Ensure the directory exists
-	if err := os.MkdirAll(path, 0755); err != nil {
-		return fmt.Errorf("failed to create history directory: %w", err)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create history directory: %w", err)
 	}
 
 	// Marshal the history to JSON
 	data, err := json.MarshalIndent(h, "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to marshal history: %w", err)
+		return "", fmt.Errorf("failed to marshal history: %w", err)
 	}
 
 	// Write to file
-	historyFile := filepath.Join(path, h.CurrentSession+".json")
+	historyFile := filepath.Join(dir, h.CurrentSession+".json")
 	if err := os.WriteFile(historyFile, data, 0644); err != nil {
-		return fmt.Errorf("failed to write history file: %w", err)
+		return "", fmt.Errorf("failed to write history file: %w", err)
 	}
 
-	return nil
+	return historyFile, nil
+}
+
+// writePrivateFile writes the history to dir like writeFile, readable by the
+// user alone: dir is created 0700 and must not be a symlink, and the file is
+// written 0600 and renamed into place, so nothing another user placed there
+// is written through
+func (h *ConversationHistory) writePrivateFile(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create history directory: %w", err)
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to check history directory: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("history directory %s is not a directory", dir)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		if err := os.Chmod(dir, 0700); err != nil {
+			return "", fmt.Errorf("failed to restrict history directory: %w", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal history: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, h.CurrentSession+".*.tmp") // Created 0600
+	if err != nil {
+		return "", fmt.Errorf("failed to write history file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write history file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write history file: %w", err)
+	}
+	historyFile := filepath.Join(dir, h.CurrentSession+".json")
+	if err := os.Rename(tmp.Name(), historyFile); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write history file: %w", err)
+	}
+	return historyFile, nil
 }
 
 // EstimateTokenCount estimates the number of tokens in the conversation history
 // This is a simple heuristic based on the number of characters
 func (h *ConversationHistory) EstimateTokenCount() int {
 	tokenCount := 0
-
 	for _, msg := range h.Messages {
-		// Each message has a base overhead
-		messageOverhead := 4
+		tokenCount += estimateMessageTokens(msg)
+	}
+	return tokenCount
+}
 
-		// Roughly estimate 4 characters per token
-		contentTokens := int(math.Ceil(float64(len(msg.Content)) / 4))
+// estimateMessageTokens estimates the tokens of a single message
+func estimateMessageTokens(msg Message) int {
+	// Each message has a base overhead
+	messageOverhead := 4
 
-		// Add to total
-		tokenCount += contentTokens + messageOverhead
-	}
+	contentTokens := EstimateTokens(msg.Content)
 
-	return tokenCount
+	return contentTokens + messageOverhead
 }
 
-// pruneIfNeeded removes older messages if the token count exceeds the maximum
+// pruneIfNeeded removes the oldest messages the retention policy does not
+// keep while the token count exceeds the maximum. A tool call and its results
+// are removed together. If the kept messages still exceed it, the removed
+// messages are replaced by a summary.
 func (h *ConversationHistory) pruneIfNeeded() {
-	// If we're under the limit, no pruning needed
-	if h.CurrentTokens <= h.MaxTokenCount {
+	// If we're under the limit, no pruning needed; a packer leaves the history
+	// whole and packs each request instead
+	if h.CurrentTokens <= h.MaxTokenCount || h.Packer != nil {
 		return
 	}
 
-	// We need to prune
-	// First, identify system messages to preserve
-	var systemMessages []Message
-	var otherMessages []Message
+	// We need to prune; any cached conversion of the history is now stale
+	h.rewrites++
 
-	for _, msg := range h.Messages {
-		if msg.Role == "system" {
-			systemMessages = append(systemMessages, msg)
-		} else {
-			otherMessages = append(otherMessages, msg)
+	keep := h.retained()
+	var dropped []Message
+	for h.CurrentTokens > h.MaxTokenCount {
+		start, end, ok := oldestDroppable(h.Messages, keep)
+		if !ok {
+			break
 		}
-	}
-
-	// If we have too many messages, start removing older ones
-	// We'll remove the oldest non-system messages first
-	for len(otherMessages) > 2 && h.EstimateTokenCount() > h.MaxTokenCount {
-		// Remove the oldest message (after systems)
-		otherMessages = otherMessages[1:]
-
-		// Recalculate with the new set
-		h.Messages = append(systemMessages, otherMessages...)
+		dropped = append(dropped, h.Messages[start:end]...)
+		h.Messages = append(h.Messages[:start], h.Messages[end:]...)
+		keep = append(keep[:start], keep[end:]...)
 		h.CurrentTokens = h.EstimateTokenCount()
 	}
 
-	// If we still exceed the token count, use AI to summarize the conversation
-	if h.CurrentTokens > h.MaxTokenCount {
-		// Generate a summary of the conversation
-		summary, err := h.SummarizeCurrentContext()
-		if err == nil && summary != "" {
-			// Create a system message with the summary
-			summaryMsg := Message{
-				Role:    "system",
-				Content: summary,
-			}
-
-			// Keep system messages plus the summary and the most recent exchanges
-			summarizedMessages := []Message{}
-
-			// Add original system messages (instructions, etc.)
-			for _, msg := range systemMessages {
-				// Skip any previous summary messages
-				if !strings.HasPrefix(msg.Content, "Summary of conversation: ") {
-					summarizedMessages = append(summarizedMessages, msg)
-				}
+	// If we still exceed the token count, use AI to summarize what was
+	// dropped; the retained messages are kept verbatim, so with nothing
+	// dropped there is nothing to summarize
+	if h.CurrentTokens > h.MaxTokenCount && len(dropped) > 0 {
+		// The new summary, placed after the system messages, replaces the
+		// previous one and takes in what it said
+		var systemMessages, otherMessages []Message
+		for _, msg := range h.Messages {
+			switch {
+			case msg.Role == "system" && strings.HasPrefix(msg.Content, "Summary of conversation: "):
+				dropped = append([]Message{msg}, dropped...)
+			case msg.Role == "system":
+				systemMessages = append(systemMessages, msg)
+			default:
+				otherMessages = append(otherMessages, msg)
 			}
-
-			// Add the new summary as a system message
-			summarizedMessages = append(summarizedMessages, summaryMsg)
-
-			// Add the most recent messages, up to a reasonable number
-			recentCount := int(math.Min(float64(len(otherMessages)), 4))
-			if recentCount > 0 {
-				summarizedMessages = append(summarizedMessages, otherMessages[len(otherMessages)-recentCount:]...)
-			}
-
-			h.Messages = summarizedMessages
-			h.CurrentTokens = h.EstimateTokenCount()
-			return
 		}
-
-		// Fallback if summarization fails: just keep a subset of messages
-		summarizedMessages := systemMessages
-
-		// Add the most recent messages, up to a reasonable number
-		recentCount := int(math.Min(float64(len(otherMessages)), 4))
-		if recentCount > 0 {
-			summarizedMessages = append(summarizedMessages, otherMessages[len(otherMessages)-recentCount:]...)
+		summary, err := h.summarize(dropped)
+		if err == nil && summary != "" {
+			systemMessages = append(systemMessages, Message{Role: "system", Content: summary})
 		}
 
-		h.Messages = summarizedMessages
+		h.Messages = append(systemMessages, otherMessages...)
 		h.CurrentTokens = h.EstimateTokenCount()
 	}
 }
 
 // SummarizeCurrentContext uses the AI to summarize the conversation
-// This is a placeholder for future implementation
 func (h *ConversationHistory) SummarizeCurrentContext() (string, error) {
-	// Implement actual summarization using OpenAI
-	// First, get all messages since the last system message that's a summary
+	return h.summarize(h.Messages)
+}
+
+// summarize uses the AI to summarize messages. An earlier summary among them
+// is summarized along with the rest.
+func (h *ConversationHistory) summarize(messages []Message) (string, error) {
 	var messagesToSummarize []Message
 	var systemMessages []Message
 
-	// Find messages to summarize (non-system) and preserve system messages
-	for _, msg := range h.Messages {
+	// Find messages to summarize (non-system) and preserve system messages.
+	// Pinned messages are kept verbatim and never summarized.
+	for _, msg := range messages {
+		if msg.Pinned {
+			continue
+		}
 		if msg.Role == "system" {
-			// Check if this is already a summary we generated
+			// A summary we generated earlier is carried into the new one
 			if strings.HasPrefix(msg.Content, "Summary of conversation: ") {
-				// Don't include previous summaries in our list to summarize
+				messagesToSummarize = append(messagesToSummarize, msg)
 				continue
 			}
 			systemMessages = append(systemMessages, msg)
@@ -343,7 +704,7 @@ func (h *ConversationHistory) SummarizeCurrentContext() (string, error) {
 
 	// If we don't have enough messages to summarize, just return a basic count
 	if len(messagesToSummarize) < 5 {
-		messageCount := len(h.Messages)
+		messageCount := len(messages)
 		systemCount := len(systemMessages)
 		userCount := 0
 		assistantCount := 0
@@ -365,52 +726,21 @@ func (h *ConversationHistory) SummarizeCurrentContext() (string, error) {
 		return summary, nil
 	}
 
-	// Otherwise, prepare messages for the summarization request
-	// We need to create a new OpenAI client for this request
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		// Fall back to basic summary if we don't have an API key
-		return fmt.Sprintf("Summary of conversation: %d messages", len(h.Messages)), nil
+	// Otherwise, ask the summarizer, falling back to a basic summary without one
+	if h.Summarizer == nil {
+		return fmt.Sprintf("Summary of conversation: %d messages", len(messages)), nil
 	}
 
-	client := openai.NewClient(apiKey)
-
 	// Prepare conversation for summarization
 	var conversationText strings.Builder
 	for _, msg := range messagesToSummarize {
 		conversationText.WriteString(fmt.Sprintf("%s: %s\n\n", msg.Role, msg.Content))
 	}
 
-	// Create a completion request for summarization
-	resp, err := client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: "gpt-3.5-turbo", // Use a smaller model for summarization
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    "system",
-					Content: "You are a helpful assistant that summarizes conversations. Create a concise summary of the following conversation, focusing on the key points and actions taken.",
-				},
-				{
-					Role:    "user",
-					Content: conversationText.String(),
-				},
-			},
-			MaxTokens: 300,
-		},
-	)
-
-	if err != nil {
+	summary, err := h.Summarizer(context.Background(), conversationText.String())
+	if err != nil || summary == "" {
 		// If summarization fails, fall back to basic summary
-		return fmt.Sprintf("Summary of conversation: %d messages", len(h.Messages)), nil
+		return fmt.Sprintf("Summary of conversation: %d messages", len(messages)), nil
 	}
-
-	// Get the summary from the response
-	if len(resp.Choices) > 0 {
-		summary := "Summary of conversation: " + resp.Choices[0].Message.Content
-		return summary, nil
-	}
-
-	// Fall back to basic summary if something went wrong
-	return fmt.Sprintf("Summary of conversation: %d messages", len(h.Messages)), nil
+	return "Summary of conversation: " + summary, nil
 }
@@ -0,0 +1,209 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RedactedContent replaces the content of redacted messages
+const RedactedContent = "[redacted]"
+
+var (
+	// ErrDeletedMessageAnswered flags a DeleteMessage that removed a user
+	// message the assistant had already replied to. The message is deleted, but
+	// the reply that follows it may no longer make sense.
+	ErrDeletedMessageAnswered = errors.New("deleted message had already been answered")
+	// ErrToolResultNotDeletable is returned by DeleteMessage for a tool result,
+	// which the API requires as long as its tool call is in the history
+	ErrToolResultNotDeletable = errors.New("tool result cannot be deleted on its own")
+)
+
+// Tombstone records that a message was redacted or deleted. It identifies the
+// removed content by size and digest so it can be matched against a known
+// value, without keeping the content itself.
+type Tombstone struct {
+	Action    string    `json:"action"` // "redact" or "delete"
+	Index     int       `json:"index"`  // Index of the message when it was changed
+	Role      string    `json:"role"`
+	ToolCalls []string  `json:"tool_calls,omitempty"` // Tool calls whose arguments and results went with it
+	Bytes     int       `json:"bytes"`                // Size of the removed content
+	SHA256    string    `json:"sha256"`               // Digest of the removed content
+	Time      time.Time `json:"time"`
+}
+
+// RedactMessage replaces the content of the message at index with
+// RedactedContent. Redacting an assistant message also clears the arguments
+// of its tool calls and the content of their results, while keeping the calls
+// and results themselves so the history stays valid for the API.
+func (h *ConversationHistory) RedactMessage(index int) (Tombstone, error) {
+	if index < 0 || index >= len(h.Messages) {
+		return Tombstone{}, fmt.Errorf("message index %d out of range (history has %d messages)", index, len(h.Messages))
+	}
+	msg := &h.Messages[index]
+	removed := sha256.New()
+	tomb := Tombstone{Action: "redact", Index: index, Role: msg.Role}
+	redact := func(content *string) {
+		if *content == "" || *content == RedactedContent {
+			return
+		}
+		removed.Write([]byte(*content))
+		tomb.Bytes += len(*content)
+		*content = RedactedContent
+	}
+
+	redact(&msg.Content)
+	if msg.Role == "tool" && msg.ToolCallID != "" {
+		tomb.ToolCalls = append(tomb.ToolCalls, msg.ToolCallID)
+	}
+	calls := make(map[string]bool)
+	for i := range msg.ToolCalls {
+		call := &msg.ToolCalls[i]
+		calls[call.ID] = true
+		tomb.ToolCalls = append(tomb.ToolCalls, call.ID)
+		if call.Function.Arguments != "{}" {
+			removed.Write([]byte(call.Function.Arguments))
+			tomb.Bytes += len(call.Function.Arguments)
+			call.Function.Arguments = "{}"
+		}
+	}
+	for i := range h.Messages {
+		if h.Messages[i].Role == "tool" && calls[h.Messages[i].ToolCallID] {
+			redact(&h.Messages[i].Content)
+		}
+	}
+
+	tomb.SHA256 = hex.EncodeToString(removed.Sum(nil))
+	return h.recordTombstone(tomb), nil
+}
+
+// DeleteMessage removes the message at index. Deleting an assistant message
+// also removes the results of its tool calls; a tool result cannot be deleted
+// on its own (redact it instead). Deleting a user message that was already
+// answered succeeds but is flagged with ErrDeletedMessageAnswered.
+func (h *ConversationHistory) DeleteMessage(index int) (Tombstone, error) {
+	if index < 0 || index >= len(h.Messages) {
+		return Tombstone{}, fmt.Errorf("message index %d out of range (history has %d messages)", index, len(h.Messages))
+	}
+	msg := h.Messages[index]
+	if msg.Role == "tool" {
+		return Tombstone{}, fmt.Errorf("%w: message %d answers tool call %s; delete the call or redact the result", ErrToolResultNotDeletable, index, msg.ToolCallID)
+	}
+
+	var flag error
+	if msg.Role == "user" && index+1 < len(h.Messages) && h.Messages[index+1].Role == "assistant" {
+		flag = fmt.Errorf("%w: message %d", ErrDeletedMessageAnswered, index)
+	}
+
+	removed := sha256.New()
+	tomb := Tombstone{Action: "delete", Index: index, Role: msg.Role}
+	calls := make(map[string]bool)
+	for _, call := range msg.ToolCalls {
+		calls[call.ID] = true
+		tomb.ToolCalls = append(tomb.ToolCalls, call.ID)
+	}
+
+	kept := make([]Message, 0, len(h.Messages)-1)
+	var gone []int
+	for i, m := range h.Messages {
+		if i == index || (m.Role == "tool" && calls[m.ToolCallID]) {
+			removed.Write([]byte(m.Content))
+			tomb.Bytes += len(m.Content)
+			for _, call := range m.ToolCalls {
+				removed.Write([]byte(call.Function.Arguments))
+				tomb.Bytes += len(call.Function.Arguments)
+			}
+			gone = append(gone, i)
+			continue
+		}
+		kept = append(kept, m)
+	}
+	h.Messages = kept
+	h.Import.remove(gone)
+
+	tomb.SHA256 = hex.EncodeToString(removed.Sum(nil))
+	return h.recordTombstone(tomb), flag
+}
+
+// recordTombstone keeps tomb with the history and persists the change
+func (h *ConversationHistory) recordTombstone(tomb Tombstone) Tombstone {
+	tomb.Time = time.Now()
+	h.Tombstones = append(h.Tombstones, tomb)
+	h.UpdatedAt = tomb.Time
+	h.rewrites++
+	h.CurrentTokens = h.EstimateTokenCount()
+	h.journalChanges()
+
+	if h.EnablePersist && h.HistoryPath != "" {
+		h.Save(h.HistoryPath)
+	}
+	return tomb
+}
+
+// RedactMessage redacts message index of the conversation history, see
+// ConversationHistory.RedactMessage. The change is saved, the session journal
+// is folded into its snapshot so no copy of the content is left behind, and a
+// tombstone is written to the audit log. It fails with
+// ErrInteractionInProgress unless the agent is idle.
+func (a *OpenAIAgent) RedactMessage(index int) (Tombstone, error) {
+	return a.editHistory(func(h *ConversationHistory) (Tombstone, error) {
+		return h.RedactMessage(index)
+	})
+}
+
+// DeleteMessage deletes message index of the conversation history, see
+// ConversationHistory.DeleteMessage, and records it like RedactMessage
+func (a *OpenAIAgent) DeleteMessage(index int) (Tombstone, error) {
+	return a.editHistory(func(h *ConversationHistory) (Tombstone, error) {
+		return h.DeleteMessage(index)
+	})
+}
+
+// editHistory applies a redaction or deletion while the agent is idle
+func (a *OpenAIAgent) editHistory(edit func(h *ConversationHistory) (Tombstone, error)) (Tombstone, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.history == nil {
+		return Tombstone{}, errors.New("agent history is nil")
+	}
+	if a.state != StateIdle {
+		return Tombstone{}, fmt.Errorf("%w: the history can only be edited between turns", ErrInteractionInProgress)
+	}
+
+	a.historyMu.Lock()
+	tomb, err := edit(a.history)
+	a.historyMu.Unlock()
+	if err != nil && !errors.Is(err, ErrDeletedMessageAnswered) {
+		return tomb, err
+	}
+	flag := err
+
+	// Calls left unanswered by a cancelled turn must not be answered once deleted
+	a.pendingMu.Lock()
+	for callID := range a.pendingToolCalls {
+		if _, found := a.history.FindToolCall(callID); !found {
+			delete(a.pendingToolCalls, callID)
+		}
+	}
+	a.pendingMu.Unlock()
+
+	// The full outputs saved for the calls go with their results
+	if err := a.discardSavedOutputs(tomb.ToolCalls); err != nil {
+		return tomb, err
+	}
+	if data, err := json.Marshal(tomb); err == nil {
+		a.logger.Log("[AUDIT] Agent.editHistory: Tombstone %s", data)
+	}
+	if err := a.history.Save(a.historyOpts.HistoryPath); err != nil {
+		return tomb, fmt.Errorf("failed to save history: %w", err)
+	}
+	if a.journal != nil {
+		if err := a.journal.checkpoint(); err != nil {
+			return tomb, fmt.Errorf("failed to save session: %w", err)
+		}
+	}
+	return tomb, flag
+}
@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// editableHistory returns a history with a user message, an answered tool call and a reply
+func editableHistory(t *testing.T) *ConversationHistory {
+	t.Helper()
+	h, err := NewConversationHistory(HistoryOptions{MaxTokenCount: 100000, SessionID: "edit"})
+	if err != nil {
+		t.Fatalf("Failed to create history: %v", err)
+	}
+	err = h.AppendMessages([]Message{
+		{Role: "system", Content: "You are helpful."},
+		{Role: "user", Content: "My token is sk-secret, check it"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1", Type: "function", Function: FunctionCall{Name: "shell", Arguments: `{"command":"curl -H 'Bearer sk-secret'"}`}}}},
+		{Role: "tool", ToolCallID: "call_1", Name: "shell", Content: "token sk-secret is valid"},
+		{Role: "assistant", Content: "The token works."},
+	})
+	if err != nil {
+		t.Fatalf("Failed to add messages: %v", err)
+	}
+	return h
+}
+
+func TestRedactToolCallMessageNeutralizesResults(t *testing.T) {
+	h := editableHistory(t)
+	tomb, err := h.RedactMessage(2)
+	if err != nil {
+		t.Fatalf("RedactMessage failed: %v", err)
+	}
+	if tomb.Action != "redact" || tomb.Index != 2 || len(tomb.ToolCalls) != 1 || tomb.Bytes == 0 || tomb.SHA256 == "" {
+		t.Errorf("Unexpected tombstone: %+v", tomb)
+	}
+	if len(h.Tombstones) != 1 {
+		t.Errorf("Expected the tombstone to be kept with the history, got %d", len(h.Tombstones))
+	}
+
+	messages := h.GetMessagesForContext()
+	if len(messages) != 5 {
+		t.Fatalf("Expected redaction to keep every message, got %d", len(messages))
+	}
+	checkToolPairing(t, messages)
+	if args := messages[2].ToolCalls[0].Function.Arguments; args != "{}" {
+		t.Errorf("Expected the call arguments to be cleared, got %q", args)
+	}
+	if messages[3].Content != RedactedContent {
+		t.Errorf("Expected the paired result to be redacted, got %q", messages[3].Content)
+	}
+	if apiMessages := newMessageCache().build(h); len(apiMessages) != 5 {
+		t.Errorf("Expected all messages to be sent to the API, got %d", len(apiMessages))
+	}
+}
+
+func TestRedactUserMessage(t *testing.T) {
+	h := editableHistory(t)
+	if _, err := h.RedactMessage(1); err != nil {
+		t.Fatalf("RedactMessage failed: %v", err)
+	}
+	if h.Messages[1].Content != RedactedContent {
+		t.Errorf("Expected the user message to be redacted, got %q", h.Messages[1].Content)
+	}
+	if !strings.Contains(h.Messages[3].Content, "sk-secret") {
+		t.Errorf("Expected other messages to be left alone")
+	}
+	if _, err := h.RedactMessage(5); err == nil {
+		t.Errorf("Expected an error for an index out of range")
+	}
+}
+
+func TestDeleteToolCallMessageRemovesResults(t *testing.T) {
+	h := editableHistory(t)
+	h.Import = &ImportInfo{Messages: []ImportedMessage{{Index: 3}, {Index: 4, Issues: []string{"x"}}}}
+
+	tomb, err := h.DeleteMessage(2)
+	if err != nil {
+		t.Fatalf("DeleteMessage failed: %v", err)
+	}
+	if tomb.Action != "delete" || len(tomb.ToolCalls) != 1 {
+		t.Errorf("Unexpected tombstone: %+v", tomb)
+	}
+	messages := h.GetMessagesForContext()
+	if len(messages) != 3 || messages[2].Content != "The token works." {
+		t.Fatalf("Expected the call and its result to be removed, got %+v", messages)
+	}
+	checkToolPairing(t, messages)
+	if len(h.Import.Messages) != 1 || h.Import.Messages[0].Index != 2 {
+		t.Errorf("Expected the import records to follow the deletion, got %+v", h.Import.Messages)
+	}
+}
+
+func TestDeleteMessageGuards(t *testing.T) {
+	h := editableHistory(t)
+	if _, err := h.DeleteMessage(3); !errors.Is(err, ErrToolResultNotDeletable) {
+		t.Errorf("Expected ErrToolResultNotDeletable, got %v", err)
+	}
+	if len(h.Messages) != 5 {
+		t.Errorf("Expected a refused deletion to leave the history alone")
+	}
+
+	h.AddMessage(Message{Role: "user", Content: "Thanks"})
+	h.AddMessage(Message{Role: "assistant", Content: "You're welcome."})
+	if _, err := h.DeleteMessage(5); !errors.Is(err, ErrDeletedMessageAnswered) {
+		t.Errorf("Expected ErrDeletedMessageAnswered, got %v", err)
+	}
+	if len(h.Messages) != 6 {
+		t.Errorf("Expected the answered message to be deleted anyway, got %d messages", len(h.Messages))
+	}
+}
+
+func TestAgentEditsHistoryOnlyWhenIdle(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, toolCallReply("shell", `{"command":"cat .env"}`), "Done.")
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Show the env"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	last := len(a.history.GetMessages()) - 1
+	if _, err := a.RedactMessage(last); !errors.Is(err, ErrInteractionInProgress) {
+		t.Errorf("Expected ErrInteractionInProgress while results are awaited, got %v", err)
+	}
+
+	a.Cancel()
+	if _, err := a.DeleteMessage(last); err != nil {
+		t.Fatalf("DeleteMessage failed: %v", err)
+	}
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Next"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage after deletion failed: %v", err)
+	}
+	checkToolPairing(t, a.history.GetMessagesForContext())
+}
+
+// recordingLogger keeps the messages logged
+type recordingLogger struct {
+	mu   sync.Mutex
+	logs []string
+}
+
+func (l *recordingLogger) Log(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logs = append(l.logs, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) IsEnabled() bool { return true }
+func (l *recordingLogger) Close() error    { return nil }
+
+func TestRedactToolResultRemovesSavedOutput(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, toolCallReply("shell", `{"command":"cat .env"}`), "Done.")
+	logger := &recordingLogger{}
+	a.logger = logger
+	a.config.ToolOutputSummaryThreshold = 100
+	a.config.ToolOutputDir = t.TempDir()
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Show the env"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	secret := strings.Repeat("API_KEY=sk-secret\n", 20)
+	if err := a.SendToolResult(context.Background(), NewToolResult("call_1", "shell", secret, true)); err != nil {
+		t.Fatalf("SendToolResult failed: %v", err)
+	}
+
+	messages := a.history.GetMessages()
+	index := -1
+	for i, msg := range messages {
+		if msg.Role == "tool" {
+			index = i
+		}
+	}
+	m := regexp.MustCompile(`saved to (\S+);`).FindStringSubmatch(messages[index].Content)
+	if m == nil {
+		t.Fatalf("Expected the result to point to the saved output, got %q", messages[index].Content)
+	}
+	if _, err := a.RedactMessage(index); err != nil {
+		t.Fatalf("RedactMessage failed: %v", err)
+	}
+
+	if _, err := os.Stat(m[1]); !os.IsNotExist(err) {
+		t.Errorf("Expected the saved output to be removed with the redaction, got %v", err)
+	}
+	for _, line := range logger.logs {
+		if strings.HasPrefix(line, "[AUDIT]") && strings.Contains(line, "sk-secret") {
+			t.Errorf("Expected the audit log to hold no copy of the output, got %q", line)
+		}
+	}
+}
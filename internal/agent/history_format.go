@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// HistoryFormatVersion is the version of the format histories and session
+// snapshots are saved in. Bump it when a change to Message or
+// ConversationHistory needs saved files to be rewritten to load correctly,
+// and register the migration from the previous version in historyMigrations.
+// Adding an optional field does not need a new version.
+//
+//	1: files from before the format was versioned, with tool results as
+//	   {"output": ...} or {"error": ..., "notice": ...} objects
+//	2: tool results in the canonical ToolResult encoding
+const HistoryFormatVersion = 2
+
+// ErrNewerHistoryFormat is returned when loading a history saved by a newer
+// version of codex, which this one cannot read without losing data
+var ErrNewerHistoryFormat = errors.New("history saved in a newer format")
+
+// historyMigrations upgrades a history from the version of its key to the
+// next one
+var historyMigrations = map[int]func(h *ConversationHistory){
+	1: (*ConversationHistory).migrateToolResults,
+}
+
+// Migrate upgrades a history loaded from an older format to the current one,
+// in memory; the file is rewritten with the next save. Files without a
+// version are version 1. A history from a newer format is left as it is
+// and reported with ErrNewerHistoryFormat.
+func (h *ConversationHistory) Migrate() error {
+	version := max(h.FormatVersion, 1)
+	if version > HistoryFormatVersion {
+		return fmt.Errorf("%w: version %d, this build reads up to version %d", ErrNewerHistoryFormat, version, HistoryFormatVersion)
+	}
+	for ; version < HistoryFormatVersion; version++ {
+		migrate, ok := historyMigrations[version]
+		if !ok {
+			return fmt.Errorf("no migration from history format version %d", version)
+		}
+		migrate(h)
+	}
+	h.FormatVersion = HistoryFormatVersion
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, saving the history in the current
+// format version
+func (h *ConversationHistory) MarshalJSON() ([]byte, error) {
+	type plain ConversationHistory // Without the method, to avoid recursion
+	saved := plain(*h)
+	saved.FormatVersion = HistoryFormatVersion
+	return json.Marshal(&saved)
+}
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadVersion1History(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "history_v1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "v1-session.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := NewConversationHistory(HistoryOptions{MaxTokenCount: 8000, SessionID: "v1-session", HistoryPath: dir, EnablePersist: true})
+	if err != nil {
+		t.Fatalf("Failed to load a version 1 history: %v", err)
+	}
+	if history.FormatVersion != HistoryFormatVersion {
+		t.Errorf("Expected the history migrated to version %d, got %d", HistoryFormatVersion, history.FormatVersion)
+	}
+	if len(history.Messages) != 6 || history.Messages[5].Content != "The README introduces Codex-Go, a coding agent." {
+		t.Fatalf("Expected the 6 messages of the file, got %+v", history.Messages)
+	}
+	if errs := history.Validate(); len(errs) > 0 {
+		t.Errorf("Expected a valid history, got %v", errs)
+	}
+
+	want := []ToolResult{
+		{Success: true, Output: "# Codex-Go\nA coding agent."},
+		{Error: "file not found", Metadata: map[string]string{"notice": "Paths are relative to the workspace"}},
+	}
+	for i, msg := range history.Messages[3:5] {
+		if msg.Content != want[i].Content() {
+			t.Errorf("Expected tool result %d in the canonical encoding %s, got %s", i, want[i].Content(), msg.Content)
+		}
+	}
+
+	// The next save rewrites the file in the current version
+	if err := history.Save(dir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	saved, _ := os.ReadFile(filepath.Join(dir, "v1-session.json"))
+	var fields struct {
+		FormatVersion int `json:"format_version"`
+	}
+	if err := json.Unmarshal(saved, &fields); err != nil || fields.FormatVersion != HistoryFormatVersion {
+		t.Errorf("Expected the saved file at version %d, got %d (%v)", HistoryFormatVersion, fields.FormatVersion, err)
+	}
+}
+
+func TestLoadNewerHistoryFails(t *testing.T) {
+	dir := t.TempDir()
+	newer := `{"format_version": 99, "messages": [{"role": "user", "content": "from the future"}], "current_session": "future"}`
+	if err := os.WriteFile(filepath.Join(dir, "future.json"), []byte(newer), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewConversationHistory(HistoryOptions{SessionID: "future", HistoryPath: dir, EnablePersist: true})
+	if !errors.Is(err, ErrNewerHistoryFormat) {
+		t.Fatalf("Expected ErrNewerHistoryFormat, got %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "future.json"))
+	if !strings.Contains(string(data), "from the future") {
+		t.Errorf("Expected the newer file left untouched, got %s", data)
+	}
+}
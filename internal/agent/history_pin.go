@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PinMessage pins the message at index: history pruning keeps it however old
+// it is and summarization leaves it out, so a crucial spec or API contract
+// stays in view verbatim. Pinning a tool call or result keeps the whole call
+// with its results.
+func (h *ConversationHistory) PinMessage(index int) error {
+	return h.setPinned(index, true)
+}
+
+// UnpinMessage unpins the message at index, making it prunable again
+func (h *ConversationHistory) UnpinMessage(index int) error {
+	return h.setPinned(index, false)
+}
+
+// setPinned sets the pinned mark of the message at index and persists the change
+func (h *ConversationHistory) setPinned(index int, pinned bool) error {
+	if index < 0 || index >= len(h.Messages) {
+		return fmt.Errorf("message index %d out of range (history has %d messages)", index, len(h.Messages))
+	}
+	if h.Messages[index].Pinned == pinned {
+		return nil
+	}
+	h.Messages[index].Pinned = pinned
+	h.rewrites++
+	h.journalChanges()
+
+	if h.EnablePersist && h.HistoryPath != "" {
+		h.Save(h.HistoryPath)
+	}
+	return nil
+}
+
+// PinMessage pins message index of the conversation history, see
+// ConversationHistory.PinMessage
+func (a *OpenAIAgent) PinMessage(index int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.history == nil {
+		return errors.New("agent history is nil")
+	}
+	a.historyMu.Lock()
+	defer a.historyMu.Unlock()
+	return a.history.PinMessage(index)
+}
+
+// UnpinMessage unpins message index of the conversation history
+func (a *OpenAIAgent) UnpinMessage(index int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.history == nil {
+		return errors.New("agent history is nil")
+	}
+	a.historyMu.Lock()
+	defer a.historyMu.Unlock()
+	return a.history.UnpinMessage(index)
+}
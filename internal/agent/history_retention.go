@@ -0,0 +1,108 @@
+package agent
+
+import "github.com/epuerta/codex-go/internal/config"
+
+// RetentionPolicy decides which messages history pruning keeps however old
+// they are, so the conversation stays coherent when its middle is dropped.
+// System messages and pinned messages are always kept.
+type RetentionPolicy struct {
+	KeepTask         bool // Keep the first user message, which usually states the task
+	RecentTurns      int  // Keep the last turns, each from a user message to the next (at least 1)
+	RecentToolRounds int  // Keep only this many of the last tool calls of those turns, with their results (0 = all)
+}
+
+// DefaultRetentionPolicy keeps the task statement and the last turn, up to
+// its last config.DefaultHistoryRecentToolRounds tool calls
+func DefaultRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{KeepTask: true, RecentTurns: 1, RecentToolRounds: config.DefaultHistoryRecentToolRounds}
+}
+
+// retained reports, for each message, whether pruning must keep it: system
+// and pinned messages, the task statement and the recent turns with their
+// last tool rounds under the retention policy, and the tool calls and results
+// paired with any of them
+func (h *ConversationHistory) retained() []bool {
+	keep := make([]bool, len(h.Messages))
+
+	// The recent turns start at the RecentTurns-th user message from the end,
+	// or at the first message if there are fewer
+	turns := max(h.Retention.RecentTurns, 1)
+	recentFrom := 0
+	for i := len(h.Messages) - 1; i >= 0; i-- {
+		if h.Messages[i].Role == "user" {
+			turns--
+			if turns == 0 {
+				recentFrom = i
+				break
+			}
+		}
+	}
+
+	// A long agentic turn is kept up to its last RecentToolRounds tool calls,
+	// so the retained messages cannot outgrow the history by themselves
+	roundsFrom := recentFrom
+	if rounds := h.Retention.RecentToolRounds; rounds > 0 {
+		for i := len(h.Messages) - 1; i >= recentFrom; i-- {
+			if len(h.Messages[i].ToolCalls) > 0 {
+				rounds--
+				if rounds == 0 {
+					roundsFrom = i
+					break
+				}
+			}
+		}
+	}
+
+	task := h.Retention.KeepTask
+	for i, msg := range h.Messages {
+		toolRound := len(msg.ToolCalls) > 0 || msg.Role == "tool"
+		switch {
+		case msg.Role == "system", msg.Pinned, i >= roundsFrom, i >= recentFrom && !toolRound:
+			keep[i] = true
+		case task && msg.Role == "user":
+			keep[i] = true
+		}
+		if msg.Role == "user" {
+			task = false
+		}
+	}
+
+	// A tool call and its results stand or fall together
+	for start := 0; start < len(h.Messages); {
+		end := groupEnd(h.Messages, start)
+		kept := false
+		for i := start; i < end; i++ {
+			kept = kept || keep[i]
+		}
+		for i := start; i < end; i++ {
+			keep[i] = kept
+		}
+		start = end
+	}
+	return keep
+}
+
+// groupEnd returns the end of the group of messages starting at start: an
+// assistant message with its tool results, or a single message
+func groupEnd(messages []Message, start int) int {
+	end := start + 1
+	if len(messages[start].ToolCalls) > 0 {
+		for end < len(messages) && messages[end].Role == "tool" {
+			end++
+		}
+	}
+	return end
+}
+
+// oldestDroppable returns the bounds of the oldest group of messages that
+// keep does not retain
+func oldestDroppable(messages []Message, keep []bool) (start, end int, ok bool) {
+	for start < len(messages) {
+		end = groupEnd(messages, start)
+		if !keep[start] {
+			return start, end, true
+		}
+		start = end
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPruneKeepsTaskPinnedAndRecentTurns(t *testing.T) {
+	// Every message with text costs 14 tokens, a tool call without text 4.
+	// The kept messages cost 134 tokens; any other message would exceed the limit.
+	text := func(s string) string { return s + strings.Repeat(".", 40-len(s)) }
+	h, err := NewConversationHistory(HistoryOptions{
+		MaxTokenCount: 140,
+		SessionID:     "retention",
+		SystemPrompt:  text("system"),
+		Retention:     RetentionPolicy{KeepTask: true, RecentTurns: 2},
+	})
+	if err != nil {
+		t.Fatalf("NewConversationHistory failed: %v", err)
+	}
+
+	h.AddMessage(Message{Role: "user", Content: text("task")})
+	h.AddMessage(Message{Role: "assistant", Content: text("decision"), Pinned: true})
+	for i := 1; i <= 6; i++ {
+		callID := fmt.Sprintf("call_%d", i)
+		h.AddMessages([]Message{
+			{Role: "user", Content: text(fmt.Sprintf("turn %d", i))},
+			{Role: "assistant", ToolCalls: []ToolCall{{ID: callID, Type: "function", Function: FunctionCall{Name: "shell", Arguments: "{}"}}}},
+			{Role: "tool", ToolCallID: callID, Content: text(fmt.Sprintf("output %d", i))},
+			{Role: "assistant", Content: text(fmt.Sprintf("reply %d", i))},
+		})
+	}
+
+	var got []string
+	for _, msg := range h.GetMessages() {
+		got = append(got, strings.TrimRight(msg.Content, "."))
+	}
+	want := []string{"system", "task", "decision", "turn 5", "", "output 5", "reply 5", "turn 6", "", "output 6", "reply 6"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected messages %q, got %q", want, got)
+	}
+	checkToolPairing(t, h.GetMessages())
+	if h.CurrentTokens > h.MaxTokenCount {
+		t.Errorf("Expected at most %d tokens, got %d", h.MaxTokenCount, h.CurrentTokens)
+	}
+}
+
+func TestRetainedKeepsToolCallsWithResults(t *testing.T) {
+	h := &ConversationHistory{Messages: []Message{
+		{Role: "user", Content: "task"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1", Type: "function", Function: FunctionCall{Name: "read_file"}}}},
+		{Role: "tool", ToolCallID: "call_1", Content: "spec", Pinned: true},
+		{Role: "user", Content: "next"},
+		{Role: "assistant", Content: "done"},
+	}}
+
+	want := []bool{false, true, true, true, true}
+	if got := h.retained(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestRetainedCapsRecentToolRounds(t *testing.T) {
+	call := func(id string) Message {
+		return Message{Role: "assistant", ToolCalls: []ToolCall{{ID: id, Type: "function", Function: FunctionCall{Name: "shell"}}}}
+	}
+	h := &ConversationHistory{
+		Retention: RetentionPolicy{RecentTurns: 1, RecentToolRounds: 2},
+		Messages: []Message{
+			{Role: "user", Content: "task"},
+			call("call_1"), {Role: "tool", ToolCallID: "call_1", Content: "output 1"},
+			call("call_2"), {Role: "tool", ToolCallID: "call_2", Content: "output 2"},
+			call("call_3"), {Role: "tool", ToolCallID: "call_3", Content: "output 3"},
+			{Role: "assistant", Content: "done"},
+		},
+	}
+
+	want := []bool{true, false, false, true, true, true, true, true}
+	if got := h.retained(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestPruneWithoutDropsAddsNoSummary(t *testing.T) {
+	h, err := NewConversationHistory(HistoryOptions{
+		MaxTokenCount: 10,
+		SessionID:     "retention",
+		SystemPrompt:  "system",
+		Retention:     RetentionPolicy{RecentTurns: 1},
+	})
+	if err != nil {
+		t.Fatalf("NewConversationHistory failed: %v", err)
+	}
+	h.AddMessages([]Message{
+		{Role: "user", Content: strings.Repeat("a long task statement ", 10)},
+		{Role: "assistant", Content: "done"},
+	})
+
+	for _, msg := range h.GetMessages() {
+		if strings.HasPrefix(msg.Content, "Summary of conversation: ") {
+			t.Errorf("Expected no summary with every message retained, got %q", msg.Content)
+		}
+	}
+	if n := len(h.GetMessages()); n != 3 {
+		t.Errorf("Expected the 3 retained messages to be kept, got %d", n)
+	}
+}
+
+func TestPinMessage(t *testing.T) {
+	h := &ConversationHistory{Messages: []Message{
+		{Role: "system", Content: "system"},
+		{Role: "user", Content: "the API contract"},
+		{Role: "assistant", Content: "noted"},
+		{Role: "user", Content: "next"},
+	}}
+	if err := h.PinMessage(1); err != nil {
+		t.Fatalf("PinMessage failed: %v", err)
+	}
+	if err := h.PinMessage(4); err == nil {
+		t.Error("Expected an error pinning a message out of range")
+	}
+	if !h.Messages[1].Pinned || !h.retained()[1] {
+		t.Errorf("Expected the pinned message to be retained, got %+v", h.Messages[1])
+	}
+
+	// Pinned messages are kept verbatim rather than summarized
+	summary, err := h.SummarizeCurrentContext()
+	if err != nil {
+		t.Fatalf("SummarizeCurrentContext failed: %v", err)
+	}
+	if !strings.Contains(summary, "(1 system, 1 user, 1 assistant)") {
+		t.Errorf("Expected the pinned message to be left out of the summary, got %q", summary)
+	}
+
+	if err := h.UnpinMessage(1); err != nil {
+		t.Fatalf("UnpinMessage failed: %v", err)
+	}
+	if h.Messages[1].Pinned {
+		t.Error("Expected the message to be unpinned")
+	}
+}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SearchOptions controls how ConversationHistory.Search matches messages
+type SearchOptions struct {
+	Regex         bool     // Treat the query as a regular expression instead of a substring
+	CaseSensitive bool     // Match case; searches ignore case by default
+	Roles         []string // Only search messages with these roles; empty means all
+	Limit         int      // Maximum number of matches to return; 0 means no limit
+}
+
+// SearchMatch is a message that matched a search
+type SearchMatch struct {
+	Index   int     // Position of the message in the history
+	Message Message // The matching message
+	Field   string  // Where the match is: "content", or "tool_call" for a call's name or arguments
+	Snippet string  // The match with some surrounding text, on one line
+}
+
+// searchSnippetContext is how many bytes around a match a snippet shows on each side
+const searchSnippetContext = 40
+
+// Search returns the messages matching query, oldest first. The content of each
+// message is searched, along with the names and arguments of its tool calls.
+// An empty query or an invalid regular expression is an error.
+func (h *ConversationHistory) Search(query string, opts SearchOptions) ([]SearchMatch, error) {
+	if query == "" {
+		return nil, errors.New("search query is empty")
+	}
+
+	pattern := query
+	if !opts.Regex {
+		pattern = regexp.QuoteMeta(query)
+	}
+	if !opts.CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search pattern: %w", err)
+	}
+
+	roles := make(map[string]bool, len(opts.Roles))
+	for _, role := range opts.Roles {
+		roles[role] = true
+	}
+
+	var matches []SearchMatch
+	for i, msg := range h.Messages {
+		if len(roles) > 0 && !roles[msg.Role] {
+			continue
+		}
+		field, snippet, ok := searchMessage(re, msg)
+		if !ok {
+			continue
+		}
+		matches = append(matches, SearchMatch{Index: i, Message: msg, Field: field, Snippet: snippet})
+		if opts.Limit > 0 && len(matches) >= opts.Limit {
+			break
+		}
+	}
+	return matches, nil
+}
+
+// searchMessage finds the first match of re in msg
+func searchMessage(re *regexp.Regexp, msg Message) (field, snippet string, ok bool) {
+	if loc := re.FindStringIndex(msg.Content); loc != nil {
+		return "content", searchSnippet(msg.Content, loc), true
+	}
+	for _, tc := range msg.ToolCalls {
+		text := tc.Function.Name + " " + tc.Function.Arguments
+		if loc := re.FindStringIndex(text); loc != nil {
+			return "tool_call", searchSnippet(text, loc), true
+		}
+	}
+	return "", "", false
+}
+
+// searchSnippet cuts the text around loc, marking elided text with "..."
+func searchSnippet(text string, loc []int) string {
+	start, end := loc[0]-searchSnippetContext, loc[1]+searchSnippetContext
+	prefix, suffix := "...", "..."
+	if start <= 0 {
+		start, prefix = 0, ""
+	}
+	if end >= len(text) {
+		end, suffix = len(text), ""
+	}
+	// Keep the cut on rune boundaries
+	for start > 0 && !isRuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !isRuneStart(text[end]) {
+		end++
+	}
+	return prefix + strings.Join(strings.Fields(text[start:end]), " ") + suffix
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
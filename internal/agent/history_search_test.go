@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func searchHistory() *ConversationHistory {
+	return &ConversationHistory{Messages: []Message{
+		{Role: "system", Content: "You are a coding assistant."},
+		{Role: "user", Content: "Why does the Login handler return 500?"},
+		{Role: "assistant", Content: "", ToolCalls: []ToolCall{{ID: "call_1", Type: "function", Function: FunctionCall{Name: "read_file", Arguments: `{"path":"auth/login.go"}`}}}},
+		{Role: "tool", ToolCallID: "call_1", Content: "func Login(w http.ResponseWriter) { panic(err) }"},
+		{Role: "assistant", Content: "The login handler panics on a nil session."},
+	}}
+}
+
+func TestSearchSubstring(t *testing.T) {
+	matches, err := searchHistory().Search("login", SearchOptions{})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	var indices []int
+	for _, m := range matches {
+		indices = append(indices, m.Index)
+	}
+	if len(indices) != 4 || indices[0] != 1 || indices[1] != 2 || indices[3] != 4 {
+		t.Fatalf("Expected matches at 1, 2, 3 and 4, got %v", indices)
+	}
+	if matches[1].Field != "tool_call" || !strings.Contains(matches[1].Snippet, "auth/login.go") {
+		t.Errorf("Expected the tool call arguments to match, got %+v", matches[1])
+	}
+
+	matches, _ = searchHistory().Search("login", SearchOptions{CaseSensitive: true})
+	if len(matches) != 2 || matches[0].Index != 2 || matches[1].Index != 4 {
+		t.Errorf("Expected case-sensitive matches at 2 and 4, got %+v", matches)
+	}
+}
+
+func TestSearchRegexAndRoles(t *testing.T) {
+	matches, err := searchHistory().Search(`panic(s|\()`, SearchOptions{Regex: true, Roles: []string{"assistant"}})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Index != 4 {
+		t.Errorf("Expected only the assistant message to match, got %+v", matches)
+	}
+
+	if _, err := searchHistory().Search("(", SearchOptions{Regex: true}); err == nil {
+		t.Errorf("Expected an invalid pattern to be an error")
+	}
+	if matches, err := searchHistory().Search("(", SearchOptions{}); err != nil || len(matches) != 1 {
+		t.Errorf("Expected a substring search to take the query literally, got %+v, %v", matches, err)
+	}
+	if _, err := searchHistory().Search("", SearchOptions{}); err == nil {
+		t.Errorf("Expected an empty query to be an error")
+	}
+}
+
+func TestSearchSnippetAndLimit(t *testing.T) {
+	h := &ConversationHistory{Messages: []Message{
+		{Role: "user", Content: strings.Repeat("a ", 50) + "needle\nin\nthe " + strings.Repeat("b ", 50)},
+		{Role: "user", Content: "another needle"},
+	}}
+	matches, err := h.Search("needle", SearchOptions{Limit: 1})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("Expected the limit to stop the search, got %d matches", len(matches))
+	}
+	snippet := matches[0].Snippet
+	if !strings.HasPrefix(snippet, "...") || !strings.HasSuffix(snippet, "...") || !strings.Contains(snippet, "needle in the") {
+		t.Errorf("Expected an elided one-line snippet, got %q", snippet)
+	}
+}
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -269,3 +270,125 @@ func TestClear(t *testing.T) {
 		t.Errorf("Expected 0 messages after clear, got %d", len(history.Messages))
 	}
 }
+
+func TestFork(t *testing.T) {
+	history := &ConversationHistory{
+		Messages: []Message{
+			{Role: "system", Content: "You are a helpful assistant."},
+			{Role: "user", Content: "List the files"},
+			{Role: "assistant", ToolCalls: []ToolCall{
+				{ID: "call_1", Type: "function", Function: FunctionCall{Name: "shell", Arguments: `{"command":"ls"}`}},
+				{ID: "call_2", Type: "function", Function: FunctionCall{Name: "shell", Arguments: `{"command":"pwd"}`}},
+			}},
+			{Role: "tool", ToolCallID: "call_1", Content: `{"output":"main.go"}`},
+			{Role: "tool", ToolCallID: "call_2", Content: `{"output":"/tmp"}`},
+			{Role: "assistant", Content: "There is one file."},
+			{Role: "user", Content: "Run the tests"},
+			{Role: "assistant", ToolCalls: []ToolCall{
+				{ID: "call_3", Type: "function", Function: FunctionCall{Name: "shell", Arguments: `{"command":"go test"}`}},
+			}},
+		},
+		MaxTokenCount:  1000,
+		CurrentSession: "original",
+	}
+
+	tests := []struct {
+		name     string
+		index    int
+		expected int
+	}{
+		{"plain message", 1, 2},
+		{"tool call pulls in its results", 2, 5},
+		{"partial results are completed", 3, 5},
+		{"unanswered tool call is dropped", 7, 7},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fork, err := history.Fork(test.index)
+			if err != nil {
+				t.Fatalf("Fork failed: %v", err)
+			}
+			if len(fork.Messages) != test.expected {
+				t.Errorf("Expected %d messages, got %d", test.expected, len(fork.Messages))
+			}
+			if fork.CurrentSession == history.CurrentSession {
+				t.Errorf("Expected the fork to get a new session ID")
+			}
+		})
+	}
+
+	fork, _ := history.Fork(2)
+	fork.Messages[2].ToolCalls[0].ID = "changed"
+	fork.AddMessage(Message{Role: "user", Content: "What if?"})
+	if history.Messages[2].ToolCalls[0].ID != "call_1" || len(history.Messages) != 8 {
+		t.Errorf("Expected the original history to be preserved")
+	}
+
+	if _, err := history.Fork(8); err == nil {
+		t.Errorf("Expected an error for an out-of-range index")
+	}
+}
+
+func TestAppendMessageValidation(t *testing.T) {
+	history := &ConversationHistory{MaxTokenCount: 1000, CurrentSession: "test"}
+	history.AddMessage(Message{Role: "user", Content: "List the files"})
+	toolCall := func(id string) Message {
+		return Message{Role: "assistant", ToolCalls: []ToolCall{{ID: id, Type: "function", Function: FunctionCall{Name: "shell", Arguments: `{"command":"ls"}`}}}}
+	}
+	toolResult := func(id string) Message {
+		return Message{Role: "tool", ToolCallID: id, Name: "shell", Content: `{"output":"main.go"}`}
+	}
+
+	// Orphan tool results are rejected
+	err := history.AppendMessage(toolResult("call_missing"))
+	if !errors.Is(err, ErrOrphanToolResult) {
+		t.Errorf("Expected ErrOrphanToolResult, got %v", err)
+	}
+	if len(history.Messages) != 1 {
+		t.Errorf("Expected the orphan result to be rejected, got %d messages", len(history.Messages))
+	}
+
+	// Tool calls need IDs to be answerable
+	if err := history.AppendMessage(toolCall("")); !errors.Is(err, ErrInvalidToolCall) {
+		t.Errorf("Expected ErrInvalidToolCall, got %v", err)
+	}
+
+	if err := history.AppendMessage(toolCall("call_1")); err != nil {
+		t.Fatalf("Expected the tool call to be added, got %v", err)
+	}
+
+	// Interrupting an unanswered call is flagged but accepted
+	err = history.AppendMessage(Message{Role: "user", Content: "Never mind"})
+	if !errors.Is(err, ErrUnansweredToolCalls) {
+		t.Errorf("Expected ErrUnansweredToolCalls, got %v", err)
+	}
+	if len(history.Messages) != 3 {
+		t.Errorf("Expected the flagged message to be added, got %d messages", len(history.Messages))
+	}
+
+	if err := history.AppendMessage(toolResult("call_1")); err != nil {
+		t.Errorf("Expected the matching result to be added, got %v", err)
+	}
+	if err := history.AppendMessage(toolResult("call_1")); !errors.Is(err, ErrOrphanToolResult) {
+		t.Errorf("Expected a second result for the same call to be rejected, got %v", err)
+	}
+
+	// AppendMessages stops at the first rejected message
+	err = history.AppendMessages([]Message{
+		{Role: "assistant", Content: "Done"},
+		{Role: "tool", ToolCallID: "call_2", Content: `{"output":""}`},
+		{Role: "user", Content: "Not added"},
+	})
+	if !errors.Is(err, ErrOrphanToolResult) {
+		t.Errorf("Expected ErrOrphanToolResult from AppendMessages, got %v", err)
+	}
+	if last, _ := history.GetLastMessage(); last.Content != "Done" {
+		t.Errorf("Expected messages after the rejected one to be skipped, last is %q", last.Content)
+	}
+
+	// AddMessage adds without checking, and later checks still see its calls
+	history.AddMessage(toolCall("call_3"))
+	if err := history.AppendMessage(toolResult("call_3")); err != nil {
+		t.Errorf("Expected the result of a call added with AddMessage to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidOrdering is reported by Validate for messages in an order the
+// chat API rejects
+var ErrInvalidOrdering = errors.New("invalid message order")
+
+// Validate checks the tool call invariants of the history without changing
+// it, and returns every violation found, in message order:
+//
+//   - every tool call has an ID, unique across the history, and is made by
+//     an assistant message
+//   - every tool call has exactly one result, and every result answers a call
+//   - the results of a message's calls directly follow it, before any other
+//     message
+//
+// Tool calls still awaiting results at the end of the history are reported
+// too, as a session saved mid-turn cannot be replayed as it is. Errors wrap
+// ErrInvalidToolCall, ErrOrphanToolResult, ErrUnansweredToolCalls or
+// ErrInvalidOrdering.
+func (h *ConversationHistory) Validate() []error {
+	var errs []error
+	seen := make(map[string]int)     // Tool call ID -> index of the message making it
+	answered := make(map[string]int) // Tool call ID -> index of its first result
+	open := make(map[string]bool)    // Calls of the latest assistant message without results yet
+	openAt := -1                     // Index of the message whose calls are open
+
+	// closeOpen reports the calls left without results when message i ends the block
+	closeOpen := func(i int, role string) {
+		if len(open) == 0 {
+			return
+		}
+		ids := make([]string, 0, len(open))
+		for id := range open {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		if i < 0 {
+			errs = append(errs, fmt.Errorf("message %d: %w: the history ends before results for %s", openAt, ErrUnansweredToolCalls, strings.Join(ids, ", ")))
+		} else {
+			errs = append(errs, fmt.Errorf("message %d: %w: %s message before results for %s of message %d", i, ErrUnansweredToolCalls, role, strings.Join(ids, ", "), openAt))
+		}
+		open = make(map[string]bool)
+	}
+
+	for i, msg := range h.Messages {
+		if msg.Role == "tool" {
+			switch first, done := answered[msg.ToolCallID]; {
+			case msg.ToolCallID == "":
+				errs = append(errs, fmt.Errorf("message %d: %w: tool result has no tool_call_id", i, ErrOrphanToolResult))
+			case open[msg.ToolCallID]:
+				delete(open, msg.ToolCallID)
+				answered[msg.ToolCallID] = i
+			case done:
+				errs = append(errs, fmt.Errorf("message %d: %w: second result for tool call %s, first answered by message %d", i, ErrOrphanToolResult, msg.ToolCallID, first))
+			default:
+				if at, ok := seen[msg.ToolCallID]; ok {
+					errs = append(errs, fmt.Errorf("message %d: %w: result for tool call %s of message %d does not directly follow it", i, ErrInvalidOrdering, msg.ToolCallID, at))
+					answered[msg.ToolCallID] = i
+				} else {
+					errs = append(errs, fmt.Errorf("message %d: %w: no tool call with ID %s precedes it", i, ErrOrphanToolResult, msg.ToolCallID))
+				}
+			}
+			if len(msg.ToolCalls) > 0 {
+				errs = append(errs, fmt.Errorf("message %d: %w: tool result makes tool calls", i, ErrInvalidToolCall))
+			}
+			continue
+		}
+
+		closeOpen(i, msg.Role)
+		if len(msg.ToolCalls) > 0 && msg.Role != "assistant" {
+			errs = append(errs, fmt.Errorf("message %d: %w: %s message makes tool calls", i, ErrInvalidToolCall, msg.Role))
+		}
+		for _, tc := range msg.ToolCalls {
+			if tc.ID == "" {
+				errs = append(errs, fmt.Errorf("message %d: %w: tool call to '%s' has no ID", i, ErrInvalidToolCall, tc.Function.Name))
+				continue
+			}
+			if at, dup := seen[tc.ID]; dup {
+				errs = append(errs, fmt.Errorf("message %d: %w: tool call ID %s is already used by message %d", i, ErrInvalidToolCall, tc.ID, at))
+				continue
+			}
+			seen[tc.ID] = i
+			open[tc.ID] = true
+		}
+		openAt = i
+	}
+	closeOpen(-1, "")
+	return errs
+}
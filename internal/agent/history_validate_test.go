@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// callMessage is an assistant message calling a tool once per ID
+func callMessage(ids ...string) Message {
+	msg := Message{Role: "assistant"}
+	for _, id := range ids {
+		msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: id, Type: "function", Function: FunctionCall{Name: "read_file"}})
+	}
+	return msg
+}
+
+// resultMessage is the result of tool call id
+func resultMessage(id string) Message {
+	return Message{Role: "tool", ToolCallID: id, Content: "ok"}
+}
+
+func TestValidateAcceptsConsistentHistory(t *testing.T) {
+	h := &ConversationHistory{Messages: []Message{
+		{Role: "system", Content: "prompt"},
+		{Role: "user", Content: "read both"},
+		callMessage("a", "b"),
+		resultMessage("b"),
+		resultMessage("a"),
+		{Role: "assistant", Content: "done"},
+	}}
+	if errs := h.Validate(); len(errs) != 0 {
+		t.Errorf("Expected no errors, got %v", errs)
+	}
+}
+
+func TestValidateReportsInconsistencies(t *testing.T) {
+	h := &ConversationHistory{Messages: []Message{
+		{Role: "user", Content: "go"},
+		callMessage("a", "b"),                            // 1
+		resultMessage("a"),                               // 2
+		{Role: "user", Content: "?"},                     // 3: b is left without a result
+		resultMessage("b"),                               // 4: late
+		resultMessage("a"),                               // 5: second result
+		resultMessage("zzz"),                             // 6: no such call
+		callMessage("a", ""),                             // 7: reused and missing IDs
+		{Role: "user", ToolCalls: []ToolCall{{ID: "u"}}}, // 8: calls from a user message, never answered
+	}}
+	errs := h.Validate()
+
+	want := []struct {
+		target error
+		text   string
+	}{
+		{ErrUnansweredToolCalls, "message 3: earlier tool calls have no results: user message before results for b of message 1"},
+		{ErrInvalidOrdering, "message 4: invalid message order: result for tool call b of message 1 does not directly follow it"},
+		{ErrOrphanToolResult, "message 5: tool result without a matching tool call: second result for tool call a, first answered by message 2"},
+		{ErrOrphanToolResult, "message 6: tool result without a matching tool call: no tool call with ID zzz precedes it"},
+		{ErrInvalidToolCall, "message 7: invalid tool call: tool call ID a is already used by message 1"},
+		{ErrInvalidToolCall, "message 7: invalid tool call: tool call to 'read_file' has no ID"},
+		{ErrInvalidToolCall, "message 8: invalid tool call: user message makes tool calls"},
+		{ErrUnansweredToolCalls, "message 8: earlier tool calls have no results: the history ends before results for u"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Expected %d errors, got %d:\n%v", len(want), len(errs), errs)
+	}
+	for i, w := range want {
+		if !errors.Is(errs[i], w.target) || !strings.Contains(errs[i].Error(), w.text) {
+			t.Errorf("Error %d: expected %q, got %q", i, w.text, errs[i])
+		}
+	}
+}
+
+func TestValidateDoesNotChangeHistory(t *testing.T) {
+	h := &ConversationHistory{Messages: []Message{callMessage("a"), resultMessage("b")}}
+	h.Validate()
+	if len(h.Messages) != 2 || h.Messages[1].ToolCallID != "b" {
+		t.Errorf("Expected the history unchanged, got %+v", h.Messages)
+	}
+}
@@ -0,0 +1,223 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ResponseHook transforms a response item before the handler receives it.
+// Hooks run in registration order, each on the previous hook's result. An
+// error replaces the item with an "error" item carrying the error message.
+// Hooks only change what the handler sees; the history keeps the model's output.
+type ResponseHook func(item ResponseItem) (ResponseItem, error)
+
+// DecisionAction is what a ToolCallInterceptor decides to do with a call
+type DecisionAction int
+
+const (
+	// ApproveCall surfaces the call unchanged
+	ApproveCall DecisionAction = iota
+	// RewriteCall surfaces the call with Decision.Arguments instead
+	RewriteCall
+	// RejectCall answers the call with an error without surfacing it
+	RejectCall
+)
+
+// Decision is a ToolCallInterceptor's verdict on a tool call
+type Decision struct {
+	Action    DecisionAction
+	Arguments string // Replacement JSON arguments for RewriteCall
+	Reason    string // Explanation given to the model for RejectCall
+}
+
+// ToolCallInterceptor inspects a tool call before it is surfaced to the handler.
+// Interceptors run in registration order; a rewrite is seen by later
+// interceptors and the first rejection stops the chain.
+type ToolCallInterceptor func(call FunctionCall) Decision
+
+// InputKind is which call an Input records
+type InputKind string
+
+const (
+	InputSendMessage    InputKind = "send_message"     // SendMessage
+	InputAddUserMessage InputKind = "add_user_message" // AddUserMessage
+	InputQueueMessage   InputKind = "queue_message"    // QueueMessage
+	InputSendQueued     InputKind = "send_queued"      // SendQueuedMessages
+	InputToolResult     InputKind = "tool_result"      // SendToolResult and SendFunctionResult
+	InputEdit           InputKind = "edit"             // EditAndRegenerate
+	InputClearHistory   InputKind = "clear_history"    // ClearHistory
+)
+
+// Input is a call that drove the agent: a message from the user, a tool
+// result from the host, or an edit of the history. Together with the
+// responses, the inputs of a session are enough to play it again.
+type Input struct {
+	Kind     InputKind   `json:"kind"`
+	Messages []Message   `json:"messages,omitempty"` // InputSendMessage
+	Content  string      `json:"content,omitempty"`  // InputAddUserMessage, InputQueueMessage and InputEdit
+	Index    int         `json:"index,omitempty"`    // Message edited by InputEdit
+	CallID   string      `json:"call_id,omitempty"`  // InputToolResult
+	Tool     string      `json:"tool,omitempty"`     // InputToolResult
+	Result   *ToolResult `json:"result,omitempty"`   // InputToolResult, as the host sent it
+}
+
+// InputHook is told of every input before the agent acts on it. It must not
+// call back into the agent.
+type InputHook func(input Input)
+
+// PreRequestHook is called with the messages of every request right before it
+// is sent to the API, e.g. to scan prompts or keep an audit trail. An error
+// aborts the request and is returned in its place. The hook must not modify
+// the messages or call back into the agent.
+type PreRequestHook func(ctx context.Context, messages []openai.ChatCompletionMessage) error
+
+// hookChain holds the registered hooks. Registration replaces the slices
+// rather than appending in place, so a snapshot taken at the start of a
+// request is unaffected by hooks registered while it streams.
+type hookChain struct {
+	mu           sync.Mutex
+	response     []ResponseHook
+	interceptors []ToolCallInterceptor
+	inputs       []InputHook
+	preRequest   PreRequestHook
+}
+
+// turnHooks is the hook chain as of the start of a request
+type turnHooks struct {
+	response     []ResponseHook
+	interceptors []ToolCallInterceptor
+}
+
+// RegisterResponseHook adds a hook applied to every response item before the
+// handler is called. Hooks registered during a turn apply from the next request.
+func (a *OpenAIAgent) RegisterResponseHook(hook ResponseHook) {
+	a.hooks.mu.Lock()
+	defer a.hooks.mu.Unlock()
+	a.hooks.response = append(a.hooks.response[:len(a.hooks.response):len(a.hooks.response)], hook)
+}
+
+// RegisterToolCallInterceptor adds an interceptor that can approve, rewrite or
+// reject tool calls before they are surfaced. Rejected calls are answered with
+// an error result so the model can react. Interceptors registered during a turn
+// apply from the next request.
+func (a *OpenAIAgent) RegisterToolCallInterceptor(interceptor ToolCallInterceptor) {
+	a.hooks.mu.Lock()
+	defer a.hooks.mu.Unlock()
+	a.hooks.interceptors = append(a.hooks.interceptors[:len(a.hooks.interceptors):len(a.hooks.interceptors)], interceptor)
+}
+
+// RegisterInputHook adds a hook told of every input, e.g. to record a session
+func (a *OpenAIAgent) RegisterInputHook(hook InputHook) {
+	a.hooks.mu.Lock()
+	defer a.hooks.mu.Unlock()
+	a.hooks.inputs = append(a.hooks.inputs[:len(a.hooks.inputs):len(a.hooks.inputs)], hook)
+}
+
+// SetPreRequestHook sets the hook called before every request to the API,
+// including continuations and follow-ups after tool results; nil removes it
+func (a *OpenAIAgent) SetPreRequestHook(hook PreRequestHook) {
+	a.hooks.mu.Lock()
+	defer a.hooks.mu.Unlock()
+	a.hooks.preRequest = hook
+}
+
+// ClearHooks removes all response hooks, tool call interceptors and input hooks
+func (a *OpenAIAgent) ClearHooks() {
+	a.hooks.mu.Lock()
+	defer a.hooks.mu.Unlock()
+	a.hooks.response = nil
+	a.hooks.interceptors = nil
+	a.hooks.inputs = nil
+}
+
+// notifyInput tells the input hooks of input
+func (a *OpenAIAgent) notifyInput(input Input) {
+	a.hooks.mu.Lock()
+	hooks := a.hooks.inputs
+	a.hooks.mu.Unlock()
+	for _, hook := range hooks {
+		hook(input)
+	}
+}
+
+// checkRequest runs the pre-request hook, if any, on the messages of req
+func (a *OpenAIAgent) checkRequest(ctx context.Context, req openai.ChatCompletionRequest) error {
+	a.hooks.mu.Lock()
+	hook := a.hooks.preRequest
+	a.hooks.mu.Unlock()
+	if hook == nil {
+		return nil
+	}
+	if err := hook(ctx, req.Messages); err != nil {
+		a.logger.Log("[INFO] Agent: Pre-request hook aborted the request: %v", err)
+		return err
+	}
+	return nil
+}
+
+// snapshot returns the hooks registered so far
+func (c *hookChain) snapshot() turnHooks {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return turnHooks{response: c.response, interceptors: c.interceptors}
+}
+
+// wrap returns a handler that runs the response hooks before calling handler
+func (h turnHooks) wrap(handler ResponseHandler) ResponseHandler {
+	if handler == nil || len(h.response) == 0 {
+		return handler
+	}
+	return func(itemJSON string) {
+		var item ResponseItem
+		if err := json.Unmarshal([]byte(itemJSON), &item); err != nil {
+			handler(itemJSON)
+			return
+		}
+		for _, hook := range h.response {
+			transformed, err := hook(item)
+			if err != nil {
+				item = ResponseItem{Type: EventError, Error: fmt.Sprintf("response hook failed on %s item: %v", item.Type, err)}
+				break
+			}
+			item = transformed
+		}
+		if data, err := json.Marshal(item); err == nil {
+			handler(string(data))
+		}
+	}
+}
+
+// intercept runs the interceptors on call. It returns the call to surface,
+// possibly with rewritten arguments, or the rejecting decision.
+func (h turnHooks) intercept(call FunctionCall) (FunctionCall, *Decision) {
+	for _, interceptor := range h.interceptors {
+		decision := interceptor(call)
+		switch decision.Action {
+		case RewriteCall:
+			call.Arguments = decision.Arguments
+		case RejectCall:
+			return call, &decision
+		}
+	}
+	return call, nil
+}
+
+// rejectedToolError is the error fed back to the model for a rejected call
+func rejectedToolError(name, reason string) string {
+	if reason == "" {
+		return fmt.Sprintf("the call to %s was rejected", name)
+	}
+	return fmt.Sprintf("the call to %s was rejected: %s", name, reason)
+}
+
+// sendRejectedToolWarning tells the handler an interceptor rejected a tool call
+func sendRejectedToolWarning(handler ResponseHandler, name, reason string) {
+	if reason == "" {
+		reason = "no reason given"
+	}
+	sendWarning(handler, fmt.Sprintf("A tool call interceptor rejected the call to %q: %s", name, reason))
+}
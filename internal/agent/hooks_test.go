@@ -0,0 +1,207 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// collectItems returns a handler that records the items it receives, except
+// the progress of streaming tool calls
+func collectItems(items *[]ResponseItem) ResponseHandler {
+	return func(itemJSON string) {
+		var item ResponseItem
+		if err := json.Unmarshal([]byte(itemJSON), &item); err == nil && item.Type != "function_call_progress" {
+			*items = append(*items, item)
+		}
+	}
+}
+
+func TestResponseHooksTransformItems(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, "[internal] The answer is 42", "A reply that is far too long")
+
+	a.RegisterResponseHook(func(item ResponseItem) (ResponseItem, error) {
+		if item.Message != nil {
+			msg := *item.Message
+			msg.Content = strings.TrimPrefix(msg.Content, "[internal] ")
+			item.Message = &msg
+		}
+		return item, nil
+	})
+	a.RegisterResponseHook(func(item ResponseItem) (ResponseItem, error) {
+		if item.Message != nil && len(item.Message.Content) > 20 {
+			return item, errors.New("response too long")
+		}
+		return item, nil
+	})
+
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "What is it?"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if len(items) != 1 || items[0].Type != "message" || items[0].Message.Content != "The answer is 42" {
+		t.Errorf("Expected the marker to be stripped, got %+v", items)
+	}
+	if last, _ := a.history.GetLastMessage(); last.Content != "[internal] The answer is 42" {
+		t.Errorf("Expected the history to keep the model's output, got %q", last.Content)
+	}
+
+	items = nil
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Again"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if len(items) != 1 || items[0].Type != "error" || !strings.Contains(items[0].Error, "response too long") {
+		t.Errorf("Expected the failing hook to produce an error item, got %+v", items)
+	}
+}
+
+func TestHooksRegisteredDuringTurnApplyToNextRequest(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, "first", "second")
+	a.RegisterResponseHook(func(item ResponseItem) (ResponseItem, error) {
+		a.RegisterResponseHook(func(item ResponseItem) (ResponseItem, error) {
+			return item, errors.New("late hook")
+		})
+		return item, nil
+	})
+
+	var items []ResponseItem
+	a.SendMessage(context.Background(), []Message{{Role: "user", Content: "one"}}, collectItems(&items))
+	if len(items) != 1 || items[0].Type != "message" {
+		t.Errorf("Expected the hook registered mid-turn not to run, got %+v", items)
+	}
+
+	items = nil
+	a.SendMessage(context.Background(), []Message{{Role: "user", Content: "two"}}, collectItems(&items))
+	if len(items) != 1 || items[0].Type != "error" {
+		t.Errorf("Expected the late hook to run on the next request, got %+v", items)
+	}
+}
+
+func TestToolCallInterceptorRewritesArguments(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, toolCallReply("shell", `{"command":"rm -rf build"}`))
+	a.RegisterToolCallInterceptor(func(call FunctionCall) Decision {
+		if call.Name == "shell" && strings.Contains(call.Arguments, "rm -rf") {
+			return Decision{Action: RewriteCall, Arguments: `{"command":"rm -ri build"}`}
+		}
+		return Decision{Action: ApproveCall}
+	})
+
+	var items []ResponseItem
+	endedWithTools, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Clean up"}}, collectItems(&items))
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if !endedWithTools || len(items) != 1 || items[0].FunctionCall == nil {
+		t.Fatalf("Expected one surfaced function call, got %+v", items)
+	}
+	if items[0].FunctionCall.Arguments != `{"command":"rm -ri build"}` {
+		t.Errorf("Expected rewritten arguments, got %s", items[0].FunctionCall.Arguments)
+	}
+	tc, found := a.history.FindToolCall(items[0].FunctionCall.ID)
+	if !found || tc.Function.Arguments != `{"command":"rm -ri build"}` {
+		t.Errorf("Expected the history to record the rewritten arguments, got %+v", tc)
+	}
+}
+
+func TestToolCallInterceptorRejectsCall(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, toolCallReply("shell", `{"command":"curl example.com"}`), "I'll work offline then.")
+	a.RegisterToolCallInterceptor(func(call FunctionCall) Decision {
+		return Decision{Action: RejectCall, Reason: "network access is not allowed"}
+	})
+
+	var items []ResponseItem
+	endedWithTools, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Fetch it"}}, collectItems(&items))
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if endedWithTools {
+		t.Errorf("Expected the turn to end without pending tool calls")
+	}
+	for _, item := range items {
+		if item.Type == "function_call" {
+			t.Errorf("Expected the rejected call not to be surfaced, got %+v", item.FunctionCall)
+		}
+	}
+
+	var toolResult string
+	for _, msg := range fake.lastRequest().Messages {
+		if msg.Role == openai.ChatMessageRoleTool {
+			toolResult = msg.Content
+		}
+	}
+	if !strings.Contains(toolResult, "network access is not allowed") {
+		t.Errorf("Expected the model to be told why the call was rejected, got %q", toolResult)
+	}
+	if last, _ := a.history.GetLastMessage(); last.Content != "I'll work offline then." {
+		t.Errorf("Expected the model's reaction last in history, got %+v", last)
+	}
+}
+
+func TestPreRequestHookAuditsAndAbortsRequests(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "Noted.")
+
+	var audited [][]openai.ChatCompletionMessage
+	errSecret := errors.New("prompt contains a credential")
+	a.SetPreRequestHook(func(ctx context.Context, messages []openai.ChatCompletionMessage) error {
+		audited = append(audited, messages)
+		if strings.Contains(messages[len(messages)-1].Content, "AKIA") {
+			return errSecret
+		}
+		return nil
+	})
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Remember the plan"}}, collectItems(new([]ResponseItem))); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if len(audited) != 1 || audited[0][len(audited[0])-1].Content != "Remember the plan" {
+		t.Fatalf("Expected the hook to see the request's messages, got %+v", audited)
+	}
+
+	_, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "The key is AKIAEXAMPLE"}}, collectItems(new([]ResponseItem)))
+	if !errors.Is(err, errSecret) {
+		t.Fatalf("Expected the hook's error to abort the request, got %v", err)
+	}
+	fake.mu.Lock()
+	sent := len(fake.requests)
+	fake.mu.Unlock()
+	if sent != 1 {
+		t.Errorf("Expected the aborted request not to reach the API, got %d requests", sent)
+	}
+
+	a.SetPreRequestHook(nil)
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Go on"}}, collectItems(new([]ResponseItem))); err != nil {
+		t.Fatalf("SendMessage failed after removing the hook: %v", err)
+	}
+}
+
+func TestPreRequestHookSeesSideRequests(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t)
+
+	errSecret := errors.New("secret in request")
+	a.SetPreRequestHook(func(ctx context.Context, messages []openai.ChatCompletionMessage) error {
+		for _, msg := range messages {
+			if strings.Contains(msg.Content, "AKIA") {
+				return errSecret
+			}
+		}
+		return nil
+	})
+
+	if _, err := a.Embedder().Embed(context.Background(), []string{"func main() {}", "key := \"AKIAEXAMPLE\""}); !errors.Is(err, errSecret) {
+		t.Errorf("Expected the hook to block the embedding request, got %v", err)
+	}
+	if _, err := a.summarizeConversation(context.Background(), "user: the key is AKIAEXAMPLE"); !errors.Is(err, errSecret) {
+		t.Errorf("Expected the hook to block the summary request, got %v", err)
+	}
+
+	fake.mu.Lock()
+	sent := len(fake.requests)
+	fake.mu.Unlock()
+	if sent != 0 {
+		t.Errorf("Expected the blocked requests not to reach the API, got %d requests", sent)
+	}
+}
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// SetQueuedMessages records the user messages waiting for the current turn
+// to end, so a session that crashes mid-turn still has them when recovered
+func (h *ConversationHistory) SetQueuedMessages(queued []string) {
+	if len(queued) == 0 && len(h.QueuedMessages) == 0 {
+		return
+	}
+	h.QueuedMessages = queued
+	h.UpdatedAt = time.Now()
+	h.journalChanges()
+
+	if h.EnablePersist && h.HistoryPath != "" {
+		h.Save(h.HistoryPath)
+	}
+}
+
+// QueueMessage holds a user message submitted while a turn is in progress.
+// Queued messages are not sent until SendQueuedMessages is called, usually
+// once the turn has ended; they are persisted with the session meanwhile.
+func (a *OpenAIAgent) QueueMessage(content string) error {
+	if strings.TrimSpace(content) == "" {
+		return errors.New("user message is empty")
+	}
+	a.notifyInput(Input{Kind: InputQueueMessage, Content: content})
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return ErrAgentClosed
+	}
+	if a.history == nil {
+		return errors.New("agent history is nil")
+	}
+	queued := append(append([]string{}, a.history.QueuedMessages...), content)
+	a.historyMu.Lock()
+	a.history.SetQueuedMessages(queued)
+	a.historyMu.Unlock()
+	a.logger.Log("[DEBUG] Agent.QueueMessage: Queued a user message (%d chars); %d waiting.", len(content), len(queued))
+	return nil
+}
+
+// QueuedMessages returns the user messages waiting to be sent, oldest first
+func (a *OpenAIAgent) QueuedMessages() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.history == nil {
+		return nil
+	}
+	return append([]string{}, a.history.QueuedMessages...)
+}
+
+// SendQueuedMessages sends the queued user messages, joined into one user
+// message, and streams the response like SendMessage. The queue is emptied as
+// the message is added to the history, and a "queued_messages_sent" item
+// carrying the message is emitted first. It returns false without a request
+// when nothing is queued. Like AddUserMessage it fails with
+// ErrInteractionInProgress unless the previous turn has ended or was
+// cancelled, leaving the queue as it is.
+func (a *OpenAIAgent) SendQueuedMessages(ctx context.Context, handler ResponseHandler) (bool, error) {
+	a.notifyInput(Input{Kind: InputSendQueued})
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return false, ErrAgentClosed
+	}
+	if a.history == nil || len(a.history.QueuedMessages) == 0 {
+		a.mu.Unlock()
+		return false, nil
+	}
+	if a.state == StateStreaming && !a.cancelRequested {
+		a.mu.Unlock()
+		return false, ErrInteractionInProgress
+	}
+	// A cancelled stream may still be writing to the history
+	if err := a.waitWhileStreaming(ctx); err != nil {
+		a.mu.Unlock()
+		return false, err
+	}
+	if a.closed {
+		a.mu.Unlock()
+		return false, ErrAgentClosed
+	}
+	if a.state != StateIdle {
+		a.mu.Unlock()
+		return false, fmt.Errorf("%w: the agent is %s", ErrInteractionInProgress, a.state)
+	}
+
+	queued := a.history.QueuedMessages
+	message := Message{Role: openai.ChatMessageRoleUser, Content: strings.Join(queued, "\n\n")}
+	a.abortPendingToolCalls()
+	if err := a.addToHistory(message); err != nil && messageRejected(err) {
+		a.mu.Unlock()
+		return false, fmt.Errorf("failed to add message to history: %w", err)
+	}
+	a.historyMu.Lock()
+	a.history.SetQueuedMessages(nil)
+	a.historyMu.Unlock()
+	a.addedInput = true
+	a.state = StateStreaming
+	a.beginTurn()
+	a.mu.Unlock()
+	defer a.finishStream()
+
+	a.logger.Log("[INFO] Agent.SendQueuedMessages: Sending %d queued message(s).", len(queued))
+	if data, err := json.Marshal(ResponseItem{Type: EventQueuedMessagesSent, Message: &message}); err == nil {
+		handler(string(data))
+	}
+	return a.streamMessage(ctx, nil, handler)
+}
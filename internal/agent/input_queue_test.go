@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSendQueuedMessages(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "Both done.")
+	ctx := context.Background()
+
+	// Nothing queued: no request is made
+	if _, err := a.SendQueuedMessages(ctx, func(string) {}); err != nil {
+		t.Fatalf("SendQueuedMessages failed: %v", err)
+	}
+	if len(fake.requests) != 0 {
+		t.Fatalf("Expected no request without queued messages, got %d", len(fake.requests))
+	}
+
+	if err := a.QueueMessage("also rename the package"); err != nil {
+		t.Fatalf("QueueMessage failed: %v", err)
+	}
+	if err := a.QueueMessage("and update the README"); err != nil {
+		t.Fatalf("QueueMessage failed: %v", err)
+	}
+	if err := a.QueueMessage("  "); err == nil {
+		t.Errorf("Expected an empty message to be rejected")
+	}
+	if got := a.QueuedMessages(); len(got) != 2 {
+		t.Fatalf("Expected 2 queued messages, got %v", got)
+	}
+
+	var items []ResponseItem
+	if _, err := a.SendQueuedMessages(ctx, collectItems(&items)); err != nil {
+		t.Fatalf("SendQueuedMessages failed: %v", err)
+	}
+
+	want := "also rename the package\n\nand update the README"
+	if countItems(items, "queued_messages_sent") != 1 || items[0].Type != "queued_messages_sent" || items[0].Message.Content != want {
+		t.Errorf("Expected a leading queued_messages_sent item with %q, got %+v", want, items)
+	}
+	req := fake.lastRequest()
+	if last := req.Messages[len(req.Messages)-1]; last.Role != "user" || last.Content != want {
+		t.Errorf("Expected the queued messages as one user message, got %s %q", last.Role, last.Content)
+	}
+	if got := a.QueuedMessages(); len(got) != 0 {
+		t.Errorf("Expected the queue to be empty after sending, got %v", got)
+	}
+	if got := a.GetHistory().QueuedMessages; len(got) != 0 {
+		t.Errorf("Expected no queued messages in the history after sending, got %v", got)
+	}
+}
+
+func TestQueuedMessagesRecoveredWithSession(t *testing.T) {
+	dir := t.TempDir()
+	a := newJournaledAgent(t, dir)
+	a.GetHistory().AddMessage(Message{Role: "user", Content: "refactor the parser"})
+	a.QueueMessage("keep the old API")
+	a.QueueMessage("skip the lexer")
+	id := a.SessionID()
+	crash(a)
+
+	saved, _, err := loadSession(dir, id)
+	if err != nil {
+		t.Fatalf("loadSession failed: %v", err)
+	}
+	if len(saved.QueuedMessages) != 2 || saved.QueuedMessages[0] != "keep the old API" || saved.QueuedMessages[1] != "skip the lexer" {
+		t.Errorf("Expected the queued messages to be recovered, got %v", saved.QueuedMessages)
+	}
+
+	// An emptied queue is journaled too
+	b := newJournaledAgent(t, dir)
+	if err := b.RecoverSession(id); err != nil {
+		t.Fatalf("RecoverSession failed: %v", err)
+	}
+	b.GetHistory().SetQueuedMessages(nil)
+	crash(b)
+	saved, _, err = loadSession(dir, id)
+	if err != nil {
+		t.Fatalf("loadSession failed: %v", err)
+	}
+	if len(saved.QueuedMessages) != 0 {
+		t.Errorf("Expected no queued messages after the queue was emptied, got %v", saved.QueuedMessages)
+	}
+}
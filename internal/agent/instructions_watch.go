@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"os"
+	"time"
+)
+
+// DefaultInstructionsPollInterval is how often a watched instructions file is checked for changes
+const DefaultInstructionsPollInterval = 2 * time.Second
+
+// SetSystemPrompt replaces the system prompt of the conversation. While a
+// turn is in progress the change is held back and applied with the next
+// request, so a turn is answered under the prompt it started with.
+func (a *OpenAIAgent) SetSystemPrompt(prompt string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.historyOpts.SystemPrompt = prompt
+	if a.state == StateIdle {
+		a.historyMu.Lock()
+		a.history.SetSystemPrompt(prompt)
+		a.historyMu.Unlock()
+		a.pendingSystemPrompt = nil
+		return
+	}
+	a.pendingSystemPrompt = &prompt
+}
+
+// applyPendingSystemPrompt applies a prompt set during the previous turn. The
+// caller must hold a.mu.
+func (a *OpenAIAgent) applyPendingSystemPrompt() {
+	if a.pendingSystemPrompt == nil {
+		return
+	}
+	a.historyMu.Lock()
+	a.history.SetSystemPrompt(*a.pendingSystemPrompt)
+	a.historyMu.Unlock()
+	a.pendingSystemPrompt = nil
+}
+
+// startInstructionsWatch polls the instructions file and reloads the system
+// prompt whenever its size or modification time changes
+func (a *OpenAIAgent) startInstructionsWatch(path string, interval time.Duration) {
+	a.instructionsStop = make(chan struct{})
+	a.instructionsDone = make(chan struct{})
+	go a.instructionsWatchLoop(path, fileState(path), interval)
+}
+
+// instructionsWatchLoop reloads the instructions from path every time it
+// changes from the last version seen, until stopped
+func (a *OpenAIAgent) instructionsWatchLoop(path string, last fileVersion, interval time.Duration) {
+	defer close(a.instructionsDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.instructionsStop:
+			return
+		case <-ticker.C:
+			state := fileState(path)
+			if state == last {
+				continue
+			}
+			last = state
+
+			// Keep the current prompt while the file is missing, e.g. mid-save
+			data, err := os.ReadFile(path)
+			if err != nil {
+				a.logger.Log("[WARN] Agent.instructionsWatch: Failed to reload %s: %v", path, err)
+				continue
+			}
+			a.SetSystemPrompt(buildSystemPrompt(a.config, string(data), DefaultHistoryOptions().SystemPrompt))
+			a.logger.Log("[INFO] Agent.instructionsWatch: Reloaded instructions from %s", path)
+		}
+	}
+}
+
+// stopInstructionsWatch stops the instructions watch, if one is running
+func (a *OpenAIAgent) stopInstructionsWatch() {
+	if a.instructionsStop == nil {
+		return
+	}
+	close(a.instructionsStop)
+	<-a.instructionsDone
+}
+
+// fileVersion identifies the version of a file by its size and modification time
+type fileVersion struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// fileState returns the current version of path; the zero value if it does not exist
+func fileState(path string) fileVersion {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{exists: true, size: info.Size(), modTime: info.ModTime()}
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetSystemPromptReplacesLeadingSystemMessage(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "first", "second")
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "hi"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	a.SetSystemPrompt("You are terse.")
+	messages := a.GetHistory().GetMessages()
+	if messages[0].Role != "system" || messages[0].Content != "You are terse." {
+		t.Errorf("Expected the new system prompt first, got %+v", messages[0])
+	}
+	if len(messages) != 3 {
+		t.Errorf("Expected the conversation to be kept, got %d messages", len(messages))
+	}
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "again"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if got := fake.lastRequest().Messages[0].Content; got != "You are terse." {
+		t.Errorf("Expected the request to use the new system prompt, got %q", got)
+	}
+}
+
+func TestHistorySetSystemPromptWithoutSystemMessage(t *testing.T) {
+	h, err := NewConversationHistory(HistoryOptions{MaxTokenCount: 8000})
+	if err != nil {
+		t.Fatalf("Failed to create history: %v", err)
+	}
+	h.AddMessage(Message{Role: "user", Content: "hi"})
+
+	h.SetSystemPrompt("prompt")
+	if len(h.Messages) != 2 || h.Messages[0].Role != "system" || h.Messages[0].Content != "prompt" {
+		t.Errorf("Expected a system message to be inserted first, got %+v", h.Messages)
+	}
+
+	h.SetSystemPrompt("")
+	if len(h.Messages) != 1 || h.Messages[0].Role != "user" {
+		t.Errorf("Expected an empty prompt to remove the system message, got %+v", h.Messages)
+	}
+}
+
+func TestInstructionsWatchReloadsChangedFile(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t)
+	path := filepath.Join(t.TempDir(), "instructions.md")
+	if err := os.WriteFile(path, []byte("Version one."), 0644); err != nil {
+		t.Fatalf("Failed to write instructions: %v", err)
+	}
+
+	a.startInstructionsWatch(path, 5*time.Millisecond)
+	defer a.Close()
+
+	later := time.Now().Add(time.Minute)
+	if err := os.WriteFile(path, []byte("Version two, longer."), 0644); err != nil {
+		t.Fatalf("Failed to write instructions: %v", err)
+	}
+	os.Chtimes(path, later, later)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		a.mu.Lock()
+		prompt := a.history.Messages[0].Content
+		a.mu.Unlock()
+		if strings.HasPrefix(prompt, "Version two, longer.") {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("Expected the system prompt to be reloaded from the changed file")
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/usagelog"
+)
+
+// InteractionState is where the agent is in a request/response cycle. Only
+// one request streams at a time, and tool results are accepted only while
+// the calls they answer are outstanding, so history writes never interleave.
+type InteractionState int
+
+const (
+	// StateIdle means no request is streaming and no tool results are expected
+	StateIdle InteractionState = iota
+	// StateStreaming means a request is streaming and writing to the history
+	StateStreaming
+	// StateAwaitingToolResults means the last stream requested tool calls
+	// whose results have not all arrived
+	StateAwaitingToolResults
+)
+
+// String returns the state's name
+func (s InteractionState) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateStreaming:
+		return "streaming"
+	case StateAwaitingToolResults:
+		return "awaiting tool results"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	// ErrInteractionInProgress is returned by SendMessage while another request
+	// is streaming. Cancel the running request first to queue a new one behind it.
+	ErrInteractionInProgress = errors.New("another interaction is in progress")
+	// ErrNoToolResultsAwaited is returned by SendFunctionResult when the agent
+	// is not waiting for tool results, e.g. after the turn was cancelled
+	ErrNoToolResultsAwaited = errors.New("no tool results are awaited")
+	// ErrUnknownToolCall is returned by SendFunctionResult for a call ID that
+	// is not pending, e.g. one that was already answered
+	ErrUnknownToolCall = errors.New("tool call is not pending")
+	// ErrOrphanedToolResult is returned by SendFunctionResult under the "error"
+	// orphaned_tool_results policy when the last result of a turn arrives after
+	// its interaction ended. The result is in the history, but no follow-up
+	// request was made.
+	ErrOrphanedToolResult = errors.New("tool result has no interaction to continue")
+	// ErrAgentClosed is returned by SendMessage and SendFunctionResult once
+	// Close has been called
+	ErrAgentClosed = errors.New("agent is closed")
+	// ErrCloseCancelFailed is wrapped by Close's error when the in-flight
+	// request did not stop in time; the history was saved as it stood
+	ErrCloseCancelFailed = errors.New("cancel failed")
+	// ErrCloseSaveFailed is wrapped by Close's error when the history or
+	// session could not be saved where configured. The error says where the
+	// history was saved instead, if anywhere.
+	ErrCloseSaveFailed = errors.New("save failed")
+)
+
+// closeSettleTimeout bounds how long Close waits for a cancelled request to
+// finish writing to the history, e.g. when Close is called from a handler
+// running on the stream itself
+const closeSettleTimeout = 5 * time.Second
+
+// State returns the agent's current interaction state
+func (a *OpenAIAgent) State() InteractionState {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state
+}
+
+// waitWhileStreaming blocks until no request is streaming or ctx is done.
+// The caller must hold a.mu, which is released while waiting.
+func (a *OpenAIAgent) waitWhileStreaming(ctx context.Context) error {
+	if a.state != StateStreaming {
+		return nil
+	}
+	stop := context.AfterFunc(ctx, func() {
+		a.mu.Lock()
+		a.stateChanged.Broadcast()
+		a.mu.Unlock()
+	})
+	defer stop()
+	for a.state == StateStreaming {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		a.stateChanged.Wait()
+	}
+	return nil
+}
+
+// finishStream leaves the Streaming state once the stream has finished writing
+// to the history. A cancelled stream returns to Idle; its pending calls are
+// answered as aborted by the next SendMessage.
+func (a *OpenAIAgent) finishStream() {
+	a.mu.Lock()
+	a.pendingMu.Lock()
+	pending := len(a.pendingToolCalls)
+	a.pendingMu.Unlock()
+
+	var record *usagelog.Record
+	if pending > 0 && !a.cancelRequested {
+		a.state = StateAwaitingToolResults
+	} else {
+		a.state = StateIdle
+		record = a.endTurn()
+	}
+	a.cancelRequested = false
+	a.logger.Log("[DEBUG] Agent.finishStream: Stream finished; now %s.", a.state)
+	a.stateChanged.Broadcast()
+	a.mu.Unlock()
+	a.logTurnUsage(record)
+}
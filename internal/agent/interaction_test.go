@@ -0,0 +1,480 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// checkToolPairing fails the test unless every tool call in messages is
+// answered exactly once, by the tool messages directly following its request
+func checkToolPairing(t *testing.T, messages []Message) {
+	t.Helper()
+	for i := 0; i < len(messages); i++ {
+		if len(messages[i].ToolCalls) == 0 {
+			if messages[i].Role == openai.ChatMessageRoleTool {
+				t.Fatalf("Message %d: tool result %s does not follow its call", i, messages[i].ToolCallID)
+			}
+			continue
+		}
+		unanswered := make(map[string]bool)
+		for _, tc := range messages[i].ToolCalls {
+			unanswered[tc.ID] = true
+		}
+		for i+1 < len(messages) && messages[i+1].Role == openai.ChatMessageRoleTool {
+			i++
+			if !unanswered[messages[i].ToolCallID] {
+				t.Fatalf("Message %d: unexpected or duplicate result for %s", i, messages[i].ToolCallID)
+			}
+			delete(unanswered, messages[i].ToolCallID)
+		}
+		if len(unanswered) > 0 {
+			t.Fatalf("Message %d: tool calls %v have no results", i, unanswered)
+		}
+	}
+}
+
+// callCollector is a handler that queues surfaced tool calls
+type callCollector struct {
+	mu    sync.Mutex
+	calls []FunctionCall
+}
+
+func (c *callCollector) handle(itemJSON string) {
+	var items []ResponseItem
+	collectItems(&items)(itemJSON)
+	for _, item := range items {
+		if item.Type == "function_call" && item.FunctionCall != nil {
+			c.mu.Lock()
+			c.calls = append(c.calls, *item.FunctionCall)
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *callCollector) pop() (FunctionCall, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.calls) == 0 {
+		return FunctionCall{}, false
+	}
+	call := c.calls[0]
+	c.calls = c.calls[1:]
+	return call, true
+}
+
+func TestSendMessageRejectedWhileStreaming(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, "first", "second")
+
+	var nestedErr error
+	handler := func(string) {
+		if nestedErr == nil {
+			_, nestedErr = a.SendMessage(context.Background(), []Message{{Role: "user", Content: "interrupting"}}, func(string) {})
+		}
+	}
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "hello"}}, handler); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if !errors.Is(nestedErr, ErrInteractionInProgress) {
+		t.Errorf("Expected ErrInteractionInProgress, got %v", nestedErr)
+	}
+	if a.State() != StateIdle {
+		t.Errorf("Expected the agent to be idle, got %s", a.State())
+	}
+	for _, msg := range a.history.GetMessages() {
+		if msg.Content == "interrupting" {
+			t.Errorf("Expected the rejected message to stay out of the history")
+		}
+	}
+}
+
+func TestResultWaitsForStreamToFinish(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, toolCallReply("shell", `{"command":"ls"}`), "Listed.")
+
+	// The result is sent as soon as the call is surfaced, before the stream has recorded it
+	results := make(chan error, 1)
+	handler := func(itemJSON string) {
+		var items []ResponseItem
+		collectItems(&items)(itemJSON)
+		if len(items) == 1 && items[0].Type == "function_call" {
+			go func() {
+				results <- a.SendFunctionResult(context.Background(), items[0].FunctionCall.ID, "shell", "main.go", true)
+			}()
+		}
+	}
+	endedWithTools, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "List files"}}, handler)
+	if err != nil || !endedWithTools {
+		t.Fatalf("Expected the turn to end with a tool call, got %t, %v", endedWithTools, err)
+	}
+	if err := <-results; err != nil {
+		t.Fatalf("SendFunctionResult failed: %v", err)
+	}
+
+	messages := a.history.GetMessages()
+	checkToolPairing(t, messages)
+	if last := messages[len(messages)-1]; last.Content != "Listed." {
+		t.Errorf("Expected the follow-up answer last, got %+v", last)
+	}
+	if a.State() != StateIdle {
+		t.Errorf("Expected the agent to be idle, got %s", a.State())
+	}
+}
+
+func TestFollowUpWaitsForEveryResult(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "Both done.")
+	a.history.AddMessage(Message{Role: "user", Content: "Run both"})
+	a.history.AddMessage(Message{Role: openai.ChatMessageRoleAssistant, ToolCalls: []ToolCall{
+		{ID: "call_a", Type: "function", Function: FunctionCall{Name: "shell", Arguments: `{"command":"a"}`}},
+		{ID: "call_b", Type: "function", Function: FunctionCall{Name: "shell", Arguments: `{"command":"b"}`}},
+	}})
+	a.pendingToolCalls["call_a"] = true
+	a.pendingToolCalls["call_b"] = true
+	a.currentHandler = func(string) {}
+	a.state = StateAwaitingToolResults
+
+	if err := a.SendFunctionResult(context.Background(), "call_a", "shell", "a", true); err != nil {
+		t.Fatalf("SendFunctionResult failed: %v", err)
+	}
+	if len(fake.requests) != 0 || a.State() != StateAwaitingToolResults {
+		t.Fatalf("Expected no follow-up before every result arrived, got %d requests in state %s", len(fake.requests), a.State())
+	}
+	if err := a.SendFunctionResult(context.Background(), "call_a", "shell", "a", true); !errors.Is(err, ErrUnknownToolCall) {
+		t.Errorf("Expected ErrUnknownToolCall for a repeated result, got %v", err)
+	}
+	if err := a.SendFunctionResult(context.Background(), "call_b", "shell", "b", true); err != nil {
+		t.Fatalf("SendFunctionResult failed: %v", err)
+	}
+	if len(fake.requests) != 1 {
+		t.Errorf("Expected one follow-up request, got %d", len(fake.requests))
+	}
+	checkToolPairing(t, a.history.GetMessages())
+	if a.State() != StateIdle {
+		t.Errorf("Expected the agent to be idle, got %s", a.State())
+	}
+}
+
+func TestCancelReturnsToIdleAfterHistoryWrites(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, toolCallReply("shell", `{"command":"sleep 10"}`), "Fresh start.")
+
+	// A message sent right after cancelling waits for the cancelled stream
+	var callID string
+	queued := make(chan error, 1)
+	handler := func(itemJSON string) {
+		var items []ResponseItem
+		collectItems(&items)(itemJSON)
+		if len(items) == 1 && items[0].Type == "function_call" {
+			callID = items[0].FunctionCall.ID
+			a.Cancel()
+			go func() {
+				_, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Never mind"}}, func(string) {})
+				queued <- err
+			}()
+		}
+	}
+	a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Wait a while"}}, handler)
+	if err := <-queued; err != nil {
+		t.Fatalf("Queued SendMessage failed: %v", err)
+	}
+
+	if err := a.SendFunctionResult(context.Background(), callID, "shell", "done", true); !errors.Is(err, ErrNoToolResultsAwaited) {
+		t.Errorf("Expected ErrNoToolResultsAwaited for a cancelled call, got %v", err)
+	}
+	messages := a.history.GetMessages()
+	checkToolPairing(t, messages)
+	if last := messages[len(messages)-1]; last.Content != "Fresh start." {
+		t.Errorf("Expected the queued message's answer last, got %+v", last)
+	}
+	if a.State() != StateIdle {
+		t.Errorf("Expected the agent to be idle, got %s", a.State())
+	}
+}
+
+func TestCancelWhileAwaitingToolResults(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, toolCallReply("shell", `{"command":"ls"}`), "Moving on.")
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "List"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if a.State() != StateAwaitingToolResults {
+		t.Fatalf("Expected the agent to await tool results, got %s", a.State())
+	}
+
+	a.Cancel()
+	if a.State() != StateIdle {
+		t.Errorf("Expected Cancel to return the agent to idle, got %s", a.State())
+	}
+	if err := a.SendFunctionResult(context.Background(), "call_1", "shell", "main.go", true); !errors.Is(err, ErrNoToolResultsAwaited) {
+		t.Errorf("Expected ErrNoToolResultsAwaited, got %v", err)
+	}
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Something else"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	checkToolPairing(t, a.history.GetMessages())
+}
+
+func TestCloseRacingAStreamSavesSettledHistory(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, toolCallReply("shell", `{"command":"ls"}`))
+	dir := t.TempDir()
+	a.historyOpts.HistoryPath = dir
+
+	// Close from several goroutines while the stream is still writing
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	var callID string
+	handler := func(itemJSON string) {
+		var items []ResponseItem
+		collectItems(&items)(itemJSON)
+		if len(items) == 1 && items[0].Type == "function_call" && callID == "" {
+			callID = items[0].FunctionCall.ID
+			for i := 0; i < cap(errs); i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs <- a.Close()
+				}()
+			}
+		}
+	}
+	a.SendMessage(context.Background(), []Message{{Role: "user", Content: "List"}}, handler)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, a.history.CurrentSession+".json"))
+	if err != nil {
+		t.Fatalf("Expected history to be saved on Close: %v", err)
+	}
+	var saved ConversationHistory
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("Failed to parse saved history: %v", err)
+	}
+	if got, want := len(saved.Messages), len(a.history.GetMessages()); got != want {
+		t.Errorf("Expected the saved history to hold all %d messages, got %d", want, got)
+	}
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Again"}}, func(string) {}); !errors.Is(err, ErrAgentClosed) {
+		t.Errorf("Expected ErrAgentClosed from SendMessage, got %v", err)
+	}
+	if err := a.SendFunctionResult(context.Background(), callID, "shell", "main.go", true); !errors.Is(err, ErrAgentClosed) {
+		t.Errorf("Expected ErrAgentClosed from SendFunctionResult, got %v", err)
+	}
+	if a.State() == StateStreaming {
+		t.Errorf("Expected no stream to be running after Close")
+	}
+}
+
+func TestConcurrentInteractionsKeepHistoryConsistent(t *testing.T) {
+	var replies []string
+	for i := 0; i < 60; i++ {
+		replies = append(replies, toolCallReply("shell", fmt.Sprintf(`{"command":"echo %d"}`, i)), fmt.Sprintf("answer %d", i))
+	}
+	a, fake := newFakeOpenAIAgent(t, replies...)
+
+	var wg sync.WaitGroup
+	for d := 0; d < 4; d++ {
+		wg.Add(1)
+		go func(d int) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				calls := &callCollector{}
+				_, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: fmt.Sprintf("driver %d message %d", d, i)}}, calls.handle)
+				if err != nil && !errors.Is(err, ErrInteractionInProgress) && !errors.Is(err, context.Canceled) {
+					t.Errorf("SendMessage failed: %v", err)
+				}
+				for {
+					call, ok := calls.pop()
+					if !ok {
+						break
+					}
+					err := a.SendFunctionResult(context.Background(), call.ID, call.Name, "ok", true)
+					if err != nil && !errors.Is(err, ErrNoToolResultsAwaited) && !errors.Is(err, ErrUnknownToolCall) && !errors.Is(err, context.Canceled) {
+						t.Errorf("SendFunctionResult failed: %v", err)
+					}
+				}
+			}
+		}(d)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			a.Cancel()
+			_ = a.State()
+		}
+	}()
+	wg.Wait()
+
+	// Anything still pending is answered as aborted by the next message
+	a.Cancel()
+	fake.mu.Lock()
+	fake.replies = nil
+	fake.mu.Unlock()
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "final"}}, func(string) {}); err != nil {
+		t.Fatalf("Final SendMessage failed: %v", err)
+	}
+	if a.State() == StateStreaming {
+		t.Errorf("Expected no stream to be running")
+	}
+	checkToolPairing(t, a.history.GetMessages())
+}
+
+func TestOrphanedToolResultPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy       config.OrphanedResultPolicy
+		wantErr      error
+		wantRequests int
+	}{
+		{"", nil, 1},
+		{config.OrphanedResultDrop, nil, 1},
+		{config.OrphanedResultError, ErrOrphanedToolResult, 1},
+		{config.OrphanedResultFollowUp, nil, 2},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			a, fake := newFakeOpenAIAgent(t, toolCallReply("shell", `{"command":"ls"}`), "Listed.")
+			a.config.OrphanedToolResults = tc.policy
+			if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "List"}}, func(string) {}); err != nil {
+				t.Fatalf("SendMessage failed: %v", err)
+			}
+			a.FinalizeInteraction()
+
+			err := a.SendFunctionResult(context.Background(), "call_1", "shell", "main.go", true)
+			if !errors.Is(err, tc.wantErr) || (tc.wantErr == nil && err != nil) {
+				t.Errorf("Expected error %v, got %v", tc.wantErr, err)
+			}
+			if len(fake.requests) != tc.wantRequests {
+				t.Errorf("Expected %d requests, got %d", tc.wantRequests, len(fake.requests))
+			}
+
+			// The result is recorded under every policy
+			messages := a.history.GetMessages()
+			checkToolPairing(t, messages)
+			if _, found := a.history.FindToolCall("call_1"); !found {
+				t.Errorf("Expected the tool call in the history")
+			}
+			if last := messages[len(messages)-1]; (last.Content == "Listed.") != (tc.wantRequests == 2) {
+				t.Errorf("Expected the follow-up answer only after a follow-up request, got %+v", last)
+			}
+			if a.State() != StateIdle {
+				t.Errorf("Expected the agent to be idle, got %s", a.State())
+			}
+		})
+	}
+}
+
+func TestAddUserMessageBuildsContextForNextRequest(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "Noted both.")
+	for _, note := range []string{"The API lives in server/", "Tests use testify"} {
+		if err := a.AddUserMessage(note); err != nil {
+			t.Fatalf("AddUserMessage failed: %v", err)
+		}
+	}
+	if len(fake.requests) != 0 {
+		t.Fatalf("Expected no request before SendMessage, got %d", len(fake.requests))
+	}
+	if err := a.AddUserMessage("  "); err == nil {
+		t.Errorf("Expected an empty message to be refused")
+	}
+
+	if _, err := a.SendMessage(context.Background(), nil, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	var users []string
+	for _, msg := range fake.requests[0].Messages {
+		if msg.Role == openai.ChatMessageRoleUser {
+			users = append(users, msg.Content)
+		}
+	}
+	if len(users) != 2 || users[1] != "Tests use testify" {
+		t.Errorf("Expected the request to carry both notes, got %v", users)
+	}
+	if last, _ := a.history.GetLastMessage(); last.Content != "Noted both." {
+		t.Errorf("Expected the reply last, got %+v", last)
+	}
+}
+
+func TestAddUserMessageAnswersAbortedCallsFirst(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, toolCallReply("shell", `{"command":"ls"}`), "Fine.")
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "List"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if err := a.AddUserMessage("Actually, wait"); !errors.Is(err, ErrInteractionInProgress) {
+		t.Errorf("Expected ErrInteractionInProgress while results are awaited, got %v", err)
+	}
+
+	a.Cancel()
+	if err := a.AddUserMessage("Actually, wait"); err != nil {
+		t.Fatalf("AddUserMessage failed: %v", err)
+	}
+	messages := a.history.GetMessages()
+	checkToolPairing(t, messages)
+	if n := len(messages); messages[n-2].Role != openai.ChatMessageRoleTool || messages[n-1].Content != "Actually, wait" {
+		t.Errorf("Expected the aborted result before the note, got %+v", messages[n-2:])
+	}
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), nil, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	checkToolPairing(t, a.history.GetMessages())
+	if len(items) == 0 || items[0].Type != "tool_aborted" || items[0].FunctionCall == nil || items[0].FunctionCall.Name != "shell" || items[0].Error != abortedToolReason {
+		t.Fatalf("Expected a tool_aborted item for the shell call first, got %+v", items)
+	}
+	for _, item := range items[1:] {
+		if item.Type == "tool_aborted" {
+			t.Errorf("Expected the aborted call to be reported once, got %+v", items)
+		}
+	}
+}
+
+func TestCancelToolCallAnswersOnlyThatCall(t *testing.T) {
+	a := streamToolCallDeltas(t, []map[string]interface{}{
+		{"index": 0, "id": "call_runaway", "type": "function", "function": map[string]string{"name": "shell", "arguments": `{"command":"yes"}`}},
+		{"index": 1, "id": "call_read", "type": "function", "function": map[string]string{"name": "read_file", "arguments": `{"path":"go.mod"}`}},
+	})
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Look around"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	if err := a.CancelToolCall(context.Background(), "call_runaway"); err != nil {
+		t.Fatalf("CancelToolCall failed: %v", err)
+	}
+	if a.IsToolCallPending("call_runaway") || !a.IsToolCallPending("call_read") {
+		t.Fatalf("Expected only the cancelled call to be answered")
+	}
+	if a.State() != StateAwaitingToolResults {
+		t.Errorf("Expected the turn to wait for the other call, got %s", a.State())
+	}
+	// The killed execution's own result comes too late
+	err := a.SendToolResult(context.Background(), NewToolResult("call_runaway", "shell", "killed", false))
+	if !errors.Is(err, ErrUnknownToolCall) {
+		t.Errorf("Expected a late result for the cancelled call to be refused, got %v", err)
+	}
+	if err := a.CancelToolCall(context.Background(), "call_unknown"); !errors.Is(err, ErrUnknownToolCall) {
+		t.Errorf("Expected cancelling an unknown call to fail, got %v", err)
+	}
+
+	var cancelled *Message
+	for i, msg := range a.GetHistory().Messages {
+		if msg.ToolCallID == "call_runaway" {
+			cancelled = &a.GetHistory().Messages[i]
+		}
+	}
+	if cancelled == nil {
+		t.Fatalf("Expected a tool result for the cancelled call")
+	}
+	result, ok := ParseToolResult(cancelled.Content)
+	if !ok || result.Success || !strings.Contains(result.Error, ToolCallCancelled) {
+		t.Errorf("Expected a failed 'cancelled by user' result, got %q", cancelled.Content)
+	}
+}
@@ -2,15 +2,22 @@ package agent
 
 import (
 	"context"
+
+	"github.com/epuerta/codex-go/internal/fileops"
 )
 
 // Message represents a single message in a conversation
 type Message struct {
+	ID         string     `json:"id,omitempty"`    // Stable ID of an assistant reply, the same on all of its "message" updates
+	Model      string     `json:"model,omitempty"` // Model that wrote an assistant reply
 	Role       string     `json:"role"`
 	Content    string     `json:"content"`
 	ToolCallID string     `json:"tool_call_id,omitempty"`
 	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
 	Name       string     `json:"name,omitempty"`
+	Pinned     bool       `json:"pinned,omitempty"` // Kept by history pruning however old it is
+
+	ContentBlob string `json:"content_blob,omitempty"` // Hash of the content in the blob store, in saved sessions only
 }
 
 // ToolCall represents a tool call in a message
@@ -36,13 +43,21 @@ type FunctionCallOutput struct {
 	Success bool   // Whether the function call was successful
 }
 
-// ResponseItem represents a single response item from the AI
+// ResponseItem represents a single response item from the AI. Its JSON is
+// the event schema consumers rely on: see EventSchemaVersion before changing it.
 type ResponseItem struct {
-	Type             string              `json:"type"` // "message", "function_call", "followup_complete"
+	Type             EventType           `json:"type"`          // One of the Event* types; see docs/events.md
+	SchemaVersion    int                 `json:"schemaVersion"` // EventSchemaVersion when marshaled; 0 for items from before versioning
 	Message          *Message            `json:"message,omitempty"`
 	FunctionCall     *FunctionCall       `json:"functionCall,omitempty"`
 	FunctionOutput   *FunctionCallOutput `json:"functionOutput,omitempty"`
+	Error            string              `json:"error,omitempty"` // Set on "error" and "tool_aborted" items
 	ThinkingDuration int64               `json:"thinkingDuration"`
+	FinishReason     FinishReason        `json:"finishReason,omitempty"` // Set on the last item of a response
+	DiffStat         *fileops.DiffStat   `json:"diffStat,omitempty"`     // Set on "turn_diffstat" items
+	Progress         *CallProgress       `json:"progress,omitempty"`     // Set on "function_call_progress" items
+	Choice           int                 `json:"choice,omitempty"`       // Choice a "message" item belongs to, with choices > 1 (0 = the one driving the turn)
+	Refusal          *Refusal            `json:"refusal,omitempty"`      // Set on "refusal" items
 }
 
 // ResponseHandler is a callback for handling streaming response items
@@ -68,6 +83,10 @@ type Agent interface {
 	// Returns true if the stream finished requesting tool calls, false otherwise.
 	SendMessage(ctx context.Context, messages []Message, handler ResponseHandler) (bool, error)
 
+	// AddUserMessage appends a user message to the history without calling
+	// the API; the next SendMessage generates against it
+	AddUserMessage(content string) error
+
 	// SendFileChange sends a file change to the AI for approval
 	SendFileChange(ctx context.Context, filePath string, diff string) (*FileChangeConfirmation, error)
 
@@ -86,6 +105,11 @@ type Agent interface {
 	// Close closes the agent and releases any resources
 	Close() error
 
-	// SendFunctionResult sends a function result back to the agent
+	// SendFunctionResult sends a function result back to the agent.
+	//
+	// Deprecated: use SendToolResult.
 	SendFunctionResult(ctx context.Context, callID, functionName, output string, success bool) error
+
+	// SendToolResult sends the result of a tool call back to the agent
+	SendToolResult(ctx context.Context, result ToolResult) error
 }
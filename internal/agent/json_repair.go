@@ -0,0 +1,264 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrMalformedArguments is returned by RepairToolArguments for arguments that
+// are not a JSON object even after repair
+var ErrMalformedArguments = errors.New("malformed tool call arguments")
+
+// Repairs applied by RepairToolArguments, as reported in tool result metadata
+const (
+	repairExtracted      = "extracted object"
+	repairQuotes         = "normalized quotes"
+	repairTrailingCommas = "removed trailing commas"
+	repairControlChars   = "escaped control characters"
+	repairBackslashes    = "escaped backslashes"
+)
+
+// snippetContext is how many bytes around a syntax error are quoted back to the model
+const snippetContext = 24
+
+// RepairToolArguments returns tool call arguments as a JSON object. Valid
+// arguments are returned unchanged. Others get a repair pass for the mistakes
+// smaller models make: prose or code fences around the object, single or
+// typographic quotes, trailing commas, raw newlines and tabs in strings, and
+// backslashes that do not start an escape (Windows paths, regexes). The
+// repairs applied are returned. Arguments that still do not parse fail with
+// ErrMalformedArguments and an error quoting the malformed snippet.
+func RepairToolArguments(args string) (string, []string, error) {
+	trimmed := strings.TrimSpace(args)
+	if trimmed == "" || isJSONObject(trimmed) {
+		return args, nil, nil
+	}
+
+	var fixes []string
+	candidate := trimmed
+	if object, ok := extractJSONObject(trimmed); ok && object != trimmed {
+		candidate = object
+		fixes = append(fixes, repairExtracted)
+	}
+	if isJSONObject(candidate) {
+		return candidate, fixes, nil
+	}
+
+	repaired, normalized := normalizeJSON(candidate)
+	fixes = append(fixes, normalized...)
+	if isJSONObject(repaired) {
+		return repaired, fixes, nil
+	}
+	return "", nil, malformedJSONError(trimmed)
+}
+
+// isJSONObject reports whether s is a valid JSON object
+func isJSONObject(s string) bool {
+	return strings.HasPrefix(s, "{") && json.Valid([]byte(s))
+}
+
+// malformedJSONError describes why args do not parse, quoting the text
+// around the error
+func malformedJSONError(args string) error {
+	var value interface{}
+	err := json.Unmarshal([]byte(args), &value)
+	offset := len(args)
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		offset = int(syntaxErr.Offset)
+	} else if err == nil {
+		err = fmt.Errorf("arguments are a JSON %s, not an object", jsonKind(value))
+		offset = 0
+	}
+	start, end := max(offset-snippetContext, 0), min(offset+snippetContext, len(args))
+	for start > 0 && !utf8.RuneStart(args[start]) {
+		start--
+	}
+	for end < len(args) && !utf8.RuneStart(args[end]) {
+		end++
+	}
+	return fmt.Errorf("%w: %v, at %q", ErrMalformedArguments, err, args[start:end])
+}
+
+// jsonKind names the type of a decoded JSON value
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// extractJSONObject returns the first balanced {...} in s, skipping braces in
+// quoted strings
+func extractJSONObject(s string) (string, bool) {
+	start := strings.IndexByte(s, '{')
+	if start < 0 {
+		return "", false
+	}
+	depth := 0
+	var quote rune
+	escaped := false
+	for i, r := range s[start:] {
+		switch {
+		case quote != 0:
+			switch {
+			case escaped:
+				escaped = false
+			case r == '\\':
+				escaped = true
+			case r == quote || (quote == '”' && r == '“'):
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '“' || r == '”':
+			quote = '”'
+		case r == '{':
+			depth++
+		case r == '}':
+			depth--
+			if depth == 0 {
+				return s[start : start+i+1], true
+			}
+		}
+	}
+	return "", false
+}
+
+// normalizeJSON rewrites single- and typographically-quoted strings as
+// double-quoted ones, escapes control characters and stray backslashes in
+// strings, and drops commas before a closing brace or bracket
+func normalizeJSON(s string) (string, []string) {
+	var b strings.Builder
+	b.Grow(len(s))
+	applied := make(map[string]bool)
+	var quote rune // Delimiter of the string being copied, 0 outside strings
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if quote == 0 {
+			switch r {
+			case '"':
+				quote = '"'
+				b.WriteRune('"')
+			case '\'', '“', '”':
+				quote = r
+				if r == '“' {
+					quote = '”'
+				}
+				applied[repairQuotes] = true
+				b.WriteRune('"')
+			case ',':
+				j := i + 1
+				for j < len(runes) && strings.ContainsRune(" \t\r\n", runes[j]) {
+					j++
+				}
+				if j < len(runes) && (runes[j] == '}' || runes[j] == ']') {
+					applied[repairTrailingCommas] = true
+					continue
+				}
+				b.WriteRune(r)
+			default:
+				b.WriteRune(r)
+			}
+			continue
+		}
+
+		switch {
+		case r == '\\' && i+1 < len(runes):
+			next := runes[i+1]
+			switch {
+			case next == '\'':
+				applied[repairQuotes] = true
+				b.WriteRune('\'')
+				i++
+			case strings.ContainsRune(`"\/bfnrtu`, next):
+				b.WriteRune('\\')
+				b.WriteRune(next)
+				i++
+			default:
+				applied[repairBackslashes] = true
+				b.WriteString(`\\`)
+			}
+		case r == quote || (quote == '”' && r == '“'):
+			quote = 0
+			b.WriteRune('"')
+		case r == '"':
+			b.WriteString(`\"`)
+		case r < 0x20:
+			applied[repairControlChars] = true
+			switch r {
+			case '\n':
+				b.WriteString(`\n`)
+			case '\r':
+				b.WriteString(`\r`)
+			case '\t':
+				b.WriteString(`\t`)
+			default:
+				fmt.Fprintf(&b, `\u%04x`, r)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	var fixes []string
+	for _, fix := range []string{repairQuotes, repairTrailingCommas, repairControlChars, repairBackslashes} {
+		if applied[fix] {
+			fixes = append(fixes, fix)
+		}
+	}
+	return b.String(), fixes
+}
+
+// repairArguments repairs the arguments of call id, remembering the repairs
+// for its result's metadata. The nulls of calls to strict tools are dropped.
+func (a *OpenAIAgent) repairArguments(id, name, args string) (string, error) {
+	repaired, fixes, err := RepairToolArguments(args)
+	if err != nil {
+		a.logger.Log("[WARN] Agent: Arguments of call to '%s' (ID: %s) could not be repaired: %v", name, id, err)
+		return args, err
+	}
+	if len(fixes) > 0 {
+		a.logger.Log("[INFO] Agent: Repaired arguments of call to '%s' (ID: %s): %s", name, id, strings.Join(fixes, ", "))
+		a.pendingMu.Lock()
+		if a.repairedArgs == nil {
+			a.repairedArgs = make(map[string][]string)
+		}
+		a.repairedArgs[id] = fixes
+		a.pendingMu.Unlock()
+	}
+	if a.sentStrict(name) {
+		repaired = dropNullArguments(repaired)
+	}
+	return repaired, nil
+}
+
+// takeArgumentRepairs returns and forgets the repairs made to the arguments of call id
+func (a *OpenAIAgent) takeArgumentRepairs(id string) []string {
+	a.pendingMu.Lock()
+	defer a.pendingMu.Unlock()
+	fixes := a.repairedArgs[id]
+	delete(a.repairedArgs, id)
+	return fixes
+}
+
+// malformedArgumentsError is the tool result error for arguments beyond repair
+func malformedArgumentsError(name string, err error) string {
+	return fmt.Sprintf("the arguments of your call to %s are not valid JSON (%v). Send the call again with the arguments as one JSON object, using double quotes and escaping newlines in strings.", name, err)
+}
+
+// sendMalformedArgumentsWarning tells the handler a call was answered with a parse error
+func sendMalformedArgumentsWarning(handler ResponseHandler, name string) {
+	sendWarning(handler, fmt.Sprintf("The model sent malformed arguments to %q; it was asked to send the call again.", name))
+}
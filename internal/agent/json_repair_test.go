@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// argumentFixture is a tool call argument string as sent by a model, and what
+// RepairToolArguments should make of it
+type argumentFixture struct {
+	Name    string          `json:"name"`
+	Input   string          `json:"input"`
+	Want    json.RawMessage `json:"want"`    // Decoded result; null for arguments returned as they are
+	Repairs []string        `json:"repairs"` // Repairs expected to be reported
+	Error   string          `json:"error"`   // Snippet the error must quote, for arguments beyond repair
+}
+
+func TestRepairToolArgumentsCorpus(t *testing.T) {
+	data, err := os.ReadFile("testdata/malformed_arguments.json")
+	if err != nil {
+		t.Fatalf("Failed to read fixtures: %v", err)
+	}
+	var fixtures []argumentFixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatalf("Failed to parse fixtures: %v", err)
+	}
+
+	for _, fx := range fixtures {
+		t.Run(fx.Name, func(t *testing.T) {
+			got, repairs, err := RepairToolArguments(fx.Input)
+			if fx.Error != "" {
+				if !errors.Is(err, ErrMalformedArguments) {
+					t.Fatalf("Expected ErrMalformedArguments, got %v (result %q)", err, got)
+				}
+				if quoted, _ := json.Marshal(fx.Error); !strings.Contains(err.Error(), strings.Trim(string(quoted), `"`)) {
+					t.Errorf("Expected the error to quote %q, got %v", fx.Error, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RepairToolArguments failed: %v", err)
+			}
+			if string(fx.Want) == "null" {
+				if got != fx.Input {
+					t.Errorf("Expected the arguments unchanged, got %q", got)
+				}
+				return
+			}
+
+			var gotValue, wantValue interface{}
+			if err := json.Unmarshal([]byte(got), &gotValue); err != nil {
+				t.Fatalf("Repaired arguments are not valid JSON: %v (%q)", err, got)
+			}
+			json.Unmarshal(fx.Want, &wantValue)
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("Expected %s, got %s", fx.Want, got)
+			}
+			if !reflect.DeepEqual(repairs, fx.Repairs) {
+				t.Errorf("Expected repairs %v, got %v", fx.Repairs, repairs)
+			}
+		})
+	}
+}
+
+func TestRepairedArgumentsAreRecordedInResultMetadata(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, toolCallReply("shell", `{'command': 'ls',}`), "Listed.")
+
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "List"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if len(items) != 1 || items[0].FunctionCall == nil || items[0].FunctionCall.Arguments != `{"command": "ls"}` {
+		t.Fatalf("Expected the call with repaired arguments, got %+v", items)
+	}
+	call := items[0].FunctionCall
+	if err := a.SendFunctionResult(context.Background(), call.ID, call.Name, "main.go", true); err != nil {
+		t.Fatalf("SendFunctionResult failed: %v", err)
+	}
+
+	messages := a.history.GetMessages()
+	checkToolPairing(t, messages)
+	for _, msg := range messages {
+		if msg.Role != "tool" {
+			continue
+		}
+		result, ok := ParseToolResult(msg.Content)
+		if !ok || result.Metadata["arguments_repaired"] != "normalized quotes, removed trailing commas" {
+			t.Errorf("Expected the repairs in the result metadata, got %s", msg.Content)
+		}
+	}
+}
+
+func TestArgumentsBeyondRepairAreAnsweredWithTheSnippet(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, toolCallReply("shell", `{command: ls}`), "Retrying.")
+
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "List"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	for _, item := range items {
+		if item.Type == "function_call" {
+			t.Errorf("Expected the malformed call not to reach the handler, got %+v", item)
+		}
+	}
+
+	messages := a.history.GetMessages()
+	checkToolPairing(t, messages)
+	var answer string
+	for _, msg := range messages {
+		if msg.Role == "tool" {
+			answer = msg.Content
+		}
+	}
+	if !strings.Contains(answer, "not valid JSON") || !strings.Contains(answer, "{command: ls}") {
+		t.Errorf("Expected the error to quote the malformed arguments, got %q", answer)
+	}
+	if len(fake.requests) != 2 {
+		t.Errorf("Expected the model to get the error straight away, got %d requests", len(fake.requests))
+	}
+}
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/epuerta/codex-go/internal/blobstore"
+)
+
+// largeMessageBytes is the content size from which a session snapshot keeps
+// a message in the blob store instead of inline
+const largeMessageBytes = 32 * 1024
+
+// offloadMessages moves the content of h's large messages to store, leaving
+// their hash in ContentBlob, before h is written as a snapshot. h must not be
+// the live history. A nil store keeps every message inline.
+func offloadMessages(h *ConversationHistory, store *blobstore.Store) error {
+	if store == nil {
+		return nil
+	}
+	for i := range h.Messages {
+		msg := &h.Messages[i]
+		if len(msg.Content) < largeMessageBytes {
+			continue
+		}
+		hash, err := store.Put(h.CurrentSession, []byte(msg.Content))
+		if err != nil {
+			return fmt.Errorf("failed to store message content: %w", err)
+		}
+		msg.Content = ""
+		msg.ContentBlob = hash
+		h.BlobDir = store.Dir()
+	}
+	return nil
+}
+
+// resolveMessages restores the content of messages a snapshot kept in the
+// blob store. A message whose blob is gone says so instead of failing the
+// whole session.
+func resolveMessages(h *ConversationHistory) {
+	if h.BlobDir == "" {
+		return
+	}
+	store := blobstore.Open(h.BlobDir, false)
+	for i := range h.Messages {
+		msg := &h.Messages[i]
+		if msg.ContentBlob == "" {
+			continue
+		}
+		data, err := store.Get(msg.ContentBlob)
+		if err != nil {
+			msg.Content = fmt.Sprintf("[Message content unavailable: %v]", err)
+		} else {
+			msg.Content = string(data)
+		}
+		msg.ContentBlob = ""
+	}
+	h.BlobDir = ""
+}
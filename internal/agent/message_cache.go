@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"sync"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// messageCache keeps the API form of the conversation history so that requests
+// only convert the messages appended since the previous request. Any rewrite of
+// earlier messages (pruning, clearing, replacing a tool result) invalidates it.
+type messageCache struct {
+	mu        sync.Mutex
+	history   *ConversationHistory
+	rewrites  uint64
+	converted int                            // Number of history messages consumed
+	messages  []openai.ChatCompletionMessage // Converted messages sent to the API
+	expected  map[string]bool                // Tool call IDs still awaiting results
+
+	toolResults config.ToolResultFormat // How tool results are sent; the history keeps JSON
+}
+
+// newMessageCache creates an empty cache
+func newMessageCache() *messageCache {
+	return &messageCache{expected: make(map[string]bool)}
+}
+
+// build returns the API messages for the history, converting only new messages
+// when the cached prefix is still valid. The returned slice must not be appended to.
+// A history with a packer is packed and converted anew for every request.
+func (c *messageCache) build(h *ConversationHistory) []openai.ChatCompletionMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if h.Packer != nil {
+		packed := &messageCache{expected: make(map[string]bool), toolResults: c.toolResults}
+		var messages []openai.ChatCompletionMessage
+		for _, msg := range h.GetMessagesForContext() {
+			if apiMsg, ok := packed.convert(msg); ok {
+				messages = append(messages, apiMsg)
+			}
+		}
+		return messages[:len(messages):len(messages)]
+	}
+
+	if c.history != h || c.rewrites != h.rewrites || c.converted > len(h.Messages) {
+		c.history = h
+		c.rewrites = h.rewrites
+		c.converted = 0
+		c.messages = nil
+		c.expected = make(map[string]bool)
+	}
+
+	for _, msg := range h.Messages[c.converted:] {
+		if apiMsg, ok := c.convert(msg); ok {
+			c.messages = append(c.messages, apiMsg)
+		}
+	}
+	c.converted = len(h.Messages)
+
+	return c.messages[:len(c.messages):len(c.messages)]
+}
+
+// convert translates a single history message, keeping the
+// Assistant(ToolCall) -> Tool(Result) sequence strict: assistant text that
+// arrives while tool results are still pending is skipped. Caller must hold c.mu.
+func (c *messageCache) convert(msg Message) (openai.ChatCompletionMessage, bool) {
+	apiMsg := openai.ChatCompletionMessage{
+		Role:    msg.Role,
+		Content: msg.Content,
+	}
+
+	switch msg.Role {
+	case openai.ChatMessageRoleAssistant:
+		if len(msg.ToolCalls) > 0 {
+			apiMsg.ToolCalls = make([]openai.ToolCall, len(msg.ToolCalls))
+			for i, tc := range msg.ToolCalls {
+				apiMsg.ToolCalls[i] = openai.ToolCall{
+					ID:   tc.ID,
+					Type: openai.ToolType(tc.Type),
+					Function: openai.FunctionCall{
+						Name:      tc.Function.Name,
+						Arguments: tc.Function.Arguments,
+					},
+				}
+				c.expected[tc.ID] = true
+			}
+			apiMsg.Content = "" // Content MUST be empty/null when tool calls are present
+		} else if len(c.expected) > 0 {
+			return apiMsg, false
+		}
+
+	case openai.ChatMessageRoleTool:
+		apiMsg.ToolCallID = msg.ToolCallID
+		delete(c.expected, msg.ToolCallID)
+		if c.toolResults == config.ToolResultText {
+			if result, ok := ParseToolResult(msg.Content); ok {
+				apiMsg.Content = result.PlainText()
+			}
+		}
+
+	case openai.ChatMessageRoleUser:
+		// A new user turn abandons any tool calls that never got results
+		if len(c.expected) > 0 {
+			c.expected = make(map[string]bool)
+		}
+	}
+
+	return apiMsg, true
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// newBenchHistory builds a history of n messages cycling through user,
+// assistant tool call, tool result and assistant text
+func newBenchHistory(n int) *ConversationHistory {
+	h := &ConversationHistory{MaxTokenCount: 1 << 30}
+	h.AddMessage(Message{Role: "system", Content: "You are a helpful assistant."})
+	for i := 1; len(h.Messages) < n; i++ {
+		callID := fmt.Sprintf("call_%d", i)
+		h.AddMessage(Message{Role: "user", Content: fmt.Sprintf("Please look at file %d", i)})
+		h.AddToolMessage("read_file", map[string]interface{}{"path": fmt.Sprintf("file%d.go", i)}, callID)
+		h.AddToolResultMessage(callID, "read_file", map[string]interface{}{"output": "package main\n\nfunc main() {}\n"})
+		h.AddMessage(Message{Role: "assistant", Content: fmt.Sprintf("File %d contains an empty main function.", i)})
+	}
+	h.Messages = h.Messages[:n]
+	return h
+}
+
+func TestMessageCacheIncrementalMatchesFull(t *testing.T) {
+	h := newBenchHistory(20)
+	cache := newMessageCache()
+	cache.build(h)
+
+	h.AddToolMessage("shell", map[string]interface{}{"command": "ls"}, "call_x")
+	h.AddMessage(Message{Role: "assistant", Content: "Skipped while the result is pending"})
+	h.AddToolResultMessage("call_x", "shell", map[string]interface{}{"output": "main.go"})
+
+	incremental := cache.build(h)
+	full := newMessageCache().build(h)
+
+	if len(incremental) != len(full) {
+		t.Fatalf("Expected %d messages, got %d", len(full), len(incremental))
+	}
+	for i := range full {
+		if incremental[i].Role != full[i].Role || incremental[i].Content != full[i].Content || incremental[i].ToolCallID != full[i].ToolCallID {
+			t.Errorf("Message %d differs: expected %+v, got %+v", i, full[i], incremental[i])
+		}
+	}
+
+	last := incremental[len(incremental)-1]
+	if last.Role != openai.ChatMessageRoleTool || last.ToolCallID != "call_x" {
+		t.Errorf("Expected the tool result last, got %+v", last)
+	}
+	for _, msg := range incremental {
+		if msg.Content == "Skipped while the result is pending" {
+			t.Errorf("Expected assistant text between a tool call and its result to be skipped")
+		}
+	}
+}
+
+func TestMessageCacheInvalidatedByRewrite(t *testing.T) {
+	h := newBenchHistory(8)
+	cache := newMessageCache()
+	cache.build(h)
+
+	if !h.ReplaceToolResultContent("call_1", `{"output":"replaced"}`) {
+		t.Fatalf("Expected the tool result to be replaced")
+	}
+
+	found := false
+	for _, msg := range cache.build(h) {
+		if msg.ToolCallID == "call_1" {
+			found = true
+			if msg.Content != `{"output":"replaced"}` {
+				t.Errorf("Expected replaced content, got %q", msg.Content)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected the tool result for call_1 in the converted messages")
+	}
+
+	h.Clear()
+	if msgs := cache.build(h); len(msgs) != 0 {
+		t.Errorf("Expected no messages after Clear, got %d", len(msgs))
+	}
+}
+
+// BenchmarkConvertHistory compares reconverting a 500-message history for every
+// request with converting only the tool result appended since the last request
+func BenchmarkConvertHistory(b *testing.B) {
+	const size = 500
+
+	b.Run("full", func(b *testing.B) {
+		h := newBenchHistory(size)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			newMessageCache().build(h)
+		}
+	})
+
+	b.Run("incremental", func(b *testing.B) {
+		h := newBenchHistory(size)
+		cache := newMessageCache()
+		cache.build(h)
+		result := Message{Role: "tool", Content: `{"output":"ok"}`, ToolCallID: "call_bench"}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			h.Messages = append(h.Messages, result)
+			cache.build(h)
+		}
+	})
+}
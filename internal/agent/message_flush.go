@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/google/uuid"
+)
+
+// messageFlusher coalesces the "message" updates of a response. Each update
+// carries the whole content so far, so updates that are held back lose
+// nothing: the next one sent includes them. Every update carries the ID of
+// the message, which the reply is also recorded with in the history.
+type messageFlusher struct {
+	id       string        // ID of the assistant message the updates belong to
+	model    string        // Model writing the message
+	interval time.Duration // Send at most one update per interval (0 = no limit)
+	chars    int           // Or once this many characters are new (0 = no limit)
+	sentAt   time.Time
+	sentLen  int
+	pending  *ResponseItem // Newest update not sent yet
+	last     *ResponseItem // Last update sent
+}
+
+// textUpdate is a "message" update carrying the text of a response so far
+func textUpdate(role, content string, started time.Time) ResponseItem {
+	return ResponseItem{
+		Type:             EventMessage,
+		Message:          &Message{Role: role, Content: content},
+		ThinkingDuration: time.Since(started).Milliseconds(),
+	}
+}
+
+// newMessageFlusher creates a flusher with the limits of cfg for a message written by model
+func newMessageFlusher(cfg *config.Config, model string) *messageFlusher {
+	return &messageFlusher{
+		id:       newMessageID(),
+		model:    model,
+		interval: time.Duration(cfg.MessageFlushIntervalMs) * time.Millisecond,
+		chars:    cfg.MessageFlushChars,
+	}
+}
+
+// send passes a message update to handler once a limit is reached, and holds
+// it otherwise. Without limits each update is sent when the next one arrives,
+// so the last one can still be marked with the response's finish reason.
+func (f *messageFlusher) send(handler ResponseHandler, item ResponseItem) {
+	if item.Type == EventMessage {
+		item.Message.ID, item.Message.Model = f.id, f.model
+	}
+	if f.interval <= 0 && f.chars <= 0 {
+		f.flush(handler)
+		f.pending = &item
+		return
+	}
+	f.pending = &item
+	newChars := len(item.Message.Content) - f.sentLen
+	if (f.interval > 0 && time.Since(f.sentAt) >= f.interval) || (f.chars > 0 && newChars >= f.chars) {
+		f.flush(handler)
+	}
+}
+
+// flush sends the update being held back, if any
+func (f *messageFlusher) flush(handler ResponseHandler) {
+	if f.pending == nil {
+		return
+	}
+	item := f.pending
+	f.pending = nil
+	f.last = item
+	f.sentAt = time.Now()
+	f.sentLen = len(item.Message.Content)
+	if data, err := json.Marshal(item); err == nil {
+		handler(string(data))
+	}
+}
+
+// finish sends the final update of a text response, marked with why the
+// response ended. The last update is sent again if a limit had just sent it.
+func (f *messageFlusher) finish(handler ResponseHandler, reason FinishReason) {
+	if f.pending == nil {
+		if f.last == nil {
+			return
+		}
+		item := *f.last
+		f.pending = &item
+	}
+	f.pending.FinishReason = reason
+	f.flush(handler)
+}
+
+// discard drops the update being held back and starts over, for a response
+// that is requested again. The message keeps its ID.
+func (f *messageFlusher) discard() {
+	f.pending = nil
+	f.last = nil
+	f.sentLen = 0
+}
+
+// newMessageID returns a new ID for an assistant message
+func newMessageID() string {
+	return "msg_" + uuid.New().String()
+}
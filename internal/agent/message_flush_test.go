@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+func messageUpdate(content string) ResponseItem {
+	return ResponseItem{Type: "message", Message: &Message{Role: "assistant", Content: content}}
+}
+
+func TestMessageFlusherCoalescesByCharacters(t *testing.T) {
+	var items []ResponseItem
+	handler := collectItems(&items)
+	f := newMessageFlusher(&config.Config{MessageFlushChars: 5}, "gpt-4o")
+
+	content := ""
+	for _, delta := range []string{"ab", "cd", "ef", "gh", "i"} {
+		content += delta
+		f.send(handler, messageUpdate(content))
+	}
+	if len(items) != 1 || items[0].Message.Content != "abcdef" {
+		t.Fatalf("Expected one update once 5 characters were new, got %+v", items)
+	}
+
+	// The end of the stream sends whatever was held back
+	f.flush(handler)
+	f.flush(handler)
+	if len(items) != 2 || items[1].Message.Content != "abcdefghi" {
+		t.Errorf("Expected one final update with the full content, got %+v", items)
+	}
+}
+
+func TestMessageFlusherCoalescesByInterval(t *testing.T) {
+	var items []ResponseItem
+	handler := collectItems(&items)
+	f := newMessageFlusher(&config.Config{MessageFlushIntervalMs: 20}, "gpt-4o")
+
+	f.send(handler, messageUpdate("a"))
+	f.send(handler, messageUpdate("ab"))
+	if len(items) != 1 {
+		t.Fatalf("Expected the second update to be held back, got %d updates", len(items))
+	}
+	time.Sleep(25 * time.Millisecond)
+	f.send(handler, messageUpdate("abc"))
+	if len(items) != 2 || items[1].Message.Content != "abc" {
+		t.Errorf("Expected an update once the interval passed, got %+v", items)
+	}
+}
+
+func TestCoalescedReplyIsFlushedAtStreamEnd(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, "A short reply")
+	a.config.MessageFlushIntervalMs = 60000
+	a.config.MessageFlushChars = 1000
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Hi"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if len(items) == 0 || items[len(items)-1].Message == nil || items[len(items)-1].Message.Content != "A short reply" {
+		t.Errorf("Expected the reply to be delivered, got %+v", items)
+	}
+}
+
+func TestMessageUpdatesCarryStableID(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, "A reply streamed in several chunks", "Another reply")
+	ids := func(content string) map[string]bool {
+		t.Helper()
+		var items []ResponseItem
+		if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: content}}, collectItems(&items)); err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+		seen := make(map[string]bool)
+		for _, item := range items {
+			if item.Type == EventMessage {
+				seen[item.Message.ID] = true
+			}
+		}
+		return seen
+	}
+
+	first := ids("Hi")
+	if len(first) != 1 || first[""] {
+		t.Fatalf("Expected every update of the reply to carry one ID, got %v", first)
+	}
+	messages := a.history.GetMessages()
+	if last := messages[len(messages)-1]; !first[last.ID] {
+		t.Errorf("Expected the reply saved with the ID of its updates, got %q", last.ID)
+	}
+	for id := range ids("Again") {
+		if first[id] {
+			t.Errorf("Expected a new ID for the next reply, got %q again", id)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// MockAgent is an Agent that plays a scripted stream instead of calling a
+// model, for testing code that drives an agent such as a tool loop. Each
+// SendMessage, and each SendFunctionResult that answers the last pending
+// call, plays the next scripted turn. Without a script the turn is an empty reply.
+type MockAgent struct {
+	mu      sync.Mutex
+	script  []mockTurn
+	history *ConversationHistory
+	handler ResponseHandler
+	pending map[string]string // CallID -> tool name of calls awaiting results
+	aborted []ResponseItem    // "tool_aborted" items for the next SendMessage
+	callIDs int
+}
+
+// mockTurn is one scripted assistant turn: a reply or a tool call
+type mockTurn struct {
+	content string
+	call    *FunctionCall
+}
+
+var _ Agent = (*MockAgent)(nil)
+
+// NewMockAgent creates a mock agent with an empty script
+func NewMockAgent() *MockAgent {
+	opts := DefaultHistoryOptions()
+	opts.SessionID = "mock"
+	history, _ := NewConversationHistory(opts) // Cannot fail without persistence
+	return &MockAgent{
+		history: history,
+		pending: make(map[string]string),
+	}
+}
+
+// QueueReply scripts a turn in which the assistant replies with content
+func (m *MockAgent) QueueReply(content string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.script = append(m.script, mockTurn{content: content})
+}
+
+// InjectAssistantToolCall scripts a turn in which the assistant calls tool name
+// with args, a JSON object. It returns the ID the call will carry, for use
+// with InjectToolResult.
+func (m *MockAgent) InjectAssistantToolCall(name, args string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.callIDs++
+	id := fmt.Sprintf("call_mock_%d", m.callIDs)
+	m.script = append(m.script, mockTurn{call: &FunctionCall{Name: name, Arguments: args, ID: id}})
+	return id
+}
+
+// InjectToolResult answers the pending call callID with a successful output,
+// as if the tool had run, and plays the follow-up turn to the handler of the
+// last SendMessage
+func (m *MockAgent) InjectToolResult(callID, output string) error {
+	m.mu.Lock()
+	name := m.pending[callID]
+	m.mu.Unlock()
+	return m.SendToolResult(context.Background(), NewToolResult(callID, name, output, true))
+}
+
+// SendMessage records messages and plays the next scripted turn to handler.
+// Calls still awaiting results are answered as aborted, like OpenAIAgent does.
+func (m *MockAgent) SendMessage(ctx context.Context, messages []Message, handler ResponseHandler) (bool, error) {
+	m.mu.Lock()
+	m.handler = handler
+	m.abortPending()
+	if err := m.history.AppendMessages(messages); err != nil && messageRejected(err) {
+		m.mu.Unlock()
+		return false, fmt.Errorf("failed to add messages to history: %w", err)
+	}
+	items, endedWithTools := m.playTurn()
+	items = append(m.aborted, items...)
+	m.aborted = nil
+	m.mu.Unlock()
+
+	sendItems(handler, items)
+	return endedWithTools, nil
+}
+
+// AddUserMessage records a user message without playing a turn. Calls still
+// awaiting results are answered as aborted first, like OpenAIAgent does.
+func (m *MockAgent) AddUserMessage(content string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.abortPending()
+	if err := m.history.AppendMessage(Message{Role: openai.ChatMessageRoleUser, Content: content}); err != nil && messageRejected(err) {
+		return fmt.Errorf("failed to add message to history: %w", err)
+	}
+	return nil
+}
+
+// abortPending answers the calls awaiting results as aborted, to be reported
+// by the next SendMessage. Caller must hold m.mu.
+func (m *MockAgent) abortPending() {
+	for _, callID := range slices.Sorted(maps.Keys(m.pending)) {
+		name := m.pending[callID]
+		m.history.AddMessage(toolErrorResult(callID, name, abortedToolReason))
+		m.aborted = append(m.aborted, ResponseItem{Type: EventToolAborted, FunctionCall: &FunctionCall{ID: callID, Name: name}, Error: abortedToolReason})
+	}
+	m.pending = make(map[string]string)
+}
+
+// SendFunctionResult records the result of a pending call.
+//
+// Deprecated: use SendToolResult.
+func (m *MockAgent) SendFunctionResult(ctx context.Context, callID, functionName, output string, success bool) error {
+	return m.SendToolResult(ctx, NewToolResult(callID, functionName, output, success))
+}
+
+// SendToolResult records the result of a pending call. Once every pending
+// call has a result, the next scripted turn is played, followed by a
+// "followup_complete" item unless the turn calls another tool.
+func (m *MockAgent) SendToolResult(ctx context.Context, result ToolResult) error {
+	m.mu.Lock()
+	if _, ok := m.pending[result.CallID]; !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("failed to send result for call %s: %w", result.CallID, ErrUnknownToolCall)
+	}
+	delete(m.pending, result.CallID)
+
+	if err := m.history.AppendMessage(result.Message()); err != nil && messageRejected(err) {
+		m.mu.Unlock()
+		return fmt.Errorf("failed to add tool result to history: %w", err)
+	}
+	if len(m.pending) > 0 || m.handler == nil {
+		m.mu.Unlock()
+		return nil
+	}
+
+	handler := m.handler
+	items, endedWithTools := m.playTurn()
+	if !endedWithTools {
+		items = append(items, ResponseItem{Type: EventFollowupComplete})
+	}
+	m.mu.Unlock()
+
+	sendItems(handler, items)
+	return nil
+}
+
+// playTurn records the next scripted turn in the history and returns the
+// items to send for it. The caller must hold m.mu.
+func (m *MockAgent) playTurn() ([]ResponseItem, bool) {
+	var turn mockTurn
+	if len(m.script) > 0 {
+		turn = m.script[0]
+		m.script = m.script[1:]
+	}
+
+	if turn.call != nil {
+		m.history.AddMessage(Message{
+			Role: openai.ChatMessageRoleAssistant,
+			ToolCalls: []ToolCall{{
+				ID:       turn.call.ID,
+				Type:     string(openai.ToolTypeFunction),
+				Function: FunctionCall{Name: turn.call.Name, Arguments: turn.call.Arguments},
+			}},
+		})
+		m.pending[turn.call.ID] = turn.call.Name
+		call := *turn.call
+		return []ResponseItem{{Type: EventFunctionCall, FunctionCall: &call}}, true
+	}
+
+	if turn.content == "" {
+		return nil, false
+	}
+	reply := Message{ID: newMessageID(), Role: openai.ChatMessageRoleAssistant, Content: turn.content}
+	m.history.AddMessage(reply)
+	return []ResponseItem{{Type: EventMessage, Message: &reply}}, false
+}
+
+// sendItems sends items to handler as JSON, the way OpenAIAgent does
+func sendItems(handler ResponseHandler, items []ResponseItem) {
+	if handler == nil {
+		return
+	}
+	for _, item := range items {
+		if data, err := json.Marshal(item); err == nil {
+			handler(string(data))
+		}
+	}
+}
+
+// SendFileChange approves every file change
+func (m *MockAgent) SendFileChange(ctx context.Context, filePath string, diff string) (*FileChangeConfirmation, error) {
+	return &FileChangeConfirmation{Approved: true}, nil
+}
+
+// GetCommandConfirmation approves every command
+func (m *MockAgent) GetCommandConfirmation(ctx context.Context, command string, args []string) (*CommandConfirmation, error) {
+	return &CommandConfirmation{Approved: true}, nil
+}
+
+// ClearHistory clears the conversation history and drops pending calls
+func (m *MockAgent) ClearHistory() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.history.Clear()
+	m.pending = make(map[string]string)
+}
+
+// GetHistory returns the conversation history
+func (m *MockAgent) GetHistory() *ConversationHistory {
+	return m.history
+}
+
+// Cancel does nothing; pending calls are answered as aborted by the next SendMessage
+func (m *MockAgent) Cancel() {}
+
+// Close does nothing
+func (m *MockAgent) Close() error {
+	return nil
+}
@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMockAgentScriptsToolRounds(t *testing.T) {
+	m := NewMockAgent()
+	readID := m.InjectAssistantToolCall("read_file", `{"path":"go.mod"}`)
+	shellID := m.InjectAssistantToolCall("shell", `{"command":"go test ./..."}`)
+	m.QueueReply("All tests pass.")
+
+	var items []ResponseItem
+	endedWithTools, err := m.SendMessage(context.Background(), []Message{{Role: "user", Content: "Run the tests"}}, collectItems(&items))
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if !endedWithTools || len(items) != 1 || items[0].FunctionCall == nil || items[0].FunctionCall.ID != readID {
+		t.Fatalf("Expected the read_file call %s, got %+v", readID, items)
+	}
+
+	items = nil
+	if err := m.InjectToolResult(readID, "module example"); err != nil {
+		t.Fatalf("InjectToolResult failed: %v", err)
+	}
+	if len(items) != 1 || items[0].FunctionCall == nil || items[0].FunctionCall.ID != shellID {
+		t.Fatalf("Expected the follow-up to call shell as %s, got %+v", shellID, items)
+	}
+
+	items = nil
+	if err := m.InjectToolResult(shellID, "ok"); err != nil {
+		t.Fatalf("InjectToolResult failed: %v", err)
+	}
+	if len(items) != 2 || items[0].Message == nil || items[0].Message.Content != "All tests pass." || items[1].Type != "followup_complete" {
+		t.Fatalf("Expected the final reply and followup_complete, got %+v", items)
+	}
+
+	if err := m.InjectToolResult(shellID, "again"); !errors.Is(err, ErrUnknownToolCall) {
+		t.Errorf("Expected ErrUnknownToolCall for an answered call, got %v", err)
+	}
+	checkToolPairing(t, m.GetHistory().GetMessages())
+}
+
+func TestMockAgentAbortsUnansweredCalls(t *testing.T) {
+	m := NewMockAgent()
+	callID := m.InjectAssistantToolCall("shell", `{"command":"sleep 60"}`)
+	m.QueueReply("Okay.")
+
+	m.SendMessage(context.Background(), []Message{{Role: "user", Content: "Wait"}}, func(string) {})
+	var items []ResponseItem
+	if _, err := m.SendMessage(context.Background(), []Message{{Role: "user", Content: "Never mind"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if len(items) == 0 || items[0].Type != "tool_aborted" || items[0].FunctionCall.ID != callID {
+		t.Errorf("Expected a tool_aborted item for %s first, got %+v", callID, items)
+	}
+
+	messages := m.GetHistory().GetMessages()
+	checkToolPairing(t, messages)
+	if tc, found := m.GetHistory().FindToolCall(callID); !found || tc.Function.Name != "shell" {
+		t.Errorf("Expected the aborted call to stay in the history, got %+v", tc)
+	}
+	if last := messages[len(messages)-1]; last.Content != "Okay." {
+		t.Errorf("Expected the scripted reply last, got %+v", last)
+	}
+}
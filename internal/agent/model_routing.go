@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// RequestStrongerModelToolName is the tool the model calls, when turns are
+// routed between models, to hand the rest of the turn to the edit model.
+// The agent answers it itself; hosts never see the call.
+const RequestStrongerModelToolName = "request_stronger_model"
+
+// requestStrongerModelTool is offered with every request sent to the explore model
+var requestStrongerModelTool = convertToolDefinitions([]ToolDefinition{{
+	Type: "function",
+	Function: FunctionDef{
+		Name:        RequestStrongerModelToolName,
+		Description: "Switch to a stronger model for the rest of this turn. Call it before changing code, or when the task turns out harder than it looked; the conversation so far is kept.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": OrderedMap{
+				{"reason", map[string]interface{}{
+					"type":        "string",
+					"description": "Why the stronger model is needed",
+				}},
+			},
+		},
+	},
+}})[0]
+
+// configuredModels returns the models requests may be sent to: the explore
+// and edit models when turns are routed, else the model
+func configuredModels(cfg *config.Config) []string {
+	if cfg.RoutesModels() {
+		return []string{cfg.ExploreModel, cfg.EditModel}
+	}
+	return []string{cfg.Model}
+}
+
+// model returns the model of the next request: the model of the current
+// turn when routing, else the configured model
+func (a *OpenAIAgent) model() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.modelLocked()
+}
+
+// modelLocked is model for callers holding a.mu
+func (a *OpenAIAgent) modelLocked() string {
+	switch {
+	case !a.config.RoutesModels():
+		return a.config.Model
+	case a.turnModel != "":
+		return a.turnModel
+	default:
+		return a.config.ExploreModel
+	}
+}
+
+// offersEscalation reports whether requests offer request_stronger_model:
+// when routing, until the turn is on the edit model. The caller must hold a.mu.
+func (a *OpenAIAgent) offersEscalation() bool {
+	return a.config.RoutesModels() && a.modelLocked() != a.config.EditModel
+}
+
+// withEscalationTool adds request_stronger_model to tools when it is offered.
+// The caller must hold a.mu.
+func (a *OpenAIAgent) withEscalationTool(tools []openai.Tool) []openai.Tool {
+	if !a.offersEscalation() {
+		return tools
+	}
+	return append(slices.Clip(tools), requestStrongerModelTool)
+}
+
+// routeTurn picks the model of a turn that starts with new input: the edit
+// model when the user's latest message matches an edit intent, else the
+// explore model. The returned notice, if any, reports a switch and is to be
+// sent once a.mu is released. The caller must hold a.mu.
+func (a *OpenAIAgent) routeTurn(messages []Message) string {
+	if !a.config.RoutesModels() {
+		return ""
+	}
+	previous := a.modelLocked()
+	a.turnModel = a.config.ExploreModel
+	if a.config.MatchesEditIntent(a.latestUserMessage(messages)) {
+		a.turnModel = a.config.EditModel
+	}
+	if a.turn != nil {
+		a.turn.model = a.turnModel
+	}
+	if a.turnModel == previous {
+		return ""
+	}
+	a.logger.Log("[INFO] Agent.routeTurn: Switching from %s to %s for this turn.", previous, a.turnModel)
+	return fmt.Sprintf("Using %s for this turn.", a.turnModel)
+}
+
+// latestUserMessage returns the last user message among messages, else in the history
+func (a *OpenAIAgent) latestUserMessage(messages []Message) string {
+	sources := [][]Message{messages}
+	if a.history != nil {
+		sources = append(sources, a.history.GetMessages())
+	}
+	for _, source := range sources {
+		for i := len(source) - 1; i >= 0; i-- {
+			if source[i].Role == openai.ChatMessageRoleUser {
+				return source[i].Content
+			}
+		}
+	}
+	return ""
+}
+
+// escalate answers a request_stronger_model call: the rest of the turn is
+// sent to the edit model, with the same history
+func (a *OpenAIAgent) escalate(callID string, handler ResponseHandler) ToolResult {
+	a.mu.Lock()
+	previous := a.modelLocked()
+	a.turnModel = a.config.EditModel
+	if a.turn != nil {
+		a.turn.model = a.turnModel
+		a.turn.escalated = true
+	}
+	a.mu.Unlock()
+
+	a.logger.Log("[INFO] Agent.escalate: The model asked for a stronger model; switching from %s to %s.", previous, a.config.EditModel)
+	sendWarning(handler, fmt.Sprintf("The model asked for a stronger model; using %s for the rest of this turn.", a.config.EditModel))
+	return NewToolResult(callID, RequestStrongerModelToolName, fmt.Sprintf("Switched to %s for the rest of this turn.", a.config.EditModel), true)
+}
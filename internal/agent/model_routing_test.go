@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/usagelog"
+	"github.com/sashabaranov/go-openai"
+)
+
+// offersTool reports whether tools of a request include name
+func offersTool(fake *fakeOpenAI, name string) bool {
+	for _, tool := range fake.lastRequest().Tools {
+		if tool.Function != nil && tool.Function.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTurnsAreRoutedByEditIntent(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "It parses flags.", "Fixed.", "It is fixed now.")
+	a.config.ExploreModel, a.config.EditModel = "gpt-4o-mini", "o3"
+
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "What does main.go do?"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if req := fake.lastRequest(); req.Model != "gpt-4o-mini" || req.Temperature != 0.7 || !offersTool(fake, RequestStrongerModelToolName) {
+		t.Errorf("Expected a question for the explore model, offering %s, got %s at temperature %v", RequestStrongerModelToolName, req.Model, req.Temperature)
+	}
+
+	items = nil
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Fix the flag parsing in main.go"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	// The capability rules follow the model: o3 is a reasoning model
+	if req := fake.lastRequest(); req.Model != "o3" || req.Temperature != 0 || offersTool(fake, RequestStrongerModelToolName) {
+		t.Errorf("Expected an edit for the edit model without the escalation tool, got %s at temperature %v", req.Model, req.Temperature)
+	}
+	if len(items) == 0 || items[0].Type != EventWarning || items[0].Message.Content != "Using o3 for this turn." {
+		t.Errorf("Expected the switch to be reported first, got %+v", items)
+	}
+
+	// "prefix" contains "fix" but not as a word
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Which prefix do the flags use?"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if req := fake.lastRequest(); req.Model != "gpt-4o-mini" {
+		t.Errorf("Expected the next question to go back to the explore model, got %s", req.Model)
+	}
+
+	var models []string
+	for _, msg := range a.history.GetMessages() {
+		if msg.Role == "assistant" {
+			models = append(models, msg.Model)
+		}
+	}
+	if len(models) != 3 || models[0] != "gpt-4o-mini" || models[1] != "o3" || models[2] != "gpt-4o-mini" {
+		t.Errorf("Expected each reply to record its model in the history, got %v", models)
+	}
+}
+
+func TestModelCanRequestStrongerModel(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, toolCallReply(RequestStrongerModelToolName, `{"reason":"the bug spans three packages"}`), "Here is the plan.")
+	a.config.ExploreModel, a.config.EditModel = "gpt-4o-mini", "gpt-4o"
+	a.config.UsageLog = filepath.Join(t.TempDir(), "usage.jsonl")
+	fake.usage = &openai.Usage{PromptTokens: 1000000}
+
+	var items []ResponseItem
+	endedWithTools, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Why do the tests hang?"}}, collectItems(&items))
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if endedWithTools {
+		t.Errorf("Expected the agent to answer the call itself")
+	}
+
+	fake.mu.Lock()
+	requests := fake.requests
+	fake.mu.Unlock()
+	if len(requests) != 2 || requests[0].Model != "gpt-4o-mini" || requests[1].Model != "gpt-4o" {
+		t.Fatalf("Expected the turn to continue on the edit model, got %d requests", len(requests))
+	}
+	// The same history is carried over, ending in the answered call
+	if last := requests[1].Messages[len(requests[1].Messages)-1]; last.Role != "tool" || len(requests[1].Messages) != len(requests[0].Messages)+2 {
+		t.Errorf("Expected the history and the switch result to be sent again, got %+v", requests[1].Messages)
+	}
+	for _, item := range items {
+		if item.Type == EventFunctionCall {
+			t.Errorf("Expected no function_call item for %s, got %+v", RequestStrongerModelToolName, item.FunctionCall)
+		}
+	}
+	if reply := items[len(items)-1]; reply.Type != EventMessage || reply.Message.Model != "gpt-4o" {
+		t.Errorf("Expected the reply to name the edit model, got %+v", reply)
+	}
+
+	records, err := usagelog.Read(a.config.UsageLog, time.Time{})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(records) != 1 || records[0].Model != "gpt-4o" || !records[0].Escalated || records[0].Requests != 2 {
+		t.Fatalf("Expected the turn to be recorded on the edit model, escalated, got %+v", records)
+	}
+	// Each request is charged to the model it went to
+	byModel := records[0].ByModel
+	if byModel["gpt-4o-mini"] != (usagelog.ModelUsage{Requests: 1, PromptTokens: 1000000, CostUSD: 0.15}) ||
+		byModel["gpt-4o"] != (usagelog.ModelUsage{Requests: 1, PromptTokens: 1000000, CostUSD: 2.50}) {
+		t.Errorf("Expected the usage split between the models, got %+v", byModel)
+	}
+}
@@ -6,12 +6,20 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/epuerta/codex-go/internal/blobstore"
 	"github.com/epuerta/codex-go/internal/config"
 	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/epuerta/codex-go/internal/lsp"
+	"github.com/epuerta/codex-go/internal/memory"
+	"github.com/epuerta/codex-go/internal/projectfacts"
+	"github.com/epuerta/codex-go/internal/usagelog"
 	"github.com/google/uuid"
 	"github.com/sashabaranov/go-openai"
 )
@@ -31,19 +39,63 @@ type FunctionDef struct {
 
 // OpenAIAgent implements the Agent interface using OpenAI
 type OpenAIAgent struct {
-	client           *openai.Client
-	config           *config.Config
-	tools            []ToolDefinition
-	currentContext   context.Context
-	cancelFunc       context.CancelFunc
-	sessionID        string
-	history          *ConversationHistory
-	historyOpts      HistoryOptions
-	mu               sync.Mutex
-	currentHandler   ResponseHandler
-	pendingToolCalls map[string]bool // Map of CallID -> true (pending)
-	pendingMu        sync.Mutex      // Mutex for pendingToolCalls map
-	logger           logging.Logger
+	client                *openai.Client // Replaced by SetAPIKey; read through apiClient
+	clientMu              sync.Mutex     // Guards client and streams
+	streams               StreamSource   // Where responses are read from; nil for the API
+	config                *config.Config
+	tools                 []ToolDefinition
+	currentContext        context.Context
+	cancelFunc            context.CancelFunc
+	sessionID             string
+	blobs                 *blobstore.Store // Project blob store for large payloads; nil when disabled
+	history               *ConversationHistory
+	historyMu             sync.Mutex // Held while the history changes, so HistorySnapshot never sees it half done
+	historyOpts           HistoryOptions
+	mu                    sync.Mutex
+	currentHandler        ResponseHandler
+	flaggedOutputApprover FlaggedOutputApprover  // Approves flagged tool outputs under the strict guard
+	pendingToolCalls      map[string]bool        // Map of CallID -> true (pending)
+	pendingMu             sync.Mutex             // Mutex for pendingToolCalls map
+	repairedArgs          map[string][]string    // CallID -> repairs made to its arguments, guarded by pendingMu
+	abortedCalls          []FunctionCall         // Calls answered as aborted and not yet reported, guarded by pendingMu
+	strictSent            map[string]bool        // Tools the last request offered strict, guarded by pendingMu
+	savedOutputs          map[string]savedOutput // CallID -> where its full output was saved when condensed, guarded by pendingMu
+	addedInput            bool                   // AddUserMessage added input since the last request. Guarded by mu.
+	logger                logging.Logger
+	toolErrors            *toolErrorGuard            // Detects the same tool call failing repeatedly
+	usage                 *usageMeter                // Cumulative usage, checked against the budget before every request
+	limiter               *rateLimiter               // Paces requests to the configured rate limit (nil without one)
+	gate                  *streamGate                // Buffers handler dispatch while paused
+	gateTarget            ResponseHandler            // Unwrapped handler that Resume flushes to
+	messages              *messageCache              // API form of the history, reused across requests
+	apiTools              []openai.Tool              // Tool definitions converted once for every request
+	allowedTools          map[string]bool            // Tools the session is limited to, nil for all; guarded by mu
+	allowedAPITools       []openai.Tool              // apiTools of the allowed tools; guarded by mu
+	strictSchemas         map[string]json.RawMessage // Strict parameter schemas by tool, nil for tools without one; guarded by mu
+	forcedTool            string                     // Tool the next request makes the model call, set by ForceTool; guarded by mu
+	turnModel             string                     // Model of the current turn when routing between models; guarded by mu
+	unknownToolRounds     int                        // Consecutive automatic retries after calls to unknown tools
+	emptyRetried          bool                       // The empty response of the current request was requested again; guarded by mu
+	candidates            []string                   // Every choice of the last response to new input, with choices > 1. Guarded by mu.
+	closeOnce             sync.Once                  // Close runs once, whether from normal exit or a signal
+	closeErr              error
+	closed                bool             // Set by Close; new requests and results are refused. Guarded by mu.
+	journal               *sessionJournal  // Write-ahead journal of the history (nil when autosave is disabled)
+	hooks                 hookChain        // Response hooks and tool call interceptors registered by embedders
+	turnHooks             turnHooks        // Hooks as of the start of the current request
+	observers             observerSet      // Additional handlers receiving every response item
+	state                 InteractionState // Where the agent is in the request/response cycle, guarded by mu
+	stateChanged          *sync.Cond       // Signalled on mu whenever state changes
+	cancelRequested       bool             // Cancel was called on the running stream
+	autosaveStop          chan struct{}
+	autosaveDone          chan struct{}
+	pendingSystemPrompt   *string              // Set by SetSystemPrompt mid-turn, applied with the next request
+	promptSources         []SystemPromptSource // Merged with the base prompt of every request; guarded by mu
+	turn                  *turnUsage           // Usage of the turn in progress, for the usage log; guarded by mu
+	requests              requestIndex         // Per-turn index of the requests sent, for codex inspect
+	newTurn               bool                 // A turn began and has not sent a request yet; guarded by mu
+	instructionsStop      chan struct{}
+	instructionsDone      chan struct{}
 }
 
 // NewOpenAIAgent creates a new OpenAI agent
@@ -51,13 +103,13 @@ func NewOpenAIAgent(cfg *config.Config, logger logging.Logger) (*OpenAIAgent, er
 	if cfg.APIKey == "" {
 		return nil, errors.New("OpenAI API key is required")
 	}
-
-	clientConfig := openai.DefaultConfig(cfg.APIKey)
-	if cfg.BaseURL != "" {
-		clientConfig.BaseURL = cfg.BaseURL
+	for _, model := range configuredModels(cfg) {
+		if _, ok := cfg.PriceFor(model); cfg.BudgetUSD > 0 && !ok {
+			return nil, fmt.Errorf("no price known for model %s: set model_prices to use budget_usd", model)
+		}
 	}
 
-	client := openai.NewClientWithConfig(clientConfig)
+	client := newOpenAIClient(cfg, cfg.APIKey)
 
 	// Generate a session ID
 	sessionID := uuid.New().String()
@@ -66,9 +118,19 @@ func NewOpenAIAgent(cfg *config.Config, logger logging.Logger) (*OpenAIAgent, er
 	historyOpts := DefaultHistoryOptions()
 	historyOpts.SessionID = sessionID
 
-	// Load instructions from config if available
-	if cfg.Instructions != "" {
-		historyOpts.SystemPrompt = cfg.Instructions
+	historyOpts.SystemPrompt = buildSystemPrompt(cfg, cfg.Instructions, historyOpts.SystemPrompt)
+	historyOpts.Retention = RetentionPolicy{KeepTask: !cfg.HistoryDropTask, RecentTurns: cfg.HistoryRecentTurns, RecentToolRounds: cfg.HistoryRecentToolRounds}
+	if historyOpts.Retention.RecentTurns == 0 {
+		historyOpts.Retention.RecentTurns = config.DefaultHistoryRecentTurns
+	}
+	if historyOpts.Retention.RecentToolRounds == 0 {
+		historyOpts.Retention.RecentToolRounds = config.DefaultHistoryRecentToolRounds
+	}
+	switch cfg.ContextStrategy {
+	case config.ContextAge:
+		historyOpts.Packer = AgePacker{}
+	case config.ContextRelevance:
+		historyOpts.Packer = RelevancePacker{}
 	}
 
 	// Initialize conversation history
@@ -83,14 +145,18 @@ func NewOpenAIAgent(cfg *config.Config, logger logging.Logger) (*OpenAIAgent, er
 			Type: "function",
 			Function: FunctionDef{
 				Name:        "shell",
-				Description: "Execute a shell command",
+				Description: "Execute a shell command. " + shellResultDescription,
 				Parameters: map[string]interface{}{
 					"type": "object",
-					"properties": map[string]interface{}{
-						"command": map[string]interface{}{
+					"properties": OrderedMap{
+						{"command", map[string]interface{}{
 							"type":        "string",
 							"description": "The shell command to execute",
-						},
+						}},
+						{"stdin_data", map[string]interface{}{
+							"type":        "string",
+							"description": "Input for commands that prompt for it, e.g. the answers to an init wizard, one per line. Commands left waiting for input are stopped.",
+						}},
 					},
 					"required": []string{"command"},
 				},
@@ -100,14 +166,39 @@ func NewOpenAIAgent(cfg *config.Config, logger logging.Logger) (*OpenAIAgent, er
 			Type: "function",
 			Function: FunctionDef{
 				Name:        "read_file",
-				Description: "Read the contents of a file",
+				Description: "Read the contents of a file, optionally limited to a range of lines",
 				Parameters: map[string]interface{}{
 					"type": "object",
-					"properties": map[string]interface{}{
-						"path": map[string]interface{}{
+					"properties": OrderedMap{
+						{"path", map[string]interface{}{
 							"type":        "string",
 							"description": "The path to the file",
-						},
+						}},
+						{"start_line", map[string]interface{}{
+							"type":        "integer",
+							"description": "Optional first line to return (1-based, inclusive)",
+						}},
+						{"end_line", map[string]interface{}{
+							"type":        "integer",
+							"description": "Optional last line to return (1-based, inclusive)",
+						}},
+					},
+					"required": []string{"path"},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: FunctionDef{
+				Name:        "file_info",
+				Description: "Describe a file without reading it: its line count, byte size, language and an outline of the functions, classes and types it declares with their line numbers. Use it before reading a large file, then read_file just the lines you need.",
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": OrderedMap{
+						{"path", map[string]interface{}{
+							"type":        "string",
+							"description": "The path to the file",
+						}},
 					},
 					"required": []string{"path"},
 				},
@@ -120,20 +211,108 @@ func NewOpenAIAgent(cfg *config.Config, logger logging.Logger) (*OpenAIAgent, er
 				Description: "Write content to a file, replacing existing content or creating a new file. Use patch_file for modifying existing files.",
 				Parameters: map[string]interface{}{
 					"type": "object",
-					"properties": map[string]interface{}{
-						"path": map[string]interface{}{
+					"properties": OrderedMap{
+						{"path", map[string]interface{}{
 							"type":        "string",
 							"description": "The path to the file",
-						},
-						"content": map[string]interface{}{
+						}},
+						{"content", map[string]interface{}{
 							"type":        "string",
 							"description": "The full content to write",
-						},
+						}},
 					},
 					"required": []string{"path", "content"},
 				},
 			},
 		},
+		{
+			Type: "function",
+			Function: FunctionDef{
+				Name:        "append_file",
+				Description: "Append content to the end of a file without rewriting it, creating the file if it does not exist. Cheaper than write_file for adding a log line or a new function at the end. The content is added as it is: start it with a newline if the file may not end with one.",
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": OrderedMap{
+						{"path", map[string]interface{}{
+							"type":        "string",
+							"description": "The path to the file",
+						}},
+						{"content", map[string]interface{}{
+							"type":        "string",
+							"description": "The content to add at the end of the file",
+						}},
+					},
+					"required": []string{"path", "content"},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: FunctionDef{
+				Name:        "begin_write",
+				Description: "Start a chunked write for content too large to send in a single write_file call. Follow with append_chunk calls and finish with commit_write; the file is only replaced once the commit is verified.",
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": OrderedMap{
+						{"path", map[string]interface{}{
+							"type":        "string",
+							"description": "The path to the file",
+						}},
+						{"total_chunks", map[string]interface{}{
+							"type":        "integer",
+							"description": "The number of chunks that will be sent",
+						}},
+						{"expected_sha256", map[string]interface{}{
+							"type":        "string",
+							"description": "Optional hex SHA-256 of the complete content, verified on commit",
+						}},
+					},
+					"required": []string{"path", "total_chunks"},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: FunctionDef{
+				Name:        "append_chunk",
+				Description: "Append the next chunk of content to a write started with begin_write. Chunks must be sent in order starting at index 0.",
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": OrderedMap{
+						{"path", map[string]interface{}{
+							"type":        "string",
+							"description": "The path passed to begin_write",
+						}},
+						{"index", map[string]interface{}{
+							"type":        "integer",
+							"description": "The zero-based index of this chunk",
+						}},
+						{"content", map[string]interface{}{
+							"type":        "string",
+							"description": "The chunk content",
+						}},
+					},
+					"required": []string{"path", "index", "content"},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: FunctionDef{
+				Name:        "commit_write",
+				Description: "Verify a chunked write (chunk count and checksum) and move it into place.",
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": OrderedMap{
+						{"path", map[string]interface{}{
+							"type":        "string",
+							"description": "The path passed to begin_write",
+						}},
+					},
+					"required": []string{"path"},
+				},
+			},
+		},
 		{
 			Type: "function",
 			Function: FunctionDef{
@@ -141,18 +320,120 @@ func NewOpenAIAgent(cfg *config.Config, logger logging.Logger) (*OpenAIAgent, er
 				Description: "Modify an existing file by applying a patch in a specific format. Preferred for edits over write_file.",
 				Parameters: map[string]interface{}{
 					"type": "object",
-					"properties": map[string]interface{}{
+					"properties": OrderedMap{
 						// Note: The actual implementation uses a custom format, not standard diff args.
 						// Describe the expected custom format in the parameter description.
-						"patch_content": map[string]interface{}{
+						{"patch_content", map[string]interface{}{
 							"type":        "string",
 							"description": "The patch content, including // FILE:, // EDIT:, // END_EDIT, ADD:, and DEL: markers.",
-						},
+						}},
 					},
 					"required": []string{"patch_content"},
 				},
 			},
 		},
+		{
+			Type: "function",
+			Function: FunctionDef{
+				Name:        "edit_symbol",
+				Description: "Edit a Go file by symbol instead of by line: replace a function body, add a method, add an import or rename an identifier. The file is reformatted with gofmt and the diff is returned. Parse errors in the file or your snippet are reported with line:column positions.",
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": OrderedMap{
+						{"path", map[string]interface{}{
+							"type":        "string",
+							"description": "The Go file to edit",
+						}},
+						{"operation", map[string]interface{}{
+							"type":        "string",
+							"enum":        []string{"replace_function_body", "add_method", "add_import", "rename_identifier"},
+							"description": "The edit to make",
+						}},
+						{"function", map[string]interface{}{
+							"type":        "string",
+							"description": "replace_function_body: the function, or Type.Method for a method",
+						}},
+						{"body", map[string]interface{}{
+							"type":        "string",
+							"description": "replace_function_body: the new statements, without the enclosing braces",
+						}},
+						{"receiver", map[string]interface{}{
+							"type":        "string",
+							"description": "add_method: the receiver type name",
+						}},
+						{"source", map[string]interface{}{
+							"type":        "string",
+							"description": "add_method: the complete method declaration, including any doc comment",
+						}},
+						{"import_path", map[string]interface{}{
+							"type":        "string",
+							"description": "add_import: the package path to import",
+						}},
+						{"import_name", map[string]interface{}{
+							"type":        "string",
+							"description": "add_import: optional name for the import",
+						}},
+						{"old_name", map[string]interface{}{
+							"type":        "string",
+							"description": "rename_identifier: the identifier to rename",
+						}},
+						{"new_name", map[string]interface{}{
+							"type":        "string",
+							"description": "rename_identifier: the new name",
+						}},
+						{"scope", map[string]interface{}{
+							"type":        "string",
+							"description": "rename_identifier: \"file\" (default) or a function or Type.Method to limit the rename to. Other files are not updated.",
+						}},
+					},
+					"required": []string{"path", "operation"},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: FunctionDef{
+				Name:        "apply_edits",
+				Description: "Apply edits to several files atomically: every edit is validated first and either all of them are applied or none is. Use it for changes that must land together, such as a rename across files. Edits to the same file apply in order. Returns the result for each file.",
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": OrderedMap{
+						{"edits", map[string]interface{}{
+							"type":        "array",
+							"description": "The edits to apply, in order",
+							"items": map[string]interface{}{
+								"type": "object",
+								"properties": OrderedMap{
+									{"path", map[string]interface{}{
+										"type":        "string",
+										"description": "The path to the file",
+									}},
+									{"operation", map[string]interface{}{
+										"type":        "string",
+										"enum":        []string{"write", "patch", "delete"},
+										"description": "write replaces or creates the file, patch replaces old_text with new_text, delete removes the file",
+									}},
+									{"content", map[string]interface{}{
+										"type":        "string",
+										"description": "write: the full content",
+									}},
+									{"old_text", map[string]interface{}{
+										"type":        "string",
+										"description": "patch: the exact text to replace; it must occur exactly once in the file",
+									}},
+									{"new_text", map[string]interface{}{
+										"type":        "string",
+										"description": "patch: the replacement text",
+									}},
+								},
+								"required": []string{"path", "operation"},
+							},
+						}},
+					},
+					"required": []string{"edits"},
+				},
+			},
+		},
 		{
 			Type: "function",
 			Function: FunctionDef{
@@ -160,11 +441,28 @@ func NewOpenAIAgent(cfg *config.Config, logger logging.Logger) (*OpenAIAgent, er
 				Description: "List the contents of a directory",
 				Parameters: map[string]interface{}{
 					"type": "object",
-					"properties": map[string]interface{}{
-						"path": map[string]interface{}{
+					"properties": OrderedMap{
+						{"path", map[string]interface{}{
 							"type":        "string",
 							"description": "The path to the directory",
-						},
+						}},
+					},
+					"required": []string{"path"},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: FunctionDef{
+				Name:        "change_directory",
+				Description: "Change the working directory of this session. Relative paths in later tool calls, and shell commands, are resolved against it; prefer this over prefixing commands with cd. The directory must be inside the workspace.",
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": OrderedMap{
+						{"path", map[string]interface{}{
+							"type":        "string",
+							"description": "The directory to change to, absolute or relative to the current working directory",
+						}},
 					},
 					"required": []string{"path"},
 				},
@@ -172,6 +470,33 @@ func NewOpenAIAgent(cfg *config.Config, logger logging.Logger) (*OpenAIAgent, er
 		},
 	}
 
+	defaultTools := tools
+	tools = append(tools, askUserTool, askUserChoiceTool)
+
+	if !cfg.DisableMemory {
+		tools = append(tools, memoryTools...)
+	}
+
+	// Code navigation is offered when a configured language server is installed
+	if !cfg.DisableLanguageServers && lsp.Available(cfg.LanguageServers) {
+		tools = append(tools, codeNavTools...)
+	}
+
+	// Semantic search costs an embeddings request per query, so it is opt-in
+	if cfg.SemanticSearch {
+		tools = append(tools, semanticSearchTool)
+	}
+
+	// Read-only sessions never see tools that modify files
+	if cfg.ReadOnly {
+		tools = readOnlyTools(tools)
+	}
+
+	tools, err = applyToolOverrides(tools, builtinTools(defaultTools), cfg.Tools)
+	if err != nil {
+		return nil, err
+	}
+
 	// If logger is nil, use a nil logger to avoid null pointer issues
 	if logger == nil {
 		logger = &logging.NilLogger{}
@@ -187,6 +512,49 @@ func NewOpenAIAgent(cfg *config.Config, logger logging.Logger) (*OpenAIAgent, er
 		historyOpts:      historyOpts,
 		logger:           logger,
 		pendingToolCalls: make(map[string]bool), // Initialize the map
+		gate:             newStreamGate(DefaultPauseBufferSize),
+		messages:         newMessageCache(),
+		apiTools:         convertToolDefinitions(tools),
+	}
+
+	// Guard against the model retrying the same failing tool call
+	threshold := cfg.ToolErrorRepeatThreshold
+	if threshold == 0 {
+		threshold = config.DefaultToolErrorRepeatThreshold
+	}
+	agent.toolErrors = newToolErrorGuard(threshold)
+	agent.historyOpts.Summarizer = agent.summarizeConversation
+	agent.history.Summarizer = agent.summarizeConversation
+	agent.usage = newUsageMeter(cfg)
+	agent.limiter = newRateLimiter(cfg)
+	agent.stateChanged = sync.NewCond(&agent.mu)
+	agent.messages.toolResults = cfg.ToolResultFormatFor(cfg.BaseURL)
+	if cfg.BlobStore && cfg.CWD != "" {
+		agent.blobs = blobstore.Open(blobstore.DirFor(cfg.ToolDir()), cfg.BlobCompression)
+	}
+
+	// Additions to the system prompt, merged with it for every request
+	for _, src := range cfg.SystemPromptSources {
+		agent.promptSources = append(agent.promptSources, agent.configSystemPromptSource(src))
+	}
+	agent.promptSources = append(agent.promptSources, SystemPromptSource{Name: "working_dir", Priority: WorkingDirSourcePriority, Content: workingDirSection})
+
+	for _, model := range configuredModels(cfg) {
+		if cfg.ReasoningEffort != "" && !cfg.IsReasoningModel(model) {
+			logger.Log("[WARN] NewOpenAIAgent: reasoning_effort ignored: %s is not a reasoning model (see reasoning_models)", model)
+		}
+	}
+
+	// Journal the session so it can be recovered after a crash
+	if cfg.AutosaveEnabled() {
+		if err := agent.startAutosave(cfg.AutosaveEvery()); err != nil {
+			logger.Log("[WARN] NewOpenAIAgent: Session autosave disabled: %v", err)
+		}
+	}
+
+	// Pick up edits to the instructions file without a restart
+	if cfg.WatchInstructions && cfg.InstructionsFile != "" {
+		agent.startInstructionsWatch(cfg.ResolveInstructionsFile(), DefaultInstructionsPollInterval)
 	}
 
 	return agent, nil
@@ -194,35 +562,100 @@ func NewOpenAIAgent(cfg *config.Config, logger logging.Logger) (*OpenAIAgent, er
 
 // SendMessage sends a message to OpenAI and streams the response
 // It returns true if the stream finished requesting tool calls, false otherwise.
+// It fails with ErrInteractionInProgress while another request streams, unless
+// that request was cancelled, in which case it waits for it to finish. Tool
+// calls still awaiting results are answered as aborted. With no messages the
+// response is generated against the history as it is, e.g. after AddUserMessage.
 func (a *OpenAIAgent) SendMessage(ctx context.Context, messages []Message, handler ResponseHandler) (bool, error) {
+	a.notifyInput(Input{Kind: InputSendMessage, Messages: messages})
 	a.mu.Lock()
-	// Cancel any ongoing request
-	if a.cancelFunc != nil {
-		a.logger.Log("[DEBUG] Agent.SendMessage: Cancelling previous context/request.")
-		a.cancelFunc()
+	if a.closed {
+		a.mu.Unlock()
+		return false, ErrAgentClosed
 	}
+	if a.state == StateStreaming && !a.cancelRequested {
+		a.mu.Unlock()
+		return false, ErrInteractionInProgress
+	}
+	// A cancelled stream may still be writing to the history
+	if err := a.waitWhileStreaming(ctx); err != nil {
+		a.mu.Unlock()
+		return false, err
+	}
+	// The agent may have been closed while waiting
+	if a.closed {
+		a.mu.Unlock()
+		return false, ErrAgentClosed
+	}
+	a.state = StateStreaming
+	a.beginTurn()
+	a.mu.Unlock()
+	defer a.finishStream()
 
-	// Store the handler for potential follow-up calls
-	a.currentHandler = handler
+	return a.streamMessage(ctx, messages, handler)
+}
 
-	// Create a new context with cancellation
-	a.currentContext, a.cancelFunc = context.WithCancel(ctx)
-	a.mu.Unlock() // Unlock main mutex early
+// AddUserMessage appends a user message to the history without calling the
+// API, to build up context over several messages. The next SendMessage, which
+// may pass no new messages, generates against everything added. Tool calls of
+// a cancelled turn that still await results are answered as aborted first,
+// as SendMessage would, so the message never separates a call from its
+// result. It fails with ErrInteractionInProgress while a request streams or
+// tool results are awaited, and with ErrAgentClosed after Close.
+func (a *OpenAIAgent) AddUserMessage(content string) error {
+	if strings.TrimSpace(content) == "" {
+		return errors.New("user message is empty")
+	}
+	a.notifyInput(Input{Kind: InputAddUserMessage, Content: content})
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return ErrAgentClosed
+	}
+	if a.state == StateStreaming && !a.cancelRequested {
+		return ErrInteractionInProgress
+	}
+	if err := a.waitWhileStreaming(context.Background()); err != nil {
+		return err
+	}
+	if a.state != StateIdle {
+		return fmt.Errorf("%w: the agent is %s", ErrInteractionInProgress, a.state)
+	}
 
-	// --- BEGIN CANCELLATION HANDLING ---
+	a.abortPendingToolCalls()
+	if err := a.addToHistory(Message{Role: openai.ChatMessageRoleUser, Content: content}); err != nil && messageRejected(err) {
+		return fmt.Errorf("failed to add message to history: %w", err)
+	}
+	a.addedInput = true
+	a.logger.Log("[DEBUG] Agent.AddUserMessage: Added a user message (%d chars) without sending it.", len(content))
+	return nil
+}
+
+// abortedToolReason is the result of tool calls left without one by a cancelled turn
+const abortedToolReason = "execution cancelled by user"
+
+// abortPendingToolCalls answers the tool calls of a cancelled interaction
+// that still await results with an aborted result. Results for calls no
+// longer in the history are dropped. The calls are reported with
+// "tool_aborted" items when the next request starts.
+func (a *OpenAIAgent) abortPendingToolCalls() {
 	var abortedToolResults []Message
 	a.pendingMu.Lock()
 	if len(a.pendingToolCalls) > 0 {
 		a.logger.Log("[INFO] Agent.SendMessage: Found %d pending tool calls from previous cancelled interaction.", len(a.pendingToolCalls))
+		callIDs := make([]string, 0, len(a.pendingToolCalls))
 		for callID := range a.pendingToolCalls {
-			abortedResultContent := map[string]interface{}{"error": "execution cancelled by user"}
-			// We might not know the function name here, but ToolCallID is the important part
-			abortedToolResults = append(abortedToolResults, Message{
-				Role:       openai.ChatMessageRoleTool,
-				Content:    string(mustMarshal(abortedResultContent)),
-				ToolCallID: callID,
-				// Name:       "unknown_cancelled_function", // Or leave empty
-			})
+			callIDs = append(callIDs, callID)
+		}
+		sort.Strings(callIDs)
+		for _, callID := range callIDs {
+			// The name is known while the call is still in the history
+			call := FunctionCall{ID: callID}
+			if tc, ok := a.history.FindToolCall(callID); ok {
+				call.Name = tc.Function.Name
+			}
+			abortedToolResults = append(abortedToolResults, toolErrorResult(callID, call.Name, abortedToolReason))
+			a.abortedCalls = append(a.abortedCalls, call)
 			a.logger.Log("[DEBUG] Agent.SendMessage: Created aborted result for CallID %s", callID)
 		}
 		// Clear the pending map after processing
@@ -231,73 +664,125 @@ func (a *OpenAIAgent) SendMessage(ctx context.Context, messages []Message, handl
 	}
 	a.pendingMu.Unlock()
 
-	// Add the aborted results AND the new user messages to history
+	for _, result := range abortedToolResults {
+		if err := a.addToHistory(result); err != nil {
+			a.logger.Log("[WARN] Agent.SendMessage: Aborted result for CallID %s not added: %v", result.ToolCallID, err)
+		}
+	}
 	if len(abortedToolResults) > 0 {
-		a.history.AddMessages(abortedToolResults) // Add aborted results first
 		a.logger.Log("[DEBUG] Agent.SendMessage: Added %d aborted tool results to history.", len(abortedToolResults))
 	}
-	if len(messages) > 0 {
-		a.history.AddMessages(messages) // Then add the new user message(s)
-		a.logger.Log("[DEBUG] Agent.SendMessage: Added %d new message(s) from user to history.", len(messages))
-	}
-	// --- END CANCELLATION HANDLING ---
+}
 
-	// Get context-aware messages from history
-	historyMessages := a.history.GetMessagesForContext()
-
-	// Convert messages to OpenAI format
-	var openAIMessages []openai.ChatCompletionMessage
-	for _, msg := range historyMessages {
-		// Create the base message
-		apiMsg := openai.ChatCompletionMessage{
-			Role:    msg.Role,
-			Content: msg.Content, // Content is used for user, system, assistant (text), tool (result JSON)
-		}
-
-		// Handle Assistant requesting tool calls
-		if msg.Role == openai.ChatMessageRoleAssistant && len(msg.ToolCalls) > 0 {
-			apiMsg.ToolCalls = make([]openai.ToolCall, len(msg.ToolCalls))
-			for i, tc := range msg.ToolCalls {
-				apiMsg.ToolCalls[i] = openai.ToolCall{
-					ID:   tc.ID,
-					Type: openai.ToolType(tc.Type), // Assuming type is compatible (e.g., "function")
-					Function: openai.FunctionCall{
-						Name:      tc.Function.Name,
-						Arguments: tc.Function.Arguments,
-					},
-				}
-			}
-			// Content might be empty or null when tool calls are present
-			apiMsg.Content = "" // Or check if msg.Content should be preserved
-		}
+// reportAbortedToolCalls sends a "tool_aborted" item for every call answered
+// as aborted since the last report, so hosts stop waiting on them
+func (a *OpenAIAgent) reportAbortedToolCalls(handler ResponseHandler) {
+	a.pendingMu.Lock()
+	calls := a.abortedCalls
+	a.abortedCalls = nil
+	a.pendingMu.Unlock()
 
-		// Handle Tool results
-		if msg.Role == openai.ChatMessageRoleTool {
-			apiMsg.ToolCallID = msg.ToolCallID
+	for _, call := range calls {
+		item := ResponseItem{Type: EventToolAborted, FunctionCall: &FunctionCall{ID: call.ID, Name: call.Name}, Error: abortedToolReason}
+		if data, err := json.Marshal(item); err == nil {
+			handler(string(data))
 		}
+	}
+}
 
-		openAIMessages = append(openAIMessages, apiMsg)
+// streamMessage adds messages to the history and streams the response. The
+// caller must have moved the agent to StateStreaming.
+func (a *OpenAIAgent) streamMessage(ctx context.Context, messages []Message, handler ResponseHandler) (bool, error) {
+	if err := a.checkBudget(); err != nil {
+		return false, err
 	}
 
+	a.mu.Lock()
+	// Release the context of the previous request
+	if a.cancelFunc != nil {
+		a.cancelFunc()
+	}
+
+	// Route dispatch through the response hooks, the additional handlers and
+	// the pause gate, and store it for potential follow-up calls. Hooks
+	// registered from now on apply to the next request.
+	a.gateTarget = handler
+	a.turnHooks = a.hooks.snapshot()
+	handler = a.turnHooks.wrap(a.observers.tee(a.gate.wrap(handler), a.logger))
+	hooks := a.turnHooks
+	a.currentHandler = handler
+	target := a.gateTarget
+	routed := "" // Notice of the model the turn was routed to
+
+	// New input, here or added with AddUserMessage since the last request,
+	// starts a fresh budget of retries after unknown tool calls
+	if len(messages) > 0 || a.addedInput {
+		a.unknownToolRounds = 0
+		a.addedInput = false
+		routed = a.routeTurn(messages)
+	}
+	a.applyPendingSystemPrompt()
+
+	// Create a new context with cancellation; a Cancel that arrived between
+	// requests of this turn still applies
+	a.currentContext, a.cancelFunc = context.WithCancel(ctx)
+	if a.cancelRequested {
+		a.cancelFunc()
+	}
+	a.candidates = nil
+	a.mu.Unlock() // Unlock main mutex early
+	if routed != "" {
+		sendWarning(handler, routed)
+	}
+
+	// --- BEGIN CANCELLATION HANDLING ---
+	// Add the aborted results first, then the new user messages
+	a.abortPendingToolCalls()
+	a.reportAbortedToolCalls(handler)
+	if len(messages) > 0 {
+		// Then add the new user message(s)
+		a.historyMu.Lock()
+		err := a.history.AppendMessages(messages)
+		a.historyMu.Unlock()
+		if err != nil {
+			if messageRejected(err) {
+				a.logger.Log("[ERROR] Agent.SendMessage: Rejected inconsistent message: %v", err)
+				return false, fmt.Errorf("failed to add messages to history: %w", err)
+			}
+			a.logger.Log("[WARN] Agent.SendMessage: %v", err)
+		}
+		a.logger.Log("[DEBUG] Agent.SendMessage: Added %d new message(s) from user to history.", len(messages))
+	}
+	// --- END CANCELLATION HANDLING ---
+
+	// Convert messages to OpenAI format, reusing the conversion from earlier requests
+	openAIMessages := a.requestMessages()
+
 	// --- ADD LOGGING ---
-	historyForAPILog, _ := json.MarshalIndent(openAIMessages, "", "  ")
-	a.logger.Log("[DEBUG] Agent.SendMessage: History being sent to API:\n%s", string(historyForAPILog))
+	if a.logger.IsEnabled() {
+		historyForAPILog, _ := json.MarshalIndent(openAIMessages, "", "  ")
+		a.logger.Log("[DEBUG] Agent.SendMessage: History being sent to API:\n%s", string(historyForAPILog))
+	}
 	// --- END LOGGING ---
 
 	// Create the request
 	req := openai.ChatCompletionRequest{
-		Model:       a.config.Model,
+		Model:       a.model(),
 		Messages:    openAIMessages,
 		Temperature: 0.7,
-		Tools:       convertToolDefinitions(a.tools),
+		Tools:       a.requestTools(),
 		Stream:      true,
 	}
+	a.applyReasoning(&req)
+	a.applyOutputLimit(&req)
+	a.applyChoices(&req)
+	a.applyRequestProfile(&req)
 
 	// Start thinking timer
 	startTime := time.Now()
 
 	a.logger.Log("[DEBUG] Agent.SendMessage: Creating stream request...")
-	stream, err := a.client.CreateChatCompletionStream(a.currentContext, req)
+	stream, err := a.openStream(a.currentContext, req, handler)
 	if err != nil {
 		a.logger.Log("[ERROR] Agent.SendMessage: Error creating stream: %v", err)
 		return false, fmt.Errorf("error creating chat completion stream: %w", err) // Return false on error
@@ -305,33 +790,60 @@ func (a *OpenAIAgent) SendMessage(ctx context.Context, messages []Message, handl
 	defer stream.Close()
 	a.logger.Log("[DEBUG] Agent.SendMessage: Stream created successfully. Starting Recv() loop.")
 
-	accumulatingToolCalls := make(map[string]*openai.FunctionCall)
+	accumulatingToolCalls := newToolCallAccumulator(a.logger.Log) // Matches deltas by index, so reused IDs stay distinct
+	progress := newCallProgress(a)                                // Reports calls while their arguments stream in
+	var completedToolCalls []*streamedCall
 	var currentContent string
 	currentRole := openai.ChatMessageRoleAssistant
 	streamEndedWithToolCall := false // Flag
 	processingToolCall := false      // NEW Flag: Set to true once any tool delta is received
+	var answeredCalls []Message      // Results the agent answered calls with: errors for unknown tools or rejected calls, or a model switch
+	var reportedUsage *openai.Usage  // Sent in the final chunk, after the choices
+	var finished FinishReason        // Why the response ended, none if the stream just stopped
+	updates := newMessageFlusher(a.config, req.Model)
+	think := newThinkFilter(a.config, startTime)                  // Splits inline reasoning out of the answer
+	candidates := newCandidateSet(a.config, req.Model, startTime) // Choices beyond the first, with choices > 1
+	var refusals refusalTracker                                   // Refusal text and filtered categories of the response
 
 	// Process the stream
 	for {
 		a.logger.Log("[DEBUG] Agent.SendMessage: Calling stream.Recv()...")
 		response, err := stream.Recv()
+		if errors.Is(err, errToolCallTruncated) {
+			a.logger.Log("[INFO] Agent.SendMessage: Discarding tool calls cut off at the token limit; the response was requested again.")
+			accumulatingToolCalls = newToolCallAccumulator(a.logger.Log)
+			progress = newCallProgress(a)
+			processingToolCall, streamEndedWithToolCall = false, false
+			currentContent, finished = "", FinishReasonNone
+			updates.discard()
+			think.reset()
+			candidates = newCandidateSet(a.config, req.Model, startTime)
+			refusals = refusalTracker{}
+			continue
+		}
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				a.logger.Log("[DEBUG] Agent.SendMessage: Received EOF from stream.")
 				break // Exit loop on EOF
 			}
 			a.logger.Log("[ERROR] Agent.SendMessage: Error receiving from stream: %v", err)
+			updates.flush(handler)
+			a.recordUsage(req, nil, currentContent, handler)
 			return false, fmt.Errorf("error receiving from stream: %w", err) // Return false on error
 		}
 		a.logger.Log("[DEBUG] Agent.SendMessage: stream.Recv() successful. Choices: %d", len(response.Choices))
+		if response.Usage != nil {
+			reportedUsage = response.Usage
+		}
 
-		if len(response.Choices) > 0 {
-			choice := response.Choices[0]
+		// Choice 0 drives the turn; other choices only stream their text
+		if choice, ok := candidates.split(handler, response.Choices); ok {
 			a.logger.Log("[DEBUG] Agent.SendMessage: Processing choice 0. Delta Content: %t, Delta ToolCalls: %t, FinishReason: %s", choice.Delta.Content != "", choice.Delta.ToolCalls != nil, choice.FinishReason)
 
 			if choice.Delta.Role != "" {
 				currentRole = choice.Delta.Role
 			}
+			refusals.add(choice)
 
 			// --- Check if we are starting to process tool calls ---
 			if choice.Delta.ToolCalls != nil && len(choice.Delta.ToolCalls) > 0 {
@@ -345,22 +857,13 @@ func (a *OpenAIAgent) SendMessage(ctx context.Context, messages []Message, handl
 
 			// --- Process Delta Content ONLY if NOT in tool call mode ---
 			if choice.Delta.Content != "" && !processingToolCall {
-				currentContent += choice.Delta.Content
-				// Send message update to handler for real-time display
-				// We send the update regardless of tool calls now,
-				// because the *history* addition is handled *after* the loop based on finish_reason.
-				a.logger.Log("[DEBUG] Agent.SendMessage: Calling handler with type 'message' update. Current content length: %d", len(currentContent))
-				itemToSend := ResponseItem{
-					Type: "message",
-					Message: &Message{
-						Role:    currentRole,
-						Content: currentContent,
-					},
-					ThinkingDuration: time.Since(startTime).Milliseconds(),
-				}
-				jsonData, err := json.Marshal(itemToSend)
-				if err == nil {
-					handler(string(jsonData))
+				if text := think.split(handler, choice.Delta.Content); text != "" {
+					currentContent += text
+					// Send message update to handler for real-time display
+					// We send the update regardless of tool calls now,
+					// because the *history* addition is handled *after* the loop based on finish_reason.
+					a.logger.Log("[DEBUG] Agent.SendMessage: Calling handler with type 'message' update. Current content length: %d", len(currentContent))
+					updates.send(handler, textUpdate(currentRole, currentContent, startTime))
 				}
 			} else if choice.Delta.Content != "" && processingToolCall {
 				a.logger.Log("[DEBUG] Agent.SendMessage: Ignoring delta content because we are processing tool calls.")
@@ -371,28 +874,71 @@ func (a *OpenAIAgent) SendMessage(ctx context.Context, messages []Message, handl
 				streamEndedWithToolCall = true // Mark that we are processing tool calls
 				a.logger.Log("[DEBUG] Agent.SendMessage: Processing Delta.ToolCalls.")
 				for _, toolCallChunk := range choice.Delta.ToolCalls {
-					if toolCallChunk.ID == "" {
-						continue
-					}
-					if _, exists := accumulatingToolCalls[toolCallChunk.ID]; !exists {
-						a.logger.Log("[DEBUG] Agent.SendMessage: Initializing new tool call buffer for ID: %s", toolCallChunk.ID)
-						accumulatingToolCalls[toolCallChunk.ID] = &openai.FunctionCall{Name: toolCallChunk.Function.Name}
-					}
-					if toolCallChunk.Function.Arguments != "" {
-						a.logger.Log("[DEBUG] Agent.SendMessage: Appending arguments chunk '%s' to tool call ID: %s", toolCallChunk.Function.Arguments, toolCallChunk.ID)
-						accumulatingToolCalls[toolCallChunk.ID].Arguments += toolCallChunk.Function.Arguments
-					}
+					a.logger.Log("[DEBUG] Agent.SendMessage: Accumulating tool call chunk for ID: '%s', arguments: '%s'", toolCallChunk.ID, toolCallChunk.Function.Arguments)
+					call := accumulatingToolCalls.add(toolCallChunk)
+					progress.update(handler, call.ID, &call.FunctionCall)
 				}
 			}
 
 			// --- Check FinishReason and Send Function Calls to Handler ---
-			if choice.FinishReason != "" {
-				if choice.FinishReason == "tool_calls" {
+			if finish := finishReasonOf(choice); finish != FinishReasonNone {
+				finished = finish
+				// Text held back goes out before anything else of the response
+				if text := think.finish(handler); text != "" && !processingToolCall {
+					currentContent += text
+					updates.send(handler, textUpdate(currentRole, currentContent, startTime))
+				}
+				if processingToolCall {
+					updates.flush(handler)
+				} else {
+					updates.finish(handler, finish)
+				}
+				if finish == FinishReasonToolCalls {
 					streamEndedWithToolCall = true // Confirm flag
 					a.logger.Log("[DEBUG] Agent.SendMessage: FinishReason is 'tool_calls'. Sending function calls to handler.")
 
 					// Send function call items to handler IMMEDIATELY
-					for id, completedCall := range accumulatingToolCalls {
+					completedToolCalls = accumulatingToolCalls.completed()
+					for _, completedCall := range completedToolCalls {
+						id := completedCall.ID
+						// A request for the stronger model is answered by the agent, which switches models
+						if completedCall.Name == RequestStrongerModelToolName && a.config.RoutesModels() {
+							answeredCalls = append(answeredCalls, a.escalate(id, handler).Message())
+							continue
+						}
+						// Calls to tools that do not exist are answered by the agent itself
+						if !a.hasTool(completedCall.Name) {
+							a.logger.Log("[WARN] Agent.SendMessage: Model called unknown tool '%s' (ID: %s).", completedCall.Name, id)
+							answeredCalls = append(answeredCalls, a.unknownToolResult(id, completedCall.Name))
+							sendUnknownToolWarning(handler, completedCall.Name)
+							continue
+						}
+						// Calls to tools outside the session's allowlist are denied without being dispatched
+						if !a.ToolAllowed(completedCall.Name) {
+							a.logger.Log("[WARN] Agent.SendMessage: Model called disallowed tool '%s' (ID: %s).", completedCall.Name, id)
+							answeredCalls = append(answeredCalls, toolErrorResult(id, completedCall.Name, deniedToolError(completedCall.Name)))
+							sendDeniedToolWarning(handler, completedCall.Name)
+							continue
+						}
+
+						// Sloppy JSON is repaired; arguments beyond repair are answered with the parse error
+						arguments, err := a.repairArguments(id, completedCall.Name, completedCall.Arguments)
+						if err != nil {
+							answeredCalls = append(answeredCalls, toolErrorResult(id, completedCall.Name, malformedArgumentsError(completedCall.Name, err)))
+							sendMalformedArgumentsWarning(handler, completedCall.Name)
+							continue
+						}
+						completedCall.Arguments = arguments
+
+						// Interceptors may rewrite the arguments, which the history then records, or reject the call
+						intercepted, rejection := hooks.intercept(FunctionCall{Name: completedCall.Name, Arguments: completedCall.Arguments, ID: id})
+						if rejection != nil {
+							a.logger.Log("[INFO] Agent.SendMessage: Interceptor rejected call to '%s' (ID: %s): %s", completedCall.Name, id, rejection.Reason)
+							answeredCalls = append(answeredCalls, toolErrorResult(id, completedCall.Name, rejectedToolError(completedCall.Name, rejection.Reason)))
+							sendRejectedToolWarning(handler, completedCall.Name, rejection.Reason)
+							continue
+						}
+						completedCall.Arguments = intercepted.Arguments
 						functionCall := &FunctionCall{
 							Name:      completedCall.Name,
 							Arguments: completedCall.Arguments,
@@ -409,25 +955,44 @@ func (a *OpenAIAgent) SendMessage(ctx context.Context, messages []Message, handl
 
 						a.logger.Log("[DEBUG] Agent.SendMessage: Calling handler with type 'function_call'. Name: %s, Args: '%s', ID: %s", functionCall.Name, functionCall.Arguments, functionCall.ID)
 						itemToSend := ResponseItem{
-							Type:             "function_call",
+							Type:             EventFunctionCall,
 							FunctionCall:     &FunctionCall{Name: functionCall.Name, Arguments: functionCall.Arguments, ID: functionCall.ID},
 							ThinkingDuration: time.Since(startTime).Milliseconds(),
+							FinishReason:     finish,
 						}
 						jsonData, err := json.Marshal(itemToSend)
 						if err == nil {
 							handler(string(jsonData))
 							a.logger.Log("[DEBUG] Agent.SendMessage: Sent function_call item as JSON string.")
 						}
+						if IsQuestionTool(functionCall.Name) {
+							sendUserInputRequired(handler, AskUserQuestion(functionCall.Arguments), functionCall)
+						}
 					}
 					// DO NOT add to history here. History is added AFTER the loop.
 				} else {
 					// Handle non-tool_call finish reasons (e.g., 'stop')
-					a.logger.Log("[DEBUG] Agent.SendMessage: FinishReason is '%s'.", choice.FinishReason)
+					a.logger.Log("[DEBUG] Agent.SendMessage: FinishReason is '%s' (%s).", choice.FinishReason, finish)
 					// History addition happens after the loop based on streamEndedWithToolCall flag.
 				}
 			}
 		}
 	} // End stream processing loop
+	if text := think.finish(handler); text != "" && !processingToolCall {
+		currentContent += text
+		updates.send(handler, textUpdate(currentRole, currentContent, startTime))
+	}
+	updates.flush(handler)
+	candidates.flush(handler)
+	a.recordUsage(req, reportedUsage, currentContent, handler)
+	if refusal := refusals.finish(finished); refusal != nil && !streamEndedWithToolCall {
+		currentContent = a.reportRefusal(handler, refusal, updates, currentContent, finished)
+	}
+	if req.N > 1 {
+		a.mu.Lock()
+		a.candidates = candidates.all(currentContent)
+		a.mu.Unlock()
+	}
 
 	a.logger.Log("[DEBUG] Agent.SendMessage: Exited Recv() loop.")
 
@@ -436,13 +1001,16 @@ func (a *OpenAIAgent) SendMessage(ctx context.Context, messages []Message, handl
 		if streamEndedWithToolCall {
 			// Add assistant message with ONLY tool calls
 			assistantMsgToolCalls := []ToolCall{}
-			for id, completedCall := range accumulatingToolCalls {
+			if completedToolCalls == nil {
+				completedToolCalls = accumulatingToolCalls.completed()
+			}
+			for _, completedCall := range completedToolCalls {
 				args := completedCall.Arguments
 				if args == "" {
 					args = "{}"
 				}
 				assistantMsgToolCalls = append(assistantMsgToolCalls, ToolCall{
-					ID:   id,
+					ID:   completedCall.ID,
 					Type: string(openai.ToolTypeFunction),
 					Function: FunctionCall{
 						Name:      completedCall.Name,
@@ -452,32 +1020,120 @@ func (a *OpenAIAgent) SendMessage(ctx context.Context, messages []Message, handl
 			}
 			if len(assistantMsgToolCalls) > 0 { // Only add if there were actual tool calls requested
 				assistantMsg := Message{
+					ID:        updates.id,
+					Model:     updates.model,
 					Role:      openai.ChatMessageRoleAssistant,
 					ToolCalls: assistantMsgToolCalls,
 					Content:   "", // Explicitly empty content
 				}
-				a.history.AddMessage(assistantMsg)
+				a.addToHistory(assistantMsg)
 				a.logger.Log("[DEBUG] Agent.SendMessage: Added final assistant message (ToolCalls only) to history.")
+
+				// Answer calls to unknown tools and rejected calls with an error the model can act on
+				for _, result := range answeredCalls {
+					if err := a.addToHistory(result); err != nil {
+						a.logger.Log("[WARN] Agent.SendMessage: Error result for CallID %s not added: %v", result.ToolCallID, err)
+					}
+				}
+
+				// Prefetch: convert the history now, while the tools run or await
+				// approval, so the follow-up request only converts the tool results
+				a.messages.build(a.history)
 			} else {
 				a.logger.Log("[WARN] Agent.SendMessage: Stream ended with tool_calls reason, but no tool calls were accumulated.")
 			}
 		} else if currentContent != "" {
 			// Add assistant message with ONLY text content
 			assistantMsg := Message{
+				ID:      updates.id, // As on the "message" updates of the reply
+				Model:   updates.model,
 				Role:    currentRole, // Should be assistant
 				Content: currentContent,
 			}
-			a.history.AddMessage(assistantMsg)
+			a.addToHistory(assistantMsg)
 			a.logger.Log("[DEBUG] Agent.SendMessage: Added final assistant message (Text only) to history.")
 		}
 	} else {
 		a.logger.Log("[ERROR] Agent.SendMessage: History is nil when trying to add final assistant message.")
 	}
 
+	// With every call unknown or rejected nothing is left for the app to run,
+	// so the model gets the errors straight away
+	if streamEndedWithToolCall && len(answeredCalls) > 0 && len(answeredCalls) == len(completedToolCalls) {
+		if a.unknownToolRounds < maxUnknownToolRounds {
+			a.unknownToolRounds++
+			a.logger.Log("[INFO] Agent.SendMessage: All %d tool calls were unknown or rejected; re-requesting (round %d).", len(answeredCalls), a.unknownToolRounds)
+			return a.streamMessage(ctx, nil, target)
+		}
+		a.logger.Log("[WARN] Agent.SendMessage: Model kept making calls that could not run; ending the turn.")
+		return false, nil
+	}
+
+	// A response with nothing in it is requested again or reported, never silently dropped
+	if isEmptyResponse(currentContent, streamEndedWithToolCall, finished) {
+		if a.retryEmptyResponse(handler, finished) {
+			return a.streamMessage(ctx, nil, target)
+		}
+		return false, nil
+	}
+	a.resetEmptyRetry()
+
+	// A turn ending on a question waits for an answer the host may have to supply
+	if question := TrailingQuestion(currentContent); question != "" && !streamEndedWithToolCall {
+		a.logger.Log("[INFO] Agent.SendMessage: Reply ends with a question; user input required.")
+		sendUserInputRequired(handler, question, nil)
+	}
+
 	a.logger.Log("[DEBUG] Agent.SendMessage: Function returning. Stream ended with tool call: %t", streamEndedWithToolCall)
 	return streamEndedWithToolCall, nil // Return the flag and nil error
 }
 
+// EditAndRegenerate replaces the content of an earlier user message, drops every
+// message after it and streams a new response, like SendMessage. Tool calls issued
+// after the edited message are forgotten so no request is left without a result.
+func (a *OpenAIAgent) EditAndRegenerate(ctx context.Context, messageIndex int, newContent string, handler ResponseHandler) (bool, error) {
+	a.notifyInput(Input{Kind: InputEdit, Index: messageIndex, Content: newContent})
+	if a.history == nil {
+		return false, errors.New("agent history is nil")
+	}
+	messages := a.history.GetMessages()
+	if messageIndex < 0 || messageIndex >= len(messages) {
+		return false, fmt.Errorf("message index %d out of range (history has %d messages)", messageIndex, len(messages))
+	}
+	if messages[messageIndex].Role != openai.ChatMessageRoleUser {
+		return false, fmt.Errorf("message %d is a %s message; only user messages can be edited", messageIndex, messages[messageIndex].Role)
+	}
+
+	// Stop any in-flight response and let it finish writing before rewriting the history under it
+	a.Cancel()
+	a.mu.Lock()
+	err := a.waitWhileStreaming(ctx)
+	a.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	edited := messages[messageIndex]
+	edited.Content = newContent
+	a.historyMu.Lock()
+	a.history.Truncate(messageIndex)
+	a.historyMu.Unlock()
+	a.toolErrors.reset()
+	a.logger.Log("[DEBUG] Agent.EditAndRegenerate: Truncated history to %d messages before edited message.", messageIndex)
+
+	// Pending calls that were truncated away must not get aborted results
+	a.pendingMu.Lock()
+	for callID := range a.pendingToolCalls {
+		if _, found := a.history.FindToolCall(callID); !found {
+			delete(a.pendingToolCalls, callID)
+			a.logger.Log("[DEBUG] Agent.EditAndRegenerate: Dropped pending CallID %s from truncated history.", callID)
+		}
+	}
+	a.pendingMu.Unlock()
+
+	return a.SendMessage(ctx, []Message{edited}, handler)
+}
+
 // SendFileChange sends a file change to the AI for approval
 func (a *OpenAIAgent) SendFileChange(ctx context.Context, filePath string, diff string) (*FileChangeConfirmation, error) {
 	// In a real implementation, this would send the diff to the AI for approval
@@ -497,7 +1153,10 @@ func (a *OpenAIAgent) GetCommandConfirmation(ctx context.Context, command string
 }
 
 // Cancel cancels the current streaming response and marks pending tool calls for abort handling.
+// A cancelled stream returns the agent to StateIdle once it has finished
+// writing to the history; results for its tool calls are then refused.
 func (a *OpenAIAgent) Cancel() {
+	var record *usagelog.Record
 	a.mu.Lock() // Lock main mutex for cancelFunc
 	if a.cancelFunc != nil {
 		a.logger.Log("[DEBUG] Agent.Cancel: Calling context cancelFunc().")
@@ -506,7 +1165,16 @@ func (a *OpenAIAgent) Cancel() {
 	} else {
 		a.logger.Log("[DEBUG] Agent.Cancel: No active context cancelFunc to call.")
 	}
+	switch a.state {
+	case StateStreaming:
+		a.cancelRequested = true
+	case StateAwaitingToolResults:
+		a.state = StateIdle
+		record = a.endTurn()
+		a.stateChanged.Broadcast()
+	}
 	a.mu.Unlock()
+	a.logTurnUsage(record)
 
 	// Note: We don't clear pendingToolCalls here. The map now correctly represents
 	// calls that were issued but whose results were not processed before cancellation.
@@ -514,27 +1182,108 @@ func (a *OpenAIAgent) Cancel() {
 	a.logger.Log("[DEBUG] Agent.Cancel: Cancellation requested. Pending tool calls will be handled on next SendMessage.")
 }
 
-// Close closes the agent and releases any resources
+// Close closes the agent and releases any resources. It is idempotent and safe
+// to call concurrently, including from a signal handler while a turn is
+// streaming: every call returns the result of the first. Close cancels the
+// in-flight request and waits (up to closeSettleTimeout) for it to finish
+// writing to the history before saving it, so the saved history is never
+// caught mid-update. Requests made after Close fail with ErrAgentClosed.
+//
+// The error wraps ErrCloseCancelFailed if the request did not stop in time
+// and ErrCloseSaveFailed if the history or the autosaved session could not be
+// saved; the history is then saved to a fallback directory readable by the
+// user alone, under the user's cache directory, and the error names the file.
 func (a *OpenAIAgent) Close() error {
-	a.Cancel()
+	a.closeOnce.Do(func() {
+		a.mu.Lock()
+		a.closed = true
+		a.mu.Unlock()
+
+		a.Cancel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), closeSettleTimeout)
+		defer cancel()
+
+		// Save history before closing, holding the lock that guards it
+		a.mu.Lock()
+		var errs []error
+		if err := a.waitWhileStreaming(ctx); err != nil {
+			a.logger.Log("[WARN] Agent.Close: Request did not settle before saving history: %v", err)
+			errs = append(errs, fmt.Errorf("%w: request did not stop: %w", ErrCloseCancelFailed, err))
+		}
+		savedFallback := false
+		if a.history != nil {
+			if err := a.history.Save(a.historyOpts.HistoryPath); err != nil {
+				errs = append(errs, a.saveFallbackHistory(err))
+				savedFallback = true
+			}
+		}
+		a.mu.Unlock()
 
-	// Save history before closing
-	if a.history != nil {
-		a.history.Save(a.historyOpts.HistoryPath)
+		a.stopInstructionsWatch()
+
+		// A clean close leaves a complete snapshot and no journal to recover
+		if err := a.stopAutosave(); err != nil {
+			a.mu.Lock()
+			if a.history != nil && !savedFallback {
+				errs = append(errs, a.saveFallbackHistory(fmt.Errorf("failed to save session: %w", err)))
+			} else {
+				errs = append(errs, fmt.Errorf("%w: failed to save session: %w", ErrCloseSaveFailed, err))
+			}
+			a.mu.Unlock()
+		}
+		a.closeErr = errors.Join(errs...)
+
+		// Let additional handlers finish recording the stream
+		a.observers.closeAll()
+	})
+	return a.closeErr
+}
+
+// fallbackHistoryDir returns where Close saves the history when saving it
+// where configured fails: a directory of the user's cache, or the session
+// directory when there is no cache directory
+func (a *OpenAIAgent) fallbackHistoryDir() (string, error) {
+	if cacheDir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(cacheDir, "codex-go", "history-fallback"), nil
 	}
+	if a.config.SessionDir != "" {
+		return filepath.Join(a.config.SessionDir, "fallback"), nil
+	}
+	return "", errors.New("no user cache or session directory")
+}
 
-	return nil
+// saveFallbackHistory saves the history to the fallback directory after
+// saving it failed with saveErr, so the session is not lost on shutdown. It
+// returns the error for Close, naming the fallback file if the history could
+// be saved there. The caller must hold a.mu.
+func (a *OpenAIAgent) saveFallbackHistory(saveErr error) error {
+	dir, err := a.fallbackHistoryDir()
+	var path string
+	if err == nil {
+		path, err = a.history.writePrivateFile(dir)
+	}
+	if err != nil {
+		a.logger.Log("[ERROR] Agent.Close: Failed to save history (%v), and to the fallback %s: %v", saveErr, dir, err)
+		return fmt.Errorf("%w: failed to save history: %w; fallback save to %s also failed: %v", ErrCloseSaveFailed, saveErr, dir, err)
+	}
+	a.logger.Log("[WARN] Agent.Close: Failed to save history (%v); saved it to the fallback %s instead.", saveErr, path)
+	return fmt.Errorf("%w: failed to save history: %w; saved to %s instead", ErrCloseSaveFailed, saveErr, path)
 }
 
 // ClearHistory clears the conversation history
 func (a *OpenAIAgent) ClearHistory() {
+	a.notifyInput(Input{Kind: InputClearHistory})
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
 	if a.history != nil {
+		a.historyMu.Lock()
 		a.history.Clear()
+		a.historyMu.Unlock()
 		a.history.Save(a.historyOpts.HistoryPath)
 	}
+	a.toolErrors.reset()
 }
 
 // GetHistory returns the conversation history
@@ -542,137 +1291,330 @@ func (a *OpenAIAgent) GetHistory() *ConversationHistory {
 	return a.history
 }
 
-// SendFunctionResult adds the tool result to history and then triggers the next AI response stream.
-func (a *OpenAIAgent) SendFunctionResult(ctx context.Context, callID, functionName, output string, success bool) error {
+// HistorySnapshot returns a copy of the messages of the conversation history
+// and its working directory. Unlike reading GetHistory, it is safe while a
+// turn changes the history on another goroutine.
+func (a *OpenAIAgent) HistorySnapshot() ([]Message, string) {
 	a.mu.Lock()
-	// Get the handler before potentially unlocking in defer
-	handler := a.currentHandler
+	history := a.history
 	a.mu.Unlock()
+	if history == nil {
+		return nil, ""
+	}
+	a.historyMu.Lock()
+	defer a.historyMu.Unlock()
+	return append([]Message{}, history.Messages...), history.WorkingDir
+}
 
-	a.logger.Log("[DEBUG] Agent.SendFunctionResult: Received result for CallID: %s, Name: %s, Success: %t", callID, functionName, success)
+// addToHistory adds message to the history with historyMu held
+func (a *OpenAIAgent) addToHistory(message Message) error {
+	a.historyMu.Lock()
+	defer a.historyMu.Unlock()
+	return a.history.AppendMessage(message)
+}
+
+// SetHistory replaces the conversation history, e.g. with a fork of another
+// agent's history. Pending tool calls from the previous history are dropped.
+func (a *OpenAIAgent) SetHistory(history *ConversationHistory) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	history.Lock = a.historyOpts.Lock
+	history.Summarizer = a.historyOpts.Summarizer
+	a.switchJournal(history)
+	a.history = history
+	a.toolErrors.reset()
 
-	// --- BEGIN Remove from Pending Tool Calls ---
 	a.pendingMu.Lock()
-	if _, exists := a.pendingToolCalls[callID]; exists {
-		delete(a.pendingToolCalls, callID)
-		a.logger.Log("[DEBUG] Agent.SendFunctionResult: Removed CallID %s from pendingToolCalls", callID)
+	a.pendingToolCalls = make(map[string]bool)
+	a.pendingMu.Unlock()
+}
+
+// Pause stops dispatching streamed items to the handler without closing the stream.
+// Items are buffered until Resume; Cancel still works while paused.
+func (a *OpenAIAgent) Pause() {
+	a.logger.Log("[DEBUG] Agent.Pause: Pausing handler dispatch.")
+	a.gate.pause()
+}
+
+// Resume flushes items buffered while paused and continues dispatching
+func (a *OpenAIAgent) Resume() {
+	a.mu.Lock()
+	target := a.gateTarget
+	a.mu.Unlock()
+	a.logger.Log("[DEBUG] Agent.Resume: Resuming handler dispatch.")
+	a.gate.resume(target)
+}
+
+// IsPaused reports whether handler dispatch is paused
+func (a *OpenAIAgent) IsPaused() bool {
+	return a.gate.isPaused()
+}
+
+// GetToolDefinitions returns a copy of the tool definitions sent to the model
+func (a *OpenAIAgent) GetToolDefinitions() []ToolDefinition {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tools := make([]ToolDefinition, len(a.tools))
+	copy(tools, a.tools)
+	return tools
+}
+
+// ExportToolSchemas writes the tools exactly as the API receives them, after conversion
+func (a *OpenAIAgent) ExportToolSchemas(w io.Writer) error {
+	converted := convertToolDefinitions(a.GetToolDefinitions())
+	data, err := json.MarshalIndent(converted, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal tool schemas: %w", err)
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write tool schemas: %w", err)
+	}
+	return nil
+}
+
+// IsToolCallPending reports whether a tool call is still awaiting its result
+func (a *OpenAIAgent) IsToolCallPending(callID string) bool {
+	a.pendingMu.Lock()
+	defer a.pendingMu.Unlock()
+	return a.pendingToolCalls[callID]
+}
+
+// ToolCallCancelled is the error sent to the model for a tool call the user
+// cancelled
+const ToolCallCancelled = "Cancelled by user before it finished. Try a different approach, or continue without this result."
+
+// CancelToolCall answers a pending tool call as cancelled by the user. The
+// model receives it as a failed result and can decide whether to retry
+// differently; other pending calls are left alone, and the turn goes on once
+// they have results. Stopping the call's execution is up to whoever runs it
+// (see functions.Executor.CancelCall); a result still sent for the call
+// afterwards is refused with ErrUnknownToolCall.
+func (a *OpenAIAgent) CancelToolCall(ctx context.Context, callID string) error {
+	if !a.IsToolCallPending(callID) {
+		return fmt.Errorf("failed to cancel call %s: %w", callID, ErrUnknownToolCall)
+	}
+	a.mu.Lock()
+	name := ""
+	if tc, found := a.history.FindToolCall(callID); found {
+		name = tc.Function.Name
+	}
+	a.mu.Unlock()
+
+	a.logger.Log("[INFO] Agent.CancelToolCall: User cancelled call %s (%s).", callID, name)
+	return a.SendToolResult(ctx, NewToolResult(callID, name, ToolCallCancelled, false))
+}
+
+// SendFunctionResult sends the result of a tool call as its output or, when
+// success is false, its error.
+//
+// Deprecated: use SendToolResult, which can also report an exit code, the
+// duration and metadata.
+func (a *OpenAIAgent) SendFunctionResult(ctx context.Context, callID, functionName, output string, success bool) error {
+	return a.SendToolResult(ctx, NewToolResult(callID, functionName, output, success))
+}
+
+// SendToolResult adds the tool result to history and then triggers the next AI response stream.
+// Results are accepted while the agent awaits them; one that arrives while the
+// stream that requested it is still finishing waits for that stream. The
+// follow-up request is sent once every pending call has a result. When the
+// interaction has ended by then (FinalizeInteraction cleared the handler), the
+// config's OrphanedToolResults policy decides between dropping the follow-up,
+// returning ErrOrphanedToolResult and streaming it to a no-op handler.
+func (a *OpenAIAgent) SendToolResult(ctx context.Context, result ToolResult) error {
+	callID := result.CallID
+	sent := result
+	a.notifyInput(Input{Kind: InputToolResult, CallID: callID, Tool: result.Name, Result: &sent})
+	a.logger.Log("[DEBUG] Agent.SendFunctionResult: Received result for CallID: %s, Name: %s, Success: %t", callID, result.Name, result.Success)
+
+	// Large outputs are condensed for the model; the full text is saved, and the audit log points to it
+	if result.Success {
+		result.Output = a.condenseToolOutput(ctx, callID, result.Name, result.Output)
 	} else {
-		// This might happen if SendFunctionResult is called unexpectedly or after a cancellation was already processed.
-		a.logger.Log("[WARN] Agent.SendFunctionResult: CallID %s not found in pendingToolCalls when trying to remove.", callID)
+		result.Error = a.condenseToolOutput(ctx, callID, result.Name, result.Error)
+	}
+	result = a.guardToolResult(ctx, result)
+
+	a.mu.Lock()
+	if err := a.waitWhileStreaming(ctx); err != nil {
+		a.mu.Unlock()
+		return err
+	}
+	if a.closed {
+		a.mu.Unlock()
+		return fmt.Errorf("failed to send result for call %s: %w", callID, ErrAgentClosed)
+	}
+	if a.state != StateAwaitingToolResults {
+		state := a.state
+		a.mu.Unlock()
+		a.logger.Log("[WARN] Agent.SendFunctionResult: Result for CallID %s arrived while %s.", callID, state)
+		return fmt.Errorf("failed to send result for call %s: %w (agent is %s)", callID, ErrNoToolResultsAwaited, state)
+	}
+
+	// --- BEGIN Remove from Pending Tool Calls ---
+	a.pendingMu.Lock()
+	if !a.pendingToolCalls[callID] {
+		a.pendingMu.Unlock()
+		a.mu.Unlock()
+		a.logger.Log("[WARN] Agent.SendFunctionResult: CallID %s not found in pendingToolCalls.", callID)
+		return fmt.Errorf("failed to send result for call %s: %w", callID, ErrUnknownToolCall)
 	}
+	delete(a.pendingToolCalls, callID)
+	remaining := len(a.pendingToolCalls)
 	a.pendingMu.Unlock()
+	a.logger.Log("[DEBUG] Agent.SendFunctionResult: Removed CallID %s from pendingToolCalls (%d still pending)", callID, remaining)
 	// --- END Remove from Pending Tool Calls ---
 
-	// 1. Create the tool result message to add to history
-	var content map[string]interface{}
-	if success {
-		content = map[string]interface{}{"output": output}
-	} else {
-		content = map[string]interface{}{"error": output}
+	if fixes := a.takeArgumentRepairs(callID); len(fixes) > 0 {
+		if result.Metadata == nil {
+			result.Metadata = make(map[string]string)
+		}
+		result.Metadata["arguments_repaired"] = strings.Join(fixes, ", ")
 	}
-	// Create the Tool Result message part
-	toolResultMessage := Message{
-		Role:       openai.ChatMessageRoleTool,
-		Content:    string(json.RawMessage(mustMarshal(content))), // Ensure content is valid JSON string
-		ToolCallID: callID,
-		Name:       functionName,
+	if env := commandEnvMetadata(a.config, result.Name); env != "" {
+		if result.Metadata == nil {
+			result.Metadata = make(map[string]string)
+		}
+		result.Metadata["command_env"] = env
 	}
 
-	if a.history != nil {
-		// Add ONLY the tool result message to history. The assistant message
-		// with the tool call request is already present from SendMessage.
-		a.history.AddMessage(toolResultMessage)
-		a.logger.Log("[DEBUG] Agent.SendFunctionResult: Tool result message added to history.")
-	} else {
-		a.logger.Log("[ERROR] Agent.SendFunctionResult: History is nil, cannot add tool result message.")
-		return fmt.Errorf("agent history is nil") // Return error if history doesn't exist
+	a.countToolCall(result.Name)
+	if err := a.recordToolResult(result); err != nil {
+		var record *usagelog.Record
+		if remaining == 0 {
+			a.state = StateIdle
+			record = a.endTurn()
+			a.stateChanged.Broadcast()
+		}
+		a.mu.Unlock()
+		a.logTurnUsage(record)
+		return err
 	}
 
-	// 2. Check if a handler is available (meaning SendMessage is waiting)
-	if handler == nil {
-		a.logger.Log("[WARN] Agent.SendFunctionResult: No current handler available to send follow-up request.")
-		// This might happen if the original SendMessage context was cancelled
-		return nil // Or return an error?
+	// The model needs every result before it can continue
+	if remaining > 0 {
+		a.mu.Unlock()
+		return nil
 	}
 
-	// 3. Prepare and send the follow-up request to OpenAI
-	a.logger.Log("[DEBUG] Agent.SendFunctionResult: Preparing follow-up OpenAI request.")
-	historyMessages := a.history.GetMessagesForContext()
-	var openAIMessages []openai.ChatCompletionMessage
-
-	// --- FILTERING HISTORY FOR API ---
-	// Ensure the sequence Assistant(ToolCall) -> Tool(Result) is strictly maintained
-	// Skip any intermediate Assistant(Content) messages.
-	toolCallIDsExpected := make(map[string]bool) // Keep track of tool IDs we expect results for
-
-	for i := 0; i < len(historyMessages); i++ {
-		msg := historyMessages[i]
-		apiMsg := openai.ChatCompletionMessage{
-			Role:    msg.Role,
-			Content: msg.Content, // May be overridden below
-		}
-		addMsg := true // Flag to control if we add the message
-
-		if msg.Role == openai.ChatMessageRoleAssistant {
-			if len(msg.ToolCalls) > 0 {
-				// This is an assistant message requesting tool calls
-				apiMsg.ToolCalls = make([]openai.ToolCall, len(msg.ToolCalls))
-				for j, tc := range msg.ToolCalls {
-					apiMsg.ToolCalls[j] = openai.ToolCall{
-						ID:   tc.ID,
-						Type: openai.ToolType(tc.Type),
-						Function: openai.FunctionCall{
-							Name:      tc.Function.Name,
-							Arguments: tc.Function.Arguments,
-						},
-					}
-					// Mark this tool call ID as expected
-					toolCallIDsExpected[tc.ID] = true
-				}
-				apiMsg.Content = "" // Content MUST be empty/null when tool calls are present
-			} else if len(toolCallIDsExpected) > 0 {
-				// This is a text message from the assistant, BUT we are still expecting tool results.
-				// This is the message we need to SKIP.
-				a.logger.Log("[DEBUG] Agent.SendFunctionResult: Skipping assistant text message (Role: %s, Content: %d chars) because tool results are pending.", msg.Role, len(msg.Content))
-				addMsg = false
-			}
-			// Otherwise, it's a normal assistant text message when no tool calls are pending - keep it.
+	// Check if a handler is available (meaning the interaction is still open).
+	// If not, the result is orphaned; it is already in the history, and the
+	// orphaned_tool_results policy decides whether the model still sees it now.
+	handler := a.currentHandler
+	hooks := a.turnHooks
+	if handler == nil {
+		switch a.config.OrphanedToolResults {
+		case config.OrphanedResultFollowUp:
+			a.logger.Log("[INFO] Agent.SendFunctionResult: No current handler for CallID %s; sending the follow-up request with its response discarded.", callID)
+			handler = func(string) {}
+		case config.OrphanedResultError:
+			a.state = StateIdle
+			record := a.endTurn()
+			a.stateChanged.Broadcast()
+			a.mu.Unlock()
+			a.logTurnUsage(record)
+			a.logger.Log("[WARN] Agent.SendFunctionResult: No current handler for CallID %s; result recorded without a follow-up request.", callID)
+			return fmt.Errorf("failed to continue after result for call %s: %w", callID, ErrOrphanedToolResult)
+		default:
+			a.state = StateIdle
+			record := a.endTurn()
+			a.stateChanged.Broadcast()
+			a.mu.Unlock()
+			a.logTurnUsage(record)
+			a.logger.Log("[WARN] Agent.SendFunctionResult: No current handler available to send follow-up request.")
+			return nil
 		}
+	}
 
-		if msg.Role == openai.ChatMessageRoleTool {
-			// This is a tool result message
-			apiMsg.ToolCallID = msg.ToolCallID
-			// Mark this tool call ID as fulfilled
-			if _, exists := toolCallIDsExpected[msg.ToolCallID]; exists {
-				delete(toolCallIDsExpected, msg.ToolCallID)
-				a.logger.Log("[DEBUG] Agent.SendFunctionResult: Matched Tool Result for ID %s.", msg.ToolCallID)
-			} else {
-				// This shouldn't normally happen if history is consistent
-				a.logger.Log("[WARN] Agent.SendFunctionResult: Encountered Tool Result for unexpected ID %s.", msg.ToolCallID)
+	// The follow-up stream can be cancelled like the one that requested the calls
+	a.state = StateStreaming
+	if a.cancelFunc != nil {
+		a.cancelFunc()
+	}
+	a.currentContext, a.cancelFunc = context.WithCancel(ctx)
+	streamCtx := a.currentContext
+	a.mu.Unlock()
+	defer a.finishStream()
+
+	return a.streamFollowUp(streamCtx, handler, hooks)
+}
+
+// recordToolResult adds a tool result to the history, collapsing repeats of
+// the same failing call. The caller must own the history, either by holding
+// a.mu while awaiting tool results or by streaming.
+func (a *OpenAIAgent) recordToolResult(result ToolResult) error {
+	callID, functionName := result.CallID, result.Name
+	toolResultMessage := result.Message()
+
+	// --- BEGIN Repeated Tool Error Guard ---
+	if a.history != nil {
+		arguments := ""
+		if tc, found := a.history.FindToolCall(callID); found {
+			arguments = tc.Function.Arguments
+		}
+		collapse, count := a.toolErrors.record(toolCallSignature(functionName, arguments), callID, result.Success)
+		if len(collapse) > 0 {
+			a.logger.Log("[INFO] Agent.SendFunctionResult: '%s' failed %d times with identical arguments; collapsing %d earlier error(s).", functionName, count, len(collapse))
+			a.historyMu.Lock()
+			for _, id := range collapse {
+				a.history.ReplaceToolResultContent(id, collapsedToolErrorContent)
 			}
+			a.historyMu.Unlock()
+			toolResultMessage.Content = repeatedToolError(result, count).Content()
 		}
+	}
+	// --- END Repeated Tool Error Guard ---
 
-		if addMsg {
-			openAIMessages = append(openAIMessages, apiMsg)
+	if a.history == nil {
+		a.logger.Log("[ERROR] Agent.SendFunctionResult: History is nil, cannot add tool result message.")
+		return fmt.Errorf("agent history is nil") // Return error if history doesn't exist
+	}
+	// Add ONLY the tool result message to history. The assistant message
+	// with the tool call request is already present from SendMessage.
+	if err := a.addToHistory(toolResultMessage); err != nil {
+		if messageRejected(err) {
+			a.logger.Log("[ERROR] Agent.SendFunctionResult: Rejected tool result: %v", err)
+			return fmt.Errorf("failed to add tool result to history: %w", err)
 		}
+		a.logger.Log("[WARN] Agent.SendFunctionResult: %v", err)
+	}
+	a.logger.Log("[DEBUG] Agent.SendFunctionResult: Tool result message added to history.")
+	return nil
+}
+
+// streamFollowUp sends the history, ending in tool results, and streams the
+// model's reaction to handler. The caller must have moved the agent to StateStreaming.
+func (a *OpenAIAgent) streamFollowUp(ctx context.Context, handler ResponseHandler, hooks turnHooks) error {
+	if err := a.checkBudget(); err != nil {
+		return err
 	}
-	// --- END FILTERING ---
+
+	// Prepare and send the follow-up request to OpenAI
+	a.logger.Log("[DEBUG] Agent.SendFunctionResult: Preparing follow-up OpenAI request.")
+	// Only the messages added since the last request are converted; the
+	// Assistant(ToolCall) -> Tool(Result) sequence is kept strict by the cache
+	openAIMessages := a.requestMessages()
 
 	// --- ADD LOGGING ---
-	historyForAPILog, _ := json.MarshalIndent(openAIMessages, "", "  ")
-	a.logger.Log("[DEBUG] Agent.SendFunctionResult: Filtered History being sent to API:\n%s", string(historyForAPILog))
+	if a.logger.IsEnabled() {
+		historyForAPILog, _ := json.MarshalIndent(openAIMessages, "", "  ")
+		a.logger.Log("[DEBUG] Agent.SendFunctionResult: Filtered History being sent to API:\n%s", string(historyForAPILog))
+	}
 	// --- END LOGGING ---
 
 	req := openai.ChatCompletionRequest{
-		Model:       a.config.Model,
+		Model:       a.model(),
 		Messages:    openAIMessages,
 		Temperature: 0.7,
-		Tools:       convertToolDefinitions(a.tools),
+		Tools:       a.requestTools(),
 		Stream:      true,
 	}
+	a.applyReasoning(&req)
+	a.applyOutputLimit(&req)
+	a.applyRequestProfile(&req)
 
 	a.logger.Log("[DEBUG] Agent.SendFunctionResult: Making follow-up CreateChatCompletionStream call.")
-	stream, err := a.client.CreateChatCompletionStream(ctx, req) // Use the passed context
+	stream, err := a.openStream(ctx, req, handler)
 	if err != nil {
 		a.logger.Log("[ERROR] Agent.SendFunctionResult: Error creating follow-up stream: %v", err)
 		// Should we maybe inform the handler of this error?
@@ -681,13 +1623,23 @@ func (a *OpenAIAgent) SendFunctionResult(ctx context.Context, callID, functionNa
 	}
 	defer stream.Close()
 
-	// 4. Process the new stream, sending results back via the original handler
+	// Process the new stream, sending results back via the original handler
 	a.logger.Log("[DEBUG] Agent.SendFunctionResult: Processing follow-up stream...")
 	startTime := time.Now() // Reset start time for this response phase
 	var currentContent string
 	currentRole := openai.ChatMessageRoleAssistant // Expecting assistant response now
 	var currentFunctionCall *openai.FunctionCall   // Added for potential nested calls
 	var currentFunctionCallID string               // Added for potential nested calls
+	var answeredCallID, answeredCallName string    // Nested call to an unknown tool, or a rejected one
+	var answeredCallError string                   // Error the agent answers that call with
+	var answeredCallOK bool                        // The call succeeded instead, with answeredCallError as its output
+	var reportedUsage *openai.Usage                // Sent in the final chunk, after the choices
+	var finished FinishReason                      // Why the response ended, none if the stream just stopped
+	calledTool := false                            // Whether the response made a tool call
+	progress := newCallProgress(a)                 // Reports a nested call while its arguments stream in
+	updates := newMessageFlusher(a.config, req.Model)
+	think := newThinkFilter(a.config, startTime) // Splits inline reasoning out of the answer
+	var refusals refusalTracker                  // Refusal text and filtered categories of the response
 
 	for {
 		response, err := stream.Recv()
@@ -695,34 +1647,36 @@ func (a *OpenAIAgent) SendFunctionResult(ctx context.Context, callID, functionNa
 			a.logger.Log("[DEBUG] Agent.SendFunctionResult: Received EOF from follow-up stream.")
 			break
 		}
+		if errors.Is(err, errToolCallTruncated) {
+			a.logger.Log("[INFO] Agent.SendFunctionResult: Discarding a tool call cut off at the token limit; the response was requested again.")
+			currentFunctionCall, currentFunctionCallID = nil, ""
+			progress = newCallProgress(a)
+			currentContent, finished = "", FinishReasonNone
+			updates.discard()
+			think.reset()
+			continue
+		}
 		if err != nil {
 			a.logger.Log("[ERROR] Agent.SendFunctionResult: Error receiving from follow-up stream: %v", err)
+			updates.flush(handler)
+			a.recordUsage(req, nil, currentContent, handler)
 			// Inform handler?
 			return fmt.Errorf("error receiving from follow-up stream: %w", err)
 		}
+		if response.Usage != nil {
+			reportedUsage = response.Usage
+		}
 
 		if len(response.Choices) > 0 {
 			choice := response.Choices[0]
+			refusals.add(choice)
 			a.logger.Log("[DEBUG] Agent.SendFunctionResult: Processing choice 0. Delta Content: %t, Delta ToolCalls: %t, FinishReason: %s", choice.Delta.Content != "", choice.Delta.ToolCalls != nil, choice.FinishReason)
 
 			// Handle delta content (for text response)
-			if choice.Delta.Content != "" {
-				currentContent += choice.Delta.Content
+			if text := think.split(handler, choice.Delta.Content); text != "" {
+				currentContent += text
 				a.logger.Log("[DEBUG] Agent.SendFunctionResult: Calling handler with type 'message'. Current content length: %d", len(currentContent))
-				itemToSend := ResponseItem{
-					Type: "message",
-					Message: &Message{
-						Role:    currentRole,
-						Content: currentContent,
-					},
-					ThinkingDuration: time.Since(startTime).Milliseconds(),
-				}
-				jsonData, err := json.Marshal(itemToSend)
-				if err != nil {
-					a.logger.Log("[ERROR] Agent.SendFunctionResult: Failed to marshal message item: %v", err)
-				} else {
-					handler(string(jsonData))
-				}
+				updates.send(handler, textUpdate(currentRole, currentContent, startTime))
 			}
 
 			// Handle accumulating tool calls data (for potential recursive calls)
@@ -741,11 +1695,39 @@ func (a *OpenAIAgent) SendFunctionResult(ctx context.Context, callID, functionNa
 					a.logger.Log("[DEBUG] Agent.SendFunctionResult: Appending to existing function call arguments (nested).")
 					currentFunctionCall.Arguments += toolCall.Function.Arguments
 				}
+				progress.update(handler, currentFunctionCallID, currentFunctionCall)
 			}
 
 			// Check for FinishReason SEPARATELY (for potential recursive calls)
-			if choice.FinishReason == "tool_calls" && currentFunctionCall != nil {
+			finish := finishReasonOf(choice)
+			if finish != FinishReasonNone {
+				finished = finish
+				if text := think.finish(handler); text != "" {
+					currentContent += text
+					updates.send(handler, textUpdate(currentRole, currentContent, startTime))
+				}
+				if currentFunctionCall != nil {
+					updates.flush(handler)
+				} else {
+					updates.finish(handler, finish)
+				}
+			}
+			if finish == FinishReasonToolCalls && currentFunctionCall != nil {
 				a.logger.Log("[DEBUG] Agent.SendFunctionResult: FinishReason is 'tool_calls' (nested). Preparing function call item.")
+				calledTool = true
+
+				// Arguments are repaired, then interceptors may rewrite them before they are recorded, or reject the call
+				var rejection *Decision
+				var malformed error
+				dispatchable := a.hasTool(currentFunctionCall.Name) && a.ToolAllowed(currentFunctionCall.Name)
+				if dispatchable {
+					currentFunctionCall.Arguments, malformed = a.repairArguments(currentFunctionCallID, currentFunctionCall.Name, currentFunctionCall.Arguments)
+				}
+				if dispatchable && malformed == nil {
+					var intercepted FunctionCall
+					intercepted, rejection = hooks.intercept(FunctionCall{Name: currentFunctionCall.Name, Arguments: currentFunctionCall.Arguments, ID: currentFunctionCallID})
+					currentFunctionCall.Arguments = intercepted.Arguments
+				}
 
 				// --- BEGIN FIX: Add Assistant message for nested tool call ---
 				nestedToolCalls := []ToolCall{
@@ -760,7 +1742,9 @@ func (a *OpenAIAgent) SendFunctionResult(ctx context.Context, callID, functionNa
 				}
 				// Add this assistant message to history NOW
 				if a.history != nil {
-					a.history.AddMessage(Message{
+					a.addToHistory(Message{
+						ID:        updates.id,
+						Model:     updates.model,
 						Role:      openai.ChatMessageRoleAssistant,
 						ToolCalls: nestedToolCalls,
 					})
@@ -770,17 +1754,66 @@ func (a *OpenAIAgent) SendFunctionResult(ctx context.Context, callID, functionNa
 				}
 				// --- END FIX ---
 
+				// A call to a tool that does not exist or is not allowed, or a rejected call, is answered after the stream ends
+				if currentFunctionCall.Name == RequestStrongerModelToolName && a.config.RoutesModels() {
+					answeredCallID, answeredCallName = currentFunctionCallID, currentFunctionCall.Name
+					answeredCallError, answeredCallOK = a.escalate(currentFunctionCallID, handler).Output, true
+					currentFunctionCall = nil
+					currentFunctionCallID = ""
+					continue
+				}
+				if !a.hasTool(currentFunctionCall.Name) {
+					a.logger.Log("[WARN] Agent.SendFunctionResult: Model called unknown tool '%s' (ID: %s, nested).", currentFunctionCall.Name, currentFunctionCallID)
+					sendUnknownToolWarning(handler, currentFunctionCall.Name)
+					answeredCallID, answeredCallName = currentFunctionCallID, currentFunctionCall.Name
+					answeredCallError, answeredCallOK = a.unknownToolError(currentFunctionCall.Name), false
+					currentFunctionCall = nil
+					currentFunctionCallID = ""
+					continue
+				}
+				if !a.ToolAllowed(currentFunctionCall.Name) {
+					a.logger.Log("[WARN] Agent.SendFunctionResult: Model called disallowed tool '%s' (ID: %s, nested).", currentFunctionCall.Name, currentFunctionCallID)
+					sendDeniedToolWarning(handler, currentFunctionCall.Name)
+					answeredCallID, answeredCallName = currentFunctionCallID, currentFunctionCall.Name
+					answeredCallError, answeredCallOK = deniedToolError(currentFunctionCall.Name), false
+					currentFunctionCall = nil
+					currentFunctionCallID = ""
+					continue
+				}
+				if malformed != nil {
+					sendMalformedArgumentsWarning(handler, currentFunctionCall.Name)
+					answeredCallID, answeredCallName = currentFunctionCallID, currentFunctionCall.Name
+					answeredCallError, answeredCallOK = malformedArgumentsError(currentFunctionCall.Name, malformed), false
+					currentFunctionCall = nil
+					currentFunctionCallID = ""
+					continue
+				}
+				if rejection != nil {
+					a.logger.Log("[INFO] Agent.SendFunctionResult: Interceptor rejected call to '%s' (ID: %s, nested): %s", currentFunctionCall.Name, currentFunctionCallID, rejection.Reason)
+					sendRejectedToolWarning(handler, currentFunctionCall.Name, rejection.Reason)
+					answeredCallID, answeredCallName = currentFunctionCallID, currentFunctionCall.Name
+					answeredCallError, answeredCallOK = rejectedToolError(currentFunctionCall.Name, rejection.Reason), false
+					currentFunctionCall = nil
+					currentFunctionCallID = ""
+					continue
+				}
+
 				functionCall := &FunctionCall{ // Prepare item for handler
 					Name:      currentFunctionCall.Name,
 					Arguments: currentFunctionCall.Arguments,
 					ID:        currentFunctionCallID,
 				}
+				// Track pending call so its result is accepted
+				a.pendingMu.Lock()
+				a.pendingToolCalls[functionCall.ID] = true
+				a.pendingMu.Unlock()
 
 				a.logger.Log("[DEBUG] Agent.SendFunctionResult: Calling handler with type 'function_call' (nested). Name: %s, Args: '%s', ID: %s", functionCall.Name, functionCall.Arguments, functionCall.ID)
 				itemToSend := ResponseItem{
-					Type:             "function_call",
+					Type:             EventFunctionCall,
 					FunctionCall:     &FunctionCall{Name: functionCall.Name, Arguments: functionCall.Arguments, ID: functionCall.ID},
 					ThinkingDuration: time.Since(startTime).Milliseconds(),
+					FinishReason:     finish,
 				}
 				// Marshal and send JSON string via handler
 				jsonData, err := json.Marshal(itemToSend)
@@ -791,6 +1824,9 @@ func (a *OpenAIAgent) SendFunctionResult(ctx context.Context, callID, functionNa
 					handler(string(jsonData))
 					a.logger.Log("[DEBUG] Agent.SendFunctionResult: Sent function_call item as JSON string.")
 				}
+				if IsQuestionTool(functionCall.Name) {
+					sendUserInputRequired(handler, AskUserQuestion(functionCall.Arguments), functionCall)
+				}
 
 				// Reset for next potential call in this stream
 				currentFunctionCall = nil
@@ -800,10 +1836,21 @@ func (a *OpenAIAgent) SendFunctionResult(ctx context.Context, callID, functionNa
 	}
 
 	a.logger.Log("[DEBUG] Agent.SendFunctionResult: Follow-up stream processing finished.")
+	if text := think.finish(handler); text != "" {
+		currentContent += text
+		updates.send(handler, textUpdate(currentRole, currentContent, startTime))
+	}
+	updates.flush(handler)
+	a.recordUsage(req, reportedUsage, currentContent, handler)
+	if refusal := refusals.finish(finished); refusal != nil && !calledTool {
+		currentContent = a.reportRefusal(handler, refusal, updates, currentContent, finished)
+	}
 	// Add the final assistant message from this stream to history
 	if currentContent != "" {
 		if a.history != nil {
-			a.history.AddMessage(Message{
+			a.addToHistory(Message{
+				ID:      updates.id,
+				Model:   updates.model,
 				Role:    currentRole,
 				Content: currentContent,
 			})
@@ -811,13 +1858,52 @@ func (a *OpenAIAgent) SendFunctionResult(ctx context.Context, callID, functionNa
 		}
 	}
 
+	// Feed the error back so the model can correct itself
+	if answeredCallID != "" {
+		if a.unknownToolRounds < maxUnknownToolRounds {
+			a.unknownToolRounds++
+			a.logger.Log("[INFO] Agent.SendFunctionResult: Answering call %s with an error (round %d).", answeredCallID, a.unknownToolRounds)
+			if err := a.recordToolResult(NewToolResult(answeredCallID, answeredCallName, answeredCallError, answeredCallOK)); err != nil {
+				return err
+			}
+			// Calls surfaced in the same stream are answered first; the last result follows up
+			a.pendingMu.Lock()
+			pending := len(a.pendingToolCalls)
+			a.pendingMu.Unlock()
+			if pending == 0 {
+				return a.streamFollowUp(ctx, handler, hooks)
+			}
+		} else {
+			a.logger.Log("[WARN] Agent.SendFunctionResult: Model kept making calls that could not run; ending the turn.")
+			if err := a.addToHistory(NewToolResult(answeredCallID, answeredCallName, answeredCallError, answeredCallOK).Message()); err != nil {
+				a.logger.Log("[WARN] Agent.SendFunctionResult: Error result for CallID %s not added: %v", answeredCallID, err)
+			}
+		}
+	}
+
+	// A response with nothing in it is requested again or reported, never silently dropped
+	if isEmptyResponse(currentContent, calledTool, finished) {
+		if a.retryEmptyResponse(handler, finished) {
+			return a.streamFollowUp(ctx, handler, hooks)
+		}
+	} else {
+		a.resetEmptyRetry()
+	}
+	if question := TrailingQuestion(currentContent); question != "" && !calledTool {
+		a.logger.Log("[INFO] Agent.SendFunctionResult: Reply ends with a question; user input required.")
+		sendUserInputRequired(handler, question, nil)
+	}
+
 	// --- FIX: Signal completion of the follow-up stream ---
-	// If we finished processing the stream and the last action wasn't requesting another tool call,
-	// signal completion back to the App.
-	if currentFunctionCall == nil { // If we are not expecting another tool call
+	// Only a follow-up that made no tool call, and left none waiting for a
+	// result, ends the turn; signal completion back to the App.
+	a.pendingMu.Lock()
+	pending := len(a.pendingToolCalls)
+	a.pendingMu.Unlock()
+	if !calledTool && pending == 0 {
 		a.logger.Log("[DEBUG] Agent.SendFunctionResult: Follow-up stream finished without further tool calls. Sending completion signal.")
 		// Use the handler to send the new completion message
-		completionItem := ResponseItem{Type: "followup_complete"} // Use a unique type
+		completionItem := ResponseItem{Type: EventFollowupComplete}
 		jsonData, err := json.Marshal(completionItem)
 		if err != nil {
 			a.logger.Log("[ERROR] Agent.SendFunctionResult: Failed to marshal followup_complete item: %v", err)
@@ -835,9 +1921,12 @@ func (a *OpenAIAgent) SendFunctionResult(ctx context.Context, callID, functionNa
 func convertToolDefinitions(tools []ToolDefinition) []openai.Tool {
 	var result []openai.Tool
 	for _, tool := range tools {
-		// Convert FunctionDef to openai.FunctionDefinition
-		bytes, _ := json.Marshal(tool.Function.Parameters)
-		var params json.RawMessage = bytes
+		// Convert FunctionDef to openai.FunctionDefinition.
+		// Parameters are encoded up front so OrderedMap properties keep their order.
+		params, err := encodeToolParameters(tool.Function.Parameters)
+		if err != nil {
+			continue
+		}
 
 		result = append(result, openai.Tool{
 			Type: openai.ToolTypeFunction,
@@ -851,6 +1940,266 @@ func convertToolDefinitions(tools []ToolDefinition) []openai.Tool {
 	return result
 }
 
+// mutatingTools are tools that modify files and are withheld in read-only mode
+var mutatingTools = map[string]bool{
+	"remember":     true,
+	"write_file":   true,
+	"append_file":  true,
+	"patch_file":   true,
+	"edit_symbol":  true,
+	"apply_edits":  true,
+	"begin_write":  true,
+	"append_chunk": true,
+	"commit_write": true,
+	"delete_file":  true,
+	"move_file":    true,
+}
+
+// navTargetProperties describe a symbol given by name or by position
+var navTargetProperties = OrderedMap{
+	{"symbol", map[string]interface{}{
+		"type":        "string",
+		"description": "Symbol name such as ConversationHistory or ConversationHistory.AddMessage, or a position as file:line:column",
+	}},
+	{"path", map[string]interface{}{
+		"type":        "string",
+		"description": "File containing the symbol, instead of a symbol name",
+	}},
+	{"line", map[string]interface{}{
+		"type":        "integer",
+		"description": "1-based line of the symbol in path",
+	}},
+	{"column", map[string]interface{}{
+		"type":        "integer",
+		"description": "1-based column of the symbol in path (default: first non-blank character)",
+	}},
+}
+
+// codeNavTools are the language server navigation tools, offered when a server is installed
+var codeNavTools = []ToolDefinition{
+	{
+		Type: "function",
+		Function: FunctionDef{
+			Name:        "find_definition",
+			Description: "Find where a symbol is defined using the language server. Returns file:line with a code snippet.",
+			Parameters: map[string]interface{}{
+				"type":       "object",
+				"properties": navTargetProperties,
+			},
+		},
+	},
+	{
+		Type: "function",
+		Function: FunctionDef{
+			Name:        "find_references",
+			Description: "Find every use of a symbol using the language server, e.g. the callers of a function. Returns file:line with the referencing line.",
+			Parameters: map[string]interface{}{
+				"type":       "object",
+				"properties": navTargetProperties,
+			},
+		},
+	},
+	{
+		Type: "function",
+		Function: FunctionDef{
+			Name:        "document_symbols",
+			Description: "Outline the types, functions and other symbols declared in a file, with their line numbers",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": OrderedMap{
+					{"path", map[string]interface{}{
+						"type":        "string",
+						"description": "The file to outline",
+					}},
+				},
+				"required": []string{"path"},
+			},
+		},
+	},
+	{
+		Type: "function",
+		Function: FunctionDef{
+			Name:        "hover",
+			Description: "Show the type signature and documentation of the symbol at a position in a file",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": OrderedMap{
+					{"path", map[string]interface{}{
+						"type":        "string",
+						"description": "The file containing the symbol",
+					}},
+					{"line", map[string]interface{}{
+						"type":        "integer",
+						"description": "1-based line of the symbol",
+					}},
+					{"column", map[string]interface{}{
+						"type":        "integer",
+						"description": "1-based column of the symbol (default: first non-blank character)",
+					}},
+				},
+				"required": []string{"path", "line"},
+			},
+		},
+	},
+}
+
+// semanticSearchTool queries the embeddings index built by codex index
+var semanticSearchTool = ToolDefinition{
+	Type: "function",
+	Function: FunctionDef{
+		Name:        "semantic_search",
+		Description: "Search the workspace code by meaning rather than exact text, e.g. \"where are retries handled\". Returns the best matching chunks with paths and line ranges. Falls back to a keyword search when no index exists.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": OrderedMap{
+				{"query", map[string]interface{}{
+					"type":        "string",
+					"description": "What the code you are looking for does, in plain words",
+				}},
+				{"top_k", map[string]interface{}{
+					"type":        "integer",
+					"description": "How many chunks to return (default 5, at most 20)",
+				}},
+			},
+			"required": []string{"query"},
+		},
+	},
+}
+
+// memoryTools are the project memory tools, offered unless memory is disabled
+var memoryTools = []ToolDefinition{
+	{
+		Type: "function",
+		Function: FunctionDef{
+			Name:        "remember",
+			Description: "Remember a project-specific fact across sessions, e.g. how to run the tests or code that must not be touched",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": OrderedMap{
+					{"key", map[string]interface{}{
+						"type":        "string",
+						"description": "Short name for the fact; an existing key is overwritten",
+					}},
+					{"value", map[string]interface{}{
+						"type":        "string",
+						"description": "The fact to remember",
+					}},
+				},
+				"required": []string{"key", "value"},
+			},
+		},
+	},
+	{
+		Type: "function",
+		Function: FunctionDef{
+			Name:        "recall",
+			Description: "Search remembered project facts by keyword",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": OrderedMap{
+					{"query", map[string]interface{}{
+						"type":        "string",
+						"description": "Keywords to match against remembered keys and values",
+					}},
+				},
+				"required": []string{"query"},
+			},
+		},
+	},
+}
+
+// buildSystemPrompt composes the system prompt from instructions, or
+// defaultPrompt when there are none, followed by the project memory and facts
+func buildSystemPrompt(cfg *config.Config, instructions, defaultPrompt string) string {
+	prompt := defaultPrompt
+	if instructions != "" {
+		prompt = instructions
+	}
+
+	// Inject project memory, most recently used entries first
+	if !cfg.DisableMemory {
+		if section := loadMemorySection(cfg); section != "" {
+			prompt += "\n\n" + section
+		}
+	}
+
+	// Tell the model how this project is built and tested
+	if !cfg.DisableProjectFacts && cfg.ToolDir() != "" {
+		if section := loadProjectFactsSection(cfg); section != "" {
+			prompt += "\n\n" + section
+		}
+	}
+
+	if cfg.ToolOutputGuard != config.InjectionGuardOff {
+		prompt += "\n\n" + toolOutputSection
+	}
+	return prompt
+}
+
+// loadMemorySection renders the project memory for the system prompt
+func loadMemorySection(cfg *config.Config) string {
+	store, err := memory.OpenProject(cfg)
+	if err != nil {
+		return ""
+	}
+	limit := cfg.MemoryPromptBytes
+	if limit == 0 {
+		limit = config.DefaultMemoryPromptBytes
+	}
+	return store.PromptSection(limit)
+}
+
+// loadProjectFactsSection renders the detected project facts for the system
+// prompt, with any facts stated in codex.md taking precedence
+func loadProjectFactsSection(cfg *config.Config) string {
+	var docPaths []string
+	if !cfg.DisableProjectDoc {
+		docPaths = append(docPaths, filepath.Join(cfg.ToolDir(), "codex.md"))
+		if cfg.ProjectDocPath != "" && cfg.ProjectDocPath != docPaths[0] {
+			docPaths = append(docPaths, cfg.ProjectDocPath)
+		}
+	}
+	return projectfacts.Load(cfg.ToolDir(), docPaths...).PromptSection()
+}
+
+// IsMutatingTool reports whether a tool modifies files
+func IsMutatingTool(name string) bool {
+	return mutatingTools[name]
+}
+
+// shellResultDescription tells the model the shape of the shell tool's result
+const shellResultDescription = "The result is JSON: {success, output (stdout), stderr, exit_code, duration_ms, cwd, truncated}. " +
+	"A nonzero exit_code means the command ran and failed. A long output or stderr is cut in the middle on its own, and listed in truncated. " +
+	"A command that could not run to completion (not found, timed out, killed) fails the call, with the reason in error and what it printed kept."
+
+// readOnlyTools removes mutating tools and tells the model shell commands must not write
+func readOnlyTools(tools []ToolDefinition) []ToolDefinition {
+	var result []ToolDefinition
+	for _, tool := range tools {
+		if IsMutatingTool(tool.Function.Name) {
+			continue
+		}
+		if tool.Function.Name == "shell" {
+			tool.Function.Description = "Execute a read-only shell command. This session is read-only: commands must not create, modify, move or delete files (no redirects, in-place edits, git commits or package installs). Mutating commands are refused. " + shellResultDescription
+		}
+		result = append(result, tool)
+	}
+	return result
+}
+
+// encodeToolParameters marshals a tool's parameter schema to raw JSON.
+// Pre-encoded json.RawMessage values are passed through unchanged.
+func encodeToolParameters(params interface{}) (json.RawMessage, error) {
+	if raw, ok := params.(json.RawMessage); ok {
+		return raw, nil
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode tool parameters: %w", err)
+	}
+	return data, nil
+}
+
 // FileChange represents a change to a file
 type FileChange struct {
 	Filename    string
@@ -863,12 +2212,12 @@ func (a *OpenAIAgent) SendFileChanges(ctx context.Context, changes []FileChange)
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	// Record the changes as context; tool-call messages would need results
 	for _, change := range changes {
-		a.history.AddToolMessage("edit_file", map[string]interface{}{
-			"target_file":  change.Filename,
-			"instructions": change.Description,
-			"code_edit":    change.Content,
-		}, "")
+		content := fmt.Sprintf("File changed: %s\n%s\n\n%s", change.Filename, change.Description, change.Content)
+		if err := a.addToHistory(Message{Role: "system", Content: content}); err != nil && messageRejected(err) {
+			return fmt.Errorf("failed to record change to %s: %w", change.Filename, err)
+		}
 	}
 
 	// Save history to disk
@@ -886,7 +2235,7 @@ func (a *OpenAIAgent) AddSystemMessage(content string) error {
 
 	// If we have a history instance, add the message to it
 	if a.history != nil {
-		a.history.AddMessage(Message{
+		a.addToHistory(Message{
 			Role:    "system",
 			Content: content,
 		})
@@ -956,6 +2305,12 @@ func (a *OpenAIAgent) FinalizeInteraction() {
 	}
 }
 
+// messageRejected reports whether an error from adding to the history means the
+// message was refused, as opposed to accepted with a flagged inconsistency
+func messageRejected(err error) bool {
+	return errors.Is(err, ErrOrphanToolResult) || errors.Is(err, ErrInvalidToolCall)
+}
+
 // mustMarshal marshals v to JSON, panicking on error.
 func mustMarshal(v interface{}) []byte {
 	data, err := json.Marshal(v)
@@ -0,0 +1,341 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// fakeOpenAI is a chat completions endpoint that streams canned text replies
+// and records the requests it receives
+type fakeOpenAI struct {
+	mu       sync.Mutex
+	replies  []string
+	requests []openai.ChatCompletionRequest
+	bodies   [][]byte      // The requests as they were encoded
+	auth     []string      // The Authorization header of every request
+	usage    *openai.Usage // Reported at the end of every stream, when set
+}
+
+// newFakeOpenAIAgent starts a fake endpoint and an agent pointed at it
+func newFakeOpenAIAgent(t *testing.T, replies ...string) (*OpenAIAgent, *fakeOpenAI) {
+	t.Helper()
+	fake := &fakeOpenAI{replies: replies}
+	ts := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(ts.Close)
+
+	a, err := NewOpenAIAgent(&config.Config{APIKey: "test", Model: "gpt-4o", BaseURL: ts.URL}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	return a, fake
+}
+
+func (f *fakeOpenAI) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var req openai.ChatCompletionRequest
+	json.Unmarshal(body, &req)
+
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	f.bodies = append(f.bodies, body)
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	reply := "ok"
+	if len(f.replies) > 0 {
+		reply = f.replies[0]
+		f.replies = f.replies[1:]
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	finishReason := "stop"
+	if strings.HasPrefix(reply, truncatedReplyPrefix) {
+		finishReason = "length"
+		reply = strings.TrimPrefix(reply, truncatedReplyPrefix)
+	}
+	contentKey := "content"
+	if strings.HasPrefix(reply, filteredReplyPrefix) {
+		finishReason = "content_filter"
+		reply = strings.TrimPrefix(reply, filteredReplyPrefix)
+	}
+	if strings.HasPrefix(reply, refusalReplyPrefix) {
+		contentKey = "refusal"
+		reply = strings.TrimPrefix(reply, refusalReplyPrefix)
+	}
+	if strings.HasPrefix(reply, toolCallReplyPrefix) {
+		if finishReason == "stop" {
+			finishReason = "tool_calls"
+		}
+		name, args, _ := strings.Cut(strings.TrimPrefix(reply, toolCallReplyPrefix), " ")
+		f.mu.Lock()
+		id := fmt.Sprintf("call_%d", len(f.requests))
+		f.mu.Unlock()
+		call := map[string]interface{}{
+			"id":      "chatcmpl-test",
+			"object":  "chat.completion.chunk",
+			"model":   req.Model,
+			"choices": []map[string]interface{}{{"index": 0, "delta": map[string]interface{}{"role": "assistant", "tool_calls": []map[string]interface{}{{"index": 0, "id": id, "type": "function", "function": map[string]string{"name": name, "arguments": args}}}}}},
+		}
+		data, _ := json.Marshal(call)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		finish := map[string]interface{}{
+			"id":      "chatcmpl-test",
+			"object":  "chat.completion.chunk",
+			"model":   req.Model,
+			"choices": []map[string]interface{}{{"index": 0, "delta": map[string]string{}, "finish_reason": finishReason}},
+		}
+		data, _ = json.Marshal(finish)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		f.writeUsage(w)
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		return
+	}
+	chunk := map[string]interface{}{
+		"id":      "chatcmpl-test",
+		"object":  "chat.completion.chunk",
+		"model":   req.Model,
+		"choices": []map[string]interface{}{{"index": 0, "delta": map[string]string{"role": "assistant", contentKey: reply}}},
+	}
+	data, _ := json.Marshal(chunk)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	stop := map[string]interface{}{
+		"id":      "chatcmpl-test",
+		"object":  "chat.completion.chunk",
+		"model":   req.Model,
+		"choices": []map[string]interface{}{{"index": 0, "delta": map[string]string{}, "finish_reason": finishReason}},
+	}
+	data, _ = json.Marshal(stop)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	f.writeUsage(w)
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// writeUsage sends the final usage chunk, as OpenAI does with include_usage
+func (f *fakeOpenAI) writeUsage(w io.Writer) {
+	f.mu.Lock()
+	usage := f.usage
+	f.mu.Unlock()
+	if usage == nil {
+		return
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"id":      "chatcmpl-test",
+		"object":  "chat.completion.chunk",
+		"choices": []interface{}{},
+		"usage":   usage,
+	})
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+// toolCallReplyPrefix marks a fake reply that calls a tool instead of answering
+const toolCallReplyPrefix = "tool_call:"
+
+// toolCallReply makes the fake endpoint call tool name with args
+func toolCallReply(name, args string) string {
+	return toolCallReplyPrefix + name + " " + args
+}
+
+// truncatedReplyPrefix marks a fake reply that stops at the token limit
+const truncatedReplyPrefix = "length:"
+
+// truncatedReply makes the fake endpoint send reply, which may be a
+// toolCallReply, and finish with "length"
+func truncatedReply(reply string) string {
+	return truncatedReplyPrefix + reply
+}
+
+// filteredReplyPrefix marks a fake reply the content filter cuts off
+const filteredReplyPrefix = "content_filter:"
+
+// filteredReply makes the fake endpoint send reply and finish with "content_filter"
+func filteredReply(reply string) string {
+	return filteredReplyPrefix + reply
+}
+
+// refusalReplyPrefix marks a fake reply the model refuses to give
+const refusalReplyPrefix = "refusal:"
+
+// refusalReply makes the fake endpoint send text as a refusal instead of content
+func refusalReply(text string) string {
+	return refusalReplyPrefix + text
+}
+
+// lastRequest returns the most recent request received
+func (f *fakeOpenAI) lastRequest() openai.ChatCompletionRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[len(f.requests)-1]
+}
+
+func TestEditAndRegenerate(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "First answer", "Regenerated answer")
+	handler := func(string) {}
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Original question"}}, handler); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	userIndex := len(a.history.GetMessages()) - 2
+
+	// A tool call issued after the edited message must not survive the edit
+	a.history.AddToolMessage("shell", map[string]interface{}{"command": "ls"}, "call_dangling")
+	a.pendingToolCalls["call_dangling"] = true
+
+	if _, err := a.EditAndRegenerate(context.Background(), userIndex, "Edited question", handler); err != nil {
+		t.Fatalf("EditAndRegenerate failed: %v", err)
+	}
+
+	messages := a.history.GetMessages()
+	if len(messages) != userIndex+2 {
+		t.Fatalf("Expected %d messages, got %d", userIndex+2, len(messages))
+	}
+	if messages[userIndex].Content != "Edited question" {
+		t.Errorf("Expected edited content, got %q", messages[userIndex].Content)
+	}
+	if messages[userIndex+1].Content != "Regenerated answer" {
+		t.Errorf("Expected regenerated answer, got %q", messages[userIndex+1].Content)
+	}
+	if a.IsToolCallPending("call_dangling") {
+		t.Errorf("Expected the truncated tool call to be dropped")
+	}
+	for _, msg := range fake.lastRequest().Messages {
+		if msg.Content == "Original question" || msg.Content == "First answer" || msg.ToolCallID == "call_dangling" || len(msg.ToolCalls) > 0 {
+			t.Errorf("Expected truncated messages to be absent from the request, found %+v", msg)
+		}
+	}
+
+	if _, err := a.EditAndRegenerate(context.Background(), userIndex+1, "nope", handler); err == nil {
+		t.Errorf("Expected an error when editing an assistant message")
+	}
+}
+
+func TestCloseIsIdempotent(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t)
+	dir := t.TempDir()
+	a.historyOpts.HistoryPath = dir
+	a.history.MarkInterrupted()
+
+	for i := 0; i < 2; i++ {
+		if err := a.Close(); err != nil {
+			t.Fatalf("Close %d failed: %v", i+1, err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, a.history.CurrentSession+".json"))
+	if err != nil {
+		t.Fatalf("Expected history to be saved on Close: %v", err)
+	}
+	var saved ConversationHistory
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("Failed to parse saved history: %v", err)
+	}
+	if !saved.Interrupted {
+		t.Errorf("Expected the interrupted marker to be saved")
+	}
+}
+
+func TestCloseSavesToFallbackWhenSaveFails(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocked := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(blocked, nil, 0644); err != nil { // A file where the directory should be
+		t.Fatal(err)
+	}
+	a.historyOpts.HistoryPath = blocked
+	a.history.AddMessage(Message{Role: "user", Content: "Do not lose me"})
+
+	err = a.Close()
+	if !errors.Is(err, ErrCloseSaveFailed) || errors.Is(err, ErrCloseCancelFailed) {
+		t.Fatalf("Expected a save failure, got %v", err)
+	}
+	fallback := filepath.Join(cacheDir, "codex-go", "history-fallback", a.history.CurrentSession+".json")
+	if !strings.Contains(err.Error(), fallback) {
+		t.Errorf("Expected the error to name the fallback %s, got %v", fallback, err)
+	}
+	data, readErr := os.ReadFile(fallback)
+	if readErr != nil || !strings.Contains(string(data), "Do not lose me") {
+		t.Errorf("Expected the history in the fallback file, got %q (%v)", data, readErr)
+	}
+	// Other users must not read the conversation
+	if info, err := os.Stat(fallback); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the fallback file to be private, got %v (%v)", info.Mode(), err)
+	}
+	if info, err := os.Stat(filepath.Dir(fallback)); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("Expected the fallback directory to be private, got %v (%v)", info.Mode(), err)
+	}
+}
+
+func TestUnknownToolCallIsAnsweredWithError(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, toolCallReply("frobnicate_widgets", `{"count":3}`), "Sorry, let me use a real tool.")
+
+	var items []ResponseItem
+	handler := func(itemJSON string) {
+		var item ResponseItem
+		if err := json.Unmarshal([]byte(itemJSON), &item); err == nil {
+			items = append(items, item)
+		}
+	}
+
+	endedWithTools, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Do the thing"}}, handler)
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if endedWithTools {
+		t.Errorf("Expected the turn to end without pending tool calls")
+	}
+
+	warnings := 0
+	for _, item := range items {
+		switch item.Type {
+		case "function_call":
+			t.Errorf("Expected no function_call item for an unknown tool, got %+v", item.FunctionCall)
+		case "warning":
+			warnings++
+			if item.Message == nil || !strings.Contains(item.Message.Content, "frobnicate_widgets") {
+				t.Errorf("Expected the warning to name the unknown tool, got %+v", item.Message)
+			}
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("Expected 1 warning item, got %d", warnings)
+	}
+
+	// The retry request carries the error so the model can self-correct
+	var toolResult *openai.ChatCompletionMessage
+	for _, msg := range fake.lastRequest().Messages {
+		if msg.Role == openai.ChatMessageRoleTool {
+			m := msg
+			toolResult = &m
+		}
+	}
+	if toolResult == nil {
+		t.Fatalf("Expected the follow-up request to include a tool result")
+	}
+	if !strings.Contains(toolResult.Content, "no such tool") || !strings.Contains(toolResult.Content, "read_file") {
+		t.Errorf("Expected a no such tool error listing the real tools, got %s", toolResult.Content)
+	}
+
+	messages := a.history.GetMessages()
+	if last := messages[len(messages)-1]; last.Content != "Sorry, let me use a real tool." {
+		t.Errorf("Expected the corrected answer last in history, got %+v", last)
+	}
+	if a.IsToolCallPending(toolResult.ToolCallID) {
+		t.Errorf("Expected the unknown call not to be pending")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package agent
+
+// processAlive cannot probe processes on this platform; journals are always
+// treated as abandoned
+func processAlive(pid int) bool {
+	return false
+}
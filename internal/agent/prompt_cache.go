@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+// cacheControlTransport adds prompt cache breakpoints to chat completion
+// requests on their way out. go-openai has no field for cache_control, so the
+// encoded request is rewritten instead.
+type cacheControlTransport struct {
+	base http.RoundTripper
+	mode config.CacheControl
+}
+
+func (t *cacheControlTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Method != http.MethodPost || !strings.HasSuffix(req.URL.Path, "/chat/completions") {
+		return t.base.RoundTrip(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if marked, ok := addCacheControl(body, t.mode); ok {
+		body = marked
+	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	req.ContentLength = int64(len(body))
+	return t.base.RoundTrip(req)
+}
+
+// addCacheControl marks the end of the leading system messages and the oldest
+// retained message of an encoded request as cache breakpoints, so a provider
+// that only caches marked prefixes reuses the system prompt and the start of
+// the history. It reports false when the request is left as it is.
+func addCacheControl(body []byte, mode config.CacheControl) ([]byte, bool) {
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, false
+	}
+	if mode != config.CacheControlAlways {
+		var model string
+		json.Unmarshal(req["model"], &model)
+		if !strings.Contains(strings.ToLower(model), "claude") {
+			return nil, false
+		}
+	}
+	var messages []map[string]json.RawMessage
+	if err := json.Unmarshal(req["messages"], &messages); err != nil {
+		return nil, false
+	}
+
+	marked := false
+	for _, i := range cacheBreakpoints(messages) {
+		marked = markCacheBreakpoint(messages[i]) || marked
+	}
+	if !marked {
+		return nil, false
+	}
+	encoded, err := json.Marshal(messages)
+	if err != nil {
+		return nil, false
+	}
+	req["messages"] = encoded
+	result, err := json.Marshal(req)
+	if err != nil {
+		return nil, false
+	}
+	return result, true
+}
+
+// cacheBreakpoints returns the indexes of the last leading system message and
+// of the first message after them
+func cacheBreakpoints(messages []map[string]json.RawMessage) []int {
+	system := 0
+	for system < len(messages) {
+		var role string
+		json.Unmarshal(messages[system]["role"], &role)
+		if role != "system" {
+			break
+		}
+		system++
+	}
+	var indexes []int
+	if system > 0 {
+		indexes = append(indexes, system-1)
+	}
+	if system < len(messages) {
+		indexes = append(indexes, system)
+	}
+	return indexes
+}
+
+// markCacheBreakpoint turns the text content of msg into a content part
+// carrying an ephemeral cache_control marker. Messages without text content
+// are left unmarked.
+func markCacheBreakpoint(msg map[string]json.RawMessage) bool {
+	var text string
+	if err := json.Unmarshal(msg["content"], &text); err != nil || text == "" {
+		return false
+	}
+	parts, err := json.Marshal([]map[string]interface{}{{
+		"type":          "text",
+		"text":          text,
+		"cache_control": map[string]string{"type": "ephemeral"},
+	}})
+	if err != nil {
+		return false
+	}
+	msg["content"] = parts
+	return true
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// encodedRequest is the part of an encoded request the prompt cache keys on
+type encodedRequest struct {
+	Messages []json.RawMessage `json:"messages"`
+	Tools    json.RawMessage   `json:"tools"`
+}
+
+func TestRequestPrefixStableAcrossTurns(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "first", "second")
+	ctx := context.Background()
+	a.config.SystemReminderEvery = 1
+	a.GetHistory().SetSystemPrompt("BASE")
+	a.AddSystemPromptSource(constSource("context", 10, "CONTEXT"))
+	if err := a.SetWorkingDir("/work/service"); err != nil {
+		t.Fatalf("SetWorkingDir failed: %v", err)
+	}
+
+	for _, content := range []string{"hello", "again"} {
+		if _, err := a.SendMessage(ctx, []Message{{Role: "user", Content: content}}, func(string) {}); err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+	}
+	var first, second encodedRequest
+	json.Unmarshal(fake.bodies[0], &first)
+	json.Unmarshal(fake.bodies[1], &second)
+
+	if len(second.Messages) <= len(first.Messages) {
+		t.Fatalf("Expected the second request to extend the first, got %d and %d messages", len(first.Messages), len(second.Messages))
+	}
+	for i, msg := range first.Messages {
+		if !bytes.Equal(msg, second.Messages[i]) {
+			t.Errorf("Expected message %d to be sent unchanged, got %s then %s", i, msg, second.Messages[i])
+		}
+	}
+	if len(first.Tools) == 0 || !bytes.Equal(first.Tools, second.Tools) {
+		t.Errorf("Expected the tools to be sent unchanged")
+	}
+}
+
+func TestCachedPromptTokensCounted(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "first", "second")
+	fake.usage = &openai.Usage{PromptTokens: 1000, CompletionTokens: 10, PromptTokensDetails: &openai.PromptTokensDetails{CachedTokens: 800}}
+
+	for _, content := range []string{"hello", "again"} {
+		if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: content}}, func(string) {}); err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+	}
+	if usage := a.Usage(); usage.PromptTokens != 2000 || usage.CachedPromptTokens != 1600 {
+		t.Errorf("Expected 1600 of 2000 prompt tokens cached, got %+v", usage)
+	}
+}
+
+func TestCacheControlBreakpoints(t *testing.T) {
+	fake := &fakeOpenAI{replies: []string{"ok"}}
+	ts := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(ts.Close)
+	a, err := NewOpenAIAgent(&config.Config{APIKey: "test", Model: "claude-sonnet-4", BaseURL: ts.URL}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	a.GetHistory().SetSystemPrompt("BASE")
+	a.GetHistory().AddMessages([]Message{{Role: "user", Content: "oldest"}, {Role: "assistant", Content: "reply"}})
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "newest"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	var sent encodedRequest
+	json.Unmarshal(fake.bodies[0], &sent)
+	marked := 0
+	for _, msg := range sent.Messages {
+		if strings.Contains(string(msg), `"cache_control":{"type":"ephemeral"}`) {
+			marked++
+		}
+	}
+	if marked != 2 || !strings.Contains(string(sent.Messages[0]), "cache_control") || !strings.Contains(string(sent.Messages[1]), "cache_control") {
+		t.Errorf("Expected breakpoints on the system prompt and the oldest message, got %s", sent.Messages)
+	}
+	if req := fake.lastRequest(); req.Messages[1].MultiContent[0].Text != "oldest" {
+		t.Errorf("Expected the marked message to keep its text, got %+v", req.Messages[1])
+	}
+
+	// Other models are left alone unless breakpoints are always added
+	body := []byte(`{"model":"gpt-4o","messages":[{"role":"system","content":"BASE"},{"role":"user","content":"hi"}]}`)
+	if _, ok := addCacheControl(body, config.CacheControlAuto); ok {
+		t.Errorf("Expected no breakpoints for gpt-4o under auto")
+	}
+	if marked, ok := addCacheControl(body, config.CacheControlAlways); !ok || strings.Count(string(marked), "cache_control") != 2 {
+		t.Errorf("Expected two breakpoints under always, got %s", marked)
+	}
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+// AskUserToolName is the tool the model calls to ask the user a question.
+// Hosts answer it with the user's reply as the tool result.
+const AskUserToolName = "ask_user"
+
+// ErrUserInputRequired is returned by unattended runs that stopped because
+// the model asked a question nobody was there to answer
+var ErrUserInputRequired = errors.New("the assistant asked a question that needs an answer")
+
+// askUserTool lets the model ask for information instead of ending its turn
+// with a question nobody may see
+var askUserTool = ToolDefinition{
+	Type: "function",
+	Function: FunctionDef{
+		Name:        AskUserToolName,
+		Description: "Ask the user a question and wait for the answer. Use this instead of ending your reply with a question whenever you need a decision or information to continue; the answer is returned as the result.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": OrderedMap{
+				{"question", map[string]interface{}{
+					"type":        "string",
+					"description": "The question, with any options to choose from",
+				}},
+			},
+			"required": []string{"question"},
+		},
+	},
+}
+
+// AskUserQuestion returns the question of an ask_user call
+func AskUserQuestion(arguments string) string {
+	var params struct {
+		Question string `json:"question"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &params); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(params.Question)
+}
+
+// TrailingQuestion returns the last paragraph of a reply when it asks
+// something, i.e. ends with a question mark outside code blocks, or ""
+func TrailingQuestion(reply string) string {
+	var paragraph []string
+	inCode, fresh := false, false
+	for _, line := range strings.Split(reply, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			inCode = !inCode
+			paragraph, fresh = nil, false
+		case inCode:
+		case trimmed == "":
+			fresh = true
+		default:
+			if fresh {
+				paragraph, fresh = nil, false
+			}
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	if len(paragraph) == 0 || !strings.HasSuffix(strings.TrimRight(paragraph[len(paragraph)-1], "*_` )"), "?") {
+		return ""
+	}
+	return strings.Join(paragraph, "\n")
+}
+
+// AnswerQuestion returns the answer an unattended run gives to question: the
+// value of the longest QuestionAnswers key found in it, ignoring case, or
+// else the default instruction. ok is false when there is no configured
+// answer and the question policy is to fail.
+func AnswerQuestion(cfg *config.Config, question string) (answer string, ok bool) {
+	keys := make([]string, 0, len(cfg.QuestionAnswers))
+	for key := range cfg.QuestionAnswers {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	lower := strings.ToLower(question)
+	for _, key := range keys {
+		if key != "" && strings.Contains(lower, strings.ToLower(key)) {
+			return cfg.QuestionAnswers[key], true
+		}
+	}
+
+	if cfg.QuestionPolicy == config.QuestionFail {
+		return "", false
+	}
+	if cfg.QuestionDefaultAnswer != "" {
+		return cfg.QuestionDefaultAnswer, true
+	}
+	return config.DefaultQuestionAnswer, true
+}
+
+// sendUserInputRequired emits a "user_input_required" item for a question
+// the turn is waiting on. call is the ask_user call to answer with a tool
+// result, or nil when the reply itself ended with the question.
+func sendUserInputRequired(handler ResponseHandler, question string, call *FunctionCall) {
+	item := ResponseItem{
+		Type:         EventUserInputRequired,
+		Message:      &Message{Role: "assistant", Content: question},
+		FunctionCall: call,
+	}
+	if data, err := json.Marshal(item); err == nil {
+		handler(string(data))
+	}
+}
@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+func TestTrailingQuestion(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  string
+	}{
+		{"I found two configs.\n\nWhich one should I update?", "Which one should I update?"},
+		{"Done. Should I also\nrun the tests?", "Done. Should I also\nrun the tests?"},
+		{"I updated the file.", ""},
+		{"Why does this fail?\n\nBecause the path was wrong. Fixed.", ""},
+		{"Here is the check:\n```go\nok := x == y?\n```", ""},
+		{"**Shall I continue?**", "**Shall I continue?**"},
+	}
+	for _, tt := range tests {
+		if got := TrailingQuestion(tt.reply); got != tt.want {
+			t.Errorf("TrailingQuestion(%q): expected %q, got %q", tt.reply, tt.want, got)
+		}
+	}
+}
+
+func TestAnswerQuestion(t *testing.T) {
+	cfg := &config.Config{QuestionAnswers: map[string]string{
+		"database":        "Use SQLite.",
+		"which database":  "Use Postgres.",
+		"unrelated topic": "No.",
+	}}
+
+	if answer, ok := AnswerQuestion(cfg, "Which DATABASE should I use?"); !ok || answer != "Use Postgres." {
+		t.Errorf("Expected the longest matching key to win, got %q, %v", answer, ok)
+	}
+	if answer, ok := AnswerQuestion(cfg, "Should I add tests?"); !ok || answer != config.DefaultQuestionAnswer {
+		t.Errorf("Expected the default answer, got %q, %v", answer, ok)
+	}
+	cfg.QuestionDefaultAnswer = "Use your judgement."
+	if answer, _ := AnswerQuestion(cfg, "Should I add tests?"); answer != "Use your judgement." {
+		t.Errorf("Expected the configured default answer, got %q", answer)
+	}
+	cfg.QuestionPolicy = config.QuestionFail
+	if _, ok := AnswerQuestion(cfg, "Should I add tests?"); ok {
+		t.Error("Expected no answer under the fail policy")
+	}
+	if answer, ok := AnswerQuestion(cfg, "Is the database local?"); !ok || answer != "Use SQLite." {
+		t.Errorf("Expected configured answers under the fail policy, got %q, %v", answer, ok)
+	}
+}
+
+func TestReplyEndingWithQuestionReported(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, "I found two configs.\n\nWhich one should I update?")
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "fix the config"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	last := items[len(items)-1]
+	if last.Type != "user_input_required" || last.FunctionCall != nil {
+		t.Fatalf("Expected a user_input_required item without a call last, got %+v", items)
+	}
+	if last.Message == nil || last.Message.Content != "Which one should I update?" {
+		t.Errorf("Expected the question in the item, got %+v", last.Message)
+	}
+}
+
+func TestAskUserCallReported(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, toolCallReply(AskUserToolName, `{"question":"Which DB?"}`))
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "set up storage"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	if n := countItems(items, "user_input_required"); n != 1 {
+		t.Fatalf("Expected 1 user_input_required item, got %d in %+v", n, items)
+	}
+	for _, item := range items {
+		if item.Type == "user_input_required" {
+			if item.FunctionCall == nil || item.FunctionCall.Name != AskUserToolName || item.Message.Content != "Which DB?" {
+				t.Errorf("Expected the ask_user call and its question, got %+v", item)
+			}
+		}
+	}
+
+	found := false
+	for _, tool := range fake.lastRequest().Tools {
+		if tool.Function != nil && tool.Function.Name == AskUserToolName {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected the ask_user tool to be offered")
+	}
+}
@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// rateLimiter paces outgoing requests so bursty tool loops stay under the
+// provider's per-minute limits. It holds a token bucket for requests and one
+// for tokens, each refilling its per-minute amount over a minute.
+type rateLimiter struct {
+	mu       sync.Mutex
+	requests bucket
+	tokens   bucket
+	now      func() time.Time
+}
+
+// bucket is a token bucket; a zero capacity means no limit
+type bucket struct {
+	capacity float64
+	level    float64 // May go below zero when usage turns out higher than estimated
+	last     time.Time
+}
+
+// newRateLimiter returns the limiter configured by cfg, or nil without limits
+func newRateLimiter(cfg *config.Config) *rateLimiter {
+	if cfg.RateLimitRPM <= 0 && cfg.RateLimitTPM <= 0 {
+		return nil
+	}
+	l := &rateLimiter{now: time.Now}
+	start := l.now()
+	l.requests = bucket{capacity: float64(cfg.RateLimitRPM), level: float64(cfg.RateLimitRPM), last: start}
+	l.tokens = bucket{capacity: float64(cfg.RateLimitTPM), level: float64(cfg.RateLimitTPM), last: start}
+	return l
+}
+
+// refill adds what the bucket earned since it was last refilled
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.level = min(b.capacity, b.level+elapsed.Minutes()*b.capacity)
+	}
+	b.last = now
+}
+
+// delay returns how long until the bucket holds n
+func (b *bucket) delay(n float64) time.Duration {
+	if b.capacity == 0 || b.level >= n {
+		return 0
+	}
+	return time.Duration((n - b.level) / b.capacity * float64(time.Minute))
+}
+
+// take removes n from the bucket
+func (b *bucket) take(n float64) {
+	if b.capacity > 0 {
+		b.level -= n
+	}
+}
+
+// wait blocks until a request of about tokens tokens may be sent, and takes
+// its slot. It returns how long it waited, or ctx's error if ctx is done
+// first. A request larger than the per-minute token limit waits for a full
+// bucket rather than forever.
+func (l *rateLimiter) wait(ctx context.Context, tokens int) (time.Duration, error) {
+	start, waited := l.now(), false
+	for {
+		l.mu.Lock()
+		now := l.now()
+		l.requests.refill(now)
+		l.tokens.refill(now)
+		need := min(float64(tokens), l.tokens.capacity)
+		delay := max(l.requests.delay(1), l.tokens.delay(need))
+		if delay == 0 {
+			l.requests.take(1)
+			l.tokens.take(float64(tokens))
+			l.mu.Unlock()
+			if !waited {
+				return 0, nil
+			}
+			return now.Sub(start), nil
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return l.now().Sub(start), ctx.Err()
+		case <-timer.C:
+			waited = true
+		}
+	}
+}
+
+// settle charges the tokens a request used beyond the estimate it waited for,
+// or returns what it did not use
+func (l *rateLimiter) settle(estimate, actual int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens.refill(l.now())
+	l.tokens.take(float64(actual - estimate))
+	l.tokens.level = min(l.tokens.level, l.tokens.capacity)
+}
+
+// throttle waits until the rate limit allows req to be sent
+func (a *OpenAIAgent) throttle(ctx context.Context, req openai.ChatCompletionRequest) error {
+	if a.limiter == nil {
+		return nil
+	}
+	waited, err := a.limiter.wait(ctx, estimateUsage(req, "").PromptTokens)
+	if err != nil {
+		a.logger.Log("[WARN] Agent.throttle: Gave up waiting for the rate limit after %s: %v", waited.Round(time.Millisecond), err)
+		return err
+	}
+	if waited > 0 {
+		a.logger.Log("[INFO] Agent.throttle: Request held %s by the rate limit", waited.Round(time.Millisecond))
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+// fakeClock returns a limiter clock that only moves when advanced
+func fakeClock(l *rateLimiter) func(time.Duration) {
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	l.requests.last, l.tokens.last = now, now
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRateLimiterPacesRequests(t *testing.T) {
+	l := newRateLimiter(&config.Config{RateLimitRPM: 2})
+	advance := fakeClock(l)
+
+	for i := 0; i < 2; i++ {
+		if waited, err := l.wait(context.Background(), 0); err != nil || waited != 0 {
+			t.Fatalf("Expected request %d within the burst to go at once, got %v, %v", i+1, waited, err)
+		}
+	}
+	if delay := l.requests.delay(1); delay != 30*time.Second {
+		t.Fatalf("Expected the third request to wait 30s, got %v", delay)
+	}
+
+	advance(30 * time.Second)
+	if waited, err := l.wait(context.Background(), 0); err != nil || waited != 0 {
+		t.Fatalf("Expected a slot after the refill, got %v, %v", waited, err)
+	}
+}
+
+func TestRateLimiterTokensAndSettle(t *testing.T) {
+	l := newRateLimiter(&config.Config{RateLimitTPM: 1000})
+	advance := fakeClock(l)
+
+	if _, err := l.wait(context.Background(), 400); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// The request used more than estimated, so the bucket owes the difference
+	l.settle(400, 1100)
+	if delay := l.tokens.delay(100); delay != 12*time.Second {
+		t.Fatalf("Expected 100 tokens to wait 12s after the overrun, got %v", delay)
+	}
+
+	// A request larger than the limit waits for a full bucket, not forever
+	advance(2 * time.Minute)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.wait(cancelled, 5000); err != nil {
+		t.Fatalf("Expected an oversized request to go with a full bucket, got %v", err)
+	}
+}
+
+func TestRateLimiterRespectsCancel(t *testing.T) {
+	l := newRateLimiter(&config.Config{RateLimitRPM: 1})
+	if _, err := l.wait(context.Background(), 0); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := l.wait(ctx, 0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the wait to end with the context, took %v", elapsed)
+	}
+}
+
+func TestRateLimitHoldsSendMessage(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "first", "second")
+	a.limiter = newRateLimiter(&config.Config{RateLimitRPM: 1})
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "hi"}}, func(string) {}); err != nil {
+		t.Fatalf("Expected the first request to go, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := a.SendMessage(ctx, []Message{{Role: "user", Content: "again"}}, func(string) {})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the second request to be held until cancelled, got %v", err)
+	}
+	if len(fake.requests) != 1 {
+		t.Fatalf("Expected 1 request sent, got %d", len(fake.requests))
+	}
+}
@@ -0,0 +1,16 @@
+package agent
+
+import (
+	"github.com/sashabaranov/go-openai"
+)
+
+// applyReasoning adapts req to a reasoning model: the configured reasoning
+// effort is set and the temperature, which such models fix at 1 and reject
+// otherwise, is left out. Requests to other models are unchanged.
+func (a *OpenAIAgent) applyReasoning(req *openai.ChatCompletionRequest) {
+	if !a.config.IsReasoningModel(req.Model) {
+		return
+	}
+	req.Temperature = 0
+	req.ReasoningEffort = string(a.config.ReasoningEffort)
+}
@@ -0,0 +1,29 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+func TestReasoningEffortSentToReasoningModels(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "first", "second")
+	a.config.ReasoningEffort = config.ReasoningEffortHigh
+
+	// Not a reasoning model: the effort is ignored
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "hello"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if req := fake.lastRequest(); req.ReasoningEffort != "" || req.Temperature != 0.7 {
+		t.Errorf("Expected gpt-4o without a reasoning effort, got %q at temperature %v", req.ReasoningEffort, req.Temperature)
+	}
+
+	a.config.Model = "o3-mini"
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "again"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if req := fake.lastRequest(); req.ReasoningEffort != "high" || req.Temperature != 0 {
+		t.Errorf("Expected o3-mini with high effort and no temperature, got %q at temperature %v", req.ReasoningEffort, req.Temperature)
+	}
+}
@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// RefusalCategoryModel is the category of a response the model declined to give
+	RefusalCategoryModel = "refusal"
+	// RefusalCategoryFilter is the category of a response the provider's
+	// content filter withheld without naming why
+	RefusalCategoryFilter = "content_filter"
+)
+
+// ErrRefused is returned by unattended runs that stopped because the model
+// refused, or the provider withheld, a response
+var ErrRefused = errors.New("the response was refused")
+
+// Refusal describes a response the model declined to give or the provider's
+// content filter withheld
+type Refusal struct {
+	Category string `json:"category"`       // RefusalCategoryModel, the filter's categories (e.g. "violence", comma-separated) or RefusalCategoryFilter
+	Text     string `json:"text,omitempty"` // The model's explanation, for RefusalCategoryModel
+}
+
+// Message returns the text shown to the user for the refusal
+func (r Refusal) Message() string {
+	if r.Category == RefusalCategoryModel {
+		return r.Text
+	}
+	return fmt.Sprintf("The response was withheld by the provider's content filter (%s).", r.Category)
+}
+
+// historyContent returns the assistant message the response is recorded
+// with: the text streamed before the refusal, then the refusal as plain
+// text, which providers accept where they would drop a refusal field
+func (r Refusal) historyContent(content string) string {
+	note := r.Text
+	if r.Category != RefusalCategoryModel {
+		note = fmt.Sprintf("[Response withheld by the content filter: %s]", r.Category)
+	}
+	if content == "" {
+		return note
+	}
+	return content + "\n\n" + note
+}
+
+// refusalTracker collects the refusal text and filtered categories of a
+// streamed response
+type refusalTracker struct {
+	text       strings.Builder
+	categories []string
+}
+
+// add records the refusal signals of a streamed choice
+func (t *refusalTracker) add(choice openai.ChatCompletionStreamChoice) {
+	t.text.WriteString(choice.Delta.Refusal)
+	for _, category := range filteredCategories(choice.ContentFilterResults) {
+		if !slices.Contains(t.categories, category) {
+			t.categories = append(t.categories, category)
+		}
+	}
+}
+
+// finish returns the refusal of a response that ended for reason, or nil
+// when it was neither refused nor filtered
+func (t *refusalTracker) finish(reason FinishReason) *Refusal {
+	if text := strings.TrimSpace(t.text.String()); text != "" {
+		return &Refusal{Category: RefusalCategoryModel, Text: text}
+	}
+	if reason != FinishReasonContentFilter && len(t.categories) == 0 {
+		return nil
+	}
+	if len(t.categories) == 0 {
+		return &Refusal{Category: RefusalCategoryFilter}
+	}
+	return &Refusal{Category: strings.Join(t.categories, ",")}
+}
+
+// filteredCategories returns the categories the content filter withheld output for
+func filteredCategories(results openai.ContentFilterResults) []string {
+	var categories []string
+	for _, c := range []struct {
+		name     string
+		filtered bool
+	}{
+		{"hate", results.Hate.Filtered},
+		{"self_harm", results.SelfHarm.Filtered},
+		{"sexual", results.Sexual.Filtered},
+		{"violence", results.Violence.Filtered},
+		{"jailbreak", results.JailBreak.Filtered},
+		{"profanity", results.Profanity.Filtered},
+	} {
+		if c.filtered {
+			categories = append(categories, c.name)
+		}
+	}
+	return categories
+}
+
+// reportRefusal sends a "refusal" item for a response that ended in
+// refusal, notes its category for the turn's usage record and returns the
+// content to record the response with
+func (a *OpenAIAgent) reportRefusal(handler ResponseHandler, refusal *Refusal, updates *messageFlusher, content string, finish FinishReason) string {
+	a.logger.Log("[AUDIT] Agent: Response refused (category: %s): %q", refusal.Category, refusal.Text)
+	a.mu.Lock()
+	if a.turn != nil {
+		a.turn.refusal = refusal.Category
+	}
+	a.mu.Unlock()
+
+	item := ResponseItem{
+		Type:         EventRefusal,
+		Message:      &Message{ID: updates.id, Model: updates.model, Role: openai.ChatMessageRoleAssistant, Content: refusal.Message()},
+		Refusal:      refusal,
+		FinishReason: finish,
+	}
+	if data, err := json.Marshal(item); err == nil {
+		handler(string(data))
+	}
+	return refusal.historyContent(content)
+}
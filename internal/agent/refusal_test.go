@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/usagelog"
+)
+
+func TestRefusalReported(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, refusalReply("I can't help with that."))
+	a.config.RetryEmptyResponse = true
+	a.config.UsageLog = filepath.Join(t.TempDir(), "usage.jsonl")
+
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "hello"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if len(fake.requests) != 1 {
+		t.Errorf("Expected a refusal not to be retried, got %d requests", len(fake.requests))
+	}
+	if n := countItems(items, EventEmptyResponse); n != 0 {
+		t.Errorf("Expected no empty_response item, got %d", n)
+	}
+	last := items[len(items)-1]
+	if last.Type != EventRefusal || last.Refusal == nil || last.Refusal.Category != RefusalCategoryModel || last.Message.Content != "I can't help with that." {
+		t.Fatalf("Expected a refusal item with the model's text, got %+v", last)
+	}
+	if reply, ok := a.GetLastAssistantMessage(); !ok || reply != "I can't help with that." {
+		t.Errorf("Expected the refusal as a plain assistant message in the history, got %q", reply)
+	}
+
+	records, err := usagelog.Read(a.config.UsageLog, time.Time{})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(records) != 1 || records[0].Refusal != RefusalCategoryModel {
+		t.Errorf("Expected the turn to be recorded as refused, got %+v", records)
+	}
+}
+
+func TestContentFilterReported(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, filteredReply("Here is how"))
+
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "hello"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	last := items[len(items)-1]
+	if last.Type != EventRefusal || last.Refusal.Category != RefusalCategoryFilter || last.FinishReason != FinishReasonContentFilter {
+		t.Fatalf("Expected a content_filter refusal item last, got %+v", last)
+	}
+	// The partial reply is kept, followed by a note the API accepts back
+	want := "Here is how\n\n[Response withheld by the content filter: content_filter]"
+	if reply, ok := a.GetLastAssistantMessage(); !ok || reply != want {
+		t.Errorf("Expected %q in the history, got %q", want, reply)
+	}
+}
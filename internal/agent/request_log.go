@@ -0,0 +1,245 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Every request sent in a session is indexed in <id>.requests.jsonl next to
+// the session snapshot: its turn, its place in the turn, its message count and
+// a hash of its body, enough to tell what a turn sent and whether two requests
+// were identical. With capture_requests the full body and the streamed
+// response are also written to <id>.captures/turn-<t>-<r>.json, which
+// codex inspect and /inspect print.
+
+const (
+	requestIndexExt = ".requests.jsonl"
+	capturesExt     = ".captures"
+)
+
+// errStreamClosed marks a captured response whose stream was closed before it ended
+var errStreamClosed = errors.New("stream closed before the end of the response")
+
+// RequestRef is one entry of a session's request index
+type RequestRef struct {
+	Turn     int       `json:"turn"`
+	Request  int       `json:"request"` // 1-based within the turn; tool follow-ups and continuations come after the first
+	Time     time.Time `json:"time"`
+	Model    string    `json:"model"`
+	Messages int       `json:"messages"`
+	Tools    int       `json:"tools,omitempty"`
+	Hash     string    `json:"hash"`              // SHA-256 of the request body
+	Capture  string    `json:"capture,omitempty"` // Capture file, relative to the session directory
+}
+
+// RequestCapture is the full exchange of one request
+type RequestCapture struct {
+	Ref      RequestRef                            `json:"ref"`
+	Request  openai.ChatCompletionRequest          `json:"request"`
+	Response []openai.ChatCompletionStreamResponse `json:"response"`        // Chunks as streamed
+	Error    string                                `json:"error,omitempty"` // Why the response ended early
+}
+
+// requestIndex numbers the requests sent in the agent's current session
+type requestIndex struct {
+	mu      sync.Mutex
+	session string // Session the counters belong to
+	turn    int
+	request int
+}
+
+// next returns the turn and request numbers of the next request of session.
+// The requests of a session resumed from disk continue after the indexed ones.
+func (ix *requestIndex) next(dir, session string, newTurn bool) RequestRef {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if session != ix.session {
+		ix.session, ix.turn, ix.request = session, 0, 0
+		if refs, _ := LoadRequestIndex(dir, session); len(refs) > 0 {
+			last := refs[len(refs)-1]
+			ix.turn, ix.request = last.Turn, last.Request
+		}
+	}
+	if newTurn || ix.turn == 0 {
+		ix.turn++
+		ix.request = 0
+	}
+	ix.request++
+	return RequestRef{Turn: ix.turn, Request: ix.request}
+}
+
+// indexRequest appends req to the request index of the current session and,
+// with capture_requests, returns the capture its response is recorded in.
+// Nothing is indexed without a session directory.
+func (a *OpenAIAgent) indexRequest(req openai.ChatCompletionRequest) *requestCapture {
+	if !a.config.AutosaveEnabled() {
+		return nil
+	}
+	a.mu.Lock()
+	session, newTurn := "", a.newTurn
+	if a.history != nil {
+		session = a.history.CurrentSession
+	}
+	a.newTurn = false
+	a.mu.Unlock()
+	if session == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		a.logger.Log("[WARN] Agent.indexRequest: failed to encode request: %v", err)
+		return nil
+	}
+	dir := a.config.SessionDir
+	ref := a.requests.next(dir, session, newTurn)
+	sum := sha256.Sum256(body)
+	ref.Time = time.Now()
+	ref.Model = req.Model
+	ref.Messages = len(req.Messages)
+	ref.Tools = len(req.Tools)
+	ref.Hash = hex.EncodeToString(sum[:])
+	if a.config.CaptureRequests {
+		ref.Capture = filepath.Join(session+capturesExt, fmt.Sprintf("turn-%d-%d.json", ref.Turn, ref.Request))
+	}
+	if err := appendRequestRef(dir, session, ref); err != nil {
+		a.logger.Log("[WARN] Agent.indexRequest: %v", err)
+	}
+	if ref.Capture == "" {
+		return nil
+	}
+	return &requestCapture{path: filepath.Join(dir, ref.Capture), logger: a.logger, data: RequestCapture{Ref: ref, Request: req}}
+}
+
+// appendRequestRef appends ref to the request index of session id in dir
+func appendRequestRef(dir, id string, ref RequestRef) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create session directory: %w", err)
+	}
+	line, err := json.Marshal(ref)
+	if err != nil {
+		return fmt.Errorf("failed to encode request index entry: %w", err)
+	}
+	file, err := os.OpenFile(filepath.Join(dir, id+requestIndexExt), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open request index: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write request index: %w", err)
+	}
+	return nil
+}
+
+// LoadRequestIndex reads the request index of session id in dir, oldest
+// request first. A session that sent no request has an empty index.
+func LoadRequestIndex(dir, id string) ([]RequestRef, error) {
+	data, err := os.ReadFile(filepath.Join(dir, id+requestIndexExt))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request index: %w", err)
+	}
+
+	var refs []RequestRef
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var ref RequestRef
+		if err := json.Unmarshal(scanner.Bytes(), &ref); err != nil {
+			// A line cut short by a crash ends the index
+			break
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// LoadRequestCapture reads the captured body and response of the request ref
+// of a session in dir
+func LoadRequestCapture(dir string, ref RequestRef) (*RequestCapture, error) {
+	if ref.Capture == "" {
+		return nil, fmt.Errorf("request %d of turn %d was not captured (set capture_requests to capture requests)", ref.Request, ref.Turn)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ref.Capture))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request capture: %w", err)
+	}
+	var capture RequestCapture
+	if err := json.Unmarshal(data, &capture); err != nil {
+		return nil, fmt.Errorf("failed to parse request capture %s: %w", ref.Capture, err)
+	}
+	return &capture, nil
+}
+
+// requestCapture collects the response to a captured request and writes the
+// capture once the response ends
+type requestCapture struct {
+	path   string
+	logger logging.Logger
+	once   sync.Once
+	data   RequestCapture
+}
+
+// finish writes the capture; err is why the response ended, io.EOF or nil
+// when it was complete. Only the first call has an effect.
+func (c *requestCapture) finish(err error) {
+	c.once.Do(func() {
+		if err != nil && err != io.EOF {
+			c.data.Error = err.Error()
+		}
+		if err := c.write(); err != nil {
+			c.logger.Log("[WARN] Agent.requestCapture: %v", err)
+		}
+	})
+}
+
+// write writes the capture file
+func (c *requestCapture) write() error {
+	data, err := json.MarshalIndent(c.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode request capture: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to create capture directory: %w", err)
+	}
+	if err := os.WriteFile(c.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write request capture: %w", err)
+	}
+	return nil
+}
+
+// capturingStream records the chunks of a stream into a capture
+type capturingStream struct {
+	ChatStream
+	capture *requestCapture
+}
+
+func (s *capturingStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	resp, err := s.ChatStream.Recv()
+	if err != nil {
+		s.capture.finish(err)
+		return resp, err
+	}
+	s.capture.data.Response = append(s.capture.data.Response, resp)
+	return resp, nil
+}
+
+func (s *capturingStream) Close() error {
+	s.capture.finish(errStreamClosed)
+	return s.ChatStream.Close()
+}
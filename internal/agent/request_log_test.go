@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestRequestsAreIndexedByTurn(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, toolCallReply("read_file", `{"path":"a.go"}`), "It declares package a.", "Hello")
+	a.config.SessionDir = t.TempDir()
+	a.config.CaptureRequests = true
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Read a.go"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if err := a.SendToolResult(context.Background(), NewToolResult("call_1", "read_file", "package a", true)); err != nil {
+		t.Fatalf("SendToolResult failed: %v", err)
+	}
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Hi"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	refs, err := LoadRequestIndex(a.config.SessionDir, a.SessionID())
+	if err != nil {
+		t.Fatalf("LoadRequestIndex failed: %v", err)
+	}
+	if len(refs) != 3 {
+		t.Fatalf("Expected 3 indexed requests, got %+v", refs)
+	}
+	want := [][2]int{{1, 1}, {1, 2}, {2, 1}}
+	for i, ref := range refs {
+		if ref.Turn != want[i][0] || ref.Request != want[i][1] {
+			t.Errorf("Expected request %d to be turn %d request %d, got %+v", i, want[i][0], want[i][1], ref)
+		}
+		sum := sha256.Sum256(fake.bodies[i])
+		if ref.Hash != hex.EncodeToString(sum[:]) || ref.Messages != len(fake.requests[i].Messages) || ref.Model != "gpt-4o" {
+			t.Errorf("Expected request %d to be described by its body, got %+v", i, ref)
+		}
+	}
+
+	capture, err := LoadRequestCapture(a.config.SessionDir, refs[1])
+	if err != nil {
+		t.Fatalf("LoadRequestCapture failed: %v", err)
+	}
+	if len(capture.Request.Messages) != refs[1].Messages || !strings.Contains(capture.Request.Messages[len(capture.Request.Messages)-1].Content, "package a") {
+		t.Errorf("Expected the follow-up request to be captured, got %+v", capture.Request.Messages)
+	}
+	if len(capture.Response) == 0 || capture.Error != "" {
+		t.Errorf("Expected the complete streamed response to be captured, got %d chunks, error %q", len(capture.Response), capture.Error)
+	}
+
+	// A resumed session continues the numbering
+	var ix requestIndex
+	if ref := ix.next(a.config.SessionDir, a.SessionID(), true); ref.Turn != 3 || ref.Request != 1 {
+		t.Errorf("Expected turn 3 after the indexed ones, got %+v", ref)
+	}
+}
+
+func TestRequestsAreNotCapturedByDefault(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, "Hello")
+	a.config.SessionDir = t.TempDir()
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Hi"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	refs, _ := LoadRequestIndex(a.config.SessionDir, a.SessionID())
+	if len(refs) != 1 || refs[0].Capture != "" || refs[0].Hash == "" {
+		t.Fatalf("Expected one indexed request without a capture, got %+v", refs)
+	}
+	if _, err := LoadRequestCapture(a.config.SessionDir, refs[0]); err == nil {
+		t.Error("Expected an error loading a request that was not captured")
+	}
+}
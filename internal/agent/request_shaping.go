@@ -0,0 +1,354 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// strictKeywords are the schema keywords a strict function schema may use;
+// a tool whose schema uses any other is sent as it is
+var strictKeywords = map[string]bool{
+	"type":                 true,
+	"description":          true,
+	"enum":                 true,
+	"properties":           true,
+	"required":             true,
+	"items":                true,
+	"additionalProperties": true,
+}
+
+// ValidateForcedTool reports why the named tool cannot be forced with
+// ForceTool, or nil when it can
+func (a *OpenAIAgent) ValidateForcedTool(name string) error {
+	if !a.hasTool(name) {
+		return fmt.Errorf("cannot force unknown tool %q", name)
+	}
+	if !a.ToolAllowed(name) {
+		return fmt.Errorf("cannot force tool %q: it is not allowed in this session", name)
+	}
+	return nil
+}
+
+// ForceTool makes the model call the named tool in its next response, by
+// sending that request with tool_choice set to the tool. It applies to one
+// request only, so the response to the tool's result is free again. An empty
+// name lifts a force that was not used yet; an unknown or disallowed tool is
+// an error and changes nothing.
+func (a *OpenAIAgent) ForceTool(name string) error {
+	if name != "" {
+		if err := a.ValidateForcedTool(name); err != nil {
+			return err
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.forcedTool = name
+	return nil
+}
+
+// applyRequestProfile shapes req for the provider and model it is sent to,
+// per their request profile: max_tokens is set when required, usage asked
+// for in the stream, tool_choice made explicit and the tools marked strict. A
+// tool forced with ForceTool is set as the tool_choice whatever the profile.
+func (a *OpenAIAgent) applyRequestProfile(req *openai.ChatCompletionRequest) {
+	profile := a.config.RequestProfileFor(a.config.BaseURL, req.Model)
+	if profile.StreamUsage && req.Stream {
+		// Usage is reported in a final chunk and counted against the budget
+		req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+	if profile.RequireMaxTokens && req.MaxTokens == 0 && req.MaxCompletionTokens == 0 {
+		if a.config.IsReasoningModel(req.Model) {
+			req.MaxCompletionTokens = config.DefaultRequiredMaxTokens
+		} else {
+			req.MaxTokens = config.DefaultRequiredMaxTokens
+		}
+	}
+
+	strict := make(map[string]bool)
+	if profile.StrictTools && len(req.Tools) > 0 {
+		req.Tools = a.strictTools(req.Tools, strict)
+	}
+	a.pendingMu.Lock()
+	a.strictSent = strict
+	a.pendingMu.Unlock()
+
+	a.mu.Lock()
+	forced := a.forcedTool
+	a.forcedTool = ""
+	a.mu.Unlock()
+	if forced != "" {
+		if slices.ContainsFunc(req.Tools, func(tool openai.Tool) bool { return tool.Function.Name == forced }) {
+			req.ToolChoice = openai.ToolChoice{Type: openai.ToolTypeFunction, Function: openai.ToolFunction{Name: forced}}
+			return
+		}
+		a.logger.Log("[WARN] Agent: Tool '%s' was forced but is not offered with the request; the force is dropped.", forced)
+	}
+	if profile.ExplicitToolChoice && len(req.Tools) > 0 {
+		req.ToolChoice = "auto"
+	}
+}
+
+// strictTools returns tools with the function definitions that can be made
+// strict replaced by strict copies, recording their names in sent. The
+// strict schemas are converted once per tool and reused.
+func (a *OpenAIAgent) strictTools(tools []openai.Tool, sent map[string]bool) []openai.Tool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.strictSchemas == nil {
+		a.strictSchemas = make(map[string]json.RawMessage)
+	}
+	result := make([]openai.Tool, len(tools))
+	for i, tool := range tools {
+		result[i] = tool
+		if tool.Function == nil {
+			continue
+		}
+		name := tool.Function.Name
+		params, cached := a.strictSchemas[name]
+		if !cached {
+			params = strictToolParameters(tool.Function.Parameters)
+			a.strictSchemas[name] = params
+			if params == nil {
+				a.logger.Log("[INFO] Agent: The schema of tool '%s' cannot be made strict; it is sent as it is.", name)
+			}
+		}
+		if params == nil {
+			continue
+		}
+		function := *tool.Function
+		function.Parameters = params
+		function.Strict = true
+		result[i].Function = &function
+		sent[name] = true
+	}
+	return result
+}
+
+// strictToolParameters returns the strict form of a tool's parameter schema,
+// or nil when it cannot have one: every object is closed with
+// additionalProperties false and requires all of its properties, those that
+// were optional becoming nullable. Property order is kept.
+func strictToolParameters(params interface{}) json.RawMessage {
+	raw, err := encodeToolParameters(params)
+	if err != nil {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	schema, err := decodeOrdered(dec)
+	if err != nil {
+		return nil
+	}
+	object, ok := schema.(OrderedMap)
+	if !ok {
+		return nil
+	}
+	object, ok = strictSchema(object)
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// strictSchema returns the strict form of schema, reporting whether it has one
+func strictSchema(schema OrderedMap) (OrderedMap, bool) {
+	for _, kv := range schema {
+		if !strictKeywords[kv.Key] {
+			return nil, false
+		}
+	}
+	if value, ok := schema.Get("items"); ok {
+		items, ok := value.(OrderedMap)
+		if !ok {
+			return nil, false
+		}
+		if items, ok = strictSchema(items); !ok {
+			return nil, false
+		}
+		schema = schema.with("items", items)
+	}
+	if typ, _ := schema.Get("type"); typ != "object" {
+		_, hasProperties := schema.Get("properties")
+		return schema, !hasProperties
+	}
+
+	if extra, ok := schema.Get("additionalProperties"); ok && extra != false {
+		return nil, false // A map with free-form keys
+	}
+	value, _ := schema.Get("properties")
+	properties, ok := value.(OrderedMap)
+	if !ok {
+		return nil, false
+	}
+	required := make(map[string]bool)
+	if value, ok := schema.Get("required"); ok {
+		names, ok := value.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		for _, name := range names {
+			if name, ok := name.(string); ok {
+				required[name] = true
+			}
+		}
+	}
+
+	strictProperties := make(OrderedMap, 0, len(properties))
+	all := make([]interface{}, 0, len(properties))
+	for _, kv := range properties {
+		property, ok := kv.Value.(OrderedMap)
+		if !ok {
+			return nil, false
+		}
+		if property, ok = strictSchema(property); !ok {
+			return nil, false
+		}
+		if !required[kv.Key] {
+			if property, ok = nullable(property); !ok {
+				return nil, false
+			}
+		}
+		strictProperties = append(strictProperties, KeyValue{Key: kv.Key, Value: property})
+		all = append(all, kv.Key)
+	}
+	return schema.with("properties", strictProperties).with("required", all).with("additionalProperties", false), true
+}
+
+// nullable returns the schema of an optional property made to take null,
+// which strict schemas use in place of leaving the property out, reporting
+// whether it could be
+func nullable(property OrderedMap) (OrderedMap, bool) {
+	switch typ, _ := property.Get("type"); typ := typ.(type) {
+	case string:
+		property = property.with("type", []interface{}{typ, "null"})
+	case []interface{}:
+		if !slices.Contains(typ, interface{}("null")) {
+			property = property.with("type", append(slices.Clip(typ), "null"))
+		}
+	default:
+		return nil, false
+	}
+	if value, ok := property.Get("enum"); ok {
+		values, ok := value.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		if !slices.Contains(values, nil) {
+			property = property.with("enum", append(slices.Clip(values), nil))
+		}
+	}
+	return property, true
+}
+
+// with returns a copy of m with key set to value, in place of its current
+// value or appended
+func (m OrderedMap) with(key string, value interface{}) OrderedMap {
+	result := slices.Clone(m)
+	for i := range result {
+		if result[i].Key == key {
+			result[i].Value = value
+			return result
+		}
+	}
+	return append(result, KeyValue{Key: key, Value: value})
+}
+
+// decodeOrdered decodes the next JSON value of dec, objects as OrderedMaps so
+// their keys keep the order they were written in
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		var object OrderedMap
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, KeyValue{Key: key.(string), Value: value})
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return object, nil
+	case json.Delim('['):
+		array := []interface{}{}
+		for dec.More() {
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return array, nil
+	}
+	return token, nil
+}
+
+// dropNullArguments removes the null members of the objects in the arguments
+// of a call to a strict tool, which stand for optional parameters left out,
+// so tools see the arguments they would without strict schemas. Arguments
+// without nulls are returned unchanged.
+func dropNullArguments(args string) string {
+	var value interface{}
+	if err := json.Unmarshal([]byte(args), &value); err != nil {
+		return args
+	}
+	if !dropNulls(value) {
+		return args
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return args
+	}
+	return string(data)
+}
+
+// dropNulls removes null object members throughout value, reporting whether there were any
+func dropNulls(value interface{}) bool {
+	dropped := false
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, member := range value {
+			if member == nil {
+				delete(value, key)
+				dropped = true
+			} else if dropNulls(member) {
+				dropped = true
+			}
+		}
+	case []interface{}:
+		for _, element := range value {
+			if dropNulls(element) {
+				dropped = true
+			}
+		}
+	}
+	return dropped
+}
+
+// sentStrict reports whether the last request offered the named tool strict
+func (a *OpenAIAgent) sentStrict(name string) bool {
+	a.pendingMu.Lock()
+	defer a.pendingMu.Unlock()
+	return a.strictSent[name]
+}
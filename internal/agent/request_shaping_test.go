@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestApplyRequestProfile(t *testing.T) {
+	tests := []struct {
+		name       string
+		baseURL    string
+		model      string
+		maxTokens  int
+		noTools    bool
+		forced     string
+		profiles   []config.RequestProfile
+		wantMax    int
+		wantChoice interface{}
+		wantStrict bool
+		wantUsage  bool
+	}{
+		{name: "openai", baseURL: config.DefaultBaseURL, model: "gpt-4o", wantStrict: true, wantUsage: true},
+		{name: "anthropic", baseURL: "https://api.anthropic.com/v1/", model: "claude-sonnet-4", wantMax: config.DefaultRequiredMaxTokens},
+		{name: "anthropic keeps max_tokens", baseURL: "https://api.anthropic.com/v1/", model: "claude-sonnet-4", maxTokens: 1000, wantMax: 1000},
+		{name: "claude behind a gateway", baseURL: "https://llm.internal/v1", model: "claude-3-5-haiku", wantMax: config.DefaultRequiredMaxTokens},
+		{
+			name:       "gateway requiring tool_choice",
+			baseURL:    "https://gateway.example.com/v1",
+			model:      "gpt-4o",
+			profiles:   []config.RequestProfile{{BaseURL: "https://gateway.example.com", ExplicitToolChoice: true}},
+			wantChoice: "auto",
+		},
+		{
+			name:       "gateway requiring tool_choice without tools",
+			baseURL:    "https://gateway.example.com/v1",
+			model:      "gpt-4o",
+			noTools:    true,
+			profiles:   []config.RequestProfile{{BaseURL: "https://gateway.example.com", ExplicitToolChoice: true}},
+			wantChoice: nil,
+		},
+		{name: "forced tool not offered", baseURL: "http://localhost:11434/v1", model: "llama3", noTools: true, forced: "read_file"},
+		{name: "unknown provider", baseURL: "http://localhost:11434/v1", model: "llama3"},
+		{
+			name:      "gateway streaming usage",
+			baseURL:   "https://gateway.example.com/v1",
+			model:     "gpt-4o",
+			profiles:  []config.RequestProfile{{BaseURL: "https://gateway.example.com", StreamUsage: true}},
+			wantUsage: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{APIKey: "test", BaseURL: tt.baseURL, Model: tt.model, MaxTokens: tt.maxTokens, RequestProfiles: tt.profiles}
+			a, err := NewOpenAIAgent(cfg, nil)
+			if err != nil {
+				t.Fatalf("Failed to create agent: %v", err)
+			}
+			req := openai.ChatCompletionRequest{Model: tt.model, Stream: true}
+			if !tt.noTools {
+				req.Tools = a.requestTools()
+			}
+			if tt.forced != "" {
+				if err := a.ForceTool(tt.forced); err != nil {
+					t.Fatalf("ForceTool failed: %v", err)
+				}
+			}
+			a.applyOutputLimit(&req)
+			a.applyRequestProfile(&req)
+
+			if req.MaxTokens != tt.wantMax {
+				t.Errorf("Expected max_tokens %d, got %d", tt.wantMax, req.MaxTokens)
+			}
+			if gotUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage; gotUsage != tt.wantUsage {
+				t.Errorf("Expected stream usage %v, got %v", tt.wantUsage, gotUsage)
+			}
+			if req.ToolChoice != tt.wantChoice {
+				t.Errorf("Expected tool_choice %v, got %v", tt.wantChoice, req.ToolChoice)
+			}
+			for _, tool := range req.Tools {
+				if tool.Function.Strict != tt.wantStrict {
+					t.Errorf("Expected tool %s strict=%v", tool.Function.Name, tt.wantStrict)
+				}
+			}
+		})
+	}
+}
+
+func TestRequiredMaxTokensOfReasoningModels(t *testing.T) {
+	cfg := &config.Config{APIKey: "test", BaseURL: "https://gateway.example.com/v1", Model: "o3",
+		RequestProfiles: []config.RequestProfile{{BaseURL: "https://gateway.example.com", RequireMaxTokens: true}}}
+	a, err := NewOpenAIAgent(cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	req := openai.ChatCompletionRequest{Model: "o3"}
+	a.applyRequestProfile(&req)
+	if req.MaxTokens != 0 || req.MaxCompletionTokens != config.DefaultRequiredMaxTokens {
+		t.Errorf("Expected max_completion_tokens %d for a reasoning model, got max_tokens %d and max_completion_tokens %d", config.DefaultRequiredMaxTokens, req.MaxTokens, req.MaxCompletionTokens)
+	}
+}
+
+func TestStrictToolParameters(t *testing.T) {
+	params := map[string]interface{}{
+		"type": "object",
+		"properties": OrderedMap{
+			{Key: "path", Value: map[string]interface{}{"type": "string"}},
+			{Key: "mode", Value: map[string]interface{}{"type": "string", "enum": []string{"a", "b"}}},
+			{Key: "edits", Value: map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type":       "object",
+					"properties": OrderedMap{{Key: "line", Value: map[string]interface{}{"type": "integer"}}},
+				},
+			}},
+		},
+		"required": []string{"path"},
+	}
+	got := strictToolParameters(params)
+	want := `{"properties":{` +
+		`"path":{"type":"string"},` +
+		`"mode":{"enum":["a","b",null],"type":["string","null"]},` +
+		`"edits":{"items":{"properties":{"line":{"type":["integer","null"]}},"type":"object","required":["line"],"additionalProperties":false},"type":["array","null"]}},` +
+		`"required":["path","mode","edits"],"type":"object","additionalProperties":false}`
+	if string(got) != want {
+		t.Errorf("Unexpected strict schema:\n got %s\nwant %s", got, want)
+	}
+
+	for name, params := range map[string]interface{}{
+		"free-form object":      map[string]interface{}{"type": "object", "properties": map[string]interface{}{"env": map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}}}},
+		"unsupported keyword":   map[string]interface{}{"type": "object", "properties": map[string]interface{}{"n": map[string]interface{}{"type": "integer", "minimum": 1}}},
+		"optional without type": map[string]interface{}{"type": "object", "properties": map[string]interface{}{"v": map[string]interface{}{"description": "any value"}}},
+	} {
+		if got := strictToolParameters(params); got != nil {
+			t.Errorf("Expected no strict schema for a %s, got %s", name, got)
+		}
+	}
+}
+
+func TestStrictToolsSentAndNullArgumentsDropped(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, toolCallReply("read_file", `{"path": "main.go", "offset": null}`))
+	a.config.RequestProfiles = []config.RequestProfile{{BaseURL: a.config.BaseURL, StrictTools: true}}
+
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Read main.go"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	for _, tool := range fake.lastRequest().Tools {
+		if !tool.Function.Strict {
+			t.Errorf("Expected tool %s to be sent strict", tool.Function.Name)
+		}
+		params, _ := json.Marshal(tool.Function.Parameters)
+		var schema struct {
+			AdditionalProperties *bool `json:"additionalProperties"`
+		}
+		if err := json.Unmarshal(params, &schema); err != nil || schema.AdditionalProperties == nil || *schema.AdditionalProperties {
+			t.Errorf("Expected tool %s to be closed with additionalProperties false, got %s", tool.Function.Name, params)
+		}
+	}
+	if len(items) != 1 || items[0].FunctionCall == nil || items[0].FunctionCall.Arguments != `{"path":"main.go"}` {
+		t.Errorf("Expected the call without its null argument, got %+v", items)
+	}
+}
+
+func TestForceTool(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, toolCallReply("list_directory", `{"path": "."}`), "Done.")
+
+	if err := a.ForceTool("no_such_tool"); err == nil || !strings.Contains(err.Error(), "unknown tool") {
+		t.Errorf("Expected forcing an unknown tool to fail, got %v", err)
+	}
+	if err := a.ForceTool("list_directory"); err != nil {
+		t.Fatalf("ForceTool failed: %v", err)
+	}
+
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "What is here?"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	choice, _ := json.Marshal(fake.lastRequest().ToolChoice)
+	if string(choice) != `{"function":{"name":"list_directory"},"type":"function"}` {
+		t.Errorf("Expected the request to force list_directory, got %s", choice)
+	}
+
+	call := items[0].FunctionCall
+	if err := a.SendFunctionResult(context.Background(), call.ID, call.Name, "main.go", true); err != nil {
+		t.Fatalf("SendFunctionResult failed: %v", err)
+	}
+	if choice := fake.lastRequest().ToolChoice; choice != nil {
+		t.Errorf("Expected the force to apply to one request, got tool_choice %v on the next", choice)
+	}
+}
+
+func TestForceToolOutsideAllowlist(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t)
+	if err := a.SetAllowedTools([]string{"read_file"}); err != nil {
+		t.Fatalf("SetAllowedTools failed: %v", err)
+	}
+	if err := a.ForceTool("shell"); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Expected forcing a disallowed tool to fail, got %v", err)
+	}
+}
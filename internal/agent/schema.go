@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// KeyValue is a single entry in an OrderedMap
+type KeyValue struct {
+	Key   string
+	Value interface{}
+}
+
+// OrderedMap is a JSON object that marshals its entries in declaration order.
+// Use it for tool parameter properties so the schema sent to the API lists
+// fields in the order they were defined instead of alphabetically.
+type OrderedMap []KeyValue
+
+// MarshalJSON implements json.Marshaler
+func (m OrderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, kv := range m {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(kv.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal value for key %q: %w", kv.Key, err)
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Get returns the value for key, if present
+func (m OrderedMap) Get(key string) (interface{}, bool) {
+	for _, kv := range m {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+func TestOrderedMapPreservesOrder(t *testing.T) {
+	m := OrderedMap{
+		{"zeta", 1},
+		{"alpha", "two"},
+		{"mid", OrderedMap{{"b", true}, {"a", false}}},
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+
+	expected := `{"zeta":1,"alpha":"two","mid":{"b":true,"a":false}}`
+	if string(data) != expected {
+		t.Errorf("Expected %s, got %s", expected, string(data))
+	}
+}
+
+func TestConvertToolDefinitionsKeepsPropertyOrder(t *testing.T) {
+	tools := []ToolDefinition{{
+		Type: "function",
+		Function: FunctionDef{
+			Name: "append_chunk",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": OrderedMap{
+					{"path", map[string]interface{}{"type": "string"}},
+					{"index", map[string]interface{}{"type": "integer"}},
+					{"content", map[string]interface{}{"type": "string"}},
+				},
+			},
+		},
+	}}
+
+	converted := convertToolDefinitions(tools)
+	if len(converted) != 1 {
+		t.Fatalf("Expected 1 tool, got %d", len(converted))
+	}
+
+	raw, ok := converted[0].Function.Parameters.(json.RawMessage)
+	if !ok {
+		t.Fatalf("Expected parameters as json.RawMessage, got %T", converted[0].Function.Parameters)
+	}
+	schema := string(raw)
+	pathIdx := strings.Index(schema, `"path"`)
+	indexIdx := strings.Index(schema, `"index"`)
+	contentIdx := strings.Index(schema, `"content"`)
+	if !(pathIdx < indexIdx && indexIdx < contentIdx) {
+		t.Errorf("Expected properties in declaration order, got %s", schema)
+	}
+
+	// Encoding twice must produce identical bytes
+	again := convertToolDefinitions(tools)[0].Function.Parameters.(json.RawMessage)
+	if string(again) != schema {
+		t.Errorf("Expected stable encoding, got %s then %s", schema, string(again))
+	}
+}
+
+func TestReadOnlyToolsWithholdsMutations(t *testing.T) {
+	a, err := NewOpenAIAgent(&config.Config{APIKey: "test", Model: "gpt-4o", ReadOnly: true}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	for _, tool := range a.tools {
+		if IsMutatingTool(tool.Function.Name) {
+			t.Errorf("Expected %s to be withheld in read-only mode", tool.Function.Name)
+		}
+		if tool.Function.Name == "shell" && !strings.Contains(tool.Function.Description, "read-only") {
+			t.Errorf("Expected shell description to mention read-only mode, got %q", tool.Function.Description)
+		}
+	}
+}
+
+func TestExportToolSchemas(t *testing.T) {
+	a, err := NewOpenAIAgent(&config.Config{APIKey: "test", Model: "gpt-4o"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	var buf strings.Builder
+	if err := a.ExportToolSchemas(&buf); err != nil {
+		t.Fatalf("ExportToolSchemas failed: %v", err)
+	}
+
+	var exported []struct {
+		Type     string `json:"type"`
+		Function struct {
+			Name       string          `json:"name"`
+			Parameters json.RawMessage `json:"parameters"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal([]byte(buf.String()), &exported); err != nil {
+		t.Fatalf("Exported schemas are not valid JSON: %v", err)
+	}
+
+	defs := a.GetToolDefinitions()
+	if len(exported) != len(defs) {
+		t.Fatalf("Expected %d exported tools, got %d", len(defs), len(exported))
+	}
+	for i, tool := range exported {
+		if tool.Type != "function" || tool.Function.Name != defs[i].Function.Name {
+			t.Errorf("Expected function %s at index %d, got %s %s", defs[i].Function.Name, i, tool.Type, tool.Function.Name)
+		}
+		if !strings.HasPrefix(string(tool.Function.Parameters), "{") {
+			t.Errorf("Expected parameters object for %s, got %s", tool.Function.Name, tool.Function.Parameters)
+		}
+	}
+
+	// Mutating the returned slice must not affect the agent
+	defs[0].Function.Name = "changed"
+	if a.GetToolDefinitions()[0].Function.Name == "changed" {
+		t.Errorf("Expected GetToolDefinitions to return a copy")
+	}
+}
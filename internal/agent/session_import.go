@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ImportInfo records where a session converted from another tool came from
+type ImportInfo struct {
+	Format     string            `json:"format"`              // Source format, e.g. "codex-rollout" or "chatgpt"
+	Source     string            `json:"source"`              // File the session was imported from
+	SourceID   string            `json:"source_id,omitempty"` // Conversation ID in the source
+	Title      string            `json:"title,omitempty"`
+	ImportedAt time.Time         `json:"imported_at"`
+	Messages   []ImportedMessage `json:"messages,omitempty"` // Only messages with a known time or an issue
+}
+
+// ImportedMessage records, for the message at Index, when it was originally
+// sent and what could not be converted faithfully
+type ImportedMessage struct {
+	Index  int        `json:"index"`
+	Time   *time.Time `json:"time,omitempty"`
+	Issues []string   `json:"issues,omitempty"`
+}
+
+// Flagged returns how many imported messages could not be fully converted
+func (i *ImportInfo) Flagged() int {
+	n := 0
+	for _, m := range i.Messages {
+		if len(m.Issues) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// shift moves the recorded message indexes after messages were inserted or
+// removed at the start of the history
+func (i *ImportInfo) shift(delta int) {
+	if i == nil {
+		return
+	}
+	for k := range i.Messages {
+		i.Messages[k].Index += delta
+	}
+}
+
+// remove drops the records of the removed message indexes, given in
+// ascending order, and moves the indexes of the messages after them
+func (i *ImportInfo) remove(removed []int) {
+	if i == nil || len(removed) == 0 {
+		return
+	}
+	kept := i.Messages[:0]
+	for _, m := range i.Messages {
+		before, gone := 0, false
+		for _, r := range removed {
+			if r == m.Index {
+				gone = true
+			}
+			if r < m.Index {
+				before++
+			}
+		}
+		if gone {
+			continue
+		}
+		m.Index -= before
+		kept = append(kept, m)
+	}
+	i.Messages = kept
+}
+
+// SaveSession writes h to dir as the snapshot of session h.CurrentSession, so
+// it can be continued with RecoverSession. An existing session is not overwritten.
+func SaveSession(dir string, h *ConversationHistory) error {
+	if h.CurrentSession == "" {
+		return errors.New("session has no ID")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create session directory: %w", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, h.CurrentSession+snapshotExt)); err == nil {
+		return fmt.Errorf("session %s already exists", h.CurrentSession)
+	}
+	return writeSnapshot(dir, h)
+}
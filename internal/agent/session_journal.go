@@ -0,0 +1,369 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/blobstore"
+)
+
+// Sessions are persisted as a snapshot (<id>.json, the same format as
+// ConversationHistory.Save) plus a write-ahead journal (<id>.journal) of the
+// changes made since. Every history change is appended to the journal and
+// synced before the history method returns, so a crash loses at most the
+// message being written. Autosave folds the journal into the snapshot; a clean
+// Close does the same and removes the journal. A journal left on disk therefore
+// marks a session that did not shut down cleanly and can be recovered.
+
+const (
+	snapshotExt = ".json"
+	journalExt  = ".journal"
+)
+
+// journalRecord is one line of a session journal. Messages are appended to the
+// history, or replace it when Reset is set: for the first record after the
+// journal is opened, and after truncation, pruning or edits.
+type journalRecord struct {
+	Reset       bool      `json:"reset,omitempty"`
+	Messages    []Message `json:"messages"`
+	Interrupted bool      `json:"interrupted,omitempty"`
+	WorkingDir  string    `json:"working_dir,omitempty"`
+	Queued      *[]string `json:"queued_messages,omitempty"` // Set when the queue changed, empty when it was emptied
+	Time        time.Time `json:"time"`
+	PID         int       `json:"pid"` // Process writing the journal
+}
+
+// sessionJournal appends the changes of one session's history to its journal file
+type sessionJournal struct {
+	dir string
+	id  string
+
+	mu          sync.Mutex
+	file        *os.File
+	started     bool             // Whether the full history was recorded since opening
+	written     int              // Messages of the history already recorded
+	rev         uint64           // History rewrites as of the last record
+	interrupted bool             // Whether the interrupted mark was recorded
+	workingDir  string           // Working directory as of the last record
+	queued      []string         // Queued messages as of the last record
+	lock        WriteLock        // Held while the journal and snapshot are written; nil when unlocked
+	blobs       *blobstore.Store // Where the snapshot keeps large messages; nil keeps them inline
+}
+
+// openSessionJournal opens (or creates) the journal for session id in dir
+func openSessionJournal(dir, id string) (*sessionJournal, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	file, err := os.OpenFile(filepath.Join(dir, id+journalExt), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session journal: %w", err)
+	}
+	return &sessionJournal{dir: dir, id: id, file: file}, nil
+}
+
+// record appends the changes made to h since the last record
+func (j *sessionJournal) record(h *ConversationHistory) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	if err := verifyLock(j.lock); err != nil {
+		return fmt.Errorf("session not journaled: %w", err)
+	}
+
+	rec := journalRecord{Interrupted: h.Interrupted, WorkingDir: h.WorkingDir, Time: time.Now(), PID: os.Getpid()}
+	queueChanged := !slices.Equal(h.QueuedMessages, j.queued)
+	if queueChanged {
+		queued := append([]string{}, h.QueuedMessages...)
+		rec.Queued = &queued
+	}
+	if !j.started || h.rewrites != j.rev || len(h.Messages) < j.written {
+		rec.Reset = true
+		rec.Messages = h.Messages
+	} else if len(h.Messages) > j.written {
+		rec.Messages = h.Messages[j.written:]
+	} else if h.Interrupted == j.interrupted && h.WorkingDir == j.workingDir && !queueChanged {
+		return nil
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal record: %w", err)
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write session journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync session journal: %w", err)
+	}
+	j.started = true
+	j.written = len(h.Messages)
+	j.rev = h.rewrites
+	j.interrupted = h.Interrupted
+	j.workingDir = h.WorkingDir
+	j.queued = append([]string{}, h.QueuedMessages...)
+	return nil
+}
+
+// checkpoint folds the journal into the snapshot and empties the journal. It
+// works from the files alone, so it is safe while the history is being changed.
+func (j *sessionJournal) checkpoint() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+
+	info, err := j.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat session journal: %w", err)
+	}
+	if info.Size() == 0 {
+		return nil // Nothing changed since the last checkpoint
+	}
+	if err := verifyLock(j.lock); err != nil {
+		return fmt.Errorf("session not saved: %w", err)
+	}
+
+	history, _, err := loadSession(j.dir, j.id)
+	if err != nil {
+		return err
+	}
+	if err := offloadMessages(history, j.blobs); err != nil {
+		return err
+	}
+	if err := writeSnapshot(j.dir, history); err != nil {
+		return err
+	}
+	if err := j.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate session journal: %w", err)
+	}
+	return nil
+}
+
+// finish checkpoints the session and removes its journal, marking a clean
+// shutdown. Sessions without a user message are not worth keeping and are
+// removed entirely.
+func (j *sessionJournal) finish(h *ConversationHistory) error {
+	recordErr := j.record(h)
+	checkpointErr := j.checkpoint()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	j.file.Close()
+	j.file = nil
+
+	if recordErr != nil || checkpointErr != nil {
+		// Keep the journal so the session can still be recovered
+		if recordErr != nil {
+			return recordErr
+		}
+		return checkpointErr
+	}
+	if !hasUserMessage(h.Messages) {
+		os.Remove(filepath.Join(j.dir, j.id+snapshotExt))
+	}
+	if err := os.Remove(filepath.Join(j.dir, j.id+journalExt)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove session journal: %w", err)
+	}
+	return nil
+}
+
+// loadSession rebuilds session id from its snapshot and journal, and returns
+// the process that last wrote the journal. A final journal line cut short by a
+// crash is ignored.
+func loadSession(dir, id string) (*ConversationHistory, int, error) {
+	history := &ConversationHistory{Messages: []Message{}, CurrentSession: id}
+
+	data, err := os.ReadFile(filepath.Join(dir, id+snapshotExt))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, 0, fmt.Errorf("failed to read session snapshot: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, history); err != nil {
+			return nil, 0, fmt.Errorf("failed to parse session snapshot: %w", err)
+		}
+		resolveMessages(history)
+	}
+
+	journal, err := os.ReadFile(filepath.Join(dir, id+journalExt))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, 0, fmt.Errorf("failed to read session journal: %w", err)
+	}
+	owner := 0
+	scanner := bufio.NewScanner(bytes.NewReader(journal))
+	scanner.Buffer(nil, len(journal)+1)
+	for scanner.Scan() {
+		var rec journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			break // Torn write at the end of the journal
+		}
+		if rec.Reset {
+			history.Messages = append([]Message{}, rec.Messages...)
+		} else {
+			history.Messages = append(history.Messages, rec.Messages...)
+		}
+		history.Interrupted = history.Interrupted || rec.Interrupted
+		if rec.WorkingDir != "" {
+			history.WorkingDir = rec.WorkingDir
+		}
+		if rec.Queued != nil {
+			history.QueuedMessages = *rec.Queued
+		}
+		if history.CreatedAt.IsZero() {
+			history.CreatedAt = rec.Time
+		}
+		history.UpdatedAt = rec.Time
+		owner = rec.PID
+	}
+
+	history.CurrentSession = id
+	if err := history.Migrate(); err != nil {
+		return nil, 0, fmt.Errorf("failed to load session %s: %w", id, err)
+	}
+	history.CurrentTokens = history.EstimateTokenCount()
+	return history, owner, nil
+}
+
+// writeSnapshot atomically replaces the snapshot of a session
+func writeSnapshot(dir string, h *ConversationHistory) error {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal session snapshot: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, h.CurrentSession+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create session snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write session snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync session snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write session snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, h.CurrentSession+snapshotExt)); err != nil {
+		return fmt.Errorf("failed to replace session snapshot: %w", err)
+	}
+	return nil
+}
+
+// hasUserMessage reports whether messages contain anything the user said
+func hasUserMessage(messages []Message) bool {
+	for _, msg := range messages {
+		if msg.Role == "user" {
+			return true
+		}
+	}
+	return false
+}
+
+// RecoverableSession describes a session that ended without a clean shutdown
+type RecoverableSession struct {
+	ID        string
+	UpdatedAt time.Time
+	Messages  int
+	Preview   string // Start of the last user message
+}
+
+// FindRecoverableSessions lists the sessions in dir whose journal was left
+// behind by a crash, most recently updated first. Sessions without a user
+// message and sessions still open in a running process are skipped.
+func FindRecoverableSessions(dir string) ([]RecoverableSession, error) {
+	journals, err := filepath.Glob(filepath.Join(dir, "*"+journalExt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list session journals: %w", err)
+	}
+
+	var sessions []RecoverableSession
+	for _, path := range journals {
+		id := strings.TrimSuffix(filepath.Base(path), journalExt)
+		history, owner, err := loadSession(dir, id)
+		if err != nil || !hasUserMessage(history.Messages) || (owner != os.Getpid() && processAlive(owner)) {
+			continue
+		}
+		session := RecoverableSession{ID: id, UpdatedAt: history.UpdatedAt, Messages: len(history.Messages)}
+		for i := len(history.Messages) - 1; i >= 0; i-- {
+			if history.Messages[i].Role == "user" {
+				session.Preview = truncatePreview(history.Messages[i].Content, 60)
+				break
+			}
+		}
+		sessions = append(sessions, session)
+	}
+
+	sort.Slice(sessions, func(i, k int) bool { return sessions[i].UpdatedAt.After(sessions[k].UpdatedAt) })
+	return sessions, nil
+}
+
+// SavedSessions returns the IDs of the sessions saved in dir and its
+// subdirectories (where the server keeps its sessions): every session with a
+// snapshot or a journal
+func SavedSessions(dir string) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		name := d.Name()
+		if !d.IsDir() && (strings.HasSuffix(name, snapshotExt) || strings.HasSuffix(name, journalExt)) {
+			ids[strings.TrimSuffix(strings.TrimSuffix(name, snapshotExt), journalExt)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list saved sessions: %w", err)
+	}
+	return ids, nil
+}
+
+// DismissSession folds a recoverable session's journal into its snapshot so it
+// is no longer offered for recovery. Nothing is deleted.
+func DismissSession(dir, id string) error {
+	journal, err := openSessionJournal(dir, id)
+	if err != nil {
+		return err
+	}
+	if err := journal.checkpoint(); err != nil {
+		journal.file.Close()
+		return err
+	}
+	journal.file.Close()
+	if err := os.Remove(filepath.Join(dir, id+journalExt)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove session journal: %w", err)
+	}
+	return nil
+}
+
+// truncatePreview shortens s to a single line of at most n characters
+func truncatePreview(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n-3]) + "..."
+	}
+	return s
+}
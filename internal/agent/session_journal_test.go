@@ -0,0 +1,272 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/blobstore"
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+// newJournaledAgent creates an agent that journals its session in dir
+func newJournaledAgent(t *testing.T, dir string) *OpenAIAgent {
+	t.Helper()
+	a, err := NewOpenAIAgent(&config.Config{APIKey: "test", Model: "gpt-4o", SessionDir: dir}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	if a.journal == nil {
+		t.Fatalf("Expected the session to be journaled")
+	}
+	return a
+}
+
+// crash abandons the agent's session the way a killed process would
+func crash(a *OpenAIAgent) {
+	close(a.autosaveStop)
+	<-a.autosaveDone
+	a.journal.file.Close()
+}
+
+func TestSessionRecoveredAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	a := newJournaledAgent(t, dir)
+	history := a.GetHistory()
+	history.AddMessage(Message{Role: "user", Content: "refactor the parser"})
+	history.AddMessage(Message{Role: "assistant", Content: "Starting with the lexer."})
+
+	// An autosave folds the journal into the snapshot; later changes are journaled again
+	if err := a.journal.checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	history.AddMessage(Message{Role: "user", Content: "also update the tests"})
+	history.Truncate(len(history.Messages) - 1)
+	history.AddMessage(Message{Role: "user", Content: "and the docs"})
+	want := append([]Message(nil), history.Messages...)
+	id := a.SessionID()
+	crash(a)
+
+	// A torn final write is ignored
+	f, _ := os.OpenFile(filepath.Join(dir, id+journalExt), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"messages":[{"role":"user","con`)
+	f.Close()
+
+	sessions, err := FindRecoverableSessions(dir)
+	if err != nil {
+		t.Fatalf("FindRecoverableSessions failed: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != id {
+		t.Fatalf("Expected session %s to be recoverable, got %+v", id, sessions)
+	}
+	if sessions[0].Messages != len(want) || sessions[0].Preview != "and the docs" {
+		t.Errorf("Expected %d messages ending with 'and the docs', got %+v", len(want), sessions[0])
+	}
+
+	b := newJournaledAgent(t, dir)
+	if err := b.RecoverSession(id); err != nil {
+		t.Fatalf("RecoverSession failed: %v", err)
+	}
+	got := b.GetHistory().GetMessages()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d recovered messages, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].Role != want[i].Role || got[i].Content != want[i].Content {
+			t.Errorf("Message %d: expected %s %q, got %s %q", i, want[i].Role, want[i].Content, got[i].Role, got[i].Content)
+		}
+	}
+	if b.SessionID() != id {
+		t.Errorf("Expected the recovered session to keep ID %s, got %s", id, b.SessionID())
+	}
+
+	// A clean close leaves the snapshot and nothing to recover
+	b.GetHistory().AddMessage(Message{Role: "assistant", Content: "Done."})
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if sessions, _ := FindRecoverableSessions(dir); len(sessions) != 0 {
+		t.Errorf("Expected no recoverable sessions after a clean close, got %+v", sessions)
+	}
+	saved, _, err := loadSession(dir, id)
+	if err != nil {
+		t.Fatalf("Failed to load saved session: %v", err)
+	}
+	if len(saved.Messages) != len(want)+1 {
+		t.Errorf("Expected %d saved messages, got %d", len(want)+1, len(saved.Messages))
+	}
+}
+
+func TestEmptySessionIsNotKept(t *testing.T) {
+	dir := t.TempDir()
+	a := newJournaledAgent(t, dir)
+	id := a.SessionID()
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	for _, ext := range []string{snapshotExt, journalExt} {
+		if _, err := os.Stat(filepath.Join(dir, id+ext)); !os.IsNotExist(err) {
+			t.Errorf("Expected no %s file for a session without user messages", ext)
+		}
+	}
+}
+
+func TestDismissSession(t *testing.T) {
+	dir := t.TempDir()
+	a := newJournaledAgent(t, dir)
+	a.GetHistory().AddMessage(Message{Role: "user", Content: "hello"})
+	id := a.SessionID()
+	crash(a)
+
+	if err := DismissSession(dir, id); err != nil {
+		t.Fatalf("DismissSession failed: %v", err)
+	}
+	if sessions, _ := FindRecoverableSessions(dir); len(sessions) != 0 {
+		t.Errorf("Expected no recoverable sessions after dismissing, got %+v", sessions)
+	}
+	saved, _, err := loadSession(dir, id)
+	if err != nil || !hasUserMessage(saved.Messages) {
+		t.Errorf("Expected the dismissed session to stay saved, got %v (err %v)", saved, err)
+	}
+}
+
+func TestSnapshotKeepsLargeMessagesInBlobStore(t *testing.T) {
+	dir, launchDir, projectDir := t.TempDir(), t.TempDir(), t.TempDir()
+	a, err := NewOpenAIAgent(&config.Config{APIKey: "test", Model: "gpt-4o", SessionDir: dir, BlobStore: true, CWD: launchDir, WorkingDir: projectDir}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	large := strings.Repeat("build log line\n", largeMessageBytes/10)
+	a.GetHistory().AddMessage(Message{Role: "user", Content: "why does the build fail?"})
+	a.GetHistory().AddMessage(Message{Role: "assistant", Content: large})
+	id := a.SessionID()
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, id+snapshotExt))
+	if strings.Contains(string(data), "build log line") || !strings.Contains(string(data), blobstore.Hash([]byte(large))) {
+		t.Errorf("Expected the snapshot to reference the large message instead of inlining it")
+	}
+	saved, _, err := loadSession(dir, id)
+	if err != nil {
+		t.Fatalf("loadSession failed: %v", err)
+	}
+	if got := saved.Messages[len(saved.Messages)-1]; got.Content != large || got.ContentBlob != "" {
+		t.Errorf("Expected the large message restored from the blob store")
+	}
+	if sessions, _ := SavedSessions(dir); !sessions[id] {
+		t.Errorf("Expected session %s among the saved sessions, got %v", id, sessions)
+	}
+
+	// The blobs belong to the project the tools work in, not the launch directory
+	if entries, _ := blobstore.Open(blobstore.DirFor(projectDir), false).Entries(); entries[blobstore.Hash([]byte(large))] == nil {
+		t.Errorf("Expected the message in the blob store of the tool directory, got %v", entries)
+	}
+	if _, err := os.Stat(blobstore.DirFor(launchDir)); !os.IsNotExist(err) {
+		t.Errorf("Expected no blob store in the launch directory, got %v", err)
+	}
+}
+
+func TestRecoverSavedSessionAddsSystemPrompt(t *testing.T) {
+	dir := t.TempDir()
+	imported := &ConversationHistory{
+		Messages:       []Message{{Role: "user", Content: "Hello from elsewhere"}, {Role: "assistant", Content: "Hi"}},
+		CurrentSession: "imported-1",
+		Import:         &ImportInfo{Format: "chatgpt", Source: "conversations.json", Messages: []ImportedMessage{{Index: 0, Issues: []string{"image content was not imported"}}}},
+	}
+	if err := SaveSession(dir, imported); err != nil {
+		t.Fatalf("SaveSession failed: %v", err)
+	}
+	if err := SaveSession(dir, imported); err == nil {
+		t.Errorf("Expected saving over an existing session to fail")
+	}
+
+	a := newJournaledAgent(t, dir)
+	defer a.Close()
+	if err := a.RecoverSession("imported-1"); err != nil {
+		t.Fatalf("RecoverSession failed: %v", err)
+	}
+	messages := a.GetHistory().GetMessages()
+	if len(messages) != 3 || messages[0].Role != "system" || messages[1].Content != "Hello from elsewhere" {
+		t.Errorf("Expected the system prompt before the imported messages, got %+v", messages)
+	}
+	info := a.GetHistory().Import
+	if info == nil || info.Format != "chatgpt" || info.Flagged() != 1 {
+		t.Fatalf("Expected the import metadata to be kept, got %+v", info)
+	}
+	if info.Messages[0].Index != 1 {
+		t.Errorf("Expected the flagged message index to follow the inserted prompt, got %d", info.Messages[0].Index)
+	}
+}
+
+// fakeWriteLock is a WriteLock that can be lost
+type fakeWriteLock struct{ lost bool }
+
+func (l *fakeWriteLock) Verify() error {
+	if l.lost {
+		return errors.New("lock lost")
+	}
+	return nil
+}
+
+func TestLostWriteLockStopsWrites(t *testing.T) {
+	dir := t.TempDir()
+	a := newJournaledAgent(t, dir)
+	lock := &fakeWriteLock{}
+	a.SetWriteLock(lock)
+	history := a.GetHistory()
+	history.AddMessage(Message{Role: "user", Content: "refactor the parser"})
+
+	lock.lost = true
+	history.AddMessage(Message{Role: "user", Content: "written by a process that lost the project"})
+	if err := a.journal.checkpoint(); err == nil {
+		t.Error("Expected the checkpoint to be refused without the lock")
+	}
+	if err := history.Save(t.TempDir()); err == nil {
+		t.Error("Expected the history not to be saved without the lock")
+	}
+	id := a.SessionID()
+	crash(a)
+
+	recovered, _, err := loadSession(dir, id)
+	if err != nil {
+		t.Fatalf("loadSession failed: %v", err)
+	}
+	last := recovered.Messages[len(recovered.Messages)-1]
+	if last.Content != "refactor the parser" {
+		t.Errorf("Expected only the messages added while the lock was held, got %q last", last.Content)
+	}
+}
+
+func TestCloseSavesToFallbackWhenAutosaveFails(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "sessions")
+	a := newJournaledAgent(t, dir)
+	a.GetHistory().AddMessage(Message{Role: "user", Content: "Do not lose me"})
+
+	// The session directory is gone, so the journal cannot be folded into a snapshot
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = a.Close()
+	if !errors.Is(err, ErrCloseSaveFailed) {
+		t.Fatalf("Expected a save failure, got %v", err)
+	}
+	fallback := filepath.Join(cacheDir, "codex-go", "history-fallback", a.SessionID()+".json")
+	data, readErr := os.ReadFile(fallback)
+	if readErr != nil || !strings.Contains(string(data), "Do not lose me") {
+		t.Errorf("Expected the history in the fallback file, got %q (%v)", data, readErr)
+	}
+}
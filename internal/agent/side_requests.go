@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/codeindex"
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Requests the agent makes besides the conversation itself, to summarize or
+// embed text, go out like the conversation's: with its client and API key,
+// and through the pre-request hook.
+
+// sideCompletion sends a chat completion request made besides the conversation
+func (a *OpenAIAgent) sideCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	if err := a.checkRequest(ctx, req); err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	return a.apiClient().CreateChatCompletion(ctx, req)
+}
+
+// summaryModel returns the cheap model summaries are asked of
+func (a *OpenAIAgent) summaryModel() string {
+	if a.config.ToolOutputSummaryModel != "" {
+		return a.config.ToolOutputSummaryModel
+	}
+	return config.DefaultToolOutputSummaryModel
+}
+
+// summarizeConversation is the history's Summarizer, asking the summary
+// model to condense the messages pruning dropped
+func (a *OpenAIAgent) summarizeConversation(ctx context.Context, transcript string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp, err := a.sideCompletion(ctx, openai.ChatCompletionRequest{
+		Model: a.summaryModel(),
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: "You are a helpful assistant that summarizes conversations. Create a concise summary of the following conversation, focusing on the key points and actions taken.",
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: transcript,
+			},
+		},
+		MaxTokens: 300,
+	})
+	if err != nil {
+		return "", fmt.Errorf("summary request failed: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("summary response had no choices")
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content), nil
+}
+
+// Embedder returns an embedder for the configured embedding model whose
+// texts go through the pre-request hook, as the user messages of a request,
+// before they are sent with the agent's client
+func (a *OpenAIAgent) Embedder() codeindex.Embedder {
+	model := codeindex.EmbeddingModel(a.config)
+	return codeindex.NewClientEmbedder(model, a.apiClient, func(ctx context.Context, texts []string) error {
+		messages := make([]openai.ChatCompletionMessage, len(texts))
+		for i, text := range texts {
+			messages[i] = openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: text}
+		}
+		return a.checkRequest(ctx, openai.ChatCompletionRequest{Model: model, Messages: messages})
+	})
+}
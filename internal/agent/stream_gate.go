@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultPauseBufferSize is how many items are buffered while paused before the
+// gate switches to accumulated-only mode
+const DefaultPauseBufferSize = 256
+
+// bufferedItem is a response item held back while the stream is paused
+type bufferedItem struct {
+	itemType EventType
+	key      string // Reply of a "message" item (its ID, or its choice without one), call of a "function_call_progress" item
+	content  string // Reasoning so far of a "reasoning" item
+	itemJSON string
+}
+
+// supersedes reports whether item carries everything older did, so that
+// accumulated-only mode can drop older: a later update of the same reply, the
+// latest progress of the same call, or reasoning that extends older's
+func (item bufferedItem) supersedes(older bufferedItem) bool {
+	if item.itemType != older.itemType {
+		return false
+	}
+	switch item.itemType {
+	case EventMessage, EventFunctionCallProgress:
+		return item.key == older.key
+	case EventReasoning:
+		return strings.HasPrefix(item.content, older.content)
+	}
+	return false
+}
+
+// streamGate sits between the streaming loop and the response handler. While
+// paused, items are buffered instead of dispatched; the underlying stream keeps
+// being read so cancellation still works.
+type streamGate struct {
+	mu          sync.Mutex
+	paused      bool
+	flushing    bool
+	buffer      []bufferedItem
+	maxBuffer   int
+	accumulated bool // Buffer overflowed: items superseded by a later one are dropped
+}
+
+// newStreamGate creates a gate that buffers up to maxBuffer items while paused
+func newStreamGate(maxBuffer int) *streamGate {
+	if maxBuffer <= 0 {
+		maxBuffer = DefaultPauseBufferSize
+	}
+	return &streamGate{maxBuffer: maxBuffer}
+}
+
+// wrap returns a handler that dispatches through the gate
+func (g *streamGate) wrap(handler ResponseHandler) ResponseHandler {
+	if handler == nil {
+		return nil
+	}
+	return func(itemJSON string) {
+		g.dispatch(handler, itemJSON)
+	}
+}
+
+// dispatch forwards an item to the handler, or buffers it while paused
+func (g *streamGate) dispatch(handler ResponseHandler, itemJSON string) {
+	g.mu.Lock()
+	if !g.paused {
+		g.mu.Unlock()
+		handler(itemJSON)
+		return
+	}
+	defer g.mu.Unlock()
+
+	item := parseBufferedItem(itemJSON)
+
+	// Message, progress and reasoning items carry the full state so far, so in
+	// accumulated-only mode a newer one replaces the last one it supersedes
+	if g.accumulated {
+		for i := len(g.buffer) - 1; i >= 0; i-- {
+			if item.supersedes(g.buffer[i]) {
+				g.buffer = append(g.buffer[:i], g.buffer[i+1:]...)
+				break
+			}
+		}
+	}
+	g.buffer = append(g.buffer, item)
+
+	if !g.accumulated && len(g.buffer) > g.maxBuffer {
+		g.accumulated = true
+		g.compact()
+	}
+}
+
+// parseBufferedItem reads what the gate needs to know of an item
+func parseBufferedItem(itemJSON string) bufferedItem {
+	var header struct {
+		Type    EventType `json:"type"`
+		Choice  int       `json:"choice"`
+		Message *struct {
+			ID      string `json:"id"`
+			Content string `json:"content"`
+		} `json:"message"`
+		FunctionCall *struct {
+			ID string `json:"id"`
+		} `json:"functionCall"`
+	}
+	json.Unmarshal([]byte(itemJSON), &header)
+	item := bufferedItem{itemType: header.Type, itemJSON: itemJSON}
+	switch header.Type {
+	case EventMessage:
+		if header.Message != nil && header.Message.ID != "" {
+			item.key = "id:" + header.Message.ID
+		} else {
+			item.key = "choice:" + strconv.Itoa(header.Choice)
+		}
+	case EventFunctionCallProgress:
+		if header.FunctionCall != nil {
+			item.key = header.FunctionCall.ID
+		}
+	case EventReasoning:
+		if header.Message != nil {
+			item.content = header.Message.Content
+		}
+	}
+	return item
+}
+
+// compact drops the items a later one supersedes. Caller must hold g.mu.
+func (g *streamGate) compact() {
+	kept := g.buffer[:0]
+	for i, item := range g.buffer {
+		superseded := false
+		for _, later := range g.buffer[i+1:] {
+			if later.supersedes(item) {
+				superseded = true
+				break
+			}
+		}
+		if !superseded {
+			kept = append(kept, item)
+		}
+	}
+	g.buffer = kept
+}
+
+// pause starts buffering items
+func (g *streamGate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paused = true
+	g.flushing = false
+}
+
+// resume flushes buffered items to the handler in order, then stops buffering.
+// Items arriving during the flush are buffered and flushed after the earlier ones.
+func (g *streamGate) resume(handler ResponseHandler) {
+	g.mu.Lock()
+	if !g.paused {
+		g.mu.Unlock()
+		return
+	}
+	g.flushing = true
+	g.mu.Unlock()
+
+	for {
+		g.mu.Lock()
+		if !g.flushing {
+			// Paused again during the flush
+			g.mu.Unlock()
+			return
+		}
+		if len(g.buffer) == 0 || handler == nil {
+			g.buffer = nil
+			g.paused = false
+			g.flushing = false
+			g.accumulated = false
+			g.mu.Unlock()
+			return
+		}
+		items := g.buffer
+		g.buffer = nil
+		g.mu.Unlock()
+
+		for _, item := range items {
+			handler(item.itemJSON)
+		}
+	}
+}
+
+// isPaused reports whether the gate is buffering
+func (g *streamGate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
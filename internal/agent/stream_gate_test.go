@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+)
+
+func messageItem(content string) string {
+	return fmt.Sprintf(`{"type":"message","message":{"role":"assistant","content":%q}}`, content)
+}
+
+func TestStreamGateBuffersWhilePaused(t *testing.T) {
+	var received []string
+	handler := func(item string) { received = append(received, item) }
+
+	g := newStreamGate(10)
+	wrapped := g.wrap(handler)
+
+	wrapped(messageItem("a"))
+	g.pause()
+	wrapped(messageItem("ab"))
+	wrapped(messageItem("abc"))
+	if len(received) != 1 {
+		t.Fatalf("Expected 1 item dispatched before pause, got %d", len(received))
+	}
+
+	g.resume(handler)
+	if len(received) != 3 || received[2] != messageItem("abc") {
+		t.Fatalf("Expected buffered items flushed in order, got %v", received)
+	}
+	if g.isPaused() {
+		t.Errorf("Expected gate to be resumed")
+	}
+
+	wrapped(messageItem("abcd"))
+	if len(received) != 4 {
+		t.Errorf("Expected direct dispatch after resume, got %d items", len(received))
+	}
+}
+
+func TestStreamGateAccumulatedOnlyMode(t *testing.T) {
+	var received []string
+	handler := func(item string) { received = append(received, item) }
+
+	g := newStreamGate(3)
+	wrapped := g.wrap(handler)
+	g.pause()
+
+	content := ""
+	for i := 0; i < 10; i++ {
+		content += "x"
+		wrapped(messageItem(content))
+		if i == 4 {
+			wrapped(`{"type":"function_call","function_call":{"name":"shell","id":"call_1"}}`)
+		}
+	}
+
+	g.resume(handler)
+	if len(received) != 2 {
+		t.Fatalf("Expected the function call and the latest message, got %d items: %v", len(received), received)
+	}
+	if received[1] != messageItem(content) {
+		t.Errorf("Expected latest accumulated message last, got %s", received[1])
+	}
+}
+
+func TestStreamGateAccumulatedModeKeepsDistinctMessages(t *testing.T) {
+	var received []string
+	handler := func(item string) { received = append(received, item) }
+	reply := func(id, content string) string {
+		return fmt.Sprintf(`{"type":"message","message":{"id":%q,"role":"assistant","content":%q}}`, id, content)
+	}
+	progress := func(bytes int) string {
+		return fmt.Sprintf(`{"type":"function_call_progress","functionCall":{"id":"call_1","name":"shell"},"progress":{"bytes":%d}}`, bytes)
+	}
+
+	g := newStreamGate(3)
+	wrapped := g.wrap(handler)
+	g.pause()
+
+	// The text before a tool round and the text after it are two replies on the same choice
+	before, after := "", ""
+	for i := 0; i < 5; i++ {
+		before += "x"
+		wrapped(reply("reply_1", before))
+	}
+	for i := 1; i <= 5; i++ {
+		wrapped(progress(i * 10))
+	}
+	call := `{"type":"function_call","functionCall":{"name":"shell","id":"call_1"}}`
+	wrapped(call)
+	for i := 0; i < 5; i++ {
+		after += "y"
+		wrapped(reply("reply_2", after))
+	}
+
+	g.resume(handler)
+	want := []string{reply("reply_1", before), progress(50), call, reply("reply_2", after)}
+	if fmt.Sprint(received) != fmt.Sprint(want) {
+		t.Errorf("Expected the latest update of each reply and of the progress, got %v", received)
+	}
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ChatStream is a stream of chat completion chunks
+type ChatStream interface {
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close() error
+}
+
+// StreamSource opens the streams responses are read from. The agent reads
+// them from the API unless SetStreamSource gives it another source, such as
+// a replay of recorded responses or a fake in tests.
+type StreamSource interface {
+	CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error)
+}
+
+// StreamSourceFunc adapts a function to a StreamSource
+type StreamSourceFunc func(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error)
+
+// CreateChatCompletionStream calls f
+func (f StreamSourceFunc) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+	return f(ctx, req)
+}
+
+// apiStreamSource opens streams with the agent's API client, as of each request
+type apiStreamSource struct {
+	agent *OpenAIAgent
+}
+
+func (s apiStreamSource) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+	stream, err := s.agent.apiClient().CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// SetStreamSource makes the agent read responses from src instead of the
+// API; nil goes back to the API. Requests already streaming are unaffected.
+func (a *OpenAIAgent) SetStreamSource(src StreamSource) {
+	a.clientMu.Lock()
+	defer a.clientMu.Unlock()
+	a.streams = src
+}
+
+// StreamSource returns the source responses are read from, the API unless
+// SetStreamSource replaced it. Wrapping it is how a source adds to the API
+// rather than replacing it, e.g. to record the traffic.
+func (a *OpenAIAgent) StreamSource() StreamSource {
+	a.clientMu.Lock()
+	defer a.clientMu.Unlock()
+	if a.streams == nil {
+		return apiStreamSource{agent: a}
+	}
+	return a.streams
+}
+
+// openChatStream opens the stream of the response to req and indexes the
+// request in the session, capturing its response with capture_requests. The
+// pre-request hook may abort it first.
+func (a *OpenAIAgent) openChatStream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+	if err := a.checkRequest(ctx, req); err != nil {
+		return nil, err
+	}
+	stream, err := a.StreamSource().CreateChatCompletionStream(ctx, req)
+	capture := a.indexRequest(req)
+	if capture == nil {
+		return stream, err
+	}
+	if err != nil {
+		capture.finish(err)
+		return nil, err
+	}
+	return &capturingStream{ChatStream: stream, capture: capture}, nil
+}
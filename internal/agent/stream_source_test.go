@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// sliceStream streams fixed chunks
+type sliceStream struct {
+	chunks []openai.ChatCompletionStreamResponse
+}
+
+func (s *sliceStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if len(s.chunks) == 0 {
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func (s *sliceStream) Close() error { return nil }
+
+func TestStreamSourceReplacesAPI(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t)
+	var inputs []Input
+	a.RegisterInputHook(func(input Input) { inputs = append(inputs, input) })
+	a.SetStreamSource(StreamSourceFunc(func(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+		return &sliceStream{chunks: []openai.ChatCompletionStreamResponse{
+			{Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Role: "assistant", Content: "from the source"}}}},
+			{Choices: []openai.ChatCompletionStreamChoice{{FinishReason: openai.FinishReasonStop}}},
+		}}, nil
+	}))
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "hello"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("Expected no request to reach the API, got %d", len(fake.requests))
+	}
+	if last, _ := a.GetHistory().GetLastMessage(); last.Content != "from the source" {
+		t.Errorf("Expected the source's response in the history, got %q", last.Content)
+	}
+	if len(inputs) != 1 || inputs[0].Kind != InputSendMessage || inputs[0].Messages[0].Content != "hello" {
+		t.Errorf("Expected the message to be reported as an input, got %+v", inputs)
+	}
+
+	// Errors opening a stream are the agent's errors; nil goes back to the API
+	a.SetStreamSource(StreamSourceFunc(func(context.Context, openai.ChatCompletionRequest) (ChatStream, error) {
+		return nil, errors.New("offline")
+	}))
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "again"}}, func(string) {}); err == nil {
+		t.Errorf("Expected the source's error")
+	}
+	a.SetStreamSource(nil)
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "once more"}}, func(string) {}); err != nil || len(fake.requests) != 1 {
+		t.Errorf("Expected the API to be used again, got %v and %d requests", err, len(fake.requests))
+	}
+}
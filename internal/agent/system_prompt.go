@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// WorkingDirSourcePriority is the priority of the built-in source stating the
+// session working directory, which is merged after the usual additions
+const WorkingDirSourcePriority = 100
+
+// SystemPromptSource contributes to the system prompt of every request. Its
+// content is produced when the request is built and never stored in the
+// history, so it may change from one request to the next; the base prompt,
+// the leading system message of the history, is left as it is.
+type SystemPromptSource struct {
+	Name     string
+	Priority int                                 // Merge order: lower first; the base prompt is 0 and goes first among equals
+	Content  func(h *ConversationHistory) string // "" contributes nothing to the request
+}
+
+// AddSystemPromptSource registers src, replacing a source of the same name in
+// place. Sources of equal priority are merged in the order they were added.
+func (a *OpenAIAgent) AddSystemPromptSource(src SystemPromptSource) error {
+	if src.Name == "" || src.Content == nil {
+		return errors.New("system prompt source needs a name and content")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := range a.promptSources {
+		if a.promptSources[i].Name == src.Name {
+			a.promptSources[i] = src
+			return nil
+		}
+	}
+	a.promptSources = append(a.promptSources, src)
+	a.logger.Log("[DEBUG] Agent.AddSystemPromptSource: Added %s (priority %d).", src.Name, src.Priority)
+	return nil
+}
+
+// RemoveSystemPromptSource unregisters the source named name and reports
+// whether there was one
+func (a *OpenAIAgent) RemoveSystemPromptSource(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := range a.promptSources {
+		if a.promptSources[i].Name == name {
+			a.promptSources = append(a.promptSources[:i:i], a.promptSources[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// requestMessages converts the history for a request, with the system prompt
+// sources and the reminder added
+func (a *OpenAIAgent) requestMessages() []openai.ChatCompletionMessage {
+	a.mu.Lock()
+	sources := a.promptSources
+	a.mu.Unlock()
+	messages := withSystemPromptSources(a.messages.build(a.history), a.history, sources, a.config.SystemPromptMerge)
+	return withSystemReminder(messages, a.history, a.config)
+}
+
+// withSystemPromptSources returns messages with the content of sources merged
+// with the base prompt in priority order: joined into one system message, or
+// as system messages of their own under SystemPromptSeparate. The merged
+// messages lead the request, before the conversation.
+func withSystemPromptSources(messages []openai.ChatCompletionMessage, h *ConversationHistory, sources []SystemPromptSource, merge config.SystemPromptMerge) []openai.ChatCompletionMessage {
+	type part struct {
+		priority int
+		content  string
+	}
+	var parts []part
+	conversation := messages
+	if len(messages) > 0 && messages[0].Role == openai.ChatMessageRoleSystem {
+		parts = append(parts, part{content: messages[0].Content})
+		conversation = messages[1:]
+	}
+	base := len(parts)
+	for _, src := range sources {
+		if content := src.Content(h); strings.TrimSpace(content) != "" {
+			parts = append(parts, part{priority: src.Priority, content: content})
+		}
+	}
+	if len(parts) == base {
+		return messages
+	}
+	sort.SliceStable(parts, func(i, j int) bool { return parts[i].priority < parts[j].priority })
+
+	var result []openai.ChatCompletionMessage
+	if merge == config.SystemPromptSeparate {
+		result = make([]openai.ChatCompletionMessage, 0, len(parts)+len(conversation)+1)
+		for _, p := range parts {
+			result = append(result, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: p.content})
+		}
+	} else {
+		contents := make([]string, len(parts))
+		for i, p := range parts {
+			contents[i] = p.content
+		}
+		result = make([]openai.ChatCompletionMessage, 0, len(conversation)+2)
+		result = append(result, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: strings.Join(contents, "\n\n")})
+	}
+	return append(result, conversation...)
+}
+
+// configSystemPromptSource turns a source from the config into one whose file,
+// if it has one, is read for every request. An unreadable file contributes
+// nothing.
+func (a *OpenAIAgent) configSystemPromptSource(src config.SystemPromptSource) SystemPromptSource {
+	content := func(*ConversationHistory) string { return src.Text }
+	if src.File != "" {
+		path := a.config.ResolveSystemPromptFile(src)
+		content = func(*ConversationHistory) string {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				a.logger.Log("[WARN] Agent.requestMessages: System prompt source %s not read: %v", src.Name, err)
+				return ""
+			}
+			return string(data)
+		}
+	}
+	return SystemPromptSource{Name: src.Name, Priority: src.Priority, Content: content}
+}
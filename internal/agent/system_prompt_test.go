@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+// constSource returns a system prompt source with fixed content
+func constSource(name string, priority int, content string) SystemPromptSource {
+	return SystemPromptSource{Name: name, Priority: priority, Content: func(*ConversationHistory) string { return content }}
+}
+
+func TestSystemPromptSourcesMergedInPriorityOrder(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "first", "second")
+	ctx := context.Background()
+	a.GetHistory().SetSystemPrompt("BASE")
+
+	a.AddSystemPromptSource(constSource("late", 50, "LATE"))
+	a.AddSystemPromptSource(constSource("early", -5, "EARLY"))
+	a.AddSystemPromptSource(constSource("tie", 0, "TIE"))
+	a.AddSystemPromptSource(constSource("empty", 20, ""))
+	if err := a.AddSystemPromptSource(SystemPromptSource{Name: "broken"}); err == nil {
+		t.Errorf("Expected a source without content to be rejected")
+	}
+
+	if _, err := a.SendMessage(ctx, []Message{{Role: "user", Content: "hello"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	req := fake.lastRequest()
+	if got, want := req.Messages[0].Content, "EARLY\n\nBASE\n\nTIE\n\nLATE"; got != want {
+		t.Errorf("Expected merged system prompt %q, got %q", want, got)
+	}
+	if req.Messages[1].Role != "user" {
+		t.Errorf("Expected one system message before the conversation, got %s second", req.Messages[1].Role)
+	}
+	// The sources are not stored in the history
+	if a.GetHistory().Messages[0].Content != "BASE" {
+		t.Errorf("Expected the stored prompt to stay BASE, got %q", a.GetHistory().Messages[0].Content)
+	}
+
+	// Replacing keeps the position; removing drops the source
+	a.AddSystemPromptSource(constSource("tie", 0, "TIE2"))
+	if !a.RemoveSystemPromptSource("late") || a.RemoveSystemPromptSource("late") {
+		t.Errorf("Expected late to be removed once")
+	}
+	if _, err := a.SendMessage(ctx, []Message{{Role: "user", Content: "again"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if got, want := fake.lastRequest().Messages[0].Content, "EARLY\n\nBASE\n\nTIE2"; got != want {
+		t.Errorf("Expected merged system prompt %q, got %q", want, got)
+	}
+}
+
+func TestSystemPromptSourcesSeparate(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "ok")
+	a.config.SystemPromptMerge = config.SystemPromptSeparate
+	a.GetHistory().SetSystemPrompt("BASE")
+	a.AddSystemPromptSource(constSource("context", 10, "CONTEXT"))
+	if err := a.SetWorkingDir("/work/service"); err != nil {
+		t.Fatalf("SetWorkingDir failed: %v", err)
+	}
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "hello"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	req := fake.lastRequest()
+	if len(req.Messages) != 4 {
+		t.Fatalf("Expected 3 system messages and the user message, got %d messages", len(req.Messages))
+	}
+	if req.Messages[0].Content != "BASE" || req.Messages[1].Content != "CONTEXT" || !strings.HasPrefix(req.Messages[2].Content, "Current working directory: /work/service") {
+		t.Errorf("Expected base, context and working directory in order, got %q, %q, %q", req.Messages[0].Content, req.Messages[1].Content, req.Messages[2].Content)
+	}
+	for _, msg := range req.Messages[:3] {
+		if msg.Role != "system" {
+			t.Errorf("Expected a system message, got %s", msg.Role)
+		}
+	}
+}
+
+func TestConfigSystemPromptSourceReadsFileEachRequest(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "first", "second")
+	ctx := context.Background()
+	a.config.CWD = t.TempDir()
+	path := filepath.Join(a.config.CWD, "team.md")
+	os.WriteFile(path, []byte("Use tabs."), 0644)
+	a.AddSystemPromptSource(a.configSystemPromptSource(config.SystemPromptSource{Name: "team", Priority: 5, File: "team.md"}))
+
+	if _, err := a.SendMessage(ctx, []Message{{Role: "user", Content: "hello"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if !strings.HasSuffix(fake.lastRequest().Messages[0].Content, "\n\nUse tabs.") {
+		t.Errorf("Expected the file's content in the system prompt, got %q", fake.lastRequest().Messages[0].Content)
+	}
+
+	os.WriteFile(path, []byte("Use spaces."), 0644)
+	if _, err := a.SendMessage(ctx, []Message{{Role: "user", Content: "again"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if !strings.HasSuffix(fake.lastRequest().Messages[0].Content, "\n\nUse spaces.") {
+		t.Errorf("Expected the edited file's content in the system prompt, got %q", fake.lastRequest().Messages[0].Content)
+	}
+}
+
+func TestProjectFactsComeFromTheToolDir(t *testing.T) {
+	project := t.TempDir()
+	os.WriteFile(filepath.Join(project, "go.mod"), []byte("module example.com/project\n\ngo 1.23\n"), 0644)
+
+	cfg := &config.Config{CWD: t.TempDir(), WorkingDir: project}
+	if prompt := buildSystemPrompt(cfg, "", "Base prompt."); !strings.Contains(prompt, "Project facts") {
+		t.Errorf("Expected the facts of the working directory in the prompt, got:\n%s", prompt)
+	}
+}
@@ -0,0 +1,43 @@
+package agent
+
+import (
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// withSystemReminder returns messages with the configured reminder appended as
+// a system message when the number of completed assistant turns in h is a
+// multiple of cfg.SystemReminderEvery. The reminder is only part of the
+// request; it is never added to the history, so it does not accumulate.
+func withSystemReminder(messages []openai.ChatCompletionMessage, h *ConversationHistory, cfg *config.Config) []openai.ChatCompletionMessage {
+	if cfg == nil || cfg.SystemReminderEvery <= 0 {
+		return messages
+	}
+	turns := completedAssistantTurns(h)
+	if turns == 0 || turns%cfg.SystemReminderEvery != 0 {
+		return messages
+	}
+
+	reminder := cfg.SystemReminder
+	if reminder == "" {
+		reminder = config.DefaultSystemReminder
+	}
+	// Full slice expression in build means append copies rather than touching the cache
+	return append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: reminder,
+	})
+}
+
+// completedAssistantTurns counts the assistant replies in h that ended a turn,
+// i.e. those without tool calls. Tool call rounds within a turn are not counted,
+// so every request of the turn that follows gets the same decision.
+func completedAssistantTurns(h *ConversationHistory) int {
+	turns := 0
+	for _, msg := range h.GetMessages() {
+		if msg.Role == openai.ChatMessageRoleAssistant && len(msg.ToolCalls) == 0 && msg.Content != "" {
+			turns++
+		}
+	}
+	return turns
+}
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestSystemReminderSentEveryNTurns(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "one", "two", toolCallReply("shell", `{"command":"ls"}`), "three", "four")
+	a.config.SystemReminderEvery = 2
+	a.config.SystemReminder = "Remember the rules."
+
+	hasReminder := func() bool {
+		msgs := fake.lastRequest().Messages
+		last := msgs[len(msgs)-1]
+		return last.Role == openai.ChatMessageRoleSystem && last.Content == "Remember the rules."
+	}
+
+	for i, want := range []bool{false, false} {
+		if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: fmt.Sprintf("message %d", i)}}, func(string) {}); err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+		if hasReminder() != want {
+			t.Errorf("Request %d: expected reminder %t", i, want)
+		}
+	}
+
+	// Two turns have completed, so both requests of the third turn carry it
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "list files"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if !hasReminder() {
+		t.Errorf("Expected the reminder after two turns")
+	}
+	if err := a.SendFunctionResult(context.Background(), "call_3", "shell", "main.go", true); err != nil {
+		t.Fatalf("SendFunctionResult failed: %v", err)
+	}
+	if !hasReminder() {
+		t.Errorf("Expected the reminder on the follow-up request")
+	}
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "again"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if hasReminder() {
+		t.Errorf("Expected no reminder after three turns")
+	}
+
+	// The reminder is never stored
+	for _, msg := range a.history.GetMessages() {
+		if msg.Content == "Remember the rules." {
+			t.Errorf("Expected the reminder to stay out of the history")
+		}
+	}
+	checkToolPairing(t, a.history.GetMessages())
+}
+
+func TestSystemReminderDisabledByDefault(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "one", "two", "three")
+	for i := 0; i < 3; i++ {
+		if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "hi"}}, func(string) {}); err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+		msgs := fake.lastRequest().Messages
+		if last := msgs[len(msgs)-1]; last.Role != openai.ChatMessageRoleUser {
+			t.Errorf("Request %d: expected the user message last, got %+v", i, last)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"strings"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// thinkFilter splits inline reasoning blocks, such as <think>...</think>,
+// out of the streamed text of a response. The reasoning is sent to the
+// handler as "reasoning" updates; only the text outside the blocks is part of
+// the answer. Tags may be split across deltas, so text that could be the
+// start of a tag is held back until the next delta tells.
+type thinkFilter struct {
+	tags      []string        // Tag names, e.g. "think"
+	closing   string          // Closing tag of the block being read, "" outside blocks
+	pending   string          // Text held back because it may start a tag
+	answered  bool            // Whether answer text was returned yet
+	trimStart bool            // Whether leading whitespace of the answer is dropped
+	reasoning strings.Builder // Reasoning of the response so far
+	updates   *messageFlusher // Coalesces the reasoning updates
+	started   time.Time
+}
+
+// newThinkFilter creates a filter for the think tags of cfg, or nil when none
+// are configured. A nil filter passes all text through.
+func newThinkFilter(cfg *config.Config, started time.Time) *thinkFilter {
+	if len(cfg.ThinkTags) == 0 {
+		return nil
+	}
+	return &thinkFilter{tags: cfg.ThinkTags, updates: newMessageFlusher(cfg, ""), started: started}
+}
+
+// split returns the part of delta that belongs to the answer, sending the
+// reasoning it contains to handler
+func (f *thinkFilter) split(handler ResponseHandler, delta string) string {
+	if f == nil {
+		return delta
+	}
+	var answer strings.Builder
+	reasoned := false
+	text := f.pending + delta
+	f.pending = ""
+	for text != "" {
+		if f.closing != "" {
+			if i := strings.Index(text, f.closing); i >= 0 {
+				f.reasoning.WriteString(text[:i])
+				text = text[i+len(f.closing):]
+				f.closing = ""
+				f.trimStart = !f.answered
+				reasoned = true
+				continue
+			}
+			keep := partialTagSuffix(text, f.closing)
+			f.reasoning.WriteString(text[:len(text)-keep])
+			f.pending = text[len(text)-keep:]
+			reasoned = reasoned || keep < len(text)
+			break
+		}
+
+		start, tag := -1, ""
+		for _, name := range f.tags {
+			if i := strings.Index(text, "<"+name+">"); i >= 0 && (start < 0 || i < start) {
+				start, tag = i, name
+			}
+		}
+		if start >= 0 {
+			answer.WriteString(text[:start])
+			text = text[start+len(tag)+2:]
+			f.closing = "</" + tag + ">"
+			continue
+		}
+		keep := 0
+		for _, name := range f.tags {
+			keep = max(keep, partialTagSuffix(text, "<"+name+">"))
+		}
+		answer.WriteString(text[:len(text)-keep])
+		f.pending = text[len(text)-keep:]
+		break
+	}
+
+	if reasoned {
+		f.sendReasoning(handler)
+	}
+	text = f.answer(answer.String())
+	if text != "" {
+		// The reasoning held back goes out before the answer that follows it
+		f.updates.flush(handler)
+	}
+	return text
+}
+
+// finish returns the text still held back, ending the response. A block that
+// was never closed is reasoning.
+func (f *thinkFilter) finish(handler ResponseHandler) string {
+	if f == nil {
+		return ""
+	}
+	text := f.pending
+	f.pending = ""
+	if f.closing != "" {
+		f.reasoning.WriteString(text)
+		f.sendReasoning(handler)
+		f.closing, text = "", ""
+	}
+	f.updates.flush(handler)
+	return f.answer(text)
+}
+
+// reset drops everything read, for a response that is requested again
+func (f *thinkFilter) reset() {
+	if f == nil {
+		return
+	}
+	f.closing, f.pending = "", ""
+	f.answered, f.trimStart = false, false
+	f.reasoning.Reset()
+	f.updates.discard()
+}
+
+// answer drops the whitespace that separates a leading reasoning block from the answer
+func (f *thinkFilter) answer(text string) string {
+	if f.trimStart {
+		text = strings.TrimLeft(text, " \t\r\n")
+	}
+	if text != "" {
+		f.answered, f.trimStart = true, false
+	}
+	return text
+}
+
+// sendReasoning passes the reasoning so far to handler as a "reasoning" update
+func (f *thinkFilter) sendReasoning(handler ResponseHandler) {
+	f.updates.send(handler, ResponseItem{
+		Type:             EventReasoning,
+		Message:          &Message{Role: openai.ChatMessageRoleAssistant, Content: strings.TrimSpace(f.reasoning.String())},
+		ThinkingDuration: time.Since(f.started).Milliseconds(),
+	})
+}
+
+// partialTagSuffix returns the length of the longest proper prefix of tag
+// that text ends with
+func partialTagSuffix(text, tag string) int {
+	for n := min(len(tag)-1, len(text)); n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+func TestThinkFilterSplitsTagsAcrossDeltas(t *testing.T) {
+	var items []ResponseItem
+	handler := collectItems(&items)
+	f := newThinkFilter(&config.Config{ThinkTags: []string{"think", "scratchpad"}}, time.Now())
+
+	var answer string
+	for _, delta := range []string{"<thi", "nk>Check the ", "tests first.</th", "ink>\n\nThe tests ", "pass. <scratch", "pad>done</scratchpad> Bye"} {
+		answer += f.split(handler, delta)
+	}
+	answer += f.finish(handler)
+
+	if answer != "The tests pass.  Bye" {
+		t.Errorf("Expected only the answer outside the blocks, got %q", answer)
+	}
+	if len(items) == 0 {
+		t.Fatalf("Expected reasoning updates")
+	}
+	last := items[len(items)-1]
+	if last.Type != "reasoning" || last.Message.Content != "Check the tests first.done" {
+		t.Errorf("Expected the reasoning of both blocks, got %+v", last)
+	}
+}
+
+func TestThinkFilterKeepsUnclosedBlockAsReasoning(t *testing.T) {
+	var items []ResponseItem
+	f := newThinkFilter(&config.Config{ThinkTags: []string{"think"}}, time.Now())
+
+	answer := f.split(collectItems(&items), "<think>I ran out of tokens <")
+	answer += f.finish(collectItems(&items))
+	if answer != "" {
+		t.Errorf("Expected no answer, got %q", answer)
+	}
+	if len(items) == 0 || items[len(items)-1].Message.Content != "I ran out of tokens <" {
+		t.Errorf("Expected the unclosed block as reasoning, got %+v", items)
+	}
+}
+
+func TestThinkFilterPassesTextThroughWithoutTags(t *testing.T) {
+	if f := newThinkFilter(&config.Config{}, time.Now()); f != nil {
+		t.Fatalf("Expected no filter without think tags")
+	}
+	var f *thinkFilter
+	if got := f.split(func(string) {}, "a <think> b"); got != "a <think> b" {
+		t.Errorf("Expected a nil filter to pass text through, got %q", got)
+	}
+
+	// Text that merely looks like the start of a tag is released once it is not one
+	f = newThinkFilter(&config.Config{ThinkTags: []string{"think"}}, time.Now())
+	got := f.split(func(string) {}, "x <th")
+	got += f.split(func(string) {}, "e> y")
+	got += f.finish(func(string) {})
+	if got != "x <the> y" {
+		t.Errorf("Expected the text unchanged, got %q", got)
+	}
+}
+
+func TestThinkTagsAreKeptOutOfHistory(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, "<think>The user wants a greeting.</think>\n\nHello!")
+	a.config.ThinkTags = []string{"think"}
+
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Hi"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if len(items) != 2 || items[0].Type != "reasoning" || items[0].Message.Content != "The user wants a greeting." {
+		t.Fatalf("Expected a reasoning item before the answer, got %+v", items)
+	}
+	if items[1].Type != "message" || items[1].Message.Content != "Hello!" {
+		t.Errorf("Expected the answer without the block, got %+v", items[1])
+	}
+	last, _ := a.history.GetLastMessage()
+	if last.Content != "Hello!" || strings.Contains(last.Content, "think") {
+		t.Errorf("Expected only the answer in the history, got %q", last.Content)
+	}
+}
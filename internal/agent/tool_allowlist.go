@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// SetAllowedTools limits the session to the named tools: only they are
+// offered to the model, and a call to any other tool is answered with a
+// permission-denied result instead of being dispatched. Unlike tool overrides,
+// which shape the tools the agent is created with, the allowlist holds for
+// every request of the session until it is changed. A nil or empty list
+// allows every tool again; an unknown name is an error and changes nothing.
+func (a *OpenAIAgent) SetAllowedTools(names []string) error {
+	if len(names) == 0 {
+		a.mu.Lock()
+		a.allowedTools = nil
+		a.allowedAPITools = nil
+		a.mu.Unlock()
+		return nil
+	}
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !a.hasTool(name) {
+			return fmt.Errorf("cannot allow unknown tool %q", name)
+		}
+		allowed[name] = true
+	}
+	var apiTools []openai.Tool
+	for i, tool := range a.tools {
+		if allowed[tool.Function.Name] {
+			apiTools = append(apiTools, a.apiTools[i])
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.allowedTools = allowed
+	a.allowedAPITools = apiTools
+	a.logger.Log("[INFO] Agent.SetAllowedTools: Session limited to %d of %d tools.", len(allowed), len(a.tools))
+	return nil
+}
+
+// AllowedTools returns the names of the tools the session is limited to,
+// sorted, or nil when every tool is allowed
+func (a *OpenAIAgent) AllowedTools() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.allowedTools == nil {
+		return nil
+	}
+	names := make([]string, 0, len(a.allowedTools))
+	for name := range a.allowedTools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ToolAllowed reports whether the session may call the named tool
+func (a *OpenAIAgent) ToolAllowed(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.allowedTools == nil || a.allowedTools[name]
+}
+
+// requestTools returns the tools offered to the model with a request: all of
+// them, or those of the allowlist, and request_stronger_model while it is offered
+func (a *OpenAIAgent) requestTools() []openai.Tool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.allowedTools == nil {
+		return a.withEscalationTool(a.apiTools)
+	}
+	return a.withEscalationTool(a.allowedAPITools)
+}
+
+// deniedToolError explains that a tool exists but is not allowed in the session
+func deniedToolError(name string) string {
+	return fmt.Sprintf("permission denied: tool %q is not allowed in this session", name)
+}
+
+// sendDeniedToolWarning tells the handler the model called a tool outside the allowlist
+func sendDeniedToolWarning(handler ResponseHandler, name string) {
+	sendWarning(handler, fmt.Sprintf("The model called %q, which is not allowed in this session; the call was denied.", name))
+}
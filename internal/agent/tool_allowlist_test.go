@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestAllowedToolsFilterRequestsAndDispatch(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, toolCallReply("shell", `{"command":["rm","-rf","build"]}`), "I may only read files here.")
+	if err := a.SetAllowedTools([]string{"read_file", "no_such_tool"}); err == nil || !strings.Contains(err.Error(), "no_such_tool") {
+		t.Fatalf("Expected an error naming the unknown tool, got %v", err)
+	}
+	if a.AllowedTools() != nil {
+		t.Fatalf("Expected a failed SetAllowedTools to change nothing, got %v", a.AllowedTools())
+	}
+	if err := a.SetAllowedTools([]string{"read_file"}); err != nil {
+		t.Fatalf("SetAllowedTools failed: %v", err)
+	}
+
+	var items []ResponseItem
+	endedWithTools, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Clean the build"}}, collectItems(&items))
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if endedWithTools {
+		t.Errorf("Expected the denied call not to be dispatched")
+	}
+	warned := false
+	for _, item := range items {
+		if item.Type == EventFunctionCall {
+			t.Errorf("Expected no function_call item for a disallowed tool, got %+v", item.FunctionCall)
+		}
+		warned = warned || (item.Type == EventWarning && strings.Contains(item.Message.Content, "not allowed"))
+	}
+	if !warned {
+		t.Errorf("Expected a warning about the disallowed call")
+	}
+
+	fake.mu.Lock()
+	requests := append([]openai.ChatCompletionRequest(nil), fake.requests...)
+	fake.mu.Unlock()
+	for i, req := range requests {
+		if len(req.Tools) != 1 || req.Tools[0].Function.Name != "read_file" {
+			t.Errorf("Expected request %d to offer only read_file, got %d tools", i, len(req.Tools))
+		}
+	}
+	var denial string
+	for _, msg := range fake.lastRequest().Messages {
+		if msg.Role == openai.ChatMessageRoleTool {
+			denial = msg.Content
+		}
+	}
+	if result, ok := ParseToolResult(denial); !ok || result.Error != deniedToolError("shell") {
+		t.Errorf("Expected a permission-denied tool result, got %q", denial)
+	}
+
+	if err := a.SetAllowedTools(nil); err != nil || a.AllowedTools() != nil || !a.ToolAllowed("shell") {
+		t.Errorf("Expected a nil list to allow every tool again")
+	}
+}
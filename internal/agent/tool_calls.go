@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// streamedCall is a tool call being assembled from stream deltas
+type streamedCall struct {
+	ID string
+	openai.FunctionCall
+}
+
+// toolCallAccumulator assembles streamed tool call deltas into calls. Deltas
+// are matched to calls by their index, so two calls that a provider sends
+// with the same ID stay distinct; the later one gets the ID suffixed with its
+// index. Deltas without an index are matched by ID.
+type toolCallAccumulator struct {
+	calls   []*streamedCall // In order of first appearance
+	byIndex map[int]*streamedCall
+	byID    map[string]*streamedCall
+	warn    func(format string, args ...interface{})
+}
+
+// newToolCallAccumulator creates an accumulator that reports duplicate IDs to warn
+func newToolCallAccumulator(warn func(format string, args ...interface{})) *toolCallAccumulator {
+	return &toolCallAccumulator{
+		byIndex: make(map[int]*streamedCall),
+		byID:    make(map[string]*streamedCall),
+		warn:    warn,
+	}
+}
+
+// add merges a delta into the call it belongs to, starting a new call if
+// needed, and returns that call
+func (acc *toolCallAccumulator) add(delta openai.ToolCall) *streamedCall {
+	call := acc.find(delta)
+	if call == nil {
+		call = &streamedCall{FunctionCall: openai.FunctionCall{Name: delta.Function.Name}}
+		acc.calls = append(acc.calls, call)
+		if delta.Index != nil {
+			acc.byIndex[*delta.Index] = call
+		}
+	}
+	if call.ID == "" && delta.ID != "" {
+		acc.assignID(call, delta)
+	}
+	if call.Name == "" {
+		call.Name = delta.Function.Name
+	}
+	call.Arguments += delta.Function.Arguments
+	return call
+}
+
+// find returns the call delta continues, or nil if it starts a new one
+func (acc *toolCallAccumulator) find(delta openai.ToolCall) *streamedCall {
+	if delta.Index != nil {
+		return acc.byIndex[*delta.Index]
+	}
+	if delta.ID == "" {
+		// A continuation without index or ID extends the latest call
+		if len(acc.calls) == 0 {
+			return nil
+		}
+		return acc.calls[len(acc.calls)-1]
+	}
+	call := acc.byID[delta.ID]
+	if call != nil && delta.Function.Name != "" && call.Name != "" {
+		// A second named start under a known ID is a distinct call
+		return nil
+	}
+	return call
+}
+
+// assignID gives call the delta's ID, disambiguating an ID already in use
+func (acc *toolCallAccumulator) assignID(call *streamedCall, delta openai.ToolCall) {
+	id := delta.ID
+	if _, taken := acc.byID[id]; taken {
+		suffix := len(acc.calls) - 1
+		if delta.Index != nil {
+			suffix = *delta.Index
+		}
+		id = fmt.Sprintf("%s_%d", delta.ID, suffix)
+		for n := 2; acc.byID[id] != nil; n++ {
+			id = fmt.Sprintf("%s_%d_%d", delta.ID, suffix, n)
+		}
+		acc.warn("[WARN] Agent: Tool call ID %s is used by more than one call in this response; renamed the call to '%s' as %s.", delta.ID, delta.Function.Name, id)
+	}
+	call.ID = id
+	acc.byID[id] = call
+}
+
+// completed returns the calls with an ID, in the order they were started
+func (acc *toolCallAccumulator) completed() []*streamedCall {
+	calls := make([]*streamedCall, 0, len(acc.calls))
+	for _, call := range acc.calls {
+		if call.ID == "" {
+			acc.warn("[WARN] Agent: Dropping streamed tool call to '%s' that never received an ID.", call.Name)
+			continue
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
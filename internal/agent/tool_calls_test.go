@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// streamToolCallDeltas serves one response streaming deltas, then finishes with tool_calls
+func streamToolCallDeltas(t *testing.T, deltas []map[string]interface{}) *OpenAIAgent {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, delta := range deltas {
+			chunk := map[string]interface{}{
+				"id":      "chatcmpl-test",
+				"object":  "chat.completion.chunk",
+				"choices": []map[string]interface{}{{"index": 0, "delta": map[string]interface{}{"role": "assistant", "tool_calls": []interface{}{delta}}}},
+			}
+			data, _ := json.Marshal(chunk)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		finish := map[string]interface{}{
+			"id":      "chatcmpl-test",
+			"object":  "chat.completion.chunk",
+			"choices": []map[string]interface{}{{"index": 0, "delta": map[string]string{}, "finish_reason": "tool_calls"}},
+		}
+		data, _ := json.Marshal(finish)
+		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", data)
+	}))
+	t.Cleanup(ts.Close)
+
+	a, err := NewOpenAIAgent(&config.Config{APIKey: "test", Model: "gpt-4o", BaseURL: ts.URL}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	return a
+}
+
+func TestDuplicateToolCallIDsStayDistinct(t *testing.T) {
+	a := streamToolCallDeltas(t, []map[string]interface{}{
+		{"index": 0, "id": "call_dup", "type": "function", "function": map[string]string{"name": "shell", "arguments": `{"command":`}},
+		{"index": 0, "function": map[string]string{"arguments": `"ls"}`}},
+		{"index": 1, "id": "call_dup", "type": "function", "function": map[string]string{"name": "read_file", "arguments": `{"path":"go.mod"}`}},
+	})
+
+	var items []ResponseItem
+	endedWithTools, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Look around"}}, collectItems(&items))
+	if err != nil || !endedWithTools {
+		t.Fatalf("Expected the turn to end with tool calls, got %t, %v", endedWithTools, err)
+	}
+
+	want := map[string]FunctionCall{
+		"call_dup":   {Name: "shell", Arguments: `{"command":"ls"}`},
+		"call_dup_1": {Name: "read_file", Arguments: `{"path":"go.mod"}`},
+	}
+	if len(items) != 2 {
+		t.Fatalf("Expected two surfaced calls, got %+v", items)
+	}
+	for _, item := range items {
+		call := item.FunctionCall
+		if call == nil || want[call.ID].Name != call.Name || want[call.ID].Arguments != call.Arguments {
+			t.Errorf("Unexpected call %+v", call)
+		}
+	}
+	for id, call := range want {
+		tc, found := a.history.FindToolCall(id)
+		if !found || tc.Function.Name != call.Name || tc.Function.Arguments != call.Arguments {
+			t.Errorf("Expected the history to record %s as %+v, got %+v", id, call, tc)
+		}
+		if !a.IsToolCallPending(id) {
+			t.Errorf("Expected %s to be pending", id)
+		}
+	}
+}
+
+func TestDuplicateToolCallIDsWithoutIndex(t *testing.T) {
+	acc := newToolCallAccumulator(func(string, ...interface{}) {})
+	acc.add(openai.ToolCall{ID: "call_1", Function: openai.FunctionCall{Name: "shell", Arguments: `{"command":`}})
+	acc.add(openai.ToolCall{Function: openai.FunctionCall{Arguments: `"pwd"}`}})
+	acc.add(openai.ToolCall{ID: "call_1", Function: openai.FunctionCall{Name: "list_directory", Arguments: `{}`}})
+
+	calls := acc.completed()
+	if len(calls) != 2 {
+		t.Fatalf("Expected two calls, got %d", len(calls))
+	}
+	if calls[0].ID != "call_1" || calls[0].Arguments != `{"command":"pwd"}` {
+		t.Errorf("Unexpected first call %+v", calls[0])
+	}
+	if calls[1].ID == "call_1" || calls[1].Name != "list_directory" {
+		t.Errorf("Expected the second call to get its own ID, got %+v", calls[1])
+	}
+}
+
+func TestFollowUpCompletesOnlyWithoutToolCalls(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, toolCallReply("shell", `{"command":"ls"}`), toolCallReply("read_file", `{"path":"main.go"}`), "Done.")
+	var items []ResponseItem
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Read the main file"}}, collectItems(&items)); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	for _, next := range []struct {
+		name      string
+		wantCalls int
+		wantDone  int
+	}{{name: "shell", wantCalls: 1}, {name: "read_file", wantDone: 1}} {
+		var call *FunctionCall
+		for _, item := range items {
+			if item.Type == "function_call" {
+				call = item.FunctionCall
+			}
+		}
+		if call == nil || call.Name != next.name {
+			t.Fatalf("Expected a call to %s, got %+v", next.name, items)
+		}
+		items = nil
+		if err := a.SendFunctionResult(context.Background(), call.ID, call.Name, "ok", true); err != nil {
+			t.Fatalf("SendFunctionResult failed: %v", err)
+		}
+		if n := countItems(items, "function_call"); n != next.wantCalls {
+			t.Errorf("Expected %d calls after the %s result, got %d", next.wantCalls, next.name, n)
+		}
+		if n := countItems(items, EventFollowupComplete); n != next.wantDone {
+			t.Errorf("Expected %d followup_complete after the %s result, got %d in %+v", next.wantDone, next.name, n, items)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// collapsedToolErrorContent replaces earlier copies of a repeated tool error in history
+var collapsedToolErrorContent = ToolResult{Error: "(collapsed: same failure as a later attempt with identical arguments)"}.Content()
+
+// toolErrorGuard tracks failing tool calls so repeated identical failures can be
+// collapsed in history and answered with a stronger corrective message.
+type toolErrorGuard struct {
+	mu        sync.Mutex
+	threshold int                 // Failures before the guard triggers (<= 0 disables)
+	failures  map[string][]string // Signature -> call IDs of failed results, oldest first
+}
+
+// newToolErrorGuard creates a guard that triggers after threshold identical failures
+func newToolErrorGuard(threshold int) *toolErrorGuard {
+	return &toolErrorGuard{
+		threshold: threshold,
+		failures:  make(map[string][]string),
+	}
+}
+
+// record registers a tool result. For failures it returns the call IDs of earlier
+// identical failures that should be collapsed and the total failure count so far.
+// A success clears the failure streak for that signature.
+func (g *toolErrorGuard) record(signature, callID string, success bool) (collapse []string, count int) {
+	if g == nil || g.threshold <= 0 {
+		return nil, 0
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if success {
+		delete(g.failures, signature)
+		return nil, 0
+	}
+
+	ids := append(g.failures[signature], callID)
+	g.failures[signature] = ids
+	if len(ids) < g.threshold {
+		return nil, len(ids)
+	}
+	return ids[:len(ids)-1], len(ids)
+}
+
+// reset forgets all tracked failures
+func (g *toolErrorGuard) reset() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	g.failures = make(map[string][]string)
+	g.mu.Unlock()
+}
+
+// toolCallSignature identifies "the same call" by tool name and normalized arguments.
+// Arguments are re-encoded so whitespace and key-order differences are ignored.
+func toolCallSignature(name, arguments string) string {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(arguments), &parsed); err == nil {
+		if normalized, err := json.Marshal(parsed); err == nil {
+			return name + "\x00" + string(normalized)
+		}
+	}
+	return name + "\x00" + strings.TrimSpace(arguments)
+}
+
+// repeatedToolError builds the tool result for a failure that hit the repeat threshold
+func repeatedToolError(result ToolResult, count int) ToolResult {
+	metadata := make(map[string]string, len(result.Metadata)+1)
+	for key, value := range result.Metadata {
+		metadata[key] = value
+	}
+	metadata["notice"] = fmt.Sprintf("STOP: '%s' has now failed %d times with the same arguments and the same kind of error. "+
+		"Do not retry this call unchanged. Re-read the relevant files or directory to check your assumptions, "+
+		"change the arguments, try a different tool, or explain the problem to the user.", result.Name, count)
+	result.Metadata = metadata
+	return result
+}
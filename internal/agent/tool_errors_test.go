@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+func TestToolCallSignatureNormalizesArguments(t *testing.T) {
+	a := toolCallSignature("patch_file", `{"path": "a.go",  "patch": "x"}`)
+	b := toolCallSignature("patch_file", `{"patch":"x","path":"a.go"}`)
+	if a != b {
+		t.Errorf("Expected equal signatures for equivalent arguments, got %q and %q", a, b)
+	}
+	if a == toolCallSignature("write_file", `{"patch":"x","path":"a.go"}`) {
+		t.Errorf("Expected different signatures for different tools")
+	}
+}
+
+func TestToolErrorGuardThresholdAndReset(t *testing.T) {
+	g := newToolErrorGuard(3)
+
+	if collapse, count := g.record("sig", "1", false); len(collapse) != 0 || count != 1 {
+		t.Fatalf("Expected no collapse after first failure, got %v (count %d)", collapse, count)
+	}
+	g.record("sig", "2", false)
+	collapse, count := g.record("sig", "3", false)
+	if count != 3 || len(collapse) != 2 || collapse[0] != "1" || collapse[1] != "2" {
+		t.Fatalf("Expected to collapse [1 2] at count 3, got %v (count %d)", collapse, count)
+	}
+
+	// A success ends the streak
+	g.record("sig", "4", true)
+	if _, count := g.record("sig", "5", false); count != 1 {
+		t.Errorf("Expected streak to restart after success, got count %d", count)
+	}
+
+	disabled := newToolErrorGuard(-1)
+	for i := 0; i < 5; i++ {
+		if collapse, _ := disabled.record("sig", fmt.Sprint(i), false); len(collapse) != 0 {
+			t.Fatalf("Expected disabled guard to never collapse")
+		}
+	}
+}
+
+func TestSendFunctionResultCollapsesRepeatedErrors(t *testing.T) {
+	a, err := NewOpenAIAgent(&config.Config{APIKey: "test", Model: "gpt-4o", ToolErrorRepeatThreshold: 2}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		callID := fmt.Sprintf("call_%d", i)
+		a.history.AddToolMessage("patch_file", map[string]interface{}{"patch_content": "// FILE: a.go"}, callID)
+		a.pendingToolCalls[callID] = true
+		a.state = StateAwaitingToolResults
+		if err := a.SendFunctionResult(context.Background(), callID, "patch_file", "anchor not found", false); err != nil {
+			t.Fatalf("SendFunctionResult failed: %v", err)
+		}
+	}
+
+	var first, second string
+	for _, msg := range a.history.GetMessages() {
+		switch msg.ToolCallID {
+		case "call_1":
+			first = msg.Content
+		case "call_2":
+			second = msg.Content
+		}
+	}
+	if first != collapsedToolErrorContent {
+		t.Errorf("Expected first error to be collapsed, got %s", first)
+	}
+	if !strings.Contains(second, "anchor not found") || !strings.Contains(second, "STOP") {
+		t.Errorf("Expected latest error with corrective notice, got %s", second)
+	}
+}
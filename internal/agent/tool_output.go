@@ -0,0 +1,301 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// SummaryStrategy selects how large tool outputs are condensed for the model
+type SummaryStrategy string
+
+const (
+	// SummaryNone sends tool output to the model verbatim
+	SummaryNone SummaryStrategy = "none"
+	// SummaryExtract keeps the exit status, error-looking lines and the tail of the output
+	SummaryExtract SummaryStrategy = "extract"
+	// SummaryTruncate keeps the head and tail of the output up to the size cap
+	SummaryTruncate SummaryStrategy = "truncate"
+	// SummaryModel asks a cheap model to summarize the output, falling back to SummaryExtract
+	SummaryModel SummaryStrategy = "model"
+)
+
+const (
+	summaryTailLines  = 20 // Trailing lines kept by the extract strategy
+	summaryMaxMatches = 30 // Error-looking lines kept by the extract strategy
+	summaryModelInput = 48 * 1024
+	toolOutputDirName = "tool-outputs" // Directory of the project's .codex directory full outputs are saved in by default
+)
+
+// errorLinePattern matches lines that usually explain a failure
+var errorLinePattern = regexp.MustCompile(`(?i)\b(error|fail(ed|ure)?|panic|fatal|exception|traceback)\b|^\s*---\s*FAIL`)
+
+// exitCodePattern matches the exit status the app reports for failed commands
+var exitCodePattern = regexp.MustCompile(`(?i)(exit code|code) (-?\d+)`)
+
+// fullOutputTools return exactly what the model asked for, so their output is
+// only condensed when a strategy is configured for them by name
+var fullOutputTools = map[string]bool{
+	"read_file": true,
+}
+
+// summaryStrategyFor returns the configured strategy for a tool, defaulting to
+// extract, or to none for fullOutputTools
+func summaryStrategyFor(cfg *config.Config, functionName string) SummaryStrategy {
+	if strategy, ok := cfg.ToolOutputSummaryStrategies[functionName]; ok {
+		return SummaryStrategy(strings.ToLower(strategy))
+	}
+	if fullOutputTools[functionName] {
+		return SummaryNone
+	}
+	if cfg.ToolOutputSummaryStrategies != nil {
+		if strategy, ok := cfg.ToolOutputSummaryStrategies["*"]; ok {
+			return SummaryStrategy(strings.ToLower(strategy))
+		}
+	}
+	return SummaryExtract
+}
+
+// toolOutputLimit returns the size above which a tool's output is condensed.
+// A per-tool cap takes precedence over the "*" cap and the global threshold.
+func toolOutputLimit(cfg *config.Config, functionName string) int {
+	if limit, ok := cfg.ToolOutputMaxSizes[functionName]; ok {
+		return limit
+	}
+	if limit, ok := cfg.ToolOutputMaxSizes["*"]; ok {
+		return limit
+	}
+	return cfg.ToolOutputSummaryThreshold
+}
+
+// condenseToolOutput returns the version of a tool output that goes into history.
+// Outputs under the tool's size cap are returned unchanged; larger ones are saved
+// in full to disk and replaced by a summary or truncation that fits the cap, with
+// a note stating the cap and pointing to the saved file.
+func (a *OpenAIAgent) condenseToolOutput(ctx context.Context, callID, functionName, output string) string {
+	limit := toolOutputLimit(a.config, functionName)
+	if limit <= 0 || len(output) <= limit {
+		return output
+	}
+
+	strategy := summaryStrategyFor(a.config, functionName)
+	if strategy == SummaryNone {
+		return output
+	}
+
+	var summary string
+	how := "condensed"
+	switch strategy {
+	case SummaryModel:
+		var err error
+		summary, err = a.summarizeWithModel(ctx, functionName, output)
+		if err != nil {
+			a.logger.Log("[WARN] Agent.condenseToolOutput: Model summary failed, using extraction: %v", err)
+			summary = ""
+		} else {
+			how = "summarized"
+		}
+	case SummaryTruncate:
+		summary = truncateOutput(output, limit)
+		how = "truncated"
+	}
+	if summary == "" {
+		summary = extractOutputSummary(output)
+	}
+	summary = truncateOutput(summary, limit)
+
+	lineCount := strings.Count(output, "\n") + 1
+	note := fmt.Sprintf("[Output of %s exceeded its %d-byte limit (%d bytes, %d lines) and was %s.", functionName, limit, len(output), lineCount, how)
+	// The audit log points to the saved output rather than holding a copy,
+	// so redacting the result can remove every copy
+	if path, err := a.saveFullToolOutput(callID, output); err != nil {
+		a.logger.Log("[ERROR] Agent.condenseToolOutput: Failed to save full output: %v", err)
+	} else {
+		a.logger.Log("[AUDIT] Agent.condenseToolOutput: Full output for %s (CallID: %s, %d bytes) saved to %s", functionName, callID, len(output), path)
+		note += fmt.Sprintf(" Full output saved to %s; use read_file with start_line/end_line to inspect it.", path)
+	}
+	note += " Narrow the request (filter with grep, limit with head/tail, or read a line range) to see more.]"
+
+	return summary + "\n\n" + note
+}
+
+// truncateOutput cuts output to about limit bytes, keeping its head and tail
+// at line boundaries around a marker
+func truncateOutput(output string, limit int) string {
+	if len(output) <= limit {
+		return output
+	}
+	half := limit / 2
+	head := output[:half]
+	if i := strings.LastIndex(head, "\n"); i > 0 {
+		head = head[:i+1]
+	}
+	tail := output[len(output)-half:]
+	if i := strings.Index(tail, "\n"); i >= 0 && i < len(tail)-1 {
+		tail = tail[i+1:]
+	}
+	omitted := len(output) - len(head) - len(tail)
+	return fmt.Sprintf("%s...[%d bytes truncated]...\n%s", head, omitted, tail)
+}
+
+// extractOutputSummary deterministically condenses output to its exit status,
+// error-looking lines and the last lines
+func extractOutputSummary(output string) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	var b strings.Builder
+
+	if m := exitCodePattern.FindStringSubmatch(output); m != nil {
+		fmt.Fprintf(&b, "Exit code: %s\n", m[2])
+	}
+
+	tailStart := len(lines) - summaryTailLines
+	if tailStart < 0 {
+		tailStart = 0
+	}
+
+	var matches []string
+	for i, line := range lines[:tailStart] {
+		if errorLinePattern.MatchString(line) {
+			matches = append(matches, fmt.Sprintf("%d: %s", i+1, line))
+			if len(matches) == summaryMaxMatches {
+				break
+			}
+		}
+	}
+	if len(matches) > 0 {
+		b.WriteString("Lines matching error/failure patterns:\n")
+		b.WriteString(strings.Join(matches, "\n"))
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, "Last %d lines:\n", len(lines)-tailStart)
+	b.WriteString(strings.Join(lines[tailStart:], "\n"))
+	return b.String()
+}
+
+// summarizeWithModel asks a cheap model for a short summary of a tool output
+func (a *OpenAIAgent) summarizeWithModel(ctx context.Context, functionName, output string) (string, error) {
+	// Keep the head and tail when the output is too large for a cheap call
+	input := output
+	if len(input) > summaryModelInput {
+		half := summaryModelInput / 2
+		input = input[:half] + "\n...[truncated]...\n" + input[len(input)-half:]
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp, err := a.sideCompletion(ctx, openai.ChatCompletionRequest{
+		Model: a.summaryModel(),
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: "Summarize the following tool output for a coding agent. Keep exit status, failing tests, error messages with file:line locations, and anything needed to decide the next step. Be concise.",
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: fmt.Sprintf("Output of %s:\n\n%s", functionName, input),
+			},
+		},
+		Temperature: 0,
+	})
+	if err != nil {
+		return "", fmt.Errorf("summary request failed: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("summary response had no choices")
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content), nil
+}
+
+// savedOutput is where the full output of a condensed tool result was saved
+type savedOutput struct {
+	path  string // File written to the tool output directory
+	hash  string // Blob in the project's blob store, when saved there
+	owner string // Session the blob was stored for
+}
+
+// saveFullToolOutput writes the full output to the tool output directory, or
+// to the project's blob store when no directory is configured
+func (a *OpenAIAgent) saveFullToolOutput(callID, output string) (string, error) {
+	if a.config.ToolOutputDir == "" && a.blobs != nil {
+		owner := a.SessionID()
+		hash, err := a.blobs.PutPlain(owner, []byte(output))
+		if err != nil {
+			return "", fmt.Errorf("failed to write tool output: %w", err)
+		}
+		a.rememberSavedOutput(callID, savedOutput{hash: hash, owner: owner})
+		return a.blobs.Path(hash), nil
+	}
+
+	// Outputs may hold file contents and secrets, so only the user may read
+	// them. By default they stay in the project, where read_file can reach
+	// them even when tools are confined to the workspace.
+	dir := a.config.ToolOutputDir
+	if dir == "" {
+		dir = filepath.Join(a.config.ToolDir(), ".codex", toolOutputDirName)
+	}
+	dir = filepath.Join(dir, a.sessionID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create tool output directory: %w", err)
+	}
+
+	name := callID
+	if name == "" {
+		name = fmt.Sprintf("output-%d", time.Now().UnixNano())
+	}
+	path := filepath.Join(dir, filepath.Base(name)+".log")
+	if err := os.WriteFile(path, []byte(output), 0600); err != nil {
+		return "", fmt.Errorf("failed to write tool output: %w", err)
+	}
+	a.rememberSavedOutput(callID, savedOutput{path: path})
+	return path, nil
+}
+
+// rememberSavedOutput records where the full output of callID was saved
+func (a *OpenAIAgent) rememberSavedOutput(callID string, saved savedOutput) {
+	if callID == "" {
+		return
+	}
+	a.pendingMu.Lock()
+	defer a.pendingMu.Unlock()
+	if a.savedOutputs == nil {
+		a.savedOutputs = make(map[string]savedOutput)
+	}
+	a.savedOutputs[callID] = saved
+}
+
+// discardSavedOutputs removes the saved full outputs of the given tool calls,
+// whose results were redacted or deleted. A blob is released for garbage
+// collection, as other sessions may hold the same content.
+func (a *OpenAIAgent) discardSavedOutputs(callIDs []string) error {
+	var errs []error
+	for _, callID := range callIDs {
+		a.pendingMu.Lock()
+		saved, ok := a.savedOutputs[callID]
+		delete(a.savedOutputs, callID)
+		a.pendingMu.Unlock()
+		if !ok {
+			continue
+		}
+		if saved.path != "" {
+			if err := os.Remove(saved.path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, fmt.Errorf("failed to remove saved output of %s: %w", callID, err))
+			}
+		}
+		if saved.hash != "" && a.blobs != nil {
+			if err := a.blobs.Release(saved.owner, saved.hash); err != nil {
+				errs = append(errs, fmt.Errorf("failed to release saved output of %s: %w", callID, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
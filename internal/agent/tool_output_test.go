@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+func TestExtractOutputSummary(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 100; i++ {
+		lines = append(lines, fmt.Sprintf("ok line %d", i))
+	}
+	lines[9] = "--- FAIL: TestSomething (0.00s)"
+	output := "Command Failed (code 1): " + strings.Join(lines, "\n")
+
+	summary := extractOutputSummary(output)
+	if !strings.Contains(summary, "Exit code: 1") {
+		t.Errorf("Expected exit code in summary, got %q", summary)
+	}
+	if !strings.Contains(summary, "10: --- FAIL: TestSomething") {
+		t.Errorf("Expected failing line with its number in summary, got %q", summary)
+	}
+	if !strings.Contains(summary, "ok line 100") || strings.Contains(summary, "ok line 50\n") {
+		t.Errorf("Expected only the tail of the output, got %q", summary)
+	}
+}
+
+func TestCondenseToolOutputSavesFullOutput(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewOpenAIAgent(&config.Config{
+		APIKey:                      "test",
+		Model:                       "gpt-4o",
+		ToolOutputSummaryThreshold:  100,
+		ToolOutputDir:               dir,
+		ToolOutputSummaryStrategies: map[string]string{"list_directory": "none"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	small := "short output"
+	if got := a.condenseToolOutput(context.Background(), "call_0", "shell", small); got != small {
+		t.Errorf("Expected small output unchanged, got %q", got)
+	}
+
+	large := strings.Repeat("build step ok\n", 50)
+	if got := a.condenseToolOutput(context.Background(), "call_1", "list_directory", large); got != large {
+		t.Errorf("Expected 'none' strategy to keep output verbatim")
+	}
+	if got := a.condenseToolOutput(context.Background(), "call_1", "read_file", large); got != large {
+		t.Errorf("Expected read_file output to be kept whole by default")
+	}
+
+	got := a.condenseToolOutput(context.Background(), "call_2", "shell", large)
+	if len(got) >= len(large) {
+		t.Errorf("Expected condensed output to be shorter than %d bytes, got %d", len(large), len(got))
+	}
+
+	m := regexp.MustCompile(`saved to (\S+);`).FindStringSubmatch(got)
+	if m == nil {
+		t.Fatalf("Expected pointer to the saved output, got %q", got)
+	}
+	saved, err := os.ReadFile(m[1])
+	if err != nil {
+		t.Fatalf("Failed to read saved output: %v", err)
+	}
+	if string(saved) != large {
+		t.Errorf("Expected saved output to match the original")
+	}
+}
+
+func TestFullOutputIsSavedPrivatelyInTheProject(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewOpenAIAgent(&config.Config{
+		APIKey:                     "test",
+		Model:                      "gpt-4o",
+		WorkingDir:                 dir,
+		ToolOutputSummaryThreshold: 100,
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	got := a.condenseToolOutput(context.Background(), "call_1", "shell", strings.Repeat("build step ok\n", 50))
+	m := regexp.MustCompile(`saved to (\S+);`).FindStringSubmatch(got)
+	if m == nil {
+		t.Fatalf("Expected pointer to the saved output, got %q", got)
+	}
+	if !strings.HasPrefix(m[1], filepath.Join(dir, ".codex", toolOutputDirName)+string(filepath.Separator)) {
+		t.Errorf("Expected the output saved in the project's .codex directory, got %s", m[1])
+	}
+	info, err := os.Stat(m[1])
+	if err != nil {
+		t.Fatalf("Failed to stat saved output: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the saved output readable by the user only, got %v", info.Mode().Perm())
+	}
+	if info, err := os.Stat(filepath.Dir(m[1])); err != nil {
+		t.Errorf("Failed to stat the output directory: %v", err)
+	} else if info.Mode().Perm() != 0700 {
+		t.Errorf("Expected the output directory private to the user, got %v", info.Mode().Perm())
+	}
+}
+
+func TestPerToolOutputCapTruncates(t *testing.T) {
+	a, err := NewOpenAIAgent(&config.Config{
+		APIKey:                      "test",
+		Model:                       "gpt-4o",
+		ToolOutputSummaryThreshold:  10000,
+		ToolOutputMaxSizes:          map[string]int{"shell": 200, "*": 5000},
+		ToolOutputDir:               t.TempDir(),
+		ToolOutputSummaryStrategies: map[string]string{"shell": "truncate"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+
+	var lines []string
+	for i := 1; i <= 1000; i++ {
+		lines = append(lines, fmt.Sprintf("log line %d", i))
+	}
+	output := strings.Join(lines, "\n")
+
+	got := a.condenseToolOutput(context.Background(), "call_1", "shell", output)
+	body, note, _ := strings.Cut(got, "\n\n[")
+	if len(body) > 250 {
+		t.Errorf("Expected the output to be cut to about 200 bytes, got %d", len(body))
+	}
+	if !strings.HasPrefix(body, "log line 1\n") || !strings.HasSuffix(body, "log line 1000") || !strings.Contains(body, "bytes truncated]") {
+		t.Errorf("Expected the head and tail around a marker, got %q", body)
+	}
+	if !strings.Contains(note, "200-byte limit") || !strings.Contains(note, "Narrow the request") {
+		t.Errorf("Expected the note to state the cap, got %q", note)
+	}
+
+	// The "*" cap applies to tools without their own
+	if got := a.condenseToolOutput(context.Background(), "call_2", "list_directory", output); got == output {
+		t.Errorf("Expected the default cap to condense list_directory output")
+	}
+	if got := a.condenseToolOutput(context.Background(), "call_3", "list_directory", output[:4000]); got != output[:4000] {
+		t.Errorf("Expected output under the default cap to be unchanged")
+	}
+	if got := a.condenseToolOutput(context.Background(), "call_4", "read_file", output); got != output {
+		t.Errorf("Expected read_file output to be kept whole unless condensing is configured for it")
+	}
+
+	// Naming read_file opts it in
+	a.config.ToolOutputSummaryStrategies["read_file"] = "truncate"
+	if got := a.condenseToolOutput(context.Background(), "call_5", "read_file", output); got == output {
+		t.Errorf("Expected read_file output to be condensed once a strategy is configured for it")
+	}
+}
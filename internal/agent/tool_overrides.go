@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+// builtinTools returns every built-in tool, including those the config or
+// the environment leaves out, in the order they are offered
+func builtinTools(tools []ToolDefinition) []ToolDefinition {
+	all := append([]ToolDefinition{}, tools...)
+	all = append(all, askUserTool, askUserChoiceTool)
+	all = append(all, memoryTools...)
+	all = append(all, codeNavTools...)
+	return append(all, semanticSearchTool)
+}
+
+// applyToolOverrides applies the tools section of the config: disabled tools
+// are dropped, descriptions replaced and parameter descriptions amended. known
+// lists every built-in tool; overrides of other tools or of parameters a tool
+// does not have are an error naming the valid ones.
+func applyToolOverrides(tools, known []ToolDefinition, overrides map[string]config.ToolOverride) ([]ToolDefinition, error) {
+	if len(overrides) == 0 {
+		return tools, nil
+	}
+
+	byName := make(map[string]ToolDefinition, len(known))
+	for _, tool := range known {
+		byName[tool.Function.Name] = tool
+	}
+	for _, name := range slices.Sorted(maps.Keys(overrides)) {
+		tool, ok := byName[name]
+		if !ok {
+			valid := slices.Sorted(maps.Keys(byName))
+			return nil, fmt.Errorf("invalid tools config: unknown tool %q: expected one of %s", name, strings.Join(valid, ", "))
+		}
+		params := toolParameterNames(tool)
+		for _, param := range slices.Sorted(maps.Keys(overrides[name].Parameters)) {
+			if !slices.Contains(params, param) {
+				return nil, fmt.Errorf("invalid tools config: tool %q has no parameter %q: expected one of %s", name, param, strings.Join(params, ", "))
+			}
+		}
+	}
+
+	var result []ToolDefinition
+	for _, tool := range tools {
+		override, ok := overrides[tool.Function.Name]
+		if !ok {
+			result = append(result, tool)
+			continue
+		}
+		if override.Disabled {
+			continue
+		}
+		if override.Description != "" {
+			tool.Function.Description = override.Description
+		}
+		if len(override.Parameters) > 0 {
+			tool.Function.Parameters = amendParameters(tool.Function.Parameters, override.Parameters)
+		}
+		result = append(result, tool)
+	}
+	return result, nil
+}
+
+// toolParameterNames returns the names of a tool's parameters in declaration order
+func toolParameterNames(tool ToolDefinition) []string {
+	schema, _ := tool.Function.Parameters.(map[string]interface{})
+	properties, _ := schema["properties"].(OrderedMap)
+	names := make([]string, len(properties))
+	for i, kv := range properties {
+		names[i] = kv.Key
+	}
+	return names
+}
+
+// amendParameters returns a copy of a parameter schema with text appended to
+// the descriptions of the named parameters. The schema itself may be shared
+// between agents and is left untouched.
+func amendParameters(params interface{}, amendments map[string]string) interface{} {
+	schema, ok := params.(map[string]interface{})
+	if !ok {
+		return params
+	}
+	properties, ok := schema["properties"].(OrderedMap)
+	if !ok {
+		return params
+	}
+
+	amended := make(OrderedMap, len(properties))
+	for i, kv := range properties {
+		amended[i] = kv
+		text, ok := amendments[kv.Key]
+		property, isMap := kv.Value.(map[string]interface{})
+		if !ok || !isMap {
+			continue
+		}
+		property = maps.Clone(property)
+		if description, _ := property["description"].(string); description != "" {
+			property["description"] = description + " " + text
+		} else {
+			property["description"] = text
+		}
+		amended[i].Value = property
+	}
+
+	schema = maps.Clone(schema)
+	schema["properties"] = amended
+	return schema
+}
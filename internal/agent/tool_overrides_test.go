@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+func TestToolOverrides(t *testing.T) {
+	cfg := &config.Config{APIKey: "test", Model: "gpt-4o", DisableMemory: true, Tools: map[string]config.ToolOverride{
+		"write_file": {Disabled: true},
+		"shell":      {Description: "Run a command in the project", Parameters: map[string]string{"command": "Prefer make targets."}},
+	}}
+	a, err := NewOpenAIAgent(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewOpenAIAgent failed: %v", err)
+	}
+
+	var shell *ToolDefinition
+	for _, tool := range a.GetToolDefinitions() {
+		switch tool.Function.Name {
+		case "write_file":
+			t.Errorf("Expected write_file to be disabled")
+		case "shell":
+			shell = &tool
+		}
+	}
+	if shell == nil {
+		t.Fatalf("Expected the shell tool to be offered")
+	}
+	if shell.Function.Description != "Run a command in the project" {
+		t.Errorf("Expected the description to be replaced, got %q", shell.Function.Description)
+	}
+	params, _ := json.Marshal(shell.Function.Parameters)
+	if !strings.Contains(string(params), `"The shell command to execute Prefer make targets."`) {
+		t.Errorf("Expected the command description to be amended, got %s", params)
+	}
+
+	// The shared default schema is left as it was
+	b, err := NewOpenAIAgent(&config.Config{APIKey: "test", Model: "gpt-4o"}, nil)
+	if err != nil {
+		t.Fatalf("NewOpenAIAgent failed: %v", err)
+	}
+	params, _ = json.Marshal(b.GetToolDefinitions()[0].Function.Parameters)
+	if strings.Contains(string(params), "make targets") {
+		t.Errorf("Expected another agent's tools to be unaffected, got %s", params)
+	}
+}
+
+func TestToolOverridesRejectUnknownNames(t *testing.T) {
+	tests := []struct {
+		tools map[string]config.ToolOverride
+		want  string
+	}{
+		{map[string]config.ToolOverride{"writefile": {Disabled: true}}, `unknown tool "writefile": expected one of `},
+		{map[string]config.ToolOverride{"shell": {Parameters: map[string]string{"cmd": "x"}}}, `tool "shell" has no parameter "cmd": expected one of command, stdin_data`},
+	}
+	for _, tt := range tests {
+		_, err := NewOpenAIAgent(&config.Config{APIKey: "test", Model: "gpt-4o", Tools: tt.tools}, nil)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+		}
+	}
+
+	// Tools that are not offered in this environment can still be configured
+	if _, err := NewOpenAIAgent(&config.Config{APIKey: "test", Model: "gpt-4o", Tools: map[string]config.ToolOverride{"find_definition": {Disabled: true}}}, nil); err != nil {
+		t.Errorf("Expected an override of a code navigation tool to be accepted, got %v", err)
+	}
+}
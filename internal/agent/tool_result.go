@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/functions"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ToolResult is the outcome of one tool call. Its canonical JSON encoding,
+// returned by Content, is what the model sees as the tool message.
+type ToolResult struct {
+	CallID     string            `json:"-"`
+	Name       string            `json:"-"`
+	Success    bool              `json:"success"`
+	Output     string            `json:"output,omitempty"` // A command's stdout, also when it failed
+	Error      string            `json:"error,omitempty"`
+	Stderr     string            `json:"stderr,omitempty"`
+	ExitCode   *int              `json:"exit_code,omitempty"`   // Set for commands that ran
+	DurationMs int64             `json:"duration_ms,omitempty"` // Wall time of the call
+	Cwd        string            `json:"cwd,omitempty"`         // Where a command ran
+	Truncated  []string          `json:"truncated,omitempty"`   // Output streams of a command that were cut short
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// NewToolResult creates the result of a call that produced output, or that
+// failed with output as its error. The output of a shell tool, a
+// functions.ShellResult, is kept structured.
+func NewToolResult(callID, name, output string, success bool) ToolResult {
+	if shell, ok := functions.ParseShellResult(output); ok && functions.IsShellTool(name) {
+		return NewShellToolResult(callID, name, shell, success)
+	}
+	result := ToolResult{CallID: callID, Name: name, Success: success}
+	if success {
+		result.Output = output
+	} else {
+		result.Error = output
+	}
+	return result
+}
+
+// NewShellToolResult creates the result of a command: its streams, exit code
+// and working directory, and why it did not run to completion when it failed
+func NewShellToolResult(callID, name string, shell functions.ShellResult, success bool) ToolResult {
+	result := ToolResult{
+		CallID:     callID,
+		Name:       name,
+		Success:    success,
+		Output:     shell.Stdout,
+		Stderr:     shell.Stderr,
+		DurationMs: shell.DurationMs,
+		Cwd:        shell.Cwd,
+	}
+	if shell.ExecError == "" {
+		result.ExitCode = &shell.ExitCode
+	}
+	if !success {
+		result.Error = shell.ExecError
+		if result.Error == "" {
+			result.Error = "command failed"
+		}
+	}
+	if shell.StdoutTruncated {
+		result.Truncated = append(result.Truncated, "output")
+	}
+	if shell.StderrTruncated {
+		result.Truncated = append(result.Truncated, "stderr")
+	}
+	return result
+}
+
+// Content returns the canonical JSON encoding of the result
+func (r ToolResult) Content() string {
+	return string(mustMarshal(r))
+}
+
+// Message returns the tool message carrying the result
+func (r ToolResult) Message() Message {
+	return Message{
+		Role:       openai.ChatMessageRoleTool,
+		Content:    r.Content(),
+		ToolCallID: r.CallID,
+		Name:       r.Name,
+	}
+}
+
+// PlainText renders the result for models that read plain text better than
+// JSON: the output as it is, or the error after "Error: ", followed by a
+// command's stderr, the exit code of a failed command and the metadata, one
+// entry per line
+func (r ToolResult) PlainText() string {
+	var b strings.Builder
+	switch {
+	case !r.Success:
+		b.WriteString("Error: " + r.Error)
+		if r.Output != "" {
+			b.WriteString("\n" + r.Output)
+		}
+	case r.Output == "" && r.Stderr == "":
+		b.WriteString("(no output)")
+	default:
+		b.WriteString(r.Output)
+	}
+	if r.Stderr != "" {
+		b.WriteString("\nStderr:\n" + r.Stderr)
+	}
+	if r.ExitCode != nil && *r.ExitCode != 0 {
+		fmt.Fprintf(&b, "\nExit code: %d", *r.ExitCode)
+	}
+	for _, key := range slices.Sorted(maps.Keys(r.Metadata)) {
+		fmt.Fprintf(&b, "\n[%s] %s", key, r.Metadata[key])
+	}
+	return b.String()
+}
+
+// commandEnvMetadata lists the variables the commands of the shell tool run
+// with, for the metadata of its results, so that the model knows why their
+// output is untranslated, in UTC and without colors. It is empty for other tools.
+func commandEnvMetadata(cfg *config.Config, name string) string {
+	if !functions.IsShellTool(name) {
+		return ""
+	}
+	env := cfg.CommandEnvironment()
+	vars := make([]string, 0, len(env))
+	for _, name := range slices.Sorted(maps.Keys(env)) {
+		vars = append(vars, name+"="+env[name])
+	}
+	return strings.Join(vars, " ")
+}
+
+// text is the output of a successful result and the error of a failed one
+func (r ToolResult) text() string {
+	if r.Success {
+		return r.Output
+	}
+	return r.Error
+}
+
+// toolFields returns the fields of the result holding text from the tool:
+// text, and a command's output and stderr when they are apart from it
+func (r *ToolResult) toolFields() []*string {
+	fields := []*string{&r.Output}
+	if !r.Success {
+		fields = []*string{&r.Error}
+		if r.Output != "" {
+			fields = append(fields, &r.Output)
+		}
+	}
+	if r.Stderr != "" {
+		fields = append(fields, &r.Stderr)
+	}
+	return fields
+}
+
+// toolText returns the text from the tool in the result
+func (r ToolResult) toolText() []string {
+	var texts []string
+	for _, field := range r.toolFields() {
+		texts = append(texts, *field)
+	}
+	return texts
+}
+
+// ParseToolResult decodes the content of a tool message. Besides the
+// canonical encoding it accepts the older {"output": ...} and
+// {"error": ..., "notice": ...} objects; a notice is kept as metadata.
+// It reports false for content in neither form.
+func ParseToolResult(content string) (ToolResult, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &fields); err != nil || len(fields) == 0 {
+		return ToolResult{}, false
+	}
+
+	if _, ok := fields["success"]; ok {
+		var result ToolResult
+		if err := json.Unmarshal([]byte(content), &result); err != nil {
+			return ToolResult{}, false
+		}
+		return result, true
+	}
+
+	var legacy struct {
+		Output *string `json:"output"`
+		Error  *string `json:"error"`
+		Notice string  `json:"notice"`
+	}
+	for key := range fields {
+		if key != "output" && key != "error" && key != "notice" {
+			return ToolResult{}, false
+		}
+	}
+	if err := json.Unmarshal([]byte(content), &legacy); err != nil || (legacy.Output == nil && legacy.Error == nil) {
+		return ToolResult{}, false
+	}
+	var result ToolResult
+	if legacy.Error != nil {
+		result.Error = *legacy.Error
+	} else {
+		result.Success = true
+		result.Output = *legacy.Output
+	}
+	if legacy.Notice != "" {
+		result.Metadata = map[string]string{"notice": legacy.Notice}
+	}
+	return result, true
+}
+
+// migrateToolResults rewrites tool messages in an older result format to
+// the canonical encoding. Content that is not a recognized result, such as
+// plain text from an imported session, is left as it is.
+func (h *ConversationHistory) migrateToolResults() {
+	for i := range h.Messages {
+		msg := &h.Messages[i]
+		if msg.Role != "tool" || !strings.HasPrefix(strings.TrimSpace(msg.Content), "{") {
+			continue
+		}
+		if result, ok := ParseToolResult(msg.Content); ok {
+			msg.Content = result.Content()
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/functions"
+)
+
+func TestToolResultContent(t *testing.T) {
+	exitCode := 0
+	result := ToolResult{CallID: "call_1", Name: "shell", Success: true, Output: "main.go", ExitCode: &exitCode, DurationMs: 12, Metadata: map[string]string{"cwd": "/tmp"}}
+	want := `{"success":true,"output":"main.go","exit_code":0,"duration_ms":12,"metadata":{"cwd":"/tmp"}}`
+	if got := result.Content(); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	if got := NewToolResult("call_2", "shell", "boom", false).Content(); got != `{"success":false,"error":"boom"}` {
+		t.Errorf("Expected a failed result, got %s", got)
+	}
+}
+
+func TestShellToolResultIsStructured(t *testing.T) {
+	ran := functions.ShellResult{ExitCode: 3, Stdout: "partial\n", Stderr: "boom\n", DurationMs: 5, Cwd: "/src"}
+	want := `{"success":true,"output":"partial\n","stderr":"boom\n","exit_code":3,"duration_ms":5,"cwd":"/src"}`
+	if got := NewToolResult("call_1", "shell", ran.String(), true).Content(); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	stopped := functions.ShellResult{Stdout: "started\n", StderrTruncated: true, Cwd: "/src", ExecError: "command timed out after 1s"}
+	want = `{"success":false,"output":"started\n","error":"command timed out after 1s","cwd":"/src","truncated":["stderr"]}`
+	if got := NewToolResult("call_2", "execute_command", stopped.String(), false).Content(); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestToolResultsSentAsPlainText(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "Done.")
+	a.config.ToolResultFormats = []config.ProviderResultFormat{{BaseURL: a.config.BaseURL, Format: config.ToolResultText}}
+	a.messages.toolResults = a.config.ToolResultFormatFor(a.config.BaseURL)
+
+	exitCode := 2
+	failed := NewToolResult("call_2", "shell", "no such file", false)
+	failed.ExitCode = &exitCode
+	a.history.AddMessage(Message{Role: "user", Content: "Look"})
+	a.history.AddMessage(Message{Role: "assistant", ToolCalls: []ToolCall{
+		{ID: "call_1", Type: "function", Function: FunctionCall{Name: "shell", Arguments: `{"command":"ls"}`}},
+		{ID: "call_2", Type: "function", Function: FunctionCall{Name: "shell", Arguments: `{"command":"cat x"}`}},
+	}})
+	a.history.AddMessage(NewToolResult("call_1", "shell", "main.go", true).Message())
+	a.history.AddMessage(failed.Message())
+	if _, err := a.SendMessage(context.Background(), nil, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	want := map[string]string{"call_1": "main.go", "call_2": "Error: no such file\nExit code: 2"}
+	for _, msg := range fake.lastRequest().Messages {
+		if msg.Role == "tool" && msg.Content != want[msg.ToolCallID] {
+			t.Errorf("Expected %s sent as %q, got %q", msg.ToolCallID, want[msg.ToolCallID], msg.Content)
+		}
+	}
+	if last := a.history.Messages[len(a.history.Messages)-2]; last.Role != "tool" || last.Content != failed.Content() {
+		t.Errorf("Expected the history to keep the JSON result, got %+v", last)
+	}
+}
+
+func TestParseToolResult(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string // Canonical encoding; empty when not a result
+	}{
+		{`{"success":true,"output":"a","exit_code":1}`, `{"success":true,"output":"a","exit_code":1}`},
+		{`{"output":"main.go"}`, `{"success":true,"output":"main.go"}`},
+		{`{"output":""}`, `{"success":true}`},
+		{`{"error":"no such file"}`, `{"success":false,"error":"no such file"}`},
+		{`{"error":"x","notice":"STOP"}`, `{"success":false,"error":"x","metadata":{"notice":"STOP"}}`},
+		{`plain text output`, ""},
+		{`{"files":["a.go"]}`, ""},
+		{`{"notice":"only"}`, ""},
+	}
+	for _, tt := range tests {
+		result, ok := ParseToolResult(tt.content)
+		if ok != (tt.want != "") {
+			t.Errorf("%s: Expected ok=%t, got %t", tt.content, tt.want != "", ok)
+			continue
+		}
+		if ok && result.Content() != tt.want {
+			t.Errorf("%s: Expected %s, got %s", tt.content, tt.want, result.Content())
+		}
+	}
+}
+
+func TestLoadSessionMigratesToolResults(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := `{"messages":[
+		{"role":"user","content":"list files"},
+		{"role":"tool","tool_call_id":"call_1","content":"{\"output\":\"main.go\"}"},
+		{"role":"tool","tool_call_id":"call_2","content":"{\"success\":false,\"error\":\"denied\",\"exit_code\":2}"}
+	]}`
+	journal := `{"messages":[{"role":"tool","tool_call_id":"call_3","content":"{\"error\":\"failed\",\"notice\":\"STOP\"}"},{"role":"tool","tool_call_id":"call_4","content":"raw text"}]}` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "mixed"+snapshotExt), []byte(snapshot), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "mixed"+journalExt), []byte(journal), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	history, _, err := loadSession(dir, "mixed")
+	if err != nil {
+		t.Fatalf("Failed to load session: %v", err)
+	}
+	want := map[string]string{
+		"call_1": `{"success":true,"output":"main.go"}`,
+		"call_2": `{"success":false,"error":"denied","exit_code":2}`,
+		"call_3": `{"success":false,"error":"failed","metadata":{"notice":"STOP"}}`,
+		"call_4": `raw text`,
+	}
+	for _, msg := range history.Messages {
+		if msg.Role != "tool" {
+			continue
+		}
+		if msg.Content != want[msg.ToolCallID] {
+			t.Errorf("Expected %s to be %s, got %s", msg.ToolCallID, want[msg.ToolCallID], msg.Content)
+		}
+		delete(want, msg.ToolCallID)
+	}
+	if len(want) != 0 {
+		t.Errorf("Expected every tool result to be loaded, missing %v", want)
+	}
+}
+
+func TestSendToolResultRecordsDetails(t *testing.T) {
+	a, err := NewOpenAIAgent(&config.Config{APIKey: "test", Model: "gpt-4o"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	a.history.AddToolMessage("execute_command", map[string]interface{}{"command": "false"}, "call_1")
+	a.pendingToolCalls["call_1"] = true
+	a.state = StateAwaitingToolResults
+
+	exitCode := 1
+	result := NewToolResult("call_1", "execute_command", "Command Failed (code 1)", false)
+	result.ExitCode = &exitCode
+	result.DurationMs = 40
+	if err := a.SendToolResult(context.Background(), result); err != nil {
+		t.Fatalf("SendToolResult failed: %v", err)
+	}
+
+	messages := a.history.GetMessages()
+	last := messages[len(messages)-1]
+	want := `{"success":false,"error":"----- BEGIN TOOL OUTPUT (call_1) -----\nCommand Failed (code 1)\n----- END TOOL OUTPUT (call_1) -----","exit_code":1,"duration_ms":40,"metadata":{"command_env":"GIT_PAGER=cat LC_ALL=C NO_COLOR=1 TZ=UTC"}}`
+	if last.Role != "tool" || last.ToolCallID != "call_1" || last.Name != "execute_command" || last.Content != want {
+		t.Errorf("Expected tool result %s, got %+v", want, last)
+	}
+}
@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// maxUnknownToolRounds bounds how many times in a row a turn is re-requested
+// automatically because every tool call was answered by the agent itself: the
+// tools do not exist or an interceptor rejected the calls
+const maxUnknownToolRounds = 3
+
+// hasTool reports whether name is one of the tools offered to the model
+func (a *OpenAIAgent) hasTool(name string) bool {
+	for _, tool := range a.tools {
+		if tool.Function.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownToolError explains that a tool does not exist, listing the tools the
+// session may call so the model can correct itself
+func (a *OpenAIAgent) unknownToolError(name string) string {
+	var names []string
+	for _, tool := range a.tools {
+		if a.ToolAllowed(tool.Function.Name) {
+			names = append(names, tool.Function.Name)
+		}
+	}
+	return fmt.Sprintf("no such tool: %q. Available tools: %s", name, strings.Join(names, ", "))
+}
+
+// unknownToolResult is the tool result fed back for a call to a tool that does not exist
+func (a *OpenAIAgent) unknownToolResult(callID, name string) Message {
+	return toolErrorResult(callID, name, a.unknownToolError(name))
+}
+
+// toolErrorResult is a tool result reporting text as the call's error
+func toolErrorResult(callID, name, text string) Message {
+	return NewToolResult(callID, name, text, false).Message()
+}
+
+// sendUnknownToolWarning tells the handler the model called a tool that does not exist
+func sendUnknownToolWarning(handler ResponseHandler, name string) {
+	sendWarning(handler, fmt.Sprintf("The model called an unknown tool %q; it was told the tool does not exist.", name))
+}
+
+// sendWarning emits a "warning" item with a system message
+func sendWarning(handler ResponseHandler, text string) {
+	item := ResponseItem{
+		Type:    EventWarning,
+		Message: &Message{Role: "system", Content: text},
+	}
+	if data, err := json.Marshal(item); err == nil {
+		handler(string(data))
+	}
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/epuerta/codex-go/internal/usagelog"
+	"github.com/sashabaranov/go-openai"
+)
+
+// turnUsage tracks the turn in progress for the usage log. A turn starts with
+// a SendMessage and ends when the agent is idle again, after every tool call
+// and follow-up request it led to.
+type turnUsage struct {
+	started   time.Time
+	model     string // Model of the turn's last request
+	escalated bool   // The model asked for the edit model mid-turn
+	refusal   string // Category of the turn's last refused response
+	start     Usage  // Session usage when the turn started
+	estimated int    // Session requests with estimated usage when the turn started
+	tools     map[string]int
+	byModel   map[string]usagelog.ModelUsage // Usage of the turn's requests per model
+}
+
+// beginTurn starts a turn: the next request is indexed under a new turn
+// number, and usage is tracked unless a turn is in progress. The caller must hold a.mu.
+func (a *OpenAIAgent) beginTurn() {
+	a.newTurn = true
+	if a.turn != nil || a.config.UsageLog == "" {
+		return
+	}
+	start, estimated := a.usage.snapshot()
+	a.turn = &turnUsage{
+		started:   time.Now(),
+		model:     a.modelLocked(),
+		start:     start,
+		estimated: estimated,
+		tools:     make(map[string]int),
+		byModel:   make(map[string]usagelog.ModelUsage),
+	}
+}
+
+// countRequest charges the usage of a request to its model in the turn in progress
+func (a *OpenAIAgent) countRequest(model string, usage openai.Usage, cost float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.turn == nil {
+		return
+	}
+	byModel := a.turn.byModel[model]
+	byModel.Requests++
+	byModel.PromptTokens += usage.PromptTokens
+	byModel.CompletionTokens += usage.CompletionTokens
+	byModel.CostUSD += cost
+	a.turn.byModel[model] = byModel
+}
+
+// countToolCall counts a tool result toward the turn. The caller must hold a.mu.
+func (a *OpenAIAgent) countToolCall(name string) {
+	if a.turn != nil && name != "" {
+		a.turn.tools[name]++
+	}
+}
+
+// endTurn finishes the turn in progress, returning its record for
+// logTurnUsage to append once a.mu is released. Turns that sent no request
+// are not recorded; nil is returned for them. The caller must hold a.mu.
+func (a *OpenAIAgent) endTurn() *usagelog.Record {
+	turn := a.turn
+	if turn == nil {
+		return nil
+	}
+	a.turn = nil
+
+	usage, estimated := a.usage.snapshot()
+	if usage.Requests == turn.start.Requests {
+		return nil
+	}
+	record := usagelog.Record{
+		Time:             time.Now(),
+		Model:            turn.model,
+		Escalated:        turn.escalated,
+		Refusal:          turn.refusal,
+		Requests:         usage.Requests - turn.start.Requests,
+		PromptTokens:     usage.PromptTokens - turn.start.PromptTokens,
+		CompletionTokens: usage.CompletionTokens - turn.start.CompletionTokens,
+		CachedTokens:     usage.CachedPromptTokens - turn.start.CachedPromptTokens,
+		CostUSD:          usage.CostUSD - turn.start.CostUSD,
+		Estimated:        estimated > turn.estimated,
+		DurationMs:       time.Since(turn.started).Milliseconds(),
+	}
+	if a.history != nil {
+		record.Session = a.history.CurrentSession
+	}
+	if len(turn.tools) > 0 {
+		record.Tools = turn.tools
+	}
+	if len(turn.byModel) > 1 {
+		record.ByModel = turn.byModel
+	}
+	return &record
+}
+
+// logTurnUsage appends a record returned by endTurn to the usage log. It
+// does file I/O, so the caller must not hold a.mu.
+func (a *OpenAIAgent) logTurnUsage(record *usagelog.Record) {
+	if record == nil {
+		return
+	}
+	if err := usagelog.Append(a.config.UsageLog, *record); err != nil {
+		a.logger.Log("[WARN] Agent.logTurnUsage: Failed to record turn usage: %v", err)
+	}
+}
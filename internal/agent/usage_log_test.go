@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/usagelog"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestTurnUsageIsLogged(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, toolCallReply("read_file", `{"path":"a.go"}`), "It declares package a.", "Hello")
+	fake.usage = &openai.Usage{PromptTokens: 100, CompletionTokens: 10}
+	a.config.UsageLog = filepath.Join(t.TempDir(), "usage.jsonl")
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Read a.go"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if records, _ := usagelog.Read(a.config.UsageLog, time.Time{}); len(records) != 0 {
+		t.Fatalf("Expected no record while tool results are awaited, got %+v", records)
+	}
+	if err := a.SendToolResult(context.Background(), NewToolResult("call_1", "read_file", "package a", true)); err != nil {
+		t.Fatalf("SendToolResult failed: %v", err)
+	}
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "Hi"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	records, err := usagelog.Read(a.config.UsageLog, time.Time{})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected one record per turn, got %d", len(records))
+	}
+	first := records[0]
+	if first.Requests != 2 || first.PromptTokens != 200 || first.CompletionTokens != 20 || first.Tools["read_file"] != 1 {
+		t.Errorf("Expected the tool call and follow-up in the first turn, got %+v", first)
+	}
+	if first.Model != a.config.Model || first.Session != a.SessionID() {
+		t.Errorf("Expected the model and session to be recorded, got %+v", first)
+	}
+	if second := records[1]; second.Requests != 1 || second.Tools != nil {
+		t.Errorf("Expected a single request without tools in the second turn, got %+v", second)
+	}
+}
+
+func TestCancelledTurnIsLogged(t *testing.T) {
+	a, _ := newFakeOpenAIAgent(t, toolCallReply("shell", `{"command":["ls"]}`))
+	a.config.UsageLog = filepath.Join(t.TempDir(), "usage.jsonl")
+
+	if _, err := a.SendMessage(context.Background(), []Message{{Role: "user", Content: "List files"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	a.Cancel()
+
+	records, _ := usagelog.Read(a.config.UsageLog, time.Time{})
+	if len(records) != 1 || records[0].Requests != 1 || !records[0].Estimated {
+		t.Errorf("Expected the cancelled turn with estimated usage, got %+v", records)
+	}
+}
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"errors"
+	"time"
+)
+
+// SetWorkingDir records the session working directory, which tools resolve
+// relative paths against, so a resumed session continues in it
+func (h *ConversationHistory) SetWorkingDir(dir string) {
+	if h.WorkingDir == dir {
+		return
+	}
+	h.WorkingDir = dir
+	h.UpdatedAt = time.Now()
+	h.journalChanges()
+
+	if h.EnablePersist && h.HistoryPath != "" {
+		h.Save(h.HistoryPath)
+	}
+}
+
+// WorkingDir returns the session working directory recorded in the history,
+// or "" when the session has not moved from the workspace root
+func (a *OpenAIAgent) WorkingDir() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.history == nil {
+		return ""
+	}
+	return a.history.WorkingDir
+}
+
+// SetWorkingDir records the session working directory after change_directory
+// moved it. The directory is stated in the system prompt of every later
+// request and persisted with the session.
+func (a *OpenAIAgent) SetWorkingDir(dir string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.history == nil {
+		return errors.New("agent history is nil")
+	}
+	a.historyMu.Lock()
+	a.history.SetWorkingDir(dir)
+	a.historyMu.Unlock()
+	a.logger.Log("[INFO] Agent.SetWorkingDir: Working directory is now %s", dir)
+	return nil
+}
+
+// workingDirSection states the session working directory in the system
+// prompt, once the session has moved from the workspace root
+func workingDirSection(h *ConversationHistory) string {
+	if h.WorkingDir == "" {
+		return ""
+	}
+	return "Current working directory: " + h.WorkingDir +
+		"\nRelative paths in tool calls and shell commands resolve against it. Use change_directory to move."
+}
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestWorkingDirStatedInSystemPrompt(t *testing.T) {
+	a, fake := newFakeOpenAIAgent(t, "first", "second")
+	ctx := context.Background()
+
+	if _, err := a.SendMessage(ctx, []Message{{Role: "user", Content: "hello"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if err := a.SetWorkingDir("/work/service"); err != nil {
+		t.Fatalf("SetWorkingDir failed: %v", err)
+	}
+	if _, err := a.SendMessage(ctx, []Message{{Role: "user", Content: "build it"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	if len(fake.requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(fake.requests))
+	}
+	if strings.Contains(fake.requests[0].Messages[0].Content, "Current working directory") {
+		t.Errorf("Expected no working directory before change_directory, got %q", fake.requests[0].Messages[0].Content)
+	}
+	if !strings.Contains(fake.requests[1].Messages[0].Content, "Current working directory: /work/service") {
+		t.Errorf("Expected the working directory in the system prompt, got %q", fake.requests[1].Messages[0].Content)
+	}
+	// The stored prompt is left alone
+	if strings.Contains(a.GetHistory().Messages[0].Content, "/work/service") {
+		t.Errorf("Expected the working directory to stay out of the history")
+	}
+	if a.WorkingDir() != "/work/service" {
+		t.Errorf("Expected working directory /work/service, got %q", a.WorkingDir())
+	}
+}
+
+func TestWorkingDirRecoveredWithSession(t *testing.T) {
+	dir := t.TempDir()
+	a := newJournaledAgent(t, dir)
+	a.GetHistory().AddMessage(Message{Role: "user", Content: "work on the service"})
+	if err := a.SetWorkingDir("/work/service"); err != nil {
+		t.Fatalf("SetWorkingDir failed: %v", err)
+	}
+	id := a.SessionID()
+	crash(a)
+
+	saved, _, err := loadSession(dir, id)
+	if err != nil {
+		t.Fatalf("loadSession failed: %v", err)
+	}
+	if saved.WorkingDir != "/work/service" {
+		t.Errorf("Expected working directory /work/service, got %q", saved.WorkingDir)
+	}
+}
@@ -0,0 +1,31 @@
+package agent
+
+// WriteLock guards the files of a project shared by codex processes, such as
+// projectlock.Lock. Verify returns an error once this process no longer holds
+// the lock; the history and session journal are then left unwritten.
+type WriteLock interface {
+	Verify() error
+}
+
+// SetWriteLock makes the agent write its history and session journal only
+// while lock is held, so a process whose project was taken over by another
+// stops overwriting the other's files. nil writes unconditionally.
+func (a *OpenAIAgent) SetWriteLock(lock WriteLock) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.historyOpts.Lock = lock
+	a.history.Lock = lock
+	if a.journal != nil {
+		a.journal.mu.Lock()
+		a.journal.lock = lock
+		a.journal.mu.Unlock()
+	}
+}
+
+// verifyLock returns the error of lock, if any
+func verifyLock(lock WriteLock) error {
+	if lock == nil {
+		return nil
+	}
+	return lock.Verify()
+}
@@ -0,0 +1,403 @@
+// Package blobstore keeps large payloads (full tool outputs, file snapshots,
+// long session messages) in a content-addressed store under .codex/blobs, so
+// they are written once however many times they are referenced. Blobs are
+// named by the sha256 of their content. An index records which owners (session
+// IDs) reference each blob; a blob no retained owner references is removed by
+// GC. Several processes may write to the same store: index updates are
+// serialized by a lock file, and blobs are written to a temporary file and
+// renamed into place.
+package blobstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// DirName is the store directory inside a project's .codex directory
+	DirName = "blobs"
+
+	indexFile = "index.json"
+	lockFile  = "index.lock"
+	zstdExt   = ".zst"
+)
+
+const (
+	// lockRetry is how often a busy index lock is tried again
+	lockRetry = 10 * time.Millisecond
+	// lockTimeout is how long a writer waits for the index lock
+	lockTimeout = 10 * time.Second
+	// lockStale is how old an index lock must be to be taken over; its
+	// holder died while updating the index
+	lockStale = 30 * time.Second
+)
+
+// The encoder and decoder are safe for concurrent use with EncodeAll and
+// DecodeAll
+var (
+	encoder, _ = zstd.NewWriter(nil)
+	decoder, _ = zstd.NewReader(nil)
+)
+
+// DefaultGracePeriod is how old an unreferenced blob must be for GC to remove
+// it, so a blob that was just written and is about to be referenced survives
+const DefaultGracePeriod = time.Hour
+
+// DirFor returns the store directory for a project directory
+func DirFor(projectDir string) string {
+	return filepath.Join(projectDir, ".codex", DirName)
+}
+
+// Entry is the index record of one blob
+type Entry struct {
+	Size       int64           `json:"size"`                 // Uncompressed size in bytes
+	Compressed bool            `json:"compressed,omitempty"` // Stored zstd-compressed
+	Owners     map[string]bool `json:"owners"`               // Owners referencing the blob
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// Refs returns how many owners reference the blob
+func (e *Entry) Refs() int {
+	return len(e.Owners)
+}
+
+// GCStats reports what a garbage collection removed
+type GCStats struct {
+	Blobs int   // Blobs removed
+	Bytes int64 // Bytes freed on disk
+	Kept  int   // Blobs still referenced
+}
+
+// Store is a content-addressed blob store in a directory
+type Store struct {
+	dir      string
+	compress bool
+	mu       sync.Mutex // Serializes index updates within the process
+}
+
+// Open returns the store in dir, which is created on the first write. With
+// compress, blobs written by Put are zstd-compressed.
+func Open(dir string, compress bool) *Store {
+	return &Store{dir: dir, compress: compress}
+}
+
+// Dir returns the store directory
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Hash returns the name data is stored under
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Put stores data on behalf of owner and returns its hash. Storing content
+// that is already present only adds the reference.
+func (s *Store) Put(owner string, data []byte) (string, error) {
+	return s.put(owner, data, s.compress)
+}
+
+// PutPlain stores data uncompressed, so the file at Path can be read directly
+// by tools, and returns its hash
+func (s *Store) PutPlain(owner string, data []byte) (string, error) {
+	return s.put(owner, data, false)
+}
+
+func (s *Store) put(owner string, data []byte, compress bool) (string, error) {
+	if owner == "" {
+		return "", errors.New("blob owner cannot be empty")
+	}
+	hash := Hash(data)
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	// The blob is written under the index lock, so GC cannot remove it
+	// between the write and the reference
+	err := s.updateIndex(func(index map[string]*Entry) error {
+		if err := s.writeBlob(hash, data, compress); err != nil {
+			return err
+		}
+		entry, exists := index[hash]
+		if !exists {
+			entry = &Entry{Size: int64(len(data)), CreatedAt: time.Now()}
+			index[hash] = entry
+		}
+		if entry.Owners == nil {
+			entry.Owners = make(map[string]bool)
+		}
+		entry.Owners[owner] = true
+		entry.Compressed = s.exists(hash+zstdExt) && !s.exists(hash)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// writeBlob writes the content of a blob unless it is already stored in the
+// requested form. The temporary file and rename keep readers from seeing a
+// partly written blob.
+func (s *Store) writeBlob(hash string, data []byte, compress bool) error {
+	name := hash
+	if compress {
+		name += zstdExt
+	}
+	if s.exists(name) {
+		return nil
+	}
+
+	content := data
+	if compress {
+		content = encoder.EncodeAll(data, nil)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, hash+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("failed to store blob: %w", err)
+	}
+	return nil
+}
+
+// Get returns the content of a blob
+func (s *Store) Get(hash string) ([]byte, error) {
+	if !validHash(hash) {
+		return nil, fmt.Errorf("invalid blob hash %q", hash)
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, hash))
+	if err == nil {
+		return data, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+
+	compressed, err := os.ReadFile(filepath.Join(s.dir, hash+zstdExt))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("blob %s not found in %s", hash, s.dir)
+		}
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+	data, err = decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress blob %s: %w", hash, err)
+	}
+	return data, nil
+}
+
+// Path returns the file holding a blob stored with PutPlain
+func (s *Store) Path(hash string) string {
+	return filepath.Join(s.dir, hash)
+}
+
+// Release drops owner's reference to a blob. The blob itself is left for GC.
+func (s *Store) Release(owner, hash string) error {
+	return s.updateIndex(func(index map[string]*Entry) error {
+		if entry, exists := index[hash]; exists {
+			delete(entry.Owners, owner)
+		}
+		return nil
+	})
+}
+
+// Entries returns the index, keyed by blob hash
+func (s *Store) Entries() (map[string]*Entry, error) {
+	return s.readIndex()
+}
+
+// GC drops the references of owners that are not retained and removes every
+// blob left unreferenced, along with stray files no index entry names (blobs
+// written by a process that died before recording them). Blobs and files
+// younger than grace are kept whatever their references.
+func (s *Store) GC(retained func(owner string) bool, grace time.Duration) (GCStats, error) {
+	var stats GCStats
+	var removeErr error
+	err := s.updateIndex(func(index map[string]*Entry) error {
+		cutoff := time.Now().Add(-grace)
+		var remove []string
+		for hash, entry := range index {
+			for owner := range entry.Owners {
+				if !retained(owner) {
+					delete(entry.Owners, owner)
+				}
+			}
+			if entry.Refs() > 0 || entry.CreatedAt.After(cutoff) {
+				stats.Kept++
+				continue
+			}
+			delete(index, hash)
+			remove = append(remove, hash, hash+zstdExt)
+		}
+
+		if entries, err := os.ReadDir(s.dir); err == nil {
+			for _, e := range entries {
+				name := e.Name()
+				if name == indexFile || name == lockFile || e.IsDir() {
+					continue
+				}
+				if _, indexed := index[strings.TrimSuffix(name, zstdExt)]; indexed {
+					continue
+				}
+				if info, err := e.Info(); err == nil && info.ModTime().Before(cutoff) {
+					remove = append(remove, name)
+				}
+			}
+		}
+
+		// Files are removed under the lock, so a concurrent Put of the same
+		// content cannot find the file about to go and skip writing it
+		removed := make(map[string]bool)
+		for _, name := range remove {
+			path := filepath.Join(s.dir, name)
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				removeErr = fmt.Errorf("failed to remove blob %s: %w", name, err)
+				continue
+			}
+			stats.Bytes += info.Size()
+			removed[strings.TrimSuffix(name, zstdExt)] = true
+		}
+		stats.Blobs = len(removed)
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	return stats, removeErr
+}
+
+// updateIndex applies change to the index under the index lock. The index is
+// left as it was when change fails.
+func (s *Store) updateIndex(change func(index map[string]*Entry) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	unlock, err := s.lockIndex()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	index, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+	if err := change(index); err != nil {
+		return err
+	}
+	return s.writeIndex(index)
+}
+
+// lockIndex takes the lock file guarding the index against other processes.
+// A lock older than lockStale was left by a writer that died and is taken
+// over.
+func (s *Store) lockIndex() (func(), error) {
+	path := filepath.Join(s.dir, lockFile)
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to lock blob index: %w", err)
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockStale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed to lock blob index: %s is held by another process", path)
+		}
+		time.Sleep(lockRetry)
+	}
+}
+
+// readIndex loads the index. A missing index is empty.
+func (s *Store) readIndex() (map[string]*Entry, error) {
+	index := make(map[string]*Entry)
+	data, err := os.ReadFile(filepath.Join(s.dir, indexFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return nil, fmt.Errorf("failed to read blob index: %w", err)
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse blob index: %w", err)
+	}
+	return index, nil
+}
+
+// writeIndex atomically replaces the index
+func (s *Store) writeIndex(index map[string]*Entry) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal blob index: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, indexFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write blob index: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob index: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob index: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, indexFile)); err != nil {
+		return fmt.Errorf("failed to replace blob index: %w", err)
+	}
+	return nil
+}
+
+// exists reports whether a file is in the store directory
+func (s *Store) exists(name string) bool {
+	_, err := os.Stat(filepath.Join(s.dir, name))
+	return err == nil
+}
+
+// validHash reports whether hash is a sha256 hex digest, so it cannot name a
+// file outside the store
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
@@ -0,0 +1,180 @@
+package blobstore
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPutAndGet(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%t", compress), func(t *testing.T) {
+			s := Open(filepath.Join(t.TempDir(), "blobs"), compress)
+			data := bytes.Repeat([]byte("line of output\n"), 1000)
+
+			hash, err := s.Put("session-1", data)
+			if err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			if hash != Hash(data) {
+				t.Errorf("Expected the blob to be named by its sha256, got %s", hash)
+			}
+			got, err := s.Get(hash)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("Get returned different content")
+			}
+
+			entries, _ := s.Entries()
+			if entries[hash].Compressed != compress || entries[hash].Size != int64(len(data)) {
+				t.Errorf("Unexpected index entry %+v", entries[hash])
+			}
+			if compress {
+				info, err := os.Stat(filepath.Join(s.Dir(), hash+zstdExt))
+				if err != nil || info.Size() >= int64(len(data)) {
+					t.Errorf("Expected a compressed blob file, got %v, %v", info, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPutPlainIsReadableInPlace(t *testing.T) {
+	s := Open(t.TempDir(), true)
+	hash, err := s.PutPlain("session-1", []byte("full output"))
+	if err != nil {
+		t.Fatalf("PutPlain failed: %v", err)
+	}
+	data, err := os.ReadFile(s.Path(hash))
+	if err != nil || string(data) != "full output" {
+		t.Errorf("Expected the output at %s, got %q, %v", s.Path(hash), data, err)
+	}
+}
+
+func TestGetRejectsInvalidHashes(t *testing.T) {
+	s := Open(t.TempDir(), false)
+	for _, hash := range []string{"", "../index.json", Hash(nil)} {
+		if _, err := s.Get(hash); err == nil {
+			t.Errorf("Expected Get(%q) to fail", hash)
+		}
+	}
+}
+
+func TestReferencesAreCountedPerOwner(t *testing.T) {
+	s := Open(t.TempDir(), false)
+	data := []byte("shared snapshot")
+	hash, _ := s.Put("session-1", data)
+	s.Put("session-1", data)
+	s.Put("session-2", data)
+
+	entries, _ := s.Entries()
+	if refs := entries[hash].Refs(); refs != 2 {
+		t.Errorf("Expected 2 owners, got %d", refs)
+	}
+	if err := s.Release("session-1", hash); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	entries, _ = s.Entries()
+	if refs := entries[hash].Refs(); refs != 1 || !entries[hash].Owners["session-2"] {
+		t.Errorf("Expected session-2 to be left, got %+v", entries[hash].Owners)
+	}
+}
+
+func TestGCRemovesBlobsOfDiscardedSessions(t *testing.T) {
+	s := Open(t.TempDir(), false)
+	kept, _ := s.Put("kept", []byte("kept"))
+	shared, _ := s.Put("discarded", []byte("shared"))
+	s.Put("kept", []byte("shared"))
+	gone, _ := s.Put("discarded", []byte("gone"))
+	released, _ := s.Put("kept", []byte("released"))
+	s.Release("kept", released)
+	stray := filepath.Join(s.Dir(), Hash([]byte("stray")))
+	os.WriteFile(stray, []byte("stray"), 0644)
+
+	// Everything is within the grace period
+	stats, err := s.GC(func(owner string) bool { return owner == "kept" }, time.Hour)
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if stats.Blobs != 0 {
+		t.Errorf("Expected recent blobs to be kept, removed %d", stats.Blobs)
+	}
+
+	stats, err = s.GC(func(owner string) bool { return owner == "kept" }, -time.Second)
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if stats.Blobs != 3 || stats.Kept != 2 {
+		t.Errorf("Expected 3 blobs removed and 2 kept, got %+v", stats)
+	}
+	for _, hash := range []string{kept, shared} {
+		if _, err := s.Get(hash); err != nil {
+			t.Errorf("Expected blob %s to be kept: %v", hash, err)
+		}
+	}
+	for _, hash := range []string{gone, released} {
+		if _, err := s.Get(hash); err == nil {
+			t.Errorf("Expected blob %s to be removed", hash)
+		}
+	}
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Errorf("Expected the unindexed file to be removed")
+	}
+	entries, _ := s.Entries()
+	if len(entries) != 2 || entries[shared].Owners["discarded"] {
+		t.Errorf("Unexpected index after GC: %+v", entries)
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Separate stores stand in for separate processes
+			s := Open(dir, i%2 == 0)
+			for j := 0; j < 10; j++ {
+				if _, err := s.Put(fmt.Sprintf("session-%d", i), []byte(fmt.Sprintf("blob %d", j))); err != nil {
+					t.Errorf("Put failed: %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	entries, err := Open(dir, false).Entries()
+	if err != nil {
+		t.Fatalf("Entries failed: %v", err)
+	}
+	if len(entries) != 10 {
+		t.Fatalf("Expected 10 blobs, got %d", len(entries))
+	}
+	for hash, entry := range entries {
+		if entry.Refs() != 8 {
+			t.Errorf("Expected blob %s to have 8 owners, got %d", hash, entry.Refs())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, lockFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected the index lock to be released")
+	}
+}
+
+func TestStaleLockIsTakenOver(t *testing.T) {
+	s := Open(t.TempDir(), false)
+	lock := filepath.Join(s.Dir(), lockFile)
+	os.WriteFile(lock, []byte("12345\n"), 0644)
+	old := time.Now().Add(-2 * lockStale)
+	os.Chtimes(lock, old, old)
+
+	if _, err := s.Put("session-1", []byte("data")); err != nil {
+		t.Fatalf("Expected a stale lock to be taken over: %v", err)
+	}
+}
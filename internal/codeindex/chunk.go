@@ -0,0 +1,142 @@
+package codeindex
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	windowLines   = 60  // Lines in a sliding-window chunk
+	windowStep    = 45  // Lines between window starts, so windows overlap by 15
+	maxChunkLines = 120 // Declarations longer than this are split into windows
+	maxEmbedChars = 16000
+)
+
+// Chunk is a span of a file that is embedded as one vector
+type Chunk struct {
+	StartLine int    `json:"start_line"` // 1-based, inclusive
+	EndLine   int    `json:"end_line"`
+	Symbol    string `json:"symbol,omitempty"` // Declaration the chunk holds, for Go files
+	Vector    Vector `json:"vector"`
+}
+
+// ChunkFile splits a file into chunks: Go files by top-level declaration,
+// anything else (and Go that does not parse) by overlapping windows of lines
+func ChunkFile(path string, content []byte) []Chunk {
+	lines := strings.Count(string(content), "\n")
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		lines++
+	}
+	if lines == 0 {
+		return nil
+	}
+	if filepath.Ext(path) == ".go" {
+		if chunks, ok := chunkGo(path, content); ok {
+			return chunks
+		}
+	}
+	return windows(1, lines, "")
+}
+
+// chunkGo makes a chunk of every top-level declaration with its doc comment
+func chunkGo(path string, content []byte) ([]Chunk, bool) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, content, parser.ParseComments)
+	if err != nil {
+		return nil, false
+	}
+
+	var chunks []Chunk
+	for _, decl := range file.Decls {
+		start, symbol := decl.Pos(), ""
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+			symbol = d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				symbol = receiverName(d.Recv.List[0].Type) + "." + symbol
+			}
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+			symbol = specName(d)
+		}
+		from, to := fset.Position(start).Line, fset.Position(decl.End()).Line
+		if to-from+1 > maxChunkLines {
+			chunks = append(chunks, windows(from, to, symbol)...)
+			continue
+		}
+		chunks = append(chunks, Chunk{StartLine: from, EndLine: to, Symbol: symbol})
+	}
+	return chunks, len(chunks) > 0
+}
+
+// windows covers lines from..to with overlapping chunks
+func windows(from, to int, symbol string) []Chunk {
+	var chunks []Chunk
+	for start := from; ; start += windowStep {
+		end := min(start+windowLines-1, to)
+		chunks = append(chunks, Chunk{StartLine: start, EndLine: end, Symbol: symbol})
+		if end == to {
+			return chunks
+		}
+	}
+}
+
+// receiverName returns the type name of a method receiver
+func receiverName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(t.X)
+	case *ast.IndexExpr:
+		return receiverName(t.X)
+	case *ast.IndexListExpr:
+		return receiverName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+// specName returns the first name a type, var or const declaration declares
+func specName(d *ast.GenDecl) string {
+	if len(d.Specs) == 0 {
+		return ""
+	}
+	switch s := d.Specs[0].(type) {
+	case *ast.TypeSpec:
+		return s.Name.Name
+	case *ast.ValueSpec:
+		if len(s.Names) > 0 {
+			return s.Names[0].Name
+		}
+	}
+	return ""
+}
+
+// chunkText returns the text embedded for a chunk of path, whose lines are given
+func chunkText(path string, lines []string, c Chunk) string {
+	var b strings.Builder
+	b.WriteString(path)
+	if c.Symbol != "" {
+		b.WriteString(" " + c.Symbol)
+	}
+	b.WriteString("\n")
+	for _, line := range lines[c.StartLine-1 : min(c.EndLine, len(lines))] {
+		b.WriteString(line + "\n")
+	}
+	text := b.String()
+	if len(text) > maxEmbedChars {
+		text = text[:maxEmbedChars]
+	}
+	return text
+}
@@ -0,0 +1,67 @@
+package codeindex
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChunkGoByDeclaration(t *testing.T) {
+	src := `package demo
+
+import "fmt"
+
+// Greeter greets
+type Greeter struct{}
+
+// Greet prints a greeting
+func (g *Greeter) Greet(name string) {
+	fmt.Println("hello", name)
+}
+
+func helper() {}
+`
+	chunks := ChunkFile("demo.go", []byte(src))
+	if len(chunks) != 3 {
+		t.Fatalf("Expected 3 chunks, got %d: %+v", len(chunks), chunks)
+	}
+	want := []Chunk{
+		{StartLine: 5, EndLine: 6, Symbol: "Greeter"},
+		{StartLine: 8, EndLine: 11, Symbol: "Greeter.Greet"},
+		{StartLine: 13, EndLine: 13, Symbol: "helper"},
+	}
+	for i, w := range want {
+		c := chunks[i]
+		if c.StartLine != w.StartLine || c.EndLine != w.EndLine || c.Symbol != w.Symbol {
+			t.Errorf("Expected chunk %d to be %+v, got %+v", i, w, c)
+		}
+	}
+}
+
+func TestChunkOtherFilesByWindow(t *testing.T) {
+	content := strings.Repeat("line\n", 100)
+	chunks := ChunkFile("notes.txt", []byte(content))
+	if len(chunks) != 2 {
+		t.Fatalf("Expected 2 windows, got %d: %+v", len(chunks), chunks)
+	}
+	if chunks[0].StartLine != 1 || chunks[0].EndLine != 60 || chunks[1].StartLine != 46 || chunks[1].EndLine != 100 {
+		t.Errorf("Expected overlapping windows 1-60 and 46-100, got %+v", chunks)
+	}
+
+	// Go that does not parse is chunked by window too
+	if chunks := ChunkFile("broken.go", []byte("package x\nfunc {\n")); len(chunks) != 1 || chunks[0].EndLine != 2 {
+		t.Errorf("Expected one window for unparsable Go, got %+v", chunks)
+	}
+}
+
+func TestChunkLongDeclarationSplit(t *testing.T) {
+	src := "package demo\n\nfunc long() {\n" + strings.Repeat("\t_ = 1\n", 200) + "}\n"
+	chunks := ChunkFile("long.go", []byte(src))
+	if len(chunks) < 2 {
+		t.Fatalf("Expected a long function split into windows, got %+v", chunks)
+	}
+	for _, c := range chunks {
+		if c.Symbol != "long" || c.EndLine-c.StartLine+1 > windowLines {
+			t.Errorf("Expected windows of at most %d lines labelled long, got %+v", windowLines, c)
+		}
+	}
+}
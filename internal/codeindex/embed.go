@@ -0,0 +1,127 @@
+package codeindex
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Embedder turns texts into vectors
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	Model() string // Vectors of different models cannot be compared
+}
+
+// openAIEmbedder embeds through the configured provider's embeddings endpoint
+type openAIEmbedder struct {
+	client func() *openai.Client
+	model  string
+	check  func(ctx context.Context, texts []string) error // Approves the texts before they are sent; nil sends them
+}
+
+// NewOpenAIEmbedder creates an embedder for the provider and embedding model of cfg
+func NewOpenAIEmbedder(cfg *config.Config) Embedder {
+	clientConfig := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientConfig.BaseURL = cfg.BaseURL
+	}
+	client := openai.NewClientWithConfig(clientConfig)
+	return &openAIEmbedder{client: func() *openai.Client { return client }, model: EmbeddingModel(cfg)}
+}
+
+// NewClientEmbedder creates an embedder sending its requests with the client
+// returned by client, such as an agent's, once check approves the texts
+func NewClientEmbedder(model string, client func() *openai.Client, check func(ctx context.Context, texts []string) error) Embedder {
+	return &openAIEmbedder{client: client, model: model, check: check}
+}
+
+// EmbeddingModel returns the embedding model of cfg
+func EmbeddingModel(cfg *config.Config) string {
+	if cfg.EmbeddingModel == "" {
+		return config.DefaultEmbeddingModel
+	}
+	return cfg.EmbeddingModel
+}
+
+func (e *openAIEmbedder) Model() string {
+	return e.model
+}
+
+func (e *openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if e.check != nil {
+		if err := e.check(ctx, texts); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := e.client().CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input: texts,
+		Model: openai.EmbeddingModel(e.model),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embeddings: %w", err)
+	}
+	vectors := make([][]float32, len(texts))
+	for _, data := range resp.Data {
+		if data.Index < 0 || data.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", data.Index)
+		}
+		vectors[data.Index] = data.Embedding
+	}
+	for i, v := range vectors {
+		if v == nil {
+			return nil, fmt.Errorf("no embedding returned for input %d", i)
+		}
+	}
+	return vectors, nil
+}
+
+// Vector is an embedding, stored as base64 little-endian float32s to keep
+// the index file small
+type Vector []float32
+
+func (v Vector) MarshalJSON() ([]byte, error) {
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return json.Marshal(base64.StdEncoding.EncodeToString(buf))
+}
+
+func (v *Vector) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	buf, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(buf)%4 != 0 {
+		return fmt.Errorf("invalid vector encoding")
+	}
+	*v = make(Vector, len(buf)/4)
+	for i := range *v {
+		(*v)[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return nil
+}
+
+// cosine returns the cosine similarity of a and b
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
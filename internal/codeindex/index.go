@@ -0,0 +1,284 @@
+// Package codeindex maintains an embeddings index of a workspace's source
+// files for semantic code search
+package codeindex
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileName is the index file inside a project's .codex directory
+const FileName = "index.json"
+
+const (
+	maxFileBytes = 512 * 1024 // Larger files are not indexed
+	embedBatch   = 64         // Chunks embedded per request
+)
+
+// skipDirs are directories never indexed, besides hidden ones
+var skipDirs = map[string]bool{"node_modules": true, "vendor": true}
+
+// Index holds the chunk vectors of every indexed file
+type Index struct {
+	Root      string                `json:"root"`
+	Model     string                `json:"model"`
+	UpdatedAt time.Time             `json:"updated_at"`
+	Files     map[string]*FileEntry `json:"files"` // By slash path relative to Root
+}
+
+// FileEntry is an indexed file; Hash detects changes since it was embedded
+type FileEntry struct {
+	Hash   string  `json:"hash"`
+	Chunks []Chunk `json:"chunks"`
+}
+
+// PathFor returns the index file path for a project directory
+func PathFor(projectDir string) string {
+	return filepath.Join(projectDir, ".codex", FileName)
+}
+
+// Load reads the index at path. A missing index is reported with an error
+// satisfying os.IsNotExist.
+func Load(path string) (*Index, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("failed to parse index file %s: %w", path, err)
+	}
+	if idx.Files == nil {
+		idx.Files = make(map[string]*FileEntry)
+	}
+	return &idx, nil
+}
+
+// Save writes the index atomically
+func (idx *Index) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create index directory: %w", err)
+	}
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write index file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace index file: %w", err)
+	}
+	return nil
+}
+
+// Chunks returns the number of chunks in the index
+func (idx *Index) Chunks() int {
+	n := 0
+	for _, f := range idx.Files {
+		n += len(f.Chunks)
+	}
+	return n
+}
+
+// BuildStats reports what an indexing run did
+type BuildStats struct {
+	Files     int // Files in the index afterwards
+	Embedded  int // Files embedded because they are new or changed
+	Unchanged int
+	Removed   int
+	Chunks    int // Chunks embedded
+}
+
+// Build brings the index of root up to date: new and changed files are
+// chunked and embedded, unchanged ones kept and deleted ones dropped. A
+// different embedding model re-embeds everything. progress, if not nil, is
+// called after every batch with the chunks embedded so far and in total.
+func Build(ctx context.Context, root string, prev *Index, embedder Embedder, progress func(done, total int)) (*Index, BuildStats, error) {
+	idx := &Index{Root: root, Model: embedder.Model(), Files: make(map[string]*FileEntry)}
+	if prev == nil || prev.Model != embedder.Model() || prev.Root != root {
+		prev = &Index{Files: map[string]*FileEntry{}}
+	}
+
+	type pending struct {
+		path  string
+		entry *FileEntry
+		lines []string
+	}
+	var stats BuildStats
+	var todo []pending
+	err := Walk(root, func(rel string, content []byte) {
+		hash := hashOf(content)
+		if old := prev.Files[rel]; old != nil && old.Hash == hash {
+			idx.Files[rel] = old
+			stats.Unchanged++
+			return
+		}
+		entry := &FileEntry{Hash: hash, Chunks: ChunkFile(rel, content)}
+		if len(entry.Chunks) == 0 {
+			return
+		}
+		todo = append(todo, pending{rel, entry, strings.Split(string(content), "\n")})
+	})
+	if err != nil {
+		return nil, stats, err
+	}
+
+	// Embed the chunks of changed files in batches
+	type ref struct{ file, chunk int }
+	var texts []string
+	var refs []ref
+	for i, p := range todo {
+		for j, c := range p.entry.Chunks {
+			texts = append(texts, chunkText(p.path, p.lines, c))
+			refs = append(refs, ref{i, j})
+		}
+	}
+	for start := 0; start < len(texts); start += embedBatch {
+		end := min(start+embedBatch, len(texts))
+		vectors, err := embedder.Embed(ctx, texts[start:end])
+		if err != nil {
+			return nil, stats, err
+		}
+		for k, v := range vectors {
+			r := refs[start+k]
+			todo[r.file].entry.Chunks[r.chunk].Vector = v
+		}
+		if progress != nil {
+			progress(end, len(texts))
+		}
+	}
+
+	for _, p := range todo {
+		idx.Files[p.path] = p.entry
+	}
+	for rel := range prev.Files {
+		if idx.Files[rel] == nil {
+			stats.Removed++
+		}
+	}
+	stats.Files, stats.Embedded, stats.Chunks = len(idx.Files), len(todo), len(texts)
+	idx.UpdatedAt = time.Now()
+	return idx, stats, nil
+}
+
+// Walk calls fn with the slash path relative to root and the content of
+// every indexable file: text files of reasonable size outside hidden,
+// vendor and node_modules directories, and not ignored by git when root is
+// in a git work tree
+func Walk(root string, fn func(rel string, content []byte)) error {
+	paths, ok := gitFiles(root)
+	if !ok {
+		var err error
+		if paths, err = walkFiles(root); err != nil {
+			return err
+		}
+	}
+
+	sort.Strings(paths)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.Size() > maxFileBytes {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil || isBinary(content) {
+			continue
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			continue
+		}
+		fn(filepath.ToSlash(rel), content)
+	}
+	return nil
+}
+
+// gitFiles lists the files under root that git tracks or would track, so
+// that .gitignore rules apply, without hidden, vendor and node_modules ones.
+// It reports false when root is not in a git work tree or git cannot run.
+func gitFiles(root string) ([]string, bool) {
+	cmd := exec.Command("git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, false
+	}
+	var paths []string
+	seen := make(map[string]bool)
+	for _, rel := range strings.Split(string(out), "\x00") {
+		if rel == "" || seen[rel] {
+			continue // Listed once per stage while a merge conflict is unresolved
+		}
+		seen[rel] = true
+		if skipped(strings.Split(rel, "/")) {
+			continue
+		}
+		path := filepath.Join(root, filepath.FromSlash(rel))
+		if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+	}
+	return paths, true
+}
+
+// skipped reports whether a file, given by the components of its relative
+// path, is hidden or in a directory never indexed
+func skipped(components []string) bool {
+	for i, name := range components {
+		if strings.HasPrefix(name, ".") || (i < len(components)-1 && skipDirs[name]) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkFiles lists the indexable files under root by walking it
+func walkFiles(root string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil // Skip what cannot be read
+		}
+		if d.IsDir() {
+			if path != root && (strings.HasPrefix(d.Name(), ".") || skipDirs[d.Name()]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && !strings.HasPrefix(d.Name(), ".") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", root, err)
+	}
+	return paths, nil
+}
+
+// isBinary reports whether content looks like a binary file
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0
+}
+
+func hashOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,169 @@
+package codeindex
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wordEmbedder embeds texts as counts of a few known words, so related
+// texts are close without a provider
+type wordEmbedder struct {
+	model string
+	calls int
+	texts int
+}
+
+var embedWords = []string{"retry", "backoff", "parse", "json", "render", "color"}
+
+func (e *wordEmbedder) Model() string { return e.model }
+
+func (e *wordEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	e.texts += len(texts)
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		v := make([]float32, len(embedWords))
+		for j, word := range embedWords {
+			v[j] = float32(strings.Count(strings.ToLower(text), word))
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuildAndSearch(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"net/retry.go":      "package net\n\n// Do retries with backoff\nfunc Do() {}\n",
+		"codec/decode.go":   "package codec\n\n// Decode parses JSON\nfunc Decode() {}\n",
+		"ui/theme.txt":      "render color palette\n",
+		".git/config":       "retry retry retry\n",
+		"node_modules/x.js": "retry backoff\n",
+	})
+	embedder := &wordEmbedder{model: "words"}
+
+	idx, stats, err := Build(context.Background(), dir, nil, embedder, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats.Files != 3 || stats.Embedded != 3 {
+		t.Fatalf("Expected 3 files embedded, hidden and vendored ones skipped, got %+v", stats)
+	}
+
+	path := PathFor(dir)
+	if err := idx.Save(path); err != nil {
+		t.Fatalf("Expected no error saving, got %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Expected no error loading, got %v", err)
+	}
+
+	results, err := loaded.Search(context.Background(), embedder, "how is backoff and retry done", 1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(results) != 1 || results[0].Path != "net/retry.go" || results[0].Symbol != "Do" {
+		t.Fatalf("Expected the retry function first, got %+v", results)
+	}
+	if !strings.Contains(results[0].Text, "func Do()") || results[0].StartLine != 3 {
+		t.Errorf("Expected the chunk text with its doc comment, got %+v", results[0])
+	}
+}
+
+func TestBuildIsIncremental(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "retry\n", "b.txt": "parse\n", "c.txt": "color\n"})
+	embedder := &wordEmbedder{model: "words"}
+	idx, _, err := Build(context.Background(), dir, nil, embedder, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, dir, map[string]string{"b.txt": "parse json\n"})
+	os.Remove(filepath.Join(dir, "c.txt"))
+	embedder.texts = 0
+	idx, stats, err := Build(context.Background(), dir, idx, embedder, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Embedded != 1 || stats.Unchanged != 1 || stats.Removed != 1 || embedder.texts != 1 {
+		t.Fatalf("Expected only the changed file embedded, got %+v with %d texts", stats, embedder.texts)
+	}
+
+	// Search flags files changed after indexing
+	writeFiles(t, dir, map[string]string{"a.txt": "retry retry\n"})
+	results, err := idx.Search(context.Background(), embedder, "retry", 1)
+	if err != nil || len(results) != 1 || !results[0].Stale {
+		t.Fatalf("Expected the changed file flagged as stale, got %+v, %v", results, err)
+	}
+
+	// Another model cannot reuse the vectors
+	other := &wordEmbedder{model: "other"}
+	if _, err := idx.Search(context.Background(), other, "retry", 1); err == nil {
+		t.Error("Expected an error searching with a different model")
+	}
+	if _, stats, _ := Build(context.Background(), dir, idx, other, nil); stats.Embedded != 2 {
+		t.Errorf("Expected a different model to re-embed everything, got %+v", stats)
+	}
+}
+
+func TestKeywordSearch(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.go": "package a\n\nfunc Retry() {\n\t// exponential backoff\n}\n",
+		"b.go": "package b\n\nfunc Other() {}\n",
+	})
+	results, err := KeywordSearch(dir, "exponential backoff", 5)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(results) != 1 || results[0].Path != "a.go" || results[0].Score != 1 {
+		t.Fatalf("Expected one full match in a.go, got %+v", results)
+	}
+	if results[0].StartLine != 2 || !strings.Contains(results[0].Text, "func Retry()") {
+		t.Errorf("Expected the match with its context, got %+v", results[0])
+	}
+}
+
+func TestWalkHonorsGitignore(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	if out, err := exec.Command("git", "-C", dir, "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v: %s", err, out)
+	}
+	writeFiles(t, dir, map[string]string{
+		".gitignore":        "gen/\n*.log\n",
+		"main.go":           "package main\n",
+		"gen/out.go":        "package gen\n",
+		"run.log":           "log\n",
+		"vendor/dep/dep.go": "package dep\n",
+	})
+
+	var got []string
+	if err := Walk(dir, func(rel string, content []byte) { got = append(got, rel) }); err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if len(got) != 1 || got[0] != "main.go" {
+		t.Errorf("Expected only main.go, the ignored, hidden and vendored files skipped, got %v", got)
+	}
+}
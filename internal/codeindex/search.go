@@ -0,0 +1,119 @@
+package codeindex
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// keywordContext is how many lines around a keyword match are shown
+const keywordContext = 2
+
+// Result is a chunk matching a search
+type Result struct {
+	Path      string // Slash path relative to the index root
+	StartLine int    // 1-based lines, inclusive
+	EndLine   int
+	Symbol    string  // Declaration the chunk holds, if known
+	Score     float64 // Cosine similarity, or the fraction of keywords matched
+	Stale     bool    // The file changed since it was indexed
+	Text      string  // The lines of the chunk as they are now
+}
+
+// Search returns the topK chunks closest in meaning to query, best first
+func (idx *Index) Search(ctx context.Context, embedder Embedder, query string, topK int) ([]Result, error) {
+	if embedder.Model() != idx.Model {
+		return nil, fmt.Errorf("the index was built with embedding model %s, not %s; run codex index again", idx.Model, embedder.Model())
+	}
+	vectors, err := embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	q := vectors[0]
+
+	var results []Result
+	for path, file := range idx.Files {
+		for _, c := range file.Chunks {
+			results = append(results, Result{Path: path, StartLine: c.StartLine, EndLine: c.EndLine, Symbol: c.Symbol, Score: cosine(q, c.Vector)})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Path != results[j].Path {
+			return results[i].Path < results[j].Path
+		}
+		return results[i].StartLine < results[j].StartLine
+	})
+	if len(results) > topK {
+		results = results[:topK]
+	}
+
+	for i := range results {
+		r := &results[i]
+		content, err := os.ReadFile(filepath.Join(idx.Root, filepath.FromSlash(r.Path)))
+		if err != nil {
+			r.Stale = true
+			continue
+		}
+		r.Stale = hashOf(content) != idx.Files[r.Path].Hash
+		r.Text = linesOf(string(content), r.StartLine, r.EndLine)
+	}
+	return results, nil
+}
+
+// KeywordSearch is the fallback without an index: it returns the topK lines
+// of files under root matching the most words of query, with a little context
+func KeywordSearch(root, query string, topK int) ([]Result, error) {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var results []Result
+	err := Walk(root, func(rel string, content []byte) {
+		text := string(content)
+		lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+		for n, line := range lines {
+			lower := strings.ToLower(line)
+			matched := 0
+			for _, term := range terms {
+				if strings.Contains(lower, term) {
+					matched++
+				}
+			}
+			if matched == 0 {
+				continue
+			}
+			start, end := max(1, n+1-keywordContext), min(len(lines), n+1+keywordContext)
+			results = append(results, Result{
+				Path:      rel,
+				StartLine: start,
+				EndLine:   end,
+				Score:     float64(matched) / float64(len(terms)),
+				Text:      linesOf(text, start, end),
+			})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > topK {
+		results = results[:topK]
+	}
+	return results, nil
+}
+
+// linesOf returns lines from..to (1-based, inclusive) of text
+func linesOf(text string, from, to int) string {
+	lines := strings.Split(text, "\n")
+	if from > len(lines) {
+		return ""
+	}
+	return strings.Join(lines[from-1:min(to, len(lines))], "\n")
+}
@@ -3,8 +3,12 @@ package config
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/spf13/viper"
 )
@@ -24,6 +28,154 @@ const (
 	DangerousAutoApprove ApprovalMode = "dangerous"
 )
 
+// OrphanedResultPolicy decides what SendFunctionResult does with the last
+// result of a turn whose interaction has already ended (its handler was
+// cleared, e.g. because the request's context was cancelled). The result is
+// recorded in the history under every policy, so the conversation stays valid.
+type OrphanedResultPolicy string
+
+const (
+	// OrphanedResultDrop records the result without a follow-up request and reports success
+	OrphanedResultDrop OrphanedResultPolicy = "drop"
+	// OrphanedResultError records the result without a follow-up request and
+	// returns agent.ErrOrphanedToolResult
+	OrphanedResultError OrphanedResultPolicy = "error"
+	// OrphanedResultFollowUp records the result and sends the follow-up request
+	// anyway, discarding the streamed response
+	OrphanedResultFollowUp OrphanedResultPolicy = "follow-up"
+)
+
+// QuestionPolicy decides how unattended runs (quiet mode and codex run)
+// answer a question the model asks that QuestionAnswers does not cover
+type QuestionPolicy string
+
+const (
+	// QuestionAssume answers with QuestionDefaultAnswer, telling the model to
+	// make a reasonable assumption and proceed
+	QuestionAssume QuestionPolicy = "assume"
+	// QuestionFail stops the run with a distinct exit code
+	QuestionFail QuestionPolicy = "fail"
+)
+
+// InjectionGuard is how tool outputs are defended against prompt injection,
+// i.e. text in a file or command output that tries to instruct the model
+type InjectionGuard string
+
+const (
+	// InjectionGuardOff sends tool outputs to the model as they are
+	InjectionGuardOff InjectionGuard = "off"
+	// InjectionGuardWarn delimits tool outputs as data and flags outputs that
+	// look like instructions to the model and the user (default)
+	InjectionGuardWarn InjectionGuard = "warn"
+	// InjectionGuardStrict also withholds flagged outputs from the model
+	// unless the user approves them
+	InjectionGuardStrict InjectionGuard = "strict"
+)
+
+// SystemPromptMerge is how the system prompt sources are combined with the
+// base system prompt in a request
+type SystemPromptMerge string
+
+const (
+	// SystemPromptConcatenate joins the base prompt and the sources into one
+	// system message, separated by blank lines (default)
+	SystemPromptConcatenate SystemPromptMerge = "concatenate"
+	// SystemPromptSeparate sends the base prompt and each source as system
+	// messages of their own
+	SystemPromptSeparate SystemPromptMerge = "separate"
+)
+
+// ContextStrategy is how the conversation is fitted to the history's token
+// limit
+type ContextStrategy string
+
+const (
+	// ContextPrune prunes the oldest messages from the history itself,
+	// summarizing when the retained messages still overflow (default)
+	ContextPrune ContextStrategy = "prune"
+	// ContextAge keeps the whole history and leaves the oldest messages out
+	// of each request
+	ContextAge ContextStrategy = "age"
+	// ContextRelevance keeps the whole history and packs each request with
+	// the most relevant messages, stubbing stale tool output
+	ContextRelevance ContextStrategy = "relevance"
+)
+
+// ReasoningEffort is how much a reasoning model thinks before it answers:
+// more effort trades latency and cost for quality
+type ReasoningEffort string
+
+const (
+	ReasoningEffortLow    ReasoningEffort = "low"
+	ReasoningEffortMedium ReasoningEffort = "medium"
+	ReasoningEffortHigh   ReasoningEffort = "high"
+)
+
+// CacheControl is when requests carry explicit prompt cache breakpoints, the
+// cache_control markers Anthropic models need to cache a prompt prefix.
+// OpenAI caches long prefixes by itself and needs none.
+type CacheControl string
+
+const (
+	// CacheControlAuto marks requests to models whose name contains "claude" (default)
+	CacheControlAuto CacheControl = "auto"
+	// CacheControlAlways marks every request
+	CacheControlAlways CacheControl = "always"
+	// CacheControlNever sends requests unmarked
+	CacheControlNever CacheControl = "never"
+)
+
+// ToolResultFormat is how tool results are encoded in the tool messages
+// sent to the model. Some models understand plain text results better.
+type ToolResultFormat string
+
+const (
+	// ToolResultJSON sends results as {"success": ..., "output": ...} objects (default)
+	ToolResultJSON ToolResultFormat = "json"
+	// ToolResultText sends the output as it is and failures as "Error: ..." text
+	ToolResultText ToolResultFormat = "text"
+)
+
+// ProviderResultFormat is the tool result format of one provider
+type ProviderResultFormat struct {
+	BaseURL string           `mapstructure:"base_url"` // Applies when base_url starts with this, e.g. https://api.groq.com
+	Format  ToolResultFormat `mapstructure:"format"`
+}
+
+// RequestProfile is what a provider or model needs of the requests it is
+// sent. A profile applies to requests whose base_url and model start with its
+// BaseURL and Model; an empty one matches any.
+type RequestProfile struct {
+	BaseURL            string `mapstructure:"base_url"`             // e.g. https://api.anthropic.com
+	Model              string `mapstructure:"model"`                // Model name prefix, e.g. claude
+	RequireMaxTokens   bool   `mapstructure:"require_max_tokens"`   // Always send max_tokens, DefaultRequiredMaxTokens when it is 0
+	ExplicitToolChoice bool   `mapstructure:"explicit_tool_choice"` // Send tool_choice "auto" with the tools instead of leaving it out
+	StrictTools        bool   `mapstructure:"strict_tools"`         // Mark function definitions strict, their schemas closed with additionalProperties false
+	StreamUsage        bool   `mapstructure:"stream_usage"`         // Ask for usage in the final chunk of a stream (stream_options.include_usage)
+}
+
+// SystemPromptSource is an addition to the system prompt, merged with the
+// base prompt when each request is sent rather than stored in the history
+type SystemPromptSource struct {
+	Name     string `mapstructure:"name"`
+	Priority int    `mapstructure:"priority"` // Merge order: lower first; the base prompt is 0 and goes first among equals
+	Text     string `mapstructure:"text"`
+	File     string `mapstructure:"file"` // Read for every request instead of Text (relative to CWD)
+}
+
+// ModelPrice is what a model costs, in US dollars per million tokens
+type ModelPrice struct {
+	Input  float64 `mapstructure:"input"`  // Prompt tokens
+	Output float64 `mapstructure:"output"` // Completion tokens
+}
+
+// ToolOverride customizes one built-in tool
+type ToolOverride struct {
+	Disabled    bool              `mapstructure:"disabled"`    // Not offered to the model; its calls are refused
+	Description string            `mapstructure:"description"` // Replaces the tool's description
+	Parameters  map[string]string `mapstructure:"parameters"`  // Parameter name -> text appended to its description
+}
+
 // Config holds all configuration options for the application
 type Config struct {
 	// API configuration
@@ -32,21 +184,186 @@ type Config struct {
 	BaseURL    string `mapstructure:"base_url"`
 	APITimeout int    `mapstructure:"api_timeout"` // in seconds
 
+	// Reasoning models are sent the reasoning effort, and no temperature,
+	// which they do not accept; for other models the effort is ignored
+	ReasoningEffort ReasoningEffort `mapstructure:"reasoning_effort"` // low, medium or high (empty = the provider's default)
+	ReasoningModels []string        `mapstructure:"reasoning_models"` // Model name prefixes (default: DefaultReasoningModels)
+
+	// Model routing: with both models set, turns are answered by the explore
+	// model unless the user's message matches an edit intent, or the model
+	// asks for a stronger one with request_stronger_model; either switches
+	// to the edit model for the rest of the turn. Model is then unused.
+	ExploreModel string   `mapstructure:"explore_model"` // Default model of a turn
+	EditModel    string   `mapstructure:"edit_model"`    // Model of turns that change code
+	EditIntents  []string `mapstructure:"edit_intents"`  // Words or phrases of edit requests, matched whole and case-insensitively (default: DefaultEditIntents)
+
+	// Context window: what is left of it after the prompt and the output
+	// reserved for the response is reported by the agent's RemainingContextTokens
+	ContextWindow int `mapstructure:"context_window"` // Tokens the model takes, prompt and output (0 = ContextWindowFor's default)
+	MaxTokens     int `mapstructure:"max_tokens"`     // Output tokens requested and reserved per response (0 = the provider's default, nothing reserved)
+
+	// Candidate answers: responses to new input are requested with this many
+	// choices (the API's n). Choice 0 drives the turn: its tool calls run and
+	// it joins the history. The others are streamed as "message" items tagged
+	// with their choice index and returned by the agent's Candidates; their
+	// tool calls are ignored. Responses to tool results ask for one choice.
+	Choices int `mapstructure:"choices"` // Choices per response (0 or 1 = one)
+
 	// Project configuration
 	CWD               string `mapstructure:"cwd"`
+	WorkingDir        string `mapstructure:"working_dir"` // Directory file and shell tools resolve paths against (default: CWD)
 	ProjectDocPath    string `mapstructure:"project_doc_path"`
 	DisableProjectDoc bool   `mapstructure:"disable_project_doc"`
 	Instructions      string `mapstructure:"instructions"`
+	InstructionsFile  string `mapstructure:"instructions_file"`  // File the instructions are read from instead (relative to CWD)
+	WatchInstructions bool   `mapstructure:"watch_instructions"` // Reload InstructionsFile into the system prompt when it changes
+
+	// Periodic reminder of key rules, sent with requests but never stored in the history
+	SystemReminder      string `mapstructure:"system_reminder"`       // Reminder text (default: DefaultSystemReminder)
+	SystemReminderEvery int    `mapstructure:"system_reminder_every"` // Assistant turns between reminders (0 = never)
+
+	// Additions to the base system prompt, merged in priority order at send time
+	SystemPromptSources []SystemPromptSource `mapstructure:"system_prompt_sources"`
+	SystemPromptMerge   SystemPromptMerge    `mapstructure:"system_prompt_merge"` // concatenate (default) or separate
+
+	// Prompt caching: breakpoints on the system prompt and the oldest retained
+	// message, for providers that only cache marked prefixes
+	CacheControl CacheControl `mapstructure:"cache_control"` // auto (default), always or never
+
+	// Tool results are sent to the model as JSON objects, or as plain text
+	// for providers whose models read that better. The history keeps JSON.
+	ToolResultFormat  ToolResultFormat       `mapstructure:"tool_result_format"`  // json (default) or text
+	ToolResultFormats []ProviderResultFormat `mapstructure:"tool_result_formats"` // Per provider, overriding tool_result_format
+
+	// Request shaping: the first request profile matching the provider and
+	// model, else the first of DefaultRequestProfiles, sets the request
+	// fields it requires and whether tools are sent strict
+	RequestProfiles []RequestProfile `mapstructure:"request_profiles"`
+
+	// Large payloads (full tool outputs, file snapshots, long session
+	// messages) go to the project's content-addressed store in .codex/blobs;
+	// "codex gc" removes blobs no saved session references
+	BlobStore       bool `mapstructure:"blob_store"`       // Default: true
+	BlobCompression bool `mapstructure:"blob_compression"` // Store blobs zstd-compressed
+
+	// Workspace snapshots (codex snapshot): in a git repository a hidden
+	// commit on refs/codex/snapshots, else copies of the files in the blob store
+	AutoSnapshot        bool  `mapstructure:"auto_snapshot"`          // Snapshot the workspace before each full-auto turn (default: true)
+	SnapshotMaxFileSize int64 `mapstructure:"snapshot_max_file_size"` // Outside git, larger files are left out (0 = 1 MiB)
+
+	// Project facts (language, build/test/lint commands) detected from the
+	// repository and cached in .codex/project-facts.json
+	DisableProjectFacts bool `mapstructure:"disable_project_facts"`
+
+	// Project memory (.codex/memory.json, read and written by the remember/recall tools)
+	DisableMemory     bool `mapstructure:"disable_memory"`
+	MemoryPromptBytes int  `mapstructure:"memory_prompt_bytes"` // Cap on memory injected into the system prompt
+
+	// Code navigation tools backed by language servers (started on first use)
+	DisableLanguageServers bool              `mapstructure:"disable_language_servers"`
+	LanguageServers        map[string]string `mapstructure:"language_servers"` // File extension -> server command line
+
+	// Files written by write_file and patch_file are normalized to the
+	// .editorconfig properties of their path (indentation, trailing
+	// whitespace, final newline, line endings)
+	DisableEditorConfig bool `mapstructure:"disable_editorconfig"`
+
+	// Semantic code search: codex index embeds the workspace into
+	// .codex/index.json and the semantic_search tool queries it. Off by
+	// default because embedding costs money.
+	SemanticSearch bool   `mapstructure:"semantic_search"`
+	EmbeddingModel string `mapstructure:"embedding_model"` // Default: DefaultEmbeddingModel
+
+	// Session persistence (write-ahead journal plus periodic autosave, for crash recovery)
+	SessionDir       string `mapstructure:"session_dir"`       // Where sessions are saved (default: ~/.codex/sessions)
+	AutosaveInterval int    `mapstructure:"autosave_interval"` // Seconds between autosaves (0 = default, <0 = no journal or autosave)
+	CaptureRequests  bool   `mapstructure:"capture_requests"`  // Store each request's full body and streamed response next to the session (read by codex inspect)
+
+	// History retention: what pruning keeps when the history outgrows its
+	// token limit, however old it is (pinned messages are always kept)
+	HistoryDropTask         bool `mapstructure:"history_drop_task"`          // Let the first user message, the task statement, be pruned
+	HistoryRecentTurns      int  `mapstructure:"history_recent_turns"`       // Last turns kept (0 = DefaultHistoryRecentTurns)
+	HistoryRecentToolRounds int  `mapstructure:"history_recent_tool_rounds"` // Last tool rounds of those turns kept (0 = DefaultHistoryRecentToolRounds)
+
+	ContextStrategy ContextStrategy `mapstructure:"context_strategy"` // prune (default), age or relevance
+
+	// Usage analytics: every turn's tokens, cost, tool calls and duration are
+	// appended here, shared by all codex processes (read by /stats and codex stats)
+	UsageLog string `mapstructure:"usage_log"` // JSON Lines file (default: ~/.codex/usage.jsonl; empty disables)
+
+	// Streaming: "message" updates are coalesced and flushed when either limit
+	// is reached, and always at the end of a response (both 0 = every delta)
+	MessageFlushIntervalMs int `mapstructure:"message_flush_interval_ms"` // At most one update per interval
+	MessageFlushChars      int `mapstructure:"message_flush_chars"`       // Or once this many characters are new
+
+	// Inline reasoning: text between <tag> and </tag> for these tag names (e.g.
+	// "think", "scratchpad") is split out of responses, sent as "reasoning"
+	// items and kept out of the history. Empty for models with a separate
+	// reasoning channel.
+	ThinkTags []string `mapstructure:"think_tags"`
+
+	// Empty responses (no text and no tool calls) are reported with an
+	// "empty_response" item; with this set the response is requested once more first
+	RetryEmptyResponse bool `mapstructure:"retry_empty_response"`
+
+	// Questions the model asks (through ask_user, or by ending its reply with
+	// one) in unattended runs are answered from QuestionAnswers when one of its
+	// keys appears in the question, and otherwise per QuestionPolicy
+	QuestionAnswers       map[string]string `mapstructure:"question_answers"`        // Keyword or phrase -> answer
+	QuestionPolicy        QuestionPolicy    `mapstructure:"question_policy"`         // assume (default) or fail
+	QuestionDefaultAnswer string            `mapstructure:"question_default_answer"` // Answer under assume (default: DefaultQuestionAnswer)
+
+	// ask_user_choice calls in unattended runs are answered from ChoiceAnswers,
+	// keyed by the hash of the question, which the call's error names when
+	// there is no answer; --choices adds the answers of a JSON file
+	ChoiceAnswers map[string]string `mapstructure:"choice_answers"` // Question hash -> selection, one option per line
 
 	// UI configuration
 	FullStdout bool `mapstructure:"full_stdout"` // Don't truncate command output
 
 	// Approval configuration
 	ApprovalMode ApprovalMode `mapstructure:"approval_mode"`
+	ReadOnly     bool         `mapstructure:"read_only"` // Withhold mutation tools and refuse file writes
+	NoReview     bool         `mapstructure:"no_review"` // Skip the end-of-turn review of auto-applied edits
 
 	// Logging configuration
 	Debug   bool   `mapstructure:"debug"`    // Enable debug logging
 	LogFile string `mapstructure:"log_file"` // Path to log file
+
+	// Tool configuration
+	Shell                    []string             `mapstructure:"shell"`                       // Program and flags commands are passed to, e.g. [bash, -euo, pipefail, -c] (default: /bin/sh -c; cmd.exe /C on Windows)
+	CommandEnv               map[string]string    `mapstructure:"command_env"`                 // Variables set for commands; merged over DefaultCommandEnv, "" leaves one unset
+	ToolErrorRepeatThreshold int                  `mapstructure:"tool_error_repeat_threshold"` // Identical failures before collapsing (0 = default, <0 = disabled)
+	OrphanedToolResults      OrphanedResultPolicy `mapstructure:"orphaned_tool_results"`       // drop (default), error or follow-up
+	ToolOutputGuard          InjectionGuard       `mapstructure:"tool_output_guard"`           // Prompt injection defense: warn (default), strict or off
+
+	// Continuation requests when a response is cut off at the output token limit (0 = default, <0 = disabled)
+	MaxContinuations int `mapstructure:"max_continuations"`
+
+	// Usage budget for the session; once reached, further requests are refused (0 = no limit)
+	BudgetTokens int                   `mapstructure:"budget_tokens"`  // Total tokens, prompt and completion
+	BudgetUSD    float64               `mapstructure:"budget_usd"`     // Estimated cost in US dollars
+	BudgetWarnAt float64               `mapstructure:"budget_warn_at"` // Fraction of the budget that triggers a warning (default 0.8)
+	ModelPrices  map[string]ModelPrice `mapstructure:"model_prices"`   // Per-model prices; merged over DefaultModelPrices
+
+	// Client-side rate limit: requests wait for a slot rather than run into the
+	// provider's per-minute limits (0 = no limit)
+	RateLimitRPM int `mapstructure:"rate_limit_rpm"` // Requests per minute
+	RateLimitTPM int `mapstructure:"rate_limit_tpm"` // Tokens per minute, prompt and completion
+
+	// Tool execution limits (calls beyond them wait in a FIFO queue; 0 or less is unlimited)
+	MaxConcurrentTools int            `mapstructure:"max_concurrent_tools"` // Across all tools
+	ToolConcurrency    map[string]int `mapstructure:"tool_concurrency"`     // Per tool; merged over DefaultToolConcurrency
+
+	// Built-in tool customizations, keyed by tool name
+	Tools map[string]ToolOverride `mapstructure:"tools"`
+
+	// Tool output summarization (results larger than the threshold are condensed for the model)
+	ToolOutputSummaryThreshold  int               `mapstructure:"tool_output_summary_threshold"`  // Bytes; 0 disables summarization
+	ToolOutputMaxSizes          map[string]int    `mapstructure:"tool_output_max_sizes"`          // Per-tool cap in bytes ("*" for any tool); overrides the threshold
+	ToolOutputSummaryStrategies map[string]string `mapstructure:"tool_output_summary_strategies"` // Per-tool strategy: extract, truncate, model, none; read_file is kept whole unless named
+	ToolOutputSummaryModel      string            `mapstructure:"tool_output_summary_model"`      // Model used by the "model" strategy
+	ToolOutputDir               string            `mapstructure:"tool_output_dir"`                // Where full outputs are saved (default: .codex/tool-outputs in the tool directory)
 }
 
 const (
@@ -55,6 +372,46 @@ const (
 	DefaultBaseURL    = "https://api.openai.com/v1"
 	DefaultAPITimeout = 60 // seconds
 	DefaultConfigDir  = ".codex"
+
+	// DefaultToolOutputSummaryModel is the cheap model used to summarize large tool outputs
+	DefaultToolOutputSummaryModel = "gpt-4o-mini"
+
+	// DefaultQuestionAnswer is sent for questions in unattended runs under the assume policy
+	DefaultQuestionAnswer = "Nobody is available to answer questions in this run. Make a reasonable assumption, say what you assumed, and proceed."
+
+	// DefaultEmbeddingModel embeds code for semantic search
+	DefaultEmbeddingModel = "text-embedding-3-small"
+
+	// DefaultMemoryPromptBytes caps how much project memory is injected into the system prompt
+	DefaultMemoryPromptBytes = 4096
+
+	// DefaultToolErrorRepeatThreshold is how many identical tool failures trigger the repeat guard
+	DefaultToolErrorRepeatThreshold = 3
+
+	// DefaultMaxContinuations is how many times a response cut off at the token limit is continued
+	DefaultMaxContinuations = 3
+
+	// DefaultSystemReminder is the reminder sent every SystemReminderEvery turns when no text is configured
+	DefaultSystemReminder = "Reminder: keep following the system instructions above. Stay within the user's request, use the provided tools instead of guessing file contents, and ask before doing anything destructive."
+
+	// DefaultBudgetWarnAt is the fraction of a usage budget after which a warning is emitted
+	DefaultBudgetWarnAt = 0.8
+
+	// DefaultMaxConcurrentTools is how many tool calls may run at once across all tools
+	DefaultMaxConcurrentTools = 4
+
+	// DefaultContextWindow is the context window of models DefaultContextWindows does not know
+	DefaultContextWindow = 8192
+
+	// DefaultHistoryRecentTurns is how many of the last turns pruning keeps
+	DefaultHistoryRecentTurns = 1
+
+	// DefaultHistoryRecentToolRounds is how many of the last tool calls, with
+	// their results, pruning keeps in those turns
+	DefaultHistoryRecentToolRounds = 8
+
+	// DefaultAutosaveInterval is how often, in seconds, the session journal is folded into its snapshot
+	DefaultAutosaveInterval = 30
 )
 
 // Load loads configuration from files, environment variables, and flags
@@ -66,6 +423,16 @@ func Load() (*Config, error) {
 		APITimeout:   DefaultAPITimeout,
 		ApprovalMode: Suggest,
 		CWD:          getWorkingDirectory(),
+		SessionDir:   filepath.Join(getConfigDir(), "sessions"),
+		UsageLog:     filepath.Join(getConfigDir(), "usage.jsonl"),
+
+		LanguageServers:          DefaultLanguageServers(),
+		MaxConcurrentTools:       DefaultMaxConcurrentTools,
+		MemoryPromptBytes:        DefaultMemoryPromptBytes,
+		ToolErrorRepeatThreshold: DefaultToolErrorRepeatThreshold,
+		MaxContinuations:         DefaultMaxContinuations,
+		BlobStore:                true,
+		AutoSnapshot:             true,
 	}
 
 	// Set up viper
@@ -100,12 +467,126 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("error unmarshaling config: %w", err)
 	}
 
-	// Load instructions from file if it exists
-	instructionsPath := filepath.Join(configDir, "instructions.md")
-	if _, err := os.Stat(instructionsPath); err == nil {
-		data, err := os.ReadFile(instructionsPath)
-		if err == nil {
-			config.Instructions = string(data)
+	// Resolve ${VAR} references, so secrets need not be written into the file
+	if err := config.expandEnv(); err != nil {
+		return nil, err
+	}
+
+	// The tools' working directory must exist before any tool runs
+	if config.WorkingDir != "" {
+		if err := config.validateWorkingDir(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.BudgetTokens < 0 || config.BudgetUSD < 0 {
+		return nil, fmt.Errorf("invalid budget: budget_tokens and budget_usd must not be negative")
+	}
+	if config.RateLimitRPM < 0 || config.RateLimitTPM < 0 {
+		return nil, fmt.Errorf("invalid rate limit: rate_limit_rpm and rate_limit_tpm must not be negative")
+	}
+
+	// A missing interpreter would otherwise only show when the first command fails
+	if len(config.Shell) > 0 {
+		if _, err := exec.LookPath(config.Shell[0]); err != nil {
+			return nil, fmt.Errorf("invalid shell: %w", err)
+		}
+	}
+
+	for _, tag := range config.ThinkTags {
+		if !validThinkTag(tag) {
+			return nil, fmt.Errorf("invalid think_tags entry %q: expected a tag name such as think, without angle brackets", tag)
+		}
+	}
+
+	switch config.OrphanedToolResults {
+	case "", OrphanedResultDrop, OrphanedResultError, OrphanedResultFollowUp:
+	default:
+		return nil, fmt.Errorf("invalid orphaned_tool_results %q: expected drop, error or follow-up", config.OrphanedToolResults)
+	}
+	switch config.ToolOutputGuard {
+	case "", InjectionGuardOff, InjectionGuardWarn, InjectionGuardStrict:
+	default:
+		return nil, fmt.Errorf("invalid tool_output_guard %q: expected warn, strict or off", config.ToolOutputGuard)
+	}
+	switch config.SystemPromptMerge {
+	case "", SystemPromptConcatenate, SystemPromptSeparate:
+	default:
+		return nil, fmt.Errorf("invalid system_prompt_merge %q: expected concatenate or separate", config.SystemPromptMerge)
+	}
+	if err := config.validateSystemPromptSources(); err != nil {
+		return nil, err
+	}
+	switch config.ReasoningEffort {
+	case "", ReasoningEffortLow, ReasoningEffortMedium, ReasoningEffortHigh:
+	default:
+		return nil, fmt.Errorf("invalid reasoning_effort %q: expected low, medium or high", config.ReasoningEffort)
+	}
+	switch config.CacheControl {
+	case "", CacheControlAuto, CacheControlAlways, CacheControlNever:
+	default:
+		return nil, fmt.Errorf("invalid cache_control %q: expected auto, always or never", config.CacheControl)
+	}
+	switch config.ToolResultFormat {
+	case "", ToolResultJSON, ToolResultText:
+	default:
+		return nil, fmt.Errorf("invalid tool_result_format %q: expected json or text", config.ToolResultFormat)
+	}
+	for _, provider := range config.ToolResultFormats {
+		if provider.BaseURL == "" || (provider.Format != ToolResultJSON && provider.Format != ToolResultText) {
+			return nil, fmt.Errorf("invalid tool_result_formats entry %+v: expected a base_url and a format of json or text", provider)
+		}
+	}
+	for _, profile := range config.RequestProfiles {
+		if profile.BaseURL == "" && profile.Model == "" {
+			return nil, fmt.Errorf("invalid request_profiles entry %+v: expected a base_url or a model", profile)
+		}
+	}
+	if config.ContextWindow < 0 {
+		return nil, fmt.Errorf("invalid context_window %d: expected 0 or more", config.ContextWindow)
+	}
+	if config.MaxTokens < 0 {
+		return nil, fmt.Errorf("invalid max_tokens %d: expected 0 or more", config.MaxTokens)
+	}
+	if config.Choices < 0 {
+		return nil, fmt.Errorf("invalid choices %d: expected 0 or more", config.Choices)
+	}
+	if (config.ExploreModel == "") != (config.EditModel == "") {
+		return nil, fmt.Errorf("invalid model routing: explore_model and edit_model must be set together")
+	}
+	switch config.ContextStrategy {
+	case "", ContextPrune, ContextAge, ContextRelevance:
+	default:
+		return nil, fmt.Errorf("invalid context_strategy %q: expected prune, age or relevance", config.ContextStrategy)
+	}
+	if config.HistoryRecentTurns < 0 {
+		return nil, fmt.Errorf("invalid history_recent_turns %d: expected 0 or more", config.HistoryRecentTurns)
+	}
+	if config.HistoryRecentToolRounds < 0 {
+		return nil, fmt.Errorf("invalid history_recent_tool_rounds %d: expected 0 or more", config.HistoryRecentToolRounds)
+	}
+	switch config.QuestionPolicy {
+	case "", QuestionAssume, QuestionFail:
+	default:
+		return nil, fmt.Errorf("invalid question_policy %q: expected assume or fail", config.QuestionPolicy)
+	}
+
+	// Load instructions from the configured file, or from the config
+	// directory's instructions.md if it exists
+	if config.InstructionsFile != "" {
+		config.InstructionsFile = config.ResolveInstructionsFile()
+		data, err := os.ReadFile(config.InstructionsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read instructions file: %w", err)
+		}
+		config.Instructions = string(data)
+	} else {
+		instructionsPath := filepath.Join(configDir, "instructions.md")
+		if _, err := os.Stat(instructionsPath); err == nil {
+			data, err := os.ReadFile(instructionsPath)
+			if err == nil {
+				config.Instructions = string(data)
+			}
 		}
 	}
 
@@ -121,6 +602,347 @@ func Load() (*Config, error) {
 	return config, nil
 }
 
+// envReference matches a ${VAR} reference in a config value
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} references in the fields holding secrets, URLs,
+// names and paths with the variable's value. A reference to an unset variable
+// is an error rather than an empty value. Free-text fields such as
+// instructions and the system reminder are left as written.
+func (c *Config) expandEnv() error {
+	fields := []struct {
+		key   string
+		value *string
+	}{
+		{"api_key", &c.APIKey},
+		{"model", &c.Model},
+		{"explore_model", &c.ExploreModel},
+		{"edit_model", &c.EditModel},
+		{"base_url", &c.BaseURL},
+		{"cwd", &c.CWD},
+		{"working_dir", &c.WorkingDir},
+		{"project_doc_path", &c.ProjectDocPath},
+		{"instructions_file", &c.InstructionsFile},
+		{"session_dir", &c.SessionDir},
+		{"usage_log", &c.UsageLog},
+		{"log_file", &c.LogFile},
+		{"tool_output_summary_model", &c.ToolOutputSummaryModel},
+		{"embedding_model", &c.EmbeddingModel},
+		{"tool_output_dir", &c.ToolOutputDir},
+	}
+	for _, field := range fields {
+		expanded, err := expandEnvReferences(*field.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", field.key, err)
+		}
+		*field.value = expanded
+	}
+
+	for ext, command := range c.LanguageServers {
+		expanded, err := expandEnvReferences(command)
+		if err != nil {
+			return fmt.Errorf("invalid language_servers.%s: %w", ext, err)
+		}
+		c.LanguageServers[ext] = expanded
+	}
+
+	for i := range c.RequestProfiles {
+		profile := &c.RequestProfiles[i]
+		baseURL, err := expandEnvReferences(profile.BaseURL)
+		if err != nil {
+			return fmt.Errorf("invalid request_profiles.%d.base_url: %w", i, err)
+		}
+		model, err := expandEnvReferences(profile.Model)
+		if err != nil {
+			return fmt.Errorf("invalid request_profiles.%d.model: %w", i, err)
+		}
+		profile.BaseURL, profile.Model = baseURL, model
+	}
+
+	for i, src := range c.SystemPromptSources {
+		expanded, err := expandEnvReferences(src.File)
+		if err != nil {
+			return fmt.Errorf("invalid system_prompt_sources.%s.file: %w", src.Name, err)
+		}
+		c.SystemPromptSources[i].File = expanded
+	}
+	return nil
+}
+
+// expandEnvReferences replaces every ${VAR} in value, failing on the first unset variable
+func expandEnvReferences(value string) (string, error) {
+	var missing string
+	expanded := envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok && missing == "" {
+			missing = name
+		}
+		return v
+	})
+	if missing != "" {
+		return "", fmt.Errorf("environment variable %s is not set", missing)
+	}
+	return expanded, nil
+}
+
+// ResolveInstructionsFile returns InstructionsFile as an absolute path,
+// resolving a relative one against CWD
+func (c *Config) ResolveInstructionsFile() string {
+	if c.InstructionsFile == "" || filepath.IsAbs(c.InstructionsFile) {
+		return c.InstructionsFile
+	}
+	return filepath.Join(c.CWD, c.InstructionsFile)
+}
+
+// ResolveSystemPromptFile returns the file of a system prompt source as an
+// absolute path, resolving a relative one against CWD
+func (c *Config) ResolveSystemPromptFile(src SystemPromptSource) string {
+	if src.File == "" || filepath.IsAbs(src.File) {
+		return src.File
+	}
+	return filepath.Join(c.CWD, src.File)
+}
+
+// validateSystemPromptSources checks that every system prompt source has a
+// unique name and exactly one of text and file
+func (c *Config) validateSystemPromptSources() error {
+	names := make(map[string]bool)
+	for i, src := range c.SystemPromptSources {
+		switch {
+		case src.Name == "":
+			return fmt.Errorf("invalid system_prompt_sources entry %d: name is required", i)
+		case names[src.Name]:
+			return fmt.Errorf("invalid system_prompt_sources entry %q: the name is used twice", src.Name)
+		case (src.Text == "") == (src.File == ""):
+			return fmt.Errorf("invalid system_prompt_sources entry %q: expected either text or file", src.Name)
+		}
+		names[src.Name] = true
+	}
+	return nil
+}
+
+// AutosaveEnabled reports whether sessions are journaled and autosaved
+func (c *Config) AutosaveEnabled() bool {
+	return c.SessionDir != "" && c.AutosaveInterval >= 0
+}
+
+// AutosaveEvery returns the interval between autosaves
+func (c *Config) AutosaveEvery() time.Duration {
+	if c.AutosaveInterval == 0 {
+		return DefaultAutosaveInterval * time.Second
+	}
+	return time.Duration(c.AutosaveInterval) * time.Second
+}
+
+// DefaultToolConcurrency returns the per-tool limits used for tools the config does not set
+func DefaultToolConcurrency() map[string]int {
+	return map[string]int{"shell": 1, "read_file": 8}
+}
+
+// ToolLimits returns the per-tool concurrency limits: the defaults overridden by the config
+func (c *Config) ToolLimits() map[string]int {
+	limits := DefaultToolConcurrency()
+	for tool, limit := range c.ToolConcurrency {
+		limits[tool] = limit
+	}
+	return limits
+}
+
+// DefaultCommandEnv returns the variables set for commands so that their
+// output is the same everywhere: untranslated messages, UTC times, no pager
+// and no colors
+func DefaultCommandEnv() map[string]string {
+	return map[string]string{"LC_ALL": "C", "TZ": "UTC", "GIT_PAGER": "cat", "NO_COLOR": "1"}
+}
+
+// CommandEnvironment returns the variables set for commands: the defaults
+// overridden by the config, without those it sets to "". Names are upper
+// case, as config files do not keep the case of keys.
+func (c *Config) CommandEnvironment() map[string]string {
+	env := DefaultCommandEnv()
+	for name, value := range c.CommandEnv {
+		name = strings.ToUpper(name)
+		if value == "" {
+			delete(env, name)
+		} else {
+			env[name] = value
+		}
+	}
+	return env
+}
+
+// ToolDisabled reports whether the config disables the built-in tool name.
+// execute_command is the shell tool under another name.
+func (c *Config) ToolDisabled(name string) bool {
+	if name == "execute_command" {
+		name = "shell"
+	}
+	return c.Tools[name].Disabled
+}
+
+// DefaultModelPrices returns the list prices of common models, used for
+// models the config does not price
+func DefaultModelPrices() map[string]ModelPrice {
+	return map[string]ModelPrice{
+		"gpt-4o":       {Input: 2.50, Output: 10.00},
+		"gpt-4o-mini":  {Input: 0.15, Output: 0.60},
+		"gpt-4.1":      {Input: 2.00, Output: 8.00},
+		"gpt-4.1-mini": {Input: 0.40, Output: 1.60},
+		"gpt-4.1-nano": {Input: 0.10, Output: 0.40},
+		"o3":           {Input: 2.00, Output: 8.00},
+		"o4-mini":      {Input: 1.10, Output: 4.40},
+	}
+}
+
+// DefaultReasoningModels returns the name prefixes of the models that take a
+// reasoning effort
+func DefaultReasoningModels() []string {
+	return []string{"o1", "o3", "o4", "gpt-5"}
+}
+
+// IsReasoningModel reports whether model takes a reasoning effort, by the
+// configured name prefixes or the defaults
+func (c *Config) IsReasoningModel(model string) bool {
+	prefixes := c.ReasoningModels
+	if len(prefixes) == 0 {
+		prefixes = DefaultReasoningModels()
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultEditIntents returns the words that mark a user message as a request
+// to change code
+func DefaultEditIntents() []string {
+	return []string{"edit", "fix", "implement", "refactor", "change", "add", "write", "update", "rename", "remove", "delete", "create"}
+}
+
+// RoutesModels reports whether turns are routed between explore_model and edit_model
+func (c *Config) RoutesModels() bool {
+	return c.ExploreModel != "" && c.EditModel != ""
+}
+
+// MatchesEditIntent reports whether message contains one of the edit
+// intents, or the defaults, as whole words, ignoring case
+func (c *Config) MatchesEditIntent(message string) bool {
+	intents := c.EditIntents
+	if len(intents) == 0 {
+		intents = DefaultEditIntents()
+	}
+	words := func(text string) string {
+		return strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+		}), " ")
+	}
+	text := " " + words(message) + " "
+	for _, intent := range intents {
+		if phrase := words(intent); phrase != "" && strings.Contains(text, " "+phrase+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultContextWindows returns the context windows of common models. Dated
+// snapshots, e.g. gpt-4o-2024-08-06, share the window of their model.
+func DefaultContextWindows() map[string]int {
+	return map[string]int{
+		"gpt-3.5-turbo": 16385,
+		"gpt-4":         8192,
+		"gpt-4-turbo":   128000,
+		"gpt-4o":        128000,
+		"gpt-4o-mini":   128000,
+		"gpt-4.1":       1047576,
+		"gpt-4.1-mini":  1047576,
+		"gpt-4.1-nano":  1047576,
+		"o1":            200000,
+		"o3":            200000,
+		"o3-mini":       200000,
+		"o4-mini":       200000,
+	}
+}
+
+// ContextWindowFor returns the context window of model: context_window if
+// set, else the default of the longest known model name it starts with, else
+// DefaultContextWindow
+func (c *Config) ContextWindowFor(model string) int {
+	if c.ContextWindow > 0 {
+		return c.ContextWindow
+	}
+	window, matched := DefaultContextWindow, ""
+	for name, size := range DefaultContextWindows() {
+		if (model == name || strings.HasPrefix(model, name+"-")) && len(name) > len(matched) {
+			window, matched = size, name
+		}
+	}
+	return window
+}
+
+// ToolResultFormatFor returns how tool results are sent to the provider at
+// baseURL: per the first tool_result_formats entry it starts with, else
+// tool_result_format, else JSON
+func (c *Config) ToolResultFormatFor(baseURL string) ToolResultFormat {
+	for _, provider := range c.ToolResultFormats {
+		if strings.HasPrefix(baseURL, provider.BaseURL) {
+			return provider.Format
+		}
+	}
+	if c.ToolResultFormat != "" {
+		return c.ToolResultFormat
+	}
+	return ToolResultJSON
+}
+
+// DefaultRequiredMaxTokens is the max_tokens sent to providers that require
+// it when max_tokens is not set
+const DefaultRequiredMaxTokens = 4096
+
+// DefaultRequestProfiles returns the request profiles of known providers:
+// Anthropic rejects requests without max_tokens, also for Claude models
+// behind other gateways, and OpenAI accepts strict function schemas and
+// stream_options. Other providers may reject stream_options, so their usage
+// is estimated unless a profile asks for it.
+func DefaultRequestProfiles() []RequestProfile {
+	return []RequestProfile{
+		{BaseURL: "https://api.anthropic.com", RequireMaxTokens: true},
+		{Model: "claude", RequireMaxTokens: true},
+		{BaseURL: "https://api.openai.com", StrictTools: true, StreamUsage: true},
+	}
+}
+
+// RequestProfileFor returns the profile of requests to model at baseURL:
+// the first request_profiles entry that matches, else the first default
+// that does, else no shaping
+func (c *Config) RequestProfileFor(baseURL, model string) RequestProfile {
+	for _, profiles := range [][]RequestProfile{c.RequestProfiles, DefaultRequestProfiles()} {
+		for _, profile := range profiles {
+			if strings.HasPrefix(baseURL, profile.BaseURL) && strings.HasPrefix(model, profile.Model) {
+				return profile
+			}
+		}
+	}
+	return RequestProfile{}
+}
+
+// PriceFor returns the price of model, from the config or the defaults
+func (c *Config) PriceFor(model string) (ModelPrice, bool) {
+	if price, ok := c.ModelPrices[model]; ok {
+		return price, true
+	}
+	price, ok := DefaultModelPrices()[model]
+	return price, ok
+}
+
+// DefaultLanguageServers returns the language servers used when none are configured
+func DefaultLanguageServers() map[string]string {
+	return map[string]string{".go": "gopls"}
+}
+
 // LoadProjectDoc loads the content of the project documentation file if specified
 func (c *Config) LoadProjectDoc() (string, error) {
 	if c.DisableProjectDoc || c.ProjectDocPath == "" {
@@ -135,6 +957,56 @@ func (c *Config) LoadProjectDoc() (string, error) {
 	return string(data), nil
 }
 
+// TurnReviewEnabled reports whether edits applied without per-change approval
+// should be reviewed at the end of a turn
+func (c *Config) TurnReviewEnabled() bool {
+	return !c.NoReview && c.ApprovalMode != Suggest
+}
+
+// AutoSnapshotEnabled reports whether the workspace should be snapshotted
+// before each turn, so a full-auto turn can be rolled back
+func (c *Config) AutoSnapshotEnabled() bool {
+	return c.AutoSnapshot && c.ApprovalMode == FullAuto
+}
+
+// ToolDir returns the directory file and shell tools resolve relative paths against
+func (c *Config) ToolDir() string {
+	if c.WorkingDir != "" {
+		return c.WorkingDir
+	}
+	return c.CWD
+}
+
+// SandboxEnforced reports whether tools are confined to the working directory
+func (c *Config) SandboxEnforced() bool {
+	return c.ApprovalMode == FullAuto
+}
+
+// validateWorkingDir makes WorkingDir absolute and checks that it is a directory
+func (c *Config) validateWorkingDir() error {
+	absDir, err := filepath.Abs(c.WorkingDir)
+	if err != nil {
+		return fmt.Errorf("invalid working_dir %s: %w", c.WorkingDir, err)
+	}
+	info, err := os.Stat(absDir)
+	if err != nil {
+		return fmt.Errorf("invalid working_dir: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("invalid working_dir: %s is not a directory", absDir)
+	}
+	c.WorkingDir = absDir
+	return nil
+}
+
+// thinkTagPattern matches the names allowed in ThinkTags
+var thinkTagPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_:-]*$`)
+
+// validThinkTag reports whether tag is a tag name usable in ThinkTags
+func validThinkTag(tag string) bool {
+	return thinkTagPattern.MatchString(tag)
+}
+
 // getConfigDir returns the path to the config directory
 func getConfigDir() string {
 	homeDir, err := os.UserHomeDir()
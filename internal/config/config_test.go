@@ -1,8 +1,10 @@
 package config
 
 import (
+	"maps"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -125,3 +127,416 @@ func TestLoadProjectDoc(t *testing.T) {
 		t.Errorf("Expected empty content with disabled project doc, got %q", content)
 	}
 }
+
+func TestLoadExpandsEnvReferences(t *testing.T) {
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("TEST_CODEX_SECRET", "sk-from-env")
+	t.Setenv("TEST_CODEX_HOST", "llm.internal")
+
+	configDir := filepath.Join(tmpHome, DefaultConfigDir)
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config directory: %v", err)
+	}
+	configPath := filepath.Join(configDir, "config.yaml")
+	t.Setenv("TEST_CODEX_MODEL", "gpt-4o-mini")
+	content := "api_key: ${TEST_CODEX_SECRET}\nbase_url: https://${TEST_CODEX_HOST}/v1\nsystem_reminder: keep ${TEST_CODEX_SECRET} literal\n" +
+		"explore_model: ${TEST_CODEX_MODEL}\nedit_model: ${TEST_CODEX_MODEL}\nrequest_profiles:\n  - base_url: https://${TEST_CODEX_HOST}\n    require_max_tokens: true\n"
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if cfg.APIKey != "sk-from-env" {
+		t.Errorf("Expected APIKey=sk-from-env, got %s", cfg.APIKey)
+	}
+	if cfg.BaseURL != "https://llm.internal/v1" {
+		t.Errorf("Expected BaseURL=https://llm.internal/v1, got %s", cfg.BaseURL)
+	}
+	if cfg.SystemReminder != "keep ${TEST_CODEX_SECRET} literal" {
+		t.Errorf("Expected free text to be left as written, got %s", cfg.SystemReminder)
+	}
+	if cfg.ExploreModel != "gpt-4o-mini" || cfg.EditModel != "gpt-4o-mini" {
+		t.Errorf("Expected the routed models expanded, got %s and %s", cfg.ExploreModel, cfg.EditModel)
+	}
+	if len(cfg.RequestProfiles) != 1 || cfg.RequestProfiles[0].BaseURL != "https://llm.internal" {
+		t.Errorf("Expected the request profile base_url expanded, got %+v", cfg.RequestProfiles)
+	}
+
+	// An unset variable is an error, not an empty key
+	if err := os.WriteFile(configPath, []byte("api_key: ${TEST_CODEX_UNSET}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	os.Unsetenv("TEST_CODEX_UNSET")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "api_key: environment variable TEST_CODEX_UNSET is not set") {
+		t.Errorf("Expected an unset variable error, got %v", err)
+	}
+	if err := os.WriteFile(configPath, []byte("request_profiles:\n  - base_url: ${TEST_CODEX_UNSET}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "request_profiles.0.base_url: environment variable TEST_CODEX_UNSET is not set") {
+		t.Errorf("Expected an unset variable error for the request profile, got %v", err)
+	}
+}
+
+func TestLoadInstructionsFile(t *testing.T) {
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	configDir := filepath.Join(tmpHome, DefaultConfigDir)
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config directory: %v", err)
+	}
+
+	// The configured file takes precedence over the config directory's instructions.md
+	promptPath := filepath.Join(t.TempDir(), "prompt.md")
+	os.WriteFile(promptPath, []byte("Team prompt v3"), 0644)
+	os.WriteFile(filepath.Join(configDir, "instructions.md"), []byte("Personal instructions"), 0644)
+	configPath := filepath.Join(configDir, "config.yaml")
+	os.WriteFile(configPath, []byte("instructions_file: "+promptPath+"\nwatch_instructions: true\n"), 0644)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if cfg.Instructions != "Team prompt v3" {
+		t.Errorf("Expected instructions from the file, got %q", cfg.Instructions)
+	}
+	if !cfg.WatchInstructions {
+		t.Errorf("Expected WatchInstructions to be set")
+	}
+
+	// A configured file that cannot be read is an error
+	os.WriteFile(configPath, []byte("instructions_file: "+filepath.Join(tmpHome, "missing.md")+"\n"), 0644)
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "failed to read instructions file") {
+		t.Errorf("Expected a read error for a missing instructions file, got %v", err)
+	}
+}
+
+func TestLoadValidatesShell(t *testing.T) {
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	configDir := filepath.Join(tmpHome, DefaultConfigDir)
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config directory: %v", err)
+	}
+	configPath := filepath.Join(configDir, "config.yaml")
+
+	if err := os.WriteFile(configPath, []byte("shell: [sh, -e, -c]\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if len(cfg.Shell) != 3 || cfg.Shell[1] != "-e" {
+		t.Errorf("Expected shell [sh -e -c], got %v", cfg.Shell)
+	}
+
+	if err := os.WriteFile(configPath, []byte("shell: [codex-no-such-shell, -c]\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid shell") {
+		t.Errorf("Expected a missing shell to be rejected, got %v", err)
+	}
+}
+
+func TestLoadSystemPromptSources(t *testing.T) {
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	configDir := filepath.Join(tmpHome, DefaultConfigDir)
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config directory: %v", err)
+	}
+	configPath := filepath.Join(configDir, "config.yaml")
+
+	config := `system_prompt_merge: separate
+system_prompt_sources:
+  - name: style
+    priority: 20
+    text: Prefer small functions.
+  - name: team
+    priority: 10
+    file: team.md
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if cfg.SystemPromptMerge != SystemPromptSeparate {
+		t.Errorf("Expected merge strategy separate, got %q", cfg.SystemPromptMerge)
+	}
+	if len(cfg.SystemPromptSources) != 2 || cfg.SystemPromptSources[0].Name != "style" || cfg.SystemPromptSources[1].Priority != 10 {
+		t.Fatalf("Expected the two sources as configured, got %+v", cfg.SystemPromptSources)
+	}
+	if got := cfg.ResolveSystemPromptFile(cfg.SystemPromptSources[1]); got != filepath.Join(cfg.CWD, "team.md") {
+		t.Errorf("Expected team.md resolved against CWD, got %q", got)
+	}
+
+	for _, bad := range []string{
+		"system_prompt_merge: interleave\n",
+		"system_prompt_sources:\n  - name: a\n    text: x\n  - name: a\n    text: y\n",
+		"system_prompt_sources:\n  - name: a\n    text: x\n    file: a.md\n",
+		"system_prompt_sources:\n  - text: x\n",
+	} {
+		if err := os.WriteFile(configPath, []byte(bad), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected config %q to be rejected", bad)
+		}
+	}
+}
+
+func TestLoadReasoningEffort(t *testing.T) {
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	configDir := filepath.Join(tmpHome, DefaultConfigDir)
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config directory: %v", err)
+	}
+	configPath := filepath.Join(configDir, "config.yaml")
+
+	if err := os.WriteFile(configPath, []byte("reasoning_effort: low\nreasoning_models: [deepseek-r1]\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.ReasoningEffort != ReasoningEffortLow {
+		t.Errorf("Expected effort low, got %q", cfg.ReasoningEffort)
+	}
+	if !cfg.IsReasoningModel("deepseek-r1-distill") || cfg.IsReasoningModel("o3") {
+		t.Errorf("Expected the configured prefixes to replace the defaults")
+	}
+	if !(&Config{}).IsReasoningModel("o4-mini") || (&Config{}).IsReasoningModel("gpt-4o") {
+		t.Errorf("Expected the default prefixes to match o4-mini and not gpt-4o")
+	}
+
+	if err := os.WriteFile(configPath, []byte("reasoning_effort: extreme\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid reasoning_effort") {
+		t.Errorf("Expected an unknown effort to be rejected, got %v", err)
+	}
+}
+
+func TestLoadTools(t *testing.T) {
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	configDir := filepath.Join(tmpHome, DefaultConfigDir)
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config directory: %v", err)
+	}
+	yaml := `tools:
+  write_file:
+    disabled: true
+  shell:
+    description: Run a command
+    parameters:
+      command: Prefer make targets.
+`
+	if err := os.WriteFile(filepath.Join(configDir, "config.yaml"), []byte(yaml), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !cfg.ToolDisabled("write_file") || cfg.ToolDisabled("shell") || cfg.ToolDisabled("read_file") {
+		t.Errorf("Expected only write_file to be disabled, got %+v", cfg.Tools)
+	}
+	shell := cfg.Tools["shell"]
+	if shell.Description != "Run a command" || shell.Parameters["command"] != "Prefer make targets." {
+		t.Errorf("Expected the shell override to be loaded, got %+v", shell)
+	}
+
+	cfg.Tools["shell"] = ToolOverride{Disabled: true}
+	if !cfg.ToolDisabled("execute_command") {
+		t.Errorf("Expected execute_command to be disabled with shell")
+	}
+}
+
+func TestLoadCommandEnv(t *testing.T) {
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	configDir := filepath.Join(tmpHome, DefaultConfigDir)
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config directory: %v", err)
+	}
+	yaml := `command_env:
+  TZ: ""
+  LANG: en_US.UTF-8
+`
+	if err := os.WriteFile(filepath.Join(configDir, "config.yaml"), []byte(yaml), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	want := map[string]string{"LC_ALL": "C", "GIT_PAGER": "cat", "NO_COLOR": "1", "LANG": "en_US.UTF-8"}
+	if got := cfg.CommandEnvironment(); !maps.Equal(got, want) {
+		t.Errorf("Expected the defaults without TZ and with LANG, got %v", got)
+	}
+}
+
+func TestLoadHistoryRetention(t *testing.T) {
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	configDir := filepath.Join(tmpHome, DefaultConfigDir)
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config directory: %v", err)
+	}
+	configPath := filepath.Join(configDir, "config.yaml")
+
+	if err := os.WriteFile(configPath, []byte("history_drop_task: true\nhistory_recent_turns: 3\nhistory_recent_tool_rounds: 5\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !cfg.HistoryDropTask || cfg.HistoryRecentTurns != 3 || cfg.HistoryRecentToolRounds != 5 {
+		t.Errorf("Expected the retention settings to be loaded, got drop task %v, %d turns, %d tool rounds", cfg.HistoryDropTask, cfg.HistoryRecentTurns, cfg.HistoryRecentToolRounds)
+	}
+
+	if err := os.WriteFile(configPath, []byte("history_recent_turns: -1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid history_recent_turns") {
+		t.Errorf("Expected a negative turn count to be rejected, got %v", err)
+	}
+}
+
+func TestContextWindowFor(t *testing.T) {
+	cfg := &Config{}
+	for model, want := range map[string]int{
+		"gpt-4o":            128000,
+		"gpt-4o-2024-08-06": 128000,
+		"gpt-4.1-mini":      1047576,
+		"gpt-4":             8192,
+		"o4-mini":           200000,
+		"local-llama":       DefaultContextWindow,
+	} {
+		if got := cfg.ContextWindowFor(model); got != want {
+			t.Errorf("Expected a context window of %d for %s, got %d", want, model, got)
+		}
+	}
+	cfg.ContextWindow = 32000
+	if got := cfg.ContextWindowFor("gpt-4o"); got != 32000 {
+		t.Errorf("Expected context_window to override the default, got %d", got)
+	}
+}
+
+func TestToolResultFormatFor(t *testing.T) {
+	cfg := &Config{}
+	if got := cfg.ToolResultFormatFor(DefaultBaseURL); got != ToolResultJSON {
+		t.Errorf("Expected JSON by default, got %q", got)
+	}
+	cfg.ToolResultFormats = []ProviderResultFormat{{BaseURL: "https://api.groq.com", Format: ToolResultText}}
+	if got := cfg.ToolResultFormatFor("https://api.groq.com/openai/v1"); got != ToolResultText {
+		t.Errorf("Expected the provider's format, got %q", got)
+	}
+	if got := cfg.ToolResultFormatFor(DefaultBaseURL); got != ToolResultJSON {
+		t.Errorf("Expected other providers to keep JSON, got %q", got)
+	}
+	cfg.ToolResultFormat = ToolResultText
+	if got := cfg.ToolResultFormatFor(DefaultBaseURL); got != ToolResultText {
+		t.Errorf("Expected tool_result_format to apply to other providers, got %q", got)
+	}
+}
+
+func TestMatchesEditIntent(t *testing.T) {
+	cfg := &Config{}
+	for message, want := range map[string]bool{
+		"Fix the failing test":         true,
+		"Please REFACTOR parser.go":    true,
+		"Which prefix is used?":        false,
+		"What does the fixture cover?": false,
+		"Explain the build":            false,
+	} {
+		if got := cfg.MatchesEditIntent(message); got != want {
+			t.Errorf("MatchesEditIntent(%q) = %v, want %v", message, got, want)
+		}
+	}
+	cfg.EditIntents = []string{"clean up", "bump"}
+	if !cfg.MatchesEditIntent("Clean  up the imports") || cfg.MatchesEditIntent("Fix it") {
+		t.Errorf("Expected edit_intents to replace the defaults and match phrases")
+	}
+}
+
+func TestLoadRejectsHalfModelRouting(t *testing.T) {
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	configDir := filepath.Join(tmpHome, DefaultConfigDir)
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "config.yaml"), []byte("explore_model: gpt-4o-mini\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "explore_model and edit_model") {
+		t.Errorf("Expected an error for explore_model without edit_model, got %v", err)
+	}
+}
+
+func TestRequestProfileFor(t *testing.T) {
+	cfg := &Config{RequestProfiles: []RequestProfile{{BaseURL: "https://gateway.example.com", ExplicitToolChoice: true}}}
+	tests := []struct {
+		name    string
+		baseURL string
+		model   string
+		want    RequestProfile
+	}{
+		{"openai", DefaultBaseURL, "gpt-4o", DefaultRequestProfiles()[2]},
+		{"anthropic", "https://api.anthropic.com/v1/", "claude-sonnet-4", DefaultRequestProfiles()[0]},
+		{"claude elsewhere", "https://llm.internal/v1", "claude-3-5-haiku", DefaultRequestProfiles()[1]},
+		{"configured gateway", "https://gateway.example.com/v1", "claude-3-5-haiku", cfg.RequestProfiles[0]},
+		{"unknown provider", "http://localhost:11434/v1", "llama3", RequestProfile{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.RequestProfileFor(tt.baseURL, tt.model); got != tt.want {
+				t.Errorf("RequestProfileFor(%q, %q) = %+v, want %+v", tt.baseURL, tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRequestProfiles(t *testing.T) {
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	configDir := filepath.Join(tmpHome, DefaultConfigDir)
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config directory: %v", err)
+	}
+	configPath := filepath.Join(configDir, "config.yaml")
+
+	profiles := "request_profiles:\n  - base_url: https://gateway.example.com\n    explicit_tool_choice: true\n    require_max_tokens: true\n"
+	if err := os.WriteFile(configPath, []byte(profiles), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	want := RequestProfile{BaseURL: "https://gateway.example.com", ExplicitToolChoice: true, RequireMaxTokens: true}
+	if len(cfg.RequestProfiles) != 1 || cfg.RequestProfiles[0] != want {
+		t.Errorf("Expected profile %+v, got %+v", want, cfg.RequestProfiles)
+	}
+
+	if err := os.WriteFile(configPath, []byte("request_profiles:\n  - strict_tools: true\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid request_profiles entry") {
+		t.Errorf("Expected a profile matching every request to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,331 @@
+package doctor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/sandbox"
+)
+
+// GitRunner runs git with args in dir and returns its trimmed output
+type GitRunner func(dir string, args ...string) (string, error)
+
+// DefaultGitRunner runs the git found in PATH
+func DefaultGitRunner(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), err
+}
+
+// CheckConfig loads the configuration and checks that an API key resolved.
+// The configuration is returned whenever it loaded, even without a key.
+func CheckConfig(load func() (*config.Config, error)) (*config.Config, Result) {
+	result := Result{Name: "config"}
+	cfg, err := load()
+	if err != nil {
+		result.Status = Fail
+		result.Detail = err.Error()
+		result.Hint = "Fix the configuration file (~/.codex/config.yaml) or the CODEX_* environment variable named in the error."
+		return nil, result
+	}
+	if cfg.APIKey == "" {
+		result.Status = Fail
+		result.Detail = "no API key is configured"
+		result.Hint = "Set OPENAI_API_KEY, or api_key in ~/.codex/config.yaml."
+		return cfg, result
+	}
+	result.Status = Pass
+	result.Detail = fmt.Sprintf("model %s, API key %s, base URL %s", cfg.Model, maskKey(cfg.APIKey), baseURL(cfg.BaseURL))
+	return cfg, result
+}
+
+// CheckReachability checks that the API base URL answers HTTP at all
+func CheckReachability(ctx context.Context, client *http.Client, base string) Result {
+	result := Result{Name: "api reachability"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL(base), nil)
+	if err != nil {
+		result.Status = Fail
+		result.Detail = fmt.Sprintf("invalid base URL: %v", err)
+		result.Hint = "Set base_url to the API root, e.g. " + config.DefaultBaseURL + "."
+		return result
+	}
+
+	started := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Status = Fail
+		result.Detail = err.Error()
+		result.Hint = "Check your network connection and base_url; if you need a proxy, set HTTPS_PROXY."
+		return result
+	}
+	resp.Body.Close()
+	result.Status = Pass
+	result.Detail = fmt.Sprintf("%s answered HTTP %d in %s", baseURL(base), resp.StatusCode, time.Since(started).Round(time.Millisecond))
+	return result
+}
+
+// CheckModels lists the models, which checks the API key, and looks for the configured model
+func CheckModels(ctx context.Context, client *http.Client, base, apiKey, model string) Result {
+	result := Result{Name: "api models"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL(base)+"/models", nil)
+	if err != nil {
+		result.Status = Fail
+		result.Detail = err.Error()
+		return result
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Status = Fail
+		result.Detail = err.Error()
+		result.Hint = "Check your network connection; if you need a proxy, set HTTPS_PROXY."
+		return result
+	}
+	defer resp.Body.Close()
+	if failed, ok := httpFailure(result, resp); ok {
+		return failed
+	}
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		result.Status = Warn
+		result.Detail = fmt.Sprintf("the model list could not be parsed: %v", err)
+		result.Hint = "base_url may point at a server that is not OpenAI-compatible."
+		return result
+	}
+	for _, m := range list.Data {
+		if m.ID == model {
+			result.Status = Pass
+			result.Detail = fmt.Sprintf("API key accepted; %s is available", model)
+			return result
+		}
+	}
+	result.Status = Warn
+	result.Detail = fmt.Sprintf("API key accepted, but %s is not among the %d models listed", model, len(list.Data))
+	result.Hint = "Check the model name (--model or model in the config); some proxies list only a subset of models."
+	return result
+}
+
+// CheckStreaming requests a one-token streamed completion and checks that it
+// arrives as server-sent events, which a buffering proxy can break
+func CheckStreaming(ctx context.Context, client *http.Client, base, apiKey, model string) Result {
+	result := Result{Name: "streaming"}
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":      model,
+		"messages":   []map[string]string{{"role": "user", "content": "Reply with OK."}},
+		"max_tokens": 1,
+		"stream":     true,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL(base)+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		result.Status = Fail
+		result.Detail = err.Error()
+		return result
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Status = Fail
+		result.Detail = err.Error()
+		result.Hint = "Check your network connection; if you need a proxy, set HTTPS_PROXY."
+		return result
+	}
+	defer resp.Body.Close()
+	if failed, ok := httpFailure(result, resp); ok {
+		return failed
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/event-stream") {
+		result.Status = Fail
+		result.Detail = fmt.Sprintf("expected an event stream, got Content-Type %q", contentType)
+		result.Hint = "A proxy between you and the API may be buffering or rewriting responses; configure it to pass text/event-stream through."
+		return result
+	}
+
+	events, done := 0, false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		if strings.TrimSpace(data) == "[DONE]" {
+			done = true
+			break
+		}
+		events++
+	}
+	if err := scanner.Err(); err != nil || events == 0 || !done {
+		result.Status = Fail
+		result.Detail = fmt.Sprintf("the stream ended early (%d events, finished: %t)", events, done)
+		if err != nil {
+			result.Detail += ": " + err.Error()
+		}
+		result.Hint = "A proxy or firewall may be cutting long-lived connections; allow streaming responses from the API."
+		return result
+	}
+	result.Status = Pass
+	result.Detail = fmt.Sprintf("received %d streamed events", events)
+	return result
+}
+
+// CheckGit checks that git is installed and whether dir is inside a repository
+func CheckGit(run GitRunner, dir string) Result {
+	result := Result{Name: "git"}
+	if run == nil {
+		run = DefaultGitRunner
+	}
+	version, err := run(dir, "--version")
+	if err != nil {
+		result.Status = Warn
+		result.Detail = "git is not available"
+		result.Hint = "Install git so changes can be reviewed and undone with it."
+		return result
+	}
+	root, err := run(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		result.Status = Warn
+		result.Detail = fmt.Sprintf("%s; %s is not inside a repository", version, displayDir(dir))
+		result.Hint = "Run codex inside a git repository (or git init one) so its edits can be reviewed and reverted."
+		return result
+	}
+	result.Status = Pass
+	result.Detail = fmt.Sprintf("%s; repository at %s", version, root)
+	return result
+}
+
+// CheckSandbox reports the sandbox commands run in on this platform
+func CheckSandbox(sb sandbox.Sandbox) Result {
+	result := Result{Name: "sandbox"}
+	if sb == nil {
+		sb = sandbox.NewSandbox()
+	}
+	if _, basic := sb.(*sandbox.BasicSandbox); basic || !sb.IsAvailable() {
+		result.Status = Warn
+		result.Detail = fmt.Sprintf("%s: commands run without isolation", sb.Name())
+		result.Hint = "Use suggest or auto-edit mode so every command is approved, or run codex in a container."
+		return result
+	}
+	result.Status = Pass
+	result.Detail = sb.Name()
+	return result
+}
+
+// CheckSessionDir checks that sessions can be written to dir
+func CheckSessionDir(dir string) Result {
+	result := Result{Name: "session directory"}
+	if dir == "" {
+		result.Status = Warn
+		result.Detail = "no session directory is configured; sessions are not saved"
+		result.Hint = "Set session_dir in ~/.codex/config.yaml to enable crash recovery."
+		return result
+	}
+	hint := fmt.Sprintf("Make %s writable, or set session_dir to a writable directory.", dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		result.Status = Fail
+		result.Detail = err.Error()
+		result.Hint = hint
+		return result
+	}
+	probe, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		result.Status = Fail
+		result.Detail = err.Error()
+		result.Hint = hint
+		return result
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	result.Status = Pass
+	result.Detail = dir + " is writable"
+	return result
+}
+
+// CheckTerminal checks what the interactive UI needs from the terminal
+func CheckTerminal(getenv func(string) string, isTerminal bool) Result {
+	result := Result{Name: "terminal"}
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	term := getenv("TERM")
+	if !isTerminal {
+		result.Status = Warn
+		result.Detail = "stdout is not a terminal"
+		result.Hint = "The interactive UI needs a terminal; use --quiet or codex run in scripts."
+		return result
+	}
+	if term == "" || term == "dumb" {
+		result.Status = Warn
+		result.Detail = fmt.Sprintf("TERM=%q does not support the alternate screen", term)
+		result.Hint = "Set TERM to your terminal's type, e.g. xterm-256color."
+		return result
+	}
+
+	colors := "256 colors or fewer"
+	if colorTerm := strings.ToLower(getenv("COLORTERM")); colorTerm == "truecolor" || colorTerm == "24bit" {
+		colors = "truecolor"
+	}
+	result.Status = Pass
+	result.Detail = fmt.Sprintf("TERM=%s, %s, alternate screen supported", term, colors)
+	return result
+}
+
+// httpFailure turns an error status into a failed result
+func httpFailure(result Result, resp *http.Response) (Result, bool) {
+	if resp.StatusCode < 300 {
+		return result, false
+	}
+	result.Status = Fail
+	result.Detail = fmt.Sprintf("HTTP %d", resp.StatusCode)
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		result.Hint = "The API key was rejected; check OPENAI_API_KEY or api_key."
+	case http.StatusNotFound:
+		result.Hint = "base_url may be missing a path such as /v1."
+	case http.StatusTooManyRequests:
+		result.Hint = "The account is rate limited or out of quota; check its billing and limits."
+	default:
+		result.Hint = "The API returned an error; try again later or check base_url."
+	}
+	return result, true
+}
+
+func baseURL(base string) string {
+	if base == "" {
+		base = config.DefaultBaseURL
+	}
+	return strings.TrimRight(base, "/")
+}
+
+func displayDir(dir string) string {
+	if dir == "" {
+		return "the current directory"
+	}
+	return dir
+}
+
+// maskKey shows only the end of an API key
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "set"
+	}
+	return "..." + key[len(key)-4:]
+}
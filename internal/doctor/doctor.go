@@ -0,0 +1,121 @@
+// Package doctor runs self-diagnostics for the configuration, the API
+// connection and the local tooling, each with a hint on how to fix a failure.
+package doctor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/sandbox"
+)
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	Pass Status = "pass"
+	Warn Status = "warn" // Works, but with reduced functionality
+	Fail Status = "fail"
+	Skip Status = "skip" // Not run because an earlier check failed
+)
+
+// Result is the outcome of one check
+type Result struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Detail string `json:"detail"`
+	Hint   string `json:"hint,omitempty"` // How to fix a warning or failure
+}
+
+// Options are the inputs of a full run
+type Options struct {
+	LoadConfig func() (*config.Config, error)
+	HTTPClient *http.Client
+	Sandbox    sandbox.Sandbox
+	Getenv     func(string) string
+	IsTerminal bool // Whether stdout is a terminal
+	RunGit     GitRunner
+}
+
+// DefaultTimeout bounds each network check
+const DefaultTimeout = 15 * time.Second
+
+// Run runs every check in order. Checks that depend on the configuration or
+// the API being reachable are skipped when those fail.
+func Run(ctx context.Context, opts Options) []Result {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: DefaultTimeout}
+	}
+
+	cfg, result := CheckConfig(opts.LoadConfig)
+	results := []Result{result}
+
+	if cfg == nil {
+		for _, name := range []string{"api reachability", "api models", "streaming"} {
+			results = append(results, skipped(name, "the configuration could not be loaded"))
+		}
+	} else {
+		reachable := CheckReachability(ctx, opts.HTTPClient, cfg.BaseURL)
+		results = append(results, reachable)
+		if reachable.Status == Fail || cfg.APIKey == "" {
+			reason := "the API is not reachable"
+			if reachable.Status != Fail {
+				reason = "no API key is configured"
+			}
+			results = append(results, skipped("api models", reason), skipped("streaming", reason))
+		} else {
+			results = append(results,
+				CheckModels(ctx, opts.HTTPClient, cfg.BaseURL, cfg.APIKey, cfg.Model),
+				CheckStreaming(ctx, opts.HTTPClient, cfg.BaseURL, cfg.APIKey, cfg.Model),
+			)
+		}
+	}
+
+	dir, sessionDir := "", ""
+	if cfg != nil {
+		dir, sessionDir = cfg.ToolDir(), cfg.SessionDir
+	}
+	results = append(results,
+		CheckGit(opts.RunGit, dir),
+		CheckSandbox(opts.Sandbox),
+		CheckSessionDir(sessionDir),
+		CheckTerminal(opts.Getenv, opts.IsTerminal),
+	)
+	return results
+}
+
+// Failed reports whether any check failed
+func Failed(results []Result) bool {
+	for _, result := range results {
+		if result.Status == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText prints results for a person, one check per line with hints indented below
+func WriteText(w io.Writer, results []Result) {
+	for _, result := range results {
+		fmt.Fprintf(w, "[%s] %s: %s\n", result.Status, result.Name, result.Detail)
+		if result.Hint != "" && result.Status != Pass {
+			fmt.Fprintf(w, "       %s\n", result.Hint)
+		}
+	}
+}
+
+// WriteJSON prints results as a JSON array
+func WriteJSON(w io.Writer, results []Result) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+func skipped(name, reason string) Result {
+	return Result{Name: name, Status: Skip, Detail: "skipped because " + reason}
+}
@@ -0,0 +1,243 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/sandbox"
+)
+
+// fakeAPI serves /models and a streamed /chat/completions
+func fakeAPI(t *testing.T, models []string, stream func(w http.ResponseWriter)) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk-test-key-1234" && r.URL.Path != "/" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/models":
+			var data []map[string]string
+			for _, id := range models {
+				data = append(data, map[string]string{"id": id})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		case "/chat/completions":
+			stream(w)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func sse(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"OK\"}}]}\n\ndata: [DONE]\n\n")
+}
+
+func TestCheckConfig(t *testing.T) {
+	_, result := CheckConfig(func() (*config.Config, error) { return nil, errors.New("bad yaml") })
+	if result.Status != Fail || !strings.Contains(result.Detail, "bad yaml") || result.Hint == "" {
+		t.Errorf("Expected a failure with a hint, got %+v", result)
+	}
+
+	cfg, result := CheckConfig(func() (*config.Config, error) { return &config.Config{Model: "gpt-4o"}, nil })
+	if cfg == nil || result.Status != Fail || !strings.Contains(result.Hint, "OPENAI_API_KEY") {
+		t.Errorf("Expected a missing key to fail but return the config, got %+v", result)
+	}
+
+	_, result = CheckConfig(func() (*config.Config, error) {
+		return &config.Config{Model: "gpt-4o", APIKey: "sk-test-key-1234"}, nil
+	})
+	if result.Status != Pass || strings.Contains(result.Detail, "sk-test") || !strings.Contains(result.Detail, "...1234") {
+		t.Errorf("Expected a pass with a masked key, got %+v", result)
+	}
+}
+
+func TestCheckReachability(t *testing.T) {
+	server := fakeAPI(t, nil, sse)
+	if result := CheckReachability(context.Background(), server.Client(), server.URL); result.Status != Pass {
+		t.Errorf("Expected any HTTP response to pass, got %+v", result)
+	}
+
+	server.Close()
+	result := CheckReachability(context.Background(), http.DefaultClient, server.URL)
+	if result.Status != Fail || !strings.Contains(result.Hint, "HTTPS_PROXY") {
+		t.Errorf("Expected a closed server to fail with a network hint, got %+v", result)
+	}
+}
+
+func TestCheckModels(t *testing.T) {
+	server := fakeAPI(t, []string{"gpt-4o", "o3"}, sse)
+	ctx := context.Background()
+
+	if result := CheckModels(ctx, server.Client(), server.URL, "sk-test-key-1234", "o3"); result.Status != Pass {
+		t.Errorf("Expected a listed model to pass, got %+v", result)
+	}
+	if result := CheckModels(ctx, server.Client(), server.URL, "sk-test-key-1234", "gpt-9"); result.Status != Warn {
+		t.Errorf("Expected an unlisted model to warn, got %+v", result)
+	}
+	result := CheckModels(ctx, server.Client(), server.URL, "sk-wrong", "o3")
+	if result.Status != Fail || !strings.Contains(result.Hint, "API key was rejected") {
+		t.Errorf("Expected a rejected key to fail, got %+v", result)
+	}
+}
+
+func TestCheckStreaming(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		stream func(w http.ResponseWriter)
+		want   Status
+	}{
+		{"event stream", sse, Pass},
+		{"buffered by a proxy", func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"choices":[]}`)
+		}, Fail},
+		{"cut off", func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[]}\n\n")
+		}, Fail},
+	}
+	for _, tt := range tests {
+		server := fakeAPI(t, nil, tt.stream)
+		if result := CheckStreaming(ctx, server.Client(), server.URL, "sk-test-key-1234", "gpt-4o"); result.Status != tt.want {
+			t.Errorf("%s: Expected %s, got %+v", tt.name, tt.want, result)
+		}
+	}
+}
+
+func TestCheckGit(t *testing.T) {
+	missing := func(dir string, args ...string) (string, error) { return "", errors.New("executable file not found") }
+	if result := CheckGit(missing, "/work"); result.Status != Warn || !strings.Contains(result.Detail, "not available") {
+		t.Errorf("Expected a missing git to warn, got %+v", result)
+	}
+
+	noRepo := func(dir string, args ...string) (string, error) {
+		if args[0] == "--version" {
+			return "git version 2.43.0", nil
+		}
+		return "", errors.New("not a git repository")
+	}
+	if result := CheckGit(noRepo, "/work"); result.Status != Warn || !strings.Contains(result.Detail, "/work is not inside a repository") {
+		t.Errorf("Expected a warning outside a repository, got %+v", result)
+	}
+
+	repo := func(dir string, args ...string) (string, error) {
+		if args[0] == "--version" {
+			return "git version 2.43.0", nil
+		}
+		return "/work", nil
+	}
+	if result := CheckGit(repo, "/work/sub"); result.Status != Pass || !strings.Contains(result.Detail, "repository at /work") {
+		t.Errorf("Expected a pass inside a repository, got %+v", result)
+	}
+}
+
+func TestCheckSandbox(t *testing.T) {
+	if result := CheckSandbox(&sandbox.BasicSandbox{}); result.Status != Warn {
+		t.Errorf("Expected the basic sandbox to warn, got %+v", result)
+	}
+}
+
+func TestCheckSessionDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	if result := CheckSessionDir(dir); result.Status != Pass {
+		t.Errorf("Expected a creatable directory to pass, got %+v", result)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected the probe file to be removed, got %d entries", len(entries))
+	}
+
+	blocker := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(blocker, nil, 0644)
+	if result := CheckSessionDir(filepath.Join(blocker, "sessions")); result.Status != Fail || result.Hint == "" {
+		t.Errorf("Expected a directory below a file to fail, got %+v", result)
+	}
+	if result := CheckSessionDir(""); result.Status != Warn {
+		t.Errorf("Expected no directory to warn, got %+v", result)
+	}
+}
+
+func TestCheckTerminal(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+
+	result := CheckTerminal(env(map[string]string{"TERM": "xterm-256color", "COLORTERM": "truecolor"}), true)
+	if result.Status != Pass || !strings.Contains(result.Detail, "truecolor") {
+		t.Errorf("Expected truecolor support, got %+v", result)
+	}
+	if result := CheckTerminal(env(map[string]string{"TERM": "xterm"}), true); !strings.Contains(result.Detail, "256 colors or fewer") {
+		t.Errorf("Expected no truecolor without COLORTERM, got %+v", result)
+	}
+	if result := CheckTerminal(env(map[string]string{"TERM": "dumb"}), true); result.Status != Warn {
+		t.Errorf("Expected a dumb terminal to warn, got %+v", result)
+	}
+	if result := CheckTerminal(env(map[string]string{"TERM": "xterm"}), false); result.Status != Warn {
+		t.Errorf("Expected a non-terminal stdout to warn, got %+v", result)
+	}
+}
+
+func TestRunSkipsAPIChecksWithoutConfig(t *testing.T) {
+	results := Run(context.Background(), Options{
+		LoadConfig: func() (*config.Config, error) { return nil, errors.New("bad yaml") },
+		Sandbox:    &sandbox.BasicSandbox{},
+		Getenv:     func(string) string { return "" },
+		RunGit:     func(dir string, args ...string) (string, error) { return "", errors.New("no git") },
+	})
+	if len(results) != 8 {
+		t.Fatalf("Expected 8 results, got %d", len(results))
+	}
+	for _, result := range results[1:4] {
+		if result.Status != Skip {
+			t.Errorf("Expected %s to be skipped, got %+v", result.Name, result)
+		}
+	}
+	if !Failed(results) {
+		t.Errorf("Expected the run to fail")
+	}
+
+	var text bytes.Buffer
+	WriteText(&text, results)
+	if !strings.Contains(text.String(), "[fail] config: bad yaml\n") {
+		t.Errorf("Expected a text line per check, got:\n%s", text.String())
+	}
+	var decoded []Result
+	var out bytes.Buffer
+	if err := WriteJSON(&out, results); err != nil || json.Unmarshal(out.Bytes(), &decoded) != nil || len(decoded) != 8 {
+		t.Errorf("Expected the results to round-trip through JSON, got %s", out.String())
+	}
+}
+
+func TestRun(t *testing.T) {
+	server := fakeAPI(t, []string{"gpt-4o"}, sse)
+	results := Run(context.Background(), Options{
+		LoadConfig: func() (*config.Config, error) {
+			return &config.Config{Model: "gpt-4o", APIKey: "sk-test-key-1234", BaseURL: server.URL, SessionDir: t.TempDir()}, nil
+		},
+		HTTPClient: server.Client(),
+		Sandbox:    &sandbox.BasicSandbox{},
+		Getenv:     func(string) string { return "xterm" },
+		IsTerminal: true,
+		RunGit:     func(dir string, args ...string) (string, error) { return "git version 2.43.0", nil },
+	})
+	if Failed(results) {
+		var text bytes.Buffer
+		WriteText(&text, results)
+		t.Errorf("Expected no failures, got:\n%s", text.String())
+	}
+}
@@ -0,0 +1,198 @@
+// Package editorconfig resolves the .editorconfig properties of a file and
+// normalizes content written by the model to them
+package editorconfig
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// FileName is the name of EditorConfig files
+const FileName = ".editorconfig"
+
+// Properties are the EditorConfig properties that apply to a file, by
+// lowercase name. Properties set to "unset" are left out.
+type Properties map[string]string
+
+// section is a glob and the properties it sets
+type section struct {
+	pattern    *regexp.Regexp
+	properties map[string]string
+}
+
+// file is a parsed .editorconfig file
+type file struct {
+	root     bool
+	sections []section
+}
+
+// Resolve returns the properties that apply to path: the .editorconfig files
+// from the nearest one with root = true down to path's directory are read in
+// order, so closer files and later sections win. A file no section matches
+// gets no properties.
+func Resolve(path string) (Properties, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve path: %w", err)
+	}
+
+	// Collect the files from the nearest directory up to the root
+	var dirs []string
+	var files []*file
+	for dir := filepath.Dir(absPath); ; dir = filepath.Dir(dir) {
+		f, err := parseFile(filepath.Join(dir, FileName))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if f != nil {
+			dirs = append(dirs, dir)
+			files = append(files, f)
+			if f.root {
+				break
+			}
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+
+	props := Properties{}
+	for i := len(files) - 1; i >= 0; i-- {
+		rel, err := filepath.Rel(dirs[i], absPath)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, s := range files[i].sections {
+			if !s.pattern.MatchString(rel) {
+				continue
+			}
+			for key, value := range s.properties {
+				if value == "unset" {
+					delete(props, key)
+				} else {
+					props[key] = value
+				}
+			}
+		}
+	}
+	return props, nil
+}
+
+// parseFile reads an .editorconfig file. Sections with globs that cannot be
+// compiled are skipped.
+func parseFile(path string) (*file, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	f := &file{}
+	var current *section
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && strings.HasSuffix(line, "]") {
+			current = nil
+			if pattern, err := compileGlob(line[1 : len(line)-1]); err == nil {
+				f.sections = append(f.sections, section{pattern: pattern, properties: map[string]string{}})
+				current = &f.sections[len(f.sections)-1]
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.ToLower(strings.TrimSpace(value))
+		switch {
+		case current != nil:
+			current.properties[key] = value
+		case key == "root":
+			// Only the preamble, before any section, can mark the root
+			f.root = value == "true"
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// compileGlob turns a section name into a regexp matching slash paths
+// relative to the .editorconfig directory. A glob without a slash matches
+// files of that name in any directory. Supported: *, **, ?, [set], [!set] and
+// {a,b}.
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	switch {
+	case strings.HasPrefix(glob, "/"):
+		glob = glob[1:]
+	case !strings.Contains(glob, "/"):
+		b.WriteString("(?:.*/)?")
+	}
+
+	braces := 0
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			set := glob[i+1 : i+end]
+			if strings.HasPrefix(set, "!") {
+				set = "^" + set[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(set, `\`, `\\`) + "]")
+			i += end
+		case '{':
+			braces++
+			b.WriteString("(?:")
+		case '}':
+			if braces == 0 {
+				b.WriteString(`\}`)
+				continue
+			}
+			braces--
+			b.WriteString(")")
+		case ',':
+			if braces == 0 {
+				b.WriteString(",")
+				continue
+			}
+			b.WriteString("|")
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if braces != 0 {
+		return nil, fmt.Errorf("unbalanced braces in %q", glob)
+	}
+	return regexp.Compile("^" + b.String() + "$")
+}
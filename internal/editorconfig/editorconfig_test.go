@@ -0,0 +1,142 @@
+package editorconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFile writes content to dir/name, creating directories as needed
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".editorconfig", `root = true
+
+[*]
+indent_style = space
+indent_size = 4
+insert_final_newline = true
+
+[*.{go,mod}]
+indent_style = tab
+
+[Makefile]
+indent_style = tab
+
+[docs/**.md]
+trim_trailing_whitespace = false
+`)
+	writeFile(t, dir, "web/.editorconfig", "[*.js]\nindent_size = 2\n\n[vendor/**]\ninsert_final_newline = unset\n")
+
+	tests := []struct {
+		path string
+		want Properties
+	}{
+		{"main.go", Properties{"indent_style": "tab", "indent_size": "4", "insert_final_newline": "true"}},
+		{"sub/Makefile", Properties{"indent_style": "tab", "indent_size": "4", "insert_final_newline": "true"}},
+		{"docs/guide/intro.md", Properties{"indent_style": "space", "indent_size": "4", "insert_final_newline": "true", "trim_trailing_whitespace": "false"}},
+		{"web/app.js", Properties{"indent_style": "space", "indent_size": "2", "insert_final_newline": "true"}},
+		{"web/vendor/lib.js", Properties{"indent_style": "space", "indent_size": "2"}},
+	}
+	for _, tt := range tests {
+		got, err := Resolve(filepath.Join(dir, filepath.FromSlash(tt.path)))
+		if err != nil {
+			t.Fatalf("Resolve(%s) failed: %v", tt.path, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("Resolve(%s): expected %v, got %v", tt.path, tt.want, got)
+			continue
+		}
+		for key, value := range tt.want {
+			if got[key] != value {
+				t.Errorf("Resolve(%s): expected %s = %s, got %v", tt.path, key, value, got)
+			}
+		}
+	}
+}
+
+func TestResolveWithoutMatchingSection(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".editorconfig", "root = true\n\n[*.py]\nindent_style = space\n")
+
+	props, err := Resolve(filepath.Join(dir, "notes.txt"))
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if len(props) != 0 {
+		t.Errorf("Expected no properties, got %v", props)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	props := Properties{
+		"indent_style":             "space",
+		"indent_size":              "4",
+		"trim_trailing_whitespace": "true",
+		"insert_final_newline":     "true",
+		"end_of_line":              "lf",
+	}
+	got, notes := Normalize([]byte("def f():  \r\n\treturn 1\t\r\n\n  # aligned"), props)
+
+	if want := "def f():\n    return 1\n\n  # aligned\n"; string(got) != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	want := []string{
+		"trimmed trailing whitespace on 2 lines",
+		"converted indentation to spaces on 1 line",
+		"converted 2 lines to LF line endings",
+		"added a final newline",
+	}
+	if strings.Join(notes, "; ") != strings.Join(want, "; ") {
+		t.Errorf("Expected notes %q, got %q", want, notes)
+	}
+}
+
+func TestNormalizeToTabs(t *testing.T) {
+	props := Properties{"indent_style": "tab", "indent_size": "4", "insert_final_newline": "false"}
+	got, notes := Normalize([]byte("func f() {\n        x :=  1\n      y\n}\n"), props)
+
+	if want := "func f() {\n\t\tx :=  1\n\t  y\n}"; string(got) != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if len(notes) != 2 || notes[1] != "removed the final newline" {
+		t.Errorf("Expected the reindent and final newline notes, got %q", notes)
+	}
+}
+
+func TestNormalizeLeavesBinaryAndCleanContent(t *testing.T) {
+	props := Properties{"trim_trailing_whitespace": "true", "insert_final_newline": "true"}
+	binary := []byte("PNG\x00data  ")
+	if got, notes := Normalize(binary, props); string(got) != string(binary) || notes != nil {
+		t.Errorf("Expected binary content untouched, got %q, %q", got, notes)
+	}
+	clean := []byte("already fine\n")
+	if got, notes := Normalize(clean, props); string(got) != string(clean) || notes != nil {
+		t.Errorf("Expected clean content untouched, got %q, %q", got, notes)
+	}
+}
+
+func TestNormalizeFor(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".editorconfig", "root = true\n\n[*.txt]\ninsert_final_newline = true\n")
+
+	got, notes, err := NormalizeFor(filepath.Join(dir, "a.txt"), []byte("hello"))
+	if err != nil || len(notes) != 1 || string(got) != "hello\n" {
+		t.Fatalf("Expected the final newline added, got %q, %q, %v", got, notes, err)
+	}
+	if got, notes, err := NormalizeFor(filepath.Join(dir, "b.md"), []byte("hello")); err != nil || notes != nil || string(got) != "hello" {
+		t.Errorf("Expected content without properties untouched, got %q, %q, %v", got, notes, err)
+	}
+}
@@ -0,0 +1,184 @@
+package editorconfig
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// line is a line of text and the line ending that followed it, if any
+type line struct {
+	text string
+	eol  string
+}
+
+// endOfLine maps end_of_line values to line endings
+var endOfLine = map[string]string{"lf": "\n", "crlf": "\r\n", "cr": "\r"}
+
+// Normalize applies the indent_style, trim_trailing_whitespace,
+// insert_final_newline and end_of_line properties to content. It returns the
+// normalized content and a description of each change made. Binary content
+// is returned as it is.
+func Normalize(content []byte, props Properties) ([]byte, []string) {
+	if len(props) == 0 || len(content) == 0 || bytes.IndexByte(content, 0) >= 0 {
+		return content, nil
+	}
+
+	lines := splitLines(string(content))
+	var notes []string
+
+	if props["trim_trailing_whitespace"] == "true" {
+		trimmed := 0
+		for i := range lines {
+			if t := strings.TrimRight(lines[i].text, " \t"); t != lines[i].text {
+				lines[i].text = t
+				trimmed++
+			}
+		}
+		if trimmed > 0 {
+			notes = append(notes, fmt.Sprintf("trimmed trailing whitespace on %s", countLines(trimmed)))
+		}
+	}
+
+	if width := tabWidth(props); width > 0 {
+		style := props["indent_style"]
+		reindented := 0
+		for i := range lines {
+			if t := reindent(lines[i].text, style, width); t != lines[i].text {
+				lines[i].text = t
+				reindented++
+			}
+		}
+		if reindented > 0 {
+			notes = append(notes, fmt.Sprintf("converted indentation to %ss on %s", style, countLines(reindented)))
+		}
+	}
+
+	if eol, ok := endOfLine[props["end_of_line"]]; ok {
+		converted := 0
+		for i := range lines {
+			if lines[i].eol != "" && lines[i].eol != eol {
+				lines[i].eol = eol
+				converted++
+			}
+		}
+		if converted > 0 {
+			notes = append(notes, fmt.Sprintf("converted %s to %s line endings", countLines(converted), strings.ToUpper(props["end_of_line"])))
+		}
+	}
+
+	last := &lines[len(lines)-1]
+	switch props["insert_final_newline"] {
+	case "true":
+		if last.eol == "" {
+			last.eol = finalEOL(lines, props)
+			notes = append(notes, "added a final newline")
+		}
+	case "false":
+		if last.eol != "" {
+			last.eol = ""
+			notes = append(notes, "removed the final newline")
+		}
+	}
+
+	if len(notes) == 0 {
+		return content, nil
+	}
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString(l.text)
+		b.WriteString(l.eol)
+	}
+	return []byte(b.String()), notes
+}
+
+// NormalizeFor normalizes content about to be written to path to the
+// path's .editorconfig properties and returns it with the changes made, if any
+func NormalizeFor(path string, content []byte) ([]byte, []string, error) {
+	props, err := Resolve(path)
+	if err != nil || len(props) == 0 {
+		return content, nil, err
+	}
+	normalized, notes := Normalize(content, props)
+	return normalized, notes, nil
+}
+
+// splitLines splits text into lines, keeping each line's ending (\n, \r\n or \r)
+func splitLines(text string) []line {
+	var lines []line
+	for text != "" {
+		i := strings.IndexAny(text, "\r\n")
+		if i < 0 {
+			lines = append(lines, line{text: text})
+			break
+		}
+		eol := text[i : i+1]
+		if text[i] == '\r' && i+1 < len(text) && text[i+1] == '\n' {
+			eol = "\r\n"
+		}
+		lines = append(lines, line{text: text[:i], eol: eol})
+		text = text[i+len(eol):]
+	}
+	return lines
+}
+
+// tabWidth returns the width of an indentation level when indent_style asks
+// for a conversion and the width is known, or 0
+func tabWidth(props Properties) int {
+	style := props["indent_style"]
+	if style != "space" && style != "tab" {
+		return 0
+	}
+	for _, key := range []string{"tab_width", "indent_size"} {
+		if n, err := strconv.Atoi(props[key]); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// reindent rewrites the leading whitespace of text in style. Spaces short of
+// a full indentation level are kept, as they usually align rather than indent.
+func reindent(text, style string, width int) string {
+	indent := len(text) - len(strings.TrimLeft(text, " \t"))
+	if indent == 0 {
+		return text
+	}
+	column := 0
+	for _, c := range text[:indent] {
+		if c == '\t' {
+			column += width - column%width
+		} else {
+			column++
+		}
+	}
+	var lead string
+	if style == "tab" {
+		lead = strings.Repeat("\t", column/width) + strings.Repeat(" ", column%width)
+	} else {
+		lead = strings.Repeat(" ", column)
+	}
+	return lead + text[indent:]
+}
+
+// finalEOL returns the line ending to add at the end of a file
+func finalEOL(lines []line, props Properties) string {
+	if eol, ok := endOfLine[props["end_of_line"]]; ok {
+		return eol
+	}
+	for _, l := range lines {
+		if l.eol != "" {
+			return l.eol
+		}
+	}
+	return "\n"
+}
+
+// countLines formats a number of lines
+func countLines(n int) string {
+	if n == 1 {
+		return "1 line"
+	}
+	return fmt.Sprintf("%d lines", n)
+}
@@ -0,0 +1,78 @@
+package fileops
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// FileStat is the size of the change to one file over a turn. Binary files
+// are measured in bytes instead of lines.
+type FileStat struct {
+	Path        string `json:"path"`
+	Status      string `json:"status"` // "created", "modified" or "deleted"
+	Added       int    `json:"added"`
+	Removed     int    `json:"removed"`
+	Binary      bool   `json:"binary,omitempty"`
+	BytesBefore int    `json:"bytes_before,omitempty"` // Binary files only
+	BytesAfter  int    `json:"bytes_after,omitempty"`  // Binary files only
+}
+
+// DiffStat summarizes the files changed over a turn
+type DiffStat struct {
+	Files   []FileStat `json:"files"`
+	Added   int        `json:"added"`   // Lines added to text files
+	Removed int        `json:"removed"` // Lines removed from text files
+}
+
+// String returns a one-line summary like "3 files changed, +120 -43", with
+// the byte delta of binary files appended
+func (s DiffStat) String() string {
+	noun := "files"
+	if len(s.Files) == 1 {
+		noun = "file"
+	}
+	summary := fmt.Sprintf("%d %s changed, +%d -%d", len(s.Files), noun, s.Added, s.Removed)
+
+	binary, delta := 0, 0
+	for _, f := range s.Files {
+		if f.Binary {
+			binary++
+			delta += f.BytesAfter - f.BytesBefore
+		}
+	}
+	if binary > 0 {
+		summary += fmt.Sprintf(" (%d binary, %+d bytes)", binary, delta)
+	}
+	return summary
+}
+
+// DiffStat returns the lines added and removed in every journaled file that
+// differs from its pre-turn state, sorted by path
+func (j *Journal) DiffStat() DiffStat {
+	var stat DiffStat
+	for _, c := range j.changed() {
+		f := FileStat{Path: c.entry.Path, Status: c.status}
+		if isBinary(c.original) || isBinary(c.current) {
+			f.Binary = true
+			f.BytesBefore, f.BytesAfter = len(c.original), len(c.current)
+		} else {
+			for _, op := range diffLines(splitDiffLines(string(c.original)), splitDiffLines(string(c.current))) {
+				switch op.kind {
+				case '+':
+					f.Added++
+				case '-':
+					f.Removed++
+				}
+			}
+			stat.Added += f.Added
+			stat.Removed += f.Removed
+		}
+		stat.Files = append(stat.Files, f)
+	}
+	return stat
+}
+
+// isBinary reports whether content looks binary: a NUL byte in its first 8000 bytes
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0
+}
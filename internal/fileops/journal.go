@@ -0,0 +1,236 @@
+package fileops
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/epuerta/codex-go/internal/blobstore"
+)
+
+// JournalEntry is the pre-turn state of a file modified during a turn
+type JournalEntry struct {
+	Path     string      // Absolute path
+	Existed  bool        // Whether the file existed before the turn
+	Original []byte      // Content before the turn (nil if it did not exist or is in the blob store)
+	Blob     string      // Hash of the content in the blob store, when the journal has one
+	Owner    string      // Owner the blob is referenced for
+	Mode     os.FileMode // Mode before the turn
+}
+
+// FileChange is a consolidated change to one file over a turn
+type FileChange struct {
+	Path   string `json:"path"`
+	Status string `json:"status"` // "created", "modified" or "deleted"
+	Diff   string `json:"diff"`   // Unified diff from the pre-turn content
+}
+
+// Journal records the original content of files before they are first
+// modified in a turn, so the turn's changes can be reviewed and reverted.
+type Journal struct {
+	mu      sync.Mutex
+	entries map[string]*JournalEntry
+	blobs   *blobstore.Store // Where snapshots are kept instead of memory; nil keeps them in memory
+	owner   func() string    // Returns the owner snapshots are stored for
+}
+
+// NewJournal creates an empty journal
+func NewJournal() *Journal {
+	return &Journal{
+		entries: make(map[string]*JournalEntry),
+	}
+}
+
+// SetBlobStore keeps later snapshots in store instead of in memory. Each
+// snapshot is referenced for the owner (the session ID) current when it is
+// taken, so snapshots follow a session switch or resume.
+func (j *Journal) SetBlobStore(store *blobstore.Store, owner func() string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.blobs = store
+	j.owner = owner
+}
+
+// Record snapshots a file before it is modified. Only the first call per path
+// in a turn is recorded; later calls keep the original snapshot.
+func (j *Journal) Record(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve absolute path: %w", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, exists := j.entries[absPath]; exists {
+		return nil
+	}
+
+	entry := &JournalEntry{Path: absPath, Mode: 0644}
+	info, err := os.Stat(absPath)
+	switch {
+	case err == nil:
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory", path)
+		}
+		data, err := os.ReadFile(absPath)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot %s: %w", path, err)
+		}
+		entry.Existed = true
+		entry.Original = data
+		entry.Mode = info.Mode()
+		if j.blobs != nil {
+			owner := j.owner()
+			hash, err := j.blobs.Put(owner, data)
+			if err != nil {
+				return fmt.Errorf("failed to snapshot %s: %w", path, err)
+			}
+			entry.Blob = hash
+			entry.Owner = owner
+			entry.Original = nil
+		}
+	case !os.IsNotExist(err):
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	j.entries[absPath] = entry
+	return nil
+}
+
+// Len returns the number of journaled files
+func (j *Journal) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.entries)
+}
+
+// Changes returns the consolidated diff of every journaled file that actually
+// differs from its pre-turn state, sorted by path
+func (j *Journal) Changes() []FileChange {
+	var changes []FileChange
+	for _, c := range j.changed() {
+		changes = append(changes, FileChange{
+			Path:   c.entry.Path,
+			Status: c.status,
+			Diff:   UnifiedDiff(c.entry.Path, string(c.original), string(c.current)),
+		})
+	}
+	return changes
+}
+
+// changedEntry is a journaled file that differs from its pre-turn state
+type changedEntry struct {
+	entry    *JournalEntry
+	status   string
+	original []byte // Content before the turn (nil if it did not exist)
+	current  []byte // Content now (nil if deleted)
+}
+
+// changed returns the journaled files that differ from their pre-turn state,
+// sorted by path
+func (j *Journal) changed() []changedEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var changes []changedEntry
+	for _, entry := range j.entries {
+		current, err := os.ReadFile(entry.Path)
+		exists := err == nil
+		original, err := j.original(entry)
+		if err != nil {
+			continue // Without its snapshot the change cannot be shown
+		}
+
+		var status string
+		switch {
+		case !entry.Existed && !exists:
+			continue
+		case !entry.Existed:
+			status = "created"
+		case !exists:
+			status = "deleted"
+		case string(current) == string(original):
+			continue
+		default:
+			status = "modified"
+		}
+
+		changes = append(changes, changedEntry{entry: entry, status: status, original: original, current: current})
+	}
+
+	sort.Slice(changes, func(a, b int) bool { return changes[a].entry.Path < changes[b].entry.Path })
+	return changes
+}
+
+// Revert restores a journaled file to its pre-turn state
+func (j *Journal) Revert(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve absolute path: %w", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry, exists := j.entries[absPath]
+	if !exists {
+		return fmt.Errorf("%s is not in the journal", path)
+	}
+
+	if !entry.Existed {
+		if err := os.Remove(absPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove created file %s: %w", path, err)
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+			return fmt.Errorf("failed to recreate directory for %s: %w", path, err)
+		}
+		original, err := j.original(entry)
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+		if err := os.WriteFile(absPath, original, entry.Mode.Perm()); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+	}
+
+	j.release(entry)
+	delete(j.entries, absPath)
+	return nil
+}
+
+// Reset forgets all entries, finalizing the turn's changes
+func (j *Journal) Reset() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, entry := range j.entries {
+		j.release(entry)
+	}
+	j.entries = make(map[string]*JournalEntry)
+}
+
+// original returns the pre-turn content of an entry. The caller must hold j.mu.
+func (j *Journal) original(entry *JournalEntry) ([]byte, error) {
+	if entry.Blob == "" {
+		return entry.Original, nil
+	}
+	return j.blobs.Get(entry.Blob)
+}
+
+// release drops the journal's reference to an entry's snapshot. Another turn
+// or session may still reference the same content, so the blob is left for
+// garbage collection. The caller must hold j.mu.
+func (j *Journal) release(entry *JournalEntry) {
+	if entry.Blob == "" {
+		return
+	}
+	for _, other := range j.entries {
+		if other != entry && other.Blob == entry.Blob && other.Owner == entry.Owner {
+			return // Files with the same content share the blob
+		}
+	}
+	j.blobs.Release(entry.Owner, entry.Blob)
+}
@@ -0,0 +1,216 @@
+package fileops
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/blobstore"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	oldText := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	newText := "a\nb\nC\nd\ne\nf\ng\nh\ni\nj\nk\n"
+
+	diff := UnifiedDiff("file.txt", oldText, newText)
+	expected := `--- a/file.txt
++++ b/file.txt
+@@ -1,6 +1,6 @@
+ a
+ b
+-c
++C
+ d
+ e
+ f
+@@ -8,3 +8,4 @@
+ h
+ i
+ j
++k
+`
+	if diff != expected {
+		t.Errorf("Unexpected diff.\nExpected:\n%s\nGot:\n%s", expected, diff)
+	}
+
+	if UnifiedDiff("file.txt", oldText, oldText) != "" {
+		t.Errorf("Expected empty diff for identical texts")
+	}
+}
+
+func TestJournalChangesAndRevert(t *testing.T) {
+	dir := t.TempDir()
+	modified := filepath.Join(dir, "modified.txt")
+	created := filepath.Join(dir, "created.txt")
+	untouched := filepath.Join(dir, "untouched.txt")
+	for _, path := range []string{modified, untouched} {
+		if err := os.WriteFile(path, []byte("original\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	j := NewJournal()
+	for _, path := range []string{modified, created, untouched} {
+		if err := j.Record(path); err != nil {
+			t.Fatalf("Record(%s) failed: %v", path, err)
+		}
+	}
+	os.WriteFile(modified, []byte("changed\n"), 0644)
+	os.WriteFile(created, []byte("new\n"), 0644)
+
+	// A second record must keep the original snapshot
+	if err := j.Record(modified); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	changes := j.Changes()
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes (untouched file excluded), got %d: %+v", len(changes), changes)
+	}
+	if changes[0].Path != created || changes[0].Status != "created" {
+		t.Errorf("Expected created.txt to be reported as created, got %+v", changes[0])
+	}
+	if changes[1].Status != "modified" || !strings.Contains(changes[1].Diff, "-original") || !strings.Contains(changes[1].Diff, "+changed") {
+		t.Errorf("Expected modified.txt diff, got %+v", changes[1])
+	}
+
+	if err := j.Revert(modified); err != nil {
+		t.Fatalf("Revert modified failed: %v", err)
+	}
+	if err := j.Revert(created); err != nil {
+		t.Fatalf("Revert created failed: %v", err)
+	}
+	if data, _ := os.ReadFile(modified); string(data) != "original\n" {
+		t.Errorf("Expected modified.txt restored, got %q", string(data))
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("Expected created.txt removed, got err=%v", err)
+	}
+}
+
+func TestJournalDiffStat(t *testing.T) {
+	dir := t.TempDir()
+	text := filepath.Join(dir, "a.txt")
+	image := filepath.Join(dir, "b.bin")
+	created := filepath.Join(dir, "c.txt")
+	os.WriteFile(text, []byte("one\ntwo\nthree\n"), 0644)
+	os.WriteFile(image, []byte{0x89, 0, 1, 2}, 0644)
+
+	j := NewJournal()
+	for _, path := range []string{text, image, created} {
+		if err := j.Record(path); err != nil {
+			t.Fatalf("Record(%s) failed: %v", path, err)
+		}
+	}
+	if stat := j.DiffStat(); len(stat.Files) != 0 {
+		t.Errorf("Expected no files before any change, got %+v", stat)
+	}
+
+	os.WriteFile(text, []byte("one\nTWO\nthree\nfour\n"), 0644)
+	os.WriteFile(image, []byte{0x89, 0, 1, 2, 3, 4, 5, 6, 7, 8}, 0644)
+	os.WriteFile(created, []byte("new\n"), 0644)
+
+	stat := j.DiffStat()
+	if len(stat.Files) != 3 {
+		t.Fatalf("Expected 3 files, got %+v", stat)
+	}
+	if f := stat.Files[0]; f.Path != text || f.Added != 2 || f.Removed != 1 || f.Binary {
+		t.Errorf("Expected a.txt +2 -1, got %+v", f)
+	}
+	if f := stat.Files[1]; !f.Binary || f.BytesBefore != 4 || f.BytesAfter != 10 {
+		t.Errorf("Expected b.bin as binary 4 -> 10 bytes, got %+v", f)
+	}
+	if f := stat.Files[2]; f.Status != "created" || f.Added != 1 {
+		t.Errorf("Expected c.txt created with 1 line, got %+v", f)
+	}
+	if stat.Added != 3 || stat.Removed != 1 {
+		t.Errorf("Expected +3 -1 in total, got +%d -%d", stat.Added, stat.Removed)
+	}
+	if got, want := stat.String(), "3 files changed, +3 -1 (1 binary, +6 bytes)"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got, want := (DiffStat{Files: []FileStat{{Added: 1}}, Added: 1}).String(), "1 file changed, +1 -0"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestJournalKeepsSnapshotsInBlobStore(t *testing.T) {
+	dir := t.TempDir()
+	store := blobstore.Open(filepath.Join(dir, "blobs"), true)
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+	for _, path := range []string{first, second} {
+		os.WriteFile(path, []byte("original\n"), 0644)
+	}
+
+	j := NewJournal()
+	j.SetBlobStore(store, func() string { return "session-1" })
+	for _, path := range []string{first, second} {
+		if err := j.Record(path); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	entry := j.entries[first]
+	if entry.Original != nil || entry.Blob != blobstore.Hash([]byte("original\n")) {
+		t.Fatalf("Expected the snapshot in the blob store, got %+v", entry)
+	}
+
+	os.WriteFile(first, []byte("changed\n"), 0644)
+	os.WriteFile(second, []byte("changed\n"), 0644)
+	changes := j.Changes()
+	if len(changes) != 2 || !strings.Contains(changes[0].Diff, "-original") {
+		t.Fatalf("Expected diffs against the stored snapshot, got %+v", changes)
+	}
+
+	// Both files share the blob: reverting one keeps the reference
+	if err := j.Revert(first); err != nil {
+		t.Fatalf("Revert failed: %v", err)
+	}
+	if data, _ := os.ReadFile(first); string(data) != "original\n" {
+		t.Errorf("Expected first.txt restored, got %q", data)
+	}
+	entries, _ := store.Entries()
+	if !entries[entry.Blob].Owners["session-1"] {
+		t.Errorf("Expected the shared snapshot to stay referenced")
+	}
+
+	j.Reset()
+	entries, _ = store.Entries()
+	if entries[entry.Blob].Refs() != 0 {
+		t.Errorf("Expected the snapshot released after the turn, got %+v", entries[entry.Blob])
+	}
+}
+
+func TestJournalSnapshotsFollowTheSession(t *testing.T) {
+	dir := t.TempDir()
+	store := blobstore.Open(filepath.Join(dir, "blobs"), false)
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+	for _, path := range []string{first, second} {
+		os.WriteFile(path, []byte("original\n"), 0644)
+	}
+
+	session := "session-1"
+	j := NewJournal()
+	j.SetBlobStore(store, func() string { return session })
+	if err := j.Record(first); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	session = "session-2"
+	if err := j.Record(second); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	hash := blobstore.Hash([]byte("original\n"))
+	entries, _ := store.Entries()
+	if owners := entries[hash].Owners; !owners["session-1"] || !owners["session-2"] {
+		t.Fatalf("Expected the snapshot referenced by both sessions, got %v", owners)
+	}
+
+	j.Reset()
+	entries, _ = store.Entries()
+	if entries[hash].Refs() != 0 {
+		t.Errorf("Expected each session's reference released, got %v", entries[hash].Owners)
+	}
+}
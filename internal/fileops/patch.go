@@ -15,9 +15,30 @@ type AgentPatchOperation struct {
 	Type    string // "add" or "remove"
 	Path    string // Path to the file
 	Content string // Content to add or remove (without ADD:/DEL: prefix)
+	Hunk    int    // 1-based index of the // EDIT: block the operation came from
 	// Note: Line numbers are not directly available in this format
 }
 
+// PatchProgress reports how one hunk (// EDIT: block) of an agent patch was
+// applied, as soon as it is known
+type PatchProgress struct {
+	Hunk    int // 1-based index of the hunk in the patch
+	Hunks   int // Hunks in the patch
+	Path    string
+	Applied bool
+	Added   int    // Lines added by the hunk
+	Removed int    // Lines removed by the hunk
+	Error   string // Why the hunk was not applied
+}
+
+// String describes the hunk's outcome in one line
+func (p PatchProgress) String() string {
+	if p.Applied {
+		return fmt.Sprintf("Hunk %d/%d applied to %s (+%d/-%d lines)", p.Hunk, p.Hunks, p.Path, p.Added, p.Removed)
+	}
+	return fmt.Sprintf("Hunk %d/%d failed on %s: %s", p.Hunk, p.Hunks, p.Path, p.Error)
+}
+
 // ParseAgentPatch parses the agent's specific patch format.
 // It looks for // FILE:, // EDIT:, // END_EDIT, ADD:, and DEL: markers.
 func ParseAgentPatch(patchContent string) ([]AgentPatchOperation, error) {
@@ -26,6 +47,7 @@ func ParseAgentPatch(patchContent string) ([]AgentPatchOperation, error) {
 
 	currentFile := ""
 	inEditBlock := false
+	hunk := 0
 	var fileParseError error
 
 	for _, line := range lines {
@@ -45,6 +67,7 @@ func ParseAgentPatch(patchContent string) ([]AgentPatchOperation, error) {
 				fileParseError = fmt.Errorf("found '// EDIT:' marker before '// FILE:' marker")
 			}
 			inEditBlock = true
+			hunk++
 			continue
 		}
 
@@ -62,6 +85,7 @@ func ParseAgentPatch(patchContent string) ([]AgentPatchOperation, error) {
 					Type:    "add",
 					Path:    currentFile,
 					Content: content,
+					Hunk:    hunk,
 				})
 			} else if strings.HasPrefix(line, "DEL:") {
 				content := strings.TrimPrefix(line, "DEL:")
@@ -71,6 +95,7 @@ func ParseAgentPatch(patchContent string) ([]AgentPatchOperation, error) {
 					Type:    "remove",
 					Path:    currentFile,
 					Content: content,
+					Hunk:    hunk,
 				})
 			}
 		}
@@ -83,37 +108,93 @@ func ParseAgentPatch(patchContent string) ([]AgentPatchOperation, error) {
 // This version attempts to remove lines based on content match (ignoring leading/trailing space)
 // and appends added lines.
 func ApplyAgentPatch(operations []AgentPatchOperation) ([]*AgentPatchResult, error) {
+	return ApplyAgentPatchProgress(operations, nil)
+}
+
+// agentPatchHunk is the operations of one hunk on one file
+type agentPatchHunk struct {
+	index int
+	ops   []AgentPatchOperation
+}
+
+// ApplyAgentPatchProgress applies an agent patch like ApplyAgentPatch and
+// calls progress, if not nil, for each hunk in patch order, with the lines
+// the hunk added and removed.
+func ApplyAgentPatchProgress(operations []AgentPatchOperation, progress func(PatchProgress)) ([]*AgentPatchResult, error) {
+	return ApplyAgentPatchWith(operations, nil, progress)
+}
+
+// ApplyAgentPatchWith applies an agent patch like ApplyAgentPatchProgress,
+// passing the new content of each file through normalize, if not nil, before
+// it is written
+func ApplyAgentPatchWith(operations []AgentPatchOperation, normalize Normalizer, progress func(PatchProgress)) ([]*AgentPatchResult, error) {
 	var results []*AgentPatchResult
 	var overallError error
-	opsByFile := make(map[string][]AgentPatchOperation)
+	report := func(p PatchProgress) {
+		if progress != nil {
+			progress(p)
+		}
+	}
+
+	// Group the operations by file, then by hunk, keeping the patch order
+	var paths []string
+	hunksByFile := make(map[string][]*agentPatchHunk)
+	totalHunks := 0
 	for _, op := range operations {
-		opsByFile[op.Path] = append(opsByFile[op.Path], op)
+		hunks, seen := hunksByFile[op.Path]
+		if !seen {
+			paths = append(paths, op.Path)
+		}
+		if len(hunks) == 0 || hunks[len(hunks)-1].index != op.Hunk {
+			hunks = append(hunks, &agentPatchHunk{index: op.Hunk})
+			totalHunks++
+		}
+		hunks[len(hunks)-1].ops = append(hunks[len(hunks)-1].ops, op)
+		hunksByFile[op.Path] = hunks
+	}
+	// Number hunks by position when the operations carry no hunk index
+	position := 0
+	for _, path := range paths {
+		for _, h := range hunksByFile[path] {
+			position++
+			if h.index == 0 {
+				h.index = position
+			}
+		}
 	}
 
-	for path, ops := range opsByFile {
+	for _, path := range paths {
+		hunks := hunksByFile[path]
 		result := &AgentPatchResult{Path: path, Success: false} // Default to failure
 		results = append(results, result)
+		reportAll := func(errMsg string) {
+			for _, h := range hunks {
+				report(PatchProgress{Hunk: h.index, Hunks: totalHunks, Path: path, Applied: errMsg == "", Error: errMsg})
+			}
+		}
 
-		// ----------------- Start Revised Logic -----------------
-
-		// 1. Collect lines to delete and lines to add
-		linesToDelete := make(map[string]bool)
+		// 1. Collect lines to delete and lines to add, and which hunk deletes each line
+		linesToDelete := make(map[string]int) // Trimmed line -> index in hunks of the first hunk deleting it
 		var linesToAdd []string
+		addedByHunk := make([]int, len(hunks))
 		deleteOpCount := 0 // Keep track of DEL operations for reporting
 		addOpCount := 0
-		for _, op := range ops {
-			if op.Type == "remove" {
-				// Split multi-line content into individual lines for deletion map
-				for _, lineToDelete := range strings.Split(op.Content, "\n") {
-					trimmedLine := strings.TrimSpace(lineToDelete)
-					if trimmedLine != "" { // Avoid adding empty lines from blank DEL blocks
-						linesToDelete[trimmedLine] = true
+		for i, h := range hunks {
+			for _, op := range h.ops {
+				if op.Type == "remove" {
+					// Split multi-line content into individual lines for deletion map
+					for _, lineToDelete := range strings.Split(op.Content, "\n") {
+						trimmedLine := strings.TrimSpace(lineToDelete)
+						if _, seen := linesToDelete[trimmedLine]; trimmedLine != "" && !seen { // Avoid adding empty lines from blank DEL blocks
+							linesToDelete[trimmedLine] = i
+						}
 					}
+					deleteOpCount++
+				} else if op.Type == "add" {
+					linesToAdd = append(linesToAdd, op.Content)
+					addedByHunk[i]++
+					addOpCount++
 				}
-				deleteOpCount++
-			} else if op.Type == "add" {
-				linesToAdd = append(linesToAdd, op.Content)
-				addOpCount++
 			}
 		}
 
@@ -126,6 +207,7 @@ func ApplyAgentPatch(operations []AgentPatchOperation) ([]*AgentPatchResult, err
 			if overallError == nil {
 				overallError = result.Error
 			}
+			reportAll(result.Error.Error())
 			continue // Skip to next file
 		}
 
@@ -138,10 +220,12 @@ func ApplyAgentPatch(operations []AgentPatchOperation) ([]*AgentPatchResult, err
 				if overallError == nil {
 					overallError = result.Error
 				}
+				reportAll(result.Error.Error())
 			} else {
 				// No error, but nothing to do
 				result.Success = true
 				result.Diff = "File does not exist, no operation performed."
+				reportAll("")
 			}
 			continue // Skip to next file
 		}
@@ -155,23 +239,28 @@ func ApplyAgentPatch(operations []AgentPatchOperation) ([]*AgentPatchResult, err
 		// 3. Build new content excluding deleted lines
 		modifiedLines := make([]string, 0, len(originalLines))
 		actualDeletions := 0
+		removedByHunk := make([]int, len(hunks))
 		for _, line := range originalLines {
-			if !linesToDelete[strings.TrimSpace(line)] {
+			if i, deleted := linesToDelete[strings.TrimSpace(line)]; !deleted {
 				modifiedLines = append(modifiedLines, line) // Keep the original line
 			} else {
+				removedByHunk[i]++
 				actualDeletions++
 			}
 		}
 
 		// 4. Append added lines
 		modifiedLines = append(modifiedLines, linesToAdd...)
+		for i, h := range hunks {
+			report(PatchProgress{Hunk: h.index, Hunks: totalHunks, Path: path, Applied: true, Added: addedByHunk[i], Removed: removedByHunk[i]})
+		}
 
 		// 5. Check if changes were actually made
 		linesWereModified := (actualDeletions > 0) || (addOpCount > 0) || shouldCreate
 
 		if linesWereModified {
 			newContent := strings.Join(modifiedLines, "\n")
-			// Ensure directory exists if creating file
+			// Create directory if needed
 			if shouldCreate {
 				dir := filepath.Dir(path)
 				if err := os.MkdirAll(dir, 0755); err != nil {
@@ -182,6 +271,7 @@ func ApplyAgentPatch(operations []AgentPatchOperation) ([]*AgentPatchResult, err
 					continue // Skip to next file
 				}
 			}
+			newContent, result.Normalized, result.NormalizeError = normalizeContent(normalize, path, newContent)
 			// Write the file
 			if err := ioutil.WriteFile(path, []byte(newContent), 0644); err != nil {
 				result.Error = fmt.Errorf("failed to write changes to file %s: %w", path, err)
@@ -198,7 +288,6 @@ func ApplyAgentPatch(operations []AgentPatchOperation) ([]*AgentPatchResult, err
 			result.Diff = "No effective changes applied."
 			result.NewLines = len(originalLines)
 		}
-		// ----------------- End Revised Logic -----------------
 	}
 
 	return results, overallError
@@ -221,16 +310,37 @@ type PatchOperation struct {
 	Content   string // Content to add or replace with
 	StartLine int    // Start line for the operation (1-indexed)
 	EndLine   int    // End line for the operation (1-indexed)
+
+	Normalize Normalizer // Applied to the new content before it is written; nil writes it as it is
 }
 
 // PatchResult represents the result of applying a patch
 type PatchResult struct {
-	Success       bool
-	Error         error
-	Path          string
-	OriginalLines int
-	NewLines      int
-	Diff          string
+	Success        bool
+	Error          error
+	Path           string
+	OriginalLines  int
+	NewLines       int
+	Diff           string
+	Normalized     []string // Changes the normalizer made to the written content
+	NormalizeError error    // Why the content was written without normalizing it
+}
+
+// Normalizer rewrites content about to be written to path and describes each
+// change it made
+type Normalizer func(path string, content []byte) ([]byte, []string, error)
+
+// normalizeContent passes content for path through normalize, if not nil. The
+// content is kept as it is when normalize fails.
+func normalizeContent(normalize Normalizer, path, content string) (string, []string, error) {
+	if normalize == nil {
+		return content, nil, nil
+	}
+	normalized, notes, err := normalize(path, []byte(content))
+	if err != nil {
+		return content, nil, err
+	}
+	return string(normalized), notes, nil
 }
 
 // ApplyPatch applies a patch operation to a file
@@ -276,6 +386,8 @@ func ApplyPatch(op PatchOperation) (*PatchResult, error) {
 		return nil, fmt.Errorf("unknown patch operation type: %s", op.Type)
 	}
 
+	newContent, normalized, normalizeErr := normalizeContent(op.Normalize, op.Path, newContent)
+
 	// Write the new content back to the file
 	if err := ioutil.WriteFile(op.Path, []byte(newContent), 0644); err != nil {
 		return nil, fmt.Errorf("failed to write to file %s: %w", op.Path, err)
@@ -285,11 +397,13 @@ func ApplyPatch(op PatchOperation) (*PatchResult, error) {
 	newLines := len(strings.Split(newContent, "\n"))
 
 	return &PatchResult{
-		Success:       true,
-		Path:          op.Path,
-		OriginalLines: originalLines,
-		NewLines:      newLines,
-		Diff:          diff,
+		Success:        true,
+		Path:           op.Path,
+		OriginalLines:  originalLines,
+		NewLines:       newLines,
+		Diff:           diff,
+		Normalized:     normalized,
+		NormalizeError: normalizeErr,
 	}, nil
 }
 
@@ -535,10 +649,12 @@ func atoi(s string) int {
 
 // AgentPatchResult represents the result of applying an agent patch operation
 type AgentPatchResult struct {
-	Success       bool
-	Error         error
-	Path          string
-	OriginalLines int
-	NewLines      int
-	Diff          string // Represents outcome description
+	Success        bool
+	Error          error
+	Path           string
+	OriginalLines  int
+	NewLines       int
+	Diff           string   // Represents outcome description
+	Normalized     []string // Changes the normalizer made to the written content
+	NormalizeError error    // Why the content was written without normalizing it
 }
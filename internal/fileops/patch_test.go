@@ -0,0 +1,93 @@
+package fileops
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyAgentPatchProgress(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.go")
+	os.WriteFile(main, []byte("package main\nfunc old() {}\nfunc keep() {}"), 0644)
+	created := filepath.Join(dir, "sub", "new.go")
+
+	patch := "// FILE: " + main + "\n// EDIT: replace old\nDEL: func old() {}\nADD: func renamed() {}\n// END_EDIT\n" +
+		"// EDIT: missing line\nDEL: func gone() {}\nADD: func never() {}\n// END_EDIT\n" +
+		"// FILE: " + created + "\n// EDIT: create\nADD: package sub\n// END_EDIT\n"
+	operations, err := ParseAgentPatch(patch)
+	if err != nil {
+		t.Fatalf("ParseAgentPatch failed: %v", err)
+	}
+
+	var progress []PatchProgress
+	results, err := ApplyAgentPatchProgress(operations, func(p PatchProgress) {
+		progress = append(progress, p)
+	})
+	if err != nil {
+		t.Errorf("ApplyAgentPatchProgress failed: %v", err)
+	}
+
+	// One report per hunk, in patch order
+	if len(progress) != 3 {
+		t.Fatalf("Expected 3 progress reports, got %+v", progress)
+	}
+	for i, p := range progress {
+		if p.Hunk != i+1 || p.Hunks != 3 {
+			t.Errorf("Report %d: expected hunk %d/3, got %d/%d", i, i+1, p.Hunk, p.Hunks)
+		}
+	}
+	if !progress[0].Applied || progress[0].Added != 1 || progress[0].Removed != 1 {
+		t.Errorf("Expected hunk 1 applied with +1/-1, got %+v", progress[0])
+	}
+	if !progress[1].Applied || progress[1].Added != 1 || progress[1].Removed != 0 {
+		t.Errorf("Expected hunk 2 applied with +1/-0, got %+v", progress[1])
+	}
+	if !progress[2].Applied || progress[2].Path != created {
+		t.Errorf("Expected hunk 3 to create %s, got %+v", created, progress[2])
+	}
+	if got := progress[1].String(); got != "Hunk 2/3 applied to "+main+" (+1/-0 lines)" {
+		t.Errorf("Unexpected description %q", got)
+	}
+
+	// Deleted lines are removed and added lines appended, as by ApplyAgentPatch
+	content, _ := os.ReadFile(main)
+	if string(content) != "package main\nfunc keep() {}\nfunc renamed() {}\nfunc never() {}" {
+		t.Errorf("Unexpected patched content %q", content)
+	}
+	if len(results) != 2 || !results[0].Success || !results[1].Success {
+		t.Errorf("Expected both files to be patched, got %+v, %+v", results[0], results[1])
+	}
+	if content, err := os.ReadFile(created); err != nil || string(content) != "package sub" {
+		t.Errorf("Expected new.go to be created, got %q (%v)", content, err)
+	}
+}
+
+func TestApplyAgentPatchWithNormalizesBeforeWriting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+	os.WriteFile(path, []byte("keep"), 0644)
+
+	operations, err := ParseAgentPatch("// FILE: " + path + "\n// EDIT: add\nADD: added\n// END_EDIT\n")
+	if err != nil {
+		t.Fatalf("ParseAgentPatch failed: %v", err)
+	}
+	var normalized string
+	finalNewline := func(p string, content []byte) ([]byte, []string, error) {
+		normalized = string(content)
+		return append(content, '\n'), []string{"added a final newline"}, nil
+	}
+	results, err := ApplyAgentPatchWith(operations, finalNewline, nil)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("ApplyAgentPatchWith failed: %v, %+v", err, results)
+	}
+	if normalized != "keep\nadded" {
+		t.Errorf("Expected the normalizer to get the new content, got %q", normalized)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "keep\nadded\n" {
+		t.Errorf("Expected the normalized content written, got %q", data)
+	}
+	if got := results[0].Normalized; len(got) != 1 || got[0] != "added a final newline" {
+		t.Errorf("Expected the change reported, got %q", got)
+	}
+}
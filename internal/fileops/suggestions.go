@@ -0,0 +1,104 @@
+package fileops
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// FileSuggestion is a fenced code block in an assistant reply that is
+// annotated with the file it belongs to, offered to the user to apply as a
+// write instead of the model having called write_file itself
+type FileSuggestion struct {
+	Path     string `json:"path"`
+	Language string `json:"language,omitempty"`
+	Content  string `json:"content"`
+}
+
+var (
+	// fenceAttribute matches a path annotation on an opening fence, e.g.
+	// ```go title=internal/foo.go or ```go path="internal/foo.go"
+	fenceAttribute = regexp.MustCompile(`\b(?:title|path|file|filename)=(?:"([^"]+)"|'([^']+)'|(\S+))`)
+	// leadInPath matches a line introducing the next block, e.g. "In internal/foo.go:"
+	// or "**`internal/foo.go`**:"
+	leadInPath = regexp.MustCompile("(?i)^(?:(?:in|file|update|create|updated|new file)\\s+)?[*_`]*([\\w.\\-/]+\\.\\w+)[*_`]*\\s*:?[*_]*\\s*:?$")
+)
+
+// ExtractFileSuggestions finds the fenced code blocks in text whose target file
+// is stated, either on the opening fence or on the line right before it. Blocks
+// without a stated path, with an absolute or escaping path, in diff form, or
+// for a path that more than one block claims are skipped rather than guessed.
+func ExtractFileSuggestions(text string) []FileSuggestion {
+	var suggestions []FileSuggestion
+	claims := make(map[string]int)
+
+	lines := strings.Split(text, "\n")
+	previous := "" // Last non-blank line outside a block
+	for i := 0; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(trimmed, "```") {
+			if trimmed != "" {
+				previous = trimmed
+			}
+			continue
+		}
+
+		// Find the closing fence
+		info := strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))
+		end := i + 1
+		for end < len(lines) && strings.TrimSpace(lines[end]) != "```" {
+			end++
+		}
+		if end == len(lines) {
+			break // Unterminated block, e.g. a reply cut short
+		}
+		content := strings.Join(lines[i+1:end], "\n") + "\n"
+		lead := previous
+		i, previous = end, ""
+
+		language := ""
+		if fields := strings.Fields(info); len(fields) > 0 && !strings.Contains(fields[0], "=") {
+			language = fields[0]
+		}
+		if language == "diff" || language == "patch" {
+			continue
+		}
+
+		path := fencePath(info)
+		if path == "" {
+			if m := leadInPath.FindStringSubmatch(lead); m != nil {
+				path = m[1]
+			}
+		}
+		path = filepath.ToSlash(filepath.Clean(path))
+		if path == "." || filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, "../") {
+			continue
+		}
+
+		claims[path]++
+		suggestions = append(suggestions, FileSuggestion{Path: path, Language: language, Content: content})
+	}
+
+	// Two blocks for the same file leave it unclear which one is the file
+	unambiguous := suggestions[:0]
+	for _, s := range suggestions {
+		if claims[s.Path] == 1 {
+			unambiguous = append(unambiguous, s)
+		}
+	}
+	return unambiguous
+}
+
+// fencePath returns the path annotated on a fence's info string, if any
+func fencePath(info string) string {
+	m := fenceAttribute.FindStringSubmatch(info)
+	if m == nil {
+		return ""
+	}
+	for _, group := range m[1:] {
+		if group != "" {
+			return group
+		}
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package fileops
+
+import (
+	"testing"
+)
+
+func TestExtractFileSuggestions(t *testing.T) {
+	reply := "Here is the updated file.\n\n" +
+		"```go title=internal/foo.go\npackage foo\n\nfunc Foo() {}\n```\n\n" +
+		"In cmd/main.go:\n\n```go\npackage main\n```\n\n" +
+		"**`web/app.ts`**:\n```ts\nexport {}\n```\n"
+
+	suggestions := ExtractFileSuggestions(reply)
+	expected := []FileSuggestion{
+		{Path: "internal/foo.go", Language: "go", Content: "package foo\n\nfunc Foo() {}\n"},
+		{Path: "cmd/main.go", Language: "go", Content: "package main\n"},
+		{Path: "web/app.ts", Language: "ts", Content: "export {}\n"},
+	}
+	if len(suggestions) != len(expected) {
+		t.Fatalf("Expected %d suggestions, got %d: %+v", len(expected), len(suggestions), suggestions)
+	}
+	for i := range expected {
+		if suggestions[i] != expected[i] {
+			t.Errorf("Suggestion %d: expected %+v, got %+v", i, expected[i], suggestions[i])
+		}
+	}
+}
+
+func TestExtractFileSuggestionsSkipsAmbiguousBlocks(t *testing.T) {
+	for name, reply := range map[string]string{
+		"no path":         "Try this:\n```go\npackage main\n```\n",
+		"lead-in too far": "In main.go:\n```go\npackage a\n```\nAnd then:\n```go\npackage b\n```\n",
+		"absolute path":   "```go title=/etc/passwd\nroot\n```\n",
+		"escaping path":   "In ../other/main.go:\n```go\npackage main\n```\n",
+		"diff block":      "In main.go:\n```diff\n-a\n+b\n```\n",
+		"unterminated":    "```go title=main.go\npackage main\n",
+		"claimed twice":   "```go title=main.go\npackage a\n```\n```go title=main.go\npackage b\n```\n",
+		"prose lead-in":   "Run it with node index.js:\n```sh\nnode index.js\n```\n",
+	} {
+		suggestions := ExtractFileSuggestions(reply)
+		if name == "lead-in too far" {
+			// Only the block right after the lead-in is claimed
+			if len(suggestions) != 1 || suggestions[0].Content != "package a\n" {
+				t.Errorf("%s: expected only the first block, got %+v", name, suggestions)
+			}
+			continue
+		}
+		if len(suggestions) != 0 {
+			t.Errorf("%s: expected no suggestions, got %+v", name, suggestions)
+		}
+	}
+}
+
+func TestExtractFileSuggestionsQuotedFenceAttribute(t *testing.T) {
+	suggestions := ExtractFileSuggestions("```python path=\"scripts/run it.py\"\nprint(1)\n```\n")
+	if len(suggestions) != 1 || suggestions[0].Path != "scripts/run it.py" || suggestions[0].Language != "python" {
+		t.Errorf("Expected the quoted path, got %+v", suggestions)
+	}
+}
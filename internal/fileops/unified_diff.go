@@ -0,0 +1,191 @@
+package fileops
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// diffContextLines is the number of unchanged lines shown around each change
+	diffContextLines = 3
+	// maxDiffCells bounds the LCS table; larger inputs fall back to a whole-file diff
+	maxDiffCells = 4_000_000
+)
+
+// diffOp is a single line in an edit script
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// UnifiedDiff returns a unified diff between oldText and newText for path.
+// It returns an empty string when the texts are equal.
+func UnifiedDiff(path, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+
+	oldLines := splitDiffLines(oldText)
+	newLines := splitDiffLines(newText)
+	ops := diffLines(oldLines, newLines)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n", path, path)
+
+	// Line numbers (1-based) of each op in the old and new text
+	oldAt := make([]int, len(ops)+1)
+	newAt := make([]int, len(ops)+1)
+	oldNo, newNo := 1, 1
+	for k, op := range ops {
+		oldAt[k], newAt[k] = oldNo, newNo
+		if op.kind != '+' {
+			oldNo++
+		}
+		if op.kind != '-' {
+			newNo++
+		}
+	}
+	oldAt[len(ops)], newAt[len(ops)] = oldNo, newNo
+
+	// Build hunks from changed ops padded with context, merging overlapping ranges
+	k := 0
+	for k < len(ops) {
+		if ops[k].kind == ' ' {
+			k++
+			continue
+		}
+		start := k - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			// Look ahead: a change within 2*context lines continues this hunk
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' && next-end < 2*diffContextLines {
+				next++
+			}
+			if next < len(ops) && ops[next].kind != ' ' {
+				end = next
+				continue
+			}
+			break
+		}
+		stop := end + diffContextLines
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		oldCount := oldAt[stop] - oldAt[start]
+		newCount := newAt[stop] - newAt[start]
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", hunkStart(oldAt[start], oldCount), oldCount, hunkStart(newAt[start], newCount), newCount)
+		for _, op := range ops[start:stop] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.line)
+			b.WriteByte('\n')
+		}
+		k = stop
+	}
+
+	return b.String()
+}
+
+// hunkStart follows the unified format convention of reporting line 0 for empty ranges
+func hunkStart(start, count int) int {
+	if count == 0 {
+		return start - 1
+	}
+	return start
+}
+
+// splitDiffLines splits text into lines without their trailing newline
+func splitDiffLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines computes a line edit script using the longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	// Strip the common prefix and suffix to keep the table small
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+
+	midA := a[prefix : len(a)-suffix]
+	midB := b[prefix : len(b)-suffix]
+	if len(midA)*len(midB) > maxDiffCells {
+		for _, line := range midA {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range midB {
+			ops = append(ops, diffOp{'+', line})
+		}
+	} else {
+		ops = append(ops, lcsDiff(midA, midB)...)
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// lcsDiff computes the edit script for a and b with a dynamic programming LCS table
+func lcsDiff(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	table := make([][]int, n+1)
+	for i := range table {
+		table[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else if table[i+1][j] >= table[i][j+1] {
+				table[i][j] = table[i+1][j]
+			} else {
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
@@ -0,0 +1,268 @@
+package functions
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileEdit is one operation of an apply_edits batch
+type FileEdit struct {
+	Path      string `json:"path"`
+	Operation string `json:"operation"` // "write", "patch" or "delete"
+	Content   string `json:"content"`   // write: the full new content
+	OldText   string `json:"old_text"`  // patch: text that must occur exactly once
+	NewText   string `json:"new_text"`  // patch: its replacement
+}
+
+// EditResult is the outcome of a batch for one file
+type EditResult struct {
+	Status string `json:"status"` // "created", "modified" or "deleted"
+	Bytes  int    `json:"bytes"`  // Size of the file after the batch
+}
+
+// stagedFile is the state of one file while a batch is validated and applied
+type stagedFile struct {
+	path     string
+	existed  bool
+	original []byte
+	mode     os.FileMode
+	exists   bool // Whether the file exists once the batch is applied
+	content  []byte
+	tempPath string // Staged content, renamed over path on commit
+}
+
+// ApplyEdits applies a batch of writes, patches and deletes atomically. Every
+// edit is validated against the batch's view of the files before anything is
+// touched; new content is then staged next to its target and renamed into
+// place. If any step fails, files already replaced are restored and nothing
+// of the batch remains. Edits to the same path apply in order.
+func ApplyEdits(args string) (string, error) {
+	var params struct {
+		Edits []FileEdit `json:"edits"`
+	}
+	if err := json.Unmarshal([]byte(args), &params); err != nil {
+		return "", fmt.Errorf("failed to parse arguments: %w", err)
+	}
+	if len(params.Edits) == 0 {
+		return "", fmt.Errorf("edits parameter is required")
+	}
+
+	// Validate every edit against the content the earlier edits produce
+	files := make(map[string]*stagedFile)
+	var order []*stagedFile
+	for i, edit := range params.Edits {
+		if edit.Path == "" {
+			return "", fmt.Errorf("edit %d: path is required", i+1)
+		}
+		absPath, err := filepath.Abs(edit.Path)
+		if err != nil {
+			return "", fmt.Errorf("edit %d: failed to resolve absolute path: %w", i+1, err)
+		}
+		file, ok := files[absPath]
+		if !ok {
+			file, err = loadStagedFile(absPath)
+			if err != nil {
+				return "", fmt.Errorf("edit %d (%s): %w", i+1, edit.Path, err)
+			}
+			files[absPath] = file
+			order = append(order, file)
+		}
+		if err := file.apply(edit); err != nil {
+			return "", fmt.Errorf("no edits were applied: edit %d (%s): %w", i+1, edit.Path, err)
+		}
+	}
+
+	if err := commitStagedFiles(order); err != nil {
+		return "", fmt.Errorf("no edits were applied: %w", err)
+	}
+
+	results := make(map[string]EditResult, len(order))
+	for _, file := range order {
+		result := EditResult{Status: "modified", Bytes: len(file.content)}
+		switch {
+		case !file.exists:
+			result = EditResult{Status: "deleted"}
+		case !file.existed:
+			result.Status = "created"
+		}
+		results[file.path] = result
+	}
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode results: %w", err)
+	}
+	return fmt.Sprintf("Applied %d edits to %d files:\n%s", len(params.Edits), len(order), data), nil
+}
+
+// loadStagedFile reads the current state of path
+func loadStagedFile(path string) (*stagedFile, error) {
+	file := &stagedFile{path: path, mode: 0644}
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		return file, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	case info.IsDir():
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	file.existed, file.exists = true, true
+	file.original, file.content = content, content
+	file.mode = info.Mode().Perm()
+	return file, nil
+}
+
+// apply updates the staged content with one edit
+func (f *stagedFile) apply(edit FileEdit) error {
+	switch edit.Operation {
+	case "write":
+		f.exists = true
+		f.content = []byte(edit.Content)
+	case "patch":
+		if !f.exists {
+			return fmt.Errorf("file does not exist")
+		}
+		if edit.OldText == "" {
+			return fmt.Errorf("old_text is required for a patch")
+		}
+		switch count := strings.Count(string(f.content), edit.OldText); count {
+		case 0:
+			return fmt.Errorf("old_text was not found")
+		case 1:
+			f.content = []byte(strings.Replace(string(f.content), edit.OldText, edit.NewText, 1))
+		default:
+			return fmt.Errorf("old_text occurs %d times; include more context so it is unique", count)
+		}
+	case "delete":
+		if !f.exists {
+			return fmt.Errorf("file does not exist")
+		}
+		f.exists = false
+		f.content = nil
+	default:
+		return fmt.Errorf("unknown operation %q (expected write, patch or delete)", edit.Operation)
+	}
+	return nil
+}
+
+// commitStagedFiles stages the new content of every file, then moves it into
+// place. On failure every file is restored to its original state.
+func commitStagedFiles(files []*stagedFile) (err error) {
+	var createdDirs []string
+	defer func() {
+		for _, file := range files {
+			if file.tempPath != "" {
+				os.Remove(file.tempPath)
+			}
+		}
+		if err != nil {
+			// Remove directories made for new files, deepest first
+			sort.Sort(sort.Reverse(sort.StringSlice(createdDirs)))
+			for _, dir := range createdDirs {
+				os.Remove(dir)
+			}
+		}
+	}()
+
+	for _, file := range files {
+		if !file.exists {
+			continue
+		}
+		dirs, err := makeParentDirs(filepath.Dir(file.path))
+		createdDirs = append(createdDirs, dirs...)
+		if err != nil {
+			return fmt.Errorf("%s: failed to create directory: %w", file.path, err)
+		}
+		if err := file.stage(); err != nil {
+			return fmt.Errorf("%s: %w", file.path, err)
+		}
+	}
+
+	for i, file := range files {
+		if err := file.commit(); err != nil {
+			for _, done := range files[:i] {
+				done.restore()
+			}
+			return fmt.Errorf("%s: %w", file.path, err)
+		}
+	}
+	return nil
+}
+
+// stage writes the new content to a temporary file next to the target
+func (f *stagedFile) stage() error {
+	temp, err := os.CreateTemp(filepath.Dir(f.path), "."+filepath.Base(f.path)+".codex-edit-*")
+	if err != nil {
+		return fmt.Errorf("failed to create staging file: %w", err)
+	}
+	f.tempPath = temp.Name()
+	if _, err := temp.Write(f.content); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to write staging file: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write staging file: %w", err)
+	}
+	if err := os.Chmod(f.tempPath, f.mode); err != nil {
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+	return nil
+}
+
+// commit moves the staged content into place or deletes the file
+func (f *stagedFile) commit() error {
+	if !f.exists {
+		if !f.existed {
+			return nil
+		}
+		if err := os.Remove(f.path); err != nil {
+			return fmt.Errorf("failed to delete file: %w", err)
+		}
+		return nil
+	}
+	if err := os.Rename(f.tempPath, f.path); err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+	f.tempPath = ""
+	return nil
+}
+
+// restore puts back the content the file had before the batch
+func (f *stagedFile) restore() {
+	if !f.existed {
+		os.Remove(f.path)
+		return
+	}
+	os.WriteFile(f.path, f.original, f.mode)
+	os.Chmod(f.path, f.mode)
+}
+
+// makeParentDirs creates dir and its missing parents, returning those it created
+func makeParentDirs(dir string) ([]string, error) {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
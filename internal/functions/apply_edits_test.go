@@ -0,0 +1,153 @@
+package functions
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/fileops"
+)
+
+// writeFiles creates files under dir from a map of relative path to content
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestApplyEdits(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.go":   "package a\n\nfunc Old() {}\n",
+		"old.go": "package a\n",
+	})
+
+	output, err := ApplyEdits(mustArgs(t, map[string]interface{}{"edits": []map[string]string{
+		{"path": filepath.Join(dir, "a.go"), "operation": "patch", "old_text": "func Old()", "new_text": "func New()"},
+		{"path": filepath.Join(dir, "a.go"), "operation": "patch", "old_text": "func New() {}", "new_text": "func New() { run() }"},
+		{"path": filepath.Join(dir, "sub", "b.go"), "operation": "write", "content": "package sub\n"},
+		{"path": filepath.Join(dir, "old.go"), "operation": "delete"},
+	}}))
+	if err != nil {
+		t.Fatalf("ApplyEdits failed: %v", err)
+	}
+
+	if got := readFile(t, filepath.Join(dir, "a.go")); got != "package a\n\nfunc New() { run() }\n" {
+		t.Errorf("Expected both patches to apply in order, got %q", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "sub", "b.go")); got != "package sub\n" {
+		t.Errorf("Expected the new file to be written, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old.go")); !os.IsNotExist(err) {
+		t.Errorf("Expected old.go to be deleted, got err=%v", err)
+	}
+	for _, want := range []string{`"status": "modified"`, `"status": "created"`, `"status": "deleted"`, "Applied 4 edits to 3 files"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, output)
+		}
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(dir, "sub"))
+	if len(entries) != 1 {
+		t.Errorf("Expected no staging files to remain, got %d entries", len(entries))
+	}
+}
+
+func TestApplyEditsValidatesBeforeWriting(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.go": "x := 1\nx := 1\n"})
+
+	tests := []struct {
+		name string
+		edit map[string]string
+		want string
+	}{
+		{"not found", map[string]string{"path": "a.go", "operation": "patch", "old_text": "y", "new_text": "z"}, "old_text was not found"},
+		{"ambiguous", map[string]string{"path": "a.go", "operation": "patch", "old_text": "x := 1", "new_text": "x := 2"}, "occurs 2 times"},
+		{"missing file", map[string]string{"path": "missing.go", "operation": "delete"}, "file does not exist"},
+		{"unknown operation", map[string]string{"path": "a.go", "operation": "rename"}, "unknown operation"},
+	}
+	for _, tt := range tests {
+		tt.edit["path"] = filepath.Join(dir, tt.edit["path"])
+		_, err := ApplyEdits(mustArgs(t, map[string]interface{}{"edits": []map[string]string{
+			{"path": filepath.Join(dir, "new.go"), "operation": "write", "content": "package a\n"},
+			tt.edit,
+		}}))
+		if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), "no edits were applied") {
+			t.Errorf("%s: Expected an error containing %q, got %v", tt.name, tt.want, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "new.go")); !os.IsNotExist(err) {
+			t.Errorf("%s: Expected the earlier write not to be applied", tt.name)
+		}
+	}
+	if got := readFile(t, filepath.Join(dir, "a.go")); got != "x := 1\nx := 1\n" {
+		t.Errorf("Expected a.go to be untouched, got %q", got)
+	}
+}
+
+func TestApplyEditsRollsBackOnStagingFailure(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.go": "one\n", "blocker": "a file, not a directory\n"})
+
+	_, err := ApplyEdits(mustArgs(t, map[string]interface{}{"edits": []map[string]string{
+		{"path": filepath.Join(dir, "a.go"), "operation": "write", "content": "two\n"},
+		{"path": filepath.Join(dir, "new", "dir", "b.go"), "operation": "write", "content": "b\n"},
+		{"path": filepath.Join(dir, "blocker", "c.go"), "operation": "write", "content": "c\n"},
+	}}))
+	if err == nil {
+		t.Fatal("Expected writing below a regular file to fail")
+	}
+
+	if got := readFile(t, filepath.Join(dir, "a.go")); got != "one\n" {
+		t.Errorf("Expected a.go to keep its content, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+		t.Errorf("Expected directories created for the batch to be removed, got err=%v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("Expected no staging files to remain, got %d entries", len(entries))
+	}
+}
+
+func TestApplyEditsJournalsEveryPath(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.go": "one\n", "b.go": "two\n"})
+	workspace := &Workspace{Dir: dir, Confine: true}
+	journal := fileops.NewJournal()
+	fn := workspace.EditPaths(WithJournal(journal, ApplyEdits))
+
+	if _, err := fn(mustArgs(t, map[string]interface{}{"edits": []map[string]string{
+		{"path": "a.go", "operation": "write", "content": "uno\n"},
+		{"path": "b.go", "operation": "delete"},
+	}})); err != nil {
+		t.Fatalf("ApplyEdits failed: %v", err)
+	}
+	if changes := journal.Changes(); len(changes) != 2 || changes[0].Status != "modified" || changes[1].Status != "deleted" {
+		t.Errorf("Expected a.go modified and b.go deleted in the journal, got %+v", changes)
+	}
+
+	_, err := fn(mustArgs(t, map[string]interface{}{"edits": []map[string]string{
+		{"path": "../outside.go", "operation": "write", "content": "x\n"},
+	}}))
+	if err == nil || !strings.Contains(err.Error(), "outside the working directory") {
+		t.Errorf("Expected a confinement error, got %v", err)
+	}
+}
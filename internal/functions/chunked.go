@@ -0,0 +1,244 @@
+package functions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/epuerta/codex-go/internal/fileops"
+)
+
+// ChunkedWriter stages large file writes that arrive over several tool calls.
+// Chunks are appended to a temporary file next to the target and the file is
+// only moved into place once commit_write verifies the staged content.
+type ChunkedWriter struct {
+	mu      sync.Mutex
+	pending map[string]*pendingWrite // Keyed by absolute target path
+}
+
+// pendingWrite tracks a single in-progress chunked write
+type pendingWrite struct {
+	path           string // Absolute target path
+	tempPath       string // Staging file in the target's directory
+	totalChunks    int    // Expected number of chunks (0 = not specified)
+	expectedSHA256 string // Expected hex digest of the full content (empty = not specified)
+	received       int    // Number of chunks appended so far
+	bytes          int    // Number of bytes appended so far
+}
+
+// NewChunkedWriter creates a new chunked writer
+func NewChunkedWriter() *ChunkedWriter {
+	return &ChunkedWriter{
+		pending: make(map[string]*pendingWrite),
+	}
+}
+
+// BeginWrite starts a chunked write for a path
+func (w *ChunkedWriter) BeginWrite(args string) (string, error) {
+	var params struct {
+		Path           string `json:"path"`
+		TotalChunks    int    `json:"total_chunks"`
+		ExpectedSHA256 string `json:"expected_sha256"`
+	}
+	if err := json.Unmarshal([]byte(args), &params); err != nil {
+		return "", fmt.Errorf("failed to parse arguments: %w", err)
+	}
+	if params.Path == "" {
+		return "", fmt.Errorf("path parameter is required")
+	}
+	if params.TotalChunks < 0 {
+		return "", fmt.Errorf("total_chunks must not be negative")
+	}
+
+	absPath, err := filepath.Abs(params.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve absolute path: %w", err)
+	}
+
+	// Stage in the target directory so the final rename stays on one filesystem
+	dir := filepath.Dir(absPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+	tempFile, err := os.CreateTemp(dir, "."+filepath.Base(absPath)+".codex-write-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging file: %w", err)
+	}
+	tempFile.Close()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Restarting a write discards whatever was staged before
+	if previous, exists := w.pending[absPath]; exists {
+		os.Remove(previous.tempPath)
+	}
+	w.pending[absPath] = &pendingWrite{
+		path:           absPath,
+		tempPath:       tempFile.Name(),
+		totalChunks:    params.TotalChunks,
+		expectedSHA256: strings.ToLower(strings.TrimSpace(params.ExpectedSHA256)),
+	}
+
+	return fmt.Sprintf("Started chunked write to %s. Send chunks with append_chunk starting at index 0, then call commit_write.", params.Path), nil
+}
+
+// AppendChunk appends the next chunk of content to a pending write
+func (w *ChunkedWriter) AppendChunk(args string) (string, error) {
+	var params struct {
+		Path    string `json:"path"`
+		Index   int    `json:"index"`
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal([]byte(args), &params); err != nil {
+		return "", fmt.Errorf("failed to parse arguments: %w", err)
+	}
+	if params.Path == "" {
+		return "", fmt.Errorf("path parameter is required")
+	}
+
+	absPath, err := filepath.Abs(params.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve absolute path: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	pw, exists := w.pending[absPath]
+	if !exists {
+		return "", fmt.Errorf("no chunked write in progress for %s; call begin_write first", params.Path)
+	}
+	if params.Index != pw.received {
+		return "", fmt.Errorf("chunk index %d out of order for %s: expected index %d", params.Index, params.Path, pw.received)
+	}
+	if pw.totalChunks > 0 && params.Index >= pw.totalChunks {
+		return "", fmt.Errorf("chunk index %d exceeds declared total_chunks %d for %s", params.Index, pw.totalChunks, params.Path)
+	}
+
+	f, err := os.OpenFile(pw.tempPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to open staging file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(params.Content); err != nil {
+		return "", fmt.Errorf("failed to write chunk: %w", err)
+	}
+
+	pw.received++
+	pw.bytes += len(params.Content)
+
+	return fmt.Sprintf("Appended chunk %d (%d bytes) to %s. Total staged: %d bytes.", params.Index, len(params.Content), params.Path, pw.bytes), nil
+}
+
+// CommitWrite verifies a pending write and moves it into place
+func (w *ChunkedWriter) CommitWrite(args string) (string, error) {
+	return w.commitWrite(args, nil)
+}
+
+// CommitWriteWith returns commit_write with the staged content passed through
+// normalize, if not nil, before it is moved into place
+func (w *ChunkedWriter) CommitWriteWith(normalize fileops.Normalizer) Function {
+	return func(args string) (string, error) {
+		return w.commitWrite(args, normalize)
+	}
+}
+
+func (w *ChunkedWriter) commitWrite(args string, normalize fileops.Normalizer) (string, error) {
+	var params struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal([]byte(args), &params); err != nil {
+		return "", fmt.Errorf("failed to parse arguments: %w", err)
+	}
+	if params.Path == "" {
+		return "", fmt.Errorf("path parameter is required")
+	}
+
+	absPath, err := filepath.Abs(params.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve absolute path: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	pw, exists := w.pending[absPath]
+	if !exists {
+		return "", fmt.Errorf("no chunked write in progress for %s; call begin_write first", params.Path)
+	}
+
+	// A failed verification discards the staged content; the target is left untouched
+	if pw.totalChunks > 0 && pw.received != pw.totalChunks {
+		w.discard(pw)
+		return "", fmt.Errorf("commit rejected for %s: received %d of %d chunks; the write was discarded, call begin_write to start over", params.Path, pw.received, pw.totalChunks)
+	}
+
+	if pw.expectedSHA256 != "" {
+		data, err := os.ReadFile(pw.tempPath)
+		if err != nil {
+			w.discard(pw)
+			return "", fmt.Errorf("failed to read staging file: %w", err)
+		}
+		sum := sha256.Sum256(data)
+		actual := hex.EncodeToString(sum[:])
+		if actual != pw.expectedSHA256 {
+			w.discard(pw)
+			return "", fmt.Errorf("commit rejected for %s: sha256 mismatch (expected %s, got %s); the write was discarded, call begin_write to start over", params.Path, pw.expectedSHA256, actual)
+		}
+	}
+
+	// The staged content is normalized before the target is written
+	note := ""
+	if normalize != nil {
+		data, err := os.ReadFile(pw.tempPath)
+		if err != nil {
+			w.discard(pw)
+			return "", fmt.Errorf("failed to read staging file: %w", err)
+		}
+		var normalized string
+		normalized, note = normalizeForWrite(normalize, pw.path, string(data))
+		if normalized != string(data) {
+			if err := os.WriteFile(pw.tempPath, []byte(normalized), 0644); err != nil {
+				w.discard(pw)
+				return "", fmt.Errorf("failed to normalize staging file: %w", err)
+			}
+			pw.bytes = len(normalized)
+		}
+	}
+
+	if err := os.Chmod(pw.tempPath, 0644); err != nil {
+		w.discard(pw)
+		return "", fmt.Errorf("failed to set file mode: %w", err)
+	}
+	if err := os.Rename(pw.tempPath, pw.path); err != nil {
+		w.discard(pw)
+		return "", fmt.Errorf("failed to move staged file into place: %w", err)
+	}
+	delete(w.pending, absPath)
+
+	return fmt.Sprintf("Successfully wrote %d bytes to %s in %d chunk(s)", pw.bytes, params.Path, pw.received) + note, nil
+}
+
+// Cleanup removes all abandoned partial writes and returns how many were discarded
+func (w *ChunkedWriter) Cleanup() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	count := len(w.pending)
+	for _, pw := range w.pending {
+		w.discard(pw)
+	}
+	return count
+}
+
+// discard removes a pending write's staging file. Caller must hold w.mu.
+func (w *ChunkedWriter) discard(pw *pendingWrite) {
+	os.Remove(pw.tempPath)
+	delete(w.pending, pw.path)
+}
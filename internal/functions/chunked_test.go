@@ -0,0 +1,139 @@
+package functions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/fileops"
+)
+
+func mustArgs(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Failed to marshal args: %v", err)
+	}
+	return string(data)
+}
+
+func TestChunkedWriteCommit(t *testing.T) {
+	tempDir := t.TempDir()
+	target := filepath.Join(tempDir, "out.txt")
+	chunks := []string{"hello ", "chunked ", "world\n"}
+	full := strings.Join(chunks, "")
+	sum := sha256.Sum256([]byte(full))
+
+	w := NewChunkedWriter()
+	if _, err := w.BeginWrite(mustArgs(t, map[string]interface{}{
+		"path":            target,
+		"total_chunks":    len(chunks),
+		"expected_sha256": hex.EncodeToString(sum[:]),
+	})); err != nil {
+		t.Fatalf("BeginWrite failed: %v", err)
+	}
+
+	for i, chunk := range chunks {
+		if _, err := w.AppendChunk(mustArgs(t, map[string]interface{}{"path": target, "index": i, "content": chunk})); err != nil {
+			t.Fatalf("AppendChunk %d failed: %v", i, err)
+		}
+	}
+
+	// The target must not exist until the commit succeeds
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("Expected target to be absent before commit, got err=%v", err)
+	}
+
+	if _, err := w.CommitWrite(mustArgs(t, map[string]interface{}{"path": target})); err != nil {
+		t.Fatalf("CommitWrite failed: %v", err)
+	}
+
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatalf("Failed to read committed file: %v", err)
+	}
+	if string(data) != full {
+		t.Errorf("Expected content %q, got %q", full, string(data))
+	}
+}
+
+func TestChunkedWriteChecksumMismatch(t *testing.T) {
+	tempDir := t.TempDir()
+	target := filepath.Join(tempDir, "out.txt")
+	if err := os.WriteFile(target, []byte("original"), 0644); err != nil {
+		t.Fatalf("Failed to write original file: %v", err)
+	}
+
+	w := NewChunkedWriter()
+	if _, err := w.BeginWrite(mustArgs(t, map[string]interface{}{
+		"path":            target,
+		"total_chunks":    1,
+		"expected_sha256": strings.Repeat("0", 64),
+	})); err != nil {
+		t.Fatalf("BeginWrite failed: %v", err)
+	}
+	if _, err := w.AppendChunk(mustArgs(t, map[string]interface{}{"path": target, "index": 0, "content": "new content"})); err != nil {
+		t.Fatalf("AppendChunk failed: %v", err)
+	}
+
+	_, err := w.CommitWrite(mustArgs(t, map[string]interface{}{"path": target}))
+	if err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("Expected sha256 mismatch error, got %v", err)
+	}
+
+	data, _ := os.ReadFile(target)
+	if string(data) != "original" {
+		t.Errorf("Target was modified despite checksum mismatch: %q", string(data))
+	}
+}
+
+func TestChunkedWriteOutOfOrderAndCleanup(t *testing.T) {
+	tempDir := t.TempDir()
+	target := filepath.Join(tempDir, "out.txt")
+
+	w := NewChunkedWriter()
+	if _, err := w.BeginWrite(mustArgs(t, map[string]interface{}{"path": target, "total_chunks": 2})); err != nil {
+		t.Fatalf("BeginWrite failed: %v", err)
+	}
+	if _, err := w.AppendChunk(mustArgs(t, map[string]interface{}{"path": target, "index": 1, "content": "x"})); err == nil {
+		t.Errorf("Expected error for out-of-order chunk")
+	}
+
+	if discarded := w.Cleanup(); discarded != 1 {
+		t.Errorf("Expected 1 discarded write, got %d", discarded)
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to read temp dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected staging files to be removed, found %d entries", len(entries))
+	}
+}
+
+func TestToolRegistryEndTurnDiscardsChunkedWrites(t *testing.T) {
+	tempDir := t.TempDir()
+	registry := NewToolRegistry(&config.Config{WorkingDir: tempDir}, fileops.NewJournal(), nil, nil, nil)
+
+	if _, err := registry.Get("begin_write")(mustArgs(t, map[string]interface{}{"path": "out.txt", "total_chunks": 2})); err != nil {
+		t.Fatalf("begin_write failed: %v", err)
+	}
+	registry.EndTurn()
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to read temp dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected the abandoned write to be discarded at the end of the turn, found %d entries", len(entries))
+	}
+	if _, err := registry.Get("commit_write")(mustArgs(t, map[string]interface{}{"path": "out.txt"})); err == nil {
+		t.Errorf("Expected committing a discarded write to fail")
+	}
+}
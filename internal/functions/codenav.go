@@ -0,0 +1,310 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/lsp"
+)
+
+const (
+	// codeNavTimeout bounds a single navigation request, including a server (re)start
+	codeNavTimeout = 30 * time.Second
+	// codeNavMaxResults caps how many locations are listed
+	codeNavMaxResults = 50
+	// definitionSnippetLines is how many lines are shown from each definition
+	definitionSnippetLines = 5
+)
+
+// positionPattern matches a "file:line" or "file:line:column" target
+var positionPattern = regexp.MustCompile(`^(.+):(\d+)(?::(\d+))?$`)
+
+// CodeNavTools exposes language server code navigation to the model as the
+// find_definition, find_references, document_symbols and hover tools
+type CodeNavTools struct {
+	servers *lsp.Manager
+}
+
+// NewCodeNavTools creates the navigation tools backed by servers
+func NewCodeNavTools(servers *lsp.Manager) *CodeNavTools {
+	return &CodeNavTools{servers: servers}
+}
+
+// navTarget is either a symbol name or a position in a file
+type navTarget struct {
+	Symbol string `json:"symbol"`
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+
+	position *lsp.Position // Set when the LSP position is already known
+}
+
+// FindDefinition locates where a symbol is defined
+func (t *CodeNavTools) FindDefinition(args string) (string, error) {
+	target, err := t.parseTarget(args)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), codeNavTimeout)
+	defer cancel()
+
+	var locations []lsp.Location
+	if target.Path != "" {
+		err = t.atPosition(ctx, target, func(c *lsp.Client, pos lsp.Position) (err error) {
+			locations, err = c.Definition(ctx, target.Path, pos)
+			return err
+		})
+	} else {
+		locations, err = t.findSymbol(ctx, target.Symbol)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(locations) == 0 {
+		return fmt.Sprintf("No definition found for %s.", target.describe()), nil
+	}
+	return fmt.Sprintf("Definition of %s:\n%s", target.describe(), t.formatLocations(locations, definitionSnippetLines)), nil
+}
+
+// FindReferences lists the places a symbol is used
+func (t *CodeNavTools) FindReferences(args string) (string, error) {
+	target, err := t.parseTarget(args)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), codeNavTimeout)
+	defer cancel()
+
+	// A symbol name is resolved to its definition, whose references are then requested
+	if target.Path == "" {
+		definitions, err := t.findSymbol(ctx, target.Symbol)
+		if err != nil {
+			return "", err
+		}
+		if len(definitions) == 0 {
+			return fmt.Sprintf("No symbol named %s was found.", target.Symbol), nil
+		}
+		target.Path = lsp.URIPath(definitions[0].URI)
+		target.Line = definitions[0].Range.Start.Line + 1
+		target.position = &definitions[0].Range.Start
+	}
+
+	var locations []lsp.Location
+	err = t.atPosition(ctx, target, func(c *lsp.Client, pos lsp.Position) (err error) {
+		locations, err = c.References(ctx, target.Path, pos)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(locations) == 0 {
+		return fmt.Sprintf("No references found for %s.", target.describe()), nil
+	}
+	return fmt.Sprintf("%d references to %s:\n%s", len(locations), target.describe(), t.formatLocations(locations, 1)), nil
+}
+
+// DocumentSymbols outlines the symbols declared in a file
+func (t *CodeNavTools) DocumentSymbols(args string) (string, error) {
+	var params struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal([]byte(args), &params); err != nil {
+		return "", fmt.Errorf("failed to parse arguments: %w", err)
+	}
+	if params.Path == "" {
+		return "", fmt.Errorf("path parameter is required")
+	}
+	path := t.resolve(params.Path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), codeNavTimeout)
+	defer cancel()
+
+	var symbols []lsp.DocumentSymbol
+	err := t.servers.ForFile(ctx, path, func(c *lsp.Client) (err error) {
+		symbols, err = c.DocumentSymbols(ctx, path)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(symbols) == 0 {
+		return fmt.Sprintf("No symbols found in %s.", t.relative(path)), nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Symbols in %s:\n", t.relative(path))
+	writeOutline(&b, symbols, 0)
+	return b.String(), nil
+}
+
+// Hover returns the signature and documentation of the symbol at a position
+func (t *CodeNavTools) Hover(args string) (string, error) {
+	target, err := t.parseTarget(args)
+	if err != nil {
+		return "", err
+	}
+	if target.Path == "" {
+		return "", fmt.Errorf("path and line parameters are required")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), codeNavTimeout)
+	defer cancel()
+
+	var text string
+	err = t.atPosition(ctx, target, func(c *lsp.Client, pos lsp.Position) (err error) {
+		text, err = c.Hover(ctx, target.Path, pos)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(text) == "" {
+		return fmt.Sprintf("No information available at %s.", target.describe()), nil
+	}
+	return text, nil
+}
+
+// parseTarget reads a navTarget, accepting "file:line[:column]" in symbol
+func (t *CodeNavTools) parseTarget(args string) (navTarget, error) {
+	var target navTarget
+	if err := json.Unmarshal([]byte(args), &target); err != nil {
+		return target, fmt.Errorf("failed to parse arguments: %w", err)
+	}
+	if target.Path == "" {
+		if m := positionPattern.FindStringSubmatch(target.Symbol); m != nil {
+			target.Path = m[1]
+			target.Line, _ = strconv.Atoi(m[2])
+			if m[3] != "" {
+				target.Column, _ = strconv.Atoi(m[3])
+			}
+		}
+	}
+	if target.Path != "" {
+		if target.Line <= 0 {
+			return target, fmt.Errorf("line parameter is required with path")
+		}
+		target.Path = t.resolve(target.Path)
+		return target, nil
+	}
+	if strings.TrimSpace(target.Symbol) == "" {
+		return target, fmt.Errorf("symbol parameter is required")
+	}
+	return target, nil
+}
+
+// atPosition runs fn with the server for the target's file and its LSP position
+func (t *CodeNavTools) atPosition(ctx context.Context, target navTarget, fn func(*lsp.Client, lsp.Position) error) error {
+	var pos lsp.Position
+	if target.position != nil {
+		pos = *target.position
+	} else {
+		data, err := os.ReadFile(target.Path)
+		if err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		if pos, err = lsp.PositionFor(string(data), target.Line, target.Column); err != nil {
+			return err
+		}
+	}
+	return t.servers.ForFile(ctx, target.Path, func(c *lsp.Client) error {
+		return fn(c, pos)
+	})
+}
+
+// findSymbol searches the workspace for symbols named name. "Type.Method" and
+// package-qualified names match on their trailing components.
+func (t *CodeNavTools) findSymbol(ctx context.Context, name string) ([]lsp.Location, error) {
+	var locations []lsp.Location
+	err := t.servers.ForEach(ctx, func(c *lsp.Client) error {
+		symbols, err := c.WorkspaceSymbols(ctx, name)
+		if err != nil {
+			return err
+		}
+		for _, sym := range symbols {
+			if symbolMatches(sym, name) {
+				locations = append(locations, sym.Location)
+			}
+		}
+		return nil
+	})
+	return locations, err
+}
+
+// symbolMatches reports whether a workspace symbol is the one asked for
+func symbolMatches(sym lsp.SymbolInformation, name string) bool {
+	qualified := sym.Name
+	if sym.ContainerName != "" && !strings.Contains(sym.Name, ".") {
+		qualified = sym.ContainerName + "." + sym.Name
+	}
+	return sym.Name == name || qualified == name || strings.HasSuffix(qualified, "."+name)
+}
+
+// formatLocations renders locations as file:line followed by up to lines lines of code
+func (t *CodeNavTools) formatLocations(locations []lsp.Location, lines int) string {
+	var b strings.Builder
+	files := make(map[string][]string)
+	for i, loc := range locations {
+		if i == codeNavMaxResults {
+			fmt.Fprintf(&b, "... and %d more\n", len(locations)-i)
+			break
+		}
+		path := lsp.URIPath(loc.URI)
+		start := loc.Range.Start.Line
+		fmt.Fprintf(&b, "%s:%d\n", t.relative(path), start+1)
+
+		content, ok := files[path]
+		if !ok {
+			if data, err := os.ReadFile(path); err == nil {
+				content = strings.Split(string(data), "\n")
+			}
+			files[path] = content
+		}
+		for n := start; n < start+lines && n < len(content); n++ {
+			fmt.Fprintf(&b, "  %d | %s\n", n+1, strings.TrimRight(content[n], "\r"))
+		}
+	}
+	return b.String()
+}
+
+// writeOutline writes an indented symbol outline
+func writeOutline(b *strings.Builder, symbols []lsp.DocumentSymbol, depth int) {
+	for _, sym := range symbols {
+		fmt.Fprintf(b, "%s%s %s", strings.Repeat("  ", depth), sym.Kind, sym.Name)
+		if sym.Detail != "" {
+			fmt.Fprintf(b, " %s", sym.Detail)
+		}
+		fmt.Fprintf(b, " (line %d)\n", sym.SelectionRange.Start.Line+1)
+		writeOutline(b, sym.Children, depth+1)
+	}
+}
+
+// resolve makes a path absolute against the project root
+func (t *CodeNavTools) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(t.servers.RootDir(), path)
+}
+
+// relative shortens paths inside the project root for display
+func (t *CodeNavTools) relative(path string) string {
+	if rel, err := filepath.Rel(t.servers.RootDir(), path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// describe names the target in messages
+func (target navTarget) describe() string {
+	if target.Symbol != "" {
+		return target.Symbol
+	}
+	return fmt.Sprintf("%s:%d", target.Path, target.Line)
+}
@@ -3,34 +3,59 @@ package functions
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"maps"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/editorconfig"
 	"github.com/epuerta/codex-go/internal/fileops"
 	"github.com/epuerta/codex-go/internal/sandbox"
 )
 
 // Registry holds registered functions
 type Registry struct {
-	functions map[string]Function
+	functions        map[string]Function
+	contextFunctions map[string]ContextFunction
+	turnEnd          []func() // Run by EndTurn, see AtTurnEnd
+	workspace        *Workspace
 }
 
 // Function represents a function that can be called by the agent
 type Function func(args string) (string, error)
 
+// ContextFunction is a function that stops, killing any process it started,
+// when its context is cancelled
+type ContextFunction func(ctx context.Context, args string) (string, error)
+
 // NewRegistry creates a new function registry
 func NewRegistry() *Registry {
 	return &Registry{
-		functions: make(map[string]Function),
+		functions:        make(map[string]Function),
+		contextFunctions: make(map[string]ContextFunction),
 	}
 }
 
 // Register adds a function to the registry
 func (r *Registry) Register(name string, fn Function) {
 	r.functions[name] = fn
+	delete(r.contextFunctions, name)
+}
+
+// RegisterContext adds a cancellable function to the registry. Get returns
+// it bound to a background context.
+func (r *Registry) RegisterContext(name string, fn ContextFunction) {
+	r.contextFunctions[name] = fn
+	r.functions[name] = func(args string) (string, error) {
+		return fn(context.Background(), args)
+	}
 }
 
 // Get retrieves a function from the registry
@@ -38,11 +63,48 @@ func (r *Registry) Get(name string) Function {
 	return r.functions[name]
 }
 
+// GetContext retrieves a function that can be cancelled through its context.
+// Functions registered without one run to completion regardless of ctx.
+func (r *Registry) GetContext(name string) ContextFunction {
+	if fn, ok := r.contextFunctions[name]; ok {
+		return fn
+	}
+	fn, ok := r.functions[name]
+	if !ok {
+		return nil
+	}
+	return func(ctx context.Context, args string) (string, error) {
+		return fn(args)
+	}
+}
+
+// AtTurnEnd registers fn to release the state tools keep across the calls
+// of one turn, such as chunked writes that were never committed
+func (r *Registry) AtTurnEnd(fn func()) {
+	r.turnEnd = append(r.turnEnd, fn)
+}
+
+// EndTurn runs the functions registered with AtTurnEnd, once the model
+// stopped requesting tools
+func (r *Registry) EndTurn() {
+	for _, fn := range r.turnEnd {
+		fn()
+	}
+}
+
+// Workspace returns the workspace the tools of a registry made by
+// NewToolRegistry resolve paths in, or nil
+func (r *Registry) Workspace() *Workspace {
+	return r.workspace
+}
+
 // ReadFile reads the contents of a file
 func ReadFile(args string) (string, error) {
 	// Parse arguments
 	var params struct {
-		Path string `json:"path"`
+		Path      string `json:"path"`
+		StartLine int    `json:"start_line"` // 1-based, inclusive (0 = from the start)
+		EndLine   int    `json:"end_line"`   // 1-based, inclusive (0 = to the end)
 	}
 	if err := json.Unmarshal([]byte(args), &params); err != nil {
 		return "", fmt.Errorf("failed to parse arguments: %w", err)
@@ -52,6 +114,9 @@ func ReadFile(args string) (string, error) {
 	if params.Path == "" {
 		return "", fmt.Errorf("path parameter is required")
 	}
+	if params.StartLine < 0 || params.EndLine < 0 || (params.EndLine > 0 && params.EndLine < params.StartLine) {
+		return "", fmt.Errorf("invalid line range %d-%d", params.StartLine, params.EndLine)
+	}
 
 	// Resolve the path
 	absPath, err := filepath.Abs(params.Path)
@@ -65,11 +130,40 @@ func ReadFile(args string) (string, error) {
 		return "", fmt.Errorf("failed to read file: %w", err)
 	}
 
-	return string(content), nil
+	if params.StartLine == 0 && params.EndLine == 0 {
+		return string(content), nil
+	}
+
+	// Return only the requested line range
+	lines := strings.SplitAfter(string(content), "\n")
+	start := params.StartLine
+	if start < 1 {
+		start = 1
+	}
+	end := params.EndLine
+	if end == 0 || end > len(lines) {
+		end = len(lines)
+	}
+	if start > end {
+		return "", nil
+	}
+	return strings.Join(lines[start-1:end], ""), nil
 }
 
 // WriteFile writes content to a file
 func WriteFile(args string) (string, error) {
+	return WriteFileWith(nil)(args)
+}
+
+// WriteFileWith returns write_file with the content passed through normalize,
+// if not nil, before it is written
+func WriteFileWith(normalize fileops.Normalizer) Function {
+	return func(args string) (string, error) {
+		return writeFile(args, normalize)
+	}
+}
+
+func writeFile(args string, normalize fileops.Normalizer) (string, error) {
 	// Parse arguments
 	var params struct {
 		Path    string `json:"path"`
@@ -96,16 +190,91 @@ func WriteFile(args string) (string, error) {
 		return "", fmt.Errorf("failed to create directory: %w", err)
 	}
 
+	content, note := normalizeForWrite(normalize, absPath, params.Content)
+
 	// Write the file
-	if err := ioutil.WriteFile(absPath, []byte(params.Content), 0644); err != nil {
+	if err := ioutil.WriteFile(absPath, []byte(content), 0644); err != nil {
 		return "", fmt.Errorf("failed to write file: %w", err)
 	}
 
-	return fmt.Sprintf("Successfully wrote %d bytes to %s", len(params.Content), params.Path), nil
+	return fmt.Sprintf("Successfully wrote %d bytes to %s", len(content), params.Path) + note, nil
+}
+
+// AppendFile appends content to the end of a file without rewriting it,
+// creating the file and its directory if missing
+func AppendFile(args string) (string, error) {
+	return AppendFileWith(nil)(args)
+}
+
+// AppendFileWith returns append_file with the appended content passed through
+// normalize, if not nil, before it is written
+func AppendFileWith(normalize fileops.Normalizer) Function {
+	return func(args string) (string, error) {
+		return appendFile(args, normalize)
+	}
+}
+
+func appendFile(args string, normalize fileops.Normalizer) (string, error) {
+	// Parse arguments
+	var params struct {
+		Path    string `json:"path"`
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal([]byte(args), &params); err != nil {
+		return "", fmt.Errorf("failed to parse arguments: %w", err)
+	}
+
+	// Check if parameters are valid
+	if params.Path == "" {
+		return "", fmt.Errorf("path parameter is required")
+	}
+	if params.Content == "" {
+		return "", fmt.Errorf("content parameter is required")
+	}
+
+	// Resolve the path
+	absPath, err := filepath.Abs(params.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve absolute path: %w", err)
+	}
+
+	// Create the directory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	content, note := normalizeForWrite(normalize, absPath, params.Content)
+
+	// Append to the file
+	f, err := os.OpenFile(absPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	_, err = f.WriteString(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to append to file: %w", err)
+	}
+
+	return fmt.Sprintf("Successfully appended %d bytes to %s", len(content), params.Path) + note, nil
 }
 
 // PatchFile applies a patch to a file
 func PatchFile(args string) (string, error) {
+	return PatchFileWith(nil)(args)
+}
+
+// PatchFileWith returns patch_file with the patched content passed through
+// normalize, if not nil, before it is written
+func PatchFileWith(normalize fileops.Normalizer) Function {
+	return func(args string) (string, error) {
+		return patchFile(args, normalize)
+	}
+}
+
+func patchFile(args string, normalize fileops.Normalizer) (string, error) {
 	// Parse arguments
 	var params struct {
 		Path      string `json:"path"`
@@ -134,6 +303,7 @@ func PatchFile(args string) (string, error) {
 		Content:   params.Content,
 		StartLine: params.StartLine,
 		EndLine:   params.EndLine,
+		Normalize: normalize,
 	}
 
 	// Apply the patch
@@ -142,11 +312,56 @@ func PatchFile(args string) (string, error) {
 		return "", fmt.Errorf("failed to apply patch: %w", err)
 	}
 
-	return fmt.Sprintf("Successfully patched %s (%d -> %d lines)", params.Path, result.OriginalLines, result.NewLines), nil
+	return fmt.Sprintf("Successfully patched %s (%d -> %d lines)", params.Path, result.OriginalLines, result.NewLines) + normalizedNote(result.Normalized, result.NormalizeError), nil
 }
 
 // ExecuteCommand executes a shell command
 func ExecuteCommand(args string) (string, error) {
+	return executeCommand(context.Background(), args, defaultCommandOptions(false))
+}
+
+// ExecuteCommandReadOnly executes a command with the sandbox in read-only mode
+func ExecuteCommandReadOnly(args string) (string, error) {
+	return executeCommand(context.Background(), args, defaultCommandOptions(true))
+}
+
+// ExecuteCommandContext executes a shell command, killing it when ctx is cancelled
+func ExecuteCommandContext(ctx context.Context, args string) (string, error) {
+	return executeCommand(ctx, args, defaultCommandOptions(false))
+}
+
+// ExecuteCommandReadOnlyContext executes a read-only command, killing it when ctx is cancelled
+func ExecuteCommandReadOnlyContext(ctx context.Context, args string) (string, error) {
+	return executeCommand(ctx, args, defaultCommandOptions(true))
+}
+
+// commandOptions are the settings commands of the shell tool run with
+type commandOptions struct {
+	shell    []string          // Program and flags the command is passed to (empty = platform default)
+	readOnly bool              // Refuse commands that write
+	limit    int               // Bytes of each output stream the model sees (0 = all)
+	env      map[string]string // Set for every command; the call's own env overrides it
+}
+
+// defaultCommandOptions returns the settings of commands run without a config
+func defaultCommandOptions(readOnly bool) commandOptions {
+	return commandOptions{readOnly: readOnly, limit: DefaultShellOutputLimit, env: config.DefaultCommandEnv()}
+}
+
+// CommandTool returns the shell tool of cfg: commands run with its shell (the
+// platform default when empty) and command environment, read-only in a
+// read-only session, and with their output truncated unless full_stdout is set
+func CommandTool(cfg *config.Config) ContextFunction {
+	opts := commandOptions{shell: cfg.Shell, readOnly: cfg.ReadOnly, limit: ShellOutputLimit(cfg), env: cfg.CommandEnvironment()}
+	return func(ctx context.Context, args string) (string, error) {
+		return executeCommand(ctx, args, opts)
+	}
+}
+
+// executeCommand executes a command in the sandbox. A command that ran returns
+// its ShellResult whatever its exit code; one that could not run to
+// completion returns an error carrying the result with its exec_error.
+func executeCommand(ctx context.Context, args string, command commandOptions) (string, error) {
 	// Parse arguments
 	var params struct {
 		Command      string            `json:"command"`
@@ -154,6 +369,7 @@ func ExecuteCommand(args string) (string, error) {
 		Env          map[string]string `json:"env"`
 		Timeout      int               `json:"timeout"`
 		AllowNetwork bool              `json:"allowNetwork"`
+		StdinData    string            `json:"stdin_data"`
 	}
 	if err := json.Unmarshal([]byte(args), &params); err != nil {
 		return "", fmt.Errorf("failed to parse arguments: %w", err)
@@ -179,32 +395,177 @@ func ExecuteCommand(args string) (string, error) {
 		timeout = 60 * time.Second // Default timeout: 60 seconds
 	}
 
+	// The command environment keeps output stable; the call may override it
+	env := make(map[string]string, len(command.env)+len(params.Env))
+	maps.Copy(env, command.env)
+	maps.Copy(env, params.Env)
+
 	// Create sandbox options
 	opts := sandbox.SandboxOptions{
 		Command:         params.Command,
+		Shell:           command.shell,
 		WorkingDir:      params.WorkingDir,
 		AllowNetwork:    params.AllowNetwork,
-		AllowFileWrites: true, // Allow writes to the working directory
+		AllowFileWrites: !command.readOnly, // Allow writes to the working directory
+		ReadOnly:        command.readOnly,
 		Timeout:         timeout,
-		Env:             params.Env,
+		Env:             env,
+		OnInput:         sandbox.InputHandlerFrom(ctx),
+	}
+	if params.StdinData != "" {
+		opts.Stdin = strings.NewReader(params.StdinData)
 	}
 
 	// Create a sandbox
 	sb := sandbox.NewSandbox()
 
 	// Execute the command
-	ctx := context.Background()
 	result, err := sb.Execute(ctx, opts)
 	if err != nil {
 		return "", fmt.Errorf("failed to execute command: %w", err)
 	}
 
-	// Check if the command was successful
-	if !result.Success {
-		return "", fmt.Errorf("command failed with exit code %d: %s", result.ExitCode, result.Stderr)
+	// A nonzero exit code is the command's answer; only a command that did not
+	// run to completion fails the call
+	if errors.Is(result.Error, sandbox.ErrWantsInput) {
+		return "", WantsInputError(result.Error)
+	}
+	shellResult := NewShellResult(result, command.limit)
+	if shellResult.ExecError != "" {
+		return "", &ShellError{Result: shellResult}
+	}
+	return shellResult.String(), nil
+}
+
+// ShellError is returned for a command that did not run to completion, with
+// what it printed before it stopped
+type ShellError struct {
+	Result ShellResult
+}
+
+func (e *ShellError) Error() string {
+	return "command did not run to completion: " + e.Result.ExecError
+}
+
+// DefaultShellOutputLimit is how many bytes of each of a command's output
+// streams the model sees
+const DefaultShellOutputLimit = 32 * 1024
+
+// ShellOutputLimit returns the limit of each output stream of commands run
+// under cfg: none with full_stdout
+func ShellOutputLimit(cfg *config.Config) int {
+	if cfg.FullStdout {
+		return 0
 	}
+	return DefaultShellOutputLimit
+}
+
+// ShellResult is what the model sees of a command, keeping error messages
+// apart from normal output. A command that ran has the exit code it exited
+// with; ExecError says why one did not run to completion.
+type ShellResult struct {
+	ExitCode        int    `json:"exit_code"`
+	Stdout          string `json:"stdout"`
+	Stderr          string `json:"stderr"`
+	StdoutTruncated bool   `json:"stdout_truncated,omitempty"`
+	StderrTruncated bool   `json:"stderr_truncated,omitempty"`
+	DurationMs      int64  `json:"duration_ms"`
+	Cwd             string `json:"cwd"`
+	ExecError       string `json:"exec_error,omitempty"` // e.g. command not found, timed out, killed
+}
 
-	return result.Stdout, nil
+// NewShellResult returns the result of a command, with ANSI escape sequences
+// stripped from its output and each output stream truncated to limit bytes on
+// its own (0 = no limit)
+func NewShellResult(result *sandbox.CommandResult, limit int) ShellResult {
+	shellResult := ShellResult{
+		ExitCode:   result.ExitCode,
+		DurationMs: result.Duration.Milliseconds(),
+		Cwd:        result.WorkingDir,
+		ExecError:  shellExecError(result),
+	}
+	shellResult.Stdout, shellResult.StdoutTruncated = truncateStream(StripANSI(result.Stdout), limit)
+	shellResult.Stderr, shellResult.StderrTruncated = truncateStream(StripANSI(result.Stderr), limit)
+	return shellResult
+}
+
+// String returns the JSON tool result
+func (r ShellResult) String() string {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return r.Stdout + r.Stderr
+	}
+	return string(data)
+}
+
+// ParseShellResult decodes the output of a shell tool call, reporting false
+// for output that is not a shell result
+func ParseShellResult(output string) (ShellResult, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(output), &fields); err != nil {
+		return ShellResult{}, false
+	}
+	if _, ok := fields["exit_code"]; !ok {
+		return ShellResult{}, false
+	}
+	var result ShellResult
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		return ShellResult{}, false
+	}
+	return result, true
+}
+
+// shellExecError describes why a command did not run to completion, or is
+// empty for one that exited by itself, whatever its exit code. The shell's
+// codes for a command it could not find or run count as not running.
+func shellExecError(result *sandbox.CommandResult) string {
+	var exitErr *exec.ExitError
+	switch {
+	case result.Error == nil:
+		return ""
+	case errors.As(result.Error, &exitErr) && exitErr.ExitCode() == 127:
+		return "command not found"
+	case errors.As(result.Error, &exitErr) && exitErr.ExitCode() == 126:
+		return "command not executable"
+	case errors.As(result.Error, &exitErr) && exitErr.ExitCode() >= 0:
+		return ""
+	case errors.As(result.Error, &exitErr):
+		return "killed (" + exitErr.Error() + ")"
+	default:
+		return result.Error.Error()
+	}
+}
+
+// ansiEscape matches ANSI escape sequences: CSI sequences such as colors and
+// cursor movement, OSC sequences such as hyperlinks and titles, and the
+// shorter escapes such as character set selection
+var ansiEscape = regexp.MustCompile(`\x1b(\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[ -/]*[0-~])`)
+
+// StripANSI removes ANSI escape sequences from command output, which the
+// model would otherwise read as noise
+func StripANSI(output string) string {
+	if !strings.Contains(output, "\x1b") {
+		return output
+	}
+	return ansiEscape.ReplaceAllString(output, "")
+}
+
+// truncateStream shortens output longer than limit bytes to its beginning and
+// end, which hold the command's progress and its outcome, reporting whether it
+// did (limit 0 keeps everything)
+func truncateStream(output string, limit int) (string, bool) {
+	if limit <= 0 || len(output) <= limit {
+		return output, false
+	}
+	head, tail := output[:limit/2], output[len(output)-limit/2:]
+	omitted := len(output) - len(head) - len(tail)
+	return strings.ToValidUTF8(head, "") + fmt.Sprintf("\n...[%d bytes truncated]...\n", omitted) + strings.ToValidUTF8(tail, ""), true
+}
+
+// WantsInputError is the tool error for a command stopped for waiting on
+// input, telling the model how to run it without interaction
+func WantsInputError(err error) error {
+	return fmt.Errorf("%w. Nobody can answer it here: pass the answers in stdin_data, one per line, or use the command's non-interactive options (e.g. -y, --yes, a passphrase flag)", err)
 }
 
 // ListDirectory lists the contents of a directory
@@ -266,3 +627,69 @@ func ListDirectory(args string) (string, error) {
 
 	return result, nil
 }
+
+// WithEditorConfig builds a tool that writes files with its content
+// normalized to the .editorconfig properties of the target before it is
+// written, telling the model about any change so its picture of the file stays
+// accurate. The tool is built without a normalizer when the config disables
+// normalization.
+func WithEditorConfig(cfg *config.Config, tool func(fileops.Normalizer) Function) Function {
+	if cfg.DisableEditorConfig {
+		return tool(nil)
+	}
+	return tool(editorconfig.NormalizeFor)
+}
+
+// normalizeForWrite passes content about to be written to path through
+// normalize and returns it with the note for the tool result
+func normalizeForWrite(normalize fileops.Normalizer, path, content string) (string, string) {
+	if normalize == nil {
+		return content, ""
+	}
+	normalized, notes, err := normalize(path, []byte(content))
+	if err != nil {
+		return content, normalizedNote(nil, err)
+	}
+	return string(normalized), normalizedNote(notes, nil)
+}
+
+// normalizedNote tells the model how written content was normalized, or why
+// it was not
+func normalizedNote(notes []string, err error) string {
+	if err != nil {
+		return fmt.Sprintf("\nWarning: the file was not normalized to .editorconfig: %v", err)
+	}
+	if len(notes) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\nNormalized to .editorconfig: %s.", strings.Join(notes, ", "))
+}
+
+// WithJournal wraps a function that writes to the "path" argument, or to the
+// paths of its "edits", so the files are recorded in the journal before they
+// are modified
+func WithJournal(journal *fileops.Journal, fn Function) Function {
+	return func(args string) (string, error) {
+		var params struct {
+			Path  string `json:"path"`
+			Edits []struct {
+				Path string `json:"path"`
+			} `json:"edits"`
+		}
+		if err := json.Unmarshal([]byte(args), &params); err == nil {
+			paths := []string{params.Path}
+			for _, edit := range params.Edits {
+				paths = append(paths, edit.Path)
+			}
+			for _, path := range paths {
+				if path == "" {
+					continue
+				}
+				if err := journal.Record(path); err != nil {
+					return "", fmt.Errorf("failed to journal %s: %w", path, err)
+				}
+			}
+		}
+		return fn(args)
+	}
+}
@@ -0,0 +1,192 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/sandbox"
+)
+
+func TestExecuteCommandSeparatesStreams(t *testing.T) {
+	dir := t.TempDir()
+	args, _ := json.Marshal(map[string]string{"command": "echo hi; echo warn >&2", "workingDir": dir})
+	out, err := ExecuteCommandContext(context.Background(), string(args))
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	var result ShellResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("Expected a JSON result, got %q: %v", out, err)
+	}
+	if result.Stdout != "hi\n" || result.Stderr != "warn\n" || result.ExitCode != 0 || result.Cwd != dir {
+		t.Errorf("Expected separate stdout and stderr run in %s, got %+v", dir, result)
+	}
+}
+
+func TestExecuteCommandNonzeroExitSucceeds(t *testing.T) {
+	out, err := ExecuteCommandContext(context.Background(), `{"command":"echo partial; echo boom >&2; exit 3"}`)
+	if err != nil {
+		t.Fatalf("Expected a command that ran to succeed whatever its exit code, got %v", err)
+	}
+	var result ShellResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("Expected a JSON result, got %q: %v", out, err)
+	}
+	if result.ExitCode != 3 || result.Stdout != "partial\n" || result.Stderr != "boom\n" || result.ExecError != "" {
+		t.Errorf("Expected exit code 3 with the output kept, got %+v", result)
+	}
+}
+
+func TestExecuteCommandFlagsExecutionErrors(t *testing.T) {
+	for _, tt := range []struct {
+		args string
+		want string
+	}{
+		{`{"command":"no_such_command_for_codex"}`, "command not found"},
+		{`{"command":"sleep 5","timeout":1}`, "command timed out after 1s"},
+		{`{"command":"kill -9 $$"}`, "killed (signal: killed)"},
+	} {
+		_, err := ExecuteCommandContext(context.Background(), tt.args)
+		var shellErr *ShellError
+		if !errors.As(err, &shellErr) || shellErr.Result.ExecError != tt.want {
+			t.Errorf("%s: expected a shell error %q, got %v", tt.args, tt.want, err)
+		}
+	}
+}
+
+func TestExecuteCommandStableEnvironment(t *testing.T) {
+	out, err := CommandTool(&config.Config{CommandEnv: map[string]string{"no_color": ""}})(context.Background(), `{"command":"echo $LC_ALL $TZ $GIT_PAGER ${NO_COLOR:-unset}; printf '\\033[31mred\\033[0m'"}`)
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	var result ShellResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("Expected a JSON result, got %q: %v", out, err)
+	}
+	if result.Stdout != "C UTC cat unset\nred" {
+		t.Errorf("Expected the command environment without NO_COLOR and no escape sequences, got %q", result.Stdout)
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	colored := "\x1b[1;32mok\x1b[0m \x1b]8;;https://example.com\x07link\x1b]8;;\x07\x1b[2K\x1b(Bdone"
+	if got := StripANSI(colored); got != "ok linkdone" {
+		t.Errorf("Expected the escape sequences stripped, got %q", got)
+	}
+}
+
+func TestShellResultTruncatesStreamsSeparately(t *testing.T) {
+	result := NewShellResult(&sandbox.CommandResult{
+		Stdout:   strings.Repeat("o", 100),
+		Stderr:   "short",
+		Duration: 1500 * time.Millisecond,
+	}, 20)
+	if !result.StdoutTruncated || result.StderrTruncated || result.Stderr != "short" {
+		t.Errorf("Expected only stdout truncated, got %+v", result)
+	}
+	if want := strings.Repeat("o", 10) + "\n...[80 bytes truncated]...\n" + strings.Repeat("o", 10); result.Stdout != want {
+		t.Errorf("Expected the start and end of stdout, got %q", result.Stdout)
+	}
+	if result.DurationMs != 1500 {
+		t.Errorf("Expected the duration in milliseconds, got %d", result.DurationMs)
+	}
+	if whole := NewShellResult(&sandbox.CommandResult{Stdout: strings.Repeat("o", 100)}, 0); whole.StdoutTruncated || len(whole.Stdout) != 100 {
+		t.Errorf("Expected no truncation without a limit, got %+v", whole)
+	}
+}
+
+func TestAppendFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "run.log")
+	var results []string
+	for _, line := range []string{"first\n", "second\n"} {
+		args, _ := json.Marshal(map[string]string{"path": path, "content": line})
+		result, err := AppendFile(string(args))
+		if err != nil {
+			t.Fatalf("append_file failed: %v", err)
+		}
+		results = append(results, result)
+	}
+	if want := "Successfully appended 7 bytes to " + path; results[1] != want {
+		t.Errorf("Expected %q, got %q", want, results[1])
+	}
+	if data, _ := os.ReadFile(path); string(data) != "first\nsecond\n" {
+		t.Errorf("Expected the file created and appended to, got %q", data)
+	}
+
+	if _, err := AppendFile(`{"path":"` + path + `"}`); err == nil {
+		t.Errorf("Expected an error without content")
+	}
+}
+
+func TestWithEditorConfigNormalizesWrites(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".editorconfig"), []byte("root = true\n\n[*]\ntrim_trailing_whitespace = true\ninsert_final_newline = true\n"), 0644); err != nil {
+		t.Fatalf("Failed to write .editorconfig: %v", err)
+	}
+	path := filepath.Join(dir, "notes.txt")
+	args, _ := json.Marshal(map[string]string{"path": path, "content": "one  \ntwo"})
+
+	result, err := WithEditorConfig(&config.Config{}, WriteFileWith)(string(args))
+	if err != nil {
+		t.Fatalf("write_file failed: %v", err)
+	}
+	if !strings.Contains(result, "Normalized to .editorconfig: trimmed trailing whitespace on 1 line, added a final newline.") {
+		t.Errorf("Expected the normalizations in the result, got %q", result)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "one\ntwo\n" {
+		t.Errorf("Expected the normalized content, got %q", data)
+	}
+
+	// Disabled, the content is written as given
+	result, err = WithEditorConfig(&config.Config{DisableEditorConfig: true}, WriteFileWith)(string(args))
+	if err != nil || strings.Contains(result, "Normalized") {
+		t.Fatalf("Expected no normalization, got %q, %v", result, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "one  \ntwo" {
+		t.Errorf("Expected the content as given, got %q", data)
+	}
+}
+
+func TestWithEditorConfigNormalizesPatchesAndChunkedWrites(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".editorconfig"), []byte("root = true\n\n[*]\ntrim_trailing_whitespace = true\n"), 0644); err != nil {
+		t.Fatalf("Failed to write .editorconfig: %v", err)
+	}
+
+	patched := filepath.Join(dir, "patched.txt")
+	os.WriteFile(patched, []byte("one\ntwo\n"), 0644)
+	args, _ := json.Marshal(map[string]interface{}{"path": patched, "type": "replace", "startLine": 2, "endLine": 2, "content": "deux  "})
+	result, err := WithEditorConfig(&config.Config{}, PatchFileWith)(string(args))
+	if err != nil {
+		t.Fatalf("patch_file failed: %v", err)
+	}
+	if !strings.Contains(result, "Normalized to .editorconfig: trimmed trailing whitespace on 1 line.") {
+		t.Errorf("Expected the normalization in the result, got %q", result)
+	}
+	if data, _ := os.ReadFile(patched); strings.Contains(string(data), "deux ") {
+		t.Errorf("Expected the patched line trimmed, got %q", data)
+	}
+
+	staged := filepath.Join(dir, "staged.txt")
+	w := NewChunkedWriter()
+	commit := WithEditorConfig(&config.Config{}, w.CommitWriteWith)
+	w.BeginWrite(mustArgs(t, map[string]interface{}{"path": staged}))
+	w.AppendChunk(mustArgs(t, map[string]interface{}{"path": staged, "index": 0, "content": "chunk  \n"}))
+	result, err = commit(mustArgs(t, map[string]interface{}{"path": staged}))
+	if err != nil {
+		t.Fatalf("commit_write failed: %v", err)
+	}
+	if !strings.Contains(result, "Normalized to .editorconfig") {
+		t.Errorf("Expected the normalization in the result, got %q", result)
+	}
+	if data, _ := os.ReadFile(staged); string(data) != "chunk\n" {
+		t.Errorf("Expected the committed content trimmed, got %q", data)
+	}
+}
@@ -0,0 +1,408 @@
+package functions
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/fileops"
+)
+
+// maxParseErrors caps how many parse errors are reported for one input
+const maxParseErrors = 10
+
+// EditSymbol applies a structured edit to a Go file, addressing code by symbol
+// instead of by line so the edit still applies after unrelated changes to the
+// file. The result is gofmt-formatted and the tool returns its diff.
+func EditSymbol(args string) (string, error) {
+	var params struct {
+		Path       string `json:"path"`
+		Operation  string `json:"operation"`
+		Function   string `json:"function"` // replace_function_body, or the scope of rename_identifier
+		Body       string `json:"body"`
+		Receiver   string `json:"receiver"` // add_method
+		Source     string `json:"source"`
+		ImportPath string `json:"import_path"` // add_import
+		ImportName string `json:"import_name"`
+		OldName    string `json:"old_name"` // rename_identifier
+		NewName    string `json:"new_name"`
+		Scope      string `json:"scope"`
+	}
+	if err := json.Unmarshal([]byte(args), &params); err != nil {
+		return "", fmt.Errorf("failed to parse arguments: %w", err)
+	}
+	if params.Path == "" {
+		return "", fmt.Errorf("path parameter is required")
+	}
+	if filepath.Ext(params.Path) != ".go" {
+		return "", fmt.Errorf("edit_symbol only edits Go files; use patch_file for %s", params.Path)
+	}
+
+	info, err := os.Stat(params.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+	original, err := os.ReadFile(params.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, params.Path, original, parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s; fix these errors with patch_file first:\n%s", params.Path, describeParseError(err, params.Path, 0))
+	}
+	e := &goFileEdit{fset: fset, file: file, src: original}
+
+	var edited []byte
+	var summary string
+	switch params.Operation {
+	case "replace_function_body":
+		edited, summary, err = e.replaceFunctionBody(params.Function, params.Body)
+	case "add_method":
+		edited, summary, err = e.addMethod(params.Receiver, params.Source)
+	case "add_import":
+		edited, summary, err = e.addImport(params.ImportPath, params.ImportName)
+	case "rename_identifier":
+		scope := params.Scope
+		if scope == "" {
+			scope = params.Function
+		}
+		edited, summary, err = e.renameIdentifier(params.OldName, params.NewName, scope)
+	default:
+		return "", fmt.Errorf("unknown operation %q (expected replace_function_body, add_method, add_import or rename_identifier)", params.Operation)
+	}
+	if err != nil {
+		return "", err
+	}
+	if edited == nil {
+		return fmt.Sprintf("No changes: %s.", summary), nil
+	}
+
+	formatted, err := format.Source(edited)
+	if err != nil {
+		return "", fmt.Errorf("the edit produced invalid Go:\n%s", describeParseError(err, params.Path, 0))
+	}
+	if bytes.Equal(formatted, original) {
+		return fmt.Sprintf("No changes: %s.", summary), nil
+	}
+	if err := os.WriteFile(params.Path, formatted, info.Mode().Perm()); err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return fmt.Sprintf("%s in %s.\n\n%s", summary, params.Path, fileops.UnifiedDiff(params.Path, string(original), string(formatted))), nil
+}
+
+// goFileEdit is a parsed Go file being edited. Edits splice text into src so
+// comments and layout outside the edited code are kept as written.
+type goFileEdit struct {
+	fset *token.FileSet
+	file *ast.File
+	src  []byte
+}
+
+// offset returns the byte offset of pos in src
+func (e *goFileEdit) offset(pos token.Pos) int {
+	return e.fset.Position(pos).Offset
+}
+
+// splice returns src with the bytes between start and end replaced by text
+func (e *goFileEdit) splice(start, end int, text string) []byte {
+	var buf bytes.Buffer
+	buf.Write(e.src[:start])
+	buf.WriteString(text)
+	buf.Write(e.src[end:])
+	return buf.Bytes()
+}
+
+// replaceFunctionBody replaces the statements of a function or method
+func (e *goFileEdit) replaceFunctionBody(name, body string) ([]byte, string, error) {
+	if name == "" {
+		return nil, "", fmt.Errorf("function parameter is required")
+	}
+	decl, err := e.findFunc(name)
+	if err != nil {
+		return nil, "", err
+	}
+	if decl.Body == nil {
+		return nil, "", fmt.Errorf("%s has no body to replace", name)
+	}
+
+	body = strings.Trim(body, "\n")
+	snippet := "package p\nfunc _() {\n" + body + "\n}\n"
+	if _, err := parser.ParseFile(token.NewFileSet(), "", snippet, 0); err != nil {
+		return nil, "", fmt.Errorf("invalid body (give the statements without the enclosing braces):\n%s", describeParseError(err, "body", 2))
+	}
+
+	start := e.offset(decl.Body.Lbrace) + 1
+	end := e.offset(decl.Body.Rbrace)
+	return e.splice(start, end, "\n"+body+"\n"), fmt.Sprintf("Replaced the body of %s", name), nil
+}
+
+// addMethod inserts a method after the receiver's type or its last method
+func (e *goFileEdit) addMethod(receiver, source string) ([]byte, string, error) {
+	receiver = strings.TrimPrefix(strings.TrimSpace(receiver), "*")
+	if receiver == "" || strings.TrimSpace(source) == "" {
+		return nil, "", fmt.Errorf("receiver and source parameters are required")
+	}
+
+	source = strings.Trim(source, "\n")
+	snippet, err := parser.ParseFile(token.NewFileSet(), "", "package p\n"+source+"\n", parser.ParseComments)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid source:\n%s", describeParseError(err, "source", 1))
+	}
+	if len(snippet.Decls) != 1 {
+		return nil, "", fmt.Errorf("source must contain exactly one method declaration, found %d declarations", len(snippet.Decls))
+	}
+	method, ok := snippet.Decls[0].(*ast.FuncDecl)
+	if !ok || method.Recv == nil {
+		return nil, "", fmt.Errorf("source must be a method declaration with a receiver")
+	}
+	if got := receiverTypeName(method); got != receiver {
+		return nil, "", fmt.Errorf("source declares a method on %s, not %s", got, receiver)
+	}
+
+	insertAt := len(e.src)
+	for _, decl := range e.file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil || receiverTypeName(d) != receiver {
+				continue
+			}
+			if d.Name.Name == method.Name.Name {
+				return nil, "", fmt.Errorf("%s.%s already exists; use replace_function_body to change it", receiver, d.Name.Name)
+			}
+			insertAt = e.offset(d.End())
+		case *ast.GenDecl:
+			if d.Tok == token.TYPE && declaresType(d, receiver) && insertAt == len(e.src) {
+				insertAt = e.offset(d.End())
+			}
+		}
+	}
+
+	return e.splice(insertAt, insertAt, "\n\n"+source+"\n"), fmt.Sprintf("Added method %s.%s", receiver, method.Name.Name), nil
+}
+
+// addImport adds an import, creating an import block if needed
+func (e *goFileEdit) addImport(importPath, name string) ([]byte, string, error) {
+	if importPath == "" {
+		return nil, "", fmt.Errorf("import_path parameter is required")
+	}
+	for _, spec := range e.file.Imports {
+		existing, _ := strconv.Unquote(spec.Path.Value)
+		if existing != importPath {
+			continue
+		}
+		if (spec.Name == nil && name == "") || (spec.Name != nil && spec.Name.Name == name) {
+			return nil, fmt.Sprintf("%s is already imported", importPath), nil
+		}
+	}
+
+	spec := strconv.Quote(importPath)
+	if name != "" {
+		spec = name + " " + spec
+	}
+	summary := fmt.Sprintf("Added import %s", spec)
+
+	for _, decl := range e.file.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok || d.Tok != token.IMPORT {
+			continue
+		}
+		if d.Lparen.IsValid() {
+			at := e.offset(d.Rparen)
+			line := "\t" + spec + "\n"
+			if at > 0 && e.src[at-1] != '\n' {
+				line = "\n" + line
+			}
+			return e.splice(at, at, line), summary, nil
+		}
+		// Turn a single-line import into a block
+		existing := string(e.src[e.offset(d.Specs[0].Pos()):e.offset(d.Specs[0].End())])
+		block := "import (\n\t" + existing + "\n\t" + spec + "\n)"
+		return e.splice(e.offset(d.Pos()), e.offset(d.End()), block), summary, nil
+	}
+
+	at := e.offset(e.file.Name.End())
+	return e.splice(at, at, "\n\nimport "+spec+"\n"), summary, nil
+}
+
+// renameIdentifier renames every use of an identifier in the file, or in one
+// function when scope names it. References in other files are not updated.
+func (e *goFileEdit) renameIdentifier(oldName, newName, scope string) ([]byte, string, error) {
+	if !token.IsIdentifier(oldName) || !token.IsIdentifier(newName) {
+		return nil, "", fmt.Errorf("old_name and new_name must be valid Go identifiers")
+	}
+
+	imports := e.importNames()
+	if imports[oldName] {
+		return nil, "", fmt.Errorf("%s is an imported package name; use add_import with import_name to rename an import", oldName)
+	}
+
+	var root ast.Node = e.file
+	where := "the file"
+	if scope != "" && scope != "file" {
+		decl, err := e.findFunc(scope)
+		if err != nil {
+			return nil, "", err
+		}
+		root = decl
+		where = scope
+	}
+
+	// Selections from imported packages (strings.Index) name another package's symbols
+	skip := map[*ast.Ident]bool{e.file.Name: true}
+	var offsets []int
+	conflict := false
+	ast.Inspect(root, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			if x, ok := n.X.(*ast.Ident); ok && imports[x.Name] {
+				skip[n.Sel] = true
+			}
+		case *ast.Ident:
+			if skip[n] {
+				return true
+			}
+			switch n.Name {
+			case oldName:
+				offsets = append(offsets, e.offset(n.Pos()))
+			case newName:
+				conflict = true
+			}
+		}
+		return true
+	})
+
+	if len(offsets) == 0 {
+		return nil, "", fmt.Errorf("no identifier named %s in %s", oldName, where)
+	}
+	if conflict {
+		return nil, "", fmt.Errorf("%s is already used in %s; choose another name", newName, where)
+	}
+
+	sort.Ints(offsets)
+	var buf bytes.Buffer
+	last := 0
+	for _, at := range offsets {
+		buf.Write(e.src[last:at])
+		buf.WriteString(newName)
+		last = at + len(oldName)
+	}
+	buf.Write(e.src[last:])
+	return buf.Bytes(), fmt.Sprintf("Renamed %d occurrences of %s to %s in %s", len(offsets), oldName, newName, where), nil
+}
+
+// findFunc finds a function by name, or a method by Type.Method
+func (e *goFileEdit) findFunc(name string) (*ast.FuncDecl, error) {
+	receiver, method, isMethod := strings.Cut(name, ".")
+	if !isMethod {
+		method = name
+	}
+	receiver = strings.Trim(receiver, "(*)")
+
+	var matches []*ast.FuncDecl
+	for _, decl := range e.file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != method {
+			continue
+		}
+		if isMethod && (fn.Recv == nil || receiverTypeName(fn) != receiver) {
+			continue
+		}
+		if !isMethod && fn.Recv == nil {
+			return fn, nil
+		}
+		matches = append(matches, fn)
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no function named %s in the file", name)
+	case 1:
+		return matches[0], nil
+	}
+	var candidates []string
+	for _, fn := range matches {
+		candidates = append(candidates, receiverTypeName(fn)+"."+method)
+	}
+	return nil, fmt.Errorf("%s is ambiguous; use one of %s", name, strings.Join(candidates, ", "))
+}
+
+// importNames returns the names the file's imports are referred to by
+func (e *goFileEdit) importNames() map[string]bool {
+	names := make(map[string]bool)
+	for _, spec := range e.file.Imports {
+		if spec.Name != nil {
+			names[spec.Name.Name] = true
+			continue
+		}
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		names[path.Base(importPath)] = true
+	}
+	return names
+}
+
+// receiverTypeName returns the type name of a method's receiver
+func receiverTypeName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return ""
+	}
+	expr := fn.Recv.List[0].Type
+	for {
+		switch t := expr.(type) {
+		case *ast.StarExpr:
+			expr = t.X
+		case *ast.ParenExpr:
+			expr = t.X
+		case *ast.IndexExpr:
+			expr = t.X
+		case *ast.IndexListExpr:
+			expr = t.X
+		case *ast.Ident:
+			return t.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// declaresType reports whether a type declaration declares name
+func declaresType(decl *ast.GenDecl, name string) bool {
+	for _, spec := range decl.Specs {
+		if ts, ok := spec.(*ast.TypeSpec); ok && ts.Name.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// describeParseError lists parse errors as label:line:column: message, shifting
+// lines by lineOffset so positions refer to the model's own input
+func describeParseError(err error, label string, lineOffset int) string {
+	var list scanner.ErrorList
+	if !errors.As(err, &list) {
+		return err.Error()
+	}
+	var b strings.Builder
+	for i, e := range list {
+		if i == maxParseErrors {
+			fmt.Fprintf(&b, "... and %d more\n", len(list)-i)
+			break
+		}
+		fmt.Fprintf(&b, "%s:%d:%d: %s\n", label, e.Pos.Line-lineOffset, e.Pos.Column, e.Msg)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
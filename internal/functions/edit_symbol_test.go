@@ -0,0 +1,135 @@
+package functions
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const editSymbolSource = `package shapes
+
+import "fmt"
+
+// Square is a square
+type Square struct {
+	side int
+}
+
+// Area returns the area
+func (s *Square) Area() int {
+	return s.side * s.side
+}
+
+func describe(s *Square) string {
+	side := s.side
+	return fmt.Sprintf("square %d", side)
+}
+`
+
+// editSymbol writes source to a temporary Go file and runs EditSymbol on it
+func editSymbol(t *testing.T, source string, params map[string]string) (string, string, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "shapes.go")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	params["path"] = path
+	args, _ := json.Marshal(params)
+	result, err := EditSymbol(string(args))
+	content, _ := os.ReadFile(path)
+	return result, string(content), err
+}
+
+func TestEditSymbolReplaceFunctionBody(t *testing.T) {
+	result, content, err := editSymbol(t, editSymbolSource, map[string]string{
+		"operation": "replace_function_body",
+		"function":  "Square.Area",
+		"body":      "if s == nil {\nreturn 0\n}\nreturn s.side*s.side",
+	})
+	if err != nil {
+		t.Fatalf("EditSymbol failed: %v", err)
+	}
+	if !strings.Contains(content, "\tif s == nil {\n\t\treturn 0\n\t}\n\treturn s.side * s.side\n}") {
+		t.Errorf("Expected a gofmt-clean body, got:\n%s", content)
+	}
+	if !strings.Contains(result, "+\tif s == nil {") {
+		t.Errorf("Expected the result to include the diff, got:\n%s", result)
+	}
+}
+
+func TestEditSymbolAddMethodAndImport(t *testing.T) {
+	_, content, err := editSymbol(t, editSymbolSource, map[string]string{
+		"operation": "add_method",
+		"receiver":  "*Square",
+		"source":    "// Perimeter returns the perimeter\nfunc (s *Square) Perimeter() int { return 4*s.side }",
+	})
+	if err != nil {
+		t.Fatalf("EditSymbol failed: %v", err)
+	}
+	area := strings.Index(content, "func (s *Square) Area()")
+	perimeter := strings.Index(content, "// Perimeter returns the perimeter\nfunc (s *Square) Perimeter() int { return 4 * s.side }")
+	if perimeter < area || area < 0 || perimeter > strings.Index(content, "func describe") {
+		t.Errorf("Expected the method after the receiver's last method, got:\n%s", content)
+	}
+
+	_, content, err = editSymbol(t, content, map[string]string{"operation": "add_import", "import_path": "strings"})
+	if err != nil {
+		t.Fatalf("EditSymbol failed: %v", err)
+	}
+	if !strings.Contains(content, "import (\n\t\"fmt\"\n\t\"strings\"\n)") {
+		t.Errorf("Expected an import block, got:\n%s", content)
+	}
+
+	result, _, err := editSymbol(t, content, map[string]string{"operation": "add_import", "import_path": "fmt"})
+	if err != nil || !strings.HasPrefix(result, "No changes") {
+		t.Errorf("Expected an existing import to be left alone, got %q (err %v)", result, err)
+	}
+}
+
+func TestEditSymbolRenameIdentifier(t *testing.T) {
+	_, content, err := editSymbol(t, editSymbolSource, map[string]string{
+		"operation": "rename_identifier",
+		"old_name":  "side",
+		"new_name":  "length",
+		"scope":     "describe",
+	})
+	if err != nil {
+		t.Fatalf("EditSymbol failed: %v", err)
+	}
+	if !strings.Contains(content, "length := s.length") || !strings.Contains(content, "return s.side * s.side") {
+		t.Errorf("Expected the rename limited to describe, got:\n%s", content)
+	}
+
+	_, _, err = editSymbol(t, editSymbolSource, map[string]string{
+		"operation": "rename_identifier",
+		"old_name":  "side",
+		"new_name":  "s",
+	})
+	if err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Errorf("Expected a conflicting name to be refused, got %v", err)
+	}
+}
+
+func TestEditSymbolReportsParseErrors(t *testing.T) {
+	_, content, err := editSymbol(t, editSymbolSource, map[string]string{
+		"operation": "replace_function_body",
+		"function":  "describe",
+		"body":      "x := 1\nreturn fmt.Sprintf(\"%d\", x",
+	})
+	if err == nil || !strings.Contains(err.Error(), "body:2:") {
+		t.Errorf("Expected a snippet error with its position, got %v", err)
+	}
+	if content != editSymbolSource {
+		t.Errorf("Expected the file to be unchanged after an error")
+	}
+
+	_, _, err = editSymbol(t, "package shapes\n\nfunc broken( {\n}\n", map[string]string{
+		"operation":   "add_import",
+		"import_path": "fmt",
+	})
+	if err == nil || !strings.Contains(err.Error(), "shapes.go:3:") {
+		t.Errorf("Expected the file's parse error with its position, got %v", err)
+	}
+}
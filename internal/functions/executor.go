@@ -0,0 +1,330 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCancelledByUser is the error of a call stopped with CancelCall
+var ErrCancelledByUser = errors.New("cancelled by user")
+
+// callIDKey is the context key of the tool call a Run is for
+type callIDKey struct{}
+
+// WithCallID tags ctx with the ID of the tool call run with it, so the call
+// can be listed by Calls and stopped by CancelCall
+func WithCallID(ctx context.Context, callID string) context.Context {
+	return context.WithValue(ctx, callIDKey{}, callID)
+}
+
+// CallStatus describes a tool call waiting for or holding a slot
+type CallStatus struct {
+	CallID  string    `json:"call_id"`
+	Tool    string    `json:"tool"`
+	Running bool      `json:"running"` // False while the call is queued
+	Since   time.Time `json:"since"`   // When the call was queued
+}
+
+// QueueStatus reports where a tool call is in the executor queue
+type QueueStatus struct {
+	Tool     string `json:"tool"`
+	Position int    `json:"position"` // 1-based place among queued calls; 0 once the call runs
+	Queued   int    `json:"queued"`   // Calls waiting for a slot, across all tools
+	Running  int    `json:"running"`  // Calls holding a slot
+}
+
+// Executor runs tool calls under a global concurrency limit and per-tool
+// limits, so a burst of calls cannot spawn unbounded processes. Waiting calls
+// are started first come, first served: the oldest queued call whose tool has
+// a free slot starts whenever a slot is released. A limit of 0 or less is unlimited.
+type Executor struct {
+	mu            sync.Mutex
+	maxConcurrent int
+	limits        map[string]int
+	running       map[string]int // Tool -> calls holding a slot
+	total         int
+	queue         []*ticket
+	active        map[*ticket]bool // Calls holding a slot
+}
+
+// ticket is one call waiting for or holding a slot
+type ticket struct {
+	tool     string // Tool the limits apply to
+	name     string // Tool as called
+	callID   string // Tool call the ticket is for, "" when the caller did not say
+	since    time.Time
+	ready    chan struct{} // Closed when the call gets a slot
+	started  bool
+	position int  // Last reported queue position
+	reported bool // Whether the start was reported
+	status   func(QueueStatus)
+	cancel   context.CancelCauseFunc
+}
+
+// NewExecutor creates an executor with the given global and per-tool limits
+func NewExecutor(maxConcurrent int, limits map[string]int) *Executor {
+	e := &Executor{
+		maxConcurrent: maxConcurrent,
+		limits:        make(map[string]int),
+		running:       make(map[string]int),
+		active:        make(map[*ticket]bool),
+	}
+	for tool, limit := range limits {
+		e.limits[canonicalTool(tool)] = limit
+	}
+	return e
+}
+
+// canonicalTool maps tool aliases to the name their limit is configured under
+func canonicalTool(name string) string {
+	if name == "execute_command" {
+		return "shell"
+	}
+	return name
+}
+
+// Run waits for a slot for tool, then calls fn. Cancelling ctx removes a
+// waiting call from the queue, or cancels the context fn runs with, which
+// kills a running shell command. status, if not nil, is called with the call's
+// queue position whenever it changes and once more when the call starts; it
+// may be called from another goroutine and must not block. A ctx tagged with
+// WithCallID lets CancelCall stop this call alone.
+func (e *Executor) Run(ctx context.Context, tool string, fn ContextFunction, args string, status func(QueueStatus)) (string, error) {
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	callID, _ := ctx.Value(callIDKey{}).(string)
+	t := &ticket{
+		tool:   canonicalTool(tool),
+		name:   tool,
+		callID: callID,
+		since:  time.Now(),
+		ready:  make(chan struct{}),
+		status: status,
+		cancel: cancel,
+	}
+
+	e.mu.Lock()
+	e.queue = append(e.queue, t)
+	updates := e.dispatch()
+	e.mu.Unlock()
+	notify(updates)
+
+	select {
+	case <-t.ready:
+	case <-runCtx.Done():
+		e.mu.Lock()
+		if !t.started {
+			e.removeQueued(t)
+			updates := e.dispatch()
+			e.mu.Unlock()
+			notify(updates)
+			return "", fmt.Errorf("tool call '%s' cancelled while queued: %w", tool, context.Cause(runCtx))
+		}
+		e.mu.Unlock()
+	}
+	defer e.release(t)
+
+	if err := runCtx.Err(); err != nil {
+		return "", fmt.Errorf("tool call '%s' cancelled: %w", tool, context.Cause(runCtx))
+	}
+	result, err := fn(runCtx, args)
+	if cause := context.Cause(runCtx); errors.Is(cause, ErrCancelledByUser) {
+		// Whatever the tool made of being killed, report why it stopped
+		return result, fmt.Errorf("tool call '%s' %w", tool, cause)
+	}
+	return result, err
+}
+
+// Cancel removes every queued call and cancels every running one
+func (e *Executor) Cancel() {
+	e.mu.Lock()
+	// Dequeue first, so a released slot cannot start a call being cancelled
+	tickets := e.queue
+	e.queue = nil
+	for t := range e.active {
+		tickets = append(tickets, t)
+	}
+	e.mu.Unlock()
+
+	for _, t := range tickets {
+		t.cancel(nil)
+	}
+}
+
+// CancelCall stops the call run with WithCallID(callID): a queued call is
+// removed, a running one has its context cancelled. Its Run returns an error
+// wrapping ErrCancelledByUser. Other calls are left alone. It reports whether
+// the call was found.
+func (e *Executor) CancelCall(callID string) bool {
+	if callID == "" {
+		return false
+	}
+	e.mu.Lock()
+	var found *ticket
+	for _, t := range e.queue {
+		if t.callID == callID {
+			found = t
+		}
+	}
+	for t := range e.active {
+		if t.callID == callID {
+			found = t
+		}
+	}
+	e.mu.Unlock()
+
+	if found == nil {
+		return false
+	}
+	found.cancel(ErrCancelledByUser)
+	return true
+}
+
+// Calls returns the calls holding a slot and then the queued ones, each group
+// oldest first
+func (e *Executor) Calls() []CallStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	calls := make([]CallStatus, 0, len(e.active)+len(e.queue))
+	for t := range e.active {
+		calls = append(calls, CallStatus{CallID: t.callID, Tool: t.name, Running: true, Since: t.since})
+	}
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Since.Before(calls[j].Since) })
+	for _, t := range e.queue {
+		calls = append(calls, CallStatus{CallID: t.callID, Tool: t.name, Since: t.since})
+	}
+	return calls
+}
+
+// SetMaxConcurrent changes the global limit; raising it starts queued calls
+func (e *Executor) SetMaxConcurrent(limit int) {
+	e.mu.Lock()
+	e.maxConcurrent = limit
+	updates := e.dispatch()
+	e.mu.Unlock()
+	notify(updates)
+}
+
+// SetLimit changes the limit of one tool; raising it starts queued calls
+func (e *Executor) SetLimit(tool string, limit int) {
+	e.mu.Lock()
+	e.limits[canonicalTool(tool)] = limit
+	updates := e.dispatch()
+	e.mu.Unlock()
+	notify(updates)
+}
+
+// Limits returns the global limit and the per-tool limits
+func (e *Executor) Limits() (int, map[string]int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	limits := make(map[string]int, len(e.limits))
+	for tool, limit := range e.limits {
+		limits[tool] = limit
+	}
+	return e.maxConcurrent, limits
+}
+
+// Describe summarizes the limits, e.g. for a /set without arguments
+func (e *Executor) Describe() string {
+	maxConcurrent, limits := e.Limits()
+	tools := make([]string, 0, len(limits))
+	for tool := range limits {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+
+	text := fmt.Sprintf("max_concurrent_tools: %s", describeLimit(maxConcurrent))
+	for _, tool := range tools {
+		text += fmt.Sprintf("\ntool_concurrency.%s: %s", tool, describeLimit(limits[tool]))
+	}
+	return text
+}
+
+func describeLimit(limit int) string {
+	if limit <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(limit)
+}
+
+// release frees the slot held by t and starts the calls it was holding up
+func (e *Executor) release(t *ticket) {
+	e.mu.Lock()
+	delete(e.active, t)
+	e.running[t.tool]--
+	e.total--
+	updates := e.dispatch()
+	e.mu.Unlock()
+	notify(updates)
+}
+
+// removeQueued drops a waiting call. The caller must hold e.mu.
+func (e *Executor) removeQueued(t *ticket) {
+	for i, queued := range e.queue {
+		if queued == t {
+			e.queue = append(e.queue[:i], e.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// statusUpdate is a status callback to make once e.mu is released
+type statusUpdate struct {
+	status func(QueueStatus)
+	value  QueueStatus
+}
+
+// dispatch starts every queued call that fits, oldest first, and returns the
+// status updates for calls that started or moved up. The caller must hold e.mu.
+func (e *Executor) dispatch() []statusUpdate {
+	var updates []statusUpdate
+	waiting := e.queue[:0]
+	for _, t := range e.queue {
+		if e.hasSlot(t.tool) {
+			t.started = true
+			e.active[t] = true
+			e.running[t.tool]++
+			e.total++
+			close(t.ready)
+			continue
+		}
+		waiting = append(waiting, t)
+	}
+	e.queue = waiting
+
+	for t := range e.active {
+		if t.reported || t.status == nil {
+			continue
+		}
+		t.reported = true
+		updates = append(updates, statusUpdate{t.status, QueueStatus{Tool: t.tool, Queued: len(e.queue), Running: e.total}})
+	}
+	for i, t := range e.queue {
+		if t.position == i+1 || t.status == nil {
+			continue
+		}
+		t.position = i + 1
+		updates = append(updates, statusUpdate{t.status, QueueStatus{Tool: t.tool, Position: i + 1, Queued: len(e.queue), Running: e.total}})
+	}
+	return updates
+}
+
+// hasSlot reports whether a call to tool can start now. The caller must hold e.mu.
+func (e *Executor) hasSlot(tool string) bool {
+	if e.maxConcurrent > 0 && e.total >= e.maxConcurrent {
+		return false
+	}
+	limit := e.limits[tool]
+	return limit <= 0 || e.running[tool] < limit
+}
+
+func notify(updates []statusUpdate) {
+	for _, u := range updates {
+		u.status(u.value)
+	}
+}
@@ -0,0 +1,243 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingTool returns a tool that signals started and then waits for release or cancellation
+func blockingTool(started chan<- string, release <-chan struct{}) ContextFunction {
+	return func(ctx context.Context, args string) (string, error) {
+		started <- args
+		select {
+		case <-release:
+			return args, nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// statusRecorder collects the queue statuses reported for one call
+type statusRecorder struct {
+	mu       sync.Mutex
+	statuses []QueueStatus
+}
+
+func (r *statusRecorder) record(status QueueStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses = append(r.statuses, status)
+}
+
+func (r *statusRecorder) all() []QueueStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]QueueStatus(nil), r.statuses...)
+}
+
+// waitQueued waits until the executor has n queued calls
+func waitQueued(t *testing.T, e *Executor, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		e.mu.Lock()
+		queued := len(e.queue)
+		e.mu.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Expected %d queued calls", n)
+}
+
+func TestExecutorQueuesPerToolInOrder(t *testing.T) {
+	e := NewExecutor(4, map[string]int{"shell": 1})
+	started := make(chan string, 4)
+	release := make(chan struct{})
+	fn := blockingTool(started, release)
+
+	results := make(chan string, 3)
+	recorders := []*statusRecorder{{}, {}, {}}
+	for i, args := range []string{"first", "second", "third"} {
+		go func(args string, recorder *statusRecorder) {
+			result, _ := e.Run(context.Background(), "shell", fn, args, recorder.record)
+			results <- result
+		}(args, recorders[i])
+		if i == 0 {
+			<-started
+		} else {
+			waitQueued(t, e, i)
+		}
+	}
+
+	// execute_command shares the shell limit
+	go e.Run(context.Background(), "execute_command", fn, "alias", nil)
+	waitQueued(t, e, 3)
+
+	// Other tools are not held up by the shell queue
+	readRelease := make(chan struct{})
+	defer close(readRelease)
+	go e.Run(context.Background(), "read_file", blockingTool(started, readRelease), "read", nil)
+	if got := <-started; got != "read" {
+		t.Fatalf("Expected read_file to start at once, got %q", got)
+	}
+
+	if statuses := recorders[2].all(); len(statuses) != 1 || statuses[0].Position != 2 || statuses[0].Tool != "shell" {
+		t.Errorf("Expected the third call to be reported at position 2, got %+v", statuses)
+	}
+
+	for _, want := range []string{"second", "third", "alias"} {
+		release <- struct{}{}
+		if got := <-started; got != want {
+			t.Fatalf("Expected %q to start next, got %q", want, got)
+		}
+	}
+
+	statuses := recorders[2].all()
+	positions := make([]int, len(statuses))
+	for i, status := range statuses {
+		positions[i] = status.Position
+	}
+	if len(positions) != 3 || positions[0] != 2 || positions[1] != 1 || positions[2] != 0 {
+		t.Errorf("Expected positions 2, 1, then a start, got %v", positions)
+	}
+	close(release)
+}
+
+func TestExecutorGlobalLimit(t *testing.T) {
+	e := NewExecutor(1, nil)
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	fn := blockingTool(started, release)
+
+	go e.Run(context.Background(), "read_file", fn, "a", nil)
+	<-started
+	go e.Run(context.Background(), "list_directory", fn, "b", nil)
+	waitQueued(t, e, 1)
+
+	// Raising the limit at runtime starts the waiting call
+	e.SetMaxConcurrent(2)
+	if got := <-started; got != "b" {
+		t.Errorf("Expected the queued call to start, got %q", got)
+	}
+	close(release)
+}
+
+func TestExecutorCancelQueuedCall(t *testing.T) {
+	e := NewExecutor(0, map[string]int{"shell": 1})
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	fn := blockingTool(started, release)
+
+	go e.Run(context.Background(), "shell", fn, "running", nil)
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := e.Run(ctx, "shell", fn, "queued", nil)
+		errs <- err
+	}()
+	waitQueued(t, e, 1)
+	cancel()
+
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancellation error, got %v", err)
+	}
+	waitQueued(t, e, 0)
+	close(release)
+}
+
+func TestExecutorCancelStopsEverything(t *testing.T) {
+	e := NewExecutor(0, map[string]int{"shell": 1})
+	started := make(chan string, 2)
+	fn := blockingTool(started, make(chan struct{}))
+
+	errs := make(chan error, 2)
+	for _, args := range []string{"running", "queued"} {
+		go func(args string) {
+			_, err := e.Run(context.Background(), "shell", fn, args, nil)
+			errs <- err
+		}(args)
+		if args == "running" {
+			<-started
+		}
+	}
+	waitQueued(t, e, 1)
+
+	e.Cancel()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected a cancellation error, got %v", err)
+		}
+	}
+	select {
+	case got := <-started:
+		t.Errorf("Expected the queued call never to start, got %q", got)
+	default:
+	}
+
+	// The executor stays usable
+	result, err := e.Run(context.Background(), "shell", func(ctx context.Context, args string) (string, error) {
+		return "ok", nil
+	}, "", nil)
+	if err != nil || result != "ok" {
+		t.Errorf("Expected the executor to run new calls, got %q, %v", result, err)
+	}
+}
+
+func TestExecutorCancelCallStopsOnlyThatCall(t *testing.T) {
+	e := NewExecutor(0, nil)
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	fn := blockingTool(started, release)
+
+	type outcome struct {
+		result string
+		err    error
+	}
+	results := make(map[string]chan outcome)
+	for _, id := range []string{"call_runaway", "call_read"} {
+		done := make(chan outcome, 1)
+		results[id] = done
+		go func(id string) {
+			result, err := e.Run(WithCallID(context.Background(), id), "shell", fn, id, nil)
+			done <- outcome{result, err}
+		}(id)
+		<-started
+	}
+	if calls := e.Calls(); len(calls) != 2 || !calls[0].Running || calls[0].CallID != "call_runaway" || calls[0].Tool != "shell" {
+		t.Fatalf("Expected both calls listed as running, got %+v", calls)
+	}
+
+	if e.CancelCall("call_unknown") {
+		t.Errorf("Expected an unknown call not to be found")
+	}
+	if !e.CancelCall("call_runaway") {
+		t.Fatalf("Expected the running call to be found")
+	}
+	if got := <-results["call_runaway"]; !errors.Is(got.err, ErrCancelledByUser) {
+		t.Errorf("Expected the cancelled call to fail with ErrCancelledByUser, got %v", got.err)
+	}
+
+	close(release)
+	if got := <-results["call_read"]; got.err != nil || got.result != "call_read" {
+		t.Errorf("Expected the other call to finish, got %q, %v", got.result, got.err)
+	}
+	if calls := e.Calls(); len(calls) != 0 {
+		t.Errorf("Expected no calls left, got %+v", calls)
+	}
+}
+
+func TestExecutorDescribe(t *testing.T) {
+	e := NewExecutor(4, map[string]int{"execute_command": 2, "read_file": 0})
+	want := "max_concurrent_tools: 4\ntool_concurrency.read_file: unlimited\ntool_concurrency.shell: 2"
+	if got := e.Describe(); got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+}
@@ -0,0 +1,295 @@
+package functions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxOutlineSymbols caps how many symbols file_info lists
+	maxOutlineSymbols = 200
+	// binarySniffBytes is how much of a file is checked for NUL bytes
+	binarySniffBytes = 8000
+)
+
+// languageNames maps file extensions to the language reported by file_info
+var languageNames = map[string]string{
+	".go":    "Go",
+	".py":    "Python",
+	".js":    "JavaScript",
+	".mjs":   "JavaScript",
+	".cjs":   "JavaScript",
+	".jsx":   "JavaScript (JSX)",
+	".ts":    "TypeScript",
+	".mts":   "TypeScript",
+	".tsx":   "TypeScript (TSX)",
+	".rs":    "Rust",
+	".rb":    "Ruby",
+	".java":  "Java",
+	".kt":    "Kotlin",
+	".swift": "Swift",
+	".c":     "C",
+	".h":     "C",
+	".cpp":   "C++",
+	".cc":    "C++",
+	".hpp":   "C++",
+	".cs":    "C#",
+	".php":   "PHP",
+	".sh":    "Shell",
+	".bash":  "Shell",
+	".sql":   "SQL",
+	".html":  "HTML",
+	".css":   "CSS",
+	".scss":  "SCSS",
+	".md":    "Markdown",
+	".json":  "JSON",
+	".yaml":  "YAML",
+	".yml":   "YAML",
+	".toml":  "TOML",
+	".xml":   "XML",
+	".proto": "Protocol Buffers",
+}
+
+// languageFileNames names the language of files known by name rather than extension
+var languageFileNames = map[string]string{
+	"Makefile":   "Makefile",
+	"Dockerfile": "Dockerfile",
+	"go.mod":     "Go module",
+}
+
+// outlinePattern matches one kind of declaration; the first group is the
+// line's indentation and the second the symbol's name
+type outlinePattern struct {
+	kind    string
+	pattern *regexp.Regexp
+}
+
+// outlinePatterns are the declarations outlined for languages without a parser
+var outlinePatterns = map[string][]outlinePattern{
+	"Python": {
+		{"class", regexp.MustCompile(`^(\s*)class\s+(\w+)`)},
+		{"def", regexp.MustCompile(`^(\s*)(?:async\s+)?def\s+(\w+)`)},
+	},
+	"JavaScript": jsOutlinePatterns,
+	"TypeScript": append([]outlinePattern{
+		{"interface", regexp.MustCompile(`^(\s*)(?:export\s+)?(?:declare\s+)?interface\s+(\w+)`)},
+		{"type", regexp.MustCompile(`^(\s*)(?:export\s+)?(?:declare\s+)?type\s+(\w+)\s*(?:<[^=]*>)?\s*=`)},
+		{"enum", regexp.MustCompile(`^(\s*)(?:export\s+)?(?:declare\s+)?(?:const\s+)?enum\s+(\w+)`)},
+	}, jsOutlinePatterns...),
+	"Rust": {
+		{"struct", regexp.MustCompile(`^(\s*)(?:pub(?:\([^)]*\))?\s+)?struct\s+(\w+)`)},
+		{"enum", regexp.MustCompile(`^(\s*)(?:pub(?:\([^)]*\))?\s+)?enum\s+(\w+)`)},
+		{"trait", regexp.MustCompile(`^(\s*)(?:pub(?:\([^)]*\))?\s+)?(?:unsafe\s+)?trait\s+(\w+)`)},
+		{"impl", regexp.MustCompile(`^(\s*)(?:unsafe\s+)?impl(?:<[^>]*>)?\s+([^{]+?)\s*(?:where\b.*)?\{?\s*$`)},
+		{"fn", regexp.MustCompile(`^(\s*)(?:pub(?:\([^)]*\))?\s+)?(?:const\s+)?(?:async\s+)?(?:unsafe\s+)?(?:extern\s+"[^"]*"\s+)?fn\s+(\w+)`)},
+		{"mod", regexp.MustCompile(`^(\s*)(?:pub(?:\([^)]*\))?\s+)?mod\s+(\w+)`)},
+	},
+	"Ruby": {
+		{"module", regexp.MustCompile(`^(\s*)module\s+([\w:]+)`)},
+		{"class", regexp.MustCompile(`^(\s*)class\s+([\w:]+)`)},
+		{"def", regexp.MustCompile(`^(\s*)def\s+((?:self\.)?[\w?!=]+)`)},
+	},
+	"Java": {
+		{"class", regexp.MustCompile(`^(\s*)(?:(?:public|protected|private|abstract|static|final|sealed)\s+)*(?:class|interface|enum|record)\s+(\w+)`)},
+		{"method", regexp.MustCompile(`^(\s+)(?:(?:public|protected|private|abstract|static|final|synchronized|native|default)\s+)+(?:<[^>]*>\s+)?[\w.<>\[\], ?]+\s+(\w+)\s*\(`)},
+	},
+	// Used when a Go file does not parse
+	"Go": {
+		{"func", regexp.MustCompile(`^()func\s+(?:\([^)]*\)\s*)?(\w+)`)},
+		{"type", regexp.MustCompile(`^()type\s+(\w+)`)},
+	},
+}
+
+// jsOutlinePatterns outline JavaScript, and TypeScript along with its own declarations
+var jsOutlinePatterns = []outlinePattern{
+	{"class", regexp.MustCompile(`^(\s*)(?:export\s+)?(?:default\s+)?(?:abstract\s+)?class\s+(\w+)`)},
+	{"function", regexp.MustCompile(`^(\s*)(?:export\s+)?(?:default\s+)?(?:async\s+)?function\s*\*?\s*(\w+)`)},
+	{"function", regexp.MustCompile(`^(\s*)(?:export\s+)?(?:const|let|var)\s+(\w+)\s*(?::[^=]+)?=\s*(?:async\s+)?(?:function\b|(?:\([^)]*\)|\w+)\s*(?::[^=]+)?=>)`)},
+}
+
+// outlineSymbol is a declaration listed by file_info
+type outlineSymbol struct {
+	kind    string
+	name    string
+	line    int // 1-based
+	endLine int // 1-based, 0 when unknown
+	depth   int // Nesting, from indentation
+}
+
+// FileInfo describes a file without returning its content: its size in lines
+// and bytes, its language and an outline of the symbols it declares, so the
+// model can read just the part it needs
+func FileInfo(args string) (string, error) {
+	var params struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal([]byte(args), &params); err != nil {
+		return "", fmt.Errorf("failed to parse arguments: %w", err)
+	}
+	if params.Path == "" {
+		return "", fmt.Errorf("path parameter is required")
+	}
+
+	info, err := os.Stat(params.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory; use list_directory", params.Path)
+	}
+	content, err := os.ReadFile(params.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	if bytes.IndexByte(content[:min(len(content), binarySniffBytes)], 0) >= 0 {
+		return fmt.Sprintf("%s: %d bytes, binary", params.Path, len(content)), nil
+	}
+
+	language := fileLanguage(params.Path)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d lines, %d bytes", params.Path, countLines(content), len(content))
+	if language != "" {
+		fmt.Fprintf(&b, ", %s", language)
+	}
+	b.WriteString("\n")
+
+	symbols, ok := outline(params.Path, language, content)
+	switch {
+	case !ok:
+		b.WriteString("No outline is available for this language; use read_file with start_line and end_line.\n")
+	case len(symbols) == 0:
+		b.WriteString("No symbols found.\n")
+	default:
+		b.WriteString("Outline:\n")
+		for i, sym := range symbols {
+			if i == maxOutlineSymbols {
+				fmt.Fprintf(&b, "... and %d more\n", len(symbols)-i)
+				break
+			}
+			fmt.Fprintf(&b, "%s%s %s", strings.Repeat("  ", sym.depth), sym.kind, sym.name)
+			if sym.endLine > sym.line {
+				fmt.Fprintf(&b, " (lines %d-%d)\n", sym.line, sym.endLine)
+			} else {
+				fmt.Fprintf(&b, " (line %d)\n", sym.line)
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// fileLanguage returns the language of a file by its name or extension, or
+// "" when it is not known
+func fileLanguage(path string) string {
+	if language, ok := languageFileNames[filepath.Base(path)]; ok {
+		return language
+	}
+	return languageNames[strings.ToLower(filepath.Ext(path))]
+}
+
+// countLines returns the number of lines in content, counting a last line
+// without a newline
+func countLines(content []byte) int {
+	n := bytes.Count(content, []byte("\n"))
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		n++
+	}
+	return n
+}
+
+// outline lists the symbols declared in content, reporting false when the
+// language has no outline
+func outline(path, language string, content []byte) ([]outlineSymbol, bool) {
+	if language == "Go" {
+		if symbols, err := goOutline(path, content); err == nil {
+			return symbols, true
+		}
+	}
+	// The JSX and TSX variants are outlined as their base language
+	language, _, _ = strings.Cut(language, " (")
+	patterns, ok := outlinePatterns[language]
+	if !ok {
+		return nil, false
+	}
+	return patternOutline(patterns, content), true
+}
+
+// goOutline lists the types, functions and methods of Go source, with the
+// lines each spans
+func goOutline(path string, content []byte) ([]outlineSymbol, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, content, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	span := func(node ast.Node) (int, int) {
+		return fset.Position(node.Pos()).Line, fset.Position(node.End()).Line
+	}
+
+	var symbols []outlineSymbol
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			sym := outlineSymbol{kind: "func", name: decl.Name.Name}
+			if receiver := receiverTypeName(decl); receiver != "" {
+				sym.kind = "method"
+				sym.name = receiver + "." + decl.Name.Name
+			}
+			sym.line, sym.endLine = span(decl)
+			symbols = append(symbols, sym)
+		case *ast.GenDecl:
+			if decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				sym := outlineSymbol{kind: "type", name: typeSpec.Name.Name}
+				switch typeSpec.Type.(type) {
+				case *ast.StructType:
+					sym.name += " struct"
+				case *ast.InterfaceType:
+					sym.name += " interface"
+				}
+				// A lone spec spans its declaration, from the type keyword
+				if len(decl.Specs) == 1 {
+					sym.line, sym.endLine = span(decl)
+				} else {
+					sym.line, sym.endLine = span(typeSpec)
+				}
+				symbols = append(symbols, sym)
+			}
+		}
+	}
+	return symbols, nil
+}
+
+// patternOutline lists the lines of content matching patterns, nesting each
+// symbol under the less indented ones before it
+func patternOutline(patterns []outlinePattern, content []byte) []outlineSymbol {
+	var symbols []outlineSymbol
+	var open []int // Indentation of the enclosing symbols
+	for i, line := range strings.Split(string(content), "\n") {
+		for _, p := range patterns {
+			match := p.pattern.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			indent := len(strings.ReplaceAll(match[1], "\t", "    "))
+			for len(open) > 0 && open[len(open)-1] >= indent {
+				open = open[:len(open)-1]
+			}
+			symbols = append(symbols, outlineSymbol{kind: p.kind, name: match[2], line: i + 1, depth: len(open)})
+			open = append(open, indent)
+			break
+		}
+	}
+	return symbols
+}
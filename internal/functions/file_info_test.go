@@ -0,0 +1,88 @@
+package functions
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fileInfoOf writes content to a file named name and returns file_info's output for it
+func fileInfoOf(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args, _ := json.Marshal(map[string]string{"path": path})
+	result, err := FileInfo(string(args))
+	if err != nil {
+		t.Fatalf("file_info failed: %v", err)
+	}
+	return strings.TrimPrefix(result, path)
+}
+
+func TestFileInfoOutlinesGo(t *testing.T) {
+	src := `package store
+
+type Store struct {
+	items map[string]int
+}
+
+type (
+	Key   string
+	Value interface{ Size() int }
+)
+
+func New() *Store {
+	return &Store{items: map[string]int{}}
+}
+
+func (s *Store) Get(k Key) int { return s.items[string(k)] }
+`
+	want := `: 16 lines, 239 bytes, Go
+Outline:
+type Store struct (lines 3-5)
+type Key (line 8)
+type Value interface (line 9)
+func New (lines 12-14)
+method Store.Get (line 16)
+`
+	if got := fileInfoOf(t, "store.go", src); got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestFileInfoOutlinesByIndentation(t *testing.T) {
+	src := "import os\n\nclass Config:\n    def load(self):\n        def helper():\n            pass\n\n    async def save(self):\n        pass\n\ndef main():\n    pass"
+	want := `: 12 lines, 145 bytes, Python
+Outline:
+class Config (line 3)
+  def load (line 4)
+    def helper (line 5)
+  def save (line 8)
+def main (line 11)
+`
+	if got := fileInfoOf(t, "config.py", src); got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	// A Go file that does not parse is still outlined, without line ranges
+	if got := fileInfoOf(t, "broken.go", "package x\n\nfunc (s *S) Run() {\n\tif {\n}\n"); !strings.Contains(got, "func Run (line 3)") {
+		t.Errorf("Expected the broken file to be outlined, got:\n%s", got)
+	}
+}
+
+func TestFileInfoWithoutOutline(t *testing.T) {
+	if got := fileInfoOf(t, "notes.txt", "one\ntwo\n"); !strings.HasPrefix(got, ": 2 lines, 8 bytes\nNo outline") {
+		t.Errorf("Expected the size of an unknown file type, got:\n%s", got)
+	}
+	if got := fileInfoOf(t, "logo.png", "\x89PNG\x00\x01"); got != ": 6 bytes, binary" {
+		t.Errorf("Expected a binary file to be reported as such, got %q", got)
+	}
+	args, _ := json.Marshal(map[string]string{"path": t.TempDir()})
+	if _, err := FileInfo(string(args)); err == nil || !strings.Contains(err.Error(), "list_directory") {
+		t.Errorf("Expected a directory to be rejected, got %v", err)
+	}
+}
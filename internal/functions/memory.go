@@ -0,0 +1,66 @@
+package functions
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/memory"
+)
+
+// MemoryTools exposes a project memory store to the model as the
+// remember and recall tools
+type MemoryTools struct {
+	store *memory.Store
+}
+
+// NewMemoryTools creates the memory tools backed by store
+func NewMemoryTools(store *memory.Store) *MemoryTools {
+	return &MemoryTools{store: store}
+}
+
+// Remember stores a project fact under a key
+func (m *MemoryTools) Remember(args string) (string, error) {
+	var params struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal([]byte(args), &params); err != nil {
+		return "", fmt.Errorf("failed to parse arguments: %w", err)
+	}
+	if params.Key == "" {
+		return "", fmt.Errorf("key parameter is required")
+	}
+	if params.Value == "" {
+		return "", fmt.Errorf("value parameter is required")
+	}
+
+	if err := m.store.Remember(params.Key, params.Value); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Remembered %q for future sessions.", params.Key), nil
+}
+
+// Recall returns remembered facts matching a keyword query
+func (m *MemoryTools) Recall(args string) (string, error) {
+	var params struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal([]byte(args), &params); err != nil {
+		return "", fmt.Errorf("failed to parse arguments: %w", err)
+	}
+
+	entries, err := m.store.Recall(params.Query)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return fmt.Sprintf("No remembered facts match %q.", params.Query), nil
+	}
+
+	var b strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&b, "- %s: %s\n", e.Key, e.Value)
+	}
+	return b.String(), nil
+}
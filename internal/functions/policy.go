@@ -0,0 +1,88 @@
+package functions
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/policy"
+)
+
+// PolicyRequest describes a tool call for the approval policy. Paths, which
+// resolve against the workspace's working directory, are made relative to
+// the workspace root when inside it. workspace may be nil.
+func PolicyRequest(workspace *Workspace, name, arguments string) policy.Request {
+	req := policy.Request{Tool: name}
+	var args struct {
+		Command      string `json:"command"`
+		Path         string `json:"path"`
+		CodeEdit     string `json:"code_edit"`
+		PatchContent string `json:"patch_content"`
+		Edits        []struct {
+			Path string `json:"path"`
+		} `json:"edits"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return req
+	}
+	req.Command = args.Command
+
+	var paths []string
+	if args.Path != "" {
+		paths = append(paths, args.Path)
+	}
+	for _, edit := range args.Edits {
+		paths = append(paths, edit.Path)
+	}
+	if patch := args.CodeEdit + args.PatchContent; patch != "" {
+		paths = append(paths, PatchTargets(patch)...)
+	}
+	for _, path := range paths {
+		req.Paths = append(req.Paths, policyPath(workspace, path))
+	}
+	return req
+}
+
+// policyPath makes a path, relative to the workspace's working directory,
+// relative to the workspace root when it is inside it
+func policyPath(workspace *Workspace, path string) string {
+	if workspace == nil {
+		return filepath.ToSlash(path)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspace.Cwd(), path)
+	}
+	rel, err := filepath.Rel(workspace.Dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(filepath.Clean(path))
+	}
+	return filepath.ToSlash(rel)
+}
+
+// PolicyApproval applies the approval policy to a tool call. It returns the
+// matching decision and true when a rule or default applies; requiresApproval
+// then tells whether the call must be approved, a "prompt" decision being
+// ignored in dangerous mode. It returns false when the approval mode and
+// workspace checks decide instead.
+func PolicyApproval(rules *policy.Policy, mode config.ApprovalMode, workspace *Workspace, name, arguments string) (match policy.Match, requiresApproval, ok bool) {
+	match, ok = rules.Evaluate(PolicyRequest(workspace, name, arguments))
+	if !ok {
+		return match, false, false
+	}
+	return match, match.Decision == policy.Prompt && mode != config.DangerousAutoApprove, true
+}
+
+// PatchTargets returns the files named by the // FILE: markers of an agent patch
+func PatchTargets(patch string) []string {
+	var files []string
+	for _, line := range strings.Split(patch, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "// FILE:") {
+			if file := strings.TrimSpace(strings.TrimPrefix(trimmed, "// FILE:")); file != "" {
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
@@ -0,0 +1,114 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/codeindex"
+)
+
+const (
+	// defaultSearchResults is how many chunks semantic_search returns by default
+	defaultSearchResults = 5
+	// maxSearchResults caps top_k
+	maxSearchResults = 20
+	// searchResultLines caps the lines shown of each chunk
+	searchResultLines = 60
+)
+
+// SearchTools exposes the workspace embeddings index to the model as the
+// semantic_search tool, falling back to a keyword search without an index
+type SearchTools struct {
+	indexPath string
+	root      string // Searched by keyword when there is no index
+	embedder  codeindex.Embedder
+}
+
+// NewSearchTools creates the search tool for the index at indexPath
+func NewSearchTools(indexPath, root string, embedder codeindex.Embedder) *SearchTools {
+	return &SearchTools{indexPath: indexPath, root: root, embedder: embedder}
+}
+
+// SemanticSearch returns the code chunks closest in meaning to a query
+func (s *SearchTools) SemanticSearch(ctx context.Context, args string) (string, error) {
+	var params struct {
+		Query string `json:"query"`
+		TopK  int    `json:"top_k"`
+	}
+	if err := json.Unmarshal([]byte(args), &params); err != nil {
+		return "", fmt.Errorf("failed to parse arguments: %w", err)
+	}
+	if strings.TrimSpace(params.Query) == "" {
+		return "", fmt.Errorf("query parameter is required")
+	}
+	topK := params.TopK
+	if topK <= 0 {
+		topK = defaultSearchResults
+	}
+	topK = min(topK, maxSearchResults)
+
+	idx, err := codeindex.Load(s.indexPath)
+	if err != nil {
+		note := "No semantic index exists (run codex index to build one)"
+		if !os.IsNotExist(err) {
+			note = fmt.Sprintf("The semantic index could not be read (%v)", err)
+		}
+		return s.keywordSearch(note, params.Query, topK)
+	}
+	results, err := idx.Search(ctx, s.embedder, params.Query, topK)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", err
+		}
+		return s.keywordSearch(fmt.Sprintf("Semantic search failed (%v)", err), params.Query, topK)
+	}
+	if len(results) == 0 {
+		return "The semantic index is empty (run codex index to build it).", nil
+	}
+	return formatSearchResults(results, true), nil
+}
+
+// keywordSearch answers a query by keyword, explaining why with note
+func (s *SearchTools) keywordSearch(note, query string, topK int) (string, error) {
+	results, err := codeindex.KeywordSearch(s.root, query, topK)
+	if err != nil {
+		return "", err
+	}
+	if len(results) == 0 {
+		return fmt.Sprintf("%s; no lines match the words of %q either.", note, query), nil
+	}
+	return fmt.Sprintf("%s; showing keyword matches instead:\n\n%s", note, formatSearchResults(results, false)), nil
+}
+
+// formatSearchResults lists results as path:lines headers followed by the code
+func formatSearchResults(results []codeindex.Result, semantic bool) string {
+	var b strings.Builder
+	for i, r := range results {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%s:%d-%d", r.Path, r.StartLine, r.EndLine)
+		if r.Symbol != "" {
+			fmt.Fprintf(&b, " (%s)", r.Symbol)
+		}
+		if semantic {
+			fmt.Fprintf(&b, " score %.2f", r.Score)
+		}
+		if r.Stale {
+			b.WriteString(" [changed since indexing]")
+		}
+		b.WriteString("\n")
+
+		lines := strings.Split(r.Text, "\n")
+		if len(lines) > searchResultLines {
+			omitted := len(lines) - searchResultLines
+			lines = append(lines[:searchResultLines], fmt.Sprintf("... (%d more lines)", omitted))
+		}
+		b.WriteString(strings.Join(lines, "\n"))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
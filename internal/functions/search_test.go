@@ -0,0 +1,30 @@
+package functions
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSemanticSearchFallsBackToKeywords(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "retry.go"), []byte("package x\n\n// retry with backoff\nfunc Do() {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// Without an index no embeddings are requested, so no embedder is needed
+	search := NewSearchTools(filepath.Join(dir, ".codex", "index.json"), dir, nil)
+	out, err := search.SemanticSearch(context.Background(), mustArgs(t, map[string]string{"query": "backoff"}))
+	if err != nil {
+		t.Fatalf("SemanticSearch failed: %v", err)
+	}
+	if !strings.Contains(out, "No semantic index exists") || !strings.Contains(out, "retry.go:1-4") {
+		t.Errorf("Expected keyword matches with a note, got %q", out)
+	}
+
+	if _, err := search.SemanticSearch(context.Background(), `{"query": " "}`); err == nil {
+		t.Error("Expected an error for an empty query")
+	}
+}
@@ -0,0 +1,101 @@
+package functions
+
+import (
+	"encoding/json"
+
+	"github.com/epuerta/codex-go/internal/codeindex"
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/fileops"
+	"github.com/epuerta/codex-go/internal/lsp"
+	"github.com/epuerta/codex-go/internal/memory"
+)
+
+// NewToolRegistry builds the tools for one session; file edits are
+// recorded in journal. The project memory store and language servers may be
+// nil, and so may embedder, semantic search then embedding its queries with
+// the configured provider directly.
+func NewToolRegistry(cfg *config.Config, journal *fileops.Journal, memoryStore *memory.Store, languageServers *lsp.Manager, embedder codeindex.Embedder) *Registry {
+	// Paths resolve against the working directory validated when the config was loaded
+	workspace := &Workspace{Dir: cfg.ToolDir(), Confine: cfg.SandboxEnforced()}
+
+	registry := NewRegistry()
+	registry.workspace = workspace
+	registry.Register("read_file", workspace.Paths(ReadFile))
+	registry.Register("file_info", workspace.Paths(FileInfo))
+	registry.Register("write_file", workspace.Paths(WithJournal(journal, WithEditorConfig(cfg, WriteFileWith))))
+	registry.Register("append_file", workspace.Paths(WithJournal(journal, WithEditorConfig(cfg, AppendFileWith))))
+	registry.Register("patch_file", workspace.Paths(WithJournal(journal, WithEditorConfig(cfg, PatchFileWith))))
+	registry.Register("edit_symbol", workspace.Paths(WithJournal(journal, EditSymbol)))
+	registry.Register("apply_edits", workspace.EditPaths(WithJournal(journal, ApplyEdits)))
+	executeCommand := CommandTool(cfg)
+	registry.RegisterContext("shell", workspace.ShellContext(executeCommand))
+	registry.RegisterContext("execute_command", workspace.ShellContext(executeCommand))
+	registry.Register("list_directory", workspace.DirPaths(ListDirectory))
+	registry.Register("change_directory", workspace.ChangeDirectory)
+
+	chunkedWriter := NewChunkedWriter()
+	registry.Register("begin_write", workspace.Paths(chunkedWriter.BeginWrite))
+	registry.Register("append_chunk", workspace.Paths(chunkedWriter.AppendChunk))
+	registry.Register("commit_write", workspace.Paths(WithJournal(journal, WithEditorConfig(cfg, chunkedWriter.CommitWriteWith))))
+	registry.AtTurnEnd(func() { chunkedWriter.Cleanup() })
+
+	if memoryStore != nil {
+		memoryTools := NewMemoryTools(memoryStore)
+		registry.Register("remember", memoryTools.Remember)
+		registry.Register("recall", memoryTools.Recall)
+	}
+	if languageServers != nil {
+		codeNav := NewCodeNavTools(languageServers)
+		registry.Register("find_definition", workspace.Paths(codeNav.FindDefinition))
+		registry.Register("find_references", workspace.Paths(codeNav.FindReferences))
+		registry.Register("document_symbols", workspace.Paths(codeNav.DocumentSymbols))
+		registry.Register("hover", workspace.Paths(codeNav.Hover))
+	}
+	if cfg.SemanticSearch {
+		if embedder == nil {
+			embedder = codeindex.NewOpenAIEmbedder(cfg)
+		}
+		search := NewSearchTools(codeindex.PathFor(cfg.ToolDir()), workspace.Dir, embedder)
+		registry.RegisterContext("semantic_search", search.SemanticSearch)
+	}
+	return registry
+}
+
+// IsShellTool reports whether name is a tool that runs shell commands
+func IsShellTool(name string) bool {
+	return name == "shell" || name == "execute_command"
+}
+
+// ShellCommand extracts the command of a shell tool call
+func ShellCommand(name, args string) (string, bool) {
+	if !IsShellTool(name) {
+		return "", false
+	}
+	var params struct {
+		Command string `json:"command"`
+	}
+	if err := json.Unmarshal([]byte(args), &params); err != nil || params.Command == "" {
+		return "", false
+	}
+	return params.Command, true
+}
+
+// NeedsApproval reports whether a call to the tool name needs the user's
+// approval under mode, in the terminal UI and without it. Tools that only read
+// are exempt in suggest mode; staging chunks never touches the target file, so
+// approval happens on commit_write.
+func NeedsApproval(mode config.ApprovalMode, name string) bool {
+	switch mode {
+	case config.AutoEdit:
+		return IsShellTool(name)
+	case config.FullAuto, config.DangerousAutoApprove:
+		return false
+	default:
+		switch name {
+		case "read_file", "file_info", "list_directory", "change_directory", "begin_write", "append_chunk", "recall",
+			"find_definition", "find_references", "document_symbols", "hover", "semantic_search":
+			return false
+		}
+		return true
+	}
+}
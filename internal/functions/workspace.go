@@ -0,0 +1,255 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Workspace resolves the paths tools receive against a working directory, so
+// tools behave the same regardless of the process working directory. The
+// session may move to a directory below Dir with change_directory; relative
+// paths then resolve against that directory instead.
+type Workspace struct {
+	Dir     string // Absolute workspace root
+	Confine bool   // Reject paths that resolve outside Dir
+
+	mu  sync.RWMutex
+	cwd string // Session working directory, "" for Dir
+}
+
+// NewWorkspace creates a workspace rooted at dir, which must be an existing directory
+func NewWorkspace(dir string, confine bool) (*Workspace, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve working directory: %w", err)
+	}
+	info, err := os.Stat(absDir)
+	if err != nil {
+		return nil, fmt.Errorf("working directory %s: %w", absDir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("working directory %s is not a directory", absDir)
+	}
+	return &Workspace{Dir: absDir, Confine: confine}, nil
+}
+
+// Cwd returns the session working directory
+func (w *Workspace) Cwd() string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.cwd == "" {
+		return w.Dir
+	}
+	return w.cwd
+}
+
+// Chdir moves the session working directory to path, resolved against the
+// current one. The directory must exist and be inside the workspace root,
+// whether or not the workspace is confined. It returns the new directory.
+func (w *Workspace) Chdir(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("path parameter is required")
+	}
+	resolved := path
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(w.Cwd(), resolved)
+	}
+	resolved = filepath.Clean(resolved)
+	if !w.contains(resolved) {
+		return "", fmt.Errorf("directory %s is outside the workspace %s", path, w.Dir)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", fmt.Errorf("directory %s: %w", path, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", path)
+	}
+
+	w.mu.Lock()
+	w.cwd = resolved
+	w.mu.Unlock()
+	return resolved, nil
+}
+
+// ChangeDirectory is the change_directory tool: it moves the session working
+// directory for the tool calls that follow
+func (w *Workspace) ChangeDirectory(args string) (string, error) {
+	var params struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal([]byte(args), &params); err != nil {
+		return "", fmt.Errorf("failed to parse arguments: %w", err)
+	}
+	dir, err := w.Chdir(params.Path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Working directory is now %s", dir), nil
+}
+
+// Resolve returns the absolute path for path. Relative paths resolve against the
+// session working directory; when confined, paths outside the root are rejected.
+func (w *Workspace) Resolve(path string) (string, error) {
+	resolved := path
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(w.Cwd(), resolved)
+	}
+	resolved = filepath.Clean(resolved)
+
+	if w.Confine && !w.contains(resolved) {
+		return "", fmt.Errorf("path %s is outside the working directory %s", path, w.Dir)
+	}
+	return resolved, nil
+}
+
+// contains reports whether the clean absolute path is inside the workspace
+// root once symlinks are followed, so a link inside the root that points
+// elsewhere does not let a path escape it
+func (w *Workspace) contains(path string) bool {
+	rel, err := filepath.Rel(evalExisting(w.Dir), evalExisting(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// evalExisting follows the symlinks in the longest part of the clean absolute
+// path that exists; the rest, such as a file about to be created, is appended
+// as it is
+func evalExisting(path string) string {
+	var rest []string
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			for i := len(rest) - 1; i >= 0; i-- {
+				real = filepath.Join(real, rest[i])
+			}
+			return real
+		}
+		if filepath.Dir(dir) == dir {
+			return path
+		}
+		rest = append(rest, filepath.Base(dir))
+	}
+}
+
+// Paths wraps a file tool so its "path" argument is resolved against the workspace
+func (w *Workspace) Paths(fn Function) Function {
+	return func(args string) (string, error) {
+		args, err := w.rewriteArg(args, "path", false)
+		if err != nil {
+			return "", err
+		}
+		return fn(args)
+	}
+}
+
+// DirPaths wraps a tool whose "path" argument names a directory, defaulting
+// to the session working directory
+func (w *Workspace) DirPaths(fn Function) Function {
+	return func(args string) (string, error) {
+		if strings.TrimSpace(args) == "" {
+			args = "{}"
+		}
+		args, err := w.rewriteArg(args, "path", true)
+		if err != nil {
+			return "", err
+		}
+		return fn(args)
+	}
+}
+
+// EditPaths wraps apply_edits so the "path" of each of its "edits" is
+// resolved against the workspace
+func (w *Workspace) EditPaths(fn Function) Function {
+	return func(args string) (string, error) {
+		var params map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(args), &params); err != nil {
+			// Let the tool report malformed arguments itself
+			return fn(args)
+		}
+		var edits []json.RawMessage
+		if err := json.Unmarshal(params["edits"], &edits); err != nil {
+			return fn(args)
+		}
+		for i, edit := range edits {
+			rewritten, err := w.rewriteArg(string(edit), "path", false)
+			if err != nil {
+				return "", fmt.Errorf("edit %d: %w", i+1, err)
+			}
+			edits[i] = json.RawMessage(rewritten)
+		}
+
+		var err error
+		if params["edits"], err = json.Marshal(edits); err != nil {
+			return "", fmt.Errorf("failed to encode arguments: %w", err)
+		}
+		rewritten, err := json.Marshal(params)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode arguments: %w", err)
+		}
+		return fn(string(rewritten))
+	}
+}
+
+// Shell wraps a shell tool so its "workingDir" argument is resolved against the
+// workspace, defaulting to the session working directory. Commands run there
+// rather than relying on a cd in an earlier command.
+func (w *Workspace) Shell(fn Function) Function {
+	return func(args string) (string, error) {
+		args, err := w.rewriteArg(args, "workingDir", true)
+		if err != nil {
+			return "", err
+		}
+		return fn(args)
+	}
+}
+
+// ShellContext is Shell for a cancellable command function
+func (w *Workspace) ShellContext(fn ContextFunction) ContextFunction {
+	return func(ctx context.Context, args string) (string, error) {
+		args, err := w.rewriteArg(args, "workingDir", true)
+		if err != nil {
+			return "", err
+		}
+		return fn(ctx, args)
+	}
+}
+
+// rewriteArg replaces a string argument with its resolved path. When
+// defaultToDir is set, a missing argument is filled in with the session
+// working directory.
+func (w *Workspace) rewriteArg(args, key string, defaultToDir bool) (string, error) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(args), &params); err != nil || params == nil {
+		// Let the tool report malformed arguments itself
+		return args, nil
+	}
+
+	var value string
+	if raw, ok := params[key]; ok {
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return args, nil
+		}
+	}
+	if value == "" {
+		if !defaultToDir {
+			return args, nil
+		}
+		value = "."
+	}
+
+	resolved, err := w.Resolve(value)
+	if err != nil {
+		return "", err
+	}
+	params[key], _ = json.Marshal(resolved)
+
+	rewritten, err := json.Marshal(params)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode arguments: %w", err)
+	}
+	return string(rewritten), nil
+}
@@ -0,0 +1,208 @@
+package functions
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkspaceResolvesRelativePaths(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("inside"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	ws, err := NewWorkspace(dir, false)
+	if err != nil {
+		t.Fatalf("NewWorkspace failed: %v", err)
+	}
+
+	content, err := ws.Paths(ReadFile)(mustArgs(t, map[string]string{"path": "notes.txt"}))
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if content != "inside" {
+		t.Errorf("Expected %q, got %q", "inside", content)
+	}
+
+	// Unconfined workspaces accept absolute paths elsewhere
+	outside := filepath.Join(t.TempDir(), "other.txt")
+	if _, err := ws.Resolve(outside); err != nil {
+		t.Errorf("Expected unconfined workspace to accept %s, got %v", outside, err)
+	}
+}
+
+func TestWorkspaceConfinement(t *testing.T) {
+	dir := t.TempDir()
+	ws, err := NewWorkspace(dir, true)
+	if err != nil {
+		t.Fatalf("NewWorkspace failed: %v", err)
+	}
+
+	for _, path := range []string{"../escape.txt", filepath.Join(t.TempDir(), "other.txt")} {
+		if _, err := ws.Resolve(path); err == nil {
+			t.Errorf("Expected %s to be rejected", path)
+		}
+	}
+	if _, err := ws.Paths(WriteFile)(mustArgs(t, map[string]string{"path": "../escape.txt", "content": "x"})); err == nil {
+		t.Errorf("Expected write outside the working directory to fail")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected no file to be written outside the working directory")
+	}
+
+	inside := filepath.Join(dir, "sub", "..", "ok.txt")
+	resolved, err := ws.Resolve(inside)
+	if err != nil {
+		t.Fatalf("Expected %s to be accepted, got %v", inside, err)
+	}
+	if resolved != filepath.Join(dir, "ok.txt") {
+		t.Errorf("Expected %s, got %s", filepath.Join(dir, "ok.txt"), resolved)
+	}
+}
+
+func TestWorkspaceShellDefaultsWorkingDir(t *testing.T) {
+	dir := t.TempDir()
+	ws, err := NewWorkspace(dir, false)
+	if err != nil {
+		t.Fatalf("NewWorkspace failed: %v", err)
+	}
+
+	var got string
+	capture := func(args string) (string, error) {
+		var params struct {
+			WorkingDir string `json:"workingDir"`
+		}
+		json.Unmarshal([]byte(args), &params)
+		got = params.WorkingDir
+		return "", nil
+	}
+
+	ws.Shell(capture)(mustArgs(t, map[string]string{"command": "pwd"}))
+	if got != dir {
+		t.Errorf("Expected workingDir %s, got %s", dir, got)
+	}
+}
+
+func TestNewWorkspaceRequiresDirectory(t *testing.T) {
+	if _, err := NewWorkspace(filepath.Join(t.TempDir(), "missing"), false); err == nil {
+		t.Errorf("Expected an error for a missing working directory")
+	}
+}
+
+func TestWorkspaceChangeDirectory(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "service")
+	if err := os.MkdirAll(filepath.Join(sub, "cmd"), 0755); err != nil {
+		t.Fatalf("Failed to create directories: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "go.mod"), []byte("module service"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// Unconfined workspaces still keep the session inside the root
+	ws, err := NewWorkspace(dir, false)
+	if err != nil {
+		t.Fatalf("NewWorkspace failed: %v", err)
+	}
+	if _, err := ws.ChangeDirectory(mustArgs(t, map[string]string{"path": "service"})); err != nil {
+		t.Fatalf("ChangeDirectory failed: %v", err)
+	}
+	if ws.Cwd() != sub {
+		t.Errorf("Expected working directory %s, got %s", sub, ws.Cwd())
+	}
+
+	content, err := ws.Paths(ReadFile)(mustArgs(t, map[string]string{"path": "go.mod"}))
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if content != "module service" {
+		t.Errorf("Expected %q, got %q", "module service", content)
+	}
+
+	// Relative moves are relative to the current directory
+	if _, err := ws.Chdir("cmd"); err != nil {
+		t.Fatalf("Chdir failed: %v", err)
+	}
+	if _, err := ws.Chdir(".."); err != nil {
+		t.Fatalf("Chdir failed: %v", err)
+	}
+	if ws.Cwd() != sub {
+		t.Errorf("Expected working directory %s, got %s", sub, ws.Cwd())
+	}
+
+	for _, path := range []string{"../..", t.TempDir(), "go.mod", "missing", ""} {
+		if _, err := ws.Chdir(path); err == nil {
+			t.Errorf("Expected moving to %q to fail", path)
+		}
+	}
+	if ws.Cwd() != sub {
+		t.Errorf("Expected failed moves to keep %s, got %s", sub, ws.Cwd())
+	}
+}
+
+func TestWorkspaceToolsFollowWorkingDir(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "web")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	ws, err := NewWorkspace(dir, true)
+	if err != nil {
+		t.Fatalf("NewWorkspace failed: %v", err)
+	}
+	if _, err := ws.Chdir("web"); err != nil {
+		t.Fatalf("Chdir failed: %v", err)
+	}
+
+	var got map[string]string
+	capture := func(args string) (string, error) {
+		got = nil
+		json.Unmarshal([]byte(args), &got)
+		return "", nil
+	}
+
+	ws.Shell(capture)(mustArgs(t, map[string]string{"command": "pwd"}))
+	if got["workingDir"] != sub {
+		t.Errorf("Expected shell workingDir %s, got %s", sub, got["workingDir"])
+	}
+	ws.DirPaths(capture)("")
+	if got["path"] != sub {
+		t.Errorf("Expected listing of %s, got %s", sub, got["path"])
+	}
+	ws.Paths(capture)(mustArgs(t, map[string]string{"path": "../README.md"}))
+	if got["path"] != filepath.Join(dir, "README.md") {
+		t.Errorf("Expected %s, got %s", filepath.Join(dir, "README.md"), got["path"])
+	}
+	if _, err := ws.Paths(capture)(mustArgs(t, map[string]string{"path": "../../escape.txt"})); err == nil {
+		t.Errorf("Expected a path outside the root to be rejected")
+	}
+}
+
+func TestWorkspaceConfinementFollowsSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Skipf("Cannot create symlinks: %v", err)
+	}
+	ws, err := NewWorkspace(dir, true)
+	if err != nil {
+		t.Fatalf("NewWorkspace failed: %v", err)
+	}
+
+	for _, path := range []string{"link/secret.txt", "link/new.txt", "link"} {
+		if _, err := ws.Resolve(path); err == nil {
+			t.Errorf("Expected %s to be rejected as it leads outside the working directory", path)
+		}
+	}
+	if _, err := ws.Chdir("link"); err == nil {
+		t.Errorf("Expected change_directory through a symlink leading outside to fail")
+	}
+	if _, err := ws.Resolve("sub/new.txt"); err != nil {
+		t.Errorf("Expected a new file inside the working directory to be accepted, got %v", err)
+	}
+}
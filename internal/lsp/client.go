@@ -0,0 +1,373 @@
+package lsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrServerExited is returned for requests to a language server that has exited
+var ErrServerExited = errors.New("language server exited")
+
+// Client speaks JSON-RPC to a language server over its stdin/stdout
+type Client struct {
+	conn    io.ReadWriteCloser
+	cmd     *exec.Cmd // nil when the connection was not spawned by Start
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan *rpcMessage
+	opened  map[string]openDocument // URI -> document as last sent to the server
+	done    chan struct{}
+	err     error // Why the connection closed
+}
+
+// openDocument is a document the server has been told about
+type openDocument struct {
+	version int
+	text    string
+}
+
+// rpcMessage is any JSON-RPC 2.0 message
+type rpcMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+// rpcError is a JSON-RPC error response
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("language server error %d: %s", e.Code, e.Message)
+}
+
+// processConn joins a child process's stdout and stdin into one connection
+type processConn struct {
+	io.ReadCloser
+	stdin io.WriteCloser
+}
+
+func (p *processConn) Write(b []byte) (int, error) { return p.stdin.Write(b) }
+
+func (p *processConn) Close() error {
+	p.stdin.Close()
+	return p.ReadCloser.Close()
+}
+
+// Start spawns a language server and initializes it for rootDir
+func Start(ctx context.Context, command []string, rootDir string) (*Client, error) {
+	if len(command) == 0 {
+		return nil, errors.New("empty language server command")
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = rootDir
+	cmd.Stderr = io.Discard
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create language server stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create language server stdout: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", command[0], err)
+	}
+
+	c := newClient(&processConn{ReadCloser: stdout, stdin: stdin})
+	c.cmd = cmd
+	go func() {
+		cmd.Wait()
+		c.shutdown(ErrServerExited)
+	}()
+
+	if err := c.Initialize(ctx, rootDir); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// newClient starts reading responses from conn
+func newClient(conn io.ReadWriteCloser) *Client {
+	c := &Client{
+		conn:    conn,
+		pending: make(map[int64]chan *rpcMessage),
+		opened:  make(map[string]openDocument),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+// Initialize performs the LSP initialize handshake
+func (c *Client) Initialize(ctx context.Context, rootDir string) error {
+	params := map[string]interface{}{
+		"processId": os.Getpid(),
+		"rootUri":   FileURI(rootDir),
+		"workspaceFolders": []map[string]string{
+			{"uri": FileURI(rootDir), "name": rootDir},
+		},
+		"capabilities": map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"documentSymbol": map[string]interface{}{"hierarchicalDocumentSymbolSupport": true},
+				"hover":          map[string]interface{}{"contentFormat": []string{"markdown", "plaintext"}},
+				"definition":     map[string]interface{}{"linkSupport": true},
+			},
+		},
+	}
+	if err := c.Call(ctx, "initialize", params, nil); err != nil {
+		return fmt.Errorf("failed to initialize language server: %w", err)
+	}
+	return c.Notify("initialized", map[string]interface{}{})
+}
+
+// Done is closed once the connection to the server is gone
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Call sends a request and decodes its result into result (if non-nil)
+func (c *Client) Call(ctx context.Context, method string, params, result interface{}) error {
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return err
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan *rpcMessage, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	rawID := json.RawMessage(strconv.FormatInt(id, 10))
+	if err := c.write(&rpcMessage{ID: &rawID, Method: method, Params: mustRaw(params)}); err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result == nil {
+			return nil
+		}
+		if raw, ok := result.(*json.RawMessage); ok {
+			*raw = resp.Result
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		c.Notify("$/cancelRequest", map[string]int64{"id": id})
+		return ctx.Err()
+	}
+}
+
+// Notify sends a notification
+func (c *Client) Notify(method string, params interface{}) error {
+	return c.write(&rpcMessage{Method: method, Params: mustRaw(params)})
+}
+
+// OpenFile tells the server about the current content of a file, sending
+// didOpen the first time and didChange when the content has changed since
+func (c *Client) OpenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	text := string(data)
+	uri := FileURI(path)
+
+	c.mu.Lock()
+	doc, isOpen := c.opened[uri]
+	if isOpen && doc.text == text {
+		c.mu.Unlock()
+		return uri, nil
+	}
+	doc = openDocument{version: doc.version + 1, text: text}
+	c.opened[uri] = doc
+	c.mu.Unlock()
+
+	if !isOpen {
+		return uri, c.Notify("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"uri":        uri,
+				"languageId": languageID(path),
+				"version":    doc.version,
+				"text":       text,
+			},
+		})
+	}
+	return uri, c.Notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": doc.version},
+		"contentChanges": []map[string]string{{"text": text}},
+	})
+}
+
+// Close shuts the server down, killing it if it does not exit promptly
+func (c *Client) Close() error {
+	select {
+	case <-c.done:
+	default:
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := c.Call(ctx, "shutdown", nil, nil); err == nil {
+			c.Notify("exit", nil)
+		}
+		cancel()
+	}
+	c.shutdown(ErrServerExited)
+	if c.cmd != nil && c.cmd.Process != nil {
+		// Already gone after a clean exit; Kill only matters for a hung server
+		c.cmd.Process.Kill()
+	}
+	return nil
+}
+
+// shutdown marks the connection closed and fails pending requests
+func (c *Client) shutdown(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	c.conn.Close()
+	close(c.done)
+}
+
+// write sends one framed message
+func (c *Client) write(msg *rpcMessage) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", msg.Method, err)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		c.shutdown(ErrServerExited)
+		return ErrServerExited
+	}
+	return nil
+}
+
+// readLoop dispatches responses to waiting calls and answers server requests
+func (c *Client) readLoop() {
+	reader := bufio.NewReader(c.conn)
+	for {
+		msg, err := readMessage(reader)
+		if err != nil {
+			c.shutdown(ErrServerExited)
+			return
+		}
+
+		switch {
+		case msg.ID != nil && msg.Method != "":
+			go c.answerServerRequest(msg)
+		case msg.ID != nil:
+			id, err := strconv.ParseInt(string(*msg.ID), 10, 64)
+			if err != nil {
+				continue
+			}
+			c.mu.Lock()
+			ch := c.pending[id]
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- msg
+			}
+		}
+		// Notifications (diagnostics, progress, logs) are not needed
+	}
+}
+
+// answerServerRequest gives neutral answers to requests the server makes of the client
+func (c *Client) answerServerRequest(req *rpcMessage) {
+	var result interface{}
+	if req.Method == "workspace/configuration" {
+		var params struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		json.Unmarshal(req.Params, &params)
+		result = make([]interface{}, len(params.Items))
+	}
+	resp := &rpcMessage{ID: req.ID, Result: mustRaw(result)}
+	if resp.Result == nil {
+		resp.Result = json.RawMessage("null")
+	}
+	c.write(resp)
+}
+
+// readMessage reads one Content-Length framed message
+func readMessage(r *bufio.Reader) (*rpcMessage, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("message without Content-Length")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	var msg rpcMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	return &msg, nil
+}
+
+// mustRaw encodes params, returning nil for nil params
+func mustRaw(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
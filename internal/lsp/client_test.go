@@ -0,0 +1,200 @@
+package lsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer answers LSP requests over an in-memory connection
+type fakeServer struct {
+	conn     net.Conn
+	mu       sync.Mutex
+	methods  []string // Methods of all requests and notifications received
+	handlers map[string]func(params json.RawMessage) interface{}
+}
+
+// startFakeClient connects a Client to a new fake server
+func startFakeClient(t *testing.T, handlers map[string]func(json.RawMessage) interface{}) (*Client, *fakeServer) {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	server := &fakeServer{conn: serverConn, handlers: handlers}
+	go server.serve()
+	c := newClient(clientConn)
+	t.Cleanup(func() { c.shutdown(ErrServerExited) })
+	return c, server
+}
+
+func (s *fakeServer) serve() {
+	reader := bufio.NewReader(s.conn)
+	for {
+		msg, err := readMessage(reader)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.methods = append(s.methods, msg.Method)
+		handler := s.handlers[msg.Method]
+		s.mu.Unlock()
+		if msg.ID == nil {
+			continue
+		}
+
+		var result interface{}
+		if handler != nil {
+			result = handler(msg.Params)
+		}
+		body, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID, "result": result})
+		fmt.Fprintf(s.conn, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+}
+
+func (s *fakeServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.methods...)
+}
+
+func TestClientDefinitionOpensDocument(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(path, []byte("package main\n\nfunc main() {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	var gotPosition Position
+	c, server := startFakeClient(t, map[string]func(json.RawMessage) interface{}{
+		"textDocument/definition": func(params json.RawMessage) interface{} {
+			var p struct {
+				Position Position `json:"position"`
+			}
+			json.Unmarshal(params, &p)
+			gotPosition = p.Position
+			return []map[string]interface{}{{
+				"targetUri":            FileURI(path),
+				"targetSelectionRange": Range{Start: Position{Line: 2, Character: 5}},
+			}}
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	locations, err := c.Definition(ctx, path, Position{Line: 2, Character: 6})
+	if err != nil {
+		t.Fatalf("Definition failed: %v", err)
+	}
+	if len(locations) != 1 || URIPath(locations[0].URI) != path || locations[0].Range.Start.Line != 2 {
+		t.Errorf("Expected one location in %s at line 2, got %+v", path, locations)
+	}
+	if gotPosition != (Position{Line: 2, Character: 6}) {
+		t.Errorf("Expected the request position to be forwarded, got %+v", gotPosition)
+	}
+
+	// Unchanged files are not re-sent; edited files are sent as a change
+	c.Definition(ctx, path, Position{Line: 2})
+	os.WriteFile(path, []byte("package main\n\nfunc main() { println() }\n"), 0644)
+	c.Definition(ctx, path, Position{Line: 2})
+
+	opens, changes := 0, 0
+	for _, method := range server.received() {
+		switch method {
+		case "textDocument/didOpen":
+			opens++
+		case "textDocument/didChange":
+			changes++
+		}
+	}
+	if opens != 1 || changes != 1 {
+		t.Errorf("Expected 1 didOpen and 1 didChange, got %d and %d", opens, changes)
+	}
+}
+
+func TestManagerRestartsExitedServer(t *testing.T) {
+	starts := 0
+	var current *fakeServer
+	m := NewManager(t.TempDir(), map[string]string{".go": "fake-gopls"})
+	m.SetStartFunc(func(ctx context.Context, command []string, rootDir string) (*Client, error) {
+		starts++
+		c, server := startFakeClient(t, map[string]func(json.RawMessage) interface{}{
+			"workspace/symbol": func(json.RawMessage) interface{} {
+				return []SymbolInformation{{Name: "ConversationHistory", Kind: 23}}
+			},
+		})
+		current = server
+		return c, nil
+	})
+	defer m.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	search := func(c *Client) error {
+		_, err := c.WorkspaceSymbols(ctx, "ConversationHistory")
+		return err
+	}
+
+	if err := m.ForEach(ctx, search); err != nil {
+		t.Fatalf("First search failed: %v", err)
+	}
+	if err := m.ForEach(ctx, search); err != nil {
+		t.Fatalf("Second search failed: %v", err)
+	}
+	if starts != 1 {
+		t.Errorf("Expected the server to be started once and reused, got %d starts", starts)
+	}
+
+	// The server crashes; the next request starts a new one
+	current.conn.Close()
+	if err := m.ForEach(ctx, search); err != nil {
+		t.Fatalf("Search after the server exited failed: %v", err)
+	}
+	if starts != 2 {
+		t.Errorf("Expected the exited server to be restarted, got %d starts", starts)
+	}
+
+	if err := m.ForFile(ctx, "notes.txt", search); err == nil {
+		t.Errorf("Expected an error for a file type without a language server")
+	}
+}
+
+func TestPositionForCountsUTF16(t *testing.T) {
+	text := "package main\n\tvar héllo😀 = x\n"
+
+	pos, err := PositionFor(text, 2, 0)
+	if err != nil {
+		t.Fatalf("PositionFor failed: %v", err)
+	}
+	if pos != (Position{Line: 1, Character: 1}) {
+		t.Errorf("Expected the first non-blank character, got %+v", pos)
+	}
+
+	// "x" follows "\tvar héllo😀 = ": the emoji takes two UTF-16 units
+	pos, err = PositionFor(text, 2, 15)
+	if err != nil {
+		t.Fatalf("PositionFor failed: %v", err)
+	}
+	if pos.Character != 15 {
+		t.Errorf("Expected character 15, got %d", pos.Character)
+	}
+
+	if _, err := PositionFor(text, 10, 1); err == nil {
+		t.Errorf("Expected an error for a line past the end of the file")
+	}
+}
+
+func TestDecodeDocumentSymbolsFlat(t *testing.T) {
+	raw := json.RawMessage(`[{"name":"main","kind":12,"location":{"uri":"file:///x.go","range":{"start":{"line":3,"character":5},"end":{"line":3,"character":9}}}}]`)
+	symbols, err := decodeDocumentSymbols(raw)
+	if err != nil {
+		t.Fatalf("decodeDocumentSymbols failed: %v", err)
+	}
+	if len(symbols) != 1 || symbols[0].Name != "main" || symbols[0].Kind.String() != "function" || symbols[0].SelectionRange.Start.Line != 3 {
+		t.Errorf("Unexpected symbols: %+v", symbols)
+	}
+}
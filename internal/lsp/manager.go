@@ -0,0 +1,151 @@
+package lsp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// StartFunc starts a language server; Start is used unless a Manager is given another
+type StartFunc func(ctx context.Context, command []string, rootDir string) (*Client, error)
+
+// Manager starts language servers on first use, one per configured command,
+// and restarts a server transparently when it has exited
+type Manager struct {
+	rootDir string
+	servers map[string]string // File extension (".go") -> server command line
+	start   StartFunc
+
+	mu      sync.Mutex
+	clients map[string]*Client // Command line -> running client
+}
+
+// NewManager creates a manager for the project at rootDir. servers maps file
+// extensions to the command line of the language server handling them.
+func NewManager(rootDir string, servers map[string]string) *Manager {
+	return &Manager{
+		rootDir: rootDir,
+		servers: servers,
+		start:   Start,
+		clients: make(map[string]*Client),
+	}
+}
+
+// SetStartFunc replaces how servers are started, e.g. with an in-process fake
+func (m *Manager) SetStartFunc(start StartFunc) {
+	m.start = start
+}
+
+// RootDir returns the project directory the servers are started in
+func (m *Manager) RootDir() string {
+	return m.rootDir
+}
+
+// Available reports whether any configured language server is installed
+func Available(servers map[string]string) bool {
+	for _, command := range servers {
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			continue
+		}
+		if _, err := exec.LookPath(fields[0]); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ForFile runs fn with the server handling path. If the server exited before
+// or during the request, it is restarted and fn is retried once.
+func (m *Manager) ForFile(ctx context.Context, path string, fn func(*Client) error) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	command, ok := m.servers[ext]
+	if !ok {
+		return fmt.Errorf("no language server is configured for %s files", ext)
+	}
+	return m.with(ctx, command, fn)
+}
+
+// ForEach runs fn with every configured server in turn, e.g. for workspace-wide
+// searches. Servers that cannot be started are skipped unless none can.
+func (m *Manager) ForEach(ctx context.Context, fn func(*Client) error) error {
+	var errs []error
+	for _, command := range m.commands() {
+		if err := m.with(ctx, command, fn); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 && len(errs) == len(m.commands()) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// Close shuts down every running server
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	clients := m.clients
+	m.clients = make(map[string]*Client)
+	m.mu.Unlock()
+
+	for _, c := range clients {
+		c.Close()
+	}
+	return nil
+}
+
+// with runs fn with the client for command, restarting the server once if it has exited
+func (m *Manager) with(ctx context.Context, command string, fn func(*Client) error) error {
+	for attempt := 0; ; attempt++ {
+		c, err := m.client(ctx, command)
+		if err != nil {
+			return err
+		}
+		err = fn(c)
+		if errors.Is(err, ErrServerExited) && attempt == 0 {
+			continue
+		}
+		return err
+	}
+}
+
+// client returns the running client for command, starting it if needed
+func (m *Manager) client(ctx context.Context, command string) (*Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.clients[command]; ok {
+		select {
+		case <-c.Done():
+			delete(m.clients, command)
+		default:
+			return c, nil
+		}
+	}
+
+	c, err := m.start(ctx, strings.Fields(command), m.rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start language server %q: %w", command, err)
+	}
+	m.clients[command] = c
+	return c, nil
+}
+
+// commands returns the distinct configured server command lines in a stable order
+func (m *Manager) commands() []string {
+	seen := make(map[string]bool)
+	var commands []string
+	for _, command := range m.servers {
+		if !seen[command] {
+			seen[command] = true
+			commands = append(commands, command)
+		}
+	}
+	sort.Strings(commands)
+	return commands
+}
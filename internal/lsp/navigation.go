@@ -0,0 +1,77 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Definition returns where the symbol at pos in path is defined
+func (c *Client) Definition(ctx context.Context, path string, pos Position) ([]Location, error) {
+	raw, err := c.positionRequest(ctx, "textDocument/definition", path, pos, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeLocations(raw)
+}
+
+// References returns the uses of the symbol at pos in path, excluding its declaration
+func (c *Client) References(ctx context.Context, path string, pos Position) ([]Location, error) {
+	extra := map[string]interface{}{"context": map[string]bool{"includeDeclaration": false}}
+	raw, err := c.positionRequest(ctx, "textDocument/references", path, pos, extra)
+	if err != nil {
+		return nil, err
+	}
+	return decodeLocations(raw)
+}
+
+// Hover returns the hover text (signature and documentation) for pos in path
+func (c *Client) Hover(ctx context.Context, path string, pos Position) (string, error) {
+	raw, err := c.positionRequest(ctx, "textDocument/hover", path, pos, nil)
+	if err != nil {
+		return "", err
+	}
+	return decodeHover(raw)
+}
+
+// DocumentSymbols returns the outline of path
+func (c *Client) DocumentSymbols(ctx context.Context, path string) ([]DocumentSymbol, error) {
+	uri, err := c.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw json.RawMessage
+	params := map[string]interface{}{"textDocument": TextDocumentIdentifier{URI: uri}}
+	if err := c.Call(ctx, "textDocument/documentSymbol", params, &raw); err != nil {
+		return nil, err
+	}
+	return decodeDocumentSymbols(raw)
+}
+
+// WorkspaceSymbols searches the whole project for symbols matching query
+func (c *Client) WorkspaceSymbols(ctx context.Context, query string) ([]SymbolInformation, error) {
+	var symbols []SymbolInformation
+	if err := c.Call(ctx, "workspace/symbol", map[string]string{"query": query}, &symbols); err != nil {
+		return nil, err
+	}
+	return symbols, nil
+}
+
+// positionRequest opens path and sends a request about pos, merging extra into the params
+func (c *Client) positionRequest(ctx context.Context, method, path string, pos Position, extra map[string]interface{}) (json.RawMessage, error) {
+	uri, err := c.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]interface{}{
+		"textDocument": TextDocumentIdentifier{URI: uri},
+		"position":     pos,
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	var raw json.RawMessage
+	if err := c.Call(ctx, method, params, &raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
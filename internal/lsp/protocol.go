@@ -0,0 +1,247 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// The subset of the Language Server Protocol used by the code navigation tools.
+// Lines and characters are zero-based; characters count UTF-16 code units.
+
+// Position is a location in a text document
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Range is a span in a text document
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Location is a range inside a document
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+// locationLink is the alternative definition result some servers return
+type locationLink struct {
+	TargetURI            string `json:"targetUri"`
+	TargetSelectionRange Range  `json:"targetSelectionRange"`
+}
+
+// TextDocumentIdentifier names a document
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+// SymbolKind classifies a symbol
+type SymbolKind int
+
+var symbolKindNames = map[SymbolKind]string{
+	1: "file", 2: "module", 3: "namespace", 4: "package", 5: "class", 6: "method",
+	7: "property", 8: "field", 9: "constructor", 10: "enum", 11: "interface",
+	12: "function", 13: "variable", 14: "constant", 15: "string", 16: "number",
+	17: "boolean", 18: "array", 19: "object", 20: "key", 21: "null",
+	22: "enum member", 23: "struct", 24: "event", 25: "operator", 26: "type parameter",
+}
+
+// String returns the protocol's name for the kind
+func (k SymbolKind) String() string {
+	if name, ok := symbolKindNames[k]; ok {
+		return name
+	}
+	return "symbol"
+}
+
+// DocumentSymbol is a symbol in a document outline
+type DocumentSymbol struct {
+	Name           string           `json:"name"`
+	Detail         string           `json:"detail,omitempty"`
+	Kind           SymbolKind       `json:"kind"`
+	Range          Range            `json:"range"`
+	SelectionRange Range            `json:"selectionRange"`
+	Children       []DocumentSymbol `json:"children,omitempty"`
+}
+
+// SymbolInformation is a symbol found by a workspace search or a flat outline
+type SymbolInformation struct {
+	Name          string     `json:"name"`
+	Kind          SymbolKind `json:"kind"`
+	Location      Location   `json:"location"`
+	ContainerName string     `json:"containerName,omitempty"`
+}
+
+// FileURI converts an absolute path to a file:// URI
+func FileURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// URIPath converts a file:// URI back to a path
+func URIPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// decodeLocations decodes a definition result, which may be a Location, a list
+// of Locations or a list of LocationLinks
+func decodeLocations(raw json.RawMessage) ([]Location, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] == '{' {
+		var loc Location
+		if err := json.Unmarshal(raw, &loc); err != nil {
+			return nil, err
+		}
+		return []Location{loc}, nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, err
+	}
+	locations := make([]Location, 0, len(items))
+	for _, item := range items {
+		var link locationLink
+		if err := json.Unmarshal(item, &link); err == nil && link.TargetURI != "" {
+			locations = append(locations, Location{URI: link.TargetURI, Range: link.TargetSelectionRange})
+			continue
+		}
+		var loc Location
+		if err := json.Unmarshal(item, &loc); err != nil {
+			return nil, err
+		}
+		locations = append(locations, loc)
+	}
+	return locations, nil
+}
+
+// decodeDocumentSymbols decodes a documentSymbol result, which is either a
+// hierarchy of DocumentSymbols or a flat list of SymbolInformation
+func decodeDocumentSymbols(raw json.RawMessage) ([]DocumentSymbol, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var probe []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return nil, err
+	}
+	if len(probe) == 0 {
+		return nil, nil
+	}
+	if _, flat := probe[0]["location"]; !flat {
+		var symbols []DocumentSymbol
+		err := json.Unmarshal(raw, &symbols)
+		return symbols, err
+	}
+
+	var infos []SymbolInformation
+	if err := json.Unmarshal(raw, &infos); err != nil {
+		return nil, err
+	}
+	symbols := make([]DocumentSymbol, len(infos))
+	for i, info := range infos {
+		symbols[i] = DocumentSymbol{Name: info.Name, Kind: info.Kind, Range: info.Location.Range, SelectionRange: info.Location.Range}
+	}
+	return symbols, nil
+}
+
+// decodeHover extracts the text of a hover result, whose contents may be
+// MarkupContent, a MarkedString or a list of MarkedStrings
+func decodeHover(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var hover struct {
+		Contents json.RawMessage `json:"contents"`
+	}
+	if err := json.Unmarshal(raw, &hover); err != nil {
+		return "", err
+	}
+	return markedText(hover.Contents), nil
+}
+
+// markedText flattens hover contents into plain text
+func markedText(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var markup struct {
+		Kind     string `json:"kind"`
+		Language string `json:"language"`
+		Value    string `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &markup); err == nil && markup.Value != "" {
+		if markup.Language != "" {
+			return "```" + markup.Language + "\n" + markup.Value + "\n```"
+		}
+		return markup.Value
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err == nil {
+		parts := make([]string, 0, len(list))
+		for _, item := range list {
+			if text := markedText(item); text != "" {
+				parts = append(parts, text)
+			}
+		}
+		return strings.Join(parts, "\n\n")
+	}
+	return ""
+}
+
+// languageIDs maps file extensions to LSP language identifiers
+var languageIDs = map[string]string{
+	".go":  "go",
+	".py":  "python",
+	".js":  "javascript",
+	".jsx": "javascriptreact",
+	".ts":  "typescript",
+	".tsx": "typescriptreact",
+	".rs":  "rust",
+	".c":   "c",
+	".h":   "c",
+	".cpp": "cpp",
+	".rb":  "ruby",
+}
+
+// languageID returns the language identifier for a file
+func languageID(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if id, ok := languageIDs[ext]; ok {
+		return id
+	}
+	return strings.TrimPrefix(ext, ".")
+}
+
+// PositionFor converts a 1-based line and character column in text to an LSP
+// position. A column of 0 selects the first non-blank character of the line.
+func PositionFor(text string, line, column int) (Position, error) {
+	lines := strings.Split(text, "\n")
+	if line < 1 || line > len(lines) {
+		return Position{}, fmt.Errorf("line %d is out of range (file has %d lines)", line, len(lines))
+	}
+	runes := []rune(strings.TrimSuffix(lines[line-1], "\r"))
+	if column <= 0 {
+		column = 1
+		for column <= len(runes) && unicode.IsSpace(runes[column-1]) {
+			column++
+		}
+	}
+	if column > len(runes)+1 {
+		return Position{}, fmt.Errorf("column %d is out of range (line %d has %d characters)", column, line, len(runes))
+	}
+	return Position{Line: line - 1, Character: len(utf16.Encode(runes[:column-1]))}, nil
+}
@@ -0,0 +1,224 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+// FileName is the memory file inside a project's .codex directory
+const FileName = "memory.json"
+
+// Entry is a single remembered fact
+type Entry struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	LastUsed  time.Time `json:"last_used"`
+}
+
+// Store is a persistent per-project memory of key/value facts
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]*Entry
+}
+
+// PathFor returns the memory file path for a project directory
+func PathFor(projectDir string) string {
+	return filepath.Join(projectDir, ".codex", FileName)
+}
+
+// OpenProject loads the store of the project cfg's tools work in, which is
+// not necessarily the directory codex was started in
+func OpenProject(cfg *config.Config) (*Store, error) {
+	return Open(PathFor(cfg.ToolDir()))
+}
+
+// Open loads the store at path. A missing file is an empty store.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		entries: make(map[string]*Entry),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read memory file: %w", err)
+	}
+
+	var entries []*Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse memory file %s: %w", path, err)
+	}
+	for _, e := range entries {
+		s.entries[e.Key] = e
+	}
+	return s, nil
+}
+
+// Path returns the file the store is saved to
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Remember stores value under key, replacing any previous value
+func (s *Store) Remember(key, value string) error {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return fmt.Errorf("memory key cannot be empty")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if e, exists := s.entries[key]; exists {
+		e.Value = value
+		e.UpdatedAt = now
+		e.LastUsed = now
+	} else {
+		s.entries[key] = &Entry{Key: key, Value: value, CreatedAt: now, UpdatedAt: now, LastUsed: now}
+	}
+	return s.save()
+}
+
+// Recall returns the entries whose key or value contains any word of query,
+// best matches first. Matched entries are marked as recently used.
+func (s *Store) Recall(query string) ([]Entry, error) {
+	terms := strings.Fields(strings.ToLower(query))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type match struct {
+		entry *Entry
+		score int
+	}
+	var matches []match
+	for _, e := range s.entries {
+		text := strings.ToLower(e.Key + " " + e.Value)
+		score := 0
+		for _, term := range terms {
+			if strings.Contains(text, term) {
+				score++
+			}
+		}
+		if score > 0 || len(terms) == 0 {
+			matches = append(matches, match{e, score})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].entry.LastUsed.After(matches[j].entry.LastUsed)
+	})
+
+	now := time.Now()
+	result := make([]Entry, len(matches))
+	for i, m := range matches {
+		m.entry.LastUsed = now
+		result[i] = *m.entry
+	}
+	if len(matches) > 0 {
+		if err := s.save(); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Forget removes key, reporting whether it existed
+func (s *Store) Forget(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.entries[key]; !exists {
+		return false, nil
+	}
+	delete(s.entries, key)
+	return true, s.save()
+}
+
+// List returns all entries, most recently used first
+func (s *Store) List() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sorted()
+}
+
+// PromptSection renders the entries for the system prompt, most recently used
+// first, stopping before maxBytes is exceeded. It returns "" for an empty store.
+func (s *Store) PromptSection(maxBytes int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.entries) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("Project memory (facts remembered from earlier sessions; use the remember tool to add more):\n")
+	omitted := 0
+	for _, e := range s.sorted() {
+		line := fmt.Sprintf("- %s: %s\n", e.Key, e.Value)
+		if maxBytes > 0 && b.Len()+len(line) > maxBytes {
+			omitted++
+			continue
+		}
+		b.WriteString(line)
+	}
+	if omitted > 0 {
+		fmt.Fprintf(&b, "(%d more entries omitted; use the recall tool to search them)\n", omitted)
+	}
+	return b.String()
+}
+
+// sorted returns copies of the entries, most recently used first. Caller must hold s.mu.
+func (s *Store) sorted() []Entry {
+	entries := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].LastUsed.Equal(entries[j].LastUsed) {
+			return entries[i].LastUsed.After(entries[j].LastUsed)
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// save writes the store atomically. Caller must hold s.mu.
+func (s *Store) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create memory directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal memory: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write memory file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace memory file: %w", err)
+	}
+	return nil
+}
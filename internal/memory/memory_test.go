@@ -0,0 +1,87 @@
+package memory
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+func TestStoreRememberRecallForget(t *testing.T) {
+	path := PathFor(t.TempDir())
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	if err := store.Remember("tests", "integration tests require TESTCONTAINERS=1"); err != nil {
+		t.Fatalf("Remember failed: %v", err)
+	}
+	if err := store.Remember("vendor", "never touch vendored code"); err != nil {
+		t.Fatalf("Remember failed: %v", err)
+	}
+
+	// A reopened store sees the persisted entries
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	entries, err := reopened.Recall("Vendored")
+	if err != nil {
+		t.Fatalf("Recall failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Key != "vendor" {
+		t.Fatalf("Expected the vendor entry, got %+v", entries)
+	}
+
+	// Recall marks entries as used, so they come first in the prompt
+	section := reopened.PromptSection(0)
+	if strings.Index(section, "vendor") > strings.Index(section, "tests") {
+		t.Errorf("Expected the most recently used entry first, got:\n%s", section)
+	}
+
+	removed, err := reopened.Forget("tests")
+	if err != nil || !removed {
+		t.Fatalf("Expected tests to be forgotten, got %t, %v", removed, err)
+	}
+	if entries := reopened.List(); len(entries) != 1 {
+		t.Errorf("Expected 1 entry after forget, got %d", len(entries))
+	}
+}
+
+func TestPromptSectionIsSizeCapped(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), FileName))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if store.PromptSection(100) != "" {
+		t.Errorf("Expected an empty section for an empty store")
+	}
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		store.Remember(key, strings.Repeat("x", 40))
+	}
+	section := store.PromptSection(200)
+	if !strings.Contains(section, "more entries omitted") {
+		t.Errorf("Expected omitted entries to be noted, got:\n%s", section)
+	}
+}
+
+func TestOpenProjectUsesTheToolDir(t *testing.T) {
+	launchDir, projectDir := t.TempDir(), t.TempDir()
+	store, err := OpenProject(&config.Config{CWD: launchDir, WorkingDir: projectDir})
+	if err != nil {
+		t.Fatalf("OpenProject failed: %v", err)
+	}
+	if err := store.Remember("build", "make all"); err != nil {
+		t.Fatalf("Remember failed: %v", err)
+	}
+	if _, err := os.Stat(PathFor(projectDir)); err != nil {
+		t.Errorf("Expected the memory in the tool directory: %v", err)
+	}
+	if _, err := os.Stat(PathFor(launchDir)); !os.IsNotExist(err) {
+		t.Errorf("Expected no memory in the launch directory, got %v", err)
+	}
+}
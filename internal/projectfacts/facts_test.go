@@ -0,0 +1,158 @@
+package projectfacts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+}
+
+func TestDetectGoModule(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "go.mod", "module github.com/example/tool\n\ngo 1.23\n")
+
+	facts := Detect(dir, DefaultProbes)
+	if facts.Language != "Go" {
+		t.Errorf("Expected language Go, got %q", facts.Language)
+	}
+	if facts.Build != "go build ./..." || facts.Test != "go test ./..." || facts.Lint != "go vet ./..." {
+		t.Errorf("Expected the go toolchain commands, got %+v", facts)
+	}
+	if len(facts.Details) != 1 || facts.Details[0] != "Go module github.com/example/tool (go 1.23)" {
+		t.Errorf("Expected the module detail, got %v", facts.Details)
+	}
+}
+
+func TestDetectPackageScripts(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "package.json", `{"name":"web","scripts":{"build":"tsc","test":"jest","dev":"vite"}}`)
+	writeFile(t, dir, "tsconfig.json", "{}")
+	writeFile(t, dir, "yarn.lock", "")
+
+	facts := Detect(dir, DefaultProbes)
+	if facts.Language != "TypeScript" {
+		t.Errorf("Expected language TypeScript, got %q", facts.Language)
+	}
+	if facts.Build != "yarn run build" || facts.Test != "yarn run test" {
+		t.Errorf("Expected yarn script commands, got %+v", facts)
+	}
+	if facts.Lint != "" {
+		t.Errorf("Expected no lint command without a lint script, got %q", facts.Lint)
+	}
+}
+
+func TestMakefileTargetsWinOverToolchain(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "Cargo.toml", "[package]\nname = \"x\"\n")
+	writeFile(t, dir, "Makefile", "VERSION := 1\n.PHONY: build test\nbuild:\n\tcargo build --release\ntest: build\n\tcargo test\n")
+
+	facts := Detect(dir, DefaultProbes)
+	if facts.Language != "Rust" {
+		t.Errorf("Expected language Rust, got %q", facts.Language)
+	}
+	if facts.Build != "make build" || facts.Test != "make test" {
+		t.Errorf("Expected make targets, got %+v", facts)
+	}
+	if facts.Lint != "cargo clippy" {
+		t.Errorf("Expected cargo clippy without a lint target, got %q", facts.Lint)
+	}
+	if last := facts.Details[len(facts.Details)-1]; last != "Makefile targets: build, test" {
+		t.Errorf("Expected the Makefile targets detail, got %q", last)
+	}
+}
+
+func TestDetectComposeAndCI(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "compose.yaml", "services: {}\n")
+	writeFile(t, dir, ".github/workflows/ci.yml", "on: push\n")
+
+	facts := Detect(dir, DefaultProbes)
+	section := facts.PromptSection()
+	for _, want := range []string{"Docker Compose services defined in compose.yaml", "CI: GitHub Actions (.github/workflows)"} {
+		if !strings.Contains(section, want) {
+			t.Errorf("Expected %q in the prompt section, got:\n%s", want, section)
+		}
+	}
+	if strings.Contains(section, "Primary language") {
+		t.Errorf("Expected no language line without a language probe match")
+	}
+}
+
+func TestLoadUsesCacheUntilProbedFilesChange(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "go.mod", "module example.com/a\n")
+
+	if facts := Load(dir); facts.Language != "Go" {
+		t.Fatalf("Expected language Go, got %q", facts.Language)
+	}
+	if _, err := os.Stat(CachePathFor(dir)); err != nil {
+		t.Fatalf("Expected the cache to be written: %v", err)
+	}
+
+	// A stale cache entry is served while the fingerprint matches
+	cache, _ := os.ReadFile(CachePathFor(dir))
+	os.WriteFile(CachePathFor(dir), []byte(strings.Replace(string(cache), `"language": "Go"`, `"language": "Cached"`, 1)), 0644)
+	if facts := Load(dir); facts.Language != "Cached" {
+		t.Errorf("Expected the cached language, got %q", facts.Language)
+	}
+
+	// Adding a probed file invalidates it
+	writeFile(t, dir, "Makefile", "test:\n\tgo test ./...\n")
+	facts := Load(dir)
+	if facts.Language != "Go" || facts.Test != "make test" {
+		t.Errorf("Expected re-detected facts, got %+v", facts)
+	}
+
+	// So does changing one
+	later := time.Now().Add(time.Minute)
+	writeFile(t, dir, "go.mod", "module example.com/renamed\n")
+	os.Chtimes(filepath.Join(dir, "go.mod"), later, later)
+	if facts := Load(dir); facts.Details[0] != "Go module example.com/renamed" {
+		t.Errorf("Expected the renamed module, got %v", facts.Details)
+	}
+}
+
+func TestLoadSkipsCacheForUnknownProject(t *testing.T) {
+	dir := t.TempDir()
+	if facts := Load(dir); !facts.IsEmpty() {
+		t.Errorf("Expected no facts, got %+v", facts)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".codex")); !os.IsNotExist(err) {
+		t.Errorf("Expected no .codex directory for a project without facts")
+	}
+}
+
+func TestCodexMdOverridesDetectedFacts(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "go.mod", "module example.com/a\n")
+	writeFile(t, dir, "codex.md", "# Notes\ntest: not a fact\n\n## Project facts\n- test: `go test -short ./...`\n- lint: golangci-lint run\n\n## Other\nbuild: ignored\n")
+
+	facts := Load(dir, filepath.Join(dir, "codex.md"), filepath.Join(dir, "missing.md"))
+	if facts.Test != "go test -short ./..." {
+		t.Errorf("Expected the codex.md test command, got %q", facts.Test)
+	}
+	if facts.Lint != "golangci-lint run" {
+		t.Errorf("Expected the codex.md lint command, got %q", facts.Lint)
+	}
+	if facts.Build != "go build ./..." {
+		t.Errorf("Expected the detected build command, got %q", facts.Build)
+	}
+
+	// Overrides are not cached, so editing codex.md takes effect at once
+	cached, _ := os.ReadFile(CachePathFor(dir))
+	if strings.Contains(string(cached), "golangci-lint") {
+		t.Errorf("Expected overrides to stay out of the cache")
+	}
+}
@@ -0,0 +1,182 @@
+package projectfacts
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Probe inspects one kind of project file and records the facts it implies.
+// Files lists the paths, relative to the project directory, the probe reads;
+// a change to any of them invalidates the cached facts.
+type Probe struct {
+	Name   string
+	Files  []string
+	Detect func(dir string, facts *Facts)
+}
+
+// DefaultProbes are run in order. Language probes only fill in facts that are
+// still unknown, so the first matching language is the primary one; the
+// Makefile probe runs last because its targets wrap the toolchain commands.
+var DefaultProbes = []Probe{
+	{Name: "go", Files: []string{"go.mod"}, Detect: detectGo},
+	{Name: "node", Files: []string{"package.json", "tsconfig.json"}, Detect: detectNode},
+	{Name: "rust", Files: []string{"Cargo.toml"}, Detect: detectRust},
+	{Name: "python", Files: []string{"pyproject.toml", "setup.py", "requirements.txt"}, Detect: detectPython},
+	{Name: "docker-compose", Files: []string{"docker-compose.yml", "docker-compose.yaml", "compose.yml", "compose.yaml"}, Detect: detectCompose},
+	{Name: "ci", Files: []string{".github/workflows", ".gitlab-ci.yml", ".circleci/config.yml", "Jenkinsfile"}, Detect: detectCI},
+	{Name: "make", Files: []string{"Makefile"}, Detect: detectMake},
+}
+
+// goDirective matches the module and go lines of a go.mod file
+var goDirective = regexp.MustCompile(`^(module|go)\s+(\S+)`)
+
+func detectGo(dir string, facts *Facts) {
+	data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return
+	}
+	var module, version string
+	for _, line := range strings.Split(string(data), "\n") {
+		if m := goDirective.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			if m[1] == "module" {
+				module = strings.Trim(m[2], `"`)
+			} else {
+				version = m[2]
+			}
+		}
+	}
+	facts.setLanguage("Go")
+	facts.setDefault(&facts.Build, "go build ./...")
+	facts.setDefault(&facts.Test, "go test ./...")
+	facts.setDefault(&facts.Lint, "go vet ./...")
+	detail := "Go module " + module
+	if version != "" {
+		detail += " (go " + version + ")"
+	}
+	facts.addDetail(detail)
+}
+
+func detectNode(dir string, facts *Facts) {
+	data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return
+	}
+	var pkg struct {
+		Name    string            `json:"name"`
+		Scripts map[string]string `json:"scripts"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return
+	}
+
+	language := "JavaScript"
+	if _, err := os.Stat(filepath.Join(dir, "tsconfig.json")); err == nil {
+		language = "TypeScript"
+	}
+	facts.setLanguage(language)
+
+	runner := "npm"
+	switch {
+	case exists(dir, "pnpm-lock.yaml"):
+		runner = "pnpm"
+	case exists(dir, "yarn.lock"):
+		runner = "yarn"
+	}
+	for script, field := range map[string]*string{"build": &facts.Build, "test": &facts.Test, "lint": &facts.Lint} {
+		if _, ok := pkg.Scripts[script]; ok {
+			facts.setDefault(field, runner+" run "+script)
+		}
+	}
+	if len(pkg.Scripts) > 0 {
+		facts.addDetail("package.json scripts: " + strings.Join(sortedKeys(pkg.Scripts), ", "))
+	}
+}
+
+func detectRust(dir string, facts *Facts) {
+	if !exists(dir, "Cargo.toml") {
+		return
+	}
+	facts.setLanguage("Rust")
+	facts.setDefault(&facts.Build, "cargo build")
+	facts.setDefault(&facts.Test, "cargo test")
+	facts.setDefault(&facts.Lint, "cargo clippy")
+}
+
+func detectPython(dir string, facts *Facts) {
+	if !exists(dir, "pyproject.toml") && !exists(dir, "setup.py") && !exists(dir, "requirements.txt") {
+		return
+	}
+	facts.setLanguage("Python")
+	facts.setDefault(&facts.Test, "pytest")
+}
+
+func detectCompose(dir string, facts *Facts) {
+	for _, name := range []string{"docker-compose.yml", "docker-compose.yaml", "compose.yml", "compose.yaml"} {
+		if exists(dir, name) {
+			facts.addDetail("Docker Compose services defined in " + name)
+			return
+		}
+	}
+}
+
+func detectCI(dir string, facts *Facts) {
+	systems := []struct{ path, name string }{
+		{".github/workflows", "GitHub Actions"},
+		{".gitlab-ci.yml", "GitLab CI"},
+		{".circleci/config.yml", "CircleCI"},
+		{"Jenkinsfile", "Jenkins"},
+	}
+	for _, system := range systems {
+		if exists(dir, system.path) {
+			facts.addDetail("CI: " + system.name + " (" + system.path + ")")
+		}
+	}
+}
+
+// makeTarget matches a rule's target names, skipping variable assignments
+var makeTarget = regexp.MustCompile(`^([A-Za-z0-9_.\-/ ]+):([^=]|$)`)
+
+func detectMake(dir string, facts *Facts) {
+	f, err := os.Open(filepath.Join(dir, "Makefile"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var targets []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := makeTarget.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		for _, target := range strings.Fields(m[1]) {
+			if !strings.HasPrefix(target, ".") && !seen[target] {
+				seen[target] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	// Makefile targets are how the project expects to be built, so they win
+	for target, field := range map[string]*string{"build": &facts.Build, "test": &facts.Test, "lint": &facts.Lint} {
+		if seen[target] {
+			*field = "make " + target
+		}
+	}
+	facts.addDetail("Makefile targets: " + strings.Join(targets, ", "))
+}
+
+// exists reports whether name exists in dir
+func exists(dir, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, name))
+	return err == nil
+}
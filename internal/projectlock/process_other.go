@@ -0,0 +1,20 @@
+//go:build !unix
+
+package projectlock
+
+import "os"
+
+// processAlive reports whether a process with the given PID may be running.
+// Where a process cannot be found by its PID, as on Windows, it is not;
+// platforms that cannot tell take it to be running, so its lock is kept.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
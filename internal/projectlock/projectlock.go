@@ -0,0 +1,203 @@
+// Package projectlock keeps two codex processes from working in the same
+// project at once. The process working in a project holds its .codex/lock
+// file, which records the process ID and start time; the start time tells a
+// live holder from an unrelated process that was given the same PID later.
+package projectlock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileName is the lock file inside a project's .codex directory
+const FileName = "lock"
+
+// startSlack is how far a holder's recorded start time may be from the start
+// time of the process with its PID for the two to be the same process
+const startSlack = 2 * time.Second
+
+// PathFor returns the lock file path for a project directory
+func PathFor(projectDir string) string {
+	return filepath.Join(projectDir, ".codex", FileName)
+}
+
+// Holder is the process a lock file names
+type Holder struct {
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"` // When the process started
+}
+
+// Alive reports whether the holder is still running: a process with its PID
+// exists and, where the platform reports it, started when the holder did
+func (h Holder) Alive() bool {
+	if !processAlive(h.PID) {
+		return false
+	}
+	started, ok := processStartTime(h.PID)
+	if !ok {
+		return true
+	}
+	diff := started.Sub(h.Started)
+	return diff > -startSlack && diff < startSlack
+}
+
+// HeldError is returned by Acquire when a live process holds the lock
+type HeldError struct {
+	Path   string
+	Holder Holder
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("another codex process (pid %d, started %s) is working in this project (%s)", e.Holder.PID, e.Holder.Started.Local().Format("Jan 2 15:04:05"), e.Path)
+}
+
+// ErrLost is returned by Verify once another process took the lock over
+var ErrLost = errors.New("the project lock was taken over by another codex process")
+
+// Lock is a project lock held by this process
+type Lock struct {
+	path string
+	self Holder
+}
+
+// self describes the current process
+func self() Holder {
+	started, ok := processStartTime(os.Getpid())
+	if !ok {
+		started = time.Now()
+	}
+	return Holder{PID: os.Getpid(), Started: started}
+}
+
+// Acquire takes the lock of projectDir. A lock left behind by a process that
+// exited, or naming a PID now used by a process started at another time, is
+// stale and taken over; a lock held by a live process fails with *HeldError.
+func Acquire(projectDir string) (*Lock, error) {
+	path := PathFor(projectDir)
+	lock := &Lock{path: path, self: self()}
+	for attempt := 0; ; attempt++ {
+		err := lock.create()
+		if err == nil {
+			return lock, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		holder, readErr := Read(path)
+		if readErr == nil && holder.PID != lock.self.PID && holder.Alive() {
+			return nil, &HeldError{Path: path, Holder: holder}
+		}
+		if attempt > 0 {
+			// Another process replaced the stale lock first
+			return nil, &HeldError{Path: path, Holder: holder}
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove stale project lock: %w", err)
+		}
+	}
+}
+
+// Steal takes the lock of projectDir over once its holder is no longer
+// running; a holder that still runs fails it with *HeldError. A previous
+// holder that was alive after all finds out with Verify and stops writing.
+func Steal(projectDir string) (*Lock, error) {
+	lock := &Lock{path: PathFor(projectDir), self: self()}
+	if holder, err := Read(lock.path); err == nil && holder.PID != lock.self.PID && holder.Alive() {
+		return nil, &HeldError{Path: lock.path, Holder: holder}
+	}
+	tmp, err := lock.writeTemp()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, lock.path); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to replace project lock: %w", err)
+	}
+	return lock, nil
+}
+
+// Read returns the holder named by the lock file at path
+func Read(path string) (Holder, error) {
+	var holder Holder
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return holder, err
+	}
+	if err := json.Unmarshal(data, &holder); err != nil {
+		return holder, fmt.Errorf("failed to parse project lock %s: %w", path, err)
+	}
+	return holder, nil
+}
+
+// Path returns the lock file path
+func (l *Lock) Path() string {
+	return l.path
+}
+
+// Verify returns ErrLost once the lock file no longer names this process
+func (l *Lock) Verify() error {
+	holder, err := Read(l.path)
+	if err != nil || holder.PID != l.self.PID || !holder.Started.Equal(l.self.Started) {
+		return ErrLost
+	}
+	return nil
+}
+
+// Release removes the lock file unless another process took it over
+func (l *Lock) Release() error {
+	if l.Verify() != nil {
+		return nil
+	}
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove project lock: %w", err)
+	}
+	return nil
+}
+
+// create writes the lock file, failing with an os.IsExist error when one
+// exists. The file is written aside and linked into place, so it is never
+// seen empty.
+func (l *Lock) create() error {
+	tmp, err := l.writeTemp()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := os.Link(tmp, l.path); err != nil {
+		if os.IsExist(err) {
+			return err
+		}
+		return fmt.Errorf("failed to create project lock: %w", err)
+	}
+	return nil
+}
+
+// writeTemp writes the lock's content to a temporary file next to the lock
+func (l *Lock) writeTemp() (string, error) {
+	dir := filepath.Dir(l.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	data, err := json.Marshal(l.self)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode project lock: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, FileName+"-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to write project lock: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write project lock: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write project lock: %w", err)
+	}
+	return tmp.Name(), nil
+}
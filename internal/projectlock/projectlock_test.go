@@ -0,0 +1,134 @@
+package projectlock
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// writeHolder makes holder the owner of dir's lock
+func writeHolder(t *testing.T, dir string, holder Holder) {
+	t.Helper()
+	data, _ := json.Marshal(holder)
+	if err := os.MkdirAll(filepath.Join(dir, ".codex"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(PathFor(dir), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// liveHolder describes the parent process, which outlives the test
+func liveHolder(t *testing.T) Holder {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("processes cannot be probed on windows")
+	}
+	started, ok := processStartTime(os.Getppid())
+	if !ok {
+		started = time.Now()
+	}
+	return Holder{PID: os.Getppid(), Started: started}
+}
+
+func TestAcquireAndRelease(t *testing.T) {
+	dir := t.TempDir()
+	lock, err := Acquire(dir)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	holder, err := Read(PathFor(dir))
+	if err != nil || holder.PID != os.Getpid() {
+		t.Fatalf("Expected the lock to name this process, got %+v (%v)", holder, err)
+	}
+	if err := lock.Verify(); err != nil {
+		t.Errorf("Expected the lock to be held, got %v", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if _, err := os.Stat(PathFor(dir)); !os.IsNotExist(err) {
+		t.Errorf("Expected the lock file to be removed, got %v", err)
+	}
+}
+
+func TestAcquireHeldByLiveProcess(t *testing.T) {
+	dir := t.TempDir()
+	writeHolder(t, dir, liveHolder(t))
+
+	_, err := Acquire(dir)
+	var held *HeldError
+	if !errors.As(err, &held) || held.Holder.PID != os.Getppid() {
+		t.Fatalf("Expected a HeldError naming the parent process, got %v", err)
+	}
+}
+
+func TestAcquireTakesOverStaleLocks(t *testing.T) {
+	cmd := exec.Command("go", "version")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run a process: %v", err)
+	}
+	stale := map[string]Holder{"exited": {PID: cmd.Process.Pid, Started: time.Now().Add(-time.Minute)}}
+	if live := liveHolder(t); live.Started.Before(time.Now().Add(-startSlack)) {
+		// The parent's PID, as if it had been reused after the holder exited
+		live.Started = live.Started.Add(-time.Hour)
+		stale["reused PID"] = live
+	}
+
+	for name, holder := range stale {
+		dir := t.TempDir()
+		writeHolder(t, dir, holder)
+		lock, err := Acquire(dir)
+		if err != nil {
+			t.Errorf("%s: expected the stale lock to be taken over, got %v", name, err)
+			continue
+		}
+		if err := lock.Verify(); err != nil {
+			t.Errorf("%s: expected the lock to be held, got %v", name, err)
+		}
+	}
+}
+
+func TestStealMakesTheHolderLoseTheLock(t *testing.T) {
+	dir := t.TempDir()
+	first, err := Acquire(dir)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	// Pose as another process holding the lock
+	first.self.Started = first.self.Started.Add(-time.Hour)
+	writeHolder(t, dir, first.self)
+
+	second, err := Steal(dir)
+	if err != nil {
+		t.Fatalf("Steal failed: %v", err)
+	}
+	if err := first.Verify(); !errors.Is(err, ErrLost) {
+		t.Errorf("Expected the first holder to have lost the lock, got %v", err)
+	}
+	if err := first.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if err := second.Verify(); err != nil {
+		t.Errorf("Expected releasing a lost lock to leave the new holder's, got %v", err)
+	}
+}
+
+func TestStealRefusesLiveHolder(t *testing.T) {
+	dir := t.TempDir()
+	writeHolder(t, dir, liveHolder(t))
+
+	_, err := Steal(dir)
+	var held *HeldError
+	if !errors.As(err, &held) || held.Holder.PID != os.Getppid() {
+		t.Fatalf("Expected a HeldError naming the parent process, got %v", err)
+	}
+	if holder, err := Read(PathFor(dir)); err != nil || holder.PID != os.Getppid() {
+		t.Errorf("Expected the lock to be left to its holder, got %+v (%v)", holder, err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package projectlock
+
+import "time"
+
+// processStartTime cannot tell when a process started on this platform; a
+// live PID is taken to be the lock holder
+func processStartTime(pid int) (time.Time, bool) {
+	return time.Time{}, false
+}
@@ -0,0 +1,143 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Recorder records the session of an agent into a bundle. The bundle is
+// written whenever an input arrives or a response stream ends, and on Close,
+// so a session that ends abruptly still leaves most of it behind.
+type Recorder struct {
+	agent *agent.OpenAIAgent
+	path  string
+
+	mu      sync.Mutex
+	bundle  Bundle
+	started bool // The first input arrived and Initial was captured
+	closed  bool
+	err     error // First failure to write the bundle
+}
+
+// Record starts recording the session of a into the bundle at path. The
+// history as of the first input is the starting point of the replay.
+func Record(a *agent.OpenAIAgent, path string, model string) *Recorder {
+	r := &Recorder{agent: a, path: path, bundle: Bundle{Version: Version, Recorded: time.Now(), Model: model}}
+	a.SetStreamSource(recordingSource{recorder: r, base: a.StreamSource()})
+	a.RegisterResponseHook(r.recordItem)
+	a.RegisterInputHook(r.recordInput)
+	return r
+}
+
+// Close writes the bundle a final time and stops recording. It returns the
+// first error met writing the bundle.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.saveLocked()
+		r.closed = true
+	}
+	return r.err
+}
+
+// recordInput adds an input, capturing the starting history at the first
+func (r *Recorder) recordInput(input agent.Input) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	if !r.started {
+		r.started = true
+		r.bundle.Initial, r.bundle.WorkingDir = r.agent.HistorySnapshot()
+	}
+	r.bundle.Inputs = append(r.bundle.Inputs, input)
+	r.saveLocked()
+}
+
+// recordItem is a response hook adding every item, and the tool calls among
+// them, to the bundle
+func (r *Recorder) recordItem(item agent.ResponseItem) (agent.ResponseItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.bundle.Items = append(r.bundle.Items, item)
+		if item.Type == "function_call" && item.FunctionCall != nil {
+			r.bundle.Dispatches = append(r.bundle.Dispatches, *item.FunctionCall)
+		}
+	}
+	return item, nil
+}
+
+// saveLocked writes the bundle with the history as it is now. The caller must hold r.mu.
+func (r *Recorder) saveLocked() {
+	r.bundle.History, _ = r.agent.HistorySnapshot()
+	if err := r.bundle.Save(r.path); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// recordingSource opens streams from base and records them
+type recordingSource struct {
+	recorder *Recorder
+	base     agent.StreamSource
+}
+
+func (s recordingSource) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (agent.ChatStream, error) {
+	r := s.recorder
+	request, _ := json.Marshal(req)
+	stream, err := s.base.CreateChatCompletionStream(ctx, req)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return stream, err
+	}
+	r.bundle.Exchanges = append(r.bundle.Exchanges, Exchange{Request: request})
+	index := len(r.bundle.Exchanges) - 1
+	if err != nil {
+		r.bundle.Exchanges[index].OpenError = err.Error()
+		r.saveLocked()
+		return nil, err
+	}
+	return &recordingStream{ChatStream: stream, recorder: r, index: index}, nil
+}
+
+// recordingStream adds the chunks it reads to its exchange
+type recordingStream struct {
+	agent.ChatStream
+	recorder *Recorder
+	index    int
+	ended    bool
+}
+
+func (s *recordingStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	response, err := s.ChatStream.Recv()
+	r := s.recorder
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || s.ended {
+		return response, err
+	}
+	exchange := &r.bundle.Exchanges[s.index]
+	if err == nil {
+		if chunk, encodeErr := json.Marshal(response); encodeErr == nil {
+			exchange.Chunks = append(exchange.Chunks, chunk)
+		}
+		return response, nil
+	}
+	s.ended = true
+	if !errors.Is(err, io.EOF) {
+		exchange.Error = err.Error()
+	}
+	r.saveLocked()
+	return response, err
+}
@@ -0,0 +1,76 @@
+// Package replay records sessions into bundles of the exact API traffic and
+// plays them back through an agent, so that a reported interaction can be
+// turned into a test that runs against later versions of the code.
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/agent"
+)
+
+// Version is the bundle format written by this version
+const Version = 1
+
+// Bundle is a recorded session: the inputs that drove the agent, the API
+// requests it sent with the streamed responses, and what came out of it
+type Bundle struct {
+	Version    int                  `json:"version"`
+	Recorded   time.Time            `json:"recorded"`
+	Model      string               `json:"model"`
+	WorkingDir string               `json:"working_dir,omitempty"`
+	Initial    []agent.Message      `json:"initial"` // The history before the first input, system prompt first
+	Inputs     []agent.Input        `json:"inputs"`
+	Exchanges  []Exchange           `json:"exchanges"`
+	Items      []agent.ResponseItem `json:"items"`      // Every response item, in the order the handler received them
+	Dispatches []agent.FunctionCall `json:"dispatches"` // Tool calls surfaced to the host
+	History    []agent.Message      `json:"history"`    // The history after the last input
+}
+
+// Exchange is one request and the stream of its response
+type Exchange struct {
+	Request   json.RawMessage   `json:"request"`
+	Chunks    []json.RawMessage `json:"chunks,omitempty"`
+	OpenError string            `json:"open_error,omitempty"` // The stream could not be opened
+	Error     string            `json:"error,omitempty"`      // The stream failed after the chunks
+}
+
+// Load reads the bundle at path
+func Load(path string) (*Bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replay bundle: %w", err)
+	}
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse replay bundle: %w", err)
+	}
+	if b.Version > Version {
+		return nil, fmt.Errorf("replay bundle version %d is newer than supported version %d", b.Version, Version)
+	}
+	return &b, nil
+}
+
+// Save writes b to path, replacing the file whole so a reader never sees
+// half of it
+func (b *Bundle) Save(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode replay bundle: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create replay bundle directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write replay bundle: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write replay bundle: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package replay
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// cannedStream streams fixed chunks
+type cannedStream struct {
+	chunks []openai.ChatCompletionStreamResponse
+}
+
+func (s *cannedStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if len(s.chunks) == 0 {
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func (s *cannedStream) Close() error { return nil }
+
+// textResponse is a response answering with text
+func textResponse(text string) []openai.ChatCompletionStreamResponse {
+	return []openai.ChatCompletionStreamResponse{
+		{Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Role: "assistant", Content: text}}}},
+		{Choices: []openai.ChatCompletionStreamChoice{{FinishReason: openai.FinishReasonStop}}},
+	}
+}
+
+// toolCallResponse is a response calling a tool
+func toolCallResponse(id, name, args string) []openai.ChatCompletionStreamResponse {
+	index := 0
+	call := openai.ToolCall{Index: &index, ID: id, Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: name, Arguments: args}}
+	return []openai.ChatCompletionStreamResponse{
+		{Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Role: "assistant", ToolCalls: []openai.ToolCall{call}}}}},
+		{Choices: []openai.ChatCompletionStreamChoice{{FinishReason: openai.FinishReasonToolCalls}}},
+	}
+}
+
+// newTestAgent creates an agent whose responses come from source, or from nowhere
+func newTestAgent(t *testing.T, source agent.StreamSource) *agent.OpenAIAgent {
+	t.Helper()
+	a, err := agent.NewOpenAIAgent(&config.Config{APIKey: "test", Model: "gpt-4o"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	t.Cleanup(func() { a.Close() })
+	a.SetStreamSource(source)
+	return a
+}
+
+// recordSession records a turn that reads a file and answers with final
+func recordSession(t *testing.T, path, final string) {
+	t.Helper()
+	responses := [][]openai.ChatCompletionStreamResponse{
+		toolCallResponse("call_1", "read_file", `{"path":"a.txt"}`),
+		textResponse(final),
+	}
+	a := newTestAgent(t, agent.StreamSourceFunc(func(ctx context.Context, req openai.ChatCompletionRequest) (agent.ChatStream, error) {
+		stream := &cannedStream{chunks: responses[0]}
+		responses = responses[1:]
+		return stream, nil
+	}))
+	a.GetHistory().SetSystemPrompt("BASE")
+	rec := Record(a, path, "gpt-4o")
+
+	ctx := context.Background()
+	if _, err := a.SendMessage(ctx, []agent.Message{{Role: "user", Content: "What is in a.txt?"}}, func(string) {}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if err := a.SendToolResult(ctx, agent.NewToolResult("call_1", "read_file", "hello", true)); err != nil {
+		t.Fatalf("SendToolResult failed: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.replay.json")
+	recordSession(t, path, "It says hello.")
+
+	b, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(b.Inputs) != 2 || b.Inputs[1].Kind != agent.InputToolResult || b.Inputs[1].CallID != "call_1" {
+		t.Errorf("Expected a message and a tool result as inputs, got %+v", b.Inputs)
+	}
+	if len(b.Exchanges) != 2 || len(b.Exchanges[0].Chunks) != 2 {
+		t.Errorf("Expected 2 exchanges of 2 chunks, got %+v", b.Exchanges)
+	}
+	if len(b.Dispatches) != 1 || b.Dispatches[0].Name != "read_file" {
+		t.Errorf("Expected read_file to be dispatched, got %+v", b.Dispatches)
+	}
+	if len(b.Initial) != 1 || b.Initial[0].Content != "BASE" || len(b.History) != 5 {
+		t.Errorf("Expected the system prompt to start and 5 messages to end, got %d and %d", len(b.Initial), len(b.History))
+	}
+
+	// The same code replays without differences, without a network
+	got, diffs, err := Run(context.Background(), newTestAgent(t, nil), b)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("Expected no differences, got %v", diffs)
+	}
+	if len(got.Exchanges) != 2 {
+		t.Errorf("Expected 2 requests in the replay, got %d", len(got.Exchanges))
+	}
+}
+
+func TestReplayReportsDifferencesAndUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.replay.json")
+	recordSession(t, path, "It says hello.")
+	b, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	// The expected outcome no longer matches what the responses lead to
+	b.History[len(b.History)-1].Content = "It says goodbye."
+	b.Items = b.Items[:len(b.Items)-1]
+	_, diffs, err := Run(context.Background(), newTestAgent(t, nil), b)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	joined := strings.Join(diffs, "\n")
+	if len(diffs) != 2 || !strings.Contains(joined, "history message 5") || !strings.Contains(joined, "It says goodbye.") || !strings.Contains(joined, "items, got") {
+		t.Errorf("Expected the history and item differences, got:\n%s", joined)
+	}
+
+	// Updating accepts the behavior of the replay
+	got, _, _ := Run(context.Background(), newTestAgent(t, nil), b)
+	if err := got.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	updated, _ := Load(path)
+	if _, diffs, _ := Run(context.Background(), newTestAgent(t, nil), updated); len(diffs) != 0 {
+		t.Errorf("Expected the updated bundle to replay cleanly, got %v", diffs)
+	}
+
+	// Missing responses are reported rather than fetched
+	updated.Exchanges = updated.Exchanges[:1]
+	if _, diffs, _ := Run(context.Background(), newTestAgent(t, nil), updated); !strings.Contains(strings.Join(diffs, "\n"), "expected 1 requests, got 2") {
+		t.Errorf("Expected the missing response to be reported, got %v", diffs)
+	}
+}
+
+func TestRecorderClosesWhileATurnRuns(t *testing.T) {
+	opened := make(chan struct{})
+	a := newTestAgent(t, agent.StreamSourceFunc(func(ctx context.Context, req openai.ChatCompletionRequest) (agent.ChatStream, error) {
+		close(opened)
+		return &cannedStream{chunks: textResponse("Done.")}, nil
+	}))
+	rec := Record(a, filepath.Join(t.TempDir(), "session.replay.json"), "gpt-4o")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.SendMessage(context.Background(), []agent.Message{{Role: "user", Content: "Go"}}, func(string) {})
+		done <- err
+	}()
+	<-opened
+	// The history is read while the turn adds the reply to it
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+}
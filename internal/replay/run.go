@@ -0,0 +1,244 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Run plays b through a, which must be new: its history is set to the
+// bundle's starting point, its responses are read from the recorded streams
+// instead of the API, and the recorded inputs are sent in order. Tool calls
+// are not run; the recorded results are sent back instead.
+//
+// It returns the bundle of the replay, with the same inputs and streams and
+// what a made of them, and how it differs from b. Saving the returned bundle
+// over b accepts the new behavior. An error means the replay could not run.
+func Run(ctx context.Context, a *agent.OpenAIAgent, b *Bundle) (*Bundle, []string, error) {
+	if err := restore(a, b); err != nil {
+		return nil, nil, err
+	}
+	got := &Bundle{
+		Version:    Version,
+		Recorded:   b.Recorded,
+		Model:      b.Model,
+		WorkingDir: b.WorkingDir,
+		Initial:    b.Initial,
+		Inputs:     b.Inputs,
+	}
+	player := &player{exchanges: b.Exchanges, got: got}
+	a.SetStreamSource(player)
+	a.RegisterResponseHook(func(item agent.ResponseItem) (agent.ResponseItem, error) {
+		player.mu.Lock()
+		defer player.mu.Unlock()
+		got.Items = append(got.Items, item)
+		if item.Type == "function_call" && item.FunctionCall != nil {
+			got.Dispatches = append(got.Dispatches, *item.FunctionCall)
+		}
+		return item, nil
+	})
+
+	handler := func(string) {}
+	for _, input := range b.Inputs {
+		// Failed inputs failed when recorded too; what they left behind is compared
+		switch input.Kind {
+		case agent.InputSendMessage:
+			a.SendMessage(ctx, input.Messages, handler)
+		case agent.InputAddUserMessage:
+			a.AddUserMessage(input.Content)
+		case agent.InputQueueMessage:
+			a.QueueMessage(input.Content)
+		case agent.InputSendQueued:
+			a.SendQueuedMessages(ctx, handler)
+		case agent.InputToolResult:
+			if input.Result == nil {
+				return nil, nil, fmt.Errorf("tool result input for call %s has no result", input.CallID)
+			}
+			result := *input.Result
+			result.CallID, result.Name = input.CallID, input.Tool
+			a.SendToolResult(ctx, result)
+		case agent.InputEdit:
+			a.EditAndRegenerate(ctx, input.Index, input.Content, handler)
+		case agent.InputClearHistory:
+			a.ClearHistory()
+		default:
+			return nil, nil, fmt.Errorf("unknown input kind %q", input.Kind)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	player.mu.Lock()
+	defer player.mu.Unlock()
+	got.History, _ = a.HistorySnapshot()
+	return got, Compare(b, got), nil
+}
+
+// restore gives a the starting point of b
+func restore(a *agent.OpenAIAgent, b *Bundle) error {
+	h := a.GetHistory()
+	if h == nil {
+		return errors.New("agent history is nil")
+	}
+	if len(h.GetMessages()) > 1 {
+		return errors.New("replay needs an agent without conversation history")
+	}
+	initial := b.Initial
+	if len(initial) > 0 && initial[0].Role == openai.ChatMessageRoleSystem {
+		h.SetSystemPrompt(initial[0].Content)
+		initial = initial[1:]
+	}
+	if err := h.AppendMessages(initial); err != nil {
+		return fmt.Errorf("failed to restore the recorded history: %w", err)
+	}
+	if b.WorkingDir != "" {
+		return a.SetWorkingDir(b.WorkingDir)
+	}
+	return nil
+}
+
+// player serves the recorded streams in order and records the requests
+type player struct {
+	mu        sync.Mutex
+	exchanges []Exchange
+	next      int
+	got       *Bundle
+}
+
+func (p *player) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (agent.ChatStream, error) {
+	request, _ := json.Marshal(req)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.next >= len(p.exchanges) {
+		err := fmt.Errorf("no recorded response for request %d", p.next+1)
+		p.got.Exchanges = append(p.got.Exchanges, Exchange{Request: request, OpenError: err.Error()})
+		p.next++
+		return nil, err
+	}
+	recorded := p.exchanges[p.next]
+	p.next++
+	p.got.Exchanges = append(p.got.Exchanges, Exchange{Request: request, Chunks: recorded.Chunks, OpenError: recorded.OpenError, Error: recorded.Error})
+	if recorded.OpenError != "" {
+		return nil, errors.New(recorded.OpenError)
+	}
+	return &playerStream{ctx: ctx, exchange: recorded}, nil
+}
+
+// playerStream yields the chunks of a recorded exchange, then its error or io.EOF
+type playerStream struct {
+	ctx      context.Context
+	exchange Exchange
+	next     int
+}
+
+func (s *playerStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	var response openai.ChatCompletionStreamResponse
+	if err := s.ctx.Err(); err != nil {
+		return response, err
+	}
+	if s.next < len(s.exchange.Chunks) {
+		chunk := s.exchange.Chunks[s.next]
+		s.next++
+		if err := json.Unmarshal(chunk, &response); err != nil {
+			return response, fmt.Errorf("failed to decode recorded chunk: %w", err)
+		}
+		return response, nil
+	}
+	if s.exchange.Error != "" {
+		return response, errors.New(s.exchange.Error)
+	}
+	return response, io.EOF
+}
+
+func (s *playerStream) Close() error {
+	return nil
+}
+
+// Compare describes how got differs from want: in the conversation sent
+// with each request, the response items, the tool calls dispatched and the
+// final history. The leading system messages and the tools of requests are
+// not compared, as they depend on the configuration and the workspace
+// rather than on the session. The "message" items of a response are compared
+// by the last one, since how often text updates are sent depends on timing;
+// thinking durations and message IDs, which are new in every run, are
+// ignored. Each part reports its first difference.
+func Compare(want, got *Bundle) []string {
+	var diffs []string
+	wantRequests, gotRequests := make([][]openai.ChatCompletionMessage, len(want.Exchanges)), make([][]openai.ChatCompletionMessage, len(got.Exchanges))
+	for i, ex := range want.Exchanges {
+		wantRequests[i] = conversation(ex.Request)
+	}
+	for i, ex := range got.Exchanges {
+		gotRequests[i] = conversation(ex.Request)
+	}
+	diffs = appendDiff(diffs, "request", "requests", wantRequests, gotRequests)
+	diffs = appendDiff(diffs, "item", "items", normalizeItems(want.Items), normalizeItems(got.Items))
+	diffs = appendDiff(diffs, "dispatch", "dispatches", want.Dispatches, got.Dispatches)
+	diffs = appendDiff(diffs, "history message", "history messages", normalizeHistory(want.History), normalizeHistory(got.History))
+	return diffs
+}
+
+// appendDiff adds the first difference between want and got to diffs
+func appendDiff[T any](diffs []string, what, whats string, want, got []T) []string {
+	for i := 0; i < len(want) && i < len(got); i++ {
+		wantJSON, _ := json.Marshal(want[i])
+		gotJSON, _ := json.Marshal(got[i])
+		if !bytes.Equal(wantJSON, gotJSON) {
+			return append(diffs, fmt.Sprintf("%s %d: expected %s, got %s", what, i+1, wantJSON, gotJSON))
+		}
+	}
+	if len(want) != len(got) {
+		return append(diffs, fmt.Sprintf("expected %d %s, got %d", len(want), whats, len(got)))
+	}
+	return diffs
+}
+
+// conversation returns the messages of an encoded request after the leading
+// system messages
+func conversation(request json.RawMessage) []openai.ChatCompletionMessage {
+	var req openai.ChatCompletionRequest
+	json.Unmarshal(request, &req)
+	messages := req.Messages
+	for len(messages) > 0 && messages[0].Role == openai.ChatMessageRoleSystem {
+		messages = messages[1:]
+	}
+	return messages
+}
+
+// normalizeItems keeps the last "message" item of each response, the one
+// marked with the finish reason or followed by another kind of item, and
+// clears the thinking durations and message IDs
+func normalizeItems(items []agent.ResponseItem) []agent.ResponseItem {
+	var result []agent.ResponseItem
+	for i, item := range items {
+		if item.Type == "message" && item.FinishReason == "" && i+1 < len(items) && items[i+1].Type == "message" {
+			continue
+		}
+		item.ThinkingDuration = 0
+		if item.Message != nil && item.Message.ID != "" {
+			msg := *item.Message
+			msg.ID = ""
+			item.Message = &msg
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// normalizeHistory clears the IDs of history messages
+func normalizeHistory(messages []agent.Message) []agent.Message {
+	result := make([]agent.Message, len(messages))
+	for i, msg := range messages {
+		msg.ID = ""
+		result[i] = msg
+	}
+	return result
+}
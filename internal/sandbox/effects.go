@@ -0,0 +1,508 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// CommandEffects is a static, best-effort preview of what a shell command touches
+type CommandEffects struct {
+	Reads      []string `json:"reads,omitempty"`   // Paths the command likely reads
+	Writes     []string `json:"writes,omitempty"`  // Paths the command likely creates, modifies or removes
+	Network    bool     `json:"network"`           // Whether the command likely accesses the network
+	Privileged bool     `json:"privileged"`        // Whether the command elevates privileges
+	Unknown    []string `json:"unknown,omitempty"` // Programs whose effects could not be determined
+}
+
+// segmentRedirectPattern matches a redirection and its target within one segment
+var segmentRedirectPattern = regexp.MustCompile(`\d*(>>?|<)\s*(&?[^\s;&|<>]+)`)
+
+// noEffectCommands are programs that neither read paths nor write anything
+var noEffectCommands = map[string]bool{
+	"echo": true, "printf": true, "true": true, "false": true, "pwd": true, "cd": true,
+	"export": true, "which": true, "date": true, "whoami": true, "uname": true, "sleep": true,
+	"test": true, "[": true, "env": true, "set": true, "unset": true, "type": true,
+}
+
+// readCommands are programs whose non-flag arguments are paths they read
+var readCommands = map[string]bool{
+	"cat": true, "head": true, "tail": true, "less": true, "more": true, "wc": true,
+	"ls": true, "stat": true, "file": true, "diff": true, "sort": true, "uniq": true,
+	"tree": true, "du": true, "md5sum": true, "sha256sum": true, "nl": true, "cmp": true,
+}
+
+// patternCommands take a pattern as their first operand followed by paths they read
+var patternCommands = map[string]bool{"grep": true, "egrep": true, "fgrep": true, "rg": true, "ag": true}
+
+// writeCommands are programs whose non-flag arguments are paths they modify
+var writeCommands = map[string]bool{
+	"rm": true, "rmdir": true, "touch": true, "mkdir": true, "shred": true, "unlink": true,
+	"truncate": true, "tee": true, "mkfifo": true,
+}
+
+// networkCommands always access the network
+var networkCommands = map[string]bool{
+	"curl": true, "wget": true, "ssh": true, "scp": true, "sftp": true, "ftp": true,
+	"nc": true, "ncat": true, "telnet": true, "ping": true, "dig": true, "nslookup": true,
+}
+
+// networkSubcommands are tool subcommands that access the network
+var networkSubcommands = map[string]map[string]bool{
+	"git":     {"push": true, "pull": true, "fetch": true, "clone": true, "ls-remote": true, "submodule": true},
+	"go":      {"get": true, "install": true, "mod": true},
+	"npm":     {"install": true, "i": true, "ci": true, "update": true, "publish": true, "add": true},
+	"yarn":    {"add": true, "install": true, "upgrade": true, "publish": true},
+	"pnpm":    {"add": true, "install": true, "i": true, "update": true},
+	"pip":     {"install": true, "download": true},
+	"pip3":    {"install": true, "download": true},
+	"cargo":   {"install": true, "add": true, "update": true, "fetch": true, "publish": true},
+	"apt":     {"install": true, "update": true, "upgrade": true},
+	"apt-get": {"install": true, "update": true, "upgrade": true},
+	"brew":    {"install": true, "update": true, "upgrade": true},
+	"gem":     {"install": true, "update": true},
+	"docker":  {"pull": true, "push": true, "login": true},
+}
+
+// privilegeCommands run what follows with elevated privileges
+var privilegeCommands = map[string]bool{"sudo": true, "doas": true, "su": true}
+
+// AnalyzeCommand statically previews the effects of a shell command by parsing the
+// arguments of known programs. Programs it does not know are listed in Unknown
+// rather than guessed at.
+func AnalyzeCommand(command string) CommandEffects {
+	var e CommandEffects
+	reads := make(map[string]bool)
+	writes := make(map[string]bool)
+
+	for _, segment := range segmentPattern.Split(command, -1) {
+		// Redirections are effects of the shell, whatever the program
+		for _, m := range segmentRedirectPattern.FindAllStringSubmatch(segment, -1) {
+			target := strings.Trim(m[2], `"'`)
+			if strings.HasPrefix(target, "&") || target == "/dev/null" {
+				continue
+			}
+			if m[1] == "<" {
+				reads[target] = true
+			} else {
+				writes[target] = true
+			}
+		}
+		fields := splitShellWords(segmentRedirectPattern.ReplaceAllString(segment, " "))
+
+		// Skip env assignments and wrappers, noting privilege elevation
+		for len(fields) > 0 {
+			switch {
+			case privilegeCommands[fields[0]]:
+				e.Privileged = true
+				fields = skipWrapperFlags(fields[1:])
+				continue
+			case strings.Contains(fields[0], "=") && !strings.HasPrefix(fields[0], "-"),
+				fields[0] == "env" && len(fields) > 1, fields[0] == "command", fields[0] == "nohup":
+				fields = fields[1:]
+				continue
+			}
+			break
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		program := filepath.Base(fields[0])
+		args := fields[1:]
+		if !analyzeProgram(program, args, &e, reads, writes) {
+			e.Unknown = append(e.Unknown, program)
+		}
+	}
+
+	e.Reads = sortedKeys(reads)
+	e.Writes = sortedKeys(writes)
+	return e
+}
+
+// analyzeProgram records the effects of a single known program. It returns false
+// if the program is unknown.
+func analyzeProgram(program string, args []string, e *CommandEffects, reads, writes map[string]bool) bool {
+	operands, opts := splitOperands(args, programValueFlags[program])
+
+	if subs, ok := networkSubcommands[program]; ok && len(operands) > 0 && subs[operands[0]] {
+		e.Network = true
+	}
+
+	switch {
+	case noEffectCommands[program]:
+	case readCommands[program]:
+		addAll(reads, operands)
+	case patternCommands[program]:
+		if _, hasPattern := opts["-e"]; !hasPattern && len(operands) > 0 {
+			operands = operands[1:] // The first operand is the pattern
+		}
+		if len(operands) > 0 {
+			addAll(reads, operands)
+		} else {
+			reads["."] = true
+		}
+	case program == "find":
+		if len(operands) > 0 {
+			reads[operands[0]] = true
+		} else {
+			reads["."] = true
+		}
+		if containsAny(args, "-delete", "-exec", "-execdir") {
+			writes[firstOr(operands, ".")] = true
+		}
+	case writeCommands[program]:
+		addAll(writes, operands)
+	case program == "chmod" || program == "chown" || program == "chgrp":
+		if len(operands) > 1 {
+			addAll(writes, operands[1:])
+		}
+	case program == "cp" || program == "install" || program == "ln" || program == "rsync":
+		if dir, ok := opts["-t"]; ok {
+			writes[dir] = true
+			addAll(reads, operands)
+		} else if len(operands) > 0 {
+			writes[operands[len(operands)-1]] = true
+			addAll(reads, operands[:len(operands)-1])
+		}
+		if program == "rsync" && containsAny(operands, ":") {
+			e.Network = true
+		}
+	case program == "mv":
+		// The sources are removed, so they are written too
+		if dir, ok := opts["-t"]; ok {
+			writes[dir] = true
+		}
+		addAll(writes, operands)
+	case program == "sed" || program == "perl":
+		files := operands
+		if _, hasScript := opts["-e"]; !hasScript && len(files) > 0 {
+			files = files[1:] // The first operand is the script
+		}
+		if inPlace(args) {
+			addAll(writes, files)
+		} else {
+			addAll(reads, files)
+		}
+	case program == "dd":
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "if=") {
+				reads[strings.TrimPrefix(arg, "if=")] = true
+			} else if strings.HasPrefix(arg, "of=") {
+				writes[strings.TrimPrefix(arg, "of=")] = true
+			}
+		}
+	case program == "tar":
+		analyzeTar(args, operands, opts, reads, writes)
+	case program == "unzip":
+		addAll(reads, operands)
+		writes[firstOr([]string{opts["-d"]}, ".")] = true
+	case networkCommands[program]:
+		e.Network = true
+		if out, ok := opts["-o"]; ok && program == "curl" {
+			writes[out] = true
+		}
+		if out, ok := opts["--output"]; ok {
+			writes[out] = true
+		}
+		if program == "wget" {
+			writes[firstOr([]string{opts["-O"]}, ".")] = true
+		}
+		if program == "scp" && len(operands) > 0 {
+			writes[operands[len(operands)-1]] = true
+		}
+	case program == "git":
+		analyzeGit(operands, opts, reads, writes)
+	case program == "go":
+		analyzeGo(operands, opts, reads, writes)
+	case networkSubcommands[program] != nil:
+		// Package managers: installs write to the project and caches
+		if len(operands) > 0 && networkSubcommands[program][operands[0]] {
+			writes["."] = true
+		}
+		reads["."] = true
+	default:
+		return false
+	}
+	return true
+}
+
+// analyzeTar records the effects of a tar invocation, including bundled flags like -czf
+func analyzeTar(args, operands []string, opts map[string]string, reads, writes map[string]bool) {
+	mode := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		mode = strings.TrimPrefix(args[0], "-")
+	}
+	archive := opts["-f"]
+	if archive == "" && strings.Contains(mode, "f") && len(operands) > 0 {
+		// Bundled flags: the archive is the first operand
+		archive = operands[0]
+		operands = operands[1:]
+	}
+
+	switch {
+	case strings.Contains(mode, "x") || containsAny(args, "--extract"):
+		if archive != "" {
+			reads[archive] = true
+		}
+		writes[firstOr([]string{opts["-C"]}, ".")] = true
+	case strings.Contains(mode, "c") || containsAny(args, "--create"):
+		if archive != "" {
+			writes[archive] = true
+		}
+		addAll(reads, operands)
+	case archive != "":
+		reads[archive] = true
+	}
+}
+
+// analyzeGit records the effects of a git invocation
+func analyzeGit(operands []string, opts map[string]string, reads, writes map[string]bool) {
+	dir := firstOr([]string{opts["-C"]}, ".")
+	if len(operands) == 0 {
+		return
+	}
+	sub := operands[0]
+	switch {
+	case sub == "clone":
+		if len(operands) > 2 {
+			writes[operands[2]] = true
+		} else {
+			writes["."] = true
+		}
+	case mutatingSubcommands["git"][sub]:
+		writes[dir] = true
+	default:
+		reads[dir] = true
+	}
+}
+
+// analyzeGo records the effects of a go invocation
+func analyzeGo(operands []string, opts map[string]string, reads, writes map[string]bool) {
+	if len(operands) == 0 {
+		return
+	}
+	reads["."] = true
+	switch operands[0] {
+	case "build":
+		if out, ok := opts["-o"]; ok {
+			writes[out] = true
+		} else {
+			writes["."] = true
+		}
+	case "install":
+		writes[filepath.Join("$GOPATH", "bin")] = true
+	case "fmt", "generate", "get", "mod":
+		writes["."] = true
+	}
+}
+
+// WritesOutside returns the written paths that resolve outside workspace. Paths
+// that cannot be resolved statically (variables, home directory) count as outside.
+func (e CommandEffects) WritesOutside(workspace string) []string {
+	return e.WritesOutsideFrom(workspace, workspace)
+}
+
+// WritesOutsideFrom is WritesOutside for a command run in cwd, which relative
+// paths resolve against
+func (e CommandEffects) WritesOutsideFrom(cwd, workspace string) []string {
+	root, err := filepath.Abs(workspace)
+	if err != nil {
+		root = workspace
+	}
+	base, err := filepath.Abs(cwd)
+	if err != nil {
+		base = cwd
+	}
+	var outside []string
+	for _, path := range e.Writes {
+		if strings.Contains(path, "$") || strings.Contains(path, ":") {
+			outside = append(outside, path)
+			continue
+		}
+		resolved := path
+		if strings.HasPrefix(path, "~") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				outside = append(outside, path)
+				continue
+			}
+			resolved = filepath.Join(home, strings.TrimPrefix(path, "~"))
+		} else if !filepath.IsAbs(path) {
+			resolved = filepath.Join(base, path)
+		}
+		rel, err := filepath.Rel(root, filepath.Clean(resolved))
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			outside = append(outside, path)
+		}
+	}
+	return outside
+}
+
+// Summary renders the effects as short human-readable lines
+func (e CommandEffects) Summary() string {
+	var lines []string
+	if len(e.Reads) > 0 {
+		lines = append(lines, "Reads: "+strings.Join(e.Reads, ", "))
+	}
+	if len(e.Writes) > 0 {
+		lines = append(lines, "Writes: "+strings.Join(e.Writes, ", "))
+	}
+	if e.Network {
+		lines = append(lines, "Accesses the network")
+	}
+	if e.Privileged {
+		lines = append(lines, "Elevates privileges")
+	}
+	if len(e.Unknown) > 0 {
+		lines = append(lines, fmt.Sprintf("Effects unknown for: %s", strings.Join(e.Unknown, ", ")))
+	}
+	if len(lines) == 0 {
+		return "No file or network effects detected"
+	}
+	return strings.Join(lines, "\n")
+}
+
+// splitShellWords splits a command segment into words, honoring quotes and backslashes
+func splitShellWords(s string) []string {
+	var words []string
+	var b strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				b.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, b.String())
+				b.Reset()
+				inWord = false
+			}
+		default:
+			b.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, b.String())
+	}
+	return words
+}
+
+// programValueFlags lists, per program, the flags whose value is the following argument
+var programValueFlags = map[string]map[string]bool{
+	"cp": {"-t": true}, "mv": {"-t": true}, "install": {"-t": true, "-m": true}, "ln": {"-t": true},
+	"rsync": {"-e": true}, "sed": {"-e": true, "-f": true}, "perl": {"-e": true, "-E": true},
+	"head": {"-n": true, "-c": true}, "tail": {"-n": true, "-c": true},
+	"grep": {"-e": true, "-f": true, "-m": true, "-A": true, "-B": true, "-C": true},
+	"rg":   {"-e": true, "-g": true, "-t": true, "-m": true, "-A": true, "-B": true, "-C": true},
+	"curl": {"-o": true, "--output": true, "-d": true, "-H": true, "-X": true, "-u": true, "-A": true},
+	"wget": {"-O": true, "-P": true}, "ssh": {"-i": true, "-p": true, "-o": true, "-l": true},
+	"scp": {"-i": true, "-P": true, "-o": true}, "tar": {"-f": true, "-C": true}, "unzip": {"-d": true},
+	"git": {"-C": true, "-c": true}, "go": {"-o": true, "-C": true},
+}
+
+// splitOperands separates operands from flags, capturing the values of valueFlags
+func splitOperands(args []string, valueFlags map[string]bool) ([]string, map[string]string) {
+	var operands []string
+	opts := make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			operands = append(operands, args[i+1:]...)
+			return operands, opts
+		case valueFlags[arg]:
+			if i+1 < len(args) {
+				opts[arg] = args[i+1]
+				i++
+			} else {
+				opts[arg] = ""
+			}
+		case strings.HasPrefix(arg, "--") && strings.Contains(arg, "="):
+			kv := strings.SplitN(arg, "=", 2)
+			opts[kv[0]] = kv[1]
+		case strings.HasPrefix(arg, "-") && arg != "-":
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	return operands, opts
+}
+
+// skipWrapperFlags drops the flags of a privilege wrapper like sudo -u root
+func skipWrapperFlags(fields []string) []string {
+	for len(fields) > 0 && strings.HasPrefix(fields[0], "-") {
+		if fields[0] == "-u" || fields[0] == "-g" {
+			fields = fields[1:]
+		}
+		if len(fields) > 0 {
+			fields = fields[1:]
+		}
+	}
+	return fields
+}
+
+// inPlace reports whether sed/perl arguments request in-place editing
+func inPlace(args []string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-i") || strings.HasPrefix(arg, "-pi") || arg == "--in-place" {
+			return true
+		}
+	}
+	return false
+}
+
+func addAll(set map[string]bool, paths []string) {
+	for _, path := range paths {
+		set[path] = true
+	}
+}
+
+func containsAny(args []string, values ...string) bool {
+	for _, arg := range args {
+		for _, v := range values {
+			if arg == v || (v == ":" && strings.Contains(arg, ":")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func firstOr(values []string, fallback string) string {
+	if len(values) > 0 && values[0] != "" {
+		return values[0]
+	}
+	return fallback
+}
+
+func sortedKeys(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
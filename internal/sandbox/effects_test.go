@@ -0,0 +1,76 @@
+package sandbox
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeCommand(t *testing.T) {
+	tests := []struct {
+		command    string
+		reads      []string
+		writes     []string
+		network    bool
+		privileged bool
+		unknown    []string
+	}{
+		{command: "cp src/a.go /tmp/b.go", reads: []string{"src/a.go"}, writes: []string{"/tmp/b.go"}},
+		{command: "mv old.txt new.txt", writes: []string{"new.txt", "old.txt"}},
+		{command: "rm -rf build dist", writes: []string{"build", "dist"}},
+		{command: "sed -i 's/a/b/' main.go", writes: []string{"main.go"}},
+		{command: "sed -n '1,5p' main.go", reads: []string{"main.go"}},
+		{command: "go build -o bin/app ./cmd/app", reads: []string{"."}, writes: []string{"bin/app"}},
+		{command: "grep -rn TODO internal", reads: []string{"internal"}},
+		{command: "head -n 20 README.md > /tmp/head.txt", reads: []string{"README.md"}, writes: []string{"/tmp/head.txt"}},
+		{command: "tar -czf out.tgz src", reads: []string{"src"}, writes: []string{"out.tgz"}},
+		{command: "curl -o data.json https://example.com", writes: []string{"data.json"}, network: true},
+		{command: "git push origin main", network: true, writes: []string{"."}},
+		{command: "npm install", network: true, reads: []string{"."}, writes: []string{"."}},
+		{command: "sudo -u root rm /etc/hosts", writes: []string{"/etc/hosts"}, privileged: true},
+		{command: "echo done && frobnicate --all", unknown: []string{"frobnicate"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.command, func(t *testing.T) {
+			e := AnalyzeCommand(test.command)
+			if !reflect.DeepEqual(e.Reads, test.reads) {
+				t.Errorf("Expected reads %v, got %v", test.reads, e.Reads)
+			}
+			if !reflect.DeepEqual(e.Writes, test.writes) {
+				t.Errorf("Expected writes %v, got %v", test.writes, e.Writes)
+			}
+			if e.Network != test.network {
+				t.Errorf("Expected network %t, got %t", test.network, e.Network)
+			}
+			if e.Privileged != test.privileged {
+				t.Errorf("Expected privileged %t, got %t", test.privileged, e.Privileged)
+			}
+			if !reflect.DeepEqual(e.Unknown, test.unknown) {
+				t.Errorf("Expected unknown %v, got %v", test.unknown, e.Unknown)
+			}
+		})
+	}
+}
+
+func TestWritesOutside(t *testing.T) {
+	workspace := t.TempDir()
+	e := CommandEffects{Writes: []string{"build/out", "../sibling", "/etc/passwd", "$HOME/.bashrc", "./ok"}}
+
+	got := e.WritesOutside(workspace)
+	expected := []string{"../sibling", "/etc/passwd", "$HOME/.bashrc"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	if outside := AnalyzeCommand("go test ./...").WritesOutside(workspace); len(outside) != 0 {
+		t.Errorf("Expected no writes outside the workspace, got %v", outside)
+	}
+
+	// Relative paths resolve against the directory the command runs in
+	got = e.WritesOutsideFrom(filepath.Join(workspace, "sub", "dir"), workspace)
+	expected = []string{"/etc/passwd", "$HOME/.bashrc"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v from a subdirectory, got %v", expected, got)
+	}
+}
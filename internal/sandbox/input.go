@@ -0,0 +1,198 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrWantsInput is returned for a command that was stopped because it was
+// waiting for input that nobody could give it
+var ErrWantsInput = errors.New("command is waiting for input")
+
+const (
+	// DefaultInputIdle is how long a command may go without output before it
+	// is checked for waiting on input
+	DefaultInputIdle = 2 * time.Second
+
+	inputPollInterval = 250 * time.Millisecond // How often a quiet command is checked
+	inputTailBytes    = 512                    // Output kept to show with an input request
+)
+
+// InputRequest describes a command found waiting for input (the
+// "command_wants_input" event)
+type InputRequest struct {
+	Command  string
+	PID      int    // Process of the command that is waiting
+	Terminal bool   // Reading the terminal rather than stdin, so input cannot be passed through
+	Output   string // End of the output so far, usually the prompt
+}
+
+// InputHandler is asked for the input of a command waiting on its stdin. It
+// returns the input to write, or ok=false to stop the command. Empty input
+// with ok=true closes the command's stdin. ctx is cancelled when the command
+// exits. Commands reading the terminal are stopped without asking: their
+// input cannot be passed through.
+type InputHandler func(ctx context.Context, req InputRequest) (input string, ok bool)
+
+type inputHandlerKey struct{}
+
+// WithInputHandler returns a context carrying handler, for tools that start
+// commands on behalf of an interactive session
+func WithInputHandler(ctx context.Context, handler InputHandler) context.Context {
+	return context.WithValue(ctx, inputHandlerKey{}, handler)
+}
+
+// InputHandlerFrom returns the input handler carried by ctx, or nil
+func InputHandlerFrom(ctx context.Context) InputHandler {
+	handler, _ := ctx.Value(inputHandlerKey{}).(InputHandler)
+	return handler
+}
+
+// outputActivity records when a command last wrote output, and the end of it
+type outputActivity struct {
+	mu   sync.Mutex
+	last time.Time
+	tail []byte
+}
+
+func (o *outputActivity) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.last = time.Now()
+	o.tail = append(o.tail, p...)
+	if len(o.tail) > inputTailBytes {
+		o.tail = append([]byte(nil), o.tail[len(o.tail)-inputTailBytes:]...)
+	}
+	return len(p), nil
+}
+
+// quiet returns how long the command has gone without output, and its end
+func (o *outputActivity) quiet() (time.Duration, string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return time.Since(o.last), string(o.tail)
+}
+
+// touch restarts the quiet period, e.g. after input was written
+func (o *outputActivity) touch() {
+	o.mu.Lock()
+	o.last = time.Now()
+	o.mu.Unlock()
+}
+
+// runWatched runs cmd like runTracked, checking it for waiting on input
+// whenever it has gone quiet. A command waiting on its stdin is given the
+// input opts.OnInput returns; one without a handler, or reading the terminal,
+// is stopped and ErrWantsInput returned.
+func runWatched(cmd *exec.Cmd, opts SandboxOptions) error {
+	idle := opts.InputIdle
+	if idle == 0 {
+		idle = DefaultInputIdle
+	}
+	if idle < 0 || !inputDetection {
+		return runTracked(cmd)
+	}
+
+	w := &inputWatch{cmd: cmd, opts: opts, idle: idle, activity: &outputActivity{last: time.Now()}}
+	cmd.Stdout = io.MultiWriter(cmd.Stdout, w.activity)
+	cmd.Stderr = io.MultiWriter(cmd.Stderr, w.activity)
+
+	// A pipe of our own lets the handler answer reads from stdin
+	var stdinRead *os.File
+	if opts.Stdin == nil && opts.OnInput != nil {
+		r, pw, err := os.Pipe()
+		if err != nil {
+			return fmt.Errorf("failed to create stdin pipe: %w", err)
+		}
+		cmd.Stdin = r
+		stdinRead, w.stdin, w.stdinID = r, pw, pipeID(r)
+		defer pw.Close()
+	}
+
+	err := startTracked(cmd)
+	if stdinRead != nil {
+		stdinRead.Close() // The command holds the read end now
+	}
+	if err != nil {
+		return err
+	}
+	defer processes.remove(cmd)
+
+	ctx, exited := context.WithCancel(context.Background())
+	go w.watch(ctx)
+	err = cmd.Wait()
+	exited()
+	if w.stopped.Load() {
+		return w.err
+	}
+	return err
+}
+
+// inputWatch checks a running command for waiting on input
+type inputWatch struct {
+	cmd      *exec.Cmd
+	opts     SandboxOptions
+	idle     time.Duration
+	activity *outputActivity
+	stdin    *os.File // Write end of the command's stdin, nil when it is not ours
+	stdinID  uint64   // Identifies the command's stdin pipe, 0 when it is not ours
+
+	stopped atomic.Bool // Set once the command was stopped for waiting on input
+	err     error       // Why it was stopped, set before stopped
+}
+
+// watch checks the command whenever it has gone quiet, until ctx is done
+func (w *inputWatch) watch(ctx context.Context) {
+	ticker := time.NewTicker(inputPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		quiet, output := w.activity.quiet()
+		if quiet < w.idle {
+			continue
+		}
+		pid, terminal, waiting := waitingForInput(w.cmd.Process.Pid, w.stdinID)
+		if !waiting {
+			continue
+		}
+
+		req := InputRequest{Command: w.opts.Command, PID: pid, Terminal: terminal, Output: output}
+		if !terminal && w.opts.OnInput != nil && w.stdin != nil {
+			input, ok := w.opts.OnInput(ctx, req)
+			if ctx.Err() != nil {
+				return
+			}
+			if ok {
+				if input == "" {
+					w.stdin.Close()
+					w.stdinID = 0
+				} else if _, err := io.WriteString(w.stdin, input); err != nil {
+					return // The command closed its stdin, so it is not waiting on it
+				}
+				w.activity.touch()
+				continue
+			}
+		}
+
+		source := "stdin"
+		if terminal {
+			source = "the terminal"
+		}
+		w.err = fmt.Errorf("%w: it was reading from %s after %s without output; its output ends with %q",
+			ErrWantsInput, source, quiet.Round(time.Second), output)
+		w.stopped.Store(true)
+		signalGroup(w.cmd, true)
+		return
+	}
+}
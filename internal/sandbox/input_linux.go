@@ -0,0 +1,83 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// inputDetection reports whether waitingForInput can tell anything here
+const inputDetection = true
+
+// pipeID identifies a pipe by its inode, as shown in /proc/<pid>/fd links
+func pipeID(f *os.File) uint64 {
+	info, err := f.Stat()
+	if err != nil {
+		return 0
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Ino
+	}
+	return 0
+}
+
+// waitingForInput looks through process group pgid for a process waiting on
+// input: one stopped for reading the terminal from the background (SIGTTIN),
+// or one blocked reading the stdin pipe identified by stdinID
+func waitingForInput(pgid int, stdinID uint64) (pid int, terminal bool, waiting bool) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, false, false
+	}
+	stdinLink := fmt.Sprintf("pipe:[%d]", stdinID)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		state, group, ok := procState(pid)
+		if !ok || group != pgid {
+			continue
+		}
+		switch state {
+		case 'T':
+			return pid, true, true
+		case 'S':
+			if stdinID == 0 {
+				continue
+			}
+			wchan, _ := os.ReadFile(fmt.Sprintf("/proc/%d/wchan", pid))
+			if !strings.Contains(string(wchan), "pipe_read") && !strings.Contains(string(wchan), "pipe_wait") {
+				continue
+			}
+			if link, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/0", pid)); err == nil && link == stdinLink {
+				return pid, false, true
+			}
+		}
+	}
+	return 0, false, false
+}
+
+// procState reads the state and process group of pid from /proc/<pid>/stat
+func procState(pid int) (state byte, pgid int, ok bool) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, 0, false
+	}
+	// The command name is in parentheses and may contain spaces
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return 0, 0, false
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 3 || len(fields[0]) != 1 {
+		return 0, 0, false
+	}
+	pgid, err = strconv.Atoi(fields[2])
+	if err != nil {
+		return 0, 0, false
+	}
+	return fields[0][0], pgid, true
+}
@@ -0,0 +1,98 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandWaitingOnStdinGetsHandlerInput(t *testing.T) {
+	var requests []InputRequest
+	result, err := NewBasicSandbox().Execute(context.Background(), SandboxOptions{
+		Command:    `printf 'Project name: '; read name; echo "created $name"`,
+		WorkingDir: t.TempDir(),
+		InputIdle:  200 * time.Millisecond,
+		OnInput: func(ctx context.Context, req InputRequest) (string, bool) {
+			requests = append(requests, req)
+			return "demo\n", true
+		},
+	})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if !result.Success || !strings.Contains(result.Stdout, "created demo") {
+		t.Fatalf("Expected the command to get its input, got %+v", result)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 input request, got %d", len(requests))
+	}
+	if requests[0].Terminal || requests[0].Output != "Project name: " {
+		t.Errorf("Expected a stdin request showing the prompt, got %+v", requests[0])
+	}
+}
+
+func TestCommandWaitingOnStdinStoppedWhenDeclined(t *testing.T) {
+	start := time.Now()
+	result, _ := NewBasicSandbox().Execute(context.Background(), SandboxOptions{
+		Command:    `read answer`,
+		WorkingDir: t.TempDir(),
+		Timeout:    30 * time.Second,
+		InputIdle:  200 * time.Millisecond,
+		OnInput: func(ctx context.Context, req InputRequest) (string, bool) {
+			return "", false
+		},
+	})
+	if result.Success || !errors.Is(result.Error, ErrWantsInput) {
+		t.Fatalf("Expected ErrWantsInput, got %+v", result)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("Expected the command to be stopped well before its timeout")
+	}
+}
+
+func TestCommandStdinClosedByEmptyInput(t *testing.T) {
+	result, _ := NewBasicSandbox().Execute(context.Background(), SandboxOptions{
+		Command:    `cat; echo done`,
+		WorkingDir: t.TempDir(),
+		InputIdle:  200 * time.Millisecond,
+		OnInput: func(ctx context.Context, req InputRequest) (string, bool) {
+			return "", true
+		},
+	})
+	if !result.Success || strings.TrimSpace(result.Stdout) != "done" {
+		t.Fatalf("Expected cat to see the end of its input, got %+v", result)
+	}
+}
+
+func TestStoppedCommandFailsFastWithoutHandler(t *testing.T) {
+	// A stopped process is what reading the terminal from the background looks like
+	result, _ := NewBasicSandbox().Execute(context.Background(), SandboxOptions{
+		Command:    `echo 'Enter passphrase:'; kill -STOP $$`,
+		WorkingDir: t.TempDir(),
+		Timeout:    30 * time.Second,
+		InputIdle:  200 * time.Millisecond,
+	})
+	if !errors.Is(result.Error, ErrWantsInput) {
+		t.Fatalf("Expected ErrWantsInput, got %+v", result)
+	}
+	if !strings.Contains(result.Error.Error(), "the terminal") || !strings.Contains(result.Error.Error(), "Enter passphrase:") {
+		t.Errorf("Expected the error to describe the terminal read and prompt, got %v", result.Error)
+	}
+}
+
+func TestQuietCommandNotMistakenForInput(t *testing.T) {
+	result, _ := NewBasicSandbox().Execute(context.Background(), SandboxOptions{
+		Command:    `sleep 1; echo finished`,
+		WorkingDir: t.TempDir(),
+		InputIdle:  200 * time.Millisecond,
+		OnInput: func(ctx context.Context, req InputRequest) (string, bool) {
+			t.Errorf("Unexpected input request: %+v", req)
+			return "", false
+		},
+	})
+	if !result.Success || strings.TrimSpace(result.Stdout) != "finished" {
+		t.Fatalf("Expected the command to finish, got %+v", result)
+	}
+}
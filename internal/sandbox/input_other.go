@@ -0,0 +1,17 @@
+//go:build !linux
+
+package sandbox
+
+import "os"
+
+// inputDetection reports whether waitingForInput can tell anything here:
+// without /proc, commands waiting on input run into their timeout
+const inputDetection = false
+
+func pipeID(f *os.File) uint64 {
+	return 0
+}
+
+func waitingForInput(pgid int, stdinID uint64) (pid int, terminal bool, waiting bool) {
+	return 0, false, false
+}
@@ -23,6 +23,10 @@ type SandboxOptions struct {
 	// Command to execute
 	Command string
 
+	// Shell program and flags the command is passed to, e.g. bash -euo
+	// pipefail -c (empty = DefaultShell)
+	Shell []string
+
 	// Working directory
 	WorkingDir string
 
@@ -32,6 +36,10 @@ type SandboxOptions struct {
 	// Allow file writes outside working directory
 	AllowFileWrites bool
 
+	// Deny all file writes. Sandboxes without filesystem isolation refuse
+	// commands that look like they would modify files instead.
+	ReadOnly bool
+
 	// Timeout for command execution
 	Timeout time.Duration
 
@@ -41,6 +49,15 @@ type SandboxOptions struct {
 	// Input to provide to the command
 	Stdin io.Reader
 
+	// How long the command may go without output before it is checked for
+	// waiting on input (0 = DefaultInputIdle, negative = never checked)
+	InputIdle time.Duration
+
+	// Asked for the input of a command waiting on its stdin when Stdin is not
+	// set. Without a handler, a command found waiting for input is stopped
+	// with ErrWantsInput instead of running into its timeout.
+	OnInput InputHandler
+
 	// Capture stdout and stderr
 	Stdout io.Writer
 	Stderr io.Writer
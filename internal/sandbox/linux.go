@@ -33,8 +33,21 @@ func (s *LinuxSandbox) IsAvailable() bool {
 func (s *LinuxSandbox) Execute(ctx context.Context, opts SandboxOptions) (*CommandResult, error) {
 	startTime := time.Now()
 
+	// No filesystem isolation here, so read-only mode refuses mutating commands
+	if result, err := refuseIfMutating(opts); result != nil {
+		return result, err
+	}
+
+	// Apply timeout if specified
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
 	// Build the command
-	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", opts.Command)
+	argv := shellArgs(opts.Shell, opts.Command)
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
 	cmd.Dir = opts.WorkingDir
 
 	// Set up restricted environment
@@ -74,7 +87,7 @@ func (s *LinuxSandbox) Execute(ctx context.Context, opts SandboxOptions) (*Comma
 	}
 
 	// Execute the command
-	err := cmd.Run()
+	err := timedOut(ctx, opts, runWatched(cmd, opts))
 	duration := time.Since(startTime)
 
 	// Build the result
@@ -54,8 +54,16 @@ func (s *MacOSSandbox) Execute(ctx context.Context, opts SandboxOptions) (*Comma
 		return nil, fmt.Errorf("failed to close sandbox profile file: %w", err)
 	}
 
+	// Apply timeout if specified
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
 	// Build the command
-	cmd := exec.CommandContext(ctx, "sandbox-exec", "-f", profileFile.Name(), "/bin/sh", "-c", opts.Command)
+	args := append([]string{"-f", profileFile.Name()}, shellArgs(opts.Shell, opts.Command)...)
+	cmd := exec.CommandContext(ctx, "sandbox-exec", args...)
 	cmd.Dir = opts.WorkingDir
 
 	// Set up environment
@@ -86,7 +94,7 @@ func (s *MacOSSandbox) Execute(ctx context.Context, opts SandboxOptions) (*Comma
 	}
 
 	// Execute the command
-	err = cmd.Run()
+	err = timedOut(ctx, opts, runWatched(cmd, opts))
 	duration := time.Since(startTime)
 
 	// Build the result
@@ -174,13 +182,21 @@ func (s *MacOSSandbox) createSandboxProfile(opts SandboxOptions) (string, error)
 ; Conditionally allow file writes based on options
 `
 
-	// Allow writes to the working directory by default
-	profile += `
+	// Allow writes to the working directory by default; read-only sessions only get /dev
+	if opts.ReadOnly {
+		profile += `
+(allow file-write*
+    (subpath "/dev")
+)
+`
+	} else {
+		profile += `
 (allow file-write*
     (subpath "` + workDir + `")
     (subpath "` + tempDir + `")
 )
 `
+	}
 
 	// Handle network access
 	if !opts.AllowNetwork {
@@ -0,0 +1,121 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// processTracker records the process groups of running commands so they can
+// be signalled together, e.g. when the CLI shuts down mid-turn
+type processTracker struct {
+	mu      sync.Mutex
+	groups  map[int]*exec.Cmd
+	changed chan struct{} // Closed and replaced whenever a group exits
+}
+
+// processes tracks every command started by this package
+var processes = &processTracker{
+	groups:  make(map[int]*exec.Cmd),
+	changed: make(chan struct{}),
+}
+
+// ErrTimedOut is the error of a command killed for running past its timeout
+var ErrTimedOut = errors.New("command timed out")
+
+// timedOut replaces the error of a command killed by the timeout of opts with
+// ErrTimedOut; ctx is the context the command ran under
+func timedOut(ctx context.Context, opts SandboxOptions, err error) error {
+	if err != nil && opts.Timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s", ErrTimedOut, opts.Timeout)
+	}
+	return err
+}
+
+// runTracked runs cmd in its own process group, tracked until it exits.
+// Cancelling the command's context kills the whole group, not just the shell.
+func runTracked(cmd *exec.Cmd) error {
+	if err := startTracked(cmd); err != nil {
+		return err
+	}
+	defer processes.remove(cmd)
+	return cmd.Wait()
+}
+
+// startTracked starts cmd in its own process group and tracks it. The caller
+// must Wait for it and then remove it from processes.
+func startTracked(cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return signalGroup(cmd, true)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	processes.add(cmd)
+	return nil
+}
+
+func (t *processTracker) add(cmd *exec.Cmd) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.groups[cmd.Process.Pid] = cmd
+}
+
+func (t *processTracker) remove(cmd *exec.Cmd) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.groups, cmd.Process.Pid)
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// snapshot returns the running commands and a channel closed on the next exit
+func (t *processTracker) snapshot() ([]*exec.Cmd, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cmds := make([]*exec.Cmd, 0, len(t.groups))
+	for _, cmd := range t.groups {
+		cmds = append(cmds, cmd)
+	}
+	return cmds, t.changed
+}
+
+// Running returns the number of commands still running
+func Running() int {
+	cmds, _ := processes.snapshot()
+	return len(cmds)
+}
+
+// TerminateAll asks every running command's process group to terminate, waits
+// up to grace for them to exit, then kills whatever is left. It returns the
+// number of process groups that had to be killed.
+func TerminateAll(grace time.Duration) int {
+	cmds, changed := processes.snapshot()
+	if len(cmds) == 0 {
+		return 0
+	}
+	for _, cmd := range cmds {
+		signalGroup(cmd, false)
+	}
+
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+	for {
+		select {
+		case <-changed:
+			if cmds, changed = processes.snapshot(); len(cmds) == 0 {
+				return 0
+			}
+		case <-deadline.C:
+			cmds, _ = processes.snapshot()
+			for _, cmd := range cmds {
+				signalGroup(cmd, true)
+			}
+			return len(cmds)
+		}
+	}
+}
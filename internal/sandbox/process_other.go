@@ -0,0 +1,13 @@
+//go:build !unix
+
+package sandbox
+
+import "os/exec"
+
+// setProcessGroup is a no-op where process groups are unavailable
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup kills the process itself where process groups are unavailable
+func signalGroup(cmd *exec.Cmd, kill bool) error {
+	return cmd.Process.Kill()
+}
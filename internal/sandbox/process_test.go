@@ -0,0 +1,95 @@
+//go:build unix
+
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startBackground runs command in the basic sandbox and waits until it has
+// created the file "ready" in its working directory
+func startBackground(t *testing.T, command string) <-chan *CommandResult {
+	t.Helper()
+	dir := t.TempDir()
+	done := make(chan *CommandResult, 1)
+	go func() {
+		result, _ := NewBasicSandbox().Execute(context.Background(), SandboxOptions{Command: command, WorkingDir: dir})
+		done <- result
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "ready")); err == nil && Running() > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Command was never tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return done
+}
+
+func TestTerminateAllStopsProcessGroups(t *testing.T) {
+	// The backgrounded sleep keeps the output pipe open; only signalling the
+	// whole group lets the command return
+	done := startBackground(t, "sleep 30 & touch ready; sleep 30")
+
+	if killed := TerminateAll(5 * time.Second); killed != 0 {
+		t.Errorf("Expected the group to exit on SIGTERM, %d had to be killed", killed)
+	}
+	select {
+	case result := <-done:
+		if result.Success {
+			t.Errorf("Expected the terminated command to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Command still running after TerminateAll")
+	}
+	if n := Running(); n != 0 {
+		t.Errorf("Expected no tracked commands, got %d", n)
+	}
+}
+
+func TestTerminateAllKillsAfterGrace(t *testing.T) {
+	done := startBackground(t, "trap '' TERM; touch ready; sleep 30")
+
+	start := time.Now()
+	if killed := TerminateAll(200 * time.Millisecond); killed != 1 {
+		t.Errorf("Expected 1 group to be killed, got %d", killed)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Command still running after being killed")
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected TerminateAll to wait for the grace period, returned after %v", elapsed)
+	}
+}
+
+func TestTimeoutAppliesToTheCommandThatRuns(t *testing.T) {
+	// A timeout keeps the command's output
+	result, _ := NewBasicSandbox().Execute(context.Background(), SandboxOptions{Command: "echo out; echo err >&2", WorkingDir: t.TempDir(), Timeout: 30 * time.Second})
+	if !result.Success || result.Stdout != "out\n" || result.Stderr != "err\n" {
+		t.Errorf("Expected the output of a command with a timeout, got %+v", result)
+	}
+
+	// and stops the command once it expires
+	start := time.Now()
+	result, _ = NewBasicSandbox().Execute(context.Background(), SandboxOptions{Command: "echo started; sleep 30", WorkingDir: t.TempDir(), Timeout: 200 * time.Millisecond})
+	if result.Success || !errors.Is(result.Error, ErrTimedOut) {
+		t.Errorf("Expected ErrTimedOut, got %+v", result)
+	}
+	if result.Stdout != "started\n" {
+		t.Errorf("Expected the output before the timeout, got %q", result.Stdout)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("Expected the command stopped at its timeout")
+	}
+}
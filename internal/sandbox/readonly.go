@@ -0,0 +1,116 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrReadOnly is returned when a command is refused because the session is read-only
+var ErrReadOnly = errors.New("read-only mode: command would modify the filesystem")
+
+// mutatingCommands are programs whose normal use modifies files
+var mutatingCommands = map[string]bool{
+	"rm": true, "rmdir": true, "mv": true, "cp": true, "mkdir": true, "touch": true,
+	"chmod": true, "chown": true, "chgrp": true, "ln": true, "dd": true, "tee": true,
+	"truncate": true, "install": true, "shred": true, "unlink": true, "patch": true,
+	"mkfifo": true, "rsync": true, "tar": true, "unzip": true, "make": true,
+}
+
+// mutatingSubcommands are tool subcommands that modify the working tree
+var mutatingSubcommands = map[string]map[string]bool{
+	"git": {"add": true, "commit": true, "push": true, "pull": true, "merge": true, "rebase": true,
+		"reset": true, "checkout": true, "switch": true, "restore": true, "rm": true, "mv": true,
+		"clean": true, "stash": true, "apply": true, "am": true, "cherry-pick": true, "revert": true,
+		"init": true, "clone": true, "fetch": true, "tag": true, "branch": true},
+	"go":    {"mod": true, "get": true, "install": true, "generate": true, "fmt": true, "build": true},
+	"npm":   {"install": true, "i": true, "ci": true, "uninstall": true, "update": true, "init": true},
+	"yarn":  {"add": true, "install": true, "remove": true, "upgrade": true},
+	"pip":   {"install": true, "uninstall": true},
+	"cargo": {"build": true, "install": true, "add": true, "remove": true, "update": true},
+}
+
+// redirectPattern matches output redirections to anything other than /dev/null or another fd
+var redirectPattern = regexp.MustCompile(`(^|[^&<>])>{1,2}\s*([^&\s>]\S*)`)
+
+// segmentPattern splits a command line into pipeline and list segments
+var segmentPattern = regexp.MustCompile(`&&|\|\||[;|&\n]`)
+
+// ClassifyMutatingCommand reports whether a shell command is likely to modify files,
+// with a short reason. It is a best-effort check used when the sandbox cannot enforce
+// a read-only filesystem itself.
+func ClassifyMutatingCommand(command string) (string, bool) {
+	for _, m := range redirectPattern.FindAllStringSubmatch(command, -1) {
+		if m[2] != "/dev/null" {
+			return fmt.Sprintf("output redirection to %s", m[2]), true
+		}
+	}
+
+	// Check each pipeline/list segment separately
+	segments := segmentPattern.Split(command, -1)
+	for _, segment := range segments {
+		fields := strings.Fields(segment)
+		// Skip leading env assignments and wrappers like sudo/env
+		for len(fields) > 0 && (strings.Contains(fields[0], "=") || fields[0] == "sudo" || fields[0] == "env" || fields[0] == "command") {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		program := fields[0]
+		if idx := strings.LastIndex(program, "/"); idx >= 0 {
+			program = program[idx+1:]
+		}
+		if mutatingCommands[program] {
+			return fmt.Sprintf("'%s' modifies files", program), true
+		}
+		if program == "sed" || program == "perl" {
+			for _, f := range fields[1:] {
+				if strings.HasPrefix(f, "-i") || strings.HasPrefix(f, "-pi") {
+					return fmt.Sprintf("'%s %s' edits files in place", program, f), true
+				}
+			}
+		}
+		if subs, ok := mutatingSubcommands[program]; ok {
+			args := fields[1:]
+			for i := 0; i < len(args); i++ {
+				f := args[i]
+				if f == "-C" || f == "-c" {
+					i++ // Global option with a separate value, e.g. git -C <dir>
+					continue
+				}
+				if strings.HasPrefix(f, "-") {
+					continue
+				}
+				if subs[f] {
+					return fmt.Sprintf("'%s %s' modifies the working tree", program, f), true
+				}
+				break
+			}
+		}
+	}
+	return "", false
+}
+
+// refuseIfMutating returns a failed result when a read-only command looks mutating.
+// Sandboxes without filesystem isolation call this before running a command.
+func refuseIfMutating(opts SandboxOptions) (*CommandResult, error) {
+	if !opts.ReadOnly {
+		return nil, nil
+	}
+	reason, mutating := ClassifyMutatingCommand(opts.Command)
+	if !mutating {
+		return nil, nil
+	}
+	err := fmt.Errorf("%w (%s)", ErrReadOnly, reason)
+	return &CommandResult{
+		Stderr:     err.Error(),
+		ExitCode:   -1,
+		Success:    false,
+		Error:      err,
+		Command:    opts.Command,
+		WorkingDir: opts.WorkingDir,
+	}, err
+}
@@ -0,0 +1,51 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestClassifyMutatingCommand(t *testing.T) {
+	tests := []struct {
+		command  string
+		mutating bool
+	}{
+		{"ls -la", false},
+		{"cat main.go | grep func", false},
+		{"go test ./... 2>&1", false},
+		{"grep -r TODO . > /dev/null", false},
+		{"git log --oneline", false},
+		{"git status && git diff", false},
+		{"echo hi > out.txt", true},
+		{"echo hi >> out.txt", true},
+		{"rm -rf build", true},
+		{"ls && mv a b", true},
+		{"sed -i 's/a/b/' file.go", true},
+		{"git commit -m msg", true},
+		{"git -C repo checkout main", true},
+		{"FOO=1 /bin/touch x", true},
+		{"npm install", true},
+	}
+
+	for _, tt := range tests {
+		if _, mutating := ClassifyMutatingCommand(tt.command); mutating != tt.mutating {
+			t.Errorf("ClassifyMutatingCommand(%q): expected mutating=%t, got %t", tt.command, tt.mutating, mutating)
+		}
+	}
+}
+
+func TestBasicSandboxRefusesMutatingCommandInReadOnlyMode(t *testing.T) {
+	dir := t.TempDir()
+	result, err := NewBasicSandbox().Execute(context.Background(), SandboxOptions{
+		Command:    "touch created.txt",
+		WorkingDir: dir,
+		ReadOnly:   true,
+	})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Expected ErrReadOnly, got %v", err)
+	}
+	if result == nil || result.Success {
+		t.Fatalf("Expected a failed result, got %+v", result)
+	}
+}
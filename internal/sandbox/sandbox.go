@@ -112,7 +112,7 @@ func (e *BasicExecutor) Execute(ctx context.Context, command string, args []stri
 	cmd.Stderr = &stderr
 
 	// Run the command
-	err := cmd.Run()
+	err := runTracked(cmd)
 
 	// Create the result
 	result := &ExecutionResult{
@@ -218,7 +218,8 @@ func executeUnsandboxedCommand(cmd string) (*CommandResult, error) {
 	startTime := time.Now()
 
 	// Prepare the command for execution
-	execCmd := exec.Command("sh", "-c", cmd)
+	argv := shellArgs(nil, cmd)
+	execCmd := exec.Command(argv[0], argv[1:]...)
 
 	// Set up pipes for stdout and stderr
 	stdout, err := execCmd.StdoutPipe()
@@ -231,10 +232,13 @@ func executeUnsandboxedCommand(cmd string) (*CommandResult, error) {
 		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
 	}
 
-	// Start the command
+	// Start the command in its own process group so shutdown can stop it
+	setProcessGroup(execCmd)
 	if err := execCmd.Start(); err != nil {
 		return nil, fmt.Errorf("failed to start command: %w", err)
 	}
+	processes.add(execCmd)
+	defer processes.remove(execCmd)
 
 	// Read stdout and stderr
 	stdoutBytes, err := io.ReadAll(stdout)
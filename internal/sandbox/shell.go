@@ -0,0 +1,22 @@
+package sandbox
+
+import "runtime"
+
+// DefaultShell returns the shell commands run with when none is configured:
+// the program and flags the command string is appended to
+func DefaultShell() []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd.exe", "/C"}
+	}
+	return []string{"/bin/sh", "-c"}
+}
+
+// shellArgs returns the argv running command with shell, or with the
+// default shell when shell is empty
+func shellArgs(shell []string, command string) []string {
+	if len(shell) == 0 {
+		shell = DefaultShell()
+	}
+	args := make([]string, 0, len(shell)+1)
+	return append(append(args, shell...), command)
+}
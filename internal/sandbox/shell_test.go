@@ -0,0 +1,34 @@
+//go:build unix
+
+package sandbox
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+)
+
+func TestExecuteUsesConfiguredShell(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	command := "false | true; echo reached"
+
+	result, err := NewBasicSandbox().Execute(context.Background(), SandboxOptions{Command: command, WorkingDir: t.TempDir()})
+	if err != nil || !result.Success || result.Stdout != "reached\n" {
+		t.Fatalf("Expected the default shell to ignore the failed pipe stage, got %+v, %v", result, err)
+	}
+
+	result, err = NewBasicSandbox().Execute(context.Background(), SandboxOptions{
+		Command:    command,
+		Shell:      []string{bash, "-euo", "pipefail", "-c"},
+		WorkingDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if result.Success || result.Stdout != "" {
+		t.Errorf("Expected bash -euo pipefail to stop at the failed pipe stage, got %+v", result)
+	}
+}
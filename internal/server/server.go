@@ -0,0 +1,802 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/blobstore"
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/fileops"
+	"github.com/epuerta/codex-go/internal/functions"
+	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/epuerta/codex-go/internal/lsp"
+	"github.com/epuerta/codex-go/internal/memory"
+	"github.com/epuerta/codex-go/internal/policy"
+	"github.com/epuerta/codex-go/internal/sandbox"
+	"github.com/google/uuid"
+)
+
+// ExecutionMode selects where a session's tool calls are executed
+type ExecutionMode string
+
+const (
+	// ExecutionServer executes tool calls on the server, asking the client for approvals
+	ExecutionServer ExecutionMode = "server"
+	// ExecutionClient streams tool calls to the client, which posts results back
+	ExecutionClient ExecutionMode = "client"
+)
+
+const (
+	// DefaultSessionTimeout is how long an idle session is kept before cleanup
+	DefaultSessionTimeout = 30 * time.Minute
+	// DefaultApprovalTimeout is how long a server-side tool call waits for an approval
+	DefaultApprovalTimeout = 5 * time.Minute
+)
+
+// Options configures the HTTP server
+type Options struct {
+	AuthToken           string         // Bearer token required on every request (empty disables auth)
+	SessionTimeout      time.Duration  // Idle time after which a session is closed
+	ApprovalTimeout     time.Duration  // Time a server-side tool call waits for approval
+	SessionQueueLimit   int            // Requests that may wait for a busy session (0 = DefaultSessionQueueLimit, negative = none)
+	SessionQueueTimeout time.Duration  // Time a request waits for a busy session (0 = DefaultSessionQueueTimeout)
+	Policy              *policy.Policy // Approval rules applied to server-side tool calls (nil for none)
+}
+
+// AgentFactory creates the agent backing a new session
+type AgentFactory func(cfg *config.Config, logger logging.Logger) (*agent.OpenAIAgent, error)
+
+// Server exposes agent sessions over HTTP with SSE streaming
+type Server struct {
+	config   *config.Config
+	logger   logging.Logger
+	opts     Options
+	newAgent AgentFactory
+	memory   *memory.Store       // Project memory shared by all sessions (nil when disabled)
+	lsp      *lsp.Manager        // Language servers shared by all sessions (nil when unavailable)
+	executor *functions.Executor // Tool call queue shared by all sessions
+
+	sessions *SessionManager
+
+	stopJanitor chan struct{}
+	closeOnce   sync.Once
+}
+
+// session is a single agent conversation owned by the server
+type session struct {
+	id        string
+	agent     *agent.OpenAIAgent
+	execution ExecutionMode
+	registry  *functions.Registry
+	journal   *fileops.Journal // Pre-turn snapshots of files modified by server-side tools
+
+	lock sessionLock // Orders the streaming requests on this session, see SessionManager
+
+	mu           sync.Mutex
+	lastActive   time.Time
+	sink         func(event string, data []byte)
+	pendingCalls []agent.FunctionCall
+	approvals    map[string]chan approvalDecision
+	question     string // ID of the ask_user call awaiting the client's answer
+}
+
+// approvalDecision is the client's answer to an approval request
+type approvalDecision struct {
+	Approved    bool
+	DenyMessage string
+}
+
+// New creates a new server. The agent factory defaults to agent.NewOpenAIAgent.
+func New(cfg *config.Config, logger logging.Logger, opts Options, newAgent AgentFactory) *Server {
+	if logger == nil {
+		logger = logging.NewNilLogger()
+	}
+	if opts.SessionTimeout <= 0 {
+		opts.SessionTimeout = DefaultSessionTimeout
+	}
+	if opts.ApprovalTimeout <= 0 {
+		opts.ApprovalTimeout = DefaultApprovalTimeout
+	}
+	if newAgent == nil {
+		newAgent = agent.NewOpenAIAgent
+	}
+
+	var memoryStore *memory.Store
+	if !cfg.DisableMemory {
+		store, err := memory.OpenProject(cfg)
+		if err != nil {
+			logger.Log("[WARN] Server: Failed to open project memory: %v", err)
+		} else {
+			memoryStore = store
+		}
+	}
+
+	var languageServers *lsp.Manager
+	if !cfg.DisableLanguageServers && lsp.Available(cfg.LanguageServers) {
+		languageServers = lsp.NewManager(cfg.ToolDir(), cfg.LanguageServers)
+	}
+
+	s := &Server{
+		config:      cfg,
+		logger:      logger,
+		opts:        opts,
+		newAgent:    newAgent,
+		memory:      memoryStore,
+		lsp:         languageServers,
+		executor:    functions.NewExecutor(cfg.MaxConcurrentTools, cfg.ToolLimits()),
+		sessions:    NewSessionManager(opts.SessionQueueLimit, opts.SessionQueueTimeout),
+		stopJanitor: make(chan struct{}),
+	}
+	go s.janitor()
+	return s
+}
+
+// Handler returns the HTTP handler for the server's API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /sessions", s.handleCreateSession)
+	mux.HandleFunc("DELETE /sessions/{id}", s.handleDeleteSession)
+	mux.HandleFunc("POST /sessions/{id}/messages", s.handleMessages)
+	mux.HandleFunc("POST /sessions/{id}/tool_results", s.handleToolResults)
+	mux.HandleFunc("POST /sessions/{id}/approvals", s.handleApprovals)
+	mux.HandleFunc("POST /sessions/{id}/tool_calls/{call_id}/cancel", s.handleCancelToolCall)
+	return s.authenticate(mux)
+}
+
+// Close closes all sessions and stops background cleanup
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stopJanitor)
+	})
+
+	sessions := s.sessions.removeAll()
+	s.executor.Cancel()
+	for _, sess := range sessions {
+		sess.close()
+	}
+	if s.lsp != nil {
+		s.lsp.Close()
+	}
+	return nil
+}
+
+// authenticate rejects requests without the configured bearer token
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.opts.AuthToken != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.AuthToken)) != 1 {
+				writeError(w, http.StatusUnauthorized, "invalid or missing bearer token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// janitor periodically closes sessions that have been idle too long
+func (s *Server) janitor() {
+	interval := s.opts.SessionTimeout / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopJanitor:
+			return
+		case <-ticker.C:
+			s.expireIdleSessions(time.Now())
+		}
+	}
+}
+
+// expireIdleSessions closes sessions idle since before now minus the session timeout
+func (s *Server) expireIdleSessions(now time.Time) {
+	for _, sess := range s.sessions.removeIdle(now, s.opts.SessionTimeout) {
+		s.logger.Log("[INFO] Server: Session %s timed out, closing.", sess.id)
+		sess.close()
+	}
+}
+
+// lookup returns the session for the request's {id}, writing a 404 if missing
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) (*session, bool) {
+	id := r.PathValue("id")
+	sess, ok := s.sessions.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("session %s not found", id))
+		return nil, false
+	}
+	sess.touch()
+	return sess, true
+}
+
+// handleCreateSession handles POST /sessions
+func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Execution    ExecutionMode `json:"execution"`
+		AllowedTools []string      `json:"allowed_tools"` // Tools the session may use; empty allows all
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+			return
+		}
+	}
+	if body.Execution == "" {
+		body.Execution = ExecutionServer
+	}
+	if body.Execution != ExecutionServer && body.Execution != ExecutionClient {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid execution mode %q (expected %q or %q)", body.Execution, ExecutionServer, ExecutionClient))
+		return
+	}
+
+	// Each session gets its own copy of the config so per-session changes don't
+	// leak, and its own session directory: sessions don't take the project lock,
+	// so they must not share files with the interactive codex working there
+	id := uuid.New().String()
+	cfgCopy := *s.config
+	if cfgCopy.SessionDir != "" {
+		cfgCopy.SessionDir = filepath.Join(cfgCopy.SessionDir, "serve", id)
+	}
+	a, err := s.newAgent(&cfgCopy, s.logger)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to create agent: %v", err))
+		return
+	}
+	if err := a.SetAllowedTools(body.AllowedTools); err != nil {
+		a.Close()
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid allowed_tools: %v", err))
+		return
+	}
+
+	journal := fileops.NewJournal()
+	if cfgCopy.BlobStore {
+		journal.SetBlobStore(blobstore.Open(blobstore.DirFor(cfgCopy.ToolDir()), cfgCopy.BlobCompression), a.SessionID)
+	}
+	sess := &session{
+		id:         id,
+		agent:      a,
+		execution:  body.Execution,
+		registry:   functions.NewToolRegistry(s.config, journal, s.memory, s.lsp, a.Embedder()),
+		journal:    journal,
+		lastActive: time.Now(),
+		approvals:  make(map[string]chan approvalDecision),
+	}
+
+	s.sessions.add(sess)
+
+	s.logger.Log("[INFO] Server: Created session %s (execution: %s)", sess.id, sess.execution)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":            sess.id,
+		"execution":     sess.execution,
+		"allowed_tools": a.AllowedTools(),
+	})
+}
+
+// handleDeleteSession handles DELETE /sessions/{id}
+func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	if _, ok := s.sessions.remove(sess.id); !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("session %s not found", sess.id))
+		return
+	}
+	sess.close()
+	s.logger.Log("[INFO] Server: Deleted session %s", sess.id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMessages handles POST /sessions/{id}/messages, streaming the response over SSE
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Content   string `json:"content"`
+		ForceTool string `json:"force_tool"` // Tool the model must call in its response
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if body.Content == "" {
+		writeError(w, http.StatusBadRequest, "content is required")
+		return
+	}
+
+	if body.ForceTool != "" {
+		if err := sess.agent.ValidateForcedTool(body.ForceTool); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid force_tool: %v", err))
+			return
+		}
+	}
+
+	s.stream(w, r, sess, func(ctx context.Context) (bool, error) {
+		// Forced once the session is free, so a queued message cannot take the force of another
+		if body.ForceTool != "" {
+			if err := sess.agent.ForceTool(body.ForceTool); err != nil {
+				return false, err
+			}
+		}
+		return sess.agent.SendMessage(ctx, []agent.Message{{Role: "user", Content: body.Content}}, sess.handle)
+	})
+}
+
+// handleToolResults handles POST /sessions/{id}/tool_results for client-executed
+// tools, and for answers to ask_user in either execution mode
+func (s *Server) handleToolResults(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		CallID     string            `json:"call_id"`
+		Name       string            `json:"name"`
+		Output     string            `json:"output"`
+		Success    bool              `json:"success"`
+		ExitCode   *int              `json:"exit_code"`
+		DurationMs int64             `json:"duration_ms"`
+		Metadata   map[string]string `json:"metadata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if body.CallID == "" {
+		writeError(w, http.StatusBadRequest, "call_id is required")
+		return
+	}
+
+	s.stream(w, r, sess, func(ctx context.Context) (bool, error) {
+		result := agent.NewToolResult(body.CallID, body.Name, body.Output, body.Success)
+		result.ExitCode = body.ExitCode
+		result.DurationMs = body.DurationMs
+		result.Metadata = body.Metadata
+		err := sess.agent.SendToolResult(ctx, result)
+		return sess.hasPendingCalls(), err
+	})
+}
+
+// handleApprovals handles POST /sessions/{id}/approvals for server-executed tools
+func (s *Server) handleApprovals(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		CallID      string `json:"call_id"`
+		Approved    bool   `json:"approved"`
+		DenyMessage string `json:"deny_message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	sess.mu.Lock()
+	ch, exists := sess.approvals[body.CallID]
+	if exists {
+		delete(sess.approvals, body.CallID)
+	}
+	sess.mu.Unlock()
+
+	if !exists {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no approval pending for call %s", body.CallID))
+		return
+	}
+
+	ch <- approvalDecision{Approved: body.Approved, DenyMessage: body.DenyMessage}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCancelToolCall handles POST /sessions/{id}/tool_calls/{call_id}/cancel
+// for server-executed tools: the call is stopped alone and the model gets it
+// as cancelled by the user, while the turn goes on with the other calls
+func (s *Server) handleCancelToolCall(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	callID := r.PathValue("call_id")
+	if !sess.agent.IsToolCallPending(callID) || !s.executor.CancelCall(callID) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no tool call %s running", callID))
+		return
+	}
+	s.logger.Log("[INFO] Server: Cancelled tool call %s of session %s", callID, sess.id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// stream runs fn with the response attached as the session's SSE sink, once
+// the requests on the session that arrived before it are done. In server
+// execution mode any tool calls are executed before the stream ends.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, sess *session, fn func(ctx context.Context) (bool, error)) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported by response writer")
+		return
+	}
+
+	release, err := s.sessions.acquire(r.Context(), sess)
+	switch {
+	case errors.Is(err, errSessionClosed):
+		writeError(w, http.StatusNotFound, fmt.Sprintf("session %s not found", sess.id))
+		return
+	case err != nil:
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	defer release()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var writeMu sync.Mutex
+	send := func(event string, data []byte) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if event != "" {
+			fmt.Fprintf(w, "event: %s\n", event)
+		}
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+
+	sess.attach(send)
+	defer sess.attach(nil)
+
+	ctx := r.Context()
+	endedWithTools, err := fn(ctx)
+	if err == nil && endedWithTools && sess.execution == ExecutionServer {
+		err = s.runTools(ctx, sess)
+	}
+	if err != nil {
+		send("error", mustJSON(map[string]string{"error": err.Error()}))
+	}
+	s.emitTurnChanges(sess, send)
+
+	awaitingResults := sess.execution == ExecutionClient && sess.hasPendingCalls()
+	awaitingAnswer := sess.awaitingAnswer()
+	if !awaitingResults && !awaitingAnswer {
+		// Chunked writes the model never committed are abandoned with the turn
+		sess.registry.EndTurn()
+	}
+	send("done", mustJSON(map[string]interface{}{
+		"awaiting_tool_results": awaitingResults,
+		"awaiting_answer":       awaitingAnswer,
+	}))
+	sess.touch()
+}
+
+// emitTurnChanges sends the size of the change to files changed by
+// server-side tools during the turn as a "turn_diffstat" item and their
+// consolidated diff as a "turn_changes" event, then finalizes the turn
+func (s *Server) emitTurnChanges(sess *session, send func(event string, data []byte)) {
+	if sess.journal.Len() == 0 {
+		return
+	}
+	defer sess.journal.Reset()
+
+	stat := sess.journal.DiffStat()
+	if len(stat.Files) == 0 {
+		return
+	}
+	send("", mustJSON(agent.ResponseItem{Type: agent.EventTurnDiffStat, DiffStat: &stat}))
+	if s.config.NoReview {
+		return
+	}
+
+	send("turn_changes", mustJSON(map[string]interface{}{
+		"type":    "turn_changes",
+		"changes": sess.journal.Changes(),
+	}))
+}
+
+// runTools executes queued tool calls server-side until the model stops requesting them
+func (s *Server) runTools(ctx context.Context, sess *session) error {
+	for {
+		call, ok := sess.popPendingCall()
+		if !ok {
+			return nil
+		}
+
+		// Only the client's user can answer; it posts the answer as the tool result
+		if agent.IsQuestionTool(call.Name) {
+			sess.mu.Lock()
+			sess.question = call.ID
+			sess.mu.Unlock()
+			continue
+		}
+
+		callStarted := time.Now()
+		output, success := s.executeTool(ctx, sess, call)
+		result := agent.NewToolResult(call.ID, call.Name, output, success)
+		result.DurationMs = time.Since(callStarted).Milliseconds()
+		sess.emit("", mustJSON(agent.ResponseItem{
+			Type: agent.EventFunctionCallOutput,
+			FunctionOutput: &agent.FunctionCallOutput{
+				CallID:  call.ID,
+				Output:  output,
+				Success: success,
+			},
+		}))
+
+		if err := sess.agent.SendToolResult(ctx, result); err != nil {
+			return err
+		}
+	}
+}
+
+// executeTool runs a single tool call, asking the client for approval when required
+func (s *Server) executeTool(ctx context.Context, sess *session, call agent.FunctionCall) (string, bool) {
+	if s.config.ReadOnly && agent.IsMutatingTool(call.Name) {
+		return fmt.Sprintf("Policy error: '%s' is not available because this session is read-only.", call.Name), false
+	}
+	if s.config.ToolDisabled(call.Name) {
+		return fmt.Sprintf("Policy error: '%s' is disabled by the tools configuration.", call.Name), false
+	}
+	if !sess.agent.ToolAllowed(call.Name) {
+		return fmt.Sprintf("Policy error: '%s' is not allowed in this session.", call.Name), false
+	}
+
+	needsApproval := functions.NeedsApproval(s.config.ApprovalMode, call.Name) || s.writesOutsideWorkspace(sess, call)
+	if match, requiresApproval, ok := functions.PolicyApproval(s.opts.Policy, s.config.ApprovalMode, sess.registry.Workspace(), call.Name, call.Arguments); ok {
+		if match.Decision == policy.Deny {
+			return fmt.Sprintf("Policy error: '%s' was denied: %s", call.Name, match.Explain()), false
+		}
+		needsApproval = requiresApproval
+	}
+	if needsApproval {
+		decision, err := s.awaitApproval(ctx, sess, call)
+		if err != nil {
+			return fmt.Sprintf("Approval for '%s' failed: %v", call.Name, err), false
+		}
+		if !decision.Approved {
+			if decision.DenyMessage != "" {
+				return fmt.Sprintf("Operation '%s' denied by user: %s", call.Name, decision.DenyMessage), false
+			}
+			return fmt.Sprintf("Operation '%s' denied by user.", call.Name), false
+		}
+	}
+
+	fn := sess.registry.GetContext(call.Name)
+	if fn == nil {
+		return fmt.Sprintf("Unknown function: %s", call.Name), false
+	}
+	// Calls from every session share the executor's limits; a queued call
+	// reports its position, and then its start, so clients can show that it waits.
+	// Updates come from whichever goroutine frees a slot, hence the lock.
+	var queueMu sync.Mutex
+	queued := false
+	result, err := s.executor.Run(functions.WithCallID(ctx, call.ID), call.Name, fn, call.Arguments, func(status functions.QueueStatus) {
+		queueMu.Lock()
+		defer queueMu.Unlock()
+		if status.Position == 0 && !queued {
+			return
+		}
+		queued = true
+		sess.emit("", mustJSON(map[string]interface{}{
+			"type":    "status",
+			"call_id": call.ID,
+			"queue":   status,
+		}))
+	})
+	if errors.Is(err, functions.ErrCancelledByUser) {
+		return agent.ToolCallCancelled, false
+	}
+	var shellErr *functions.ShellError
+	if errors.As(err, &shellErr) {
+		return shellErr.Result.String(), false
+	}
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err), false
+	}
+	if call.Name == "change_directory" {
+		// Persisted with the session and stated in the prompt of later requests
+		if err := sess.agent.SetWorkingDir(sess.registry.Workspace().Cwd()); err != nil {
+			s.logger.Log("[WARN] Server: Failed to record the working directory of session %s: %v", sess.id, err)
+		}
+	}
+	return result, true
+}
+
+// writesOutsideWorkspace reports whether a shell call is likely to write outside
+// the workspace, run in the session's working directory, which requires
+// approval even in auto modes
+func (s *Server) writesOutsideWorkspace(sess *session, call agent.FunctionCall) bool {
+	if s.config.ApprovalMode == config.DangerousAutoApprove {
+		return false
+	}
+	command, ok := functions.ShellCommand(call.Name, call.Arguments)
+	if !ok {
+		return false
+	}
+	workspace := sess.registry.Workspace()
+	return len(sandbox.AnalyzeCommand(command).WritesOutsideFrom(workspace.Cwd(), workspace.Dir)) > 0
+}
+
+// awaitApproval emits an approval request and blocks until the client answers
+func (s *Server) awaitApproval(ctx context.Context, sess *session, call agent.FunctionCall) (approvalDecision, error) {
+	ch := make(chan approvalDecision, 1)
+	sess.mu.Lock()
+	sess.approvals[call.ID] = ch
+	sess.mu.Unlock()
+	defer func() {
+		sess.mu.Lock()
+		delete(sess.approvals, call.ID)
+		sess.mu.Unlock()
+	}()
+
+	request := map[string]interface{}{
+		"call_id":   call.ID,
+		"name":      call.Name,
+		"arguments": call.Arguments,
+	}
+	if command, ok := functions.ShellCommand(call.Name, call.Arguments); ok {
+		request["effects"] = sandbox.AnalyzeCommand(command)
+	}
+	sess.emit("approval_request", mustJSON(request))
+
+	timer := time.NewTimer(s.opts.ApprovalTimeout)
+	defer timer.Stop()
+
+	select {
+	case decision := <-ch:
+		return decision, nil
+	case <-timer.C:
+		return approvalDecision{}, errors.New("timed out waiting for approval")
+	case <-ctx.Done():
+		return approvalDecision{}, ctx.Err()
+	}
+}
+
+// handle is the agent's response handler; it records tool calls and forwards items to the sink
+func (sess *session) handle(itemJSON string) {
+	var item agent.ResponseItem
+	if err := json.Unmarshal([]byte(itemJSON), &item); err == nil {
+		if item.Type == "function_call" && item.FunctionCall != nil {
+			sess.mu.Lock()
+			sess.pendingCalls = append(sess.pendingCalls, *item.FunctionCall)
+			sess.mu.Unlock()
+		}
+		// A call of a cancelled turn was answered as aborted; nothing waits on it now
+		if item.Type == "tool_aborted" && item.FunctionCall != nil {
+			sess.forgetCall(item.FunctionCall.ID)
+		}
+	}
+	sess.emit("", []byte(itemJSON))
+}
+
+// forgetCall drops the recorded tool call callID, and the question it asked
+func (sess *session) forgetCall(callID string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.pendingCalls = slices.DeleteFunc(sess.pendingCalls, func(call agent.FunctionCall) bool { return call.ID == callID })
+	if sess.question == callID {
+		sess.question = ""
+	}
+}
+
+// emit sends an event to the attached sink, if any
+func (sess *session) emit(event string, data []byte) {
+	sess.mu.Lock()
+	sink := sess.sink
+	sess.mu.Unlock()
+	if sink != nil {
+		sink(event, data)
+	}
+}
+
+// attach sets the sink receiving this session's events
+func (sess *session) attach(sink func(event string, data []byte)) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.sink = sink
+	if sink != nil && sess.execution == ExecutionServer {
+		sess.pendingCalls = nil
+	}
+}
+
+// popPendingCall removes and returns the oldest recorded tool call
+func (sess *session) popPendingCall() (agent.FunctionCall, bool) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if len(sess.pendingCalls) == 0 {
+		return agent.FunctionCall{}, false
+	}
+	call := sess.pendingCalls[0]
+	sess.pendingCalls = sess.pendingCalls[1:]
+	return call, true
+}
+
+// hasPendingCalls reports whether tool calls are still awaiting results.
+// In client mode calls are tracked by the agent, so consult it there.
+func (sess *session) hasPendingCalls() bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.execution == ExecutionClient {
+		pending := sess.pendingCalls[:0]
+		for _, call := range sess.pendingCalls {
+			if sess.agent.IsToolCallPending(call.ID) {
+				pending = append(pending, call)
+			}
+		}
+		sess.pendingCalls = pending
+	}
+	return len(sess.pendingCalls) > 0
+}
+
+// awaitingAnswer reports whether an ask_user call is waiting for the
+// client to post the user's answer to tool_results
+func (sess *session) awaitingAnswer() bool {
+	sess.mu.Lock()
+	question := sess.question
+	sess.mu.Unlock()
+	if sess.execution == ExecutionClient {
+		return false // The call is one of the awaited tool results
+	}
+	return question != "" && sess.agent.IsToolCallPending(question)
+}
+
+// touch marks the session as active now
+func (sess *session) touch() {
+	sess.mu.Lock()
+	sess.lastActive = time.Now()
+	sess.mu.Unlock()
+}
+
+// idleSince returns when the session was last active
+func (sess *session) idleSince() time.Time {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.lastActive
+}
+
+// close cancels any in-flight work and releases the session's agent
+func (sess *session) close() {
+	sess.agent.Cancel()
+	sess.agent.Close()
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// mustJSON marshals v, falling back to an error object
+func mustJSON(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return []byte(fmt.Sprintf(`{"error":%q}`, err.Error()))
+	}
+	return data
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/fileops"
+	"github.com/epuerta/codex-go/internal/functions"
+	"github.com/epuerta/codex-go/internal/logging"
+	"github.com/epuerta/codex-go/internal/policy"
+)
+
+func newTestServer(t *testing.T, opts Options) (*Server, *httptest.Server) {
+	t.Helper()
+	cfg := &config.Config{
+		APIKey:       "test-key",
+		Model:        "gpt-4o",
+		ApprovalMode: config.Suggest,
+	}
+	srv := New(cfg, nil, opts, nil)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Close()
+	})
+	return srv, ts
+}
+
+func doRequest(t *testing.T, method, url, token, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func createSession(t *testing.T, ts *httptest.Server, token, body string) string {
+	t.Helper()
+	resp := doRequest(t, http.MethodPost, ts.URL+"/sessions", token, body)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if created.ID == "" {
+		t.Fatalf("Expected a session ID in the response")
+	}
+	return created.ID
+}
+
+func TestServerAuthToken(t *testing.T) {
+	_, ts := newTestServer(t, Options{AuthToken: "secret"})
+
+	if resp := doRequest(t, http.MethodPost, ts.URL+"/sessions", "", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without token, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+	if resp := doRequest(t, http.MethodPost, ts.URL+"/sessions", "wrong", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d with wrong token, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+	createSession(t, ts, "secret", "")
+}
+
+func TestServerSessionLifecycle(t *testing.T) {
+	srv, ts := newTestServer(t, Options{})
+
+	if resp := doRequest(t, http.MethodPost, ts.URL+"/sessions", "", `{"execution":"bogus"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d for invalid execution mode, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	id := createSession(t, ts, "", `{"execution":"client"}`)
+	if sess, _ := srv.sessions.get(id); sess.execution != ExecutionClient {
+		t.Errorf("Expected execution mode %q, got %q", ExecutionClient, sess.execution)
+	}
+
+	if resp := doRequest(t, http.MethodPost, ts.URL+"/sessions/"+id+"/messages", "", `{}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d for empty content, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	if resp := doRequest(t, http.MethodDelete, ts.URL+"/sessions/"+id, "", ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected status %d on delete, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if resp := doRequest(t, http.MethodDelete, ts.URL+"/sessions/"+id, "", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d for deleted session, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestServerExpiresIdleSessions(t *testing.T) {
+	srv, ts := newTestServer(t, Options{SessionTimeout: time.Hour})
+
+	id := createSession(t, ts, "", "")
+	srv.expireIdleSessions(time.Now())
+	if _, exists := srv.sessions.get(id); !exists {
+		t.Fatalf("Expected active session to survive cleanup")
+	}
+
+	srv.expireIdleSessions(time.Now().Add(2 * time.Hour))
+	if _, exists := srv.sessions.get(id); exists {
+		t.Errorf("Expected idle session to be removed")
+	}
+}
+
+func TestServerApprovalWithoutPendingCall(t *testing.T) {
+	_, ts := newTestServer(t, Options{})
+	id := createSession(t, ts, "", "")
+
+	resp := doRequest(t, http.MethodPost, ts.URL+"/sessions/"+id+"/approvals", "", `{"call_id":"missing","approved":true}`)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestServerCancelWithoutRunningCall(t *testing.T) {
+	_, ts := newTestServer(t, Options{})
+	id := createSession(t, ts, "", "")
+
+	resp := doRequest(t, http.MethodPost, ts.URL+"/sessions/"+id+"/tool_calls/missing/cancel", "", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestServerEmitsTurnChanges(t *testing.T) {
+	srv, _ := newTestServer(t, Options{})
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	journal := fileops.NewJournal()
+	sess := &session{registry: functions.NewToolRegistry(srv.config, journal, nil, nil, nil), journal: journal}
+
+	args := mustJSON(map[string]string{"path": path, "content": "hello\n"})
+	if _, err := sess.registry.Get("write_file")(string(args)); err != nil {
+		t.Fatalf("write_file failed: %v", err)
+	}
+
+	var events []string
+	var item agent.ResponseItem
+	var payload struct {
+		Type    string               `json:"type"`
+		Changes []fileops.FileChange `json:"changes"`
+	}
+	srv.emitTurnChanges(sess, func(event string, data []byte) {
+		events = append(events, event)
+		if event == "" {
+			json.Unmarshal(data, &item)
+		} else {
+			json.Unmarshal(data, &payload)
+		}
+	})
+
+	if len(events) != 2 || events[0] != "" || events[1] != "turn_changes" {
+		t.Fatalf("Expected a turn_diffstat item and a turn_changes event, got %v", events)
+	}
+	if item.Type != "turn_diffstat" || item.DiffStat == nil || item.DiffStat.String() != "1 file changed, +1 -0" {
+		t.Errorf("Expected a turn_diffstat item for one added line, got %+v", item)
+	}
+	if len(payload.Changes) != 1 || payload.Changes[0].Status != "created" {
+		t.Fatalf("Expected one created file, got %+v", payload.Changes)
+	}
+	if sess.journal.Len() != 0 {
+		t.Errorf("Expected the journal to be reset after the turn, got %d entries", sess.journal.Len())
+	}
+}
+
+func TestServerRecordsSessionWorkingDir(t *testing.T) {
+	srv, _ := newTestServer(t, Options{})
+	srv.config.WorkingDir = t.TempDir()
+	sub := filepath.Join(srv.config.WorkingDir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	a, err := agent.NewOpenAIAgent(srv.config, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	defer a.Close()
+	sess := &session{agent: a, registry: functions.NewToolRegistry(srv.config, fileops.NewJournal(), nil, nil, nil)}
+
+	if output, ok := srv.executeTool(context.Background(), sess, agent.FunctionCall{ID: "call_1", Name: "change_directory", Arguments: `{"path":"sub"}`}); !ok {
+		t.Fatalf("change_directory failed: %s", output)
+	}
+	if got := a.WorkingDir(); got != sub {
+		t.Errorf("Expected the session working directory %s recorded with the agent, got %q", sub, got)
+	}
+
+	// Writes are checked from the session working directory
+	if srv.writesOutsideWorkspace(sess, agent.FunctionCall{Name: "shell", Arguments: `{"command":"touch ../inside"}`}) {
+		t.Errorf("Expected ../inside from %s to be inside the workspace", sub)
+	}
+	if !srv.writesOutsideWorkspace(sess, agent.FunctionCall{Name: "shell", Arguments: `{"command":"touch ../../outside"}`}) {
+		t.Errorf("Expected ../../outside from %s to be outside the workspace", sub)
+	}
+}
+
+func TestServerRefusesCallsThePolicyDenies(t *testing.T) {
+	rules, err := policy.Parse([]byte("rules:\n  - tool: shell\n    command_prefix: touch\n    decision: deny\n"), "policy.yaml")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	srv, _ := newTestServer(t, Options{Policy: policy.Merge(rules, nil)})
+	srv.config.WorkingDir = t.TempDir()
+	srv.config.ApprovalMode = config.DangerousAutoApprove
+	a, err := agent.NewOpenAIAgent(srv.config, nil)
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	defer a.Close()
+	sess := &session{agent: a, registry: functions.NewToolRegistry(srv.config, fileops.NewJournal(), nil, nil, nil)}
+
+	output, success := srv.executeTool(context.Background(), sess, agent.FunctionCall{ID: "call_1", Name: "shell", Arguments: `{"command":"touch denied"}`})
+	if success || !strings.Contains(output, "Policy error") {
+		t.Errorf("Expected the policy to deny the shell call, got %q (success %v)", output, success)
+	}
+	if _, err := os.Stat(filepath.Join(srv.config.WorkingDir, "denied")); !os.IsNotExist(err) {
+		t.Errorf("Expected the denied command not to run, got %v", err)
+	}
+}
+
+func TestSessionForgetsAbortedCalls(t *testing.T) {
+	sess := &session{pendingCalls: []agent.FunctionCall{{ID: "call_1", Name: "shell"}, {ID: "call_2", Name: "ask_user"}}, question: "call_2"}
+
+	sess.handle(string(mustJSON(agent.ResponseItem{Type: "tool_aborted", FunctionCall: &agent.FunctionCall{ID: "call_2", Name: "ask_user"}})))
+
+	if len(sess.pendingCalls) != 1 || sess.pendingCalls[0].ID != "call_1" {
+		t.Errorf("Expected only call_1 to stay pending, got %+v", sess.pendingCalls)
+	}
+	if sess.question != "" {
+		t.Errorf("Expected the aborted question to be forgotten, got %q", sess.question)
+	}
+}
+
+func TestServerSessionsUseTheirOwnSessionDir(t *testing.T) {
+	dir := t.TempDir()
+	var dirs []string
+	srv := New(&config.Config{APIKey: "test-key", Model: "gpt-4o", SessionDir: dir}, nil, Options{},
+		func(cfg *config.Config, logger logging.Logger) (*agent.OpenAIAgent, error) {
+			dirs = append(dirs, cfg.SessionDir)
+			return agent.NewOpenAIAgent(cfg, logger)
+		})
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Close()
+	})
+
+	first := createSession(t, ts, "", "")
+	second := createSession(t, ts, "", "")
+	want := []string{filepath.Join(dir, "serve", first), filepath.Join(dir, "serve", second)}
+	if !slices.Equal(dirs, want) {
+		t.Errorf("Expected session directories %v, got %v", want, dirs)
+	}
+}
+
+func TestServerEnforcesAllowedTools(t *testing.T) {
+	srv, ts := newTestServer(t, Options{})
+
+	if resp := doRequest(t, http.MethodPost, ts.URL+"/sessions", "", `{"allowed_tools":["no_such_tool"]}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown allowed tool, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	id := createSession(t, ts, "", `{"allowed_tools":["read_file"]}`)
+	sess, _ := srv.sessions.get(id)
+	if got := sess.agent.AllowedTools(); !slices.Equal(got, []string{"read_file"}) {
+		t.Fatalf("Expected the session limited to read_file, got %v", got)
+	}
+	output, success := srv.executeTool(context.Background(), sess, agent.FunctionCall{ID: "call_1", Name: "shell", Arguments: `{"command":["ls"]}`})
+	if success || !strings.Contains(output, "not allowed in this session") {
+		t.Errorf("Expected shell denied by the allowlist, got %q (success %v)", output, success)
+	}
+
+	resp := doRequest(t, http.MethodPost, ts.URL+"/sessions/"+id+"/messages", "", `{"content":"List the files","force_tool":"shell"}`)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "not allowed in this session") {
+		t.Errorf("Expected forcing shell outside the allowlist to be rejected with status %d, got %d: %s", http.StatusBadRequest, resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSessionQueueLimit is how many requests may wait for a busy session
+	DefaultSessionQueueLimit = 8
+	// DefaultSessionQueueTimeout is how long a request waits for a busy session
+	DefaultSessionQueueTimeout = 10 * time.Minute
+)
+
+var (
+	// errSessionBusy is returned to a request for a session whose queue is full
+	errSessionBusy = errors.New("session is busy with another request")
+	// errSessionQueueTimeout is returned to a request that waited too long for its session
+	errSessionQueueTimeout = errors.New("timed out waiting for the session's previous requests")
+	// errSessionClosed is returned to requests still waiting when their session is closed
+	errSessionClosed = errors.New("session was closed")
+)
+
+// SessionManager owns the sessions of a server and orders the requests on
+// each of them. Requests that stream from a session's agent (messages and
+// tool results) hold the session's lock for their whole turn, so they never
+// interleave in its history:
+//
+//   - Requests on the same session run one at a time, in the order they
+//     acquired the lock, which is the order they arrived in. A request that
+//     finds the session busy waits its turn behind the running one and the
+//     requests that arrived before it.
+//   - At most queueLimit requests wait per session; further ones are
+//     rejected right away, as are all waiting ones when the limit is negative.
+//     A request waiting longer than queueTimeout, or whose client goes away,
+//     leaves the queue without running.
+//   - Requests on different sessions do not wait on each other.
+//
+// Approvals and tool call cancellations do not take the lock: they answer
+// the turn that holds it.
+type SessionManager struct {
+	queueLimit   int
+	queueTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// NewSessionManager creates a manager queueing up to queueLimit requests per
+// session for up to queueTimeout each, with the defaults for zero values. A
+// negative queueLimit rejects requests to a busy session instead of queueing them.
+func NewSessionManager(queueLimit int, queueTimeout time.Duration) *SessionManager {
+	if queueLimit == 0 {
+		queueLimit = DefaultSessionQueueLimit
+	}
+	if queueLimit < 0 {
+		queueLimit = 0
+	}
+	if queueTimeout <= 0 {
+		queueTimeout = DefaultSessionQueueTimeout
+	}
+	return &SessionManager{
+		queueLimit:   queueLimit,
+		queueTimeout: queueTimeout,
+		sessions:     make(map[string]*session),
+	}
+}
+
+// Len returns the number of open sessions
+func (m *SessionManager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sessions)
+}
+
+// add registers sess under its ID
+func (m *SessionManager) add(sess *session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[sess.id] = sess
+}
+
+// get returns the session with the given ID
+func (m *SessionManager) get(id string) (*session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, ok := m.sessions[id]
+	return sess, ok
+}
+
+// remove unregisters the session with the given ID, reporting whether it was
+// registered. Requests waiting for it fail with errSessionClosed.
+func (m *SessionManager) remove(id string) (*session, bool) {
+	m.mu.Lock()
+	sess, ok := m.sessions[id]
+	delete(m.sessions, id)
+	m.mu.Unlock()
+	if ok {
+		sess.lock.close()
+	}
+	return sess, ok
+}
+
+// removeAll unregisters every session and returns them
+func (m *SessionManager) removeAll() []*session {
+	m.mu.Lock()
+	sessions := make([]*session, 0, len(m.sessions))
+	for _, sess := range m.sessions {
+		sessions = append(sessions, sess)
+	}
+	m.sessions = make(map[string]*session)
+	m.mu.Unlock()
+
+	for _, sess := range sessions {
+		sess.lock.close()
+	}
+	return sessions
+}
+
+// removeIdle unregisters and returns the sessions idle since before now
+// minus timeout. Sessions running or queueing a request are never idle.
+func (m *SessionManager) removeIdle(now time.Time, timeout time.Duration) []*session {
+	var expired []*session
+	m.mu.Lock()
+	for id, sess := range m.sessions {
+		if now.Sub(sess.idleSince()) > timeout && !sess.lock.busy() {
+			expired = append(expired, sess)
+			delete(m.sessions, id)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, sess := range expired {
+		sess.lock.close()
+	}
+	return expired
+}
+
+// acquire waits until sess is free for a request, per the ordering above.
+// The caller must call the returned release when the request is done.
+func (m *SessionManager) acquire(ctx context.Context, sess *session) (release func(), err error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, m.queueTimeout, errSessionQueueTimeout)
+	defer cancel()
+	if err := sess.lock.acquire(ctx, m.queueLimit); err != nil {
+		return nil, err
+	}
+	return sess.lock.release, nil
+}
+
+// sessionLock is a FIFO lock: waiters are granted it in the order they asked
+type sessionLock struct {
+	mu      sync.Mutex
+	held    bool
+	closed  bool
+	waiters []chan error // Granted with nil, or failed with errSessionClosed
+}
+
+// acquire takes the lock, waiting behind at most limit earlier waiters
+func (l *sessionLock) acquire(ctx context.Context, limit int) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return errSessionClosed
+	}
+	if !l.held {
+		l.held = true
+		l.mu.Unlock()
+		return nil
+	}
+	if len(l.waiters) >= limit {
+		l.mu.Unlock()
+		return errSessionBusy
+	}
+	grant := make(chan error, 1)
+	l.waiters = append(l.waiters, grant)
+	l.mu.Unlock()
+
+	select {
+	case err := <-grant:
+		return err
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, waiter := range l.waiters {
+		if waiter == grant {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return context.Cause(ctx)
+		}
+	}
+	// Granted or failed while giving up: a granted lock passes on to the next waiter
+	if err := <-grant; err == nil {
+		l.releaseLocked()
+	}
+	return context.Cause(ctx)
+}
+
+// release hands the lock to the longest waiting request, or frees it
+func (l *sessionLock) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+// releaseLocked is release for callers holding l.mu
+func (l *sessionLock) releaseLocked() {
+	if len(l.waiters) == 0 {
+		l.held = false
+		return
+	}
+	next := l.waiters[0]
+	l.waiters = l.waiters[1:]
+	next <- nil
+}
+
+// busy reports whether a request holds the lock or waits for it
+func (l *sessionLock) busy() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.held || len(l.waiters) > 0
+}
+
+// close fails the waiting requests and every later one; the running
+// request, if any, finishes
+func (l *sessionLock) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	for _, waiter := range l.waiters {
+		waiter <- errSessionClosed
+	}
+	l.waiters = nil
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForWaiters blocks until n requests wait for sess
+func waitForWaiters(t *testing.T, sess *session, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		sess.lock.mu.Lock()
+		waiting := len(sess.lock.waiters)
+		sess.lock.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d waiting requests, got %d", n, waiting)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSessionRequestsRunInArrivalOrder(t *testing.T) {
+	m := NewSessionManager(0, 0)
+	sess, other := &session{id: "a"}, &session{id: "b"}
+	m.add(sess)
+	m.add(other)
+
+	release, err := m.acquire(context.Background(), sess)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	// Other sessions are not held up
+	releaseOther, err := m.acquire(context.Background(), other)
+	if err != nil {
+		t.Fatalf("Expected another session to be free, got %v", err)
+	}
+	releaseOther()
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 1; i <= 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := m.acquire(context.Background(), sess)
+			if err != nil {
+				t.Errorf("Request %d failed: %v", i, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			release()
+		}()
+		waitForWaiters(t, sess, i)
+	}
+	release()
+	wg.Wait()
+
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 3 {
+		t.Errorf("Expected the requests to run in arrival order, got %v", order)
+	}
+	if sess.lock.busy() {
+		t.Errorf("Expected the session to be free once every request is done")
+	}
+}
+
+func TestSessionQueueLimits(t *testing.T) {
+	m := NewSessionManager(1, 20*time.Millisecond)
+	sess := &session{id: "a"}
+	release, _ := m.acquire(context.Background(), sess)
+	defer release()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.acquire(context.Background(), sess)
+		done <- err
+	}()
+	waitForWaiters(t, sess, 1)
+	if _, err := m.acquire(context.Background(), sess); !errors.Is(err, errSessionBusy) {
+		t.Errorf("Expected a full queue to reject the request, got %v", err)
+	}
+	if err := <-done; !errors.Is(err, errSessionQueueTimeout) {
+		t.Errorf("Expected the queued request to time out, got %v", err)
+	}
+	waitForWaiters(t, sess, 0)
+
+	// Without a queue, a busy session rejects requests at once
+	if _, err := NewSessionManager(-1, 0).acquire(context.Background(), sess); !errors.Is(err, errSessionBusy) {
+		t.Errorf("Expected a busy session to reject the request, got %v", err)
+	}
+}
+
+func TestRemovedSessionFailsWaitingRequests(t *testing.T) {
+	m := NewSessionManager(0, 0)
+	sess := &session{id: "a"}
+	m.add(sess)
+	release, _ := m.acquire(context.Background(), sess)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.acquire(context.Background(), sess)
+		done <- err
+	}()
+	waitForWaiters(t, sess, 1)
+	if expired := m.removeIdle(time.Now().Add(time.Hour), time.Minute); len(expired) != 0 {
+		t.Errorf("Expected a busy session never to expire, got %d expired", len(expired))
+	}
+	if _, ok := m.remove("a"); !ok {
+		t.Fatalf("Expected the session to be removed")
+	}
+	if err := <-done; !errors.Is(err, errSessionClosed) {
+		t.Errorf("Expected the waiting request to fail, got %v", err)
+	}
+	release()
+	if m.Len() != 0 || sess.lock.busy() {
+		t.Errorf("Expected no session left and the lock free")
+	}
+}
@@ -0,0 +1,253 @@
+package sessionimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/agent"
+)
+
+// chatGPTConversation is one conversation of a ChatGPT export. Messages form
+// a tree, since edits and regenerations branch it; current_node is the leaf
+// of the branch that was showing.
+type chatGPTConversation struct {
+	Title          string                     `json:"title"`
+	CreateTime     float64                    `json:"create_time"`
+	UpdateTime     float64                    `json:"update_time"`
+	Mapping        map[string]json.RawMessage `json:"mapping"`
+	CurrentNode    string                     `json:"current_node"`
+	ConversationID string                     `json:"conversation_id"`
+	ID             string                     `json:"id"`
+	nodes          map[string]*chatGPTNode    // Decoded mapping
+}
+
+type chatGPTNode struct {
+	ID       string          `json:"id"`
+	Message  json.RawMessage `json:"message"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+}
+
+type chatGPTMessage struct {
+	ID     string `json:"id"`
+	Author struct {
+		Role string `json:"role"`
+		Name string `json:"name"`
+	} `json:"author"`
+	CreateTime float64         `json:"create_time"`
+	Content    json.RawMessage `json:"content"`
+	Recipient  string          `json:"recipient"`
+	Metadata   struct {
+		Hidden bool `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+type chatGPTContent struct {
+	ContentType string            `json:"content_type"`
+	Parts       []json.RawMessage `json:"parts"`
+	Text        string            `json:"text"`
+	Language    string            `json:"language"`
+}
+
+var (
+	chatGPTConversationFields = []string{
+		"title", "create_time", "update_time", "mapping", "current_node", "conversation_id", "id",
+		"moderation_results", "plugin_ids", "gizmo_id", "gizmo_type", "is_archived", "is_starred",
+		"conversation_template_id", "default_model_slug", "safe_urls", "blocked_urls", "async_status",
+		"disabled_tool_ids", "is_do_not_remember", "memory_scope", "conversation_origin", "voice",
+	}
+	chatGPTNodeFields    = []string{"id", "message", "parent", "children"}
+	chatGPTMessageFields = []string{
+		"id", "author", "create_time", "update_time", "content", "status", "end_turn", "weight",
+		"metadata", "recipient", "channel",
+	}
+)
+
+// importChatGPT converts the conversations of a ChatGPT export, each into its own session
+func importChatGPT(data []byte, source string) ([]Session, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse ChatGPT export: %w", err)
+	}
+
+	var sessions []Session
+	for i, rawConv := range raw {
+		var conv chatGPTConversation
+		if err := json.Unmarshal(rawConv, &conv); err != nil || conv.Mapping == nil {
+			return nil, fmt.Errorf("failed to parse ChatGPT conversation %d: not a conversation", i)
+		}
+		b := newBuilder(FormatChatGPT, source)
+		b.info.Title = conv.Title
+		b.info.SourceID = conv.ConversationID
+		if b.info.SourceID == "" {
+			b.info.SourceID = conv.ID
+		}
+		b.warnings.checkFields(rawConv, "conversation", chatGPTConversationFields...)
+
+		conv.nodes = make(map[string]*chatGPTNode, len(conv.Mapping))
+		for id, rawNode := range conv.Mapping {
+			var node chatGPTNode
+			if err := json.Unmarshal(rawNode, &node); err != nil {
+				b.warnings.add("skipped node %s: %v", id, err)
+				continue
+			}
+			b.warnings.checkFields(rawNode, "conversation node", chatGPTNodeFields...)
+			conv.nodes[id] = &node
+		}
+
+		for _, node := range conv.branch() {
+			if len(node.Message) == 0 || string(node.Message) == "null" {
+				continue
+			}
+			b.warnings.checkFields(node.Message, "message", chatGPTMessageFields...)
+			var msg chatGPTMessage
+			if err := json.Unmarshal(node.Message, &msg); err != nil {
+				b.warnings.add("skipped message %s: %v", node.ID, err)
+				continue
+			}
+			b.addChatGPTMessage(msg)
+		}
+		sessions = append(sessions, b.session(unixTime(conv.CreateTime), unixTime(conv.UpdateTime)))
+	}
+	return sessions, nil
+}
+
+// branch returns the nodes from the root to the current node. Without a
+// current node it follows the most recent child at every fork.
+func (c *chatGPTConversation) branch() []*chatGPTNode {
+	var path []*chatGPTNode
+	if node := c.nodes[c.CurrentNode]; node != nil {
+		seen := make(map[string]bool)
+		for node != nil && !seen[node.ID] {
+			seen[node.ID] = true
+			path = append(path, node)
+			node = c.nodes[node.Parent]
+		}
+		for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+			path[i], path[j] = path[j], path[i]
+		}
+		return path
+	}
+
+	var root *chatGPTNode
+	for _, node := range c.nodes {
+		if c.nodes[node.Parent] == nil && (root == nil || node.ID < root.ID) {
+			root = node
+		}
+	}
+	seen := make(map[string]bool)
+	for node := root; node != nil && !seen[node.ID]; {
+		seen[node.ID] = true
+		path = append(path, node)
+		if len(node.Children) == 0 {
+			break
+		}
+		node = c.nodes[node.Children[len(node.Children)-1]]
+	}
+	return path
+}
+
+// addChatGPTMessage converts one message. Messages an assistant addressed to
+// a tool (python, browser, ...) become tool calls, and the tool's reply their result.
+func (b *builder) addChatGPTMessage(msg chatGPTMessage) {
+	if msg.Metadata.Hidden {
+		return
+	}
+	at := unixTime(msg.CreateTime)
+	text, contentType, issues := b.chatGPTText(msg.Content)
+	if contentType == "" {
+		return // Content with nothing to show, e.g. reasoning
+	}
+
+	switch msg.Author.Role {
+	case "system":
+		if strings.TrimSpace(text) == "" {
+			return
+		}
+		b.add(agent.Message{Role: "system", Content: text}, at, issues...)
+	case "user":
+		b.add(agent.Message{Role: "user", Content: text}, at, issues...)
+	case "assistant":
+		if msg.Recipient != "" && msg.Recipient != "all" {
+			call := agent.ToolCall{
+				ID:       "chatgpt_" + msg.ID,
+				Type:     "function",
+				Function: agent.FunctionCall{Name: msg.Recipient, Arguments: mustJSON(map[string]string{"input": text})},
+			}
+			issues = append(issues, fmt.Sprintf("ChatGPT tool %q is not available here", msg.Recipient))
+			b.add(agent.Message{Role: "assistant", ToolCalls: []agent.ToolCall{call}}, at, issues...)
+			return
+		}
+		b.add(agent.Message{Role: "assistant", Content: text}, at, issues...)
+	case "tool":
+		// Answer the latest call to this tool
+		for i := len(b.messages) - 1; i >= 0 && len(b.pending) > 0; i-- {
+			for _, tc := range b.messages[i].ToolCalls {
+				if tc.Function.Name == msg.Author.Name && b.addResult(tc.ID, text, at, issues...) {
+					return
+				}
+			}
+		}
+		issues = append(issues, "tool output without a matching call was imported as assistant text")
+		b.add(agent.Message{Role: "assistant", Content: fmt.Sprintf("[%s output]\n%s", msg.Author.Name, text)}, at, issues...)
+	default:
+		b.warnings.add("skipped messages with unknown role %q", msg.Author.Role)
+	}
+}
+
+// chatGPTText renders message content as text. It returns an empty content
+// type for content that is not imported.
+func (b *builder) chatGPTText(raw json.RawMessage) (text, contentType string, issues []string) {
+	var content chatGPTContent
+	if err := json.Unmarshal(raw, &content); err != nil {
+		return "", "", nil
+	}
+
+	switch content.ContentType {
+	case "text", "multimodal_text":
+		var parts []string
+		for _, rawPart := range content.Parts {
+			var s string
+			if json.Unmarshal(rawPart, &s) == nil {
+				if s != "" {
+					parts = append(parts, s)
+				}
+				continue
+			}
+			var part struct {
+				ContentType string `json:"content_type"`
+			}
+			json.Unmarshal(rawPart, &part)
+			parts = append(parts, "[attachment omitted]")
+			issues = append(issues, fmt.Sprintf("%s content was not imported", part.ContentType))
+		}
+		return strings.Join(parts, "\n"), content.ContentType, issues
+	case "code":
+		if content.Language != "" && content.Language != "unknown" {
+			return fmt.Sprintf("```%s\n%s\n```", content.Language, content.Text), content.ContentType, nil
+		}
+		return content.Text, content.ContentType, nil
+	case "execution_output", "tether_quote", "tether_browsing_display", "system_error":
+		return content.Text, content.ContentType, nil
+	case "thoughts", "reasoning_recap", "model_editable_context", "user_editable_context":
+		b.warnings.add("%s content is not imported", content.ContentType)
+		return "", "", nil
+	}
+	if content.Text != "" {
+		return content.Text, content.ContentType, []string{fmt.Sprintf("content of unknown type %q was imported as text", content.ContentType)}
+	}
+	b.warnings.add("skipped content of unknown type %q", content.ContentType)
+	return "", "", nil
+}
+
+// unixTime converts an export timestamp in fractional seconds
+func unixTime(seconds float64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC()
+}
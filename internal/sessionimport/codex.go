@@ -0,0 +1,182 @@
+package sessionimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/agent"
+)
+
+// codexRollout is the rollout file the TypeScript codex CLI saves per session:
+// session metadata and the Responses API items of the conversation
+type codexRollout struct {
+	Session struct {
+		Timestamp    string `json:"timestamp"`
+		ID           string `json:"id"`
+		Instructions string `json:"instructions"`
+	} `json:"session"`
+	Items []json.RawMessage `json:"items"`
+}
+
+// codexItem is one Responses API item of a rollout
+type codexItem struct {
+	Type    string `json:"type"`
+	Role    string `json:"role"`
+	Content []struct {
+		Type    string `json:"type"`
+		Text    string `json:"text"`
+		Refusal string `json:"refusal"`
+	} `json:"content"`
+	CallID    string `json:"call_id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Output    string `json:"output"`
+}
+
+var (
+	codexRolloutFields = []string{"session", "items"}
+	codexSessionFields = []string{"timestamp", "id", "instructions"}
+	codexItemFields    = []string{"id", "type", "role", "content", "status", "call_id", "name", "arguments", "output", "summary", "encrypted_content", "action"}
+)
+
+// importCodexRollout converts a TypeScript codex CLI rollout
+func importCodexRollout(data []byte, source string) (Session, error) {
+	var rollout codexRollout
+	if err := json.Unmarshal(data, &rollout); err != nil {
+		return Session{}, fmt.Errorf("failed to parse codex rollout: %w", err)
+	}
+
+	b := newBuilder(FormatCodexRollout, source)
+	b.info.SourceID = rollout.Session.ID
+	b.warnings.checkFields(data, "rollout", codexRolloutFields...)
+	var raw struct {
+		Session json.RawMessage `json:"session"`
+	}
+	if json.Unmarshal(data, &raw) == nil && raw.Session != nil {
+		b.warnings.checkFields(raw.Session, "rollout session", codexSessionFields...)
+	}
+	if rollout.Session.Instructions != "" {
+		b.warnings.add("the session's custom instructions were not imported; the current instructions apply instead")
+	}
+	started, _ := time.Parse(time.RFC3339Nano, rollout.Session.Timestamp)
+
+	for i, rawItem := range rollout.Items {
+		var item codexItem
+		if err := json.Unmarshal(rawItem, &item); err != nil {
+			b.warnings.add("skipped item %d: %v", i, err)
+			continue
+		}
+		b.warnings.checkFields(rawItem, fmt.Sprintf("%s item", item.Type), codexItemFields...)
+
+		switch item.Type {
+		case "message":
+			b.addCodexMessage(item)
+		case "function_call", "local_shell_call":
+			call, issues := codexToolCall(item)
+			// Calls directly after the assistant's text belong to the same message
+			if last := len(b.messages) - 1; last >= 0 && b.messages[last].Role == "assistant" && len(b.messages[last].ToolCalls) == 0 && len(b.pending) == 0 {
+				b.messages[last].ToolCalls = append(b.messages[last].ToolCalls, call)
+				b.pending = append(b.pending, call.ID)
+				b.note(last, time.Time{}, issues...)
+				continue
+			}
+			// Parallel calls share one assistant message
+			if last := len(b.messages) - 1; last >= 0 && len(b.messages[last].ToolCalls) > 0 && len(b.pending) == len(b.messages[last].ToolCalls) {
+				b.messages[last].ToolCalls = append(b.messages[last].ToolCalls, call)
+				b.pending = append(b.pending, call.ID)
+				b.note(last, time.Time{}, issues...)
+				continue
+			}
+			b.add(agent.Message{Role: "assistant", ToolCalls: []agent.ToolCall{call}}, time.Time{}, issues...)
+		case "function_call_output", "local_shell_call_output":
+			if !b.addResult(item.CallID, item.Output, time.Time{}) {
+				b.warnings.add("skipped the output of tool call %s, which has no matching call", item.CallID)
+			}
+		case "reasoning":
+			b.warnings.add("reasoning items are not imported")
+		default:
+			b.warnings.add("skipped items of unknown type %q", item.Type)
+		}
+	}
+
+	return b.session(started, time.Time{}), nil
+}
+
+// addCodexMessage converts a message item
+func (b *builder) addCodexMessage(item codexItem) {
+	role := item.Role
+	switch role {
+	case "user", "assistant", "system":
+	case "developer":
+		role = "system"
+	default:
+		b.warnings.add("skipped messages with unknown role %q", item.Role)
+		return
+	}
+
+	var parts, issues []string
+	for _, c := range item.Content {
+		switch c.Type {
+		case "input_text", "output_text", "text":
+			parts = append(parts, c.Text)
+		case "refusal":
+			parts = append(parts, c.Refusal)
+		case "input_image", "input_file":
+			parts = append(parts, "[attachment omitted]")
+			issues = append(issues, fmt.Sprintf("%s content was not imported", c.Type))
+		default:
+			issues = append(issues, fmt.Sprintf("content of unknown type %q was not imported", c.Type))
+		}
+	}
+	b.add(agent.Message{Role: role, Content: strings.Join(parts, "\n")}, time.Time{}, issues...)
+}
+
+// codexToolCall converts a function call item. The codex CLI's shell tool
+// takes an argv array, which becomes the command string of the TUI's
+// execute_command tool, so imported commands are shown and replayed as its own.
+func codexToolCall(item codexItem) (agent.ToolCall, []string) {
+	call := agent.ToolCall{ID: item.CallID, Type: "function", Function: agent.FunctionCall{Name: item.Name, Arguments: item.Arguments}}
+	if item.Name != "shell" && item.Name != "container.exec" && item.Type != "local_shell_call" {
+		return call, []string{fmt.Sprintf("tool %q is not available here", item.Name)}
+	}
+
+	var args struct {
+		Command []string `json:"command"`
+		Workdir string   `json:"workdir"`
+		Timeout int      `json:"timeout"` // Milliseconds
+	}
+	if err := json.Unmarshal([]byte(item.Arguments), &args); err != nil || len(args.Command) == 0 {
+		return call, []string{"the shell arguments could not be converted"}
+	}
+
+	var issues []string
+	command := shellCommand(args.Command)
+	if args.Command[0] == "apply_patch" {
+		issues = append(issues, "an apply_patch call was imported as a shell command")
+	}
+	params := map[string]interface{}{"command": command}
+	if args.Workdir != "" {
+		params["workingDir"] = args.Workdir
+	}
+	if args.Timeout > 0 {
+		params["timeout"] = (args.Timeout + 999) / 1000
+	}
+	call.Function.Name = "execute_command"
+	call.Function.Arguments = mustJSON(params)
+	return call, issues
+}
+
+// shellCommand turns an argv array into a command line, unwrapping the
+// script of a "bash -lc <script>" invocation
+func shellCommand(argv []string) string {
+	if len(argv) == 3 && (argv[0] == "bash" || argv[0] == "sh" || argv[0] == "zsh") && (argv[1] == "-c" || argv[1] == "-lc") {
+		return argv[2]
+	}
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
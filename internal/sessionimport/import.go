@@ -0,0 +1,232 @@
+// Package sessionimport converts conversations saved by other tools, the
+// original TypeScript codex CLI and ChatGPT, into sessions that can be continued here.
+package sessionimport
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/google/uuid"
+)
+
+const (
+	// FormatCodexRollout is the rollout JSON saved by the TypeScript codex CLI
+	FormatCodexRollout = "codex-rollout"
+	// FormatChatGPT is the conversations.json file of a ChatGPT data export
+	FormatChatGPT = "chatgpt"
+)
+
+// ErrUnknownFormat is returned for files that are neither a codex rollout nor a ChatGPT export
+var ErrUnknownFormat = errors.New("unrecognized session format: expected a codex rollout or a ChatGPT conversations.json export")
+
+// Session is one conversation converted from another tool, ready to be saved
+// with agent.SaveSession. Its history's Import records the origin of the
+// conversation and the messages that could not be fully converted.
+type Session struct {
+	History  *agent.ConversationHistory
+	Warnings []string // Parts of the file that were skipped, e.g. fields added by a newer exporter
+}
+
+// Import detects the format of data and converts every conversation in it.
+// source names the imported file in the sessions' metadata.
+func Import(data []byte, source string) ([]Session, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, ErrUnknownFormat
+	}
+
+	// A ChatGPT export is an array of conversations
+	if trimmed[0] == '[' {
+		return importChatGPT(trimmed, source)
+	}
+
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+	}
+	switch {
+	case probe["items"] != nil:
+		session, err := importCodexRollout(trimmed, source)
+		if err != nil {
+			return nil, err
+		}
+		return []Session{session}, nil
+	case probe["mapping"] != nil:
+		// A single conversation, e.g. cut out of an export
+		return importChatGPT(append(append([]byte{'['}, trimmed...), ']'), source)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// builder collects the converted messages of one conversation, keeping every
+// tool call answered so the history is valid for the API
+type builder struct {
+	messages []agent.Message
+	info     agent.ImportInfo
+	notes    map[int]*agent.ImportedMessage
+	pending  []string // Tool call IDs still awaiting a result, in call order
+	warnings warnings
+}
+
+func newBuilder(format, source string) *builder {
+	return &builder{
+		info:  agent.ImportInfo{Format: format, Source: source, ImportedAt: time.Now()},
+		notes: make(map[int]*agent.ImportedMessage),
+	}
+}
+
+// add appends a message sent at the given time (zero if unknown) with the
+// issues that kept it from being converted faithfully
+func (b *builder) add(msg agent.Message, at time.Time, issues ...string) {
+	if msg.Role != "tool" {
+		b.closePending()
+	}
+	b.messages = append(b.messages, msg)
+	for _, tc := range msg.ToolCalls {
+		b.pending = append(b.pending, tc.ID)
+	}
+	b.note(len(b.messages)-1, at, issues...)
+}
+
+// addResult appends the result of a pending tool call. It reports false, and
+// adds nothing, when no call with that ID awaits a result.
+func (b *builder) addResult(callID, content string, at time.Time, issues ...string) bool {
+	for i, id := range b.pending {
+		if id == callID {
+			b.pending = append(b.pending[:i], b.pending[i+1:]...)
+			b.messages = append(b.messages, agent.Message{Role: "tool", ToolCallID: callID, Content: content})
+			b.note(len(b.messages)-1, at, issues...)
+			return true
+		}
+	}
+	return false
+}
+
+// closePending answers tool calls the source recorded no result for
+func (b *builder) closePending() {
+	pending := b.pending
+	b.pending = nil
+	for _, id := range pending {
+		b.messages = append(b.messages, agent.NewToolResult(id, "", "no result was recorded for this tool call", false).Message())
+		b.note(len(b.messages)-1, time.Time{}, "the source has no result for this tool call; a placeholder was added")
+	}
+}
+
+// note records the time and issues of the message at index
+func (b *builder) note(index int, at time.Time, issues ...string) {
+	if at.IsZero() && len(issues) == 0 {
+		return
+	}
+	n := b.notes[index]
+	if n == nil {
+		n = &agent.ImportedMessage{Index: index}
+		b.notes[index] = n
+	}
+	if !at.IsZero() {
+		t := at.UTC()
+		n.Time = &t
+	}
+	n.Issues = append(n.Issues, issues...)
+}
+
+// session finishes the conversation as a new session
+func (b *builder) session(createdAt, updatedAt time.Time) Session {
+	b.closePending()
+
+	indexes := make([]int, 0, len(b.notes))
+	for i := range b.notes {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		b.info.Messages = append(b.info.Messages, *b.notes[i])
+	}
+
+	if createdAt.IsZero() {
+		createdAt = b.info.ImportedAt
+	}
+	if updatedAt.IsZero() {
+		updatedAt = createdAt
+	}
+	info := b.info
+	history := &agent.ConversationHistory{
+		Messages:       b.messages,
+		CurrentSession: uuid.New().String(),
+		CreatedAt:      createdAt.UTC(),
+		UpdatedAt:      updatedAt.UTC(),
+		Import:         &info,
+	}
+	history.CurrentTokens = history.EstimateTokenCount()
+	return Session{History: history, Warnings: b.warnings.list}
+}
+
+// warnings collects distinct warnings in the order they were first raised
+type warnings struct {
+	list []string
+	seen map[string]bool
+}
+
+func (w *warnings) add(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if w.seen == nil {
+		w.seen = make(map[string]bool)
+	}
+	if !w.seen[msg] {
+		w.seen[msg] = true
+		w.list = append(w.list, msg)
+	}
+}
+
+// checkFields warns about fields of an object that this importer does not
+// know, e.g. ones added by a newer version of the exporting tool. They are
+// ignored rather than rejected.
+func (w *warnings) checkFields(raw json.RawMessage, where string, known ...string) {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(raw, &fields) != nil {
+		return
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !contains(known, name) {
+			w.add("ignored unknown field %q in %s", name, where)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// mustJSON marshals tool call arguments built from decoded values
+func mustJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// shellQuote quotes an argument for a POSIX shell when it needs it
+func shellQuote(arg string) string {
+	if arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,+@%", r))
+	}) < 0 {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
@@ -0,0 +1,193 @@
+package sessionimport
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/agent"
+)
+
+func importFixture(t *testing.T, name string) []Session {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	sessions, err := Import(data, name)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	return sessions
+}
+
+// issuesAt returns the import issues recorded for message index
+func issuesAt(info *agent.ImportInfo, index int) []string {
+	for _, m := range info.Messages {
+		if m.Index == index {
+			return m.Issues
+		}
+	}
+	return nil
+}
+
+// roundTrip saves a session and reads it back the way RecoverSession does
+func roundTrip(t *testing.T, h *agent.ConversationHistory) *agent.ConversationHistory {
+	t.Helper()
+	dir := t.TempDir()
+	if err := agent.SaveSession(dir, h); err != nil {
+		t.Fatalf("SaveSession failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, h.CurrentSession+".json"))
+	if err != nil {
+		t.Fatalf("Failed to read saved session: %v", err)
+	}
+	var saved agent.ConversationHistory
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("Failed to parse saved session: %v", err)
+	}
+	if !reflect.DeepEqual(saved.Messages, h.Messages) {
+		t.Errorf("Expected the saved messages to match the import:\n%+v\ngot:\n%+v", h.Messages, saved.Messages)
+	}
+	if saved.Import == nil || saved.Import.Format != h.Import.Format || len(saved.Import.Messages) != len(h.Import.Messages) {
+		t.Errorf("Expected the import metadata to be saved, got %+v", saved.Import)
+	}
+	return &saved
+}
+
+func TestImportCodexRollout(t *testing.T) {
+	sessions := importFixture(t, "codex_rollout.json")
+	if len(sessions) != 1 {
+		t.Fatalf("Expected 1 session, got %d", len(sessions))
+	}
+	h := sessions[0].History
+	if h.Import.Format != FormatCodexRollout || h.Import.SourceID != "5b0d3c6e-8f3a-4d0e-9c55-0e8f6f3a9b21" {
+		t.Errorf("Expected the rollout's metadata, got %+v", h.Import)
+	}
+	if want := time.Date(2025, 4, 18, 9, 12, 33, 118000000, time.UTC); !h.CreatedAt.Equal(want) {
+		t.Errorf("Expected the session to start at %v, got %v", want, h.CreatedAt)
+	}
+
+	want := []agent.Message{
+		{Role: "user", Content: "List the Go files and run the tests"},
+		{Role: "assistant", Content: "Let me look around.", ToolCalls: []agent.ToolCall{
+			{ID: "call_ls", Type: "function", Function: agent.FunctionCall{Name: "execute_command", Arguments: `{"command":"ls *.go","timeout":10,"workingDir":"/work/app"}`}},
+		}},
+		{Role: "tool", ToolCallID: "call_ls", Content: `{"output":"main.go\nmain_test.go\n","metadata":{"exit_code":0,"duration_seconds":0.01}}`},
+		{Role: "assistant", ToolCalls: []agent.ToolCall{
+			{ID: "call_test", Type: "function", Function: agent.FunctionCall{Name: "execute_command", Arguments: `{"command":"go test ./... -run 'Test Foo'"}`}},
+			{ID: "call_patch", Type: "function", Function: agent.FunctionCall{Name: "execute_command", Arguments: `{"command":"apply_patch '*** Begin Patch\n*** End Patch'"}`}},
+		}},
+		{Role: "tool", ToolCallID: "call_test", Content: `{"output":"ok\n","metadata":{"exit_code":0}}`},
+		{Role: "tool", ToolCallID: "call_patch", Content: `{"success":false,"error":"no result was recorded for this tool call"}`},
+		{Role: "user", Content: "What does this screenshot show?\n[attachment omitted]"},
+		{Role: "assistant", Content: "A passing test run."},
+	}
+	if !reflect.DeepEqual(h.Messages, want) {
+		t.Fatalf("Expected messages:\n%+v\ngot:\n%+v", want, h.Messages)
+	}
+
+	if h.Import.Flagged() != 3 {
+		t.Errorf("Expected 3 flagged messages, got %+v", h.Import.Messages)
+	}
+	for index, issue := range map[int]string{3: "apply_patch", 5: "no result", 6: "input_image"} {
+		if issues := issuesAt(h.Import, index); len(issues) == 0 || !strings.Contains(issues[0], issue) {
+			t.Errorf("Expected message %d to be flagged for %s, got %v", index, issue, issues)
+		}
+	}
+
+	warnings := strings.Join(sessions[0].Warnings, "\n")
+	for _, w := range []string{`"git_branch" in rollout session`, "custom instructions", "reasoning items", "call_missing", `"web_search_call"`} {
+		if !strings.Contains(warnings, w) {
+			t.Errorf("Expected a warning mentioning %s, got:\n%s", w, warnings)
+		}
+	}
+
+	roundTrip(t, h)
+}
+
+func TestImportChatGPTExport(t *testing.T) {
+	sessions := importFixture(t, "chatgpt_conversations.json")
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+	}
+
+	h := sessions[0].History
+	if h.Import.Title != "Parse CSV in Go" || h.Import.SourceID != "67a1b2c3-0000-4000-8000-000000000001" {
+		t.Errorf("Expected the conversation's metadata, got %+v", h.Import)
+	}
+	if want := time.Unix(1712000300, 250000000).UTC(); !h.UpdatedAt.Equal(want) {
+		t.Errorf("Expected the conversation's update time %v, got %v", want, h.UpdatedAt)
+	}
+
+	// The hidden system message, the abandoned regeneration and the thoughts are left out
+	want := []agent.Message{
+		{Role: "user", Content: "[attachment omitted]\nHow do I parse this CSV in Go?"},
+		{Role: "assistant", ToolCalls: []agent.ToolCall{
+			{ID: "chatgpt_n3", Type: "function", Function: agent.FunctionCall{Name: "python", Arguments: `{"input":"import csv\nprint(len(list(csv.reader(open('data.csv')))))"}`}},
+		}},
+		{Role: "tool", ToolCallID: "chatgpt_n3", Content: "42"},
+		{Role: "assistant", Content: "The file has 42 rows. In Go, use encoding/csv."},
+		{Role: "assistant", Content: "[dalle.text2im output]\nImage generated."},
+	}
+	if !reflect.DeepEqual(h.Messages, want) {
+		t.Fatalf("Expected messages:\n%+v\ngot:\n%+v", want, h.Messages)
+	}
+
+	if h.Import.Flagged() != 3 {
+		t.Errorf("Expected 3 flagged messages, got %+v", h.Import.Messages)
+	}
+	for _, m := range h.Import.Messages {
+		if m.Time == nil {
+			t.Errorf("Expected message %d to keep its time", m.Index)
+		}
+	}
+	if len(h.Import.Messages) > 2 && !h.Import.Messages[2].Time.Equal(time.Unix(1712000021, 0)) {
+		t.Errorf("Expected the tool output time, got %v", h.Import.Messages[2].Time)
+	}
+
+	warnings := strings.Join(sessions[0].Warnings, "\n")
+	for _, w := range []string{`"future_top_level_field" in conversation`, `"sparkle_level" in message`, "thoughts content"} {
+		if !strings.Contains(warnings, w) {
+			t.Errorf("Expected a warning mentioning %s, got:\n%s", w, warnings)
+		}
+	}
+	if strings.Contains(warnings, "default_model_slug") {
+		t.Errorf("Expected no warning for known fields, got:\n%s", warnings)
+	}
+
+	// Without a current node, the latest branch is followed
+	second := sessions[1].History
+	if len(second.Messages) != 2 || second.Messages[1].Content != "regenerated answer" {
+		t.Errorf("Expected the latest regeneration, got %+v", second.Messages)
+	}
+	if second.Import.SourceID != "67a1b2c3-0000-4000-8000-000000000002" {
+		t.Errorf("Expected the conversation ID to fall back to id, got %q", second.Import.SourceID)
+	}
+
+	roundTrip(t, h)
+	roundTrip(t, second)
+}
+
+func TestImportSingleChatGPTConversation(t *testing.T) {
+	data := `{"title": "One", "mapping": {"a": {"id": "a", "message": {"id": "a", "author": {"role": "user"}, "content": {"content_type": "text", "parts": ["hello"]}}}}, "current_node": "a"}`
+	sessions, err := Import([]byte(data), "one.json")
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if len(sessions) != 1 || len(sessions[0].History.Messages) != 1 || sessions[0].History.Messages[0].Content != "hello" {
+		t.Errorf("Expected one conversation with one message, got %+v", sessions)
+	}
+}
+
+func TestImportRejectsUnknownFormat(t *testing.T) {
+	for _, data := range []string{"", `{"messages": []}`, "not json"} {
+		if _, err := Import([]byte(data), "x.json"); err == nil {
+			t.Errorf("Expected an error for %q", data)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/blobstore"
+)
+
+// manifestDir is where the manifests of snapshots outside git are kept,
+// inside a project's .codex directory
+const manifestDir = "snapshots"
+
+// manifestPath returns the manifest file of a snapshot
+func (m *Manager) manifestPath(id string) string {
+	return filepath.Join(m.dir, ".codex", manifestDir, id+".json")
+}
+
+// walk calls fn with the path, relative to the workspace, of every regular
+// file a snapshot outside git covers
+func (m *Manager) walk(fn func(path string, info os.FileInfo) error) error {
+	return filepath.WalkDir(m.dir, func(abs string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(m.dir, abs)
+		if err != nil {
+			return err
+		}
+		path := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if excluded(path) || d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(path, info)
+	})
+}
+
+// createFiles copies the workspace's files into the blob store and saves
+// their manifest
+func (m *Manager) createFiles(label string) (Snapshot, error) {
+	snap := Snapshot{Kind: KindFiles, Label: label, CreatedAt: time.Now()}
+	contents := make(map[string][]byte)
+	err := m.walk(func(path string, info os.FileInfo) error {
+		if info.Size() > m.maxFileSize {
+			snap.Skipped = append(snap.Skipped, path)
+			return nil
+		}
+		if len(snap.Files) == maxFiles {
+			return fmt.Errorf("the workspace has more than %d files; snapshots of large workspaces need git", maxFiles)
+		}
+		data, err := os.ReadFile(filepath.Join(m.dir, filepath.FromSlash(path)))
+		if err != nil {
+			return err
+		}
+		hash := blobstore.Hash(data)
+		contents[hash] = data
+		snap.Files = append(snap.Files, File{Path: path, Hash: hash, Mode: info.Mode().Perm()})
+		return nil
+	})
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to snapshot the workspace: %w", err)
+	}
+
+	// The ID is the manifest's hash, before the ID is part of it
+	manifest, err := json.Marshal(snap)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	snap.ID = blobstore.Hash(manifest)[:idLength]
+	for hash, data := range contents {
+		if _, err := m.blobs.Put(Owner(snap.ID), data); err != nil {
+			return Snapshot{}, fmt.Errorf("failed to store %s: %w", hash, err)
+		}
+	}
+	if err := m.saveManifest(snap); err != nil {
+		return Snapshot{}, err
+	}
+	return snap, nil
+}
+
+// saveManifest atomically writes the manifest of a snapshot
+func (m *Manager) saveManifest(snap Snapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	path := m.manifestPath(snap.ID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// listFiles reads the manifests of the snapshots taken outside git
+func (m *Manager) listFiles() ([]Snapshot, error) {
+	entries, err := os.ReadDir(filepath.Dir(m.manifestPath("")))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	var snapshots []Snapshot
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(m.manifestPath(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %s: %w", id, err)
+		}
+		var snap Snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("failed to parse snapshot %s: %w", id, err)
+		}
+		snapshots = append(snapshots, snap)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt) })
+	return snapshots, nil
+}
+
+// snapshotPaths lists the files a snapshot outside git covers: the copied
+// ones, and those left out for their size, which a restore leaves as they are
+func snapshotPaths(snap Snapshot) []string {
+	paths := append([]string(nil), snap.Skipped...)
+	for _, f := range snap.Files {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
+
+// restoreFiles writes back the copied files of a snapshot that differ from
+// the workspace's
+func (m *Manager) restoreFiles(snap Snapshot) error {
+	for _, f := range snap.Files {
+		data, err := m.blobs.Get(f.Hash)
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", f.Path, err)
+		}
+		abs := filepath.Join(m.dir, filepath.FromSlash(f.Path))
+		if current, err := os.ReadFile(abs); err == nil && bytes.Equal(current, data) {
+			if err := os.Chmod(abs, f.Mode); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", f.Path, err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", f.Path, err)
+		}
+		if err := os.WriteFile(abs, data, f.Mode); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", f.Path, err)
+		}
+		if err := os.Chmod(abs, f.Mode); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", f.Path, err)
+		}
+	}
+	return nil
+}
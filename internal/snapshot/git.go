@@ -0,0 +1,210 @@
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitIdentity is the author and committer of snapshot commits, so they do not
+// depend on the user's git configuration
+var gitIdentity = []string{
+	"GIT_AUTHOR_NAME=codex", "GIT_AUTHOR_EMAIL=codex@localhost",
+	"GIT_COMMITTER_NAME=codex", "GIT_COMMITTER_EMAIL=codex@localhost",
+}
+
+// gitRepo is a workspace inside a git repository. Paths are relative to the
+// workspace, which may be a subdirectory of the repository; snapshots cover
+// only the workspace.
+type gitRepo struct {
+	dir string
+}
+
+// openGitRepo returns the repository dir is in, or nil when it is not in one
+func openGitRepo(dir string) *gitRepo {
+	repo := &gitRepo{dir: dir}
+	out, err := repo.run(nil, nil, "rev-parse", "--is-inside-work-tree")
+	if err != nil || strings.TrimSpace(out) != "true" {
+		return nil
+	}
+	return repo
+}
+
+// run runs git in the workspace with env added to its environment and stdin
+// as its input, returning its output
+func (r *gitRepo) run(env []string, stdin io.Reader, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = stdin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+// revision resolves a revision, returning "" when it does not exist
+func (r *gitRepo) revision(rev string) string {
+	out, err := r.run(nil, nil, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(out)
+}
+
+// withIndex calls fn with the environment of a temporary index, seeded from
+// the real index when seed is set so unchanged files need not be hashed again
+func (r *gitRepo) withIndex(seed bool, fn func(env []string) error) error {
+	tmp, err := os.MkdirTemp("", "codex-snapshot-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary index: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	index := filepath.Join(tmp, "index")
+
+	if seed {
+		out, err := r.run(nil, nil, "rev-parse", "--git-path", "index")
+		if err != nil {
+			return err
+		}
+		real := strings.TrimSpace(out)
+		if !filepath.IsAbs(real) {
+			real = filepath.Join(r.dir, real)
+		}
+		if data, err := os.ReadFile(real); err == nil {
+			if err := os.WriteFile(index, data, 0644); err != nil {
+				return fmt.Errorf("failed to create temporary index: %w", err)
+			}
+		}
+	}
+	return fn([]string{"GIT_INDEX_FILE=" + index})
+}
+
+// create commits the workspace's tracked and untracked, not ignored, files
+// and chains the commit on Ref
+func (r *gitRepo) create(label string) (Snapshot, error) {
+	var tree string
+	err := r.withIndex(true, func(env []string) error {
+		if _, err := r.run(env, nil, "add", "--all", "--", ".", ":(exclude).codex"); err != nil {
+			return err
+		}
+		out, err := r.run(env, nil, "write-tree")
+		tree = strings.TrimSpace(out)
+		return err
+	})
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to snapshot the workspace: %w", err)
+	}
+
+	message := label
+	if head := r.revision("HEAD"); head != "" {
+		message += "\n\nHead: " + head
+	}
+	args := []string{"commit-tree", tree, "-m", message}
+	parent := r.revision(Ref)
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	out, err := r.run(gitIdentity, nil, args...)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to snapshot the workspace: %w", err)
+	}
+	commit := strings.TrimSpace(out)
+	if _, err := r.run(nil, nil, "update-ref", "-m", "codex snapshot", Ref, commit, parent); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to record the snapshot: %w", err)
+	}
+	return Snapshot{ID: commit[:idLength], Kind: KindGit, Label: label, CreatedAt: time.Now()}, nil
+}
+
+// list returns the snapshots on Ref, newest first
+func (r *gitRepo) list() ([]Snapshot, error) {
+	if r.revision(Ref) == "" {
+		return nil, nil
+	}
+	out, err := r.run(nil, nil, "log", "--first-parent", "--format=%H%x1f%ct%x1f%s", Ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	var snapshots []Snapshot
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.SplitN(line, "\x1f", 3)
+		if len(fields) != 3 || len(fields[0]) < idLength {
+			continue
+		}
+		seconds, _ := strconv.ParseInt(fields[1], 10, 64)
+		snapshots = append(snapshots, Snapshot{ID: fields[0][:idLength], Kind: KindGit, Label: fields[2], CreatedAt: time.Unix(seconds, 0)})
+	}
+	return snapshots, nil
+}
+
+// files lists the files of a snapshot
+func (r *gitRepo) files(id string) ([]string, error) {
+	out, err := r.run(nil, nil, "ls-tree", "-r", "-z", "--name-only", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", id, err)
+	}
+	return splitPaths(out), nil
+}
+
+// workingFiles lists the workspace's tracked and untracked, not ignored,
+// files that exist
+func (r *gitRepo) workingFiles() ([]string, error) {
+	out, err := r.run(nil, nil, "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the workspace's files: %w", err)
+	}
+	var paths []string
+	seen := make(map[string]bool)
+	for _, path := range splitPaths(out) {
+		if seen[path] {
+			continue // Listed once per stage while a merge conflict is unresolved
+		}
+		seen[path] = true
+		if _, err := os.Lstat(filepath.Join(r.dir, filepath.FromSlash(path))); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// checkout writes the files of a snapshot to the workspace, through a
+// temporary index so the real one is untouched
+func (r *gitRepo) checkout(id string, paths []string) error {
+	err := r.withIndex(false, func(env []string) error {
+		if _, err := r.run(env, nil, "read-tree", id); err != nil {
+			return err
+		}
+		if len(paths) == 0 {
+			return nil
+		}
+		_, err := r.run(env, strings.NewReader(strings.Join(paths, "\x00")+"\x00"), "checkout-index", "--force", "-z", "--stdin")
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore snapshot %s: %w", id, err)
+	}
+	return nil
+}
+
+// splitPaths splits NUL-terminated git output, leaving out excluded paths
+func splitPaths(out string) []string {
+	var paths []string
+	for _, path := range strings.Split(out, "\x00") {
+		if path != "" && !excluded(path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
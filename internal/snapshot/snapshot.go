@@ -0,0 +1,279 @@
+// Package snapshot takes and restores checkpoints of a whole workspace, so an
+// unattended run can be rolled back in one step. In a git repository a
+// snapshot is a commit of the working tree, staged through a temporary index
+// so the real index and the checked-out branch are left alone, and chained on
+// Ref. Outside git, the workspace's files up to a size limit are copied into
+// the project's blob store and listed in a manifest under .codex/snapshots.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/blobstore"
+)
+
+const (
+	// Ref is the ref git snapshots are chained on; each snapshot's parent is the previous one
+	Ref = "refs/codex/snapshots"
+
+	// KindGit is a snapshot committed to Ref
+	KindGit = "git"
+	// KindFiles is a snapshot copied into the blob store
+	KindFiles = "files"
+
+	// DefaultMaxFileSize is the size above which files are left out of
+	// snapshots taken outside git
+	DefaultMaxFileSize = 1 << 20
+
+	// maxFiles caps how many files a snapshot outside git copies
+	maxFiles = 20000
+	// idLength is the length of snapshot IDs, hex digits of a commit or manifest hash
+	idLength = 12
+	// ownerPrefix starts the blob store owner of a snapshot's files
+	ownerPrefix = "snapshot:"
+)
+
+// ErrNotFound is returned for a snapshot ID that names no snapshot
+var ErrNotFound = errors.New("snapshot not found")
+
+// NewFilesError is returned by a restore that would delete files created since the snapshot
+type NewFilesError struct {
+	ID    string
+	Paths []string // Relative to the workspace
+}
+
+func (e *NewFilesError) Error() string {
+	return fmt.Sprintf("restoring snapshot %s would delete %d file(s) created since it was taken: %s", e.ID, len(e.Paths), strings.Join(e.Paths, ", "))
+}
+
+// Snapshot describes a checkpoint of the workspace
+type Snapshot struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Label     string    `json:"label"`
+	CreatedAt time.Time `json:"created_at"`
+	Files     []File    `json:"files,omitempty"`   // Copied files, for KindFiles
+	Skipped   []string  `json:"skipped,omitempty"` // Files left out for their size, for KindFiles
+}
+
+// File is a file copied into the blob store
+type File struct {
+	Path string      `json:"path"` // Relative to the workspace, with forward slashes
+	Hash string      `json:"hash"`
+	Mode os.FileMode `json:"mode"`
+}
+
+// RestoreOptions controls what a restore may delete
+type RestoreOptions struct {
+	IncludeNew bool     // Delete the files created since the snapshot
+	Discard    []string // Files created since the snapshot that are deleted even without IncludeNew
+}
+
+// RestoreResult reports what a restore did
+type RestoreResult struct {
+	Snapshot Snapshot
+	Restored int      // Files written back
+	Deleted  []string // Files created since the snapshot that were deleted
+}
+
+// Owner returns the blob store owner of the files of a snapshot
+func Owner(id string) string {
+	return ownerPrefix + id
+}
+
+// Manager takes and restores snapshots of a workspace
+type Manager struct {
+	dir         string
+	blobs       *blobstore.Store
+	maxFileSize int64
+	git         *gitRepo // nil outside a git repository
+}
+
+// Open returns the manager of the workspace in dir. Outside git, files are
+// copied into blobs, leaving out those larger than maxFileSize (0 =
+// DefaultMaxFileSize).
+func Open(dir string, blobs *blobstore.Store, maxFileSize int64) *Manager {
+	if maxFileSize <= 0 {
+		maxFileSize = DefaultMaxFileSize
+	}
+	return &Manager{dir: dir, blobs: blobs, maxFileSize: maxFileSize, git: openGitRepo(dir)}
+}
+
+// Kind returns the kind of the snapshots the manager takes
+func (m *Manager) Kind() string {
+	if m.git != nil {
+		return KindGit
+	}
+	return KindFiles
+}
+
+// Create snapshots the workspace
+func (m *Manager) Create(label string) (Snapshot, error) {
+	if m.git != nil {
+		return m.git.create(label)
+	}
+	return m.createFiles(label)
+}
+
+// List returns the snapshots, newest first
+func (m *Manager) List() ([]Snapshot, error) {
+	if m.git != nil {
+		return m.git.list()
+	}
+	return m.listFiles()
+}
+
+// Find returns the snapshot whose ID starts with id, or the latest one when id is empty
+func (m *Manager) Find(id string) (Snapshot, error) {
+	snapshots, err := m.List()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if id == "" {
+		if len(snapshots) == 0 {
+			return Snapshot{}, fmt.Errorf("%w: no snapshots were taken", ErrNotFound)
+		}
+		return snapshots[0], nil
+	}
+	var matches []Snapshot
+	for _, s := range snapshots {
+		if strings.HasPrefix(s.ID, id) {
+			matches = append(matches, s)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return Snapshot{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	case 1:
+		return matches[0], nil
+	default:
+		return Snapshot{}, fmt.Errorf("snapshot ID %s is ambiguous (%d snapshots start with it)", id, len(matches))
+	}
+}
+
+// Restore brings the workspace back to the snapshot whose ID starts with id,
+// or the latest one when id is empty. Files created since the snapshot are
+// deleted only with opts.IncludeNew, or when opts.Discard lists them;
+// otherwise the restore is refused with a *NewFilesError and nothing changes.
+func (m *Manager) Restore(id string, opts RestoreOptions) (RestoreResult, error) {
+	snap, err := m.Find(id)
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	saved := snapshotPaths(snap)
+	if m.git != nil {
+		if saved, err = m.git.files(snap.ID); err != nil {
+			return RestoreResult{}, err
+		}
+	}
+	current, err := m.currentFiles()
+	if err != nil {
+		return RestoreResult{}, err
+	}
+
+	newFiles := newSince(current, saved, m.discarded(opts.Discard), opts.IncludeNew)
+	if len(newFiles.blocking) > 0 {
+		return RestoreResult{}, &NewFilesError{ID: snap.ID, Paths: newFiles.blocking}
+	}
+
+	if m.git != nil {
+		err = m.git.checkout(snap.ID, saved)
+	} else {
+		err = m.restoreFiles(snap)
+	}
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	result := RestoreResult{Snapshot: snap, Restored: len(saved)}
+	for _, path := range newFiles.deleted {
+		if err := m.remove(path); err != nil {
+			return result, err
+		}
+		result.Deleted = append(result.Deleted, path)
+	}
+	return result, nil
+}
+
+// currentFiles lists the workspace's files a snapshot would cover
+func (m *Manager) currentFiles() ([]string, error) {
+	if m.git != nil {
+		return m.git.workingFiles()
+	}
+	var paths []string
+	err := m.walk(func(path string, info os.FileInfo) error {
+		paths = append(paths, path)
+		return nil
+	})
+	return paths, err
+}
+
+// discarded makes the paths of opts.Discard relative to the workspace
+func (m *Manager) discarded(paths []string) map[string]bool {
+	set := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		if filepath.IsAbs(path) {
+			rel, err := filepath.Rel(m.dir, path)
+			if err != nil {
+				continue
+			}
+			path = rel
+		}
+		set[filepath.ToSlash(filepath.Clean(path))] = true
+	}
+	return set
+}
+
+// newFiles splits the files created since a snapshot into those a restore
+// deletes and those that block it
+type newFiles struct {
+	deleted  []string
+	blocking []string
+}
+
+// newSince returns the files of current missing from saved
+func newSince(current, saved []string, discard map[string]bool, includeNew bool) newFiles {
+	inSnapshot := make(map[string]bool, len(saved))
+	for _, path := range saved {
+		inSnapshot[path] = true
+	}
+	var result newFiles
+	for _, path := range current {
+		switch {
+		case inSnapshot[path]:
+		case includeNew || discard[path]:
+			result.deleted = append(result.deleted, path)
+		default:
+			result.blocking = append(result.blocking, path)
+		}
+	}
+	sort.Strings(result.deleted)
+	sort.Strings(result.blocking)
+	return result
+}
+
+// remove deletes a file created since the snapshot, along with the
+// directories it leaves empty
+func (m *Manager) remove(path string) error {
+	abs := filepath.Join(m.dir, filepath.FromSlash(path))
+	if err := os.Remove(abs); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete %s: %w", path, err)
+	}
+	for dir := filepath.Dir(abs); dir != m.dir && strings.HasPrefix(dir, m.dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// excluded reports whether a path relative to the workspace is never
+// snapshotted: codex's own state, which includes the snapshots
+func excluded(path string) bool {
+	return path == ".codex" || strings.HasPrefix(path, ".codex/")
+}
@@ -0,0 +1,157 @@
+package snapshot
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/blobstore"
+)
+
+// writeFiles creates files, path -> content, under dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		abs := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readFile returns the content of a file under dir, or "<missing>"
+func readFile(dir, path string) string {
+	data, err := os.ReadFile(filepath.Join(dir, path))
+	if err != nil {
+		return "<missing>"
+	}
+	return string(data)
+}
+
+// git runs git in dir, failing the test on error
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), gitIdentity...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+// testRestore changes the workspace after a snapshot, then checks that a
+// restore is refused over a new file and brings everything back with IncludeNew
+func testRestore(t *testing.T, m *Manager, dir string) {
+	t.Helper()
+	snap, err := m.Create("before the run")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	writeFiles(t, dir, map[string]string{"main.go": "package broken\n", "gen/new.txt": "generated"})
+	os.Remove(filepath.Join(dir, "docs/notes.md"))
+
+	var newFiles *NewFilesError
+	if _, err := m.Restore("", RestoreOptions{}); !errors.As(err, &newFiles) || len(newFiles.Paths) != 1 || newFiles.Paths[0] != "gen/new.txt" {
+		t.Fatalf("Expected the restore to be refused over gen/new.txt, got %v", err)
+	}
+	if readFile(dir, "main.go") != "package broken\n" {
+		t.Fatalf("Expected a refused restore to change nothing")
+	}
+
+	result, err := m.Restore(snap.ID[:6], RestoreOptions{IncludeNew: true})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if result.Snapshot.ID != snap.ID || len(result.Deleted) != 1 {
+		t.Errorf("Expected snapshot %s restored with one file deleted, got %+v", snap.ID, result)
+	}
+	if got := readFile(dir, "main.go"); got != "package main\n" {
+		t.Errorf("Expected main.go restored, got %q", got)
+	}
+	if got := readFile(dir, "docs/notes.md"); got != "notes" {
+		t.Errorf("Expected the deleted file restored, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gen")); !os.IsNotExist(err) {
+		t.Errorf("Expected the new file and its directory deleted, got %v", err)
+	}
+
+	snapshots, err := m.List()
+	if err != nil || len(snapshots) != 1 || snapshots[0].Label != "before the run" {
+		t.Errorf("Expected the snapshot listed, got %+v, %v", snapshots, err)
+	}
+	if _, err := m.Find("ffffffffffff"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected an unknown ID not to be found, got %v", err)
+	}
+}
+
+func TestGitSnapshotLeavesIndexAndBranchAlone(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	git(t, dir, "init", "-q")
+	writeFiles(t, dir, map[string]string{"main.go": "package main\n", "docs/notes.md": "notes", ".gitignore": "build/\n"})
+	git(t, dir, "add", "main.go", ".gitignore")
+	git(t, dir, "commit", "-q", "-m", "initial")
+	writeFiles(t, dir, map[string]string{"build/out": "ignored", "docs/notes.md": "notes"})
+	head := git(t, dir, "rev-parse", "HEAD")
+	status := git(t, dir, "status", "--porcelain")
+
+	m := Open(dir, blobstore.Open(blobstore.DirFor(dir), false), 0)
+	if m.Kind() != KindGit {
+		t.Fatalf("Expected git snapshots in a repository, got %s", m.Kind())
+	}
+	testRestore(t, m, dir)
+
+	if got := git(t, dir, "rev-parse", "HEAD"); got != head {
+		t.Errorf("Expected HEAD unchanged, got %s", got)
+	}
+	if got := git(t, dir, "status", "--porcelain"); got != status {
+		t.Errorf("Expected the index and status unchanged, got:\n%s\nwant:\n%s", got, status)
+	}
+	if readFile(dir, "build/out") != "ignored" {
+		t.Errorf("Expected ignored files left alone")
+	}
+	if log := git(t, dir, "log", "--format=%s", Ref); strings.TrimSpace(log) != "before the run" {
+		t.Errorf("Expected the snapshot on %s, got %q", Ref, log)
+	}
+}
+
+func TestFileSnapshotOutsideGit(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"main.go": "package main\n", "docs/notes.md": "notes", "big.bin": strings.Repeat("x", 64)})
+	blobs := blobstore.Open(blobstore.DirFor(dir), true)
+	m := Open(dir, blobs, 32)
+	if m.Kind() != KindFiles {
+		t.Skipf("%s is inside a git repository", dir)
+	}
+	testRestore(t, m, dir)
+
+	snapshots, _ := m.List()
+	if len(snapshots[0].Skipped) != 1 || snapshots[0].Skipped[0] != "big.bin" || readFile(dir, "big.bin") == "<missing>" {
+		t.Errorf("Expected the large file left out and left alone, got %+v", snapshots[0])
+	}
+	entries, _ := blobs.Entries()
+	for hash, entry := range entries {
+		if !entry.Owners[Owner(snapshots[0].ID)] {
+			t.Errorf("Expected blob %s to be owned by the snapshot, got %v", hash, entry.Owners)
+		}
+	}
+
+	// A file the caller knows it created may go without IncludeNew
+	writeFiles(t, dir, map[string]string{"scratch.txt": "tmp"})
+	if _, err := m.Restore("", RestoreOptions{Discard: []string{filepath.Join(dir, "scratch.txt")}}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if readFile(dir, "scratch.txt") != "<missing>" {
+		t.Errorf("Expected the discarded file deleted")
+	}
+}
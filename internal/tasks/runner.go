@@ -0,0 +1,631 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/blobstore"
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/fileops"
+	"github.com/epuerta/codex-go/internal/functions"
+	"github.com/epuerta/codex-go/internal/policy"
+	"github.com/epuerta/codex-go/internal/sandbox"
+)
+
+const (
+	// outputTailSize is how much of a failed criterion's output is kept in the report
+	outputTailSize = 4096
+	// maxAnswers is how many questions a step's turn may ask before it is
+	// stopped as needing input, so a model that keeps asking cannot loop
+	maxAnswers = 3
+)
+
+// Agent is the part of the agent a Runner drives
+type Agent interface {
+	SendMessage(ctx context.Context, messages []agent.Message, handler agent.ResponseHandler) (bool, error)
+	SendToolResult(ctx context.Context, result agent.ToolResult) error
+}
+
+// ToolFactory builds the tools for one step from the step's effective config.
+// File edits must be recorded in journal.
+type ToolFactory func(cfg *config.Config, journal *fileops.Journal) *functions.Registry
+
+// StepStatus is the outcome of a step
+type StepStatus string
+
+const (
+	// StepPassed means the step's turn finished and its criterion passed
+	StepPassed StepStatus = "passed"
+	// StepFailed means the step's success criterion exited non-zero
+	StepFailed StepStatus = "failed"
+	// StepError means the step's turn or criterion could not be run
+	StepError StepStatus = "error"
+	// StepNeedsInput means the model asked a question the config could not answer
+	StepNeedsInput StepStatus = "needs_input"
+	// StepRefused means the model refused the step, or a content filter withheld its response
+	StepRefused StepStatus = "refused"
+	// StepSkipped means the step came before --from-step
+	StepSkipped StepStatus = "skipped"
+	// StepNotRun means an earlier step stopped the task
+	StepNotRun StepStatus = "not_run"
+)
+
+// StepOutcome records what happened in one step
+type StepOutcome struct {
+	ID       string     `json:"id"`
+	Status   StepStatus `json:"status"`
+	ExitCode int        `json:"exit_code,omitempty"` // Exit code of the success criterion
+	Output   string     `json:"output,omitempty"`    // Tail of the criterion's output when it failed
+	Error    string     `json:"error,omitempty"`
+	Changed  []string   `json:"changed,omitempty"` // Files created, modified or deleted by the step
+	Duration float64    `json:"duration_seconds"`
+}
+
+// Report is the outcome of a task run
+type Report struct {
+	Task  string        `json:"task"`
+	Steps []StepOutcome `json:"steps"`
+}
+
+// Passed reports whether every step that ran passed
+func (r *Report) Passed() bool {
+	for _, step := range r.Steps {
+		if step.Status != StepPassed && step.Status != StepSkipped {
+			return false
+		}
+	}
+	return true
+}
+
+// NeedsInput reports whether the task stopped at a question nobody answered
+func (r *Report) NeedsInput() bool {
+	failed, ok := r.FailedStep()
+	return ok && failed.Status == StepNeedsInput
+}
+
+// Refused reports whether the task stopped at a refused response
+func (r *Report) Refused() bool {
+	failed, ok := r.FailedStep()
+	return ok && failed.Status == StepRefused
+}
+
+// FailedStep returns the step that stopped the task, if any
+func (r *Report) FailedStep() (StepOutcome, bool) {
+	for _, step := range r.Steps {
+		if step.Status == StepFailed || step.Status == StepError || step.Status == StepNeedsInput || step.Status == StepRefused {
+			return step, true
+		}
+	}
+	return StepOutcome{}, false
+}
+
+// WriteSummary writes a human-readable summary of the report
+func (r *Report) WriteSummary(w io.Writer) {
+	fmt.Fprintf(w, "Task %s:\n", r.Task)
+	for _, step := range r.Steps {
+		fmt.Fprintf(w, "  %-8s %s", step.Status, step.ID)
+		if step.Status != StepSkipped && step.Status != StepNotRun {
+			fmt.Fprintf(w, " (%.1fs)", step.Duration)
+		}
+		fmt.Fprintln(w)
+	}
+
+	failed, ok := r.FailedStep()
+	if !ok {
+		return
+	}
+	switch failed.Status {
+	case StepFailed:
+		fmt.Fprintf(w, "\nStep %s failed: success criterion exited with code %d.\n", failed.ID, failed.ExitCode)
+	case StepNeedsInput:
+		fmt.Fprintf(w, "\nStep %s stopped: %s\n", failed.ID, failed.Error)
+		fmt.Fprintln(w, "Answer it in the prompt or with question_answers in the config.")
+	case StepRefused:
+		fmt.Fprintf(w, "\nStep %s stopped: %s\n", failed.ID, failed.Error)
+	default:
+		fmt.Fprintf(w, "\nStep %s failed: %s\n", failed.ID, failed.Error)
+	}
+	if failed.Output != "" {
+		fmt.Fprintf(w, "%s\n", strings.TrimRight(failed.Output, "\n"))
+	}
+	fmt.Fprintf(w, "Resume with --from-step %s once the problem is fixed.\n", failed.ID)
+}
+
+// Runner executes a task's steps in order against one agent session. Tool
+// calls run unattended: calls the approval policy denies, and calls that
+// would need approval under the policy or the step's approval mode, are denied. Every event is written to out as a JSON line
+// carrying the ID of the step it belongs to.
+type Runner struct {
+	agent  Agent
+	config *config.Config
+	tools  ToolFactory
+	policy *policy.Policy // Approval rules applied before the approval mode (nil for none)
+
+	outMu sync.Mutex
+	out   io.Writer
+
+	mu        sync.Mutex
+	step      string
+	pending   []agent.FunctionCall
+	lastReply string         // Latest assistant text of the current turn
+	question  string         // Question the current reply ended with, if any
+	refusal   *agent.Refusal // Refusal that ended the current reply, if any
+	answered  int            // Questions answered in the current turn
+}
+
+// NewRunner creates a runner for a session of a. cfg supplies the defaults a
+// task overrides, such as the approval mode.
+func NewRunner(a Agent, cfg *config.Config, tools ToolFactory, out io.Writer) *Runner {
+	return &Runner{
+		agent:  a,
+		config: cfg,
+		tools:  tools,
+		out:    out,
+	}
+}
+
+// SetPolicy applies the approval rules of p to the tool calls of the run: a
+// call they deny is refused, and so is one they require approval for.
+func (r *Runner) SetPolicy(p *policy.Policy) {
+	r.policy = p
+}
+
+// Run executes task starting at fromStep, which is a step ID or 1-based step
+// number (empty runs every step). It stops at the first step whose turn fails
+// or whose success criterion exits non-zero. The returned error is only set
+// when the task could not be started; step failures are in the report.
+func (r *Runner) Run(ctx context.Context, task *Task, fromStep string) (*Report, error) {
+	start, err := resolveStep(task, fromStep)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Task: task.Name, Steps: make([]StepOutcome, len(task.Steps))}
+	stopped := false
+	for i, step := range task.Steps {
+		switch {
+		case i < start:
+			report.Steps[i] = StepOutcome{ID: step.ID, Status: StepSkipped}
+		case stopped:
+			report.Steps[i] = StepOutcome{ID: step.ID, Status: StepNotRun}
+		default:
+			report.Steps[i] = r.runStep(ctx, task, i)
+			stopped = report.Steps[i].Status != StepPassed
+		}
+	}
+
+	r.setStep("")
+	status := "passed"
+	if !report.Passed() {
+		status = "failed"
+	}
+	r.write(map[string]interface{}{
+		"type":   "task_finished",
+		"task":   task.Name,
+		"status": status,
+		"steps":  report.Steps,
+	})
+	return report, nil
+}
+
+// runStep sends one step's prompt, executes the tool calls it leads to and
+// checks its success criterion
+func (r *Runner) runStep(ctx context.Context, task *Task, index int) StepOutcome {
+	step := task.Steps[index]
+	started := time.Now()
+	outcome := StepOutcome{ID: step.ID}
+
+	stepConfig := *r.config
+	if mode := stepApprovalMode(task, step); mode != "" {
+		stepConfig.ApprovalMode = mode
+	}
+	journal := fileops.NewJournal()
+	if owner, ok := r.agent.(interface{ SessionID() string }); ok && r.config.BlobStore {
+		journal.SetBlobStore(blobstore.Open(blobstore.DirFor(r.config.ToolDir()), r.config.BlobCompression), owner.SessionID)
+	}
+	registry := r.tools(&stepConfig, journal)
+
+	r.setStep(step.ID)
+	r.write(map[string]interface{}{
+		"type":          "step_started",
+		"step":          step.ID,
+		"index":         index + 1,
+		"approval_mode": stepConfig.ApprovalMode,
+		"prompt":        step.Prompt,
+	})
+
+	err := r.runTurn(ctx, &stepConfig, registry, step.Prompt)
+	registry.EndTurn()
+	if err == nil {
+		r.emitFileSuggestions(&stepConfig)
+	}
+	stat := journal.DiffStat()
+	for _, f := range stat.Files {
+		outcome.Changed = append(outcome.Changed, f.Path)
+	}
+	if len(stat.Files) > 0 {
+		r.emit(agent.ResponseItem{Type: agent.EventTurnDiffStat, DiffStat: &stat})
+	}
+
+	switch {
+	case errors.Is(err, agent.ErrUserInputRequired):
+		outcome.Status = StepNeedsInput
+		outcome.Error = err.Error()
+	case errors.Is(err, agent.ErrRefused):
+		outcome.Status = StepRefused
+		outcome.Error = err.Error()
+	case err != nil:
+		outcome.Status = StepError
+		outcome.Error = err.Error()
+	case step.Success == "":
+		outcome.Status = StepPassed
+	default:
+		exitCode, output, err := r.checkCriterion(ctx, step.Success)
+		switch {
+		case err != nil:
+			outcome.Status = StepError
+			outcome.Error = fmt.Sprintf("failed to run success criterion: %v", err)
+		case exitCode != 0:
+			outcome.Status = StepFailed
+			outcome.ExitCode = exitCode
+			outcome.Output = tail(output, outputTailSize)
+		default:
+			outcome.Status = StepPassed
+		}
+	}
+	outcome.Duration = time.Since(started).Seconds()
+
+	event := map[string]interface{}{"type": "step_finished", "step": step.ID}
+	data, _ := json.Marshal(outcome)
+	json.Unmarshal(data, &event)
+	r.write(event)
+	return outcome
+}
+
+// runTurn sends prompt and executes tool calls until the model stops
+// requesting them. Questions the model asks are answered per the config; a
+// reply ending with one is answered with a new message.
+func (r *Runner) runTurn(ctx context.Context, cfg *config.Config, registry *functions.Registry, prompt string) error {
+	if err := r.restoreWorkingDir(registry); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.answered = 0
+	r.mu.Unlock()
+
+	for {
+		r.mu.Lock()
+		r.pending = nil
+		r.lastReply = ""
+		r.question = ""
+		r.refusal = nil
+		r.mu.Unlock()
+
+		endedWithTools, err := r.agent.SendMessage(ctx, []agent.Message{{Role: "user", Content: prompt}}, r.handle)
+		if err != nil {
+			return err
+		}
+		if endedWithTools {
+			if err := r.runToolCalls(ctx, cfg, registry); err != nil {
+				return err
+			}
+		}
+
+		r.mu.Lock()
+		question, refusal := r.question, r.refusal
+		r.mu.Unlock()
+		if refusal != nil {
+			return fmt.Errorf("%w (%s): %s", agent.ErrRefused, refusal.Category, refusal.Message())
+		}
+		if question == "" {
+			return nil
+		}
+		if prompt, err = r.answer(cfg, question); err != nil {
+			return err
+		}
+	}
+}
+
+// answer returns the answer to a question the model asked, or
+// ErrUserInputRequired when the config has none or the turn asked too often
+func (r *Runner) answer(cfg *config.Config, question string) (string, error) {
+	r.mu.Lock()
+	r.answered++
+	answered := r.answered
+	r.mu.Unlock()
+
+	answer, ok := agent.AnswerQuestion(cfg, question)
+	if !ok || answered > maxAnswers {
+		return "", fmt.Errorf("%w: %q", agent.ErrUserInputRequired, question)
+	}
+	return answer, nil
+}
+
+// runToolCalls executes the queued tool calls, and the calls of the
+// responses that follow, until none are left
+func (r *Runner) runToolCalls(ctx context.Context, cfg *config.Config, registry *functions.Registry) error {
+	for {
+		call, ok := r.popPendingCall()
+		if !ok {
+			return nil
+		}
+
+		callStarted := time.Now()
+		var output string
+		var success bool
+		if call.Name == agent.AskUserToolName {
+			answer, err := r.answer(cfg, agent.AskUserQuestion(call.Arguments))
+			if err != nil {
+				return err
+			}
+			output, success = answer, true
+		} else if call.Name == agent.AskUserChoiceToolName {
+			// Nobody can pick; the choices answers file may have
+			answer, err := agent.AnswerChoice(cfg.ChoiceAnswers, call.Arguments)
+			output, success = answer, err == nil
+			if err != nil {
+				output = err.Error()
+			}
+		} else {
+			output, success = executeTool(cfg, r.policy, registry, call)
+			if call.Name == "change_directory" && success && registry.Workspace() != nil {
+				if err := r.recordWorkingDir(registry.Workspace().Cwd()); err != nil {
+					return err
+				}
+			}
+		}
+		result := agent.NewToolResult(call.ID, call.Name, output, success)
+		result.DurationMs = time.Since(callStarted).Milliseconds()
+		r.emit(agent.ResponseItem{
+			Type: agent.EventFunctionCallOutput,
+			FunctionOutput: &agent.FunctionCallOutput{
+				CallID:  call.ID,
+				Output:  output,
+				Success: success,
+			},
+		})
+
+		if err := r.agent.SendToolResult(ctx, result); err != nil {
+			return err
+		}
+	}
+}
+
+// executeTool runs a single tool call, denying calls that the approval policy
+// denies or that would need approval
+func executeTool(cfg *config.Config, approvalPolicy *policy.Policy, registry *functions.Registry, call agent.FunctionCall) (string, bool) {
+	if cfg.ReadOnly && agent.IsMutatingTool(call.Name) {
+		return fmt.Sprintf("Policy error: '%s' is not available because this session is read-only.", call.Name), false
+	}
+	if cfg.ToolDisabled(call.Name) {
+		return fmt.Sprintf("Policy error: '%s' is disabled by the tools configuration.", call.Name), false
+	}
+	needsApproval := functions.NeedsApproval(cfg.ApprovalMode, call.Name) || writesOutsideWorkspace(cfg, registry, call)
+	if match, requiresApproval, ok := functions.PolicyApproval(approvalPolicy, cfg.ApprovalMode, registry.Workspace(), call.Name, call.Arguments); ok {
+		if match.Decision == policy.Deny {
+			return fmt.Sprintf("Policy error: '%s' was denied: %s", call.Name, match.Explain()), false
+		}
+		needsApproval = requiresApproval
+	}
+	if needsApproval {
+		return fmt.Sprintf("Operation '%s' denied: it needs approval, which is unavailable in an unattended run (approval mode: %s).", call.Name, cfg.ApprovalMode), false
+	}
+
+	fn := registry.Get(call.Name)
+	if fn == nil {
+		return fmt.Sprintf("Unknown function: %s", call.Name), false
+	}
+	result, err := fn(call.Arguments)
+	var shellErr *functions.ShellError
+	if errors.As(err, &shellErr) {
+		return shellErr.Result.String(), false
+	}
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err), false
+	}
+	return result, true
+}
+
+// writesOutsideWorkspace reports whether a shell call is likely to write outside
+// the workspace, run in the working directory of the registry's tools, which
+// requires approval even in auto modes
+func writesOutsideWorkspace(cfg *config.Config, registry *functions.Registry, call agent.FunctionCall) bool {
+	if cfg.ApprovalMode == config.DangerousAutoApprove {
+		return false
+	}
+	command, ok := functions.ShellCommand(call.Name, call.Arguments)
+	if !ok {
+		return false
+	}
+	cwd, root := cfg.ToolDir(), cfg.ToolDir()
+	if workspace := registry.Workspace(); workspace != nil {
+		cwd, root = workspace.Cwd(), workspace.Dir
+	}
+	return len(sandbox.AnalyzeCommand(command).WritesOutsideFrom(cwd, root)) > 0
+}
+
+// restoreWorkingDir moves the tools of a step's registry to the working
+// directory the previous steps left the session in, or back to the workspace
+// root when it no longer exists
+func (r *Runner) restoreWorkingDir(registry *functions.Registry) error {
+	getter, ok := r.agent.(interface{ WorkingDir() string })
+	workspace := registry.Workspace()
+	if !ok || workspace == nil || getter.WorkingDir() == "" {
+		return nil
+	}
+	if _, err := workspace.Chdir(getter.WorkingDir()); err != nil {
+		return r.recordWorkingDir("")
+	}
+	return nil
+}
+
+// recordWorkingDir records the working directory change_directory moved the
+// tools to with the agent, which states it in the prompt and persists it
+func (r *Runner) recordWorkingDir(dir string) error {
+	setter, ok := r.agent.(interface{ SetWorkingDir(dir string) error })
+	if !ok {
+		return nil
+	}
+	if err := setter.SetWorkingDir(dir); err != nil {
+		return fmt.Errorf("failed to record working directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// checkCriterion runs a success criterion in the working directory and returns
+// its exit code and combined output
+func (r *Runner) checkCriterion(ctx context.Context, command string) (int, string, error) {
+	executor := &sandbox.BasicExecutor{}
+	result, err := executor.Execute(ctx, "/bin/sh", []string{"-c", command}, sandbox.Options{
+		WorkingDirectory: r.config.ToolDir(),
+		NetworkEnabled:   true,
+		EnvironmentVars:  os.Environ(),
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	if result.ExitCode < 0 {
+		return 0, "", fmt.Errorf("%s", result.Error)
+	}
+	return result.ExitCode, result.Output + result.Error, nil
+}
+
+// handle is the agent's response handler; it queues tool calls and logs every item
+func (r *Runner) handle(itemJSON string) {
+	var item agent.ResponseItem
+	if err := json.Unmarshal([]byte(itemJSON), &item); err != nil {
+		return
+	}
+	if item.Type == "function_call" && item.FunctionCall != nil {
+		r.mu.Lock()
+		r.pending = append(r.pending, *item.FunctionCall)
+		r.mu.Unlock()
+	}
+	if item.Type == "message" && item.Message != nil && item.Message.Role == "assistant" && item.Choice == 0 {
+		r.mu.Lock()
+		r.lastReply = item.Message.Content // Each item carries the full message so far
+		r.mu.Unlock()
+	}
+	if item.Type == "refusal" && item.Refusal != nil {
+		r.mu.Lock()
+		r.refusal = item.Refusal
+		r.mu.Unlock()
+	}
+	// ask_user calls are answered as tool calls; a reply ending with a question gets a new message
+	if item.Type == "user_input_required" && item.FunctionCall == nil && item.Message != nil {
+		r.mu.Lock()
+		r.question = item.Message.Content
+		r.mu.Unlock()
+	}
+	r.emit(item)
+}
+
+// emitFileSuggestions writes a "suggested_file" event for each file the
+// turn's final reply gave in full in a code block instead of writing it.
+// Nothing is written; the event carries a diff against the current file.
+func (r *Runner) emitFileSuggestions(cfg *config.Config) {
+	r.mu.Lock()
+	reply := r.lastReply
+	step := r.step
+	r.mu.Unlock()
+
+	for _, suggestion := range fileops.ExtractFileSuggestions(reply) {
+		event := map[string]interface{}{
+			"type":     "suggested_file",
+			"step":     step,
+			"path":     suggestion.Path,
+			"language": suggestion.Language,
+			"content":  suggestion.Content,
+		}
+		path := suggestion.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cfg.ToolDir(), path)
+		}
+		existing, err := os.ReadFile(path)
+		event["exists"] = err == nil
+		event["diff"] = fileops.UnifiedDiff(suggestion.Path, string(existing), suggestion.Content)
+		r.write(event)
+	}
+}
+
+// emit logs a response item tagged with the current step
+func (r *Runner) emit(item agent.ResponseItem) {
+	event := map[string]interface{}{}
+	data, _ := json.Marshal(item)
+	json.Unmarshal(data, &event)
+
+	r.mu.Lock()
+	event["step"] = r.step
+	r.mu.Unlock()
+	r.write(event)
+}
+
+// write writes one event as a JSON line
+func (r *Runner) write(event map[string]interface{}) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	r.outMu.Lock()
+	defer r.outMu.Unlock()
+	r.out.Write(append(data, '\n'))
+}
+
+// setStep sets the step that subsequent events belong to
+func (r *Runner) setStep(id string) {
+	r.mu.Lock()
+	r.step = id
+	r.mu.Unlock()
+}
+
+// popPendingCall removes and returns the oldest queued tool call
+func (r *Runner) popPendingCall() (agent.FunctionCall, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) == 0 {
+		return agent.FunctionCall{}, false
+	}
+	call := r.pending[0]
+	r.pending = r.pending[1:]
+	return call, true
+}
+
+// stepApprovalMode returns the approval mode a step runs under, or "" to use the config's
+func stepApprovalMode(task *Task, step Step) config.ApprovalMode {
+	if step.ApprovalMode != "" {
+		return step.ApprovalMode
+	}
+	return task.ApprovalMode
+}
+
+// resolveStep returns the index of the step named by a step ID or 1-based number
+func resolveStep(task *Task, fromStep string) (int, error) {
+	if fromStep == "" {
+		return 0, nil
+	}
+	if index, ok := task.StepIndex(fromStep); ok {
+		return index, nil
+	}
+	if n, err := strconv.Atoi(fromStep); err == nil && n >= 1 && n <= len(task.Steps) {
+		return n - 1, nil
+	}
+	return 0, fmt.Errorf("unknown step %q", fromStep)
+}
+
+// tail returns the last n bytes of s
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return "...[truncated]...\n" + s[len(s)-n:]
+}
@@ -0,0 +1,367 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/agent"
+	"github.com/epuerta/codex-go/internal/config"
+	"github.com/epuerta/codex-go/internal/fileops"
+	"github.com/epuerta/codex-go/internal/functions"
+	"github.com/epuerta/codex-go/internal/policy"
+)
+
+// fakeAgent answers each prompt with the tool call scripted for it, if any,
+// and records the tool results it receives
+type fakeAgent struct {
+	calls    map[string]agent.FunctionCall // Prompt -> tool call to request
+	replies  map[string]string             // Prompt -> reply instead of "Done."
+	refusals map[string]agent.Refusal      // Prompt -> refusal instead of a reply
+	prompts  []string
+	results  []string
+	handler  agent.ResponseHandler
+}
+
+func (f *fakeAgent) SendMessage(ctx context.Context, messages []agent.Message, handler agent.ResponseHandler) (bool, error) {
+	prompt := messages[len(messages)-1].Content
+	f.prompts = append(f.prompts, prompt)
+	f.handler = handler
+	call, ok := f.calls[prompt]
+	if refusal, ok := f.refusals[prompt]; ok {
+		data, _ := json.Marshal(agent.ResponseItem{Type: "refusal", Message: &agent.Message{Role: "assistant", Content: refusal.Message()}, Refusal: &refusal})
+		handler(string(data))
+		return false, nil
+	}
+	if !ok {
+		if reply, ok := f.replies[prompt]; ok {
+			f.reply(reply)
+		} else {
+			f.reply("Done.")
+		}
+		return false, nil
+	}
+	call.ID = fmt.Sprintf("call_%d", len(f.prompts))
+	data, _ := json.Marshal(agent.ResponseItem{Type: "function_call", FunctionCall: &call})
+	handler(string(data))
+	return true, nil
+}
+
+func (f *fakeAgent) SendToolResult(ctx context.Context, result agent.ToolResult) error {
+	output := result.Output
+	if !result.Success {
+		output = result.Error
+	}
+	f.results = append(f.results, output)
+	f.reply("Finished with " + result.Name)
+	return nil
+}
+
+func (f *fakeAgent) reply(content string) {
+	data, _ := json.Marshal(agent.ResponseItem{Type: "message", Message: &agent.Message{Role: "assistant", Content: content}})
+	f.handler(string(data))
+	if question := agent.TrailingQuestion(content); question != "" {
+		data, _ := json.Marshal(agent.ResponseItem{Type: "user_input_required", Message: &agent.Message{Role: "assistant", Content: question}})
+		f.handler(string(data))
+	}
+}
+
+// newTestRunner creates a runner whose tools work in a temporary directory
+func newTestRunner(t *testing.T, fake *fakeAgent) (*Runner, *bytes.Buffer, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &config.Config{CWD: dir, ApprovalMode: config.Suggest, DisableMemory: true}
+	tools := func(cfg *config.Config, journal *fileops.Journal) *functions.Registry {
+		return functions.NewToolRegistry(cfg, journal, nil, nil, nil)
+	}
+	var out bytes.Buffer
+	return NewRunner(fake, cfg, tools, &out), &out, dir
+}
+
+// decodeEvents parses the runner's JSON lines
+func decodeEvents(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var events []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Invalid event line %q: %v", line, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestRunnerStopsOnFailedCriterion(t *testing.T) {
+	fake := &fakeAgent{calls: map[string]agent.FunctionCall{
+		"Create the marker.": {Name: "write_file", Arguments: `{"path":"marker","content":"ok"}`},
+	}}
+	runner, out, dir := newTestRunner(t, fake)
+	task := &Task{
+		Name: "chores",
+		Steps: []Step{
+			{ID: "create", Prompt: "Create the marker.", ApprovalMode: config.AutoEdit, Success: "test -f marker"},
+			{ID: "check", Prompt: "Check it.", Success: "echo broken >&2; exit 3"},
+			{ID: "never", Prompt: "Unreachable."},
+		},
+	}
+
+	report, err := runner.Run(context.Background(), task, "")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want := []StepStatus{StepPassed, StepFailed, StepNotRun}
+	for i, status := range want {
+		if report.Steps[i].Status != status {
+			t.Errorf("Step %s: expected %s, got %s", report.Steps[i].ID, status, report.Steps[i].Status)
+		}
+	}
+	if report.Passed() {
+		t.Errorf("Expected the report not to pass")
+	}
+	if got := report.Steps[0].Changed; len(got) != 1 || got[0] != filepath.Join(dir, "marker") {
+		t.Errorf("Expected the created file to be reported, got %v", got)
+	}
+	if failed := report.Steps[1]; failed.ExitCode != 3 || !strings.Contains(failed.Output, "broken") {
+		t.Errorf("Expected exit code 3 with the criterion's output, got %+v", failed)
+	}
+	if len(fake.prompts) != 2 {
+		t.Errorf("Expected the run to stop after the failed step, got prompts %v", fake.prompts)
+	}
+
+	var summary bytes.Buffer
+	report.WriteSummary(&summary)
+	if !strings.Contains(summary.String(), "--from-step check") {
+		t.Errorf("Expected the summary to explain how to resume, got:\n%s", summary.String())
+	}
+
+	events := decodeEvents(t, out)
+	steps := map[string]string{}
+	diffstats := 0
+	for _, event := range events {
+		if event["type"] == "turn_diffstat" {
+			diffstats++
+			if event["step"] != "create" {
+				t.Errorf("Expected the turn_diffstat event for step create, got %v", event["step"])
+			}
+		}
+		if event["type"] == "function_call_output" || event["type"] == "message" {
+			if event["step"] == "" {
+				t.Errorf("Expected %s event to carry a step ID", event["type"])
+			}
+		}
+		if event["type"] == "step_finished" {
+			steps[event["step"].(string)] = event["status"].(string)
+		}
+	}
+	if diffstats != 1 {
+		t.Errorf("Expected one turn_diffstat event, got %d", diffstats)
+	}
+	if steps["create"] != "passed" || steps["check"] != "failed" {
+		t.Errorf("Expected step_finished events for both steps, got %v", steps)
+	}
+	if last := events[len(events)-1]; last["type"] != "task_finished" || last["status"] != "failed" {
+		t.Errorf("Expected a failed task_finished event last, got %v", last)
+	}
+}
+
+func TestRunnerResumesFromStepAndDeniesUnapprovedCalls(t *testing.T) {
+	fake := &fakeAgent{calls: map[string]agent.FunctionCall{
+		"Clean up.": {Name: "shell", Arguments: `{"command":"touch cleaned"}`},
+	}}
+	runner, _, _ := newTestRunner(t, fake)
+	task := &Task{Steps: []Step{
+		{ID: "build", Prompt: "Build it."},
+		{ID: "clean", Prompt: "Clean up."},
+	}}
+
+	report, err := runner.Run(context.Background(), task, "2")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Steps[0].Status != StepSkipped || report.Steps[1].Status != StepPassed {
+		t.Errorf("Expected the first step to be skipped, got %+v", report.Steps)
+	}
+	if !report.Passed() {
+		t.Errorf("Expected the report to pass")
+	}
+	if len(fake.results) != 1 || !strings.Contains(fake.results[0], "unattended") {
+		t.Errorf("Expected the shell call to be denied in suggest mode, got %v", fake.results)
+	}
+
+	if _, err := runner.Run(context.Background(), task, "deploy"); err == nil {
+		t.Errorf("Expected an error for an unknown step")
+	}
+}
+
+func TestRunnerRefusesCallsThePolicyDenies(t *testing.T) {
+	fake := &fakeAgent{calls: map[string]agent.FunctionCall{
+		"Clean up.": {Name: "shell", Arguments: `{"command":"touch cleaned"}`},
+	}}
+	runner, _, dir := newTestRunner(t, fake)
+	runner.config.ApprovalMode = config.DangerousAutoApprove
+	rules, err := policy.Parse([]byte("rules:\n  - tool: shell\n    command_prefix: touch\n    decision: deny\n"), "policy.yaml")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	runner.SetPolicy(policy.Merge(rules, nil))
+
+	if _, err := runner.Run(context.Background(), &Task{Steps: []Step{{ID: "clean", Prompt: "Clean up."}}}, ""); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(fake.results) != 1 || !strings.Contains(fake.results[0], "Policy error") {
+		t.Errorf("Expected the policy to deny the shell call, got %v", fake.results)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cleaned")); !os.IsNotExist(err) {
+		t.Errorf("Expected the denied command not to run, got %v", err)
+	}
+}
+
+func TestRunnerEmitsFileSuggestions(t *testing.T) {
+	fake := &fakeAgent{replies: map[string]string{
+		"Fix main.": "In main.go:\n```go\npackage main\n\nfunc main() {}\n```\nAnd a snippet:\n```go\nfmt.Println()\n```\n",
+	}}
+	runner, out, dir := newTestRunner(t, fake)
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatalf("Failed to write main.go: %v", err)
+	}
+
+	if _, err := runner.Run(context.Background(), &Task{Steps: []Step{{ID: "fix", Prompt: "Fix main."}}}, ""); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	var suggestions []map[string]interface{}
+	for _, event := range decodeEvents(t, out) {
+		if event["type"] == "suggested_file" {
+			suggestions = append(suggestions, event)
+		}
+	}
+	if len(suggestions) != 1 {
+		t.Fatalf("Expected one suggested_file event, got %v", suggestions)
+	}
+	event := suggestions[0]
+	if event["step"] != "fix" || event["path"] != "main.go" || event["exists"] != true {
+		t.Errorf("Unexpected suggestion event: %v", event)
+	}
+	if diff, _ := event["diff"].(string); !strings.Contains(diff, "+func main() {}") {
+		t.Errorf("Expected a diff against the existing file, got %q", diff)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "main.go")); string(data) != "package main\n" {
+		t.Errorf("Expected the suggestion not to be written, got %q", data)
+	}
+}
+
+func TestRunnerAnswersQuestions(t *testing.T) {
+	fake := &fakeAgent{
+		replies: map[string]string{"Set up storage.": "Which database should I use?"},
+		calls:   map[string]agent.FunctionCall{"Deploy.": {Name: agent.AskUserToolName, Arguments: `{"question":"Which region?"}`}},
+	}
+	runner, _, _ := newTestRunner(t, fake)
+	runner.config.QuestionAnswers = map[string]string{"database": "Use SQLite."}
+	task := &Task{Steps: []Step{
+		{ID: "storage", Prompt: "Set up storage."},
+		{ID: "deploy", Prompt: "Deploy."},
+	}}
+
+	report, err := runner.Run(context.Background(), task, "")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !report.Passed() {
+		t.Fatalf("Expected both steps to pass, got %+v", report.Steps)
+	}
+	if len(fake.prompts) != 3 || fake.prompts[1] != "Use SQLite." {
+		t.Errorf("Expected the question answered from question_answers, got prompts %v", fake.prompts)
+	}
+	if len(fake.results) != 1 || fake.results[0] != config.DefaultQuestionAnswer {
+		t.Errorf("Expected ask_user answered with the default instruction, got %v", fake.results)
+	}
+}
+
+func TestRunnerAnswersChoicesFromTheChoicesFile(t *testing.T) {
+	args := `{"question":"Which files should I refactor?","kind":"files","multiple":true}`
+	request, _ := agent.ParseChoiceRequest(args)
+	fake := &fakeAgent{calls: map[string]agent.FunctionCall{
+		"Refactor.": {Name: agent.AskUserChoiceToolName, Arguments: args},
+		"Pick one.": {Name: agent.AskUserChoiceToolName, Arguments: `{"question":"Which one?","options":["a","b"]}`},
+	}}
+	runner, _, _ := newTestRunner(t, fake)
+	runner.config.ChoiceAnswers = map[string]string{request.Hash(): "a.go\nb.go"}
+	task := &Task{Steps: []Step{{ID: "refactor", Prompt: "Refactor."}, {ID: "pick", Prompt: "Pick one."}}}
+
+	if _, err := runner.Run(context.Background(), task, ""); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(fake.results) != 2 || fake.results[0] != "a.go\nb.go" {
+		t.Fatalf("Expected the choice answered from the choices file, got %v", fake.results)
+	}
+	if !strings.Contains(fake.results[1], "--choices") {
+		t.Errorf("Expected an unanswered choice to fail naming --choices, got %q", fake.results[1])
+	}
+}
+
+func TestRunnerStopsOnUnansweredQuestion(t *testing.T) {
+	fake := &fakeAgent{replies: map[string]string{"Set up storage.": "Should I use Postgres or SQLite?"}}
+	runner, _, _ := newTestRunner(t, fake)
+	runner.config.QuestionPolicy = config.QuestionFail
+	task := &Task{Steps: []Step{{ID: "storage", Prompt: "Set up storage."}, {ID: "never", Prompt: "Unreachable."}}}
+
+	report, err := runner.Run(context.Background(), task, "")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Steps[0].Status != StepNeedsInput || report.Steps[1].Status != StepNotRun || !report.NeedsInput() {
+		t.Fatalf("Expected the run to stop needing input, got %+v", report.Steps)
+	}
+	if !strings.Contains(report.Steps[0].Error, "Postgres or SQLite") {
+		t.Errorf("Expected the question in the error, got %q", report.Steps[0].Error)
+	}
+	if len(fake.prompts) != 1 {
+		t.Errorf("Expected no answer to be sent, got prompts %v", fake.prompts)
+	}
+}
+
+func TestRunnerStopsOnRefusal(t *testing.T) {
+	fake := &fakeAgent{refusals: map[string]agent.Refusal{"Write the exploit.": {Category: "violence"}}}
+	runner, out, _ := newTestRunner(t, fake)
+	task := &Task{Steps: []Step{{ID: "exploit", Prompt: "Write the exploit."}, {ID: "never", Prompt: "Unreachable."}}}
+
+	report, err := runner.Run(context.Background(), task, "")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Steps[0].Status != StepRefused || report.Steps[1].Status != StepNotRun || !report.Refused() || report.NeedsInput() {
+		t.Fatalf("Expected the run to stop at the refusal, got %+v", report.Steps)
+	}
+	if !strings.Contains(report.Steps[0].Error, "(violence)") {
+		t.Errorf("Expected the category in the error, got %q", report.Steps[0].Error)
+	}
+	report.WriteSummary(out)
+	if !strings.Contains(out.String(), "Step exploit stopped:") {
+		t.Errorf("Expected the summary to explain the stop, got:\n%s", out.String())
+	}
+}
+
+func TestWritesOutsideWorkspaceResolvesAgainstToolDir(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{CWD: dir, ApprovalMode: config.FullAuto}
+	call := func(command string) agent.FunctionCall {
+		args, _ := json.Marshal(map[string]string{"command": command})
+		return agent.FunctionCall{Name: "shell", Arguments: string(args)}
+	}
+
+	// Without a workspace on the registry the config's tool directory is the
+	// root, not the directory the process runs in
+	registry := functions.NewRegistry()
+	if writesOutsideWorkspace(cfg, registry, call("touch "+filepath.Join(dir, "inside"))) {
+		t.Errorf("Expected a write inside the tool directory not to be escalated")
+	}
+	if !writesOutsideWorkspace(cfg, registry, call("touch "+filepath.Join(filepath.Dir(dir), "outside"))) {
+		t.Errorf("Expected a write outside the tool directory to be escalated")
+	}
+}
@@ -0,0 +1,136 @@
+package tasks
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/epuerta/codex-go/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Task is an ordered list of prompts run against a single session
+type Task struct {
+	Name         string              `yaml:"name"`
+	ApprovalMode config.ApprovalMode `yaml:"approval_mode"` // Default for steps that don't set one
+	Variables    map[string]string   `yaml:"variables"`     // Substituted into prompts and criteria as {{.name}}
+	Steps        []Step              `yaml:"steps"`
+}
+
+// Step is a single prompt and the criterion that must pass before the next step runs
+type Step struct {
+	ID           string              `yaml:"id"`
+	Prompt       string              `yaml:"prompt"`
+	ApprovalMode config.ApprovalMode `yaml:"approval_mode"`
+	Success      string              `yaml:"success"` // Shell command whose exit code gates progression (optional)
+}
+
+// Load reads a task file and substitutes its variables. Values in overrides
+// replace or add to the variables defined in the file.
+func Load(path string, overrides map[string]string) (*Task, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task file: %w", err)
+	}
+	return Parse(data, overrides)
+}
+
+// Parse parses a task definition and substitutes its variables
+func Parse(data []byte, overrides map[string]string) (*Task, error) {
+	var task Task
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&task); err != nil {
+		return nil, fmt.Errorf("failed to parse task file: %w", err)
+	}
+
+	if task.Variables == nil {
+		task.Variables = make(map[string]string)
+	}
+	for name, value := range overrides {
+		task.Variables[name] = value
+	}
+
+	err := task.validate()
+	if err != nil {
+		return nil, err
+	}
+	for i := range task.Steps {
+		step := &task.Steps[i]
+		if step.Prompt, err = expand(step.Prompt, task.Variables); err != nil {
+			return nil, fmt.Errorf("step %s: invalid prompt: %w", step.ID, err)
+		}
+		if step.Success, err = expand(step.Success, task.Variables); err != nil {
+			return nil, fmt.Errorf("step %s: invalid success criterion: %w", step.ID, err)
+		}
+	}
+	return &task, nil
+}
+
+// validate checks the task's steps and assigns IDs to steps without one
+func (t *Task) validate() error {
+	if len(t.Steps) == 0 {
+		return fmt.Errorf("task has no steps")
+	}
+	if err := validateApprovalMode(t.ApprovalMode); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for i := range t.Steps {
+		step := &t.Steps[i]
+		if step.ID == "" {
+			step.ID = fmt.Sprintf("step-%d", i+1)
+		}
+		if seen[step.ID] {
+			return fmt.Errorf("duplicate step id %q", step.ID)
+		}
+		seen[step.ID] = true
+
+		if strings.TrimSpace(step.Prompt) == "" {
+			return fmt.Errorf("step %s: prompt is required", step.ID)
+		}
+		if err := validateApprovalMode(step.ApprovalMode); err != nil {
+			return fmt.Errorf("step %s: %w", step.ID, err)
+		}
+	}
+	return nil
+}
+
+// StepIndex returns the index of the step with the given ID
+func (t *Task) StepIndex(id string) (int, bool) {
+	for i, step := range t.Steps {
+		if step.ID == id {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// validateApprovalMode rejects unknown approval modes; empty means inherit
+func validateApprovalMode(mode config.ApprovalMode) error {
+	switch mode {
+	case "", config.Suggest, config.AutoEdit, config.FullAuto, config.DangerousAutoApprove:
+		return nil
+	}
+	return fmt.Errorf("invalid approval mode %q (expected %s, %s, %s or %s)",
+		mode, config.Suggest, config.AutoEdit, config.FullAuto, config.DangerousAutoApprove)
+}
+
+// expand substitutes {{.name}} references to variables in text
+func expand(text string, variables map[string]string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, variables); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
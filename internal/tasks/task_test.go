@@ -0,0 +1,66 @@
+package tasks
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/epuerta/codex-go/internal/config"
+)
+
+func TestParseExpandsVariables(t *testing.T) {
+	task, err := Parse([]byte(`
+name: bump
+approval_mode: auto-edit
+variables:
+  module: cobra
+  target: ./...
+steps:
+  - prompt: Upgrade {{.module}}.
+    success: go build {{.target}}
+  - id: test
+    prompt: Fix the tests.
+    approval_mode: full-auto
+`), map[string]string{"target": "./cmd/..."})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if task.Steps[0].ID != "step-1" {
+		t.Errorf("Expected a default step ID, got %q", task.Steps[0].ID)
+	}
+	if task.Steps[0].Prompt != "Upgrade cobra." {
+		t.Errorf("Expected the prompt to be expanded, got %q", task.Steps[0].Prompt)
+	}
+	if task.Steps[0].Success != "go build ./cmd/..." {
+		t.Errorf("Expected the override to win, got %q", task.Steps[0].Success)
+	}
+	if mode := stepApprovalMode(task, task.Steps[0]); mode != config.AutoEdit {
+		t.Errorf("Expected the task's approval mode, got %q", mode)
+	}
+	if mode := stepApprovalMode(task, task.Steps[1]); mode != config.FullAuto {
+		t.Errorf("Expected the step's approval mode, got %q", mode)
+	}
+}
+
+func TestParseRejectsInvalidTasks(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"no steps", "name: empty", "no steps"},
+		{"missing prompt", "steps:\n  - id: a", "prompt is required"},
+		{"duplicate id", "steps:\n  - id: a\n    prompt: x\n  - id: a\n    prompt: y", "duplicate step id"},
+		{"bad approval mode", "steps:\n  - prompt: x\n    approval_mode: yolo", "invalid approval mode"},
+		{"undefined variable", "steps:\n  - prompt: '{{.missing}}'", "missing"},
+		{"unknown field", "steps:\n  - prompt: x\n    sucess: true", "sucess"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.yaml), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
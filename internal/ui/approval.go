@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -12,7 +13,8 @@ import (
 
 // ApprovalResultMsg is sent when the user makes a choice in the approval UI
 type ApprovalResultMsg struct {
-	Approved bool // true if approved, false if denied or cancelled
+	Approved bool   // true if approved, false if denied or cancelled
+	Edited   string // Replacement the user approved instead of the proposal (empty if unchanged)
 }
 
 // Styles for approval UI
@@ -70,6 +72,7 @@ type approvalKeyMap struct {
 	PageDown key.Binding
 	Approve  key.Binding
 	Deny     key.Binding
+	Edit     key.Binding
 	Help     key.Binding // Added Help key
 }
 
@@ -111,6 +114,10 @@ func defaultApprovalKeyMap() approvalKeyMap {
 			key.WithKeys("n"),
 			key.WithHelp("n", "deny"),
 		),
+		Edit: key.NewBinding(
+			key.WithKeys("e"),
+			key.WithHelp("e", "edit"),
+		),
 		Help: key.NewBinding( // Added Help key binding
 			key.WithKeys("?"),
 			key.WithHelp("?", "toggle help"), // Simple toggle description
@@ -129,6 +136,12 @@ type ApprovalModel struct {
 	keyMap       approvalKeyMap
 	showFullHelp bool // Added state for toggling help
 
+	// Inline editing of the proposal, e.g. a shell command
+	editable  bool
+	editing   bool
+	original  string // Proposal the edit input starts from
+	editInput textinput.Model
+
 	viewport viewport.Model
 	ready    bool // Viewport readiness flag
 	// Store terminal dimensions for Place function in View
@@ -158,6 +171,18 @@ func NewApprovalModel(title, description, action string) ApprovalModel {
 	}
 }
 
+// AllowEdit lets the user edit value, the proposal, before approving it. The
+// edit input is pre-filled with value.
+func (m *ApprovalModel) AllowEdit(value string) {
+	input := textinput.New()
+	input.Prompt = "$ "
+	input.CharLimit = 4096
+	input.SetValue(value)
+	m.editable = true
+	m.original = value
+	m.editInput = input
+}
+
 // SetSize calculates layout dimensions based on terminal size
 func (m *ApprovalModel) SetSize(termWidth, termHeight int) {
 	m.terminalWidth = termWidth
@@ -190,6 +215,7 @@ func (m *ApprovalModel) SetSize(termWidth, termHeight int) {
 		vpWidth = 0
 	}
 	m.viewport.Width = vpWidth
+	m.editInput.Width = vpWidth - lipgloss.Width(m.editInput.Prompt) - 1
 
 	// --- Wrap Content for Height Calculation ---
 	wrappedAction := lipgloss.NewStyle().Width(m.viewport.Width).Render(m.Action)
@@ -210,6 +236,9 @@ func (m *ApprovalModel) SetSize(termWidth, termHeight int) {
 		approvalDescriptionStyle.GetVerticalMargins() +
 		approvalButtonStyle.GetVerticalMargins()*2 + // Button row margins
 		approvalHelpStyle.GetVerticalMargins()
+	if m.editing {
+		nonViewportHeight += lipgloss.Height(m.renderEditInput(vpWidth))
+	}
 
 	// --- Calculate Viewport and Dialog Height ---
 	// Available height within terminal for the dialog content itself
@@ -292,6 +321,10 @@ func (m ApprovalModel) Update(msg tea.Msg) (ApprovalModel, tea.Cmd) {
 		m.SetSize(msg.Width, msg.Height)
 
 	case tea.KeyMsg:
+		if m.editing {
+			return m.updateEditing(msg)
+		}
+
 		// Give viewport priority for scrolling keys if content overflows
 		contentOverflows := m.viewport.TotalLineCount() > m.viewport.Height
 		isScrollingKey := key.Matches(msg, m.keyMap.Up) || key.Matches(msg, m.keyMap.Down) || key.Matches(msg, m.keyMap.PageUp) || key.Matches(msg, m.keyMap.PageDown)
@@ -318,6 +351,12 @@ func (m ApprovalModel) Update(msg tea.Msg) (ApprovalModel, tea.Cmd) {
 				m.Approved = false // Treat cancel as denial for simplicity
 				cmds = append(cmds, func() tea.Msg { return ApprovalResultMsg{Approved: false} })
 
+			case m.editable && key.Matches(msg, m.keyMap.Edit):
+				m.editing = true
+				m.editInput.CursorEnd()
+				cmds = append(cmds, m.editInput.Focus())
+				m.SetSize(m.terminalWidth, m.terminalHeight)
+
 			case key.Matches(msg, m.keyMap.Help):
 				m.showFullHelp = !m.showFullHelp
 				// Recalculate layout as help height might change
@@ -341,6 +380,40 @@ func (m ApprovalModel) Update(msg tea.Msg) (ApprovalModel, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// updateEditing handles keys while the edit input has focus. Enter approves the
+// edited value and Esc returns to the approval choices.
+func (m ApprovalModel) updateEditing(msg tea.KeyMsg) (ApprovalModel, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		edited := strings.TrimSpace(m.editInput.Value())
+		if edited == "" {
+			return m, nil
+		}
+		if edited == strings.TrimSpace(m.original) {
+			edited = ""
+		}
+		m.Approved = true
+		return m, func() tea.Msg { return ApprovalResultMsg{Approved: true, Edited: edited} }
+	case tea.KeyEsc:
+		m.editing = false
+		m.editInput.Blur()
+		m.editInput.SetValue(m.original)
+		m.SetSize(m.terminalWidth, m.terminalHeight)
+		return m, nil
+	}
+	var cmd tea.Cmd
+	m.editInput, cmd = m.editInput.Update(msg)
+	return m, cmd
+}
+
+// renderEditInput renders the edit input while editing
+func (m ApprovalModel) renderEditInput(maxWidth int) string {
+	if !m.editing {
+		return ""
+	}
+	return approvalActionStyle.Copy().Width(maxWidth).Render(m.editInput.View())
+}
+
 // renderButtons renders the Approve/Deny buttons
 func (m ApprovalModel) renderButtons() string {
 	yesStyle := approvalButtonInactiveStyle
@@ -362,8 +435,15 @@ func (m ApprovalModel) renderButtons() string {
 
 // renderHelp builds and renders the help string
 func (m ApprovalModel) renderHelp(maxWidth int) string {
+	if m.editing {
+		return approvalHelpStyle.Copy().Width(maxWidth).Render("enter: approve edited • esc: back")
+	}
+
 	// Base keys available always
 	keys := []key.Binding{m.keyMap.Select, m.keyMap.Confirm, m.keyMap.Approve, m.keyMap.Deny, m.keyMap.Cancel, m.keyMap.Help}
+	if m.editable {
+		keys = append(keys, m.keyMap.Edit)
+	}
 
 	// Add scrolling keys if content overflows
 	if m.viewport.TotalLineCount() > m.viewport.Height {
@@ -421,17 +501,17 @@ func (m ApprovalModel) View() string {
 		Width(m.viewport.Width).   // Use viewport width for the action box style
 		Height(m.viewport.Height). // Use viewport height for the action box style
 		Render(m.viewport.View())  // Render the viewport content
+	editView := m.renderEditInput(contentWidth)
 	buttonsView := m.renderButtons()
 	helpView := m.renderHelp(contentWidth) // Render help within content width
 
 	// Combine elements vertically
-	ui := lipgloss.JoinVertical(lipgloss.Left,
-		titleView,
-		descView,
-		actionView, // Render the styled viewport
-		buttonsView,
-		helpView,
-	)
+	parts := []string{titleView, descView, actionView} // Render the styled viewport
+	if editView != "" {
+		parts = append(parts, editView)
+	}
+	parts = append(parts, buttonsView, helpView)
+	ui := lipgloss.JoinVertical(lipgloss.Left, parts...)
 
 	// Apply dialog styling with calculated width and height
 	// Subtract padding *before* rendering content inside
@@ -90,6 +90,15 @@ var (
 				Foreground(lipgloss.Color("1")). // Red
 				Bold(true).
 				PaddingLeft(1)
+
+	diffStatStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("8")). // Gray
+			PaddingLeft(2)
+
+	refusalStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("9")). // Bright red
+			Bold(true).
+			PaddingLeft(1)
 )
 
 // CommandResult represents the result of a command execution
@@ -142,11 +151,22 @@ type ChatModel struct {
 	thinkingSub   chan time.Time // For thinking timer updates
 	currentStatus string         // Current status message during thinking
 
+	// Question the assistant is waiting on, quoted above the input
+	question string
+
+	// User messages waiting for the current turn to end, listed above the input
+	queued []string
+
+	// Tool calls running in the background, listed above the input with the
+	// key that cancels each
+	runningCalls []string
+
 	// Status bar info
 	sessionID    string
 	workDir      string
 	model        string
 	approvalMode string
+	readOnly     bool
 
 	// Callbacks
 	onSendMessage func(content string)
@@ -207,6 +227,11 @@ func (m *ChatModel) SetSessionInfo(sessionID, workDir, model, approvalMode strin
 	}
 }
 
+// SetReadOnly marks the session as read-only for the status bar badge
+func (m *ChatModel) SetReadOnly(readOnly bool) {
+	m.readOnly = readOnly
+}
+
 // SetAgent sets the agent reference for history access
 func (m *ChatModel) SetAgent(a agent.Agent) {
 	m.agent = a
@@ -380,6 +405,41 @@ func (m *ChatModel) AddAgentPatchResultMessage(result *fileops.AgentPatchResult)
 	})
 }
 
+// AddPatchProgressMessage adds a line for one hunk of a patch being applied
+func (m *ChatModel) AddPatchProgressMessage(progress fileops.PatchProgress) {
+	var content string
+	if progress.Applied {
+		content = fmt.Sprintf("[✓ Hunk %d/%d] %s (+%d/-%d lines)", progress.Hunk, progress.Hunks, progress.Path, progress.Added, progress.Removed)
+	} else {
+		content = fmt.Sprintf("[✗ Hunk %d/%d] %s: %s", progress.Hunk, progress.Hunks, progress.Path, progress.Error)
+	}
+	m.AddMessage(Message{
+		Role:      "patch_result",
+		Content:   content,
+		Timestamp: time.Now(),
+	})
+}
+
+// AddDiffStatMessage adds the one-line summary of the files a turn changed,
+// shown as a footer under the assistant message
+func (m *ChatModel) AddDiffStatMessage(stat fileops.DiffStat) {
+	m.AddMessage(Message{
+		Role:      "diffstat",
+		Content:   stat.String(),
+		Timestamp: time.Now(),
+	})
+}
+
+// AddRefusalMessage adds a response the model refused, or a content filter
+// withheld, set apart from the assistant's replies
+func (m *ChatModel) AddRefusalMessage(content string) {
+	m.AddMessage(Message{
+		Role:      "refusal",
+		Content:   content,
+		Timestamp: time.Now(),
+	})
+}
+
 // UpdateLastAssistantMessage updates the content of the last assistant message
 func (m *ChatModel) UpdateLastAssistantMessage(additionalContent string) {
 	// Use logger instead of direct stderr output
@@ -614,6 +674,13 @@ func formatMessage(msg Message, width int, showTimestamp bool) string {
 		}
 		prefix = "" // Prefix is already part of the content
 		renderedContent = wordWrap(msg.Content, width-2)
+	case "diffstat":
+		prefix = ""
+		renderedContent = diffStatStyle.Render(msg.Content)
+	case "refusal":
+		prefix = "refused"
+		style = refusalStyle
+		renderedContent = lipgloss.NewStyle().Italic(true).Render(wordWrap(msg.Content, width-len(prefix)-2))
 
 	default:
 		prefix = msg.Role
@@ -786,6 +853,13 @@ func (m ChatModel) View() string {
 	// Add thinking indicator to the status bar if active
 	statusInfo := fmt.Sprintf("localhost session: %s\n• workdir: %s\n• model: %s\n• approval: %s",
 		m.sessionID, m.workDir, m.model, m.approvalMode)
+	if m.readOnly {
+		statusInfo += " " + lipgloss.NewStyle().
+			Foreground(lipgloss.Color("0")).
+			Background(lipgloss.Color("14")). // Bright cyan
+			Bold(true).
+			Render(" READ-ONLY ")
+	}
 
 	if m.isThinking {
 		elapsed := time.Since(m.thinkingStart).Round(time.Second)
@@ -840,13 +914,50 @@ func (m ChatModel) View() string {
 		viewContent += thinkingStyle.Render(thinkingText)
 	}
 
+	// Quote the question being answered right above the input
+	input := m.textInput.View()
+	if m.question != "" {
+		quoteStyle := lipgloss.NewStyle().
+			Foreground(lipgloss.Color("14")). // Bright cyan
+			Width(m.width - 2)
+		var quoted []string
+		for _, line := range strings.Split(m.question, "\n") {
+			quoted = append(quoted, "> "+line)
+		}
+		input = quoteStyle.Render("The assistant asks:\n"+strings.Join(quoted, "\n")) + "\n" + input
+	}
+	if len(m.runningCalls) > 0 {
+		runningStyle := lipgloss.NewStyle().
+			Foreground(lipgloss.Color("3")). // Yellow
+			Width(m.width - 2)
+		lines := []string{fmt.Sprintf("Running tool calls (%d, alt+<n> cancels one):", len(m.runningCalls))}
+		for i, call := range m.runningCalls {
+			if i < 9 {
+				lines = append(lines, fmt.Sprintf("  [alt+%d] %s", i+1, call))
+			} else {
+				lines = append(lines, "        "+call)
+			}
+		}
+		input = runningStyle.Render(strings.Join(lines, "\n")) + "\n" + input
+	}
+	if len(m.queued) > 0 {
+		queuedStyle := lipgloss.NewStyle().
+			Foreground(lipgloss.Color("8")). // Gray
+			Width(m.width - 2)
+		lines := []string{fmt.Sprintf("Queued (%d, sent when the assistant finishes; /interrupt sends now):", len(m.queued))}
+		for _, msg := range m.queued {
+			lines = append(lines, "> "+strings.ReplaceAll(msg, "\n", " "))
+		}
+		input = queuedStyle.Render(strings.Join(lines, "\n")) + "\n" + input
+	}
+
 	// Combine the status bar, viewport, help text, and textinput
 	finalView := fmt.Sprintf(
 		"%s\n%s\n%s\n%s\n",
 		statusBar,
 		viewContent, // Use our adjusted viewport content
 		helpText,
-		m.textInput.View(),
+		input,
 	)
 	return finalView
 }
@@ -1017,6 +1128,36 @@ func (m *ChatModel) InputValue() string {
 	return m.textInput.Value()
 }
 
+// AskQuestion quotes a question of the assistant above the input and
+// focuses the input for the answer
+func (m *ChatModel) AskQuestion(question string) {
+	m.question = question
+	m.textInput.SetPlaceholder("Type your answer and press enter")
+	m.textInput.Focus()
+}
+
+// ClearQuestion removes the quoted question once it was answered
+func (m *ChatModel) ClearQuestion() {
+	if m.question == "" {
+		return
+	}
+	m.question = ""
+	m.textInput.SetPlaceholder("Send a message or press tab to select a suggestion")
+}
+
+// SetQueued lists the user messages waiting for the current turn to end
+// above the input; nil clears the list
+func (m *ChatModel) SetQueued(queued []string) {
+	m.queued = queued
+}
+
+// SetRunningCalls lists the tool calls running in the background above the
+// input, the first nine with the alt+<n> key that cancels them; nil clears
+// the list
+func (m *ChatModel) SetRunningCalls(calls []string) {
+	m.runningCalls = calls
+}
+
 // SetInputValue sets the value of the text input
 func (m *ChatModel) SetInputValue(s string) {
 	m.textInput.SetValue(s)
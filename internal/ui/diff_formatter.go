@@ -59,3 +59,24 @@ func FormatPatchForDisplay(rawPatch string) string {
 
 	return formatted.String()
 }
+
+// FormatUnifiedDiffForDisplay colors a unified diff's headers, hunks and lines
+func FormatUnifiedDiffForDisplay(diff string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			b.WriteString(approvalTitleStyle.Copy().MarginBottom(0).Render(line))
+		case strings.HasPrefix(line, "@@"):
+			b.WriteString(reviewHunkStyle.Render(line))
+		case strings.HasPrefix(line, "+"):
+			b.WriteString(diffAddedStyle.Render(line))
+		case strings.HasPrefix(line, "-"):
+			b.WriteString(diffRemovedStyle.Render(line))
+		default:
+			b.WriteString(diffContextStyle.Render(line))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
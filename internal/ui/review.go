@@ -0,0 +1,251 @@
+package ui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/epuerta/codex-go/internal/fileops"
+)
+
+// ReviewResultMsg is sent when the user finishes the end-of-turn review
+type ReviewResultMsg struct {
+	Keep    map[string]bool // Path -> true to keep the change, false to revert it
+	Restore bool            // Restore the snapshot taken before the turn instead
+}
+
+// Styles for the review UI
+var (
+	reviewFileStyle         = lipgloss.NewStyle().Foreground(lipgloss.Color("7"))
+	reviewFileSelectedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("0")).Background(lipgloss.Color("6"))
+	reviewRevertStyle       = lipgloss.NewStyle().Foreground(lipgloss.Color("9")).Strikethrough(true)
+	reviewHunkStyle         = lipgloss.NewStyle().Foreground(lipgloss.Color("6"))
+)
+
+// reviewKeyMap holds the key bindings for the review UI
+type reviewKeyMap struct {
+	Up       key.Binding
+	Down     key.Binding
+	Toggle   key.Binding
+	KeepAll  key.Binding
+	PageUp   key.Binding
+	PageDown key.Binding
+	Confirm  key.Binding
+	Cancel   key.Binding
+	Restore  key.Binding
+}
+
+func defaultReviewKeyMap() reviewKeyMap {
+	return reviewKeyMap{
+		Up:       key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "prev file")),
+		Down:     key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "next file")),
+		Toggle:   key.NewBinding(key.WithKeys(" ", "x"), key.WithHelp("space", "keep/revert")),
+		KeepAll:  key.NewBinding(key.WithKeys("a"), key.WithHelp("a", "keep all")),
+		PageUp:   key.NewBinding(key.WithKeys("pgup"), key.WithHelp("pgup", "scroll diff")),
+		PageDown: key.NewBinding(key.WithKeys("pgdown"), key.WithHelp("pgdn", "scroll diff")),
+		Confirm:  key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "finalize")),
+		Cancel:   key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "keep all and close")),
+		Restore:  key.NewBinding(key.WithKeys("R"), key.WithHelp("R", "restore snapshot")),
+	}
+}
+
+// ReviewModel shows the consolidated changes of a turn with per-file keep/revert toggles
+type ReviewModel struct {
+	changes []fileops.FileChange
+	keep    []bool
+	cursor  int
+	keyMap  reviewKeyMap
+
+	snapshot string // Snapshot taken before the turn, "" when there is none
+	notice   string // Shown above the help, e.g. why the snapshot was not restored
+
+	viewport viewport.Model
+	width    int
+	height   int
+}
+
+// NewReviewModel creates a review model; every change starts out kept
+func NewReviewModel(changes []fileops.FileChange) ReviewModel {
+	keep := make([]bool, len(changes))
+	for i := range keep {
+		keep[i] = true
+	}
+	m := ReviewModel{
+		changes:  changes,
+		keep:     keep,
+		keyMap:   defaultReviewKeyMap(),
+		viewport: viewport.New(0, 0),
+	}
+	return m
+}
+
+// SetSnapshot offers to restore the snapshot with the given ID, taken before the turn
+func (m *ReviewModel) SetSnapshot(id string) {
+	m.snapshot = id
+}
+
+// SetNotice shows text above the key help
+func (m *ReviewModel) SetNotice(text string) {
+	m.notice = text
+}
+
+// SetSize sets the layout dimensions
+func (m *ReviewModel) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+
+	listHeight := len(m.changes) + 1
+	if listHeight > height/3 {
+		listHeight = height / 3
+	}
+	vpHeight := height - listHeight - 6 // Title, separator and help lines
+	if vpHeight < 3 {
+		vpHeight = 3
+	}
+	m.viewport.Width = width - 2
+	m.viewport.Height = vpHeight
+	m.refreshDiff()
+}
+
+// refreshDiff shows the diff of the file under the cursor
+func (m *ReviewModel) refreshDiff() {
+	if len(m.changes) == 0 {
+		m.viewport.SetContent("")
+		return
+	}
+	m.viewport.SetContent(FormatUnifiedDiffForDisplay(m.changes[m.cursor].Diff))
+	m.viewport.GotoTop()
+}
+
+// result builds the ReviewResultMsg for the current selection
+func (m ReviewModel) result() ReviewResultMsg {
+	keep := make(map[string]bool, len(m.changes))
+	for i, change := range m.changes {
+		keep[change.Path] = m.keep[i]
+	}
+	return ReviewResultMsg{Keep: keep}
+}
+
+// Init initializes the model
+func (m ReviewModel) Init() tea.Cmd {
+	return nil
+}
+
+// Update handles updates to the model
+func (m ReviewModel) Update(msg tea.Msg) (ReviewModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.SetSize(msg.Width, msg.Height)
+
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keyMap.Up):
+			if m.cursor > 0 {
+				m.cursor--
+				m.refreshDiff()
+			}
+		case key.Matches(msg, m.keyMap.Down):
+			if m.cursor < len(m.changes)-1 {
+				m.cursor++
+				m.refreshDiff()
+			}
+		case key.Matches(msg, m.keyMap.Toggle):
+			if len(m.keep) > 0 {
+				m.keep[m.cursor] = !m.keep[m.cursor]
+			}
+		case key.Matches(msg, m.keyMap.KeepAll):
+			for i := range m.keep {
+				m.keep[i] = true
+			}
+		case key.Matches(msg, m.keyMap.PageUp), key.Matches(msg, m.keyMap.PageDown):
+			var cmd tea.Cmd
+			m.viewport, cmd = m.viewport.Update(msg)
+			return m, cmd
+		case key.Matches(msg, m.keyMap.Confirm):
+			result := m.result()
+			return m, func() tea.Msg { return result }
+		case key.Matches(msg, m.keyMap.Restore) && m.snapshot != "":
+			return m, func() tea.Msg { return ReviewResultMsg{Restore: true} }
+		case key.Matches(msg, m.keyMap.Cancel):
+			for i := range m.keep {
+				m.keep[i] = true
+			}
+			result := m.result()
+			return m, func() tea.Msg { return result }
+		}
+
+	case tea.MouseMsg:
+		var cmd tea.Cmd
+		m.viewport, cmd = m.viewport.Update(msg)
+		return m, cmd
+	}
+
+	return m, nil
+}
+
+// View renders the review UI
+func (m ReviewModel) View() string {
+	var b strings.Builder
+
+	kept := 0
+	for _, k := range m.keep {
+		if k {
+			kept++
+		}
+	}
+	b.WriteString(approvalTitleStyle.Render(fmt.Sprintf("Review changes from this turn (%d of %d files kept)", kept, len(m.changes))))
+	b.WriteString("\n")
+
+	for i, change := range m.changes {
+		mark := "[x]"
+		if !m.keep[i] {
+			mark = "[ ]"
+		}
+		line := fmt.Sprintf(" %s %-8s %s", mark, change.Status, displayPath(change.Path))
+		switch {
+		case i == m.cursor:
+			line = reviewFileSelectedStyle.Render(line)
+		case !m.keep[i]:
+			line = reviewRevertStyle.Render(line)
+		default:
+			line = reviewFileStyle.Render(line)
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+
+	b.WriteString(strings.Repeat("─", max(m.width, 1)))
+	b.WriteString("\n")
+	b.WriteString(m.viewport.View())
+	b.WriteString("\n")
+
+	if m.notice != "" {
+		b.WriteString(reviewRevertStyle.UnsetStrikethrough().Width(max(m.width, 1)).Render(m.notice))
+		b.WriteString("\n")
+	}
+	keys := []key.Binding{m.keyMap.Up, m.keyMap.Down, m.keyMap.Toggle, m.keyMap.KeepAll, m.keyMap.PageDown, m.keyMap.Confirm, m.keyMap.Cancel}
+	if m.snapshot != "" {
+		keys = append(keys, m.keyMap.Restore)
+	}
+	var help []string
+	for _, k := range keys {
+		help = append(help, fmt.Sprintf("%s: %s", k.Help().Key, k.Help().Desc))
+	}
+	b.WriteString(approvalHelpStyle.Render(strings.Join(help, " • ")))
+
+	return b.String()
+}
+
+// displayPath shows paths relative to the working directory when possible
+func displayPath(path string) string {
+	if cwd, err := filepath.Abs("."); err == nil {
+		if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
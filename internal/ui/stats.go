@@ -0,0 +1,29 @@
+package ui
+
+import (
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Styles for the stats overlay
+var (
+	statsDialogStyle = lipgloss.NewStyle().
+				Border(lipgloss.RoundedBorder()).
+				BorderForeground(lipgloss.Color("6")). // Cyan
+				Padding(1, 2)
+
+	statsTitleStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("5")). // Magenta-ish
+			MarginBottom(1)
+)
+
+// StatsOverlay renders a usage report in a dialog centered over a terminal
+// of width by height, shown until any key is pressed
+func StatsOverlay(report string, width, height int) string {
+	content := lipgloss.JoinVertical(lipgloss.Left,
+		statsTitleStyle.Render("Usage"),
+		report,
+		approvalHelpStyle.Render("Press any key to close"),
+	)
+	return lipgloss.Place(width, height, lipgloss.Center, lipgloss.Center, statsDialogStyle.Render(content))
+}
@@ -0,0 +1,207 @@
+package usagelog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Summary aggregates the records of a period
+type Summary struct {
+	Turns            int                `json:"turns"`
+	Requests         int                `json:"requests"`
+	PromptTokens     int                `json:"prompt_tokens"`
+	CompletionTokens int                `json:"completion_tokens"`
+	CachedTokens     int                `json:"cached_tokens,omitempty"`
+	CostUSD          float64            `json:"cost_usd"`
+	Estimated        bool               `json:"estimated,omitempty"`
+	DurationMs       int64              `json:"duration_ms"` // Time spent in turns
+	Tools            map[string]int     `json:"tools,omitempty"`
+	Models           map[string]int     `json:"models,omitempty"`      // Turns per model
+	ModelCosts       map[string]float64 `json:"model_costs,omitempty"` // Cost per model, by the model of each request
+	Days             []Day              `json:"days,omitempty"`        // Oldest first, only days with turns
+}
+
+// Day aggregates the records of one local calendar day
+type Day struct {
+	Date    string  `json:"date"` // YYYY-MM-DD
+	Turns   int     `json:"turns"`
+	Tokens  int     `json:"tokens"`
+	CostUSD float64 `json:"cost_usd"`
+}
+
+// ToolCount is the number of calls to one tool
+type ToolCount struct {
+	Name  string `json:"name"`
+	Calls int    `json:"calls"`
+}
+
+// Summarize aggregates records, bucketing days in loc
+func Summarize(records []Record, loc *time.Location) Summary {
+	s := Summary{Tools: make(map[string]int), Models: make(map[string]int), ModelCosts: make(map[string]float64)}
+	days := make(map[string]*Day)
+	for _, rec := range records {
+		s.Turns++
+		s.Requests += rec.Requests
+		s.PromptTokens += rec.PromptTokens
+		s.CompletionTokens += rec.CompletionTokens
+		s.CachedTokens += rec.CachedTokens
+		s.CostUSD += rec.CostUSD
+		s.Estimated = s.Estimated || rec.Estimated
+		s.DurationMs += rec.DurationMs
+		for name, calls := range rec.Tools {
+			s.Tools[name] += calls
+		}
+		if rec.Model != "" {
+			s.Models[rec.Model]++
+		}
+		for model, usage := range rec.Models() {
+			s.ModelCosts[model] += usage.CostUSD
+		}
+
+		date := rec.Time.In(loc).Format(time.DateOnly)
+		day := days[date]
+		if day == nil {
+			day = &Day{Date: date}
+			days[date] = day
+		}
+		day.Turns++
+		day.Tokens += rec.TotalTokens()
+		day.CostUSD += rec.CostUSD
+	}
+
+	for _, day := range days {
+		s.Days = append(s.Days, *day)
+	}
+	sort.Slice(s.Days, func(i, j int) bool { return s.Days[i].Date < s.Days[j].Date })
+	return s
+}
+
+// TotalTokens returns the prompt and completion tokens of the period
+func (s Summary) TotalTokens() int {
+	return s.PromptTokens + s.CompletionTokens
+}
+
+// CacheHitRate returns the fraction of prompt tokens served from the prompt cache
+func (s Summary) CacheHitRate() float64 {
+	if s.PromptTokens == 0 {
+		return 0
+	}
+	return float64(s.CachedTokens) / float64(s.PromptTokens)
+}
+
+// AverageTurn returns the mean duration of a turn
+func (s Summary) AverageTurn() time.Duration {
+	if s.Turns == 0 {
+		return 0
+	}
+	return time.Duration(s.DurationMs) * time.Millisecond / time.Duration(s.Turns)
+}
+
+// TopTools returns the n most called tools, most calls first (all of them when n <= 0)
+func (s Summary) TopTools(n int) []ToolCount {
+	var tools []ToolCount
+	for name, calls := range s.Tools {
+		tools = append(tools, ToolCount{Name: name, Calls: calls})
+	}
+	sort.Slice(tools, func(i, j int) bool {
+		if tools[i].Calls != tools[j].Calls {
+			return tools[i].Calls > tools[j].Calls
+		}
+		return tools[i].Name < tools[j].Name
+	})
+	if n > 0 && len(tools) > n {
+		tools = tools[:n]
+	}
+	return tools
+}
+
+// ParseSince resolves the start of a report period relative to now: a
+// number of days or weeks ("7d", "2w"), a Go duration ("12h"), "today",
+// or a date ("2025-01-31", in now's location)
+func ParseSince(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "today" {
+		year, month, day := now.Date()
+		return time.Date(year, month, day, 0, 0, 0, 0, now.Location()), nil
+	}
+	if date, err := time.ParseInLocation(time.DateOnly, value, now.Location()); err == nil {
+		return date, nil
+	}
+	if n, unit := strings.TrimRight(value, "dw"), strings.TrimLeft(value, "0123456789"); n != "" && (unit == "d" || unit == "w") {
+		count, err := strconv.Atoi(n)
+		if err == nil {
+			if unit == "w" {
+				count *= 7
+			}
+			return now.AddDate(0, 0, -count), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid period %q: expected e.g. 7d, 2w, 12h, today or YYYY-MM-DD", value)
+}
+
+// WriteReport prints s under title: totals, average turn latency, the top
+// tools and, when the period spans more than one day, the per-day totals
+func WriteReport(w io.Writer, title string, s Summary) {
+	fmt.Fprintf(w, "%s\n", title)
+	if s.Turns == 0 {
+		fmt.Fprintln(w, "  No usage recorded.")
+		return
+	}
+	approx := ""
+	if s.Estimated {
+		approx = "~"
+	}
+	fmt.Fprintf(w, "  Turns: %d (%d requests), average %s\n", s.Turns, s.Requests, s.AverageTurn().Round(100*time.Millisecond))
+	fmt.Fprintf(w, "  Tokens: %s%d (%d prompt, %d completion)\n", approx, s.TotalTokens(), s.PromptTokens, s.CompletionTokens)
+	if s.CachedTokens > 0 {
+		fmt.Fprintf(w, "  Prompt cache: %d of %d prompt tokens cached (%.0f%% hit rate)\n", s.CachedTokens, s.PromptTokens, s.CacheHitRate()*100)
+	}
+	fmt.Fprintf(w, "  Cost: %s$%.2f\n", approx, s.CostUSD)
+
+	if tools := s.TopTools(5); len(tools) > 0 {
+		parts := make([]string, len(tools))
+		for i, tool := range tools {
+			parts[i] = fmt.Sprintf("%s %d", tool.Name, tool.Calls)
+		}
+		fmt.Fprintf(w, "  Top tools: %s\n", strings.Join(parts, ", "))
+	}
+
+	if len(s.Models) > 1 {
+		models := make([]string, 0, len(s.Models))
+		for model := range s.Models {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+		parts := make([]string, len(models))
+		for i, model := range models {
+			parts[i] = fmt.Sprintf("%s %d", model, s.Models[model])
+		}
+		fmt.Fprintf(w, "  Turns by model: %s\n", strings.Join(parts, ", "))
+	}
+	if len(s.ModelCosts) > 1 {
+		models := make([]string, 0, len(s.ModelCosts))
+		for model := range s.ModelCosts {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+		parts := make([]string, len(models))
+		for i, model := range models {
+			parts[i] = fmt.Sprintf("%s %s$%.2f", model, approx, s.ModelCosts[model])
+		}
+		fmt.Fprintf(w, "  Cost by model: %s\n", strings.Join(parts, ", "))
+	}
+
+	if len(s.Days) > 1 {
+		fmt.Fprintln(w, "  By day:")
+		for _, day := range s.Days {
+			fmt.Fprintf(w, "    %s  %4d turns  %9d tokens  $%.2f\n", day.Date, day.Turns, day.Tokens, day.CostUSD)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Package usagelog records the usage of every turn in an append-only JSON
+// Lines file shared by all codex processes, and aggregates it into reports.
+package usagelog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// FileName is the name of the usage log in the config directory
+const FileName = "usage.jsonl"
+
+// MaxRecordSize bounds an encoded record, newline included. Each record is
+// appended with a single write to a file opened with O_APPEND, so records
+// from processes writing at the same time land whole and never interleave.
+const MaxRecordSize = 4096
+
+// Record is the usage of one turn: a user message and every request and
+// tool call it led to
+type Record struct {
+	Time             time.Time             `json:"time"` // When the turn ended
+	Session          string                `json:"session,omitempty"`
+	Model            string                `json:"model"`               // Model of the turn's last request
+	ByModel          map[string]ModelUsage `json:"by_model,omitempty"`  // Usage per model, for turns whose requests went to more than one
+	Escalated        bool                  `json:"escalated,omitempty"` // The turn switched to the edit model midway
+	Refusal          string                `json:"refusal,omitempty"`   // Category of a response refused or withheld by a content filter
+	Requests         int                   `json:"requests"`
+	PromptTokens     int                   `json:"prompt_tokens"`
+	CompletionTokens int                   `json:"completion_tokens"`
+	CachedTokens     int                   `json:"cached_tokens,omitempty"` // Prompt tokens served from the provider's prompt cache
+	CostUSD          float64               `json:"cost_usd"`
+	Estimated        bool                  `json:"estimated,omitempty"` // Some usage was estimated rather than reported
+	Tools            map[string]int        `json:"tools,omitempty"`     // Calls per tool
+	ToolsOmitted     bool                  `json:"tools_omitted,omitempty"`
+	DurationMs       int64                 `json:"duration_ms"`
+}
+
+// ModelUsage is the part of a turn's usage that went to one model
+type ModelUsage struct {
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// TotalTokens returns the prompt and completion tokens of the turn
+func (r Record) TotalTokens() int {
+	return r.PromptTokens + r.CompletionTokens
+}
+
+// Models returns the usage of the turn per model: ByModel, or all of it for
+// the turn's model when its requests went to one
+func (r Record) Models() map[string]ModelUsage {
+	if len(r.ByModel) > 0 {
+		return r.ByModel
+	}
+	if r.Model == "" {
+		return nil
+	}
+	return map[string]ModelUsage{r.Model: {
+		Requests:         r.Requests,
+		PromptTokens:     r.PromptTokens,
+		CompletionTokens: r.CompletionTokens,
+		CostUSD:          r.CostUSD,
+	}}
+}
+
+// Append adds rec to the log at path, creating the file and its directory as needed
+func Append(path string, rec Record) error {
+	line, err := encode(rec)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create usage log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open usage log: %w", err)
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write usage log: %w", err)
+	}
+	return f.Close()
+}
+
+// encode marshals rec as one line of at most MaxRecordSize bytes, dropping
+// the tool breakdown if it does not fit
+func encode(rec Record) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode usage record: %w", err)
+	}
+	if len(data) >= MaxRecordSize {
+		rec.Tools, rec.ToolsOmitted = nil, true
+		if data, err = json.Marshal(rec); err != nil {
+			return nil, fmt.Errorf("failed to encode usage record: %w", err)
+		}
+		if len(data) >= MaxRecordSize {
+			return nil, errors.New("usage record is too large")
+		}
+	}
+	return append(data, '\n'), nil
+}
+
+// Read returns the records at path that ended at or after since, sorted by
+// time; processes writing at once may append them out of order. A missing
+// file has no records. Lines that cannot be parsed, such as one cut short by
+// a crash, are skipped.
+func Read(path string, since time.Time) ([]Record, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open usage log: %w", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, MaxRecordSize), 1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Time.IsZero() {
+			continue
+		}
+		if rec.Time.Before(since) {
+			continue
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read usage log: %w", err)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
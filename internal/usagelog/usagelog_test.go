@@ -0,0 +1,154 @@
+package usagelog
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAppendAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "codex", FileName)
+	base := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	for i, rec := range []Record{
+		{Time: base.Add(2 * time.Hour), Model: "gpt-4o", Requests: 1},
+		{Time: base, Model: "gpt-4o", Requests: 2, Tools: map[string]int{"shell": 1}},
+		{Time: base.Add(-48 * time.Hour), Model: "o3", Requests: 1},
+	} {
+		if err := Append(path, rec); err != nil {
+			t.Fatalf("Append %d failed: %v", i, err)
+		}
+	}
+	// A line cut short by a crash
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"time":"2025-03-10T13:00:00Z","mod`)
+	f.Close()
+
+	records, err := Read(path, base.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(records) != 2 || records[0].Requests != 2 || records[1].Requests != 1 {
+		t.Fatalf("Expected the two recent records sorted by time, got %+v", records)
+	}
+	if records[0].Tools["shell"] != 1 {
+		t.Errorf("Expected the tool counts to round-trip, got %+v", records[0].Tools)
+	}
+
+	if records, err := Read(filepath.Join(t.TempDir(), "missing.jsonl"), time.Time{}); err != nil || records != nil {
+		t.Errorf("Expected a missing log to have no records, got %+v, %v", records, err)
+	}
+}
+
+func TestConcurrentAppendsDoNotInterleave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	tools := make(map[string]int)
+	for i := 0; i < 40; i++ {
+		tools[fmt.Sprintf("tool_with_a_long_name_%02d", i)] = i
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := Append(path, Record{Time: time.Now(), Model: "gpt-4o", Requests: i, Tools: tools}); err != nil {
+				t.Errorf("Append failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	records, err := Read(path, time.Time{})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(records) != 50 {
+		t.Errorf("Expected all 50 records intact, got %d", len(records))
+	}
+}
+
+func TestOversizedRecordDropsTools(t *testing.T) {
+	tools := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		tools[strings.Repeat("x", 30)+fmt.Sprint(i)] = 1
+	}
+	line, err := encode(Record{Time: time.Now(), Model: "gpt-4o", Requests: 1, Tools: tools})
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if len(line) > MaxRecordSize || !bytes.Contains(line, []byte(`"tools_omitted":true`)) || bytes.Contains(line, []byte(`"tools":`)) {
+		t.Errorf("Expected the tools to be dropped to fit, got %d bytes: %s", len(line), line)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	day1 := time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+	s := Summarize([]Record{
+		{Time: day1, Model: "gpt-4o", Escalated: true, Requests: 2, PromptTokens: 100, CachedTokens: 40, CompletionTokens: 10, CostUSD: 0.5, DurationMs: 2000, Tools: map[string]int{"shell": 2, "read_file": 1},
+			ByModel: map[string]ModelUsage{"gpt-4o-mini": {Requests: 1, PromptTokens: 50, CostUSD: 0.125}, "gpt-4o": {Requests: 1, PromptTokens: 50, CompletionTokens: 10, CostUSD: 0.375}}},
+		{Time: day1.Add(time.Hour), Model: "gpt-4o-mini", Requests: 1, PromptTokens: 50, CompletionTokens: 5, CostUSD: 0.25, DurationMs: 1000, Tools: map[string]int{"shell": 1}},
+		{Time: day2, Model: "gpt-4o-mini", Requests: 1, PromptTokens: 10, CompletionTokens: 1, CostUSD: 0.25, DurationMs: 3000, Estimated: true},
+	}, time.UTC)
+
+	if s.Turns != 3 || s.Requests != 4 || s.TotalTokens() != 176 || s.CostUSD != 1 || !s.Estimated {
+		t.Errorf("Unexpected totals: %+v", s)
+	}
+	if rate := s.CacheHitRate(); rate != 0.25 {
+		t.Errorf("Expected a cache hit rate of 0.25, got %v", rate)
+	}
+	if avg := s.AverageTurn(); avg != 2*time.Second {
+		t.Errorf("Expected an average turn of 2s, got %s", avg)
+	}
+	if top := s.TopTools(1); len(top) != 1 || top[0] != (ToolCount{Name: "shell", Calls: 3}) {
+		t.Errorf("Expected shell to be the top tool, got %+v", top)
+	}
+	if s.Models["gpt-4o"] != 1 || s.Models["gpt-4o-mini"] != 2 {
+		t.Errorf("Unexpected turns per model: %+v", s.Models)
+	}
+	if s.ModelCosts["gpt-4o"] != 0.375 || s.ModelCosts["gpt-4o-mini"] != 0.625 {
+		t.Errorf("Expected the cost of the escalated turn split by model, got %+v", s.ModelCosts)
+	}
+	if len(s.Days) != 2 || s.Days[0].Date != "2025-03-10" || s.Days[0].Turns != 2 || s.Days[1].Tokens != 11 {
+		t.Errorf("Unexpected days: %+v", s.Days)
+	}
+
+	var out bytes.Buffer
+	WriteReport(&out, "Last 7 days", s)
+	for _, want := range []string{"Turns: 3 (4 requests), average 2s", "Prompt cache: 40 of 160 prompt tokens cached (25% hit rate)", "Cost: ~$1.00", "Top tools: shell 3, read_file 1", "Turns by model: gpt-4o 1, gpt-4o-mini 2", "Cost by model: gpt-4o ~$0.38, gpt-4o-mini ~$0.62", "2025-03-11"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected the report to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2025, 3, 10, 15, 30, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"7d", now.AddDate(0, 0, -7)},
+		{"2w", now.AddDate(0, 0, -14)},
+		{"12h", now.Add(-12 * time.Hour)},
+		{"today", time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)},
+		{"2025-03-01", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseSince(tt.value, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseSince(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+		}
+	}
+	for _, value := range []string{"", "d", "week", "-3h"} {
+		if _, err := ParseSince(value, now); err == nil {
+			t.Errorf("Expected ParseSince(%q) to fail", value)
+		}
+	}
+}